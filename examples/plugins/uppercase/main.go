@@ -0,0 +1,42 @@
+// Command uppercase is a minimal ChatRelayBot plugin. It registers an
+// "!upper" command and uppercases every answer before it is posted.
+//
+// Build it and add the binary to PLUGINS to try it:
+//
+//	go build -o uppercase ./examples/plugins/uppercase
+//	PLUGINS=./uppercase go run .
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/heykvr/chatrelaybot/relayplugin"
+	"github.com/heykvr/chatrelaybot/relayplugin/pluginpb"
+)
+
+type Plugin struct {
+	// The embedded server answers Retrieve, which this plugin does not
+	// provide, with Unimplemented.
+	pluginpb.UnimplementedPluginServer
+}
+
+func (Plugin) Describe(context.Context, *pluginpb.DescribeRequest) (*pluginpb.Manifest, error) {
+	return &pluginpb.Manifest{
+		Name:          "uppercase",
+		Commands:      []*pluginpb.Command{{Name: "upper", Usage: "<text>"}},
+		PostProcessor: true,
+	}, nil
+}
+
+func (Plugin) Command(_ context.Context, req *pluginpb.CommandRequest) (*pluginpb.CommandReply, error) {
+	return &pluginpb.CommandReply{Text: strings.ToUpper(req.Args)}, nil
+}
+
+func (Plugin) PostProcess(_ context.Context, req *pluginpb.PostProcessRequest) (*pluginpb.PostProcessReply, error) {
+	return &pluginpb.PostProcessReply{Text: strings.ToUpper(req.Text)}, nil
+}
+
+func main() {
+	relayplugin.Serve(Plugin{})
+}
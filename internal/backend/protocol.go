@@ -0,0 +1,232 @@
+// Package backend defines the JSON protocol between the relay and the chat
+// backend: the request sent for each question, the JSON or server-sent
+// event replies, and the capabilities and forms a backend can advertise.
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Path is where the backend (and the built-in mock) serves answers.
+	Path = "/v1/chat/stream"
+	// CapabilitiesPath is where the backend advertises parameter ranges.
+	CapabilitiesPath = "/v1/capabilities"
+
+	FormFieldChoice = "choice"
+	FormFieldText   = "text"
+
+	// MaxFormFields is the most fields a Form may have.
+	MaxFormFields = 10
+)
+
+// ChatRequest is the body of every request to the backend.
+type ChatRequest struct {
+	UserID    string `json:"user_id"`
+	Query     string `json:"query"`
+	ChannelID string `json:"channel_id"`
+
+	DisableInternalRetrieval bool            `json:"disable_internal_retrieval,omitempty"`
+	PreviousAnswer           string          `json:"previous_answer,omitempty"`
+	Instruction              string          `json:"instruction,omitempty"`
+	Context                  []string        `json:"context,omitempty"`
+	Model                    string          `json:"model,omitempty"`
+	Channel                  *ChannelContext `json:"channel_context,omitempty"`
+	FormResponse             *FormResponse   `json:"form_response,omitempty"`
+	// NoStore asks the backend not to retain the request (DM privacy mode).
+	NoStore bool `json:"no_store,omitempty"`
+	// History is the conversation so far, oldest first; see internal/bot/memory.go.
+	History []HistoryTurn `json:"history,omitempty"`
+	// Persona is the versioned system prompt; see internal/bot/persona.go.
+	Persona *PersonaPrompt `json:"persona,omitempty"`
+	// CodeBlocks are the fenced code blocks in Query; see
+	// internal/bot/codeblocks.go.
+	CodeBlocks []CodeBlock `json:"code_blocks,omitempty"`
+	GenerationParams
+}
+
+// ChatResponse is the JSON reply, or one server-sent event of a streamed
+// reply.
+type ChatResponse struct {
+	ID     int    `json:"id,omitempty"`
+	Event  string `json:"event,omitempty"`
+	Text   string `json:"text_chunk,omitempty"`
+	Status string `json:"status,omitempty"`
+	Full   string `json:"full_response,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Blocks is Slack Block Kit JSON sent with a "blocks" event.
+	Blocks json.RawMessage `json:"blocks,omitempty"`
+	// Form asks the user for structured input with a "form" event.
+	Form *Form `json:"form,omitempty"`
+	// Image is sent with an "image" or "chart" event.
+	Image *Image `json:"image,omitempty"`
+	// NoAnswer flags, on any event or the full response, that the backend
+	// had no answer; see internal/bot/knowledgegap.go.
+	NoAnswer bool `json:"no_answer,omitempty"`
+}
+
+// ChannelContext is sent to the backend with every question so answers
+// can take the channel's domain into account (#payments-oncall, say)
+// without the asker restating it.
+type ChannelContext struct {
+	Name    string `json:"name,omitempty"`
+	Topic   string `json:"topic,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// HistoryTurn is one earlier question and its answer.
+type HistoryTurn struct {
+	Query  string `json:"query"`
+	Answer string `json:"answer"`
+}
+
+// CodeBlock is a fenced code block in a question. Start and End are the
+// byte offsets of its opening fence and just past its closing one in the
+// query, and Code is its text without fences or Slack escapes.
+type CodeBlock struct {
+	Code  string `json:"code"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	// Language is the fence's tag when Tagged, and otherwise a guess;
+	// empty when the language could not be told.
+	Language string `json:"language,omitempty"`
+	Tagged   bool   `json:"tagged,omitempty"`
+}
+
+// PersonaPrompt is what the backend receives for the answering persona.
+type PersonaPrompt struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	Prompt  string `json:"prompt"`
+}
+
+// Label names the persona version, e.g. "support@v3".
+func (p PersonaPrompt) Label() string {
+	return fmt.Sprintf("%s@v%d", p.Name, p.Version)
+}
+
+// GenerationParams tune sampling; unset fields use the backend defaults.
+type GenerationParams struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+}
+
+func (g GenerationParams) String() string {
+	var parts []string
+	if g.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("temperature=%g", *g.Temperature))
+	}
+	if g.MaxTokens != nil {
+		parts = append(parts, fmt.Sprintf("max_tokens=%d", *g.MaxTokens))
+	}
+	if g.TopP != nil {
+		parts = append(parts, fmt.Sprintf("top_p=%g", *g.TopP))
+	}
+	if len(parts) == 0 {
+		return "backend defaults"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Validate checks g against the ranges the backend supports.
+func (g GenerationParams) Validate(ranges map[string]ParamRange) error {
+	check := func(name string, v float64) error {
+		r, ok := ranges[name]
+		if ok && (v < r.Min || v > r.Max) {
+			return fmt.Errorf("%s=%g is outside the supported range [%g, %g]", name, v, r.Min, r.Max)
+		}
+		return nil
+	}
+	if g.Temperature != nil {
+		if err := check("temperature", *g.Temperature); err != nil {
+			return err
+		}
+	}
+	if g.MaxTokens != nil {
+		if err := check("max_tokens", float64(*g.MaxTokens)); err != nil {
+			return err
+		}
+	}
+	if g.TopP != nil {
+		if err := check("top_p", *g.TopP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParamRange bounds one generation parameter.
+type ParamRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// Capabilities is served by the backend at /v1/capabilities.
+type Capabilities struct {
+	Parameters map[string]ParamRange `json:"parameters"`
+}
+
+// Form is the structured input a backend asks for.
+type Form struct {
+	ID     string      `json:"id"`
+	Title  string      `json:"title,omitempty"`
+	Prompt string      `json:"prompt"`
+	Fields []FormField `json:"fields"`
+	// State is opaque to the relay and returned with the response.
+	State string `json:"state,omitempty"`
+}
+
+// FormField is one input of a Form.
+type FormField struct {
+	Name      string   `json:"name"`
+	Label     string   `json:"label"`
+	Type      string   `json:"type"`
+	Options   []string `json:"options,omitempty"`
+	Multiline bool     `json:"multiline,omitempty"`
+	Optional  bool     `json:"optional,omitempty"`
+}
+
+// FormResponse is sent back to the backend with the user's answers.
+type FormResponse struct {
+	ID     string            `json:"id"`
+	State  string            `json:"state,omitempty"`
+	Values map[string]string `json:"values"`
+}
+
+// Validate checks that the relay can show f.
+func (f *Form) Validate() error {
+	if f.ID == "" || strings.TrimSpace(f.Prompt) == "" {
+		return errors.New("a form needs an id and a prompt")
+	}
+	if len(f.Fields) == 0 || len(f.Fields) > MaxFormFields {
+		return fmt.Errorf("a form needs 1 to %d fields, got %d", MaxFormFields, len(f.Fields))
+	}
+	seen := map[string]bool{}
+	for _, field := range f.Fields {
+		switch {
+		case field.Name == "" || seen[field.Name]:
+			return fmt.Errorf("field names must be unique and non-empty (%q)", field.Name)
+		case field.Type == FormFieldChoice && len(field.Options) == 0:
+			return fmt.Errorf("choice field %q has no options", field.Name)
+		case field.Type != FormFieldChoice && field.Type != FormFieldText:
+			return fmt.Errorf("field %q has unknown type %q", field.Name, field.Type)
+		}
+		seen[field.Name] = true
+	}
+	return nil
+}
+
+// Image is sent with an "image" event (Data) or a "chart" event
+// (Spec).
+type Image struct {
+	Data     string          `json:"data,omitempty"`
+	Spec     json.RawMessage `json:"spec,omitempty"`
+	Filename string          `json:"filename,omitempty"`
+	Title    string          `json:"title,omitempty"`
+	AltText  string          `json:"alt_text,omitempty"`
+}
@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Event Intake
+//
+// Events API envelopes are not acknowledged on arrival. eventIntake
+// validates each one, drops duplicates of an event it has already accepted
+// (see Event Deduplication), and acks only once the event has been
+// admitted. Events that queue work for the pool are admitted only while
+// the queue has room (see Queue Overflow); when it is full, ACK_OVERFLOW
+// decides what happens. "drop" (the default) acks and discards the event,
+// so Slack does not redeliver it. "delay" leaves it unacknowledged so Slack
+// redelivers it a few seconds later, when the queue may have drained; the
+// last redelivery Slack makes is dropped instead. Outcomes are counted
+// under "event_intake" on /debug/vars. Interactive payloads are still acked
+// first, since Slack requires that within three seconds.
+const (
+	ackOverflowDrop  = "drop"
+	ackOverflowDelay = "delay"
+
+	eventDedupTTL = 10 * time.Minute
+	// slackMaxRetries is how many times Slack redelivers an unacknowledged
+	// event before giving up.
+	slackMaxRetries = 3
+)
+
+var metricEventIntake = expvar.NewMap("event_intake")
+
+type intakeDecision int
+
+const (
+	// intakeAccept acks the event and dispatches it.
+	intakeAccept intakeDecision = iota
+	// intakeIgnore acks the event without dispatching it.
+	intakeIgnore
+	// intakeDelay leaves the event unacknowledged for Slack to redeliver.
+	intakeDelay
+)
+
+type eventIntake struct {
+	mu       sync.Mutex
+	overflow string
+	dedup    EventDedupStore
+	ttl      time.Duration
+}
+
+var intake = newEventIntake(ackOverflowDrop)
+
+func newEventIntake(overflow string) *eventIntake {
+	return &eventIntake{overflow: overflow, dedup: newLRUDedupStore(defaultEventDedupSize), ttl: eventDedupTTL}
+}
+
+func parseAckOverflow(s string) (string, error) {
+	switch s {
+	case "", ackOverflowDrop:
+		return ackOverflowDrop, nil
+	case ackOverflowDelay:
+		return ackOverflowDelay, nil
+	}
+	return "", fmt.Errorf("invalid ACK_OVERFLOW %q (use drop or delay)", s)
+}
+
+// queuesWork reports whether handling the inner event submits a task to
+// the worker pool and so needs queue admission.
+func queuesWork(inner any) bool {
+	switch inner.(type) {
+	case *slackevents.AppMentionEvent, *slackevents.MessageEvent, *slackevents.ReactionAddedEvent:
+		return true
+	}
+	return false
+}
+
+// decide applies the ack policy to an Events API envelope. stats is the
+// worker pool's state at arrival.
+func (in *eventIntake) decide(ctx context.Context, req socketmode.Request, ev slackevents.EventsAPIEvent, stats workerpool.Stats) intakeDecision {
+	if ev.Type != slackevents.CallbackEvent || ev.InnerEvent.Data == nil {
+		metricEventIntake.Add("ignored", 1)
+		return intakeIgnore
+	}
+	var eventID string
+	if cb, ok := ev.Data.(*slackevents.EventsAPICallbackEvent); ok {
+		eventID = cb.EventID
+	}
+	keys := eventDedupKeys(ev)
+	if !in.claim(ctx, keys) {
+		metricEventIntake.Add("duplicate", 1)
+		return intakeIgnore
+	}
+	if queuesWork(ev.InnerEvent.Data) && !queueHasRoom(stats) {
+		if in.overflow == ackOverflowDelay && req.RetryAttempt < slackMaxRetries {
+			in.forget(ctx, keys)
+			metricEventIntake.Add("delayed", 1)
+			slog.WarnContext(ctx, "Queue full, leaving event unacknowledged for redelivery", "event", eventID, "depth", stats.QueueDepth, "capacity", stats.QueueCapacity, "attempt", req.RetryAttempt)
+			return intakeDelay
+		}
+		metricEventIntake.Add("dropped_overflow", 1)
+		slog.WarnContext(ctx, "Queue full, dropping event", "event", eventID, "depth", stats.QueueDepth, "capacity", stats.QueueCapacity)
+		return intakeIgnore
+	}
+	metricEventIntake.Add("accepted", 1)
+	return intakeAccept
+}
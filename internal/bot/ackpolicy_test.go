@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+func mentionEnvelope(eventID string) slackevents.EventsAPIEvent {
+	return slackevents.EventsAPIEvent{
+		Type:       slackevents.CallbackEvent,
+		Data:       &slackevents.EventsAPICallbackEvent{EventID: eventID},
+		InnerEvent: slackevents.EventsAPIInnerEvent{Data: &slackevents.AppMentionEvent{User: "U1", Channel: "C1"}},
+	}
+}
+
+func TestEventIntake_DedupsAndValidates(t *testing.T) {
+	in := newEventIntake(ackOverflowDrop)
+	ctx := context.Background()
+	room := workerpool.Stats{QueueDepth: 0, QueueCapacity: 4}
+
+	if d := in.decide(ctx, socketmode.Request{}, mentionEnvelope("Ev1"), room); d != intakeAccept {
+		t.Fatalf("first delivery = %v, want accept", d)
+	}
+	if d := in.decide(ctx, socketmode.Request{RetryAttempt: 1}, mentionEnvelope("Ev1"), room); d != intakeIgnore {
+		t.Errorf("redelivery of an accepted event = %v, want ignore", d)
+	}
+	if d := in.decide(ctx, socketmode.Request{}, slackevents.EventsAPIEvent{Type: slackevents.AppRateLimited}, room); d != intakeIgnore {
+		t.Errorf("non-callback envelope = %v, want ignore", d)
+	}
+
+	in.dedup.(*lruDedupStore).now = func() time.Time { return time.Now().Add(eventDedupTTL + time.Minute) }
+	if d := in.decide(ctx, socketmode.Request{}, mentionEnvelope("Ev1"), room); d != intakeAccept {
+		t.Errorf("event ID should be forgotten after the TTL, got %v", d)
+	}
+}
+
+func TestEventIntake_Overflow(t *testing.T) {
+	ctx := context.Background()
+	full := workerpool.Stats{QueueDepth: 4, QueueCapacity: 4}
+
+	drop := newEventIntake(ackOverflowDrop)
+	if d := drop.decide(ctx, socketmode.Request{}, mentionEnvelope("Ev1"), full); d != intakeIgnore {
+		t.Errorf("drop policy on a full queue = %v, want ignore", d)
+	}
+	home := mentionEnvelope("Ev2")
+	home.InnerEvent.Data = &slackevents.AppHomeOpenedEvent{User: "U1", Tab: "home"}
+	if d := drop.decide(ctx, socketmode.Request{}, home, full); d != intakeAccept {
+		t.Errorf("events that do not queue work should bypass admission, got %v", d)
+	}
+
+	delay := newEventIntake(ackOverflowDelay)
+	if d := delay.decide(ctx, socketmode.Request{}, mentionEnvelope("Ev3"), full); d != intakeDelay {
+		t.Fatalf("delay policy on a full queue = %v, want delay", d)
+	}
+	if d := delay.decide(ctx, socketmode.Request{RetryAttempt: 1}, mentionEnvelope("Ev3"), workerpool.Stats{QueueCapacity: 4}); d != intakeAccept {
+		t.Errorf("redelivery of a delayed event once the queue drains = %v, want accept", d)
+	}
+	if d := delay.decide(ctx, socketmode.Request{RetryAttempt: slackMaxRetries}, mentionEnvelope("Ev4"), full); d != intakeIgnore {
+		t.Errorf("Slack's last redelivery should be dropped, got %v", d)
+	}
+
+	if _, err := parseAckOverflow("queue"); err == nil {
+		t.Error("unknown ACK_OVERFLOW values should be rejected")
+	}
+}
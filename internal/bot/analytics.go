@@ -0,0 +1,304 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Analytics API
+//
+// BI tools read the conversation store through read-only JSON endpoints
+// instead of reaching into the relay:
+//
+//	GET /api/v1/analytics/queries   one item per answered question
+//	GET /api/v1/analytics/feedback  one item per reaction or evaluation label
+//	GET /api/v1/analytics/costs     one item per answered question, with its cost
+//
+// Requests carry "Authorization: Bearer <ANALYTICS_API_TOKEN>" (the admin
+// token works too); with neither token set the API is off. "from" and "to"
+// (RFC 3339 times or dates, "to" exclusive) limit the time range, "channel"
+// limits it to one channel, and "limit" (default analyticsDefaultLimit, at
+// most analyticsMaxLimit) sizes each page. Items come oldest first; when
+// more remain the response has "next_cursor", passed back as "cursor" with
+// the same filters for the next page. Private DMs are never stored, so they never appear, and
+// redacted answers appear without their question. Requests and rejected
+// requests are counted under "analytics_api" on /debug/vars.
+const (
+	analyticsDefaultLimit = 100
+	analyticsMaxLimit     = 1000
+)
+
+var metricAnalytics = expvar.NewMap("analytics_api")
+
+var analyticsAPIToken string
+
+func configureAnalytics() {
+	analyticsAPIToken = os.Getenv("ANALYTICS_API_TOKEN")
+}
+
+// requireAnalyticsToken admits GET requests carrying the analytics or the
+// admin token.
+func requireAnalyticsToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if analyticsAPIToken == "" && config.AdminAPIToken == "" {
+			http.Error(w, "analytics API disabled", http.StatusForbidden)
+			return
+		}
+		token := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if (analyticsAPIToken == "" || subtle.ConstantTimeCompare(token, []byte(analyticsAPIToken)) != 1) &&
+			(config.AdminAPIToken == "" || subtle.ConstantTimeCompare(token, []byte(config.AdminAPIToken)) != 1) {
+			metricAnalytics.Add("unauthorized", 1)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// analyticsQuery is a parsed time range, filter and page.
+type analyticsQuery struct {
+	From, To time.Time
+	Channel  string
+	Limit    int
+	// After is the position of the last item of the previous page.
+	After analyticsPosition
+}
+
+type analyticsPosition struct {
+	At time.Time
+	ID string
+}
+
+func (p analyticsPosition) before(o analyticsPosition) bool {
+	if !p.At.Equal(o.At) {
+		return p.At.Before(o.At)
+	}
+	return p.ID < o.ID
+}
+
+func (p analyticsPosition) cursor() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(p.At.UnixNano(), 10) + "/" + p.ID))
+}
+
+func parseAnalyticsCursor(s string) (analyticsPosition, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return analyticsPosition{}, fmt.Errorf("invalid cursor")
+	}
+	nanos, id, ok := strings.Cut(string(raw), "/")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if !ok || err != nil {
+		return analyticsPosition{}, fmt.Errorf("invalid cursor")
+	}
+	return analyticsPosition{At: time.Unix(0, n), ID: id}, nil
+}
+
+func parseAnalyticsTime(name, v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid %s %q: use an RFC 3339 time or a date such as 2026-03-01", name, v)
+}
+
+func parseAnalyticsQuery(r *http.Request) (analyticsQuery, error) {
+	params := r.URL.Query()
+	q := analyticsQuery{Channel: params.Get("channel"), Limit: analyticsDefaultLimit}
+	var err error
+	if v := params.Get("from"); v != "" {
+		if q.From, err = parseAnalyticsTime("from", v); err != nil {
+			return q, err
+		}
+	}
+	if v := params.Get("to"); v != "" {
+		if q.To, err = parseAnalyticsTime("to", v); err != nil {
+			return q, err
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > analyticsMaxLimit {
+			return q, fmt.Errorf("invalid limit %q: use 1 to %d", v, analyticsMaxLimit)
+		}
+	}
+	if v := params.Get("cursor"); v != "" {
+		if q.After, err = parseAnalyticsCursor(v); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// matches reports whether an item at pos in channel belongs on this page
+// or a later one.
+func (q analyticsQuery) matches(pos analyticsPosition, channel string) bool {
+	if (q.Channel != "" && channel != q.Channel) || pos.At.Before(q.From) || (!q.To.IsZero() && !pos.At.Before(q.To)) {
+		return false
+	}
+	return q.After.ID == "" || q.After.before(pos)
+}
+
+type analyticsItem struct {
+	pos  analyticsPosition
+	data any
+}
+
+type analyticsPage struct {
+	Items      []any  `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// page sorts items oldest first and cuts the first q.Limit.
+func (q analyticsQuery) page(items []analyticsItem) analyticsPage {
+	sort.Slice(items, func(i, j int) bool { return items[i].pos.before(items[j].pos) })
+	p := analyticsPage{Items: []any{}}
+	for i, it := range items {
+		if i == q.Limit {
+			p.NextCursor = items[i-1].pos.cursor()
+			break
+		}
+		p.Items = append(p.Items, it.data)
+	}
+	return p
+}
+
+type analyticsQueryItem struct {
+	ID          string    `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Channel     string    `json:"channel"`
+	User        string    `json:"user"`
+	Source      string    `json:"source,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	Persona     string    `json:"persona,omitempty"`
+	Query       string    `json:"query,omitempty"`
+	AnswerChars int       `json:"answer_chars"`
+	Cost        float64   `json:"cost"`
+	Positive    int       `json:"positive_feedback"`
+	Negative    int       `json:"negative_feedback"`
+	Unanswered  bool      `json:"unanswered"`
+	Escalated   bool      `json:"escalated"`
+	Withdrawn   bool      `json:"withdrawn"`
+	Redacted    bool      `json:"redacted"`
+}
+
+type analyticsFeedbackItem struct {
+	ConversationID string    `json:"conversation_id"`
+	At             time.Time `json:"at"`
+	Channel        string    `json:"channel"`
+	By             string    `json:"by"`
+	Rating         string    `json:"rating"`
+	Source         string    `json:"source"`
+	Model          string    `json:"model,omitempty"`
+}
+
+type analyticsCostItem struct {
+	ConversationID string    `json:"conversation_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	Channel        string    `json:"channel"`
+	Model          string    `json:"model,omitempty"`
+	Cost           float64   `json:"cost"`
+}
+
+func analyticsQueries(q analyticsQuery) analyticsPage {
+	var items []analyticsItem
+	for _, rec := range conversations.All() {
+		pos := analyticsPosition{At: rec.CreatedAt, ID: rec.ID}
+		if !q.matches(pos, rec.Channel) {
+			continue
+		}
+		it := analyticsQueryItem{ID: rec.ID, CreatedAt: rec.CreatedAt.UTC(), Channel: rec.Channel, User: rec.User, Source: rec.Source,
+			Model: rec.Model, Persona: rec.Persona, AnswerChars: len(rec.AnswerText()), Cost: rec.Cost,
+			Unanswered: rec.Unanswered, Escalated: rec.Escalated, Withdrawn: rec.Withdrawn, Redacted: rec.Redacted}
+		if !rec.Redacted {
+			it.Query = rec.Query
+		}
+		for _, f := range rec.Feedback {
+			switch f.Rating {
+			case "positive", EvalGood:
+				it.Positive++
+			case "negative", EvalBad:
+				it.Negative++
+			}
+		}
+		items = append(items, analyticsItem{pos: pos, data: it})
+	}
+	return q.page(items)
+}
+
+func analyticsFeedback(q analyticsQuery) analyticsPage {
+	var items []analyticsItem
+	for _, rec := range conversations.All() {
+		for i, f := range rec.Feedback {
+			pos := analyticsPosition{At: f.At, ID: fmt.Sprintf("%s.%d", rec.ID, i)}
+			if !q.matches(pos, rec.Channel) {
+				continue
+			}
+			items = append(items, analyticsItem{pos: pos, data: analyticsFeedbackItem{ConversationID: rec.ID, At: f.At.UTC(),
+				Channel: rec.Channel, By: f.By, Rating: f.Rating, Source: f.Source, Model: rec.Model}})
+		}
+	}
+	return q.page(items)
+}
+
+// analyticsCosts also returns the total over the whole range, not just
+// the page.
+func analyticsCosts(q analyticsQuery) (analyticsPage, float64) {
+	var items []analyticsItem
+	total := 0.0
+	all := q
+	all.After = analyticsPosition{}
+	for _, rec := range conversations.All() {
+		pos := analyticsPosition{At: rec.CreatedAt, ID: rec.ID}
+		if all.matches(pos, rec.Channel) {
+			total += rec.Cost
+		}
+		if !q.matches(pos, rec.Channel) {
+			continue
+		}
+		items = append(items, analyticsItem{pos: pos, data: analyticsCostItem{ConversationID: rec.ID, CreatedAt: rec.CreatedAt.UTC(),
+			Channel: rec.Channel, Model: rec.Model, Cost: rec.Cost}})
+	}
+	return q.page(items), total
+}
+
+// analyticsHandler serves one endpoint; run returns its response body.
+func analyticsHandler(name string, run func(analyticsQuery) any) http.HandlerFunc {
+	return requireAnalyticsToken(func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseAnalyticsQuery(r)
+		if err != nil {
+			metricAnalytics.Add("bad_requests", 1)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		metricAnalytics.Add(name, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(run(q))
+	})
+}
+
+func registerAnalyticsAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/analytics/queries", analyticsHandler("queries", func(q analyticsQuery) any { return analyticsQueries(q) }))
+	mux.HandleFunc("/api/v1/analytics/feedback", analyticsHandler("feedback", func(q analyticsQuery) any { return analyticsFeedback(q) }))
+	mux.HandleFunc("/api/v1/analytics/costs", analyticsHandler("costs", func(q analyticsQuery) any {
+		p, total := analyticsCosts(q)
+		return struct {
+			analyticsPage
+			TotalCost float64 `json:"total_cost"`
+		}{p, total}
+	}))
+}
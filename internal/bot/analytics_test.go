@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func getAnalytics(t *testing.T, mux *http.ServeMux, path, token string, out any) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK && out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAnalyticsAPI_Authentication(t *testing.T) {
+	defer func(store *ConversationStore, token, admin string) {
+		conversations, analyticsAPIToken, config.AdminAPIToken = store, token, admin
+	}(conversations, analyticsAPIToken, config.AdminAPIToken)
+	conversations = NewConversationStore()
+	analyticsAPIToken, config.AdminAPIToken = "bi-token", "admin-token"
+	mux := http.NewServeMux()
+	registerAnalyticsAPI(mux)
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "bi-token": http.StatusOK, "admin-token": http.StatusOK} {
+		if got := getAnalytics(t, mux, "/api/v1/analytics/queries", token, nil); got != want {
+			t.Errorf("token %q: status %d, want %d", token, got, want)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/analytics/queries", nil)
+	req.Header.Set("Authorization", "Bearer bi-token")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d", rec.Code)
+	}
+	analyticsAPIToken, config.AdminAPIToken = "", ""
+	if got := getAnalytics(t, mux, "/api/v1/analytics/costs", "", nil); got != http.StatusForbidden {
+		t.Errorf("without tokens: status %d", got)
+	}
+}
+
+func TestAnalyticsAPI_QueriesFilterAndPaginate(t *testing.T) {
+	defer func(store *ConversationStore, token, admin string) {
+		conversations, analyticsAPIToken, config.AdminAPIToken = store, token, admin
+	}(conversations, analyticsAPIToken, config.AdminAPIToken)
+	conversations = NewConversationStore()
+	analyticsAPIToken, config.AdminAPIToken = "bi-token", "admin-token"
+	mux := http.NewServeMux()
+	registerAnalyticsAPI(mux)
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		channel := "CONE"
+		if id == "d" {
+			channel = "CTWO"
+		}
+		conversations.Save(&ConversationRecord{ID: id, Channel: channel, User: "U1", Query: "question " + id, Answer: []string{"answer"},
+			Model: "small", Cost: 0.25, CreatedAt: start.Add(time.Duration(i) * time.Hour)})
+	}
+	conversations.Redact("b")
+	conversations.AddFeedback("a", AnswerFeedback{By: "U2", Rating: "positive", Source: "reaction", At: start.Add(10 * time.Minute)})
+	conversations.AddFeedback("a", AnswerFeedback{By: "UADMIN", Rating: EvalBad, Source: "eval", At: start.Add(20 * time.Minute)})
+
+	var ids []string
+	filters := "/api/v1/analytics/queries?channel=CONE&from=2026-03-01&to=" + url.QueryEscape(start.Add(4*time.Hour).Format(time.RFC3339)) + "&limit=2"
+	path := filters
+	for pages := 0; path != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination does not end")
+		}
+		var page struct {
+			Items      []analyticsQueryItem `json:"items"`
+			NextCursor string               `json:"next_cursor"`
+		}
+		if code := getAnalytics(t, mux, path, "bi-token", &page); code != http.StatusOK {
+			t.Fatalf("%s: status %d", path, code)
+		}
+		for _, it := range page.Items {
+			ids = append(ids, it.ID)
+			if it.ID == "a" && (it.Positive != 1 || it.Negative != 1 || it.Query != "question a") {
+				t.Errorf("item a = %+v", it)
+			}
+			if it.ID == "b" && (!it.Redacted || it.Query != "") {
+				t.Errorf("redacted item b = %+v", it)
+			}
+		}
+		path = ""
+		if page.NextCursor != "" {
+			path = filters + "&cursor=" + page.NextCursor
+		}
+	}
+	// d is in another channel and e is past "to".
+	if want := []string{"a", "b", "c"}; len(ids) != len(want) || ids[0] != "a" || ids[1] != "b" || ids[2] != "c" {
+		t.Errorf("ids = %v, want %v", ids, want)
+	}
+
+	var feedback struct {
+		Items []analyticsFeedbackItem `json:"items"`
+	}
+	getAnalytics(t, mux, "/api/v1/analytics/feedback", "bi-token", &feedback)
+	if len(feedback.Items) != 2 || feedback.Items[0].Rating != "positive" || feedback.Items[1].Source != "eval" {
+		t.Errorf("feedback = %+v", feedback.Items)
+	}
+
+	var costs struct {
+		Items     []analyticsCostItem `json:"items"`
+		Next      string              `json:"next_cursor"`
+		TotalCost float64             `json:"total_cost"`
+	}
+	getAnalytics(t, mux, "/api/v1/analytics/costs?limit=1", "bi-token", &costs)
+	if len(costs.Items) != 1 || costs.Next == "" || costs.TotalCost != 1.25 {
+		t.Errorf("costs = %+v", costs)
+	}
+
+	for _, bad := range []string{"from=yesterday", "limit=0", "limit=5000", "cursor=bm90LWEtY3Vyc29y"} {
+		if code := getAnalytics(t, mux, "/api/v1/analytics/queries?"+bad, "bi-token", nil); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", bad, code)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Block Kit Answers
+//
+// Channels with "format": "blocks" get answers laid out in Block Kit
+// instead of one long mrkdwn text: "#" headings become header blocks, "---"
+// rules become dividers, the text between them becomes sections of at most
+// maxSectionText characters, and a trailing "Sources:" list moves into a
+// context block. A context footer then gives the request's reference code,
+// which admins look up with "!trace". The rendered text, after the usual
+// answer rendering and the outgoing filters, is also the notification
+// fallback. "format": "text", the default, posts plain text. So does any
+// answer that can't be laid out within Slack's block limits. Blocks
+// sent by the backend, live-edited answers and answers published from
+// review are posted as before. Rendered answers and plain-text fallbacks are
+// counted under "answer_blocks" on /debug/vars.
+const (
+	answerFormatText   = "text"
+	answerFormatBlocks = "blocks"
+
+	maxHeaderText = 150
+)
+
+var (
+	metricAnswerBlocks = expvar.NewMap("answer_blocks")
+
+	headingPattern = regexp.MustCompile(`^#{1,6}\s+(.+?)\s*#*$`)
+	rulePattern    = regexp.MustCompile(`^\s*(-{3,}|\*{3,}|_{3,})\s*$`)
+	sourcesPattern = regexp.MustCompile(`(?i)^\s*\**sources?\**:\**\s*(.*)$`)
+)
+
+func validateAnswerFormat(format string) error {
+	switch format {
+	case "", answerFormatText, answerFormatBlocks:
+		return nil
+	}
+	return fmt.Errorf("format must be %q or %q, got %q", answerFormatText, answerFormatBlocks, format)
+}
+
+// splitSources separates a trailing "Sources:" list from text.
+func splitSources(text string) (string, []string) {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		m := sourcesPattern.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		var sources []string
+		if m[1] != "" {
+			sources = append(sources, m[1])
+		}
+		for _, line := range lines[i+1:] {
+			line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*•"))
+			if line == "" {
+				continue
+			}
+			sources = append(sources, line)
+		}
+		if len(sources) == 0 {
+			return text, nil
+		}
+		return strings.Join(lines[:i], "\n"), sources
+	}
+	return text, nil
+}
+
+// answerBlocks lays out rendered answer text in Block Kit, with footer as
+// the last context block when it is set.
+func answerBlocks(text, footer string) ([]slack.Block, error) {
+	body, sources := splitSources(text)
+	var blocks []slack.Block
+	var paragraph []string
+	flush := func() {
+		section := strings.TrimSpace(strings.Join(paragraph, "\n"))
+		paragraph = nil
+		if section == "" {
+			return
+		}
+		for _, part := range segmentAnswer(section, maxSectionText) {
+			blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, part, false, false), nil, nil))
+		}
+	}
+	inCode := false
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		}
+		if !inCode {
+			if m := headingPattern.FindStringSubmatch(line); m != nil {
+				flush()
+				blocks = append(blocks, slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, truncate(m[1], maxHeaderText), false, false)))
+				continue
+			}
+			if rulePattern.MatchString(line) {
+				flush()
+				blocks = append(blocks, slack.NewDividerBlock())
+				continue
+			}
+		}
+		paragraph = append(paragraph, line)
+	}
+	flush()
+	if len(blocks) == 0 {
+		return nil, errors.New("nothing to lay out")
+	}
+	if len(sources) > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, truncate(":books: "+strings.Join(sources, " · "), maxSectionText), false, false)))
+	}
+	if footer != "" {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, footer, false, false)))
+	}
+	if len(blocks) > maxMessageBlocks {
+		return nil, fmt.Errorf("%d blocks, limit %d", len(blocks), maxMessageBlocks)
+	}
+	return blocks, nil
+}
+
+// answerFooter is the context line under a Block Kit answer.
+func answerFooter(ctx context.Context) string {
+	if code := errorReference(ctx); code != "" {
+		return fmt.Sprintf("ref `%s`", code)
+	}
+	return ""
+}
+
+// sendBlockAnswer posts one answer chunk laid out by answerBlocks, falling
+// back to plain text when it can't be.
+func sendBlockAnswer(ctx context.Context, api SlackClient, channel, user, text string, options ...slack.MsgOption) (string, error) {
+	text, files := renderAnswer(ctx, api, channel, user, text)
+	// Filtered whole before it is split into blocks, so a match spanning
+	// two blocks is still caught.
+	text = filterText(ctx, channel, user, text)
+	msg := outgoingMessage{Channel: channel, User: user, Text: text}
+	blocks, err := answerBlocks(text, answerFooter(ctx))
+	if err != nil {
+		metricAnswerBlocks.Add("plain_fallback", 1)
+		slog.WarnContext(ctx, "Posting answer as plain text", "err", err)
+	} else {
+		metricAnswerBlocks.Add("rendered", 1)
+		options = append([]slack.MsgOption{slack.MsgOptionBlocks(blocks...)}, options...)
+	}
+	ts, err := sendAnswerMessage(ctx, api, msg, options...)
+	if err != nil {
+		return ts, err
+	}
+	uploadTables(ctx, api, channel, user, ts, files)
+	return ts, nil
+}
@@ -0,0 +1,84 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestAnswerBlocks_Layout(t *testing.T) {
+	text := "# Rotating keys\nOpen *Settings*.\n\n---\n```\n# not a heading\n---\n```\nThen save.\nSources:\n- https://docs.example.com/keys\n- Runbook 12"
+	blocks, err := answerBlocks(text, "ref `ABCD-1234`")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, b := range blocks {
+		types = append(types, string(b.BlockType()))
+	}
+	if got := strings.Join(types, ","); got != "header,section,divider,section,section,context,context" {
+		t.Fatalf("block types %s", got)
+	}
+	if h := blocks[0].(*slack.HeaderBlock); h.Text.Text != "Rotating keys" {
+		t.Errorf("header %q", h.Text.Text)
+	}
+	if s := blocks[3].(*slack.SectionBlock); !strings.Contains(s.Text.Text, "# not a heading\n---") {
+		t.Errorf("code section %q", s.Text.Text)
+	}
+	if s := blocks[4].(*slack.SectionBlock); s.Text.Text != "Then save." {
+		t.Errorf("last section %q", s.Text.Text)
+	}
+	sources := blocks[5].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject).Text
+	if sources != ":books: https://docs.example.com/keys · Runbook 12" {
+		t.Errorf("sources %q", sources)
+	}
+
+	if _, err := answerBlocks(strings.Repeat("# h\n", maxMessageBlocks+1), ""); err == nil {
+		t.Error("expected an error above the block limit")
+	}
+}
+
+func TestProcessTask_BlockFormatWithPlainFallback(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CRICH": {Format: answerFormatBlocks}}})
+	defer setChannelSettings(channelSettings{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "## Answer\nUse the CLI."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	for _, channel := range []string{"CRICH", "CPLAIN"} {
+		api := &fakeSlackClient{}
+		ctx := withRequestID(context.Background())
+		processTask(ctx, api, slackevents.AppMentionEvent{User: "U1", Channel: channel}, "how?")
+		if len(api.posts) != 1 {
+			t.Fatalf("%s: expected one post, got %d", channel, len(api.posts))
+		}
+		post := api.posts[0]
+		if post.Text() != "## Answer\nUse the CLI." {
+			t.Errorf("%s: fallback text %q", channel, post.Text())
+		}
+		raw := post.Values.Get("blocks")
+		if channel == "CPLAIN" {
+			if raw != "" {
+				t.Errorf("plain channel posted blocks %s", raw)
+			}
+			continue
+		}
+		if !strings.Contains(raw, `"type":"header"`) || !strings.Contains(raw, "ref `"+errorReference(ctx)+"`") {
+			t.Errorf("blocks %s", raw)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Answer Scoring
+//
+// Every finished answer is scored for sentiment (-1 to 1) and safety, so a
+// prompt or model regression shows up as a shift in the distribution before
+// users report it. ANSWER_SCORING picks the classifier: "local" (default)
+// uses the word lists and patterns below, "backend" asks the backend for a
+// JSON score as low-priority work and falls back to the local rules when
+// that fails, and "off" disables scoring. Scores are counted under
+// "answer_scores" on /debug/vars in sentiment buckets, with unsafe answers
+// per category. When the share of negative answers (sentiment below
+// negativeSentiment) or unsafe answers over the last scoreWindow reaches
+// SCORE_ALERT_NEGATIVE_RATE (default 0.3) or SCORE_ALERT_UNSAFE_RATE
+// (default 0.05), with at least scoreMinAnswers answers, ADMIN_CHANNEL is
+// alerted once, and again when the rate recovers. Self-test answers are
+// not scored.
+const (
+	scoringLocal   = "local"
+	scoringBackend = "backend"
+	scoringOff     = "off"
+
+	negativeSentiment        = -0.3
+	scoreWindow              = time.Hour
+	scoreMinAnswers          = 20
+	defaultNegativeRateAlert = 0.3
+	defaultUnsafeRateAlert   = 0.05
+
+	scoreInstruction = `Rate the following assistant answer. Reply with JSON only: {"sentiment": <number from -1 (hostile, dismissive or distressed) to 1 (warm and helpful)>, "unsafe": [<zero or more of "profanity", "self_harm", "violence", "secrets">]}`
+)
+
+var metricAnswerScores = expvar.NewMap("answer_scores")
+
+type answerScore struct {
+	Sentiment float64  `json:"sentiment"`
+	Unsafe    []string `json:"unsafe"`
+}
+
+var (
+	positiveWords = wordSet("glad happy great thanks thank welcome helpful good excellent sure recommend easy works resolved success successfully appreciate")
+	negativeWords = wordSet("sorry unfortunately cannot can't unable fail failed failure error wrong bad impossible refuse stupid useless hate annoying terrible awful broken")
+
+	unsafePatterns = map[string]*regexp.Regexp{
+		"profanity": regexp.MustCompile(`(?i)\b(fuck\w*|shit\w*|bitch\w*|asshole|bastard|cunt)\b`),
+		"self_harm": regexp.MustCompile(`(?i)\b(kill (yourself|myself)|suicide method|how to self[- ]harm|end your life)\b`),
+		"violence":  regexp.MustCompile(`(?i)\b(build a bomb|make a weapon|hurt (him|her|them) badly|poison (him|her|them|someone))\b`),
+		"secrets":   regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----|\bAKIA[0-9A-Z]{16}\b|\bxox[abpr]-[0-9A-Za-z-]{10,}`),
+	}
+	scoreWordPattern = regexp.MustCompile(`[a-z']+`)
+)
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// scoreLocally rates text with the word lists and unsafe patterns; code is
+// left out of the sentiment.
+func scoreLocally(text string) answerScore {
+	var score answerScore
+	for name, pattern := range unsafePatterns {
+		if pattern.MatchString(text) {
+			score.Unsafe = append(score.Unsafe, name)
+		}
+	}
+	pos, neg := 0, 0
+	outsideCode(strings.ToLower(text), func(s string) string {
+		for _, w := range scoreWordPattern.FindAllString(s, -1) {
+			switch {
+			case positiveWords[w]:
+				pos++
+			case negativeWords[w]:
+				neg++
+			}
+		}
+		return s
+	})
+	if pos+neg > 0 {
+		score.Sentiment = float64(pos-neg) / float64(pos+neg)
+	}
+	return score
+}
+
+// scoreWithBackend asks the backend to rate text.
+func scoreWithBackend(ctx context.Context, text string) (answerScore, error) {
+	reply, err := requestAnswer(withLowPriority(ctx), backend.ChatRequest{UserID: "scoring", Query: text, Instruction: scoreInstruction, DisableInternalRetrieval: true})
+	if err != nil {
+		return answerScore{}, err
+	}
+	reply = strings.TrimSpace(reply)
+	reply = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(reply, "```json"), "```"), "```")
+	var score answerScore
+	if err := json.Unmarshal([]byte(strings.TrimSpace(reply)), &score); err != nil {
+		return answerScore{}, fmt.Errorf("unreadable score %q: %w", truncate(reply, 80), err)
+	}
+	score.Sentiment = math.Max(-1, math.Min(1, score.Sentiment))
+	return score, nil
+}
+
+func sentimentBucket(s float64) string {
+	switch {
+	case s < -0.6:
+		return "sentiment_very_negative"
+	case s < negativeSentiment:
+		return "sentiment_negative"
+	case s <= 0.3:
+		return "sentiment_neutral"
+	case s <= 0.6:
+		return "sentiment_positive"
+	}
+	return "sentiment_very_positive"
+}
+
+type scoreOutcome struct {
+	at       time.Time
+	negative bool
+	unsafe   bool
+}
+
+type answerScorer struct {
+	mu           sync.Mutex
+	mode         string
+	negativeRate float64
+	unsafeRate   float64
+	outcomes     []scoreOutcome
+	alerting     map[string]bool
+	now          func() time.Time
+}
+
+var answerScores = &answerScorer{mode: scoringLocal, negativeRate: defaultNegativeRateAlert, unsafeRate: defaultUnsafeRateAlert, alerting: map[string]bool{}, now: time.Now}
+
+// configure applies the environment settings; zero rates keep the defaults.
+func (s *answerScorer) configure(mode string, negativeRate, unsafeRate float64) error {
+	switch mode {
+	case "":
+		mode = scoringLocal
+	case scoringLocal, scoringBackend, scoringOff:
+	default:
+		return fmt.Errorf("ANSWER_SCORING must be %q, %q or %q", scoringLocal, scoringBackend, scoringOff)
+	}
+	for _, r := range []float64{negativeRate, unsafeRate} {
+		if r < 0 || r > 1 {
+			return fmt.Errorf("score alert rates must be between 0 and 1")
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mode = mode
+	if negativeRate > 0 {
+		s.negativeRate = negativeRate
+	}
+	if unsafeRate > 0 {
+		s.unsafeRate = unsafeRate
+	}
+	return nil
+}
+
+func (s *answerScorer) enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mode != scoringOff
+}
+
+// score rates text with the configured classifier.
+func (s *answerScorer) score(ctx context.Context, text string) answerScore {
+	s.mu.Lock()
+	mode := s.mode
+	s.mu.Unlock()
+	if mode == scoringBackend {
+		score, err := scoreWithBackend(ctx, text)
+		if err == nil {
+			return score
+		}
+		metricAnswerScores.Add("backend_errors", 1)
+		slog.WarnContext(ctx, "Falling back to local answer scoring", "err", err)
+	}
+	return scoreLocally(text)
+}
+
+// record adds score to the metrics and window and returns the alerts to
+// send, if a rate crossed its threshold either way.
+func (s *answerScorer) record(score answerScore) []string {
+	metricAnswerScores.Add("scored", 1)
+	metricAnswerScores.Add(sentimentBucket(score.Sentiment), 1)
+	for _, category := range score.Unsafe {
+		metricAnswerScores.Add("unsafe_"+category, 1)
+	}
+	if len(score.Unsafe) > 0 {
+		metricAnswerScores.Add("unsafe", 1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.outcomes = append(s.outcomes, scoreOutcome{at: now, negative: score.Sentiment < negativeSentiment, unsafe: len(score.Unsafe) > 0})
+	cutoff := now.Add(-scoreWindow)
+	for len(s.outcomes) > 0 && s.outcomes[0].at.Before(cutoff) {
+		s.outcomes = s.outcomes[1:]
+	}
+	if len(s.outcomes) < scoreMinAnswers {
+		return nil
+	}
+	negative, unsafe := 0, 0
+	for _, o := range s.outcomes {
+		if o.negative {
+			negative++
+		}
+		if o.unsafe {
+			unsafe++
+		}
+	}
+	var alerts []string
+	check := func(kind string, count int, threshold float64) {
+		rate := float64(count) / float64(len(s.outcomes))
+		switch {
+		case rate >= threshold && !s.alerting[kind]:
+			s.alerting[kind] = true
+			metricAnswerScores.Add("alerts", 1)
+			alerts = append(alerts, fmt.Sprintf(":chart_with_downwards_trend: %d of the last %d answers (%.0f%%) scored %s, above the %.0f%% alert threshold. A prompt or model change may have caused a regression.",
+				count, len(s.outcomes), rate*100, kind, threshold*100))
+		case rate < threshold/2 && s.alerting[kind]:
+			s.alerting[kind] = false
+			alerts = append(alerts, fmt.Sprintf(":white_check_mark: %s answers are back to %.0f%% of the last %d.", kind, rate*100, len(s.outcomes)))
+		}
+	}
+	check("negative", negative, s.negativeRate)
+	check("unsafe", unsafe, s.unsafeRate)
+	return alerts
+}
+
+// scoreConversation scores rec's answer in the background and alerts
+// admins when a rate spikes.
+func scoreConversation(ctx context.Context, api SlackClient, rec *ConversationRecord) {
+	if !answerScores.enabled() || isSelfTest(ctx) {
+		return
+	}
+	text := rec.AnswerText()
+	// Scoring outlives the task that answered.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		ctx, span := otel.Tracer("bot").Start(ctx, "score_answer")
+		defer span.End()
+		score := answerScores.score(ctx, text)
+		span.SetAttributes(attribute.Float64("answer.sentiment", score.Sentiment), attribute.StringSlice("answer.unsafe", score.Unsafe))
+		for _, alert := range answerScores.record(score) {
+			slog.InfoContext(ctx, alert)
+			alertAdmins(ctx, api, alert)
+		}
+	}()
+}
@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Answer Attribution
+//
+// Once a second person asks in a thread, answers there could be read as
+// replies to the wrong question. From then on each answer opens with a
+// quote naming the asker and their question, linked to the question's
+// permalink. Only the first message of an answer is quoted; the stored
+// answer, history and webhooks keep the plain text. Threads are remembered
+// for threadAskersTTL after their last question, at most maxAskerThreads
+// at a time. Answers quoted this way are counted under
+// "answer_attribution" on /debug/vars.
+const (
+	threadAskersTTL        = 24 * time.Hour
+	maxAskerThreads        = 10000
+	attributionQueryLength = 150
+)
+
+var metricAttribution = expvar.NewMap("answer_attribution")
+
+type threadAskerSet struct {
+	users   map[string]bool
+	updated time.Time
+}
+
+type threadAskerTracker struct {
+	mu      sync.Mutex
+	threads map[string]*threadAskerSet
+	now     func() time.Time
+}
+
+func newThreadAskerTracker(now func() time.Time) *threadAskerTracker {
+	return &threadAskerTracker{threads: make(map[string]*threadAskerSet), now: now}
+}
+
+var threadAskers = newThreadAskerTracker(time.Now)
+
+// add records that user asked in channel's thread and reports whether
+// anyone else has asked there too.
+func (t *threadAskerTracker) add(channel, threadTS, user string) bool {
+	if threadTS == "" {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	key := messageKey(channel, threadTS)
+	s, ok := t.threads[key]
+	if !ok {
+		t.evictLocked(now)
+		s = &threadAskerSet{users: make(map[string]bool)}
+		t.threads[key] = s
+	}
+	s.users[user] = true
+	s.updated = now
+	return len(s.users) > 1
+}
+
+// shared reports whether more than one person has asked in the thread.
+func (t *threadAskerTracker) shared(channel, threadTS string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.threads[messageKey(channel, threadTS)]
+	return ok && len(s.users) > 1
+}
+
+// evictLocked drops expired threads and, when still full, the least
+// recently asked one. Callers hold t.mu.
+func (t *threadAskerTracker) evictLocked(now time.Time) {
+	var oldest string
+	for k, s := range t.threads {
+		if now.Sub(s.updated) > threadAskersTTL {
+			delete(t.threads, k)
+			continue
+		}
+		if oldest == "" || s.updated.Before(t.threads[oldest].updated) {
+			oldest = k
+		}
+	}
+	if len(t.threads) >= maxAskerThreads {
+		delete(t.threads, oldest)
+	}
+}
+
+// attributionLine quotes rec's question for the top of its answer.
+func attributionLine(ctx context.Context, api SlackClient, rec ConversationRecord) string {
+	query := truncate(strings.Join(strings.Fields(rec.Query), " "), attributionQueryLength)
+	line := fmt.Sprintf("> <@%s> asked: _%s_", rec.User, query)
+	if link := questionPermalink(ctx, api, rec); link != "" {
+		line += fmt.Sprintf(" (<%s|question>)", link)
+	}
+	return line
+}
+
+// attributeAnswer wraps post so the first message of rec's answer opens
+// with its attribution when the thread has more than one asker.
+func attributeAnswer(ctx context.Context, api SlackClient, rec *ConversationRecord, post func(string, ...slack.Block)) func(string, ...slack.Block) {
+	first := true
+	return func(text string, blocks ...slack.Block) {
+		quote := first && threadAskers.shared(rec.Channel, rec.ThreadTS)
+		first = false
+		if !quote {
+			post(text, blocks...)
+			return
+		}
+		metricAttribution.Add("answers", 1)
+		line := attributionLine(ctx, api, *rec)
+		if len(blocks) > 0 {
+			quoted := slack.NewContextBlock("attribution_"+rec.ID, slack.NewTextBlockObject(slack.MarkdownType, line, false, false))
+			blocks = append([]slack.Block{quoted}, blocks...)
+		}
+		post(line+"\n"+text, blocks...)
+	}
+}
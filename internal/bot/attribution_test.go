@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestThreadAskers_SharedOnceASecondPersonAsks(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	threadAskers := newThreadAskerTracker(func() time.Time { return now })
+	if threadAskers.add("C1", "1.0", "UALICE") || threadAskers.add("C1", "1.0", "UALICE") {
+		t.Fatal("one asker counted as shared")
+	}
+	if threadAskers.add("C1", "", "UBOB") || threadAskers.shared("C1", "") {
+		t.Error("channel root counted as a shared thread")
+	}
+	if !threadAskers.add("C1", "1.0", "UBOB") || !threadAskers.shared("C1", "1.0") {
+		t.Fatal("second asker not counted")
+	}
+	if threadAskers.shared("C2", "1.0") {
+		t.Error("thread in another channel counted as shared")
+	}
+	now = now.Add(threadAskersTTL + time.Minute)
+	threadAskers.add("C1", "2.0", "UALICE")
+	if threadAskers.shared("C1", "1.0") {
+		t.Error("expired thread still shared")
+	}
+}
+
+func TestProcessTask_QuotesQuestionInSharedThread(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	defer func(a *threadAskerTracker) { threadAskers = a }(threadAskers)
+	threadAskers = newThreadAskerTracker(time.Now)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Use the deploy script."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	ask := func(user, msgTS, query string) string {
+		api := &fakeSlackClient{}
+		ev := slackevents.AppMentionEvent{User: user, Channel: "CATTR", TimeStamp: msgTS, ThreadTimeStamp: "100.000001"}
+		processTask(context.Background(), api, ev, query, slack.MsgOptionTS(ev.ThreadTimeStamp))
+		posts := api.sent()
+		if len(posts) == 0 {
+			t.Fatalf("%s: nothing posted", user)
+		}
+		return posts[0].Text()
+	}
+
+	if got := ask("UALICE", "100.000002", "how do I deploy?"); strings.HasPrefix(got, ">") {
+		t.Errorf("first asker's answer quoted: %q", got)
+	}
+	got := ask("UBOB", "100.000003", "how do I\nroll back?")
+	want := "> <@UBOB> asked: _how do I roll back?_ (<https://example.slack.com/archives/CATTR/p100000003|question>)\n"
+	if !strings.HasPrefix(got, want) || !strings.Contains(got, "Use the deploy script.") {
+		t.Errorf("second asker's answer = %q, want prefix %q", got, want)
+	}
+	if got := ask("UALICE", "100.000004", "and staging?"); !strings.HasPrefix(got, "> <@UALICE> asked: _and staging?_") {
+		t.Errorf("follow-up in shared thread = %q", got)
+	}
+}
@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Voice Notes
+//
+// With TRANSCRIBE_URL set, an audio clip sent to the bot in a DM is
+// downloaded, sent to the Whisper-compatible transcription endpoint
+// (multipart "file" and "model" in, {"text"} out) and answered like a typed
+// question. The transcript is posted first so the user can see what was
+// heard; text typed alongside the clip is asked together with it. Reading
+// the clip needs the files:read scope. Transcriptions are counted under
+// "transcriptions" on /debug/vars.
+const (
+	maxAudioBytes          = 25 << 20
+	transcriptionTimeout   = 2 * time.Minute
+	defaultTranscribeModel = "whisper-1"
+)
+
+var metricTranscriptions = expvar.NewMap("transcriptions")
+
+type transcriptionClient struct {
+	URL    string
+	Model  string
+	APIKey string
+}
+
+// transcriber is set from TRANSCRIBE_URL; voice notes are ignored when nil.
+var transcriber *transcriptionClient
+
+// transcribe returns the text spoken in audio.
+func (c *transcriptionClient) transcribe(ctx context.Context, filename string, audio []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, transcriptionTimeout)
+	defer cancel()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	model := c.Model
+	if model == "" {
+		model = defaultTranscribeModel
+	}
+	w.WriteField("model", model)
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		return "", err
+	}
+	part.Write(audio)
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("transcription endpoint returned %s", resp.Status)
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode transcript: %w", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// voiceNote returns the first audio file on a DM, if transcription is on.
+func voiceNote(ev *slackevents.MessageEvent) *slackevents.File {
+	if transcriber == nil || ev.ChannelType != "im" {
+		return nil
+	}
+	for i, f := range ev.Files {
+		if strings.HasPrefix(f.Mimetype, "audio/") {
+			return &ev.Files[i]
+		}
+	}
+	return nil
+}
+
+// processVoiceNote transcribes a DM'd audio clip and answers it.
+func processVoiceNote(ctx context.Context, api SlackClient, ev *slackevents.MessageEvent, file *slackevents.File, pool *workerpool.Pool) {
+	ctx = withRequestID(ctx)
+	requests.record(ctx, "queued", fmt.Sprintf("voice note, queue depth %d", pool.QueueDepth()))
+	emitWebhook(ctx, EventQueryReceived, ev.Channel, ev.User, ev.ThreadTimeStamp, map[string]any{"source": "voice_note", "query": ev.Text})
+	submitConversationTask(ctx, api, pool, ev.Channel, ev.ThreadTimeStamp, ev.User, func() {
+		transcript, err := transcribeVoiceNote(ctx, api, file)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to transcribe audio", "file", file.ID, "err", err)
+			requests.recordError(ctx, fmt.Sprintf("transcription failed: %v", err))
+			emitAnswerFailed(ctx, ev.Channel, ev.User, ev.ThreadTimeStamp, "transcription_failed", err)
+			sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: withErrorReference(ctx, "Sorry, I couldn't make out that voice note. Could you type the question instead?")})
+			return
+		}
+		sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: ":studio_microphone: _I heard:_\n> " + strings.ReplaceAll(transcript, "\n", "\n> ")})
+		query := strings.TrimSpace(ev.Text + "\n" + transcript)
+		processTask(ctx, api, slackevents.AppMentionEvent{User: ev.User, Channel: ev.Channel, Text: query}, query)
+	})
+}
+
+func transcribeVoiceNote(ctx context.Context, api SlackClient, file *slackevents.File) (string, error) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "transcribe_voice_note")
+	defer span.End()
+	span.SetAttributes(attribute.String("file.id", file.ID), attribute.String("file.mimetype", file.Mimetype), attribute.Int("file.size", file.Size))
+	if file.Size > maxAudioBytes {
+		metricTranscriptions.Add("too_large", 1)
+		return "", fmt.Errorf("clip is %d bytes, limit is %d", file.Size, maxAudioBytes)
+	}
+	url := file.URLPrivateDownload
+	if url == "" {
+		url = file.URLPrivate
+	}
+	var audio bytes.Buffer
+	if err := api.GetFileContext(ctx, url, &audio); err != nil {
+		metricTranscriptions.Add("download_errors", 1)
+		span.RecordError(err)
+		return "", fmt.Errorf("download: %w", err)
+	}
+	started := time.Now()
+	transcript, err := transcriber.transcribe(ctx, file.Name, audio.Bytes())
+	if err == nil && transcript == "" {
+		err = errors.New("empty transcript")
+	}
+	if err != nil {
+		metricTranscriptions.Add("errors", 1)
+		span.RecordError(err)
+		return "", err
+	}
+	metricTranscriptions.Add("ok", 1)
+	requests.record(ctx, "transcribed", fmt.Sprintf("%d bytes in %s", audio.Len(), time.Since(started).Round(time.Millisecond)))
+	return transcript, nil
+}
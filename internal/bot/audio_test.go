@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestProcessDirectMessage_TranscribesVoiceNote(t *testing.T) {
+	defer func(c *transcriptionClient) { transcriber = c }(transcriber)
+
+	var gotModel, gotAudio string
+	whisper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotModel = r.FormValue("model")
+		if f, _, err := r.FormFile("file"); err == nil {
+			data, _ := io.ReadAll(f)
+			gotAudio = string(data)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"text": " Why is the deploy stuck? "})
+	}))
+	defer whisper.Close()
+	transcriber = &transcriptionClient{URL: whisper.URL}
+
+	var gotQuery string
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		gotQuery = req.Query
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Check the release lock."})
+	}))
+	defer backendServer.Close()
+	config.BackendURL = backendServer.URL
+
+	api := &fakeSlackClient{files: map[string][]byte{"https://files.slack.com/clip.webm": []byte("opus bytes")}}
+	pool := workerpool.New(1)
+	ev := &slackevents.MessageEvent{User: "U1", Channel: "D1", ChannelType: "im", SubType: "file_share",
+		Files: []slackevents.File{{ID: "F1", Name: "clip.webm", Mimetype: "audio/webm", Size: 10, URLPrivateDownload: "https://files.slack.com/clip.webm"}}}
+	processDirectMessage(context.Background(), api, ev, pool)
+	pool.Shutdown()
+
+	if gotModel != defaultTranscribeModel || gotAudio != "opus bytes" {
+		t.Errorf("transcription request: model %q, audio %q", gotModel, gotAudio)
+	}
+	if gotQuery != "Why is the deploy stuck?" {
+		t.Errorf("backend got %q", gotQuery)
+	}
+	posts := api.sent()
+	if len(posts) != 2 || !strings.Contains(posts[0].Text(), "> Why is the deploy stuck?") || posts[1].Text() != "Check the release lock." {
+		t.Fatalf("expected the transcript and the answer, got %+v", posts)
+	}
+}
+
+func TestProcessDirectMessage_VoiceNoteDownloadFails(t *testing.T) {
+	defer func(c *transcriptionClient) { transcriber = c }(transcriber)
+	transcriber = &transcriptionClient{URL: "http://127.0.0.1:0"}
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+	ev := &slackevents.MessageEvent{User: "U1", Channel: "D1", ChannelType: "im",
+		Files: []slackevents.File{{ID: "F2", Mimetype: "audio/mp4", URLPrivate: "https://files.slack.com/missing"}}}
+	processDirectMessage(context.Background(), api, ev, pool)
+	pool.Shutdown()
+
+	posts := api.sent()
+	if len(posts) != 1 || !strings.Contains(posts[0].Text(), "type the question") {
+		t.Errorf("expected an apology, got %+v", posts)
+	}
+
+	// Without TRANSCRIBE_URL audio-only messages are ignored.
+	transcriber = nil
+	api = &fakeSlackClient{}
+	pool = workerpool.New(1)
+	processDirectMessage(context.Background(), api, ev, pool)
+	pool.Shutdown()
+	if len(api.sent()) != 0 {
+		t.Error("voice notes should be ignored when transcription is off")
+	}
+}
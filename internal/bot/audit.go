@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit Log
+//
+// Security-relevant actions, such as redacting an answer, are appended as
+// JSON lines to AUDIT_LOG_FILE and logged. Entries describe who did what
+// to which message, never the content acted on.
+type AuditEntry struct {
+	At             time.Time `json:"at"`
+	Actor          string    `json:"actor"`
+	Action         string    `json:"action"`
+	Channel        string    `json:"channel,omitempty"`
+	MessageTS      string    `json:"message_ts,omitempty"`
+	ConversationID string    `json:"conversation_id,omitempty"`
+	Detail         string    `json:"detail,omitempty"`
+}
+
+type auditLogger struct {
+	mu   sync.Mutex
+	path string
+}
+
+var auditLog = &auditLogger{}
+
+func (a *auditLogger) configure(path string) {
+	a.mu.Lock()
+	a.path = path
+	a.mu.Unlock()
+}
+
+func (a *auditLogger) record(ctx context.Context, e AuditEntry) error {
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	slog.InfoContext(ctx, "Audit", "action", e.Action, "actor", e.Actor, "channel", e.Channel, "message_ts", e.MessageTS, "conversation", e.ConversationID, "detail", e.Detail)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.path == "" {
+		return nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,119 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"go.opentelemetry.io/otel"
+)
+
+// Backend Client
+//
+// requestAnswer is used where the bot needs the complete answer text before
+// posting anything (edits, rewrites); it accepts both JSON and SSE replies
+// and holds the text in memory, as the message it becomes must. Output that
+// is passed on as a file is requested with requestTranscript instead, which
+// assembles it in a transcript buffer: a large answer spills to disk and
+// the caller reads it from there, so it is never loaded back whole (see
+// transcript.go).
+func requestAnswer(ctx context.Context, chatReq backend.ChatRequest) (string, error) {
+	var answer strings.Builder
+	err := streamAnswer(ctx, chatReq, &answer)
+	return answer.String(), err
+}
+
+// requestTranscript returns the answer in a transcript buffer, which the
+// caller must close.
+func requestTranscript(ctx context.Context, chatReq backend.ChatRequest) (*transcriptBuffer, error) {
+	transcript := newTranscriptBuffer(ctx)
+	if err := streamAnswer(ctx, chatReq, transcript); err != nil {
+		transcript.Close()
+		return nil, err
+	}
+	return transcript, nil
+}
+
+// streamAnswer requests an answer and writes its text to w as it arrives.
+func streamAnswer(ctx context.Context, chatReq backend.ChatRequest, w io.Writer) error {
+	ctx, span := otel.Tracer("bot").Start(ctx, "backend_answer")
+	defer span.End()
+
+	if maintenance.status().Active {
+		return errMaintenance
+	}
+	release, err := backendLimits.acquire(ctx, backendURLFor(ctx))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	body, _ := json.Marshal(chatReq)
+	req, err := newBackendRequest(ctx, body, "application/json")
+	if err != nil {
+		return err
+	}
+	sent := time.Now()
+	resp, err := backendClient.Do(req)
+	backendRegions.observe(ctx, req.URL.String(), time.Since(sent), err != nil || resp.StatusCode >= 500)
+	maintenance.recordBackend(err != nil || resp.StatusCode >= 500)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	defer resp.Body.Close()
+	if err := decodeBackendResponse(resp); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("backend returned %s", resp.Status)
+		span.RecordError(err)
+		return err
+	}
+	written := 0
+	defer func() { budget.charge(budget.cost(resp.Header.Get(costHeader), len(body)+written)) }()
+	write := func(s string) error {
+		n, err := io.WriteString(w, s)
+		written += n
+		return err
+	}
+
+	if resp.Header.Get("Content-Type") == "text/event-stream" {
+		scanner := bufio.NewScanner(resp.Body)
+		dedup := newChunkDeduper()
+		for scanner.Scan() {
+			line := scanner.Text()
+			dedup.observeLine(line)
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var msg backend.ChatResponse
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg); err == nil && !dedup.duplicate(msg) && msg.Event == "message_part" {
+				if written > 0 {
+					if err := write("\n"); err != nil {
+						return err
+					}
+				}
+				if err := write(msg.Text); err != nil {
+					return err
+				}
+			}
+		}
+		return scanner.Err()
+	}
+
+	var result backend.ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return fmt.Errorf("backend error: %s", result.Error)
+	}
+	return write(result.Full)
+}
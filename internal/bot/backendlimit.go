@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Backend Concurrency Caps
+//
+// BACKEND_CONCURRENCY limits in-flight requests per backend host separately
+// from the worker pool, e.g. a local GPU server that only handles 4 at once.
+// Requests over the cap wait for a slot. Waiting, in-flight and wait-time
+// counters are published under "backend_concurrency" on /debug/vars, and a
+// warning is logged when a backend stays saturated with requests waiting for
+// longer than backendSaturationAlert.
+const backendSaturationAlert = 30 * time.Second
+
+var backendConcurrencyMetrics = expvar.NewMap("backend_concurrency")
+
+type backendSemaphore struct {
+	host  string
+	slots chan struct{}
+
+	mu             sync.Mutex
+	waiting        int
+	saturatedSince time.Time
+	alerted        bool
+}
+
+type backendLimiter struct {
+	mu     sync.Mutex
+	limits map[string]int
+	sems   map[string]*backendSemaphore
+}
+
+var backendLimits = &backendLimiter{limits: map[string]int{}, sems: map[string]*backendSemaphore{}}
+
+// parseBackendConcurrency reads "4" (the BACKEND_URL host) or a comma
+// separated list of host=limit pairs.
+func parseBackendConcurrency(spec, defaultURL string) (map[string]int, error) {
+	limits := map[string]int{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		host, value, found := strings.Cut(part, "=")
+		if !found {
+			host, value = backendHost(defaultURL), part
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit %q for %s", value, host)
+		}
+		limits[strings.TrimSpace(host)] = n
+	}
+	return limits, nil
+}
+
+func backendHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host
+}
+
+func (l *backendLimiter) configure(limits map[string]int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits, l.sems = limits, map[string]*backendSemaphore{}
+	for host, n := range limits {
+		backendConcurrencyMetrics.Set(host+".limit", intVar(n))
+	}
+}
+
+func intVar(n int) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(int64(n))
+	return v
+}
+
+func (l *backendLimiter) semaphore(host string) *backendSemaphore {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n, ok := l.limits[host]
+	if !ok {
+		return nil
+	}
+	sem, ok := l.sems[host]
+	if !ok {
+		sem = &backendSemaphore{host: host, slots: make(chan struct{}, n)}
+		l.sems[host] = sem
+	}
+	return sem
+}
+
+// acquire waits for a slot on the backend serving rawURL and returns the
+// function that frees it. Backends without a cap return immediately.
+func (l *backendLimiter) acquire(ctx context.Context, rawURL string) (func(), error) {
+	sem := l.semaphore(backendHost(rawURL))
+	if sem == nil {
+		return func() {}, nil
+	}
+	start := time.Now()
+	queued := false
+	select {
+	case sem.slots <- struct{}{}:
+	default:
+		queued = true
+		sem.wait(1)
+		backendConcurrencyMetrics.Add(sem.host+".queued", 1)
+		select {
+		case sem.slots <- struct{}{}:
+			sem.wait(-1)
+		case <-ctx.Done():
+			sem.wait(-1)
+			return nil, ctx.Err()
+		}
+	}
+	waited := time.Since(start)
+	backendConcurrencyMetrics.Add(sem.host+".in_flight", 1)
+	backendConcurrencyMetrics.Add(sem.host+".wait_ms_total", waited.Milliseconds())
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("backend.slot_wait_ms", waited.Milliseconds()))
+	if queued {
+		requests.record(ctx, "backend_slot", fmt.Sprintf("waited %s", waited.Round(time.Millisecond)))
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-sem.slots
+			backendConcurrencyMetrics.Add(sem.host+".in_flight", -1)
+		})
+	}, nil
+}
+
+// wait adjusts the waiting count and tracks when saturation began.
+func (s *backendSemaphore) wait(delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waiting += delta
+	backendConcurrencyMetrics.Add(s.host+".waiting", int64(delta))
+	switch {
+	case s.waiting == 0:
+		if s.alerted {
+			slog.InfoContext(context.Background(), "Backend is no longer saturated", "backend", s.host)
+		}
+		s.saturatedSince, s.alerted = time.Time{}, false
+	case s.saturatedSince.IsZero():
+		s.saturatedSince = time.Now()
+	}
+}
+
+// checkSaturation logs once per episode when requests have been waiting for
+// the backend longer than backendSaturationAlert.
+func (s *backendSemaphore) checkSaturation(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.alerted || s.saturatedSince.IsZero() || now.Sub(s.saturatedSince) < backendSaturationAlert {
+		return false
+	}
+	s.alerted = true
+	backendConcurrencyMetrics.Add(s.host+".saturation_alerts", 1)
+	slog.WarnContext(context.Background(), "Backend saturated", "backend", s.host, "for", now.Sub(s.saturatedSince).Round(time.Second), "in_flight", cap(s.slots), "waiting", s.waiting)
+	return true
+}
+
+// watchSaturation checks every capped backend until ctx is done.
+func (l *backendLimiter) watchSaturation(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			sems := make([]*backendSemaphore, 0, len(l.sems))
+			for _, sem := range l.sems {
+				sems = append(sems, sem)
+			}
+			l.mu.Unlock()
+			for _, sem := range sems {
+				sem.checkSaturation(now)
+			}
+		}
+	}
+}
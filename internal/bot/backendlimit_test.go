@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseBackendConcurrency(t *testing.T) {
+	limits, err := parseBackendConcurrency("4", "http://gpu.local:8080/v1/chat")
+	if err != nil || limits["gpu.local:8080"] != 4 {
+		t.Errorf("bare limit = %v, %v", limits, err)
+	}
+	limits, err = parseBackendConcurrency("gpu.local:8080=2, api.example.com=16", "")
+	if err != nil || limits["gpu.local:8080"] != 2 || limits["api.example.com"] != 16 {
+		t.Errorf("host limits = %v, %v", limits, err)
+	}
+	for _, bad := range []string{"0", "gpu=x", "gpu=-1"} {
+		if _, err := parseBackendConcurrency(bad, "http://gpu"); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestBackendLimiter_CapsInFlight(t *testing.T) {
+	l := &backendLimiter{}
+	l.configure(map[string]int{"gpu.test": 2})
+
+	var inFlight, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.acquire(context.Background(), "http://gpu.test/chat")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+		}()
+	}
+	wg.Wait()
+	if peak != 2 {
+		t.Errorf("peak in flight = %d, want 2", peak)
+	}
+
+	// Uncapped backends are not limited.
+	release, err := l.acquire(context.Background(), "http://other.test/chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestBackendLimiter_CancelWhileWaitingAndSaturation(t *testing.T) {
+	l := &backendLimiter{}
+	l.configure(map[string]int{"gpu.test": 1})
+	hold, _ := l.acquire(context.Background(), "http://gpu.test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := l.acquire(ctx, "http://gpu.test")
+		done <- err
+	}()
+	sem := l.semaphore("gpu.test")
+	for {
+		sem.mu.Lock()
+		waiting := sem.waiting
+		sem.mu.Unlock()
+		if waiting == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if sem.checkSaturation(time.Now()) {
+		t.Error("should not alert before the threshold")
+	}
+	if !sem.checkSaturation(time.Now().Add(backendSaturationAlert)) {
+		t.Error("expected a saturation alert")
+	}
+	if sem.checkSaturation(time.Now().Add(2 * backendSaturationAlert)) {
+		t.Error("should alert once per episode")
+	}
+
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("expected the canceled wait to fail")
+	}
+	if sem.waiting != 0 || !sem.saturatedSince.IsZero() {
+		t.Errorf("saturation not cleared: waiting=%d since=%v", sem.waiting, sem.saturatedSince)
+	}
+	hold()
+	release, err := l.acquire(context.Background(), "http://gpu.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -0,0 +1,241 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Backend Regions
+//
+// BACKEND_REGIONS lists the same logical backend deployed in several
+// regions, as name=url pairs. Every request goes to the fastest healthy
+// region, judged by a moving average of time to first byte; regions that
+// have not answered yet are tried first so each gets measured. A region
+// that fails regionDegradeConsecutive requests in a row, or whose moving
+// error rate reaches regionDegradeErrorRate, is skipped for regionCooldown
+// and then tried again, so the relay fails over automatically and fails
+// back once the region recovers. The chosen region is set as the
+// backend.region span attribute, and per-region stats are published under
+// "backend_regions" on /debug/vars.
+const (
+	regionEWMAWeight         = 0.3
+	regionDegradeErrorRate   = 0.5
+	regionDegradeConsecutive = 3
+	regionDegradeMinRequests = 5
+	regionCooldown           = 30 * time.Second
+)
+
+type backendRegion struct {
+	Name string
+	URL  string
+
+	latency       time.Duration
+	errorRate     float64
+	requests      int64
+	failures      int64
+	consecutive   int
+	degradedUntil time.Time
+}
+
+type regionSelector struct {
+	mu      sync.Mutex
+	regions []*backendRegion
+}
+
+var backendRegions = &regionSelector{}
+
+func init() {
+	expvar.Publish("backend_regions", expvar.Func(func() any { return backendRegions.status() }))
+}
+
+func parseBackendRegions(spec string) ([]*backendRegion, error) {
+	var regions []*backendRegion
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, rawURL, found := strings.Cut(part, "=")
+		name, rawURL = strings.TrimSpace(name), strings.TrimSpace(rawURL)
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid region %q (use name=url)", part)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid backend URL for region %s: %q", name, rawURL)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate region %q", name)
+		}
+		seen[name] = true
+		regions = append(regions, &backendRegion{Name: name, URL: rawURL})
+	}
+	return regions, nil
+}
+
+func (s *regionSelector) configure(regions []*backendRegion) {
+	s.mu.Lock()
+	s.regions = regions
+	s.mu.Unlock()
+}
+
+func (s *regionSelector) enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.regions) > 0
+}
+
+type regionAttemptsKey struct{}
+
+// withRegionFailover lets retries under ctx avoid regions that already
+// failed them.
+func withRegionFailover(ctx context.Context) context.Context {
+	return context.WithValue(ctx, regionAttemptsKey{}, &regionAttempts{failed: map[string]bool{}})
+}
+
+type regionAttempts struct {
+	mu     sync.Mutex
+	failed map[string]bool
+}
+
+func (a *regionAttempts) has(name string) bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.failed[name]
+}
+
+// pick returns the region the next request should use, or nil when no
+// regions are configured. When every region is degraded or has already
+// failed this request, the one whose cooldown ends first is used rather
+// than failing outright.
+func (s *regionSelector) pick(ctx context.Context) *backendRegion {
+	attempts, _ := ctx.Value(regionAttemptsKey{}).(*regionAttempts)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var best, fallback *backendRegion
+	for _, r := range s.regions {
+		if now.Before(r.degradedUntil) || attempts.has(r.Name) {
+			if fallback == nil || r.degradedUntil.Before(fallback.degradedUntil) {
+				fallback = r
+			}
+			continue
+		}
+		if r.requests == 0 {
+			return r
+		}
+		if best == nil || r.latency < best.latency {
+			best = r
+		}
+	}
+	if best == nil {
+		return fallback
+	}
+	return best
+}
+
+func (s *regionSelector) byURL(rawURL string) *backendRegion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.regions {
+		if r.URL == rawURL {
+			return r
+		}
+	}
+	return nil
+}
+
+// observe records the outcome of a request to rawURL; latency is the time
+// to the response headers and is ignored for failures.
+func (s *regionSelector) observe(ctx context.Context, rawURL string, latency time.Duration, failed bool) {
+	r := s.byURL(rawURL)
+	if r == nil {
+		return
+	}
+	if attempts, ok := ctx.Value(regionAttemptsKey{}).(*regionAttempts); ok && failed {
+		attempts.mu.Lock()
+		attempts.failed[r.Name] = true
+		attempts.mu.Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.requests++
+	outcome := 0.0
+	if failed {
+		outcome = 1
+		r.failures++
+		r.consecutive++
+	} else {
+		r.consecutive = 0
+		if r.latency == 0 {
+			r.latency = latency
+		} else {
+			r.latency = time.Duration(regionEWMAWeight*float64(latency) + (1-regionEWMAWeight)*float64(r.latency))
+		}
+	}
+	r.errorRate = regionEWMAWeight*outcome + (1-regionEWMAWeight)*r.errorRate
+
+	now := time.Now()
+	degraded := now.Before(r.degradedUntil)
+	unhealthy := r.consecutive >= regionDegradeConsecutive ||
+		(r.requests >= regionDegradeMinRequests && r.errorRate >= regionDegradeErrorRate)
+	switch {
+	case failed && unhealthy:
+		r.degradedUntil = now.Add(regionCooldown)
+		if !degraded {
+			slog.WarnContext(ctx, "Backend region degraded, failing over", "region", r.Name, "consecutive_failures", r.consecutive, "error_rate", r.errorRate, "cooldown", regionCooldown)
+		}
+	case !failed && !r.degradedUntil.IsZero():
+		r.degradedUntil = time.Time{}
+		slog.InfoContext(ctx, "Backend region recovered", "region", r.Name)
+	}
+}
+
+// annotate sets the backend.region span attribute for a request to rawURL.
+func (s *regionSelector) annotate(ctx context.Context, rawURL string) {
+	if r := s.byURL(rawURL); r != nil {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("backend.region", r.Name))
+	}
+}
+
+type regionStatus struct {
+	Name      string  `json:"name"`
+	URL       string  `json:"url"`
+	LatencyMS int64   `json:"latency_ms"`
+	ErrorRate float64 `json:"error_rate"`
+	Requests  int64   `json:"requests"`
+	Failures  int64   `json:"failures"`
+	Degraded  bool    `json:"degraded"`
+}
+
+func (s *regionSelector) status() []regionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	out := make([]regionStatus, 0, len(s.regions))
+	for _, r := range s.regions {
+		out = append(out, regionStatus{
+			Name:      r.Name,
+			URL:       r.URL,
+			LatencyMS: r.latency.Milliseconds(),
+			ErrorRate: r.errorRate,
+			Requests:  r.requests,
+			Failures:  r.failures,
+			Degraded:  now.Before(r.degradedUntil),
+		})
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestParseBackendRegions(t *testing.T) {
+	for _, spec := range []string{"eu", "eu=ftp://x", "=http://x", "eu=http://a,eu=http://b"} {
+		if _, err := parseBackendRegions(spec); err == nil {
+			t.Errorf("%q should be rejected", spec)
+		}
+	}
+}
+
+func TestRegionSelector_PrefersFastestHealthy(t *testing.T) {
+	regions, err := parseBackendRegions("us=http://us.test/chat, eu=http://eu.test/chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendRegions := &regionSelector{}
+	backendRegions.configure(regions)
+	ctx := context.Background()
+
+	if r := backendRegions.pick(ctx); r.Name != "us" {
+		t.Fatalf("unmeasured regions should be tried in order, got %s", r.Name)
+	}
+	backendRegions.observe(ctx, "http://us.test/chat", 400*time.Millisecond, false)
+	if r := backendRegions.pick(ctx); r.Name != "eu" {
+		t.Fatalf("eu has not been measured yet, got %s", r.Name)
+	}
+	backendRegions.observe(ctx, "http://eu.test/chat", 100*time.Millisecond, false)
+	if r := backendRegions.pick(ctx); r.Name != "eu" {
+		t.Fatalf("expected the faster region, got %s", r.Name)
+	}
+
+	for i := 0; i < regionDegradeConsecutive; i++ {
+		backendRegions.observe(ctx, "http://eu.test/chat", 0, true)
+	}
+	if r := backendRegions.pick(ctx); r.Name != "us" {
+		t.Fatalf("expected failover to us, got %s", r.Name)
+	}
+	if st := backendRegions.status(); !st[1].Degraded || st[1].Failures != 3 {
+		t.Errorf("eu status = %+v", st[1])
+	}
+
+	// Once the cooldown is over eu is tried again and, if healthy, preferred.
+	backendRegions.mu.Lock()
+	regions[1].degradedUntil = time.Now().Add(-time.Second)
+	backendRegions.mu.Unlock()
+	if r := backendRegions.pick(ctx); r.Name != "eu" {
+		t.Fatalf("expected eu after its cooldown, got %s", r.Name)
+	}
+	backendRegions.observe(ctx, "http://eu.test/chat", 100*time.Millisecond, false)
+	if st := backendRegions.status(); st[1].Degraded {
+		t.Error("eu should have recovered")
+	}
+
+	// Every region down: use the one that comes back first.
+	for _, url := range []string{"http://us.test/chat", "http://eu.test/chat"} {
+		for i := 0; i < regionDegradeConsecutive; i++ {
+			backendRegions.observe(ctx, url, 0, true)
+		}
+	}
+	if r := backendRegions.pick(ctx); r.Name != "us" {
+		t.Errorf("expected the region whose cooldown ends first, got %s", r.Name)
+	}
+}
+
+func TestProcessTask_FailsOverToAnotherRegion(t *testing.T) {
+	var euCalls int32
+	eu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&euCalls, 1)
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer eu.Close()
+	us := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Answer from us."})
+	}))
+	defer us.Close()
+	regions, err := parseBackendRegions("eu=" + eu.URL + ",us=" + us.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(s *regionSelector) { backendRegions = s }(backendRegions)
+	backendRegions = &regionSelector{}
+	backendRegions.configure(regions)
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+
+	api := &fakeSlackClient{}
+	rec := processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CREGION"}, "question")
+	if rec == nil || rec.AnswerText() != "Answer from us." {
+		t.Fatalf("expected the answer from the healthy region, got %+v", rec)
+	}
+	if n := atomic.LoadInt32(&euCalls); n != 1 {
+		t.Errorf("eu called %d times, want 1", n)
+	}
+	if st := backendRegions.status(); st[0].Failures != 1 || st[1].Requests != 1 {
+		t.Errorf("region stats = %+v", st)
+	}
+}
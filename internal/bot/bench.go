@@ -0,0 +1,262 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	relayconfig "github.com/heykvr/chatrelaybot/internal/config"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Benchmark Mode
+//
+// "chatrelaybot bench" drives synthetic mentions through the worker pool
+// against the mock backend and an in-memory Slack sender, so pool size and
+// pacing changes can be compared before they reach production.
+type benchOptions struct {
+	Rate         float64
+	Duration     time.Duration
+	Workers      int
+	ChunkDelay   time.Duration
+	PostInterval time.Duration
+}
+
+type latencySummary struct {
+	P50 time.Duration `json:"p50"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+type benchReport struct {
+	Options       benchOptions   `json:"options"`
+	Submitted     int            `json:"submitted"`
+	Completed     int            `json:"completed"`
+	Posts         int            `json:"posts"`
+	Elapsed       time.Duration  `json:"elapsed"`
+	Throughput    float64        `json:"throughput_per_sec"`
+	Latency       latencySummary `json:"latency"`
+	FirstPost     latencySummary `json:"first_post_latency"`
+	MaxQueueDepth int            `json:"max_queue_depth"`
+	AvgQueueDepth float64        `json:"avg_queue_depth"`
+	MaxSubmitWait time.Duration  `json:"max_submit_wait"`
+}
+
+// benchSlackClient records post times per channel; every synthetic event uses
+// its own channel so posts can be attributed to it. Methods the bot does not
+// call on the answer path are left to the embedded nil interface.
+type benchSlackClient struct {
+	SlackClient
+
+	mu    sync.Mutex
+	posts int
+	first map[string]time.Time
+}
+
+func (b *benchSlackClient) PostMessageContext(ctx context.Context, channel string, options ...slack.MsgOption) (string, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.posts++
+	if _, ok := b.first[channel]; !ok {
+		b.first[channel] = time.Now()
+	}
+	return channel, fmt.Sprintf("%d.000100", b.posts), nil
+}
+
+func (b *benchSlackClient) GetUserInfoContext(ctx context.Context, user string) (*slack.User, error) {
+	return &slack.User{ID: user, TZ: "UTC", Locale: "en-US"}, nil
+}
+
+func (b *benchSlackClient) GetConversationInfoContext(ctx context.Context, input *slack.GetConversationInfoInput) (*slack.Channel, error) {
+	ch := &slack.Channel{}
+	ch.ID = input.ChannelID
+	return ch, nil
+}
+
+func runBenchmark(ctx context.Context, opts benchOptions) benchReport {
+	savedChunkDelay, savedPostInterval, savedBackend := mockChunkDelay, postInterval, config.BackendURL
+	mockChunkDelay, postInterval = opts.ChunkDelay, opts.PostInterval
+	defer func() {
+		mockChunkDelay, postInterval, config.BackendURL = savedChunkDelay, savedPostInterval, savedBackend
+	}()
+
+	backend := httptest.NewServer(http.HandlerFunc(mockBackendHandler))
+	defer backend.Close()
+	config.BackendURL = backend.URL
+
+	api := &benchSlackClient{first: make(map[string]time.Time)}
+	pool := workerpool.New(opts.Workers)
+
+	var (
+		mu        sync.Mutex
+		started   = make(map[string]time.Time)
+		latencies []time.Duration
+		submitMax time.Duration
+	)
+
+	stopSampling := make(chan struct{})
+	samplingDone := make(chan struct{})
+	var depthMax, depthSum, depthSamples int
+	go func() {
+		defer close(samplingDone)
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopSampling:
+				return
+			case <-ticker.C:
+				d := pool.QueueDepth()
+				depthSum += d
+				depthSamples++
+				if d > depthMax {
+					depthMax = d
+				}
+			}
+		}
+	}()
+
+	interval := time.Duration(float64(time.Second) / opts.Rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.After(opts.Duration)
+
+	begin := time.Now()
+	submitted := 0
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-deadline:
+			break loop
+		case <-ticker.C:
+		}
+		ev := slackevents.AppMentionEvent{
+			User:    "UBENCH",
+			Channel: fmt.Sprintf("BENCH-%d", submitted),
+			Text:    fmt.Sprintf("benchmark question %d", submitted),
+		}
+		submitted++
+
+		at := time.Now()
+		mu.Lock()
+		started[ev.Channel] = at
+		mu.Unlock()
+		pool.Submit(func() {
+			processTask(ctx, api, ev, ev.Text)
+			mu.Lock()
+			latencies = append(latencies, time.Since(at))
+			mu.Unlock()
+		})
+		if wait := time.Since(at); wait > submitMax {
+			submitMax = wait
+		}
+	}
+	pool.Shutdown()
+	elapsed := time.Since(begin)
+	close(stopSampling)
+	<-samplingDone
+
+	var firstPost []time.Duration
+	for channel, at := range started {
+		if t, ok := api.first[channel]; ok {
+			firstPost = append(firstPost, t.Sub(at))
+		}
+	}
+
+	report := benchReport{
+		Options:       opts,
+		Submitted:     submitted,
+		Completed:     len(latencies),
+		Posts:         api.posts,
+		Elapsed:       elapsed,
+		Latency:       summarizeLatencies(latencies),
+		FirstPost:     summarizeLatencies(firstPost),
+		MaxQueueDepth: depthMax,
+		MaxSubmitWait: submitMax,
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Completed) / elapsed.Seconds()
+	}
+	if depthSamples > 0 {
+		report.AvgQueueDepth = float64(depthSum) / float64(depthSamples)
+	}
+	return report
+}
+
+func summarizeLatencies(samples []time.Duration) latencySummary {
+	if len(samples) == 0 {
+		return latencySummary{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return latencySummary{
+		P50: percentile(samples, 0.50),
+		P99: percentile(samples, 0.99),
+		Max: samples[len(samples)-1],
+	}
+}
+
+// percentile expects samples sorted ascending.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	i := int(float64(len(samples))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(samples) {
+		i = len(samples) - 1
+	}
+	return samples[i]
+}
+
+func (r benchReport) print(w io.Writer) {
+	fmt.Fprintf(w, "workers=%d rate=%.1f/s duration=%s chunk-delay=%s post-interval=%s\n",
+		r.Options.Workers, r.Options.Rate, r.Options.Duration, r.Options.ChunkDelay, r.Options.PostInterval)
+	fmt.Fprintf(w, "submitted %d, completed %d, %d posts in %s (%.2f events/s)\n",
+		r.Submitted, r.Completed, r.Posts, r.Elapsed.Round(time.Millisecond), r.Throughput)
+	fmt.Fprintf(w, "latency     p50=%s p99=%s max=%s\n", r.Latency.P50, r.Latency.P99, r.Latency.Max)
+	fmt.Fprintf(w, "first post  p50=%s p99=%s max=%s\n", r.FirstPost.P50, r.FirstPost.P99, r.FirstPost.Max)
+	fmt.Fprintf(w, "queue depth max=%d avg=%.1f, max submit wait=%s\n", r.MaxQueueDepth, r.AvgQueueDepth, r.MaxSubmitWait)
+}
+
+// RunBench runs the "bench" subcommand with its command-line arguments.
+func RunBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	opts := benchOptions{}
+	fs.Float64Var(&opts.Rate, "rate", 50, "synthetic events per second")
+	fs.DurationVar(&opts.Duration, "duration", 10*time.Second, "how long to generate events")
+	fs.IntVar(&opts.Workers, "workers", relayconfig.DefaultWorkers, "worker pool size")
+	fs.DurationVar(&opts.ChunkDelay, "chunk-delay", mockChunkDelay, "delay between mock backend stream events")
+	fs.DurationVar(&opts.PostInterval, "post-interval", postInterval, "pause after each posted chunk")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	if opts.Rate <= 0 || opts.Workers <= 0 {
+		log.Fatal("bench: -rate and -workers must be positive")
+	}
+
+	// Per-request logging would dominate the run; only the report is printed.
+	log.SetOutput(io.Discard)
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	report := runBenchmark(context.Background(), opts)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	report.print(os.Stdout)
+}
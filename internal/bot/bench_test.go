@@ -0,0 +1,43 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunBenchmark(t *testing.T) {
+	report := runBenchmark(context.Background(), benchOptions{
+		Rate:         200,
+		Duration:     100 * time.Millisecond,
+		Workers:      4,
+		ChunkDelay:   time.Millisecond,
+		PostInterval: time.Millisecond,
+	})
+
+	if report.Submitted == 0 || report.Completed != report.Submitted {
+		t.Fatalf("expected every submitted event to complete: %+v", report)
+	}
+	if report.Posts != 3*report.Completed {
+		t.Errorf("expected 3 posts per event from the mock stream, got %d for %d events", report.Posts, report.Completed)
+	}
+	if report.Latency.P50 <= 0 || report.Latency.P99 < report.Latency.P50 || report.FirstPost.P50 > report.Latency.P50 {
+		t.Errorf("implausible latency summary: %+v / %+v", report.Latency, report.FirstPost)
+	}
+	if mockChunkDelay != 300*time.Millisecond || postInterval != 500*time.Millisecond {
+		t.Error("benchmark should restore pacing settings")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var samples []time.Duration
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	if got := percentile(samples, 0.5); got != 50*time.Millisecond {
+		t.Errorf("p50 = %s", got)
+	}
+	if got := percentile(samples, 0.99); got != 99*time.Millisecond {
+		t.Errorf("p99 = %s", got)
+	}
+}
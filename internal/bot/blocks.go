@@ -0,0 +1,224 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Backend Blocks
+//
+// A "blocks" stream event carries Slack Block Kit JSON that is posted as-is,
+// so backends can produce rich layouts without relay-side templates. The
+// blocks are checked against the message block schema and Slack's size
+// limits first; invalid blocks fall back to the event's text_chunk. Layout
+// blocks only: buttons and other interactive elements would send actions
+// the relay does not handle, so blocks with them are invalid. The
+// text_chunk, or the blocks' own text, is also the notification fallback and
+// what is stored with the conversation. Every text in the blocks goes
+// through the outgoing filters like message text does, and a block with
+// text the filters reject is dropped.
+const maxMessageBlocks = 50
+
+var messageBlockTypes = map[string]bool{
+	"context": true, "divider": true, "file": true, "header": true,
+	"image": true, "rich_text": true, "section": true, "video": true,
+}
+
+// rawBlock posts a validated block exactly as the backend sent it.
+type rawBlock struct {
+	typ string
+	id  string
+	raw json.RawMessage
+}
+
+func (b rawBlock) BlockType() slack.MessageBlockType { return slack.MessageBlockType(b.typ) }
+func (b rawBlock) ID() string                        { return b.id }
+func (b rawBlock) MarshalJSON() ([]byte, error)      { return b.raw, nil }
+
+type blockTextObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type blockFields struct {
+	Type     string            `json:"type"`
+	BlockID  string            `json:"block_id"`
+	Text     *blockTextObject  `json:"text"`
+	Fields   []blockTextObject `json:"fields"`
+	Elements []json.RawMessage `json:"elements"`
+	ImageURL string            `json:"image_url"`
+	AltText  string            `json:"alt_text"`
+	// Accessory is only checked for its type.
+	Accessory *struct {
+		Type string `json:"type"`
+	} `json:"accessory"`
+}
+
+func checkTextObject(t *blockTextObject, plainOnly bool, max int) error {
+	if t == nil || t.Text == "" {
+		return errors.New("text is required")
+	}
+	if t.Type != "plain_text" && (plainOnly || t.Type != "mrkdwn") {
+		return fmt.Errorf("text type %q not allowed", t.Type)
+	}
+	if n := len([]rune(t.Text)); n > max {
+		return fmt.Errorf("text is %d characters, limit %d", n, max)
+	}
+	return nil
+}
+
+func checkBlock(b blockFields) error {
+	if len(b.BlockID) > 255 {
+		return errors.New("block_id longer than 255 characters")
+	}
+	switch b.Type {
+	case "header":
+		return checkTextObject(b.Text, true, 150)
+	case "section":
+		if b.Text == nil && len(b.Fields) == 0 {
+			return errors.New("section needs text or fields")
+		}
+		if b.Text != nil {
+			if err := checkTextObject(b.Text, false, 3000); err != nil {
+				return err
+			}
+		}
+		if len(b.Fields) > 10 {
+			return fmt.Errorf("%d fields, limit 10", len(b.Fields))
+		}
+		for i := range b.Fields {
+			if err := checkTextObject(&b.Fields[i], false, 2000); err != nil {
+				return fmt.Errorf("field %d: %w", i, err)
+			}
+		}
+		if b.Accessory != nil && b.Accessory.Type != "image" {
+			return fmt.Errorf("accessory type %q not allowed", b.Accessory.Type)
+		}
+	case "context":
+		if len(b.Elements) == 0 || len(b.Elements) > 10 {
+			return fmt.Errorf("context needs 1-10 elements, got %d", len(b.Elements))
+		}
+	case "rich_text":
+		if len(b.Elements) == 0 {
+			return errors.New("rich_text needs elements")
+		}
+	case "image":
+		if b.ImageURL == "" || len(b.ImageURL) > 3000 {
+			return errors.New("image_url is required and limited to 3000 characters")
+		}
+		if b.AltText == "" || len([]rune(b.AltText)) > 2000 {
+			return errors.New("alt_text is required and limited to 2000 characters")
+		}
+	}
+	return nil
+}
+
+// parseBackendBlocks validates raw Block Kit JSON and returns blocks that
+// marshal back to exactly what the backend sent, plus their plain text.
+func parseBackendBlocks(raw json.RawMessage) ([]slack.Block, string, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, "", fmt.Errorf("blocks must be a JSON array: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, "", errors.New("no blocks")
+	}
+	if len(items) > maxMessageBlocks {
+		return nil, "", fmt.Errorf("%d blocks, limit %d", len(items), maxMessageBlocks)
+	}
+	blocks := make([]slack.Block, 0, len(items))
+	var text []string
+	for i, item := range items {
+		var b blockFields
+		if err := json.Unmarshal(item, &b); err != nil {
+			return nil, "", fmt.Errorf("block %d: %w", i, err)
+		}
+		if !messageBlockTypes[b.Type] {
+			return nil, "", fmt.Errorf("block %d: type %q is not allowed in messages", i, b.Type)
+		}
+		if err := checkBlock(b); err != nil {
+			return nil, "", fmt.Errorf("block %d (%s): %w", i, b.Type, err)
+		}
+		if b.Text != nil && b.Text.Text != "" {
+			text = append(text, b.Text.Text)
+		}
+		blocks = append(blocks, rawBlock{typ: b.Type, id: b.BlockID, raw: item})
+	}
+	return blocks, strings.Join(text, "\n"), nil
+}
+
+// sendBlocks posts blocks with a text fallback, both filtered by
+// sendMessage. If the filters drop every block, only the fallback is posted.
+func sendBlocks(ctx context.Context, api SlackClient, channel, user, fallback string, blocks []slack.Block, options ...slack.MsgOption) (string, error) {
+	if len(blocks) > 0 {
+		options = append([]slack.MsgOption{slack.MsgOptionBlocks(blocks...)}, options...)
+	}
+	return sendAnswerMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: fallback}, options...)
+}
+
+// filterBlocks runs the outgoing filters over every "text" and "alt_text"
+// string in blocks: text objects, fields, context and rich text elements.
+// Blocks the filters leave alone are posted unchanged, masked text is
+// rewritten in place, and a block with text the filters reject is dropped.
+func filterBlocks(ctx context.Context, channel, user string, blocks []slack.Block) []slack.Block {
+	if len(outgoingFilters) == 0 {
+		return blocks
+	}
+	out := make([]slack.Block, 0, len(blocks))
+	for _, b := range blocks {
+		raw, err := json.Marshal(b)
+		if err != nil {
+			continue
+		}
+		var tree any
+		if err := json.Unmarshal(raw, &tree); err != nil {
+			continue
+		}
+		changed, rejected := false, false
+		tree = mapBlockText(tree, "", func(text string) string {
+			filtered := filterText(ctx, channel, user, text)
+			if filtered == filterRejectNotice && text != filterRejectNotice {
+				rejected = true
+			}
+			changed = changed || filtered != text
+			return filtered
+		})
+		switch {
+		case rejected:
+			slog.InfoContext(ctx, "Block dropped by content filter", "block", b.BlockType(), "channel", channel)
+		case !changed:
+			out = append(out, b)
+		default:
+			if filtered, err := json.Marshal(tree); err == nil {
+				out = append(out, rawBlock{typ: string(b.BlockType()), id: b.ID(), raw: filtered})
+			}
+		}
+	}
+	return out
+}
+
+// mapBlockText replaces each string under a "text" or "alt_text" key of a
+// decoded block with fn's result.
+func mapBlockText(v any, key string, fn func(string) string) any {
+	switch v := v.(type) {
+	case string:
+		if key == "text" || key == "alt_text" {
+			return fn(v)
+		}
+	case map[string]any:
+		for k, item := range v {
+			v[k] = mapBlockText(item, k, fn)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = mapBlockText(item, key, fn)
+		}
+	}
+	return v
+}
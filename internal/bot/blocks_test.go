@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestParseBackendBlocks(t *testing.T) {
+	valid := `[{"type":"header","text":{"type":"plain_text","text":"Release notes"}},` +
+		`{"type":"section","block_id":"s1","text":{"type":"mrkdwn","text":"*v2* is out"},"accessory":{"type":"image","image_url":"https://example.com/v2.png","alt_text":"v2"}},` +
+		`{"type":"divider"}]`
+	blocks, text, err := parseBackendBlocks(json.RawMessage(valid))
+	if err != nil {
+		t.Fatalf("valid blocks rejected: %v", err)
+	}
+	if text != "Release notes\n*v2* is out" {
+		t.Errorf("text = %q", text)
+	}
+	out, _ := json.Marshal(blocks)
+	if string(out) != valid {
+		t.Errorf("blocks not passed through as-is:\n got %s\nwant %s", out, valid)
+	}
+
+	tooMany := "[" + strings.TrimSuffix(strings.Repeat(`{"type":"divider"},`, maxMessageBlocks+1), ",") + "]"
+	for name, raw := range map[string]string{
+		"not an array":   `{"type":"divider"}`,
+		"empty":          `[]`,
+		"too many":       tooMany,
+		"unknown type":   `[{"type":"modal"}]`,
+		"input in msg":   `[{"type":"input","element":{"type":"plain_text_input"}}]`,
+		"long header":    `[{"type":"header","text":{"type":"plain_text","text":"` + strings.Repeat("x", 151) + `"}}]`,
+		"mrkdwn header":  `[{"type":"header","text":{"type":"mrkdwn","text":"*hi*"}}]`,
+		"empty section":  `[{"type":"section"}]`,
+		"image no alt":   `[{"type":"image","image_url":"https://example.com/a.png"}]`,
+		"actions":        `[{"type":"actions","elements":[{"type":"button","text":{"type":"plain_text","text":"Pay"},"url":"https://example.com"}]}]`,
+		"button":         `[{"type":"section","text":{"type":"mrkdwn","text":"hi"},"accessory":{"type":"button","text":{"type":"plain_text","text":"Open"}}}]`,
+		"long block_id":  `[{"type":"divider","block_id":"` + strings.Repeat("b", 256) + `"}]`,
+		"too many field": `[{"type":"section","fields":[` + strings.TrimSuffix(strings.Repeat(`{"type":"mrkdwn","text":"f"},`, 11), ",") + `]}]`,
+	} {
+		if _, _, err := parseBackendBlocks(json.RawMessage(raw)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestProcessTask_PostsBackendBlocks(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+
+	blocks := `[{"type":"section","text":{"type":"mrkdwn","text":"Rich *answer*"}}]`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"event\":\"blocks\",\"blocks\":%s}\n\n", blocks)
+		fmt.Fprintf(w, "data: {\"event\":\"blocks\",\"text_chunk\":\"Plain fallback\",\"blocks\":[{\"type\":\"header\"}]}\n\n")
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	rec := processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CBLK"}, "foo")
+
+	if len(api.posts) != 2 {
+		t.Fatalf("expected 2 posts, got %d", len(api.posts))
+	}
+	if got := api.posts[0].Values.Get("blocks"); got != blocks {
+		t.Errorf("blocks = %s, want %s", got, blocks)
+	}
+	if got := api.posts[0].Text(); got != "Rich *answer*" {
+		t.Errorf("fallback text = %q", got)
+	}
+	// Invalid blocks are dropped in favour of the text chunk.
+	if api.posts[1].Values.Get("blocks") != "" || api.posts[1].Text() != "Plain fallback" {
+		t.Errorf("invalid blocks should post text only, got %v", api.posts[1].Values)
+	}
+	if rec == nil || len(rec.Answer) != 2 {
+		t.Errorf("answer not recorded: %+v", rec)
+	}
+}
+
+func TestSendBlocks_FiltersBlockText(t *testing.T) {
+	raw := `[{"type":"section","text":{"type":"mrkdwn","text":"Ask Darn support"},"fields":[{"type":"plain_text","text":"darn"}]},` +
+		`{"type":"context","elements":[{"type":"mrkdwn","text":"clean"}]},` +
+		`{"type":"rich_text","elements":[{"type":"rich_text_section","elements":[{"type":"text","text":"heck & more"}]}]}]`
+	blocks, _, err := parseBackendBlocks(json.RawMessage(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { outgoingFilters = nil }()
+
+	mask, _ := newBlocklistFilter([]string{"darn", "heck"}, FilterModeMask)
+	outgoingFilters = []outgoingFilter{mask.Apply}
+	api := &fakeSlackClient{}
+	sendBlocks(context.Background(), api, "C1", "U1", "fallback", blocks)
+	got := api.sent()[0].Values.Get("blocks")
+	want := `[{"fields":[{"text":"****","type":"plain_text"}],"text":{"text":"Ask **** support","type":"mrkdwn"},"type":"section"},` +
+		`{"type":"context","elements":[{"type":"mrkdwn","text":"clean"}]},` +
+		`{"elements":[{"elements":[{"text":"**** \u0026 more","type":"text"}],"type":"rich_text_section"}],"type":"rich_text"}]`
+	if got != want {
+		t.Errorf("masked blocks:\n got %s\nwant %s", got, want)
+	}
+
+	reject, _ := newBlocklistFilter([]string{"darn", "heck"}, FilterModeReject)
+	outgoingFilters = []outgoingFilter{reject.Apply}
+	api = &fakeSlackClient{}
+	sendBlocks(context.Background(), api, "C1", "U1", "fallback", blocks)
+	if got := api.sent()[0].Values.Get("blocks"); got != `[{"type":"context","elements":[{"type":"mrkdwn","text":"clean"}]}]` {
+		t.Errorf("rejected blocks not dropped: %s", got)
+	}
+
+	api = &fakeSlackClient{}
+	sendBlocks(context.Background(), api, "C1", "U1", "fallback", blocks[:1])
+	if p := api.sent()[0]; p.Values.Get("blocks") != "" || p.Text() != "fallback" {
+		t.Errorf("expected the fallback alone when every block is dropped, got %v", p.Values)
+	}
+}
@@ -0,0 +1,1353 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	relayconfig "github.com/heykvr/chatrelaybot/internal/config"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/heykvr/chatrelaybot/slackfetch"
+	"github.com/heykvr/chatrelaybot/slacktape"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// Pacing between posted chunks and between mock backend events; the bench
+// subcommand overrides these to evaluate different settings.
+var (
+	postInterval   = 500 * time.Millisecond
+	mockChunkDelay = 300 * time.Millisecond
+)
+
+// config holds the core settings; see internal/config.
+var config relayconfig.Config
+
+var (
+	// slackProxy is the parsed SLACK_PROXY_URL, if any.
+	slackProxy *url.URL
+	// tracerProvider exports the relay's spans; Run flushes it on exit.
+	tracerProvider *sdktrace.TracerProvider
+)
+
+// slackReader serves paginated Slack reads (history, users) for features
+// that need channel context.
+var slackReader *slackfetch.Fetcher
+
+// OpenTelemetry
+func initTracer(settings tracingSettings) (*sdktrace.TracerProvider, error) {
+	exporter, err := newSpanExporter(context.Background(), settings)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, settings.batchOptions()...),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName("chatrelay-bot"),
+			semconv.ServiceVersion("1.0.0"),
+			attribute.String("environment", "production"),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	return tp, nil
+}
+
+type SlackClient interface {
+	PostMessageContext(ctx context.Context, channel string, options ...slack.MsgOption) (string, string, error)
+	GetConversationInfoContext(ctx context.Context, input *slack.GetConversationInfoInput) (*slack.Channel, error)
+	GetUsersInConversationContext(ctx context.Context, params *slack.GetUsersInConversationParameters) ([]string, string, error)
+	GetPermalinkContext(ctx context.Context, params *slack.PermalinkParameters) (string, error)
+	GetUserInfoContext(ctx context.Context, user string) (*slack.User, error)
+	UpdateMessageContext(ctx context.Context, channel, timestamp string, options ...slack.MsgOption) (string, string, string, error)
+	DeleteMessageContext(ctx context.Context, channel, timestamp string) (string, string, error)
+	UploadFileV2Context(ctx context.Context, params slack.UploadFileV2Parameters) (*slack.FileSummary, error)
+	GetEmojiContext(ctx context.Context) (map[string]string, error)
+	OpenViewContext(ctx context.Context, triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error)
+	PublishViewContext(ctx context.Context, userID string, view slack.HomeTabViewRequest, hash string) (*slack.ViewResponse, error)
+	AddPinContext(ctx context.Context, channel string, item slack.ItemRef) error
+	GetFileContext(ctx context.Context, downloadURL string, writer io.Writer) error
+	CreateChannelCanvasContext(ctx context.Context, channel string, content slack.DocumentContent) (string, error)
+	EditCanvasContext(ctx context.Context, params slack.EditCanvasParams) error
+	LookupCanvasSectionsContext(ctx context.Context, params slack.LookupCanvasSectionsParams) ([]slack.CanvasSection, error)
+}
+
+// Backend Mock
+
+// registerMockBackend adds the mock backend's routes to the admin HTTP
+// server.
+func registerMockBackend() {
+	handler, capabilities := mockBackendHandler, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.Capabilities{Parameters: defaultParamRanges})
+	}
+	if config.BackendSigningSecret != "" {
+		verifier := newSignatureVerifier(config.BackendSigningSecret)
+		handler, capabilities = verifier.requireSignature(handler), verifier.requireSignature(capabilities)
+	}
+	http.HandleFunc(backend.Path, handler)
+	http.HandleFunc(backend.CapabilitiesPath, capabilities)
+}
+
+// serveHTTP serves the admin endpoints and the mock backend until ctx is
+// done, then shuts the server down.
+func serveHTTP(ctx context.Context) error {
+	srv := &http.Server{Addr: ":" + config.Port}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	slog.Info("Backend running", "port", config.Port, "path", backend.Path)
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdown)
+}
+
+func mockBackendHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("backend").Start(r.Context(), "handle_request")
+	defer span.End()
+
+	slog.InfoContext(ctx, "Received request to backend")
+
+	var req backend.ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		span.RecordError(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("user.id", req.UserID),
+		attribute.String("channel.id", req.ChannelID),
+		attribute.String("query", req.Query),
+	)
+
+	if sc, ok := mockScenarios.find(req); ok {
+		span.SetAttributes(attribute.String("mock.scenario", sc.Name))
+		slog.InfoContext(ctx, "Serving mock scenario", "scenario", sc.Name)
+		sc.serve(w, r, req)
+		return
+	}
+
+	if r.Header.Get("Accept") == "text/event-stream" {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher, _ := w.(http.Flusher)
+
+		responses := []backend.ChatResponse{
+			{ID: 1, Event: "message_part", Text: fmt.Sprintf("Processing: %s", req.Query)},
+			{ID: 2, Event: "message_part", Text: "Goroutines are lightweight threads"},
+			{ID: 3, Event: "message_part", Text: "They enable concurrent execution"},
+			{ID: 4, Event: "stream_end", Status: "done"},
+		}
+
+		for _, resp := range responses {
+			data, _ := json.Marshal(resp)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", resp.ID, resp.Event, data)
+			flusher.Flush()
+			time.Sleep(mockChunkDelay)
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{
+			Full: fmt.Sprintf("Complete response to '%s': Goroutines enable concurrency in Go", req.Query),
+		})
+	}
+}
+
+// Bot Logic
+func processMention(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, pool *workerpool.Pool) {
+	ctx = withLogFields(withDefaultQuerySource(ctx, SourceMention), ev.User, ev.Channel)
+	ctx, span := otel.Tracer("bot").Start(ctx, "process_mention")
+	defer span.End()
+
+	cleanQuery := strings.TrimSpace(strings.ReplaceAll(ev.Text, "<@"+ev.BotID+">", ""))
+	if cleanQuery == "" {
+		span.SetAttributes(attribute.Bool("error.invalid_input", true))
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("user.id", ev.User),
+		attribute.String("channel.id", ev.Channel),
+		attribute.String("query", cleanQuery),
+	)
+
+	slog.InfoContext(ctx, "Received mention", "query", cleanQuery)
+	ev.ThreadTimeStamp = answerThread(ev, false)
+
+	if dispatchCommand(ctx, api, ev, cleanQuery) || handleResetRequest(ctx, api, ev, cleanQuery) || handleMaintenance(ctx, api, ev.Channel, ev.User) || handleSmallTalk(ctx, api, ev.Channel, ev.User, cleanQuery) {
+		return
+	}
+	if handleSearch(ctx, api, ev, cleanQuery) || handlePRReview(ctx, api, ev, cleanQuery, pool) {
+		return
+	}
+
+	ctx = withRequestID(ctx)
+	requests.record(ctx, "queued", fmt.Sprintf("queue depth %d", pool.QueueDepth()))
+	emitWebhook(ctx, EventQueryReceived, ev.Channel, ev.User, ev.ThreadTimeStamp, map[string]any{"source": "mention", "query": cleanQuery})
+	run := focusedTask(ctx, api, pool, ev.Channel, ev.ThreadTimeStamp, func() {
+		processTask(ctx, api, ev, cleanQuery, threadOptions(ev.ThreadTimeStamp)...)
+	})
+	if holdFollowUp(ctx, api, ev.Channel, ev.ThreadTimeStamp, ev.User, cleanQuery, run) {
+		return
+	}
+	submitConversationTask(ctx, api, pool, ev.Channel, ev.ThreadTimeStamp, ev.User, run)
+}
+
+// processTask answers query in ev.Channel and returns the saved conversation,
+// or nil when the backend could not be reached. replyOptions are applied to
+// every answer message.
+func processTask(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, query string, replyOptions ...slack.MsgOption) *ConversationRecord {
+	ctx, cancel := taskContext(withLogFields(ctx, ev.User, ev.Channel))
+	defer cancel()
+	ctx, span := otel.Tracer("bot").Start(ctx, "backend_request")
+	defer span.End()
+
+	requestID := conversationIDFrom(ctx)
+	if requestID == "" {
+		ctx = withRequestID(ctx)
+		requestID = conversationIDFrom(ctx)
+	}
+	span.SetAttributes(attribute.String("request.id", requestID), attribute.String("query.source", querySourceFrom(ctx)))
+	requests.describe(ctx, ev.Channel, ev.User)
+	// Work queued before maintenance began is answered with the notice too.
+	if handleMaintenance(ctx, api, ev.Channel, ev.User) {
+		requests.record(ctx, "maintenance", "backend not called")
+		return nil
+	}
+	if setupWizard.blocking(ctx, api, ev.Channel, ev.User) {
+		requests.record(ctx, "setup", "backend not configured")
+		return nil
+	}
+	requests.record(ctx, "started", "")
+	if !isSelfTest(ctx) {
+		threadAskers.add(ev.Channel, ev.ThreadTimeStamp, ev.User)
+	}
+	emitWebhook(ctx, EventAnswerStarted, ev.Channel, ev.User, ev.ThreadTimeStamp, nil)
+	countRequest(ctx, ev.Channel, ev.User, "questions")
+	cc := effectiveChannelConfig(ctx, api, ev.Channel)
+	span.SetAttributes(attribute.Bool("channel.external", cc.External))
+	recordFlags(ctx, span, ev.Channel)
+
+	queryVec, faqRec := answerFromFAQ(ctx, api, ev, cc, query, replyOptions...)
+	if faqRec != nil {
+		return faqRec
+	}
+	ctx, admitted := budget.admit(ctx, api, ev.Channel, ev.User, replyOptions...)
+	if !admitted {
+		return nil
+	}
+	sli := startSLOAnswer(ctx)
+	defer sli.finish()
+
+	chatReq := backend.ChatRequest{
+		UserID:    ev.User,
+		Query:     query,
+		ChannelID: ev.Channel,
+
+		DisableInternalRetrieval: cc.DisableInternalRetrieval,
+		Channel:                  channelContextFor(ctx, api, ev.Channel),
+		GenerationParams:         cc.Generation,
+		Instruction:              instructionFrom(ctx),
+		FormResponse:             formResponseFrom(ctx),
+		NoStore:                  isPrivateDM(ctx),
+		History:                  conversationHistory(ctx, ev.Channel, ev.ThreadTimeStamp, ev.User),
+		Persona:                  personaFor(ctx, ev.Channel),
+	}
+	if flagEnabled(ctx, FlagRetrieval, ev.Channel) {
+		chatReq.Context = pluginContext(ctx, cc, ev.User, ev.Channel, query)
+	} else {
+		chatReq.DisableInternalRetrieval = true
+	}
+	chatReq.Context = append(chatReq.Context, glossaryContext(ctx, ev.Channel, query)...)
+	if m, ok := modelFrom(ctx); ok {
+		chatReq.Model = m.Model
+		span.SetAttributes(attribute.String("model.override", m.Label))
+	}
+	chatReq, err := condenseRequest(ctx, workerPool, chatReq)
+	if err != nil {
+		span.RecordError(err)
+		requests.recordError(ctx, err.Error())
+		emitAnswerFailed(ctx, ev.Channel, ev.User, ev.ThreadTimeStamp, "input_too_long", err)
+		sli.discard()
+		countRequest(ctx, ev.Channel, ev.User, "errors")
+		sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: withErrorReference(ctx, "Sorry, that input is too long and I couldn't summarize enough of it. Please try again or send a shorter excerpt.")}, replyOptions...)
+		return nil
+	}
+	if chatReq.CodeBlocks = extractCodeBlocks(chatReq.Query); len(chatReq.CodeBlocks) > 0 {
+		recordCodeBlocks(chatReq.CodeBlocks)
+		span.SetAttributes(attribute.Int("query.code_blocks", len(chatReq.CodeBlocks)), attribute.String("query.code_language", questionCodeLanguage(chatReq.CodeBlocks)))
+	}
+	if cc.Drafts > 1 && !cc.ReviewMode && !isPrivateDM(ctx) {
+		if err := offerDrafts(ctx, api, ev, query, chatReq, cc, replyOptions...); err != nil {
+			requests.recordError(ctx, err.Error())
+			emitAnswerFailed(ctx, ev.Channel, ev.User, ev.ThreadTimeStamp, "backend_unreachable", err)
+			countRequest(ctx, ev.Channel, ev.User, "errors")
+			sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: withErrorReference(ctx, "Service unavailable, please try later")}, replyOptions...)
+		} else {
+			sli.discard()
+		}
+		return nil
+	}
+	reqBody, _ := json.Marshal(chatReq)
+	target := backendURLFor(ctx)
+	accept := "application/json"
+	if flagEnabled(ctx, FlagStreaming, ev.Channel) {
+		accept = "text/event-stream"
+		if streamFallback.downgraded(target) {
+			accept = "application/json"
+			metricStreamFallback.Add(streamFallbackDowngraded, 1)
+			span.SetAttributes(attribute.String("stream.fallback", streamFallbackDowngraded))
+		}
+	}
+
+	release, err := backendLimits.acquire(ctx, target)
+	if err != nil {
+		return nil
+	}
+	defer release()
+
+	var resp *http.Response
+
+	ctx = withRegionFailover(ctx)
+	// streamCtx lets the stall watchdog drop a stream that sends nothing.
+	streamCtx, cancelStream := context.WithCancel(ctx)
+	defer cancelStream()
+	for attempt := 0; attempt < 3; attempt++ {
+		var req *http.Request
+		req, err = newBackendRequest(streamCtx, reqBody, accept)
+		if err != nil {
+			break
+		}
+		requests.record(ctx, "backend_request", fmt.Sprintf("attempt %d", attempt+1))
+		sent := time.Now()
+		resp, err = backendClient.Do(req)
+		backendRegions.observe(ctx, req.URL.String(), time.Since(sent), err != nil || resp.StatusCode >= 500)
+		if err == nil {
+			// With regions configured, a server error fails over at once.
+			if resp.StatusCode >= 500 && backendRegions.enabled() && attempt < 2 {
+				resp.Body.Close()
+				requests.record(ctx, "backend_error", resp.Status)
+				continue
+			}
+			if err = decodeBackendResponse(resp); err != nil {
+				resp.Body.Close()
+				break
+			}
+			requests.record(ctx, "backend_response", fmt.Sprintf("%s %s, TTFB %s", resp.Status, resp.Header.Get("Content-Type"), time.Since(sent).Round(time.Millisecond)))
+			break
+		}
+		requests.record(ctx, "backend_error", err.Error())
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+
+	maintenance.recordBackend(err != nil || resp.StatusCode >= 500)
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to reach backend")
+		requests.recordError(ctx, fmt.Sprintf("backend unreachable: %v", err))
+		emitAnswerFailed(ctx, ev.Channel, ev.User, ev.ThreadTimeStamp, "backend_unreachable", err)
+		countRequest(ctx, ev.Channel, ev.User, "errors")
+		sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: withErrorReference(ctx, "Service unavailable, please try later")}, replyOptions...)
+		return nil
+	}
+	defer resp.Body.Close()
+	if accept == "text/event-stream" && resp.Header.Get("Content-Type") != "text/event-stream" {
+		span.SetAttributes(attribute.String("stream.fallback", streamFallbackIgnoredAccept))
+		logStreamFallback(ctx, streamFallbackIgnoredAccept, fmt.Sprintf("%s answered a streaming request with %q", target, resp.Header.Get("Content-Type")))
+	}
+
+	rec := &ConversationRecord{ID: requestID, Channel: ev.Channel, ThreadTS: ev.ThreadTimeStamp, User: ev.User, Query: query, QueryTS: ev.TimeStamp, Model: chatReq.Model, Embedding: queryVec, Source: querySourceFrom(ctx)}
+	if chatReq.Persona != nil {
+		rec.Persona = chatReq.Persona.Label()
+	}
+	reportedCost := resp.Header.Get(costHeader)
+	defer func() {
+		cost := budget.cost(reportedCost, len(reqBody)+len(rec.AnswerText()))
+		budget.charge(cost)
+		conversations.Update(rec.ID, func(r *ConversationRecord) { r.Cost = cost })
+	}()
+	answer := newAnswerDelivery(ctx, api, cc, rec, sli, questionCodeLanguage(chatReq.CodeBlocks), replyOptions...)
+	defer answer.finish()
+	post, live, progress := answer.post, answer.live, answer.progress
+	// showForm posts a backend form; reviewers approve text only, so forms
+	// are not shown in review mode.
+	showForm := func(f *backend.Form) {
+		if cc.ReviewMode {
+			slog.InfoContext(ctx, "Skipped backend form in review mode")
+			return
+		}
+		postForm(ctx, api, ev, query, f, replyOptions...)
+	}
+	// showImage uploads a backend image or chart; like forms, they are not
+	// shown in review mode.
+	showImage := func(kind string, img *backend.Image) {
+		if cc.ReviewMode {
+			slog.InfoContext(ctx, "Skipped backend image in review mode")
+			return
+		}
+		text, err := postImage(ctx, api, ev.Channel, ev.User, kind, img, replyOptions...)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipped backend image", "err", err)
+			return
+		}
+		rec.Answer = append(rec.Answer, text)
+	}
+	// answerJSON posts a whole, non-streamed answer.
+	answerJSON := func(body io.Reader) {
+		var result backend.ChatResponse
+		if err := json.NewDecoder(body).Decode(&result); err == nil {
+			rec.Unanswered = result.NoAnswer
+			for _, chunk := range segmentAnswer(result.Full, config.FallbackChunkSize) {
+				post(chunk)
+			}
+			if result.Image != nil {
+				kind := "image"
+				if len(result.Image.Spec) > 0 {
+					kind = "chart"
+				}
+				showImage(kind, result.Image)
+			}
+			if result.Form != nil {
+				showForm(result.Form)
+			}
+			progress.complete()
+		}
+	}
+
+	switch resp.Header.Get("Content-Type") {
+	case "text/event-stream":
+		if live != nil {
+			live.start()
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		dedup := newChunkDeduper()
+		failed := false
+		validator := newStreamValidator(config.StreamValidation)
+		// rejected reports whether the stream must stop because of v.
+		rejected := func(v *streamViolation) bool {
+			span.SetAttributes(attribute.String("stream.violation", v.Kind))
+			if !validator.strict() {
+				slog.WarnContext(ctx, "Dropped invalid chunk", "err", v)
+				return false
+			}
+			span.RecordError(v)
+			slog.WarnContext(ctx, "Stopped answer on invalid chunk", "err", v)
+			requests.recordError(ctx, fmt.Sprintf("invalid stream: %v", v))
+			emitAnswerFailed(ctx, ev.Channel, ev.User, ev.ThreadTimeStamp, "invalid_stream", v)
+			sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User,
+				Text: withErrorReference(ctx, fmt.Sprintf(":warning: The rest of this answer was withheld because the backend sent an invalid response (%v).", v))}, replyOptions...)
+			return true
+		}
+		watchdog := watchStream(cancelStream)
+		for scanner.Scan() {
+			watchdog.stop()
+			select {
+			case <-ctx.Done():
+				return rec
+			default:
+				line := scanner.Text()
+				dedup.observeLine(line)
+				if strings.HasPrefix(line, "data: ") {
+					var msg backend.ChatResponse
+					err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg)
+					if err != nil && validator.enabled() && rejected(validator.malformed(err)) {
+						return rec
+					}
+					if err == nil {
+						if dedup.duplicate(msg) {
+							span.SetAttributes(attribute.Bool("stream.duplicates", true))
+							slog.InfoContext(ctx, "Skipped duplicate chunk", "chunk", msg.ID)
+							continue
+						}
+						if validator.enabled() {
+							if v := validator.check(&msg); v != nil {
+								if rejected(v) {
+									return rec
+								}
+								continue
+							}
+						}
+						if msg.NoAnswer {
+							rec.Unanswered = true
+						}
+						switch msg.Event {
+						case "message_part":
+							post(msg.Text)
+						case "blocks":
+							blocks, text, err := parseBackendBlocks(msg.Blocks)
+							if msg.Text != "" {
+								text = msg.Text
+							}
+							if err != nil {
+								span.SetAttributes(attribute.Bool("stream.invalid_blocks", true))
+								slog.ErrorContext(ctx, "Failed to validate backend blocks", "err", err)
+								blocks = nil
+							}
+							if text != "" || len(blocks) > 0 {
+								post(text, blocks...)
+							}
+						case "form":
+							showForm(msg.Form)
+						case "image", "chart":
+							showImage(msg.Event, msg.Image)
+						case "error":
+							failed = true
+						}
+					}
+				}
+			}
+		}
+		watchdog.stop()
+		if watchdog.stalled() && len(rec.Answer) == 0 {
+			// Something between here and the backend buffers the stream, so
+			// the question is asked again for a whole answer.
+			resp.Body.Close()
+			span.SetAttributes(attribute.String("stream.fallback", streamFallbackStalled))
+			logStreamFallback(ctx, streamFallbackStalled, fmt.Sprintf("%s sent no event within %s", target, streamFallback.stallTimeout()))
+			streamFallback.stalled(target)
+			requests.record(ctx, "stream_fallback", "stalled stream, asking without streaming")
+			whole, err := requestWithoutStreaming(ctx, reqBody)
+			if err != nil {
+				metricStreamFallback.Add("fallback_failed", 1)
+				span.RecordError(err)
+				requests.recordError(ctx, fmt.Sprintf("non-streaming retry failed: %v", err))
+				emitAnswerFailed(ctx, ev.Channel, ev.User, ev.ThreadTimeStamp, "backend_unreachable", err)
+				countRequest(ctx, ev.Channel, ev.User, "errors")
+				sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: withErrorReference(ctx, "Service unavailable, please try later")}, replyOptions...)
+				return rec
+			}
+			defer whole.Body.Close()
+			if cost := whole.Header.Get(costHeader); cost != "" {
+				reportedCost = cost
+			}
+			answerJSON(whole.Body)
+			return rec
+		}
+		if scanner.Err() == nil && !failed {
+			progress.complete()
+		}
+	default:
+		answerJSON(resp.Body)
+	}
+	return rec
+}
+
+// answerDelivery posts the chunks of one answer through the channel's
+// policies: live editing or review, part numbers, the disclaimer footer,
+// attribution and code tags. finish posts what follows the last chunk and
+// stores the conversation.
+type answerDelivery struct {
+	post     func(text string, blocks ...slack.Block)
+	live     *liveAnswer
+	progress *answerProgress
+	// after runs when the answer is finished, last added first.
+	after []func()
+}
+
+// newAnswerDelivery prepares the delivery of rec's answer in cc's channel.
+// sli is nil for answers that leave the latency SLOs out; language tags
+// the answer's untagged code fences.
+func newAnswerDelivery(ctx context.Context, api SlackClient, cc ChannelConfig, rec *ConversationRecord, sli *sloAnswer, language string, replyOptions ...slack.MsgOption) *answerDelivery {
+	d := &answerDelivery{progress: newAnswerProgress()}
+	// chunk is set while an answer chunk, rather than a footer or notice,
+	// is being posted.
+	chunk := false
+	// post delivers one answer chunk; blocks, when present, are posted with
+	// text as their fallback.
+	post := func(text string, blocks ...slack.Block) {
+		send := sendAnswer
+		if cc.Format == answerFormatBlocks {
+			send = sendBlockAnswer
+		}
+		if len(blocks) > 0 {
+			send = func(ctx context.Context, api SlackClient, channel, user, text string, options ...slack.MsgOption) (string, error) {
+				return sendBlocks(ctx, api, channel, user, text, blocks, options...)
+			}
+		}
+		if ts, err := send(ctx, api, rec.Channel, rec.User, text, replyOptions...); err == nil {
+			rec.MessageTS = append(rec.MessageTS, ts)
+			if chunk {
+				rec.AnswerTS = append(rec.AnswerTS, ts)
+			}
+		}
+		time.Sleep(postInterval)
+	}
+	d.after = append(d.after, func() { finishConversation(ctx, api, rec) })
+	if cc.LiveEdit && !cc.ReviewMode {
+		live := newLiveAnswer(ctx, api, rec.Channel, rec.User, func(ts string) {
+			rec.MessageTS = append(rec.MessageTS, ts)
+			rec.AnswerTS = append(rec.AnswerTS, ts)
+		}, replyOptions...)
+		d.live = live
+		d.after = append(d.after, live.finish)
+		posted := post
+		post = func(text string, blocks ...slack.Block) {
+			if len(blocks) > 0 {
+				live.close()
+				posted(text, blocks...)
+				return
+			}
+			live.add(text)
+		}
+	}
+	if cc.ReviewMode {
+		review := &pendingReview{ID: newID(), Channel: rec.Channel, ThreadTS: rec.ThreadTS, User: rec.User, Query: rec.Query, Conversation: rec.ID}
+		// Reviewers approve text, so blocks are reviewed as their fallback.
+		post = func(text string, _ ...slack.Block) {
+			review.Chunks = append(review.Chunks, text)
+		}
+		d.after = append(d.after, func() { submitForReview(ctx, api, cc, review) })
+	}
+	// Reviewers approve the answer as a whole, and a live answer is a single
+	// message, so neither is numbered.
+	numberParts := cc.NumberParts && !cc.ReviewMode && d.live == nil
+	if numberParts {
+		// Registered before the disclaimer so the done message comes last.
+		unlabelled := post
+		d.after = append(d.after, func() {
+			if notice := d.progress.doneNotice(); notice != "" {
+				unlabelled(notice)
+			}
+		})
+	}
+	footer := cc.Disclaimer
+	if isPrivateDM(ctx) {
+		footer = strings.TrimSpace(footer + "\n" + privacyIndicator)
+	}
+	if footer != "" {
+		answered := false
+		inner := post
+		post = func(text string, blocks ...slack.Block) {
+			answered = true
+			inner(text, blocks...)
+		}
+		d.after = append(d.after, func() {
+			if answered {
+				inner(footer)
+			}
+		})
+	}
+	if numberParts {
+		labelled := post
+		post = func(text string, blocks ...slack.Block) {
+			labelled(d.progress.label(text), blocks...)
+		}
+	}
+	if !cc.ReviewMode {
+		// Outside the part labels, so the quote stays the message's first line.
+		post = attributeAnswer(ctx, api, rec, post)
+	}
+	deliver := post
+	post = func(text string, blocks ...slack.Block) {
+		if len(rec.Answer) == 0 {
+			requests.record(ctx, "first_chunk", "")
+			if sli != nil {
+				sli.chunk()
+			}
+		}
+		rec.Answer = append(rec.Answer, text)
+		chunk = true
+		deliver(text, blocks...)
+		chunk = false
+	}
+	if language != "" && answerCodeTags {
+		tagger := &codeFenceTagger{language: language}
+		untagged := post
+		post = func(text string, blocks ...slack.Block) {
+			untagged(tagger.tag(text), blocks...)
+		}
+	}
+	d.after = append(d.after, func() { requests.record(ctx, "answer_done", fmt.Sprintf("%d chunks", len(rec.Answer))) })
+	d.post = post
+	return d
+}
+
+func (d *answerDelivery) finish() {
+	for i := len(d.after) - 1; i >= 0; i-- {
+		d.after[i]()
+	}
+}
+
+func finishConversation(ctx context.Context, api SlackClient, rec *ConversationRecord) {
+	if len(rec.Answer) == 0 {
+		return
+	}
+	// Private DMs are never stored, so they have nothing to sample or
+	// convert to a ticket later.
+	private := isPrivateDM(ctx)
+	if private {
+		metricDMPrivacy.Add("answers", 1)
+	} else {
+		conversations.Save(rec)
+		if !isSelfTest(ctx) {
+			searchIndex.add(ctx, rec)
+		}
+	}
+	rememberConversation(ctx, rec)
+	countRequest(ctx, rec.Channel, rec.User, "answers")
+	emitWebhook(ctx, EventAnswerCompleted, rec.Channel, rec.User, rec.ThreadTS, map[string]any{
+		"query": rec.Query, "answer": rec.AnswerText(), "model": rec.Model, "message_ts": rec.MessageTS, "entry_point": querySourceFrom(ctx), "persona": rec.Persona,
+	})
+	if !isSelfTest(ctx) && !private {
+		evals.sample(rec)
+	}
+	appendToQACanvas(ctx, api, rec)
+	scoreConversation(ctx, api, rec)
+	offerUndo(ctx, api, rec)
+	postResetButton(ctx, api, rec)
+	offerGapReport(ctx, api, rec)
+	if ticketProvider != nil && len(rec.MessageTS) > 0 && !private {
+		sendMessage(ctx, api, outgoingMessage{Channel: rec.Channel, User: rec.User, Text: "Need more help? Convert this conversation to a ticket."},
+			slack.MsgOptionBlocks(ticketButtonBlock(rec.ID)),
+		)
+	}
+}
+
+// Configure applies cfg and reads the settings of every feature from the
+// environment. It sets package state, so a process runs one relay.
+func Configure(cfg relayconfig.Config) (err error) {
+	if n, err := strconv.Atoi(os.Getenv("LOG_BUFFER_SIZE")); err == nil && n > 0 {
+		recentLogs = newLogRing(n)
+	}
+	if err := setupLogging(); err != nil {
+		slog.Error(err.Error())
+	}
+	defer func() {
+		if err != nil {
+			closePlugins()
+		}
+	}()
+
+	config = cfg
+	if slackProxy, err = parseSlackProxy(config.SlackProxyURL); err != nil {
+		return fmt.Errorf("invalid SLACK_PROXY_URL: %w", err)
+	}
+	transportConfig, err := backendTransportConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	backendClient = &http.Client{Transport: newBackendTransport(transportConfig)}
+	if config.DrainTimeout == 0 {
+		config.DrainTimeout = defaultDrainTimeout
+	}
+	replicaID := os.Getenv("REPLICA_ID")
+	if replicaID == "" {
+		replicaID = defaultReplicaID()
+	}
+	leadership.configure(os.Getenv("LEADER_LEASE_FILE"), replicaID, config.LeaderLeaseTTL)
+	if err := validateStreamValidation(config.StreamValidation); err != nil {
+		return err
+	}
+	if err := configureTranscripts(); err != nil {
+		return err
+	}
+	if err := configureSLOs(); err != nil {
+		return err
+	}
+	if err := configureWorkspaces(); err != nil {
+		return err
+	}
+	if err := configureEventDedup(); err != nil {
+		return err
+	}
+	configureHTTPEvents()
+	if err := configureStartupReport(); err != nil {
+		return err
+	}
+	if err := configureTaskOrdering(); err != nil {
+		return err
+	}
+	if err := configureStreamFallback(); err != nil {
+		return err
+	}
+	if err := configureCodeBlocks(); err != nil {
+		return err
+	}
+	if err := configureSearchIndex(); err != nil {
+		return err
+	}
+	configureAnalytics()
+	ticketProvider = newTicketProviderFromEnv()
+	blocklist, err := newBlocklistFilterFromEnv()
+	if err != nil {
+		return fmt.Errorf("failed to load blocklist: %w", err)
+	}
+	if blocklist != nil {
+		outgoingFilters = append(outgoingFilters, blocklist.Apply)
+	}
+	if path := os.Getenv("SUMMARY_TEMPLATE"); path != "" {
+		if err := loadSummaryTemplate(path); err != nil {
+			return fmt.Errorf("failed to load summary template: %w", err)
+		}
+	}
+	schedule, err := parseDigestSchedule(os.Getenv("DIGEST_SCHEDULE"))
+	if err != nil {
+		return fmt.Errorf("invalid DIGEST_SCHEDULE: %w", err)
+	}
+	digests.configure(digestSettings{
+		Channels:   strings.Fields(strings.ReplaceAll(os.Getenv("DIGEST_CHANNELS"), ",", " ")),
+		Target:     os.Getenv("DIGEST_TARGET_CHANNEL"),
+		Recipients: strings.Fields(strings.ReplaceAll(os.Getenv("DIGEST_RECIPIENTS"), ",", " ")),
+		Schedule:   schedule,
+	})
+	if path := os.Getenv("DIGEST_TEMPLATE"); path != "" {
+		if err := loadDigestTemplate(path); err != nil {
+			return fmt.Errorf("failed to load digest template: %w", err)
+		}
+	}
+	if err := loadPluginsFromEnv(); err != nil {
+		return fmt.Errorf("failed to start plugin: %w", err)
+	}
+	if n, err := strconv.Atoi(os.Getenv("OUTBOX_MAX_ATTEMPTS")); err == nil && n > 0 {
+		outbox.maxAttempts = n
+	}
+	evalPercent, _ := strconv.ParseFloat(os.Getenv("EVAL_SAMPLE_PERCENT"), 64)
+	evals.configure(evalPercent)
+	if path := os.Getenv("EVAL_FILE"); path != "" {
+		if err := evals.load(path); err != nil {
+			return fmt.Errorf("failed to load evaluation set: %w", err)
+		}
+	}
+	if path := os.Getenv("GAPS_FILE"); path != "" {
+		if err := knowledgeGaps.load(path); err != nil {
+			return fmt.Errorf("failed to load knowledge gaps: %w", err)
+		}
+	}
+	if path := os.Getenv("OUTBOX_FILE"); path != "" {
+		if err := outbox.load(path); err != nil {
+			return fmt.Errorf("failed to load outbox: %w", err)
+		}
+	}
+
+	if err := dmPrivacy.configure(os.Getenv("DM_PRIVACY")); err != nil {
+		return fmt.Errorf("invalid DM_PRIVACY: %w", err)
+	}
+	if path := os.Getenv("DM_PRIVACY_FILE"); path != "" {
+		if err := dmPrivacy.load(path); err != nil {
+			return fmt.Errorf("failed to load DM privacy settings: %w", err)
+		}
+	}
+	if path := os.Getenv("MOCK_SCENARIOS"); path != "" {
+		if err := loadMockScenarios(path); err != nil {
+			return fmt.Errorf("failed to load mock backend scenarios: %w", err)
+		}
+	}
+	if path := os.Getenv("PERSONAS_FILE"); path != "" {
+		if err := personas.load(path); err != nil {
+			return fmt.Errorf("failed to load personas: %w", err)
+		}
+	}
+	if path := os.Getenv("GLOSSARY_FILE"); path != "" {
+		if err := glossary.load(path); err != nil {
+			return fmt.Errorf("failed to load glossary: %w", err)
+		}
+	}
+	if path := os.Getenv("FEATURE_FLAGS"); path != "" {
+		if err := flags.load(path); err != nil {
+			return fmt.Errorf("failed to load feature flags: %w", err)
+		}
+	}
+
+	limits, err := parseBackendConcurrency(os.Getenv("BACKEND_CONCURRENCY"), config.BackendURL)
+	if err != nil {
+		return fmt.Errorf("invalid BACKEND_CONCURRENCY: %w", err)
+	}
+	backendLimits.configure(limits)
+	panicRate, _ := strconv.ParseFloat(os.Getenv("PANIC_ERROR_RATE"), 64)
+	if panicRate < 0 || panicRate > 1 {
+		return errors.New("PANIC_ERROR_RATE must be between 0 and 1")
+	}
+	negativeRate, _ := strconv.ParseFloat(os.Getenv("SCORE_ALERT_NEGATIVE_RATE"), 64)
+	unsafeRate, _ := strconv.ParseFloat(os.Getenv("SCORE_ALERT_UNSAFE_RATE"), 64)
+	if err := answerScores.configure(strings.ToLower(os.Getenv("ANSWER_SCORING")), negativeRate, unsafeRate); err != nil {
+		return err
+	}
+	memoryTurns := defaultMemoryTurns
+	if v := os.Getenv("MEMORY_TURNS"); v != "" {
+		if memoryTurns, err = strconv.Atoi(v); err != nil || memoryTurns < 0 {
+			return fmt.Errorf("invalid MEMORY_TURNS %q: use a number of turns, or 0 to turn memory off", v)
+		}
+	}
+	memoryTTL := time.Duration(-1)
+	if v := os.Getenv("MEMORY_TTL"); v != "" {
+		if memoryTTL, err = time.ParseDuration(v); err != nil || memoryTTL <= 0 {
+			return fmt.Errorf("invalid MEMORY_TTL %q: use a positive duration such as 30m", v)
+		}
+	}
+	memory.configure(memoryTurns, memoryTTL)
+	var conversationTTL time.Duration
+	if v := os.Getenv("CONVERSATION_TTL"); v != "" {
+		if conversationTTL, err = time.ParseDuration(v); err != nil || conversationTTL <= 0 {
+			return fmt.Errorf("invalid CONVERSATION_TTL %q: use a positive duration such as 720h", v)
+		}
+	}
+	var conversationMax int
+	if v := os.Getenv("CONVERSATION_MAX"); v != "" {
+		if conversationMax, err = strconv.Atoi(v); err != nil || conversationMax <= 0 {
+			return fmt.Errorf("invalid CONVERSATION_MAX %q: use a positive number of records", v)
+		}
+	}
+	conversations.configure(conversationTTL, conversationMax)
+	if v := os.Getenv("UNDO_WINDOW"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("invalid UNDO_WINDOW %q: use a positive duration such as 30s", v)
+		}
+		undos.configure(window)
+	}
+	if v := os.Getenv("FOCUS_WINDOW"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 || window > maxFocusWindow {
+			return fmt.Errorf("invalid FOCUS_WINDOW %q: use a positive duration up to %s", v, maxFocusWindow)
+		}
+		focus.configure(window)
+	}
+	panicMin, _ := strconv.Atoi(os.Getenv("PANIC_MIN_REQUESTS"))
+	maintenance.configure(os.Getenv("MAINTENANCE_NOTICE"), os.Getenv("MAINTENANCE_FILE"), panicRate, panicMin)
+	if backendModels, err = parseBackendModels(os.Getenv("BACKEND_MODELS")); err != nil {
+		return fmt.Errorf("invalid BACKEND_MODELS: %w", err)
+	}
+	regions, err := parseBackendRegions(os.Getenv("BACKEND_REGIONS"))
+	if err != nil {
+		return fmt.Errorf("invalid BACKEND_REGIONS: %w", err)
+	}
+	backendRegions.configure(regions)
+	if config.BackendURL == "" && len(regions) > 0 {
+		config.BackendURL = regions[0].URL
+	}
+	if reactionLanguages, err = parseReactionLanguages(os.Getenv("REACTION_LANGUAGES")); err != nil {
+		return fmt.Errorf("invalid REACTION_LANGUAGES: %w", err)
+	}
+
+	if url := os.Getenv("EMBEDDINGS_URL"); url != "" {
+		var entries []FAQEntry
+		if path := os.Getenv("FAQ_FILE"); path != "" {
+			if entries, err = loadFAQ(path); err != nil {
+				return fmt.Errorf("failed to load FAQ file: %w", err)
+			}
+		}
+		threshold, _ := strconv.ParseFloat(os.Getenv("FAQ_THRESHOLD"), 64)
+		client := &embeddingsClient{URL: url, Model: os.Getenv("EMBEDDINGS_MODEL"), APIKey: os.Getenv("EMBEDDINGS_API_KEY")}
+		if err := faqs.configure(context.Background(), client, entries, threshold); err != nil {
+			return fmt.Errorf("failed to set up FAQ matching: %w", err)
+		}
+	}
+	if url := os.Getenv("TRANSCRIBE_URL"); url != "" {
+		transcriber = &transcriptionClient{URL: url, Model: os.Getenv("TRANSCRIBE_MODEL"), APIKey: os.Getenv("TRANSCRIBE_API_KEY")}
+	}
+	auditLog.configure(os.Getenv("AUDIT_LOG_FILE"))
+	selfTestInterval, _ := time.ParseDuration(os.Getenv("SELFTEST_INTERVAL"))
+	chartRenderURL = os.Getenv("CHART_RENDER_URL")
+	githubToken = os.Getenv("GITHUB_TOKEN")
+	if u := os.Getenv("GITHUB_API_URL"); u != "" {
+		githubAPIURL = u
+	}
+	dailyBudget, _ := strconv.ParseFloat(os.Getenv("COST_BUDGET_DAILY"), 64)
+	monthlyBudget, _ := strconv.ParseFloat(os.Getenv("COST_BUDGET_MONTHLY"), 64)
+	costPer1K, _ := strconv.ParseFloat(os.Getenv("COST_PER_1K_TOKENS"), 64)
+	var cheapModel *ModelOption
+	if label := os.Getenv("BUDGET_CHEAP_MODEL"); label != "" {
+		m, ok := findModel(label)
+		if !ok {
+			return fmt.Errorf("BUDGET_CHEAP_MODEL %q is not in BACKEND_MODELS", label)
+		}
+		cheapModel = &m
+	}
+	if urls := strings.Fields(strings.ReplaceAll(os.Getenv("WEBHOOK_URLS"), ",", " ")); len(urls) > 0 {
+		events := strings.Fields(strings.ReplaceAll(os.Getenv("WEBHOOK_EVENTS"), ",", " "))
+		if webhooks, err = newWebhookDispatcher(urls, os.Getenv("WEBHOOK_SECRET"), events); err != nil {
+			return fmt.Errorf("invalid WEBHOOK_EVENTS: %w", err)
+		}
+	}
+	userLimits := []int{defaultUserMaxInFlight, defaultUserMaxWaiting}
+	for i, name := range []string{"USER_MAX_IN_FLIGHT", "USER_MAX_WAITING"} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s %q", name, v)
+			}
+			userLimits[i] = n
+		}
+	}
+	fairness = newUserSlots(userLimits[0], userLimits[1])
+	budget.configure(dailyBudget, monthlyBudget, costPer1K, cheapModel, strings.Fields(strings.ReplaceAll(os.Getenv("BUDGET_CHANNELS"), ",", " ")))
+	selfTest.configure(os.Getenv("SELFTEST_CHANNEL"), os.Getenv("SELFTEST_QUERY"), selfTestInterval)
+	topChannels := defaultTopChannels
+	if n, err := strconv.Atoi(os.Getenv("METRIC_TOP_CHANNELS")); err == nil && n >= 0 {
+		topChannels = n
+	}
+	userLabels, err := parseUserLabelMode(os.Getenv("METRIC_USER_LABELS"))
+	if err != nil {
+		return err
+	}
+	userBuckets, _ := strconv.Atoi(os.Getenv("METRIC_USER_BUCKETS"))
+	metricLabels = newLabelPolicy(strings.Fields(strings.ReplaceAll(os.Getenv("METRIC_CHANNELS"), ",", " ")), topChannels, userLabels, userBuckets)
+	if intake.overflow, err = parseAckOverflow(os.Getenv("ACK_OVERFLOW")); err != nil {
+		return err
+	}
+	if config.SetupFile == "" {
+		config.SetupFile = defaultSetupFile
+	}
+	setup, err := loadSetup(config.SetupFile)
+	if err != nil {
+		return fmt.Errorf("failed to load setup file: %w", err)
+	}
+	applySetup(setup)
+
+	if config.ChannelConfig != "" {
+		if err := loadChannelConfig(config.ChannelConfig); err != nil {
+			return fmt.Errorf("failed to load channel config: %w", err)
+		}
+	}
+
+	tracing, err := tracingSettingsFromEnv()
+	if err != nil {
+		return err
+	}
+	if tracerProvider, err = initTracer(tracing); err != nil {
+		return fmt.Errorf("failed to initialize tracer: %w", err)
+	}
+	if tracing.Endpoint != "" {
+		slog.Info("Exporting traces over OTLP", "endpoint", tracing.Endpoint, "protocol", tracing.Protocol)
+	}
+	return nil
+}
+
+// Run serves the admin endpoints, connects to Slack and answers questions
+// until ctx is done or a subsystem fails. Configure must be called first.
+func Run(ctx context.Context) error {
+	defer closePlugins()
+	defer func() {
+		if err := tracerProvider.Shutdown(context.Background()); err != nil {
+			slog.Error("Error shutting down tracer", "err", err)
+		}
+	}()
+
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/admin/logs", requireAdminToken(adminLogsHandler))
+	http.HandleFunc("/admin/import", requireAdminToken(adminImportHandler))
+	http.HandleFunc("/admin/outbox", requireAdminToken(adminOutboxHandler))
+	http.HandleFunc("/admin/flags", requireAdminToken(adminFlagsHandler))
+	http.HandleFunc("/admin/maintenance", requireAdminToken(adminMaintenanceHandler))
+	http.HandleFunc("/admin/eval", requireAdminToken(adminEvalHandler))
+	http.HandleFunc("/admin/glossary", requireAdminToken(adminGlossaryHandler))
+	http.HandleFunc("/admin/handover", requireAdminToken(adminHandoverHandler))
+	http.HandleFunc("/admin/gaps", requireAdminToken(adminGapsHandler))
+	http.HandleFunc("/admin/slo", requireAdminToken(adminSLOHandler))
+	http.HandleFunc("/admin/workspaces", requireAdminToken(adminWorkspacesHandler))
+	http.HandleFunc("/admin/startup", requireAdminToken(adminStartupHandler))
+	http.HandleFunc("/slack/install", slackInstallHandler)
+	http.HandleFunc("/slack/oauth_redirect", slackOAuthRedirectHandler)
+	registerAnalyticsAPI(http.DefaultServeMux)
+	registerMockBackend()
+
+	slackClient := slackHTTPClient(slackProxy)
+	if config.SlackRecordFile != "" {
+		// Captures sanitized Web API traffic for slacktape contract tests.
+		slackClient.Transport = slacktape.NewRecorder(slackClient.Transport, config.SlackRecordFile)
+		slog.Info("Recording Slack Web API calls", "path", config.SlackRecordFile)
+	}
+	api := slack.New(
+		config.SlackBotToken,
+		slack.OptionAppLevelToken(config.SlackAppToken),
+		slack.OptionAPIURL(config.SlackAPIURL),
+		slack.OptionHTTPClient(slackClient),
+		slack.OptionDebug(true),
+	)
+	workspaces.connect(func(token string) SlackClient {
+		return slack.New(token, slack.OptionAPIURL(config.SlackAPIURL), slack.OptionHTTPClient(slackClient))
+	})
+
+	// Tier 3 methods allow roughly 50 calls a minute.
+	slackReader = slackfetch.New(api, slackfetch.Options{MinInterval: 1200 * time.Millisecond})
+
+	dialer := slackDialer(slackProxy)
+	socket := socketmode.New(
+		api,
+		socketmode.OptionDialer(dialer),
+		socketmode.OptionLog(socketLogger()),
+	)
+	if err := checkSlackConnectivity(ctx, api, socket, dialer); err != nil {
+		return fmt.Errorf("failed to reach Slack (proxy: %s): %w", redactProxyURL(config.SlackProxyURL), err)
+	}
+	slog.Info("Slack connectivity check passed", "proxy", redactProxyURL(config.SlackProxyURL))
+
+	// The pool is drained rather than shut down on exit; see taskctx.go.
+	pool := workerpool.NewScaling(workerpool.Options{Min: minWorkers(), Max: config.Workers, IdleTimeout: config.WorkerIdleTimeout,
+		QueueSize: config.QueueSize, Overflow: config.QueueOverflow, BlockTimeout: config.QueueBlockTimeout})
+	workerPool = pool
+	registerHTTPEvents(http.DefaultServeMux, api, pool)
+
+	if config.BackendURL == "" {
+		setupWizard.start(ctx, api, config.SetupFile, checkSlackScopes(ctx, slackClient, config.SlackAPIURL, config.SlackBotToken))
+	}
+
+	// Subsystems stop in reverse order; see runner.go.
+	runner := NewRunner()
+	runner.Add(subsystem{name: "http", policy: runOnce, run: serveHTTP})
+	// Started after the HTTP server, which serves the mock backend; a
+	// failed round is retried with the restart backoff.
+	runner.Add(subsystem{name: "warmup", run: func(ctx context.Context) error {
+		return warmUpBackend(ctx, config.WarmupCount, config.WarmupQuery)
+	}})
+	runner.Add(subsystem{
+		name:   "pool",
+		policy: runOnce,
+		run: loop(func(ctx context.Context) {
+			<-ctx.Done()
+			drainTasks(pool, config.DrainTimeout)
+		}),
+		stopTimeout: config.DrainTimeout + drainGrace + time.Second,
+	})
+	if os.Getenv("LEADER_LEASE_FILE") != "" {
+		runner.Add(subsystem{name: "leadership", run: loop(leadership.watch)})
+	}
+	runner.Add(subsystem{name: "daily_summaries", run: loop(func(ctx context.Context) { runDailySummaries(ctx, api) })})
+	runner.Add(subsystem{name: "weekly_digests", run: loop(func(ctx context.Context) { runWeeklyDigests(ctx, api) })})
+	runner.Add(subsystem{name: "self_tests", run: loop(func(ctx context.Context) { runSelfTests(ctx, api) })})
+	runner.Add(subsystem{name: "diagnostics", run: loop(dumpDiagnosticsOnSignal)})
+	runner.Add(subsystem{name: "imports", run: loop(func(ctx context.Context) { imports.run(ctx, api, pool) })})
+	runner.Add(subsystem{name: "outbox", run: loop(func(ctx context.Context) { outbox.run(ctx, api) })})
+	runner.Add(subsystem{name: "backend_saturation", run: loop(backendLimits.watchSaturation)})
+	runner.Add(subsystem{name: "slo", run: loop(func(ctx context.Context) { watchSLOs(ctx, api) })})
+	runner.Add(subsystem{name: "startup_report", policy: runOnce, run: loop(func(ctx context.Context) {
+		warmup.wait(ctx)
+		postStartupReport(ctx, api, slackClient)
+	})})
+	if os.Getenv("FEATURE_FLAGS") != "" {
+		runner.Add(subsystem{name: "flags", run: loop(flags.watch)})
+	}
+	if os.Getenv("MAINTENANCE_FILE") != "" {
+		runner.Add(subsystem{name: "maintenance", run: loop(maintenance.watchFile)})
+	}
+	runner.Add(subsystem{name: "events", run: loop(func(ctx context.Context) { handleEvents(ctx, api, socket, pool) })})
+	runner.Add(subsystem{name: "listener", policy: stopRunner, run: func(ctx context.Context) error {
+		// A handover disconnects from Slack the same way a signal does.
+		listen, stopListening := context.WithCancel(ctx)
+		defer stopListening()
+		handover.attach(stopListening)
+		// User traffic waits for the backend's first warm-up round.
+		warmup.wait(listen)
+		slog.Info("Starting ChatRelayBot...")
+		err := socket.RunContext(listen)
+		slog.Info("Stopped listening for Slack events")
+		if listen.Err() != nil {
+			return nil
+		}
+		return err
+	}})
+	return runner.Run(ctx)
+}
+
+// handleEvents dispatches Socket Mode events until ctx is done.
+func handleEvents(ctx context.Context, api SlackClient, socket *socketmode.Client, pool *workerpool.Pool) {
+	for {
+		var evt socketmode.Event
+		select {
+		case <-ctx.Done():
+			return
+		case evt = <-socket.Events:
+		}
+		switch evt.Type {
+		case socketmode.EventTypeEventsAPI:
+			eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
+			if !ok || handover.paused() {
+				// Unacknowledged events are redelivered to other replicas.
+				continue
+			}
+			decision := intake.decide(ctx, *evt.Request, eventsAPIEvent, pool.Stats())
+			if decision != intakeDelay {
+				socket.Ack(*evt.Request)
+			}
+			if decision != intakeAccept {
+				continue
+			}
+			dispatchEventsAPI(ctx, api, eventsAPIEvent, pool)
+		case socketmode.EventTypeInteractive:
+			callback, ok := evt.Data.(slack.InteractionCallback)
+			if !ok {
+				continue
+			}
+			socket.Ack(*evt.Request)
+			ctx := withWorkspace(detachTask(ctx), callback.Team.ID)
+			handleInteraction(withQuerySource(ctx, SourceInteractive), workspaces.client(ctx, callback.Team.ID, api), callback)
+		case socketmode.EventTypeSlashCommand:
+			cmd, ok := evt.Data.(slack.SlashCommand)
+			if !ok {
+				continue
+			}
+			// Slash commands are not redelivered, so they are taken even
+			// during a handover; draining waits for them.
+			ctx := withWorkspace(detachTask(ctx), cmd.TeamID)
+			socket.Ack(*evt.Request, handleSlashCommand(ctx, workspaces.client(ctx, cmd.TeamID, api), cmd, pool))
+		}
+	}
+}
+
+// dispatchEventsAPI handles an admitted Events API event, whether it came
+// over Socket Mode or HTTP.
+func dispatchEventsAPI(ctx context.Context, api SlackClient, ev slackevents.EventsAPIEvent, pool *workerpool.Pool) {
+	ctx = withWorkspace(detachTask(ctx), ev.TeamID)
+	api = workspaces.client(ctx, ev.TeamID, api)
+	switch innerEvent := ev.InnerEvent.Data.(type) {
+	case *slackevents.AppMentionEvent:
+		processMention(ctx, api, *innerEvent, pool)
+	case *slackevents.MessageEvent:
+		trackThreadLength(ctx, api, innerEvent, pool)
+		if !processFixRequest(ctx, api, innerEvent, pool) {
+			processDirectMessage(ctx, api, innerEvent, pool)
+		}
+	case *slackevents.ReactionAddedEvent:
+		processReaction(ctx, api, innerEvent, pool)
+	case *slackevents.AppHomeOpenedEvent:
+		if innerEvent.Tab == "home" {
+			publishEvalHome(ctx, api, innerEvent.User)
+		}
+	case *slackevents.AppUninstalledEvent:
+		workspaces.uninstall(ctx, ev.TeamID, "app uninstalled")
+	case *slackevents.TokensRevokedEvent:
+		if len(innerEvent.Tokens.Bot) > 0 {
+			workspaces.uninstall(ctx, ev.TeamID, "tokens revoked")
+		}
+	}
+}
+
+func processDirectMessage(ctx context.Context, api SlackClient, ev *slackevents.MessageEvent, pool *workerpool.Pool) {
+	ctx = withLogFields(withDefaultQuerySource(ctx, SourceDM), ev.User, ev.Channel)
+	ctx, span := otel.Tracer("bot").Start(ctx, "process_direct_message")
+	defer span.End()
+
+	note := voiceNote(ev)
+	if ev.BotID != "" || (ev.Text == "" && note == nil) {
+		return
+	}
+
+	if ev.ChannelType != "im" {
+		return
+	}
+	if setupWizard.handleDM(ctx, api, ev) {
+		return
+	}
+
+	if isPrivacyCommand(ev.Text) {
+		dispatchCommand(ctx, api, slackevents.AppMentionEvent{User: ev.User, Channel: ev.Channel}, strings.TrimSpace(ev.Text))
+		return
+	}
+	if isResetRequest(ev.Text) {
+		resetConversation(ctx, api, ev.Channel, ev.ThreadTimeStamp, ev.User, "command")
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("user.id", ev.User),
+		attribute.String("channel.id", ev.Channel),
+	)
+	if dmPrivacy.applies(ev.User) {
+		ctx = withPrivateDM(ctx)
+		span.SetAttributes(attribute.Bool("dm.private", true))
+		slog.InfoContext(ctx, "Received DM in privacy mode")
+	} else {
+		span.SetAttributes(attribute.String("query", ev.Text))
+		slog.InfoContext(ctx, "Received DM", "text", ev.Text)
+	}
+
+	if handleMaintenance(ctx, api, ev.Channel, ev.User) || handleSmallTalk(ctx, api, ev.Channel, ev.User, ev.Text) {
+		return
+	}
+
+	if note != nil {
+		processVoiceNote(ctx, api, ev, note, pool)
+		return
+	}
+
+	ctx = withRequestID(ctx)
+	requests.record(ctx, "queued", fmt.Sprintf("queue depth %d", pool.QueueDepth()))
+	emitWebhook(ctx, EventQueryReceived, ev.Channel, ev.User, ev.ThreadTimeStamp, map[string]any{"source": "dm", "query": ev.Text})
+	submitConversationTask(ctx, api, pool, ev.Channel, ev.ThreadTimeStamp, ev.User, func() {
+		mention := slackevents.AppMentionEvent{
+			User:            ev.User,
+			Channel:         ev.Channel,
+			Text:            ev.Text,
+			TimeStamp:       ev.TimeStamp,
+			ThreadTimeStamp: ev.ThreadTimeStamp,
+		}
+		processTask(ctx, api, mention, ev.Text, threadOptions(answerThread(mention, true))...)
+	})
+}
+
+// Interactions
+func handleInteraction(ctx context.Context, api SlackClient, callback slack.InteractionCallback) {
+	ctx = withLogFields(ctx, callback.User.ID, callback.Channel.ID)
+	switch callback.Type {
+	case slack.InteractionTypeMessageAction:
+		switch callback.CallbackID {
+		case CallbackAskWith:
+			handleAskWithShortcut(ctx, api, callback)
+		case CallbackRedact:
+			handleRedactShortcut(ctx, api, callback)
+		case CallbackExplainError:
+			handleExplainShortcut(ctx, api, callback)
+		}
+		return
+	case slack.InteractionTypeViewSubmission:
+		switch callback.View.CallbackID {
+		case CallbackAskWithModal:
+			handleAskWithSubmission(ctx, api, callback)
+		case CallbackRedactModal:
+			handleRedactSubmission(ctx, api, callback)
+		case CallbackFormModal:
+			handleFormSubmission(ctx, api, callback)
+		}
+		return
+	case slack.InteractionTypeViewClosed:
+		if callback.View.CallbackID == CallbackAskWithModal {
+			takePendingAsk(callback.View.PrivateMetadata)
+		}
+		return
+	case slack.InteractionTypeBlockActions:
+	default:
+		return
+	}
+	for _, action := range callback.ActionCallback.BlockActions {
+		switch action.ActionID {
+		case ActionReviewApprove, ActionReviewReject:
+			handleReviewAction(ctx, api, callback, action)
+		case ActionConvertTicket:
+			handleTicketAction(ctx, api, callback, action)
+		case ActionEvalGood, ActionEvalBad, ActionEvalNeedsSource:
+			handleEvalLabel(ctx, api, callback, action)
+		case ActionFormChoice, ActionFormOpen:
+			handleFormAction(ctx, api, callback, action)
+		case ActionDraftPublish, ActionDraftDiscard:
+			handleDraftAction(ctx, api, callback, action)
+		case ActionResetConversation:
+			handleResetAction(ctx, api, callback, action)
+		case ActionReportGap:
+			handleGapReport(ctx, api, callback, action)
+		case ActionUndoAnswer:
+			handleUndoAction(ctx, api, callback, action)
+		}
+	}
+}
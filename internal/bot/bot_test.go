@@ -1,74 +1,227 @@
-package main
+package bot
 
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
-	"github.com/slack-go/slack"
-	"github.com/slack-go/slack/slackevents"
 )
 
-
 type fakeSlackClient struct {
 	messages []string
 	calls    int32
+
+	mu    sync.Mutex
+	posts []fakePost
+
+	external  map[string]bool
+	topics    map[string]string
+	updates   []fakePost
+	deleted   []string
+	uploads   []slack.UploadFileV2Parameters
+	views     []slack.ModalViewRequest
+	homeViews []slack.HomeTabViewRequest
+	pins      []slack.ItemRef
+	files     map[string][]byte
+	canvases  map[string][]string
+	infoErr   error
+	private   map[string]bool
+	members   map[string][]string
 }
 
+type fakePost struct {
+	Channel string
+	Values  url.Values
+}
+
+func (p fakePost) Text() string { return p.Values.Get("text") }
+
 func (f *fakeSlackClient) PostMessageContext(ctx context.Context, channel string, options ...slack.MsgOption) (string, string, error) {
 	atomic.AddInt32(&f.calls, 1)
+	_, values, _ := slack.UnsafeApplyMsgOptions("", channel, "", options...)
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.messages = append(f.messages, "message sent")
-	return "", "", nil
+	f.posts = append(f.posts, fakePost{Channel: channel, Values: values})
+	return channel, fmt.Sprintf("%d.000100", len(f.posts)), nil
 }
 
+func (f *fakeSlackClient) GetConversationInfoContext(ctx context.Context, input *slack.GetConversationInfoInput) (*slack.Channel, error) {
+	if f.infoErr != nil {
+		return nil, f.infoErr
+	}
+	ch := &slack.Channel{}
+	ch.ID = input.ChannelID
+	ch.IsExtShared = f.external[input.ChannelID]
+	ch.IsPrivate = f.private[input.ChannelID]
+	ch.Name = strings.ToLower(input.ChannelID)
+	ch.IsMember = true
+	ch.Topic.Value = f.topics[input.ChannelID]
+	ch.Purpose.Value = f.topics[input.ChannelID+"/purpose"]
+	return ch, nil
+}
 
+func (f *fakeSlackClient) GetUsersInConversationContext(ctx context.Context, params *slack.GetUsersInConversationParameters) ([]string, string, error) {
+	return f.members[params.ChannelID], "", nil
+}
 
-func TestWorkerPool_SubmitAndShutdown(t *testing.T) {
-	var count int32
-	pool := NewWorkerPool(3)
-	for i := 0; i < 10; i++ {
-		pool.Submit(func() {
-			atomic.AddInt32(&count, 1)
-		})
+func (f *fakeSlackClient) GetPermalinkContext(ctx context.Context, params *slack.PermalinkParameters) (string, error) {
+	return fmt.Sprintf("https://example.slack.com/archives/%s/p%s", params.Channel, strings.ReplaceAll(params.Ts, ".", "")), nil
+}
+
+func (f *fakeSlackClient) GetUserInfoContext(ctx context.Context, user string) (*slack.User, error) {
+	u := &slack.User{ID: user, RealName: "Test User " + user, TZ: "UTC"}
+	u.Profile.Email = strings.ToLower(user) + "@example.com"
+	return u, nil
+}
+
+func (f *fakeSlackClient) UpdateMessageContext(ctx context.Context, channel, timestamp string, options ...slack.MsgOption) (string, string, string, error) {
+	_, values, _ := slack.UnsafeApplyMsgOptions("", channel, "", options...)
+	values.Set("ts", timestamp)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates = append(f.updates, fakePost{Channel: channel, Values: values})
+	return channel, timestamp, values.Get("text"), nil
+}
+
+func (f *fakeSlackClient) AddPinContext(ctx context.Context, channel string, item slack.ItemRef) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pins = append(f.pins, item)
+	return nil
+}
+
+func (f *fakeSlackClient) CreateChannelCanvasContext(ctx context.Context, channel string, content slack.DocumentContent) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.canvases == nil {
+		f.canvases = map[string][]string{}
 	}
-	pool.Shutdown()
-	if count != 10 {
-		t.Errorf("expected 10 tasks to run, got %d", count)
+	id := "F" + channel
+	f.canvases[id] = append(f.canvases[id], content.Markdown)
+	return id, nil
+}
+
+func (f *fakeSlackClient) EditCanvasContext(ctx context.Context, params slack.EditCanvasParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.canvases[params.CanvasID]; !ok {
+		return fmt.Errorf("canvas_not_found")
 	}
+	entries := f.canvases[params.CanvasID]
+	for _, c := range params.Changes {
+		if c.SectionID == "" {
+			entries = append(entries, c.DocumentContent.Markdown)
+			continue
+		}
+		_, index, _ := strings.Cut(c.SectionID, ":")
+		i, err := strconv.Atoi(index)
+		if err != nil || i >= len(entries) {
+			return fmt.Errorf("invalid_section_id")
+		}
+		if c.Operation == "delete" {
+			entries[i] = ""
+		} else {
+			entries[i] = c.DocumentContent.Markdown
+		}
+	}
+	f.canvases[params.CanvasID] = entries
+	return nil
 }
 
-func TestWorkerPool_ConcurrentSubmit(t *testing.T) {
-	var count int32
-	pool := NewWorkerPool(5)
-	for i := 0; i < 50; i++ {
-		pool.Submit(func() {
-			time.Sleep(2 * time.Millisecond)
-			atomic.AddInt32(&count, 1)
-		})
+// LookupCanvasSectionsContext treats each canvas entry as a section and
+// matches the text of its "## " heading.
+func (f *fakeSlackClient) LookupCanvasSectionsContext(ctx context.Context, params slack.LookupCanvasSectionsParams) ([]slack.CanvasSection, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries, ok := f.canvases[params.CanvasID]
+	if !ok {
+		return nil, fmt.Errorf("canvas_not_found")
 	}
-	pool.Shutdown()
-	if count != 50 {
-		t.Errorf("expected 50 tasks to run, got %d", count)
+	var sections []slack.CanvasSection
+	for i, entry := range entries {
+		for _, line := range strings.Split(entry, "\n") {
+			if heading, ok := strings.CutPrefix(line, "## "); ok && strings.Contains(heading, params.Criteria.ContainsText) {
+				sections = append(sections, slack.CanvasSection{ID: fmt.Sprintf("%s:%d", params.CanvasID, i)})
+			}
+		}
 	}
+	return sections, nil
 }
 
+func (f *fakeSlackClient) GetFileContext(ctx context.Context, downloadURL string, writer io.Writer) error {
+	f.mu.Lock()
+	data, ok := f.files[downloadURL]
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("file %s not found", downloadURL)
+	}
+	_, err := writer.Write(data)
+	return err
+}
+
+func (f *fakeSlackClient) DeleteMessageContext(ctx context.Context, channel, timestamp string) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, timestamp)
+	return channel, timestamp, nil
+}
+
+func (f *fakeSlackClient) UploadFileV2Context(ctx context.Context, params slack.UploadFileV2Parameters) (*slack.FileSummary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploads = append(f.uploads, params)
+	return &slack.FileSummary{ID: fmt.Sprintf("F%d", len(f.uploads)), Title: params.Title}, nil
+}
+
+func (f *fakeSlackClient) GetEmojiContext(ctx context.Context) (map[string]string, error) {
+	return map[string]string{"shipit": "https://emoji.example.com/shipit.png"}, nil
+}
+
+func (f *fakeSlackClient) OpenViewContext(ctx context.Context, triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.views = append(f.views, view)
+	return &slack.ViewResponse{}, nil
+}
+
+func (f *fakeSlackClient) PublishViewContext(ctx context.Context, userID string, view slack.HomeTabViewRequest, hash string) (*slack.ViewResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.homeViews = append(f.homeViews, view)
+	return &slack.ViewResponse{}, nil
+}
+
+func (f *fakeSlackClient) sent() []fakePost {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakePost(nil), f.posts...)
+}
 
 func TestProcessMention_SubmitsTaskForValidQuery(t *testing.T) {
 	// Setup test backend
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ChatResponse{Full: "Test backend reply."})
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Test backend reply."})
 	}))
 	defer ts.Close()
 	config.BackendURL = ts.URL
 
 	api := &fakeSlackClient{}
-	pool := NewWorkerPool(1)
+	pool := workerpool.New(1)
 	defer pool.Shutdown()
 
 	ev := slackevents.AppMentionEvent{
@@ -87,7 +240,7 @@ func TestProcessMention_SubmitsTaskForValidQuery(t *testing.T) {
 
 func TestProcessMention_DoesNotSubmitForEmptyQuery(t *testing.T) {
 	api := &fakeSlackClient{}
-	pool := NewWorkerPool(1)
+	pool := workerpool.New(1)
 	defer pool.Shutdown()
 
 	ev := slackevents.AppMentionEvent{
@@ -104,13 +257,11 @@ func TestProcessMention_DoesNotSubmitForEmptyQuery(t *testing.T) {
 	}
 }
 
-
-
 func TestProcessTask_JSONResponse(t *testing.T) {
 	// Mock backend server returns JSON
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ChatResponse{
+		json.NewEncoder(w).Encode(backend.ChatResponse{
 			Full: "Sentence one. Sentence two.",
 		})
 	})
@@ -136,7 +287,7 @@ func TestProcessTask_SSEResponse(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/event-stream")
 		flusher, _ := w.(http.Flusher)
-		responses := []ChatResponse{
+		responses := []backend.ChatResponse{
 			{ID: 1, Event: "message_part", Text: "part1"},
 			{ID: 2, Event: "message_part", Text: "part2"},
 			{ID: 3, Event: "stream_end", Status: "done"},
@@ -164,20 +315,18 @@ func TestProcessTask_SSEResponse(t *testing.T) {
 	}
 }
 
-
-
 func TestProcessDirectMessage_ValidDM(t *testing.T) {
 	// 1. Setup test backend
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ChatResponse{Full: "Test backend reply."})
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Test backend reply."})
 	}))
 	defer ts.Close()
 	config.BackendURL = ts.URL
 
 	// 2. Setup fake Slack client and pool
 	api := &fakeSlackClient{}
-	pool := NewWorkerPool(1)
+	pool := workerpool.New(1)
 	defer pool.Shutdown()
 
 	// 3. Create a DM event
@@ -198,7 +347,7 @@ func TestProcessDirectMessage_ValidDM(t *testing.T) {
 
 func TestProcessDirectMessage_IgnoresBotOrNonIM(t *testing.T) {
 	api := &fakeSlackClient{}
-	pool := NewWorkerPool(1)
+	pool := workerpool.New(1)
 	defer pool.Shutdown()
 	ev := &slackevents.MessageEvent{
 		User:        "U1",
@@ -225,8 +374,6 @@ func TestProcessDirectMessage_IgnoresBotOrNonIM(t *testing.T) {
 	}
 }
 
-
-
 func TestBackendRequestFormatting(t *testing.T) {
 	ev := slackevents.AppMentionEvent{
 		User:    "U1",
@@ -234,12 +381,12 @@ func TestBackendRequestFormatting(t *testing.T) {
 		Text:    "Hello",
 	}
 	query := "Hello"
-	reqBody, _ := json.Marshal(ChatRequest{
+	reqBody, _ := json.Marshal(backend.ChatRequest{
 		UserID:    ev.User,
 		Query:     query,
 		ChannelID: ev.Channel,
 	})
-	var req ChatRequest
+	var req backend.ChatRequest
 	if err := json.Unmarshal(reqBody, &req); err != nil {
 		t.Fatalf("failed to unmarshal: %v", err)
 	}
@@ -248,14 +395,12 @@ func TestBackendRequestFormatting(t *testing.T) {
 	}
 }
 
-
-
 func TestMockBackend_JSONResponse(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var req ChatRequest
+		var req backend.ChatRequest
 		json.NewDecoder(r.Body).Decode(&req)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ChatResponse{
+		json.NewEncoder(w).Encode(backend.ChatResponse{
 			Full: "Complete response to 'foo': Goroutines enable concurrency in Go",
 		})
 	})
@@ -270,7 +415,7 @@ func TestMockBackend_JSONResponse(t *testing.T) {
 		t.Fatalf("http request failed: %v", err)
 	}
 	defer resp.Body.Close()
-	var chatResp ChatResponse
+	var chatResp backend.ChatResponse
 	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
 		t.Fatalf("failed to decode: %v", err)
 	}
@@ -283,7 +428,7 @@ func TestMockBackend_SSEResponse(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/event-stream")
 		flusher, _ := w.(http.Flusher)
-		responses := []ChatResponse{
+		responses := []backend.ChatResponse{
 			{ID: 1, Event: "message_part", Text: "Processing: foo"},
 			{ID: 2, Event: "message_part", Text: "Goroutines are lightweight threads"},
 			{ID: 3, Event: "stream_end", Status: "done"},
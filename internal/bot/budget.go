@@ -0,0 +1,249 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Cost Budget
+//
+// COST_BUDGET_DAILY and COST_BUDGET_MONTHLY cap backend spend in dollars.
+// A backend reports what a request cost in the X-Request-Cost response
+// header; without it, the cost is estimated from the request and answer
+// size at COST_PER_1K_TOKENS (four characters to a token). As spend on the
+// tighter budget grows the relay sheds load in tiers: from 70% answers are
+// no longer rewritten or translated, from 85% questions go to the cheaper
+// BUDGET_CHEAP_MODEL (a BACKEND_MODELS label), and from 95% only the
+// BUDGET_CHANNELS are answered. Users are told once per tier, and the
+// admin channel is alerted when the tier changes in either direction.
+// Spend is kept in memory and starts again at midnight UTC and on the
+// first of the month. Spend and the current tier are under "cost_budget"
+// on /debug/vars, and shed or rerouted questions under
+// "cost_budget_events".
+const (
+	costHeader            = "X-Request-Cost"
+	defaultCostPer1KToken = 0.002
+)
+
+type budgetTier int
+
+const (
+	tierNormal budgetTier = iota
+	tierNoRegeneration
+	tierCheapModel
+	tierAllowlist
+)
+
+// budgetThresholds is the share of the budget at which each tier starts.
+var budgetThresholds = [...]float64{0, 0.70, 0.85, 0.95}
+
+func (t budgetTier) String() string {
+	return [...]string{"normal", "no_regeneration", "cheap_model", "allowlist"}[t]
+}
+
+// effects describes what the tier and the ones below it turn off.
+func (t budgetTier) effects() string {
+	switch t {
+	case tierNoRegeneration:
+		return "rewrites and translations are paused"
+	case tierCheapModel:
+		return "rewrites and translations are paused and questions use a smaller model"
+	case tierAllowlist:
+		return "only priority channels are answered, rewrites and translations are paused and questions use a smaller model"
+	}
+	return "all features are available"
+}
+
+type costBudget struct {
+	mu       sync.Mutex
+	daily    float64
+	monthly  float64
+	per1K    float64
+	cheap    *ModelOption
+	channels map[string]bool
+	now      func() time.Time
+
+	day, month           string
+	spentDay, spentMonth float64
+	announced            budgetTier
+	// notified is the tier each user was last told about.
+	notified map[string]budgetTier
+}
+
+func newCostBudget(now func() time.Time) *costBudget {
+	return &costBudget{per1K: defaultCostPer1KToken, now: now}
+}
+
+var (
+	budget       = newCostBudget(time.Now)
+	metricBudget = expvar.NewMap("cost_budget_events")
+)
+
+func init() {
+	expvar.Publish("cost_budget", expvar.Func(func() any { return budget.snapshot() }))
+}
+
+func (b *costBudget) configure(daily, monthly, per1K float64, cheap *ModelOption, channels []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.daily, b.monthly, b.cheap = daily, monthly, cheap
+	if per1K > 0 {
+		b.per1K = per1K
+	}
+	b.channels = map[string]bool{}
+	for _, ch := range channels {
+		b.channels[ch] = true
+	}
+}
+
+// cost returns what a request cost: the backend's reported cost, or an
+// estimate from the number of characters sent and received.
+func (b *costBudget) cost(reported string, chars int) float64 {
+	if c, err := strconv.ParseFloat(strings.TrimSpace(reported), 64); err == nil && c >= 0 {
+		return c
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return float64(chars) / 4 / 1000 * b.per1K
+}
+
+func (b *costBudget) charge(cost float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollLocked()
+	b.spentDay += cost
+	b.spentMonth += cost
+}
+
+func (b *costBudget) rollLocked() {
+	t := b.now().UTC()
+	if day := t.Format(time.DateOnly); day != b.day {
+		b.day, b.spentDay = day, 0
+	}
+	if month := t.Format("2006-01"); month != b.month {
+		b.month, b.spentMonth = month, 0
+	}
+}
+
+// usedLocked returns the share of the tighter budget spent.
+func (b *costBudget) usedLocked() float64 {
+	var used float64
+	if b.daily > 0 {
+		used = b.spentDay / b.daily
+	}
+	if b.monthly > 0 {
+		used = max(used, b.spentMonth/b.monthly)
+	}
+	return used
+}
+
+// tier returns the current tier, alerting admins when it has changed.
+func (b *costBudget) tier(ctx context.Context, api SlackClient) budgetTier {
+	b.mu.Lock()
+	b.rollLocked()
+	used := b.usedLocked()
+	t := tierNormal
+	for i := len(budgetThresholds) - 1; i > 0; i-- {
+		if used >= budgetThresholds[i] {
+			t = budgetTier(i)
+			break
+		}
+	}
+	previous := b.announced
+	if t != previous {
+		b.announced, b.notified = t, map[string]budgetTier{}
+	}
+	b.mu.Unlock()
+
+	if t != previous {
+		metricBudget.Add("tier_changes", 1)
+		verb := "reached"
+		if t < previous {
+			verb = "dropped to"
+		}
+		slog.InfoContext(ctx, "Cost budget tier changed", "from", previous, "to", t, "used", used)
+		alertAdmins(ctx, api, fmt.Sprintf(":money_with_wings: Backend spend has %s %.0f%% of the budget, so %s.", verb, used*100, t.effects()))
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("budget.tier", t.String()))
+	return t
+}
+
+// notifyOnce reports whether user has not yet been told about tier t.
+func (b *costBudget) notifyOnce(user string, t budgetTier) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.notified == nil {
+		b.notified = map[string]budgetTier{}
+	}
+	if told, ok := b.notified[user]; ok && told >= t {
+		return false
+	}
+	b.notified[user] = t
+	return true
+}
+
+// admit applies the current tier to a question. It returns false, after
+// telling the user, when the question is not answered, and otherwise the
+// context to answer it with.
+func (b *costBudget) admit(ctx context.Context, api SlackClient, channel, user string, replyOptions ...slack.MsgOption) (context.Context, bool) {
+	if isSelfTest(ctx) {
+		return ctx, true
+	}
+	t := b.tier(ctx, api)
+	b.mu.Lock()
+	allowed, cheap := b.channels[channel], b.cheap
+	b.mu.Unlock()
+	switch {
+	case t >= tierAllowlist && !allowed:
+		metricBudget.Add("shed", 1)
+		requests.record(ctx, "budget", "shed outside priority channels")
+		sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user,
+			Text: ":money_with_wings: The bot has nearly used up its budget, so for now it is only answering in priority channels. Please try again later."}, replyOptions...)
+		return ctx, false
+	case t >= tierCheapModel && cheap != nil:
+		if _, ok := modelFrom(ctx); !ok {
+			metricBudget.Add("cheap_model", 1)
+			requests.record(ctx, "budget", "routed to "+cheap.Label)
+			ctx = withModel(ctx, *cheap)
+			if b.notifyOnce(user, t) {
+				notifyUser(ctx, api, channel, user, ":money_with_wings: The bot is close to its budget, so answers come from a smaller model for now.")
+			}
+		}
+	}
+	return ctx, true
+}
+
+// allowRegeneration reports whether answers may be rewritten or
+// translated, telling the user why not.
+func (b *costBudget) allowRegeneration(ctx context.Context, api SlackClient, channel, user string) bool {
+	if b.tier(ctx, api) < tierNoRegeneration {
+		return true
+	}
+	metricBudget.Add("regeneration_refused", 1)
+	notifyUser(ctx, api, channel, user, ":money_with_wings: Rewrites and translations are paused while the bot is close to its budget.")
+	return false
+}
+
+func (b *costBudget) snapshot() map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollLocked()
+	return map[string]any{
+		"daily_budget":   b.daily,
+		"monthly_budget": b.monthly,
+		"spent_today":    b.spentDay,
+		"spent_month":    b.spentMonth,
+		"used":           b.usedLocked(),
+		"tier":           b.announced.String(),
+	}
+}
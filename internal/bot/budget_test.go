@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestCostBudget_Tiers(t *testing.T) {
+	defer func(ch string) { config.AdminChannel = ch }(config.AdminChannel)
+	config.AdminChannel = "CADMIN"
+	cheap := &ModelOption{Label: "small", Model: "llama-8b"}
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	budget := newCostBudget(func() time.Time { return now })
+	budget.configure(10, 0, 0, cheap, []string{"CPRIO"})
+	api := &fakeSlackClient{}
+	ctx := context.Background()
+
+	budget.charge(6.9)
+	if tier := budget.tier(ctx, api); tier != tierNormal || len(api.sent()) != 0 {
+		t.Fatalf("tier = %s, posts %d", tier, len(api.sent()))
+	}
+	budget.charge(0.2)
+	if budget.allowRegeneration(ctx, api, "C1", "U1") {
+		t.Error("regeneration should be refused from 70%")
+	}
+	posts := api.sent()
+	if len(posts) != 2 || posts[0].Channel != "CADMIN" || !strings.Contains(posts[0].Text(), "71%") {
+		t.Fatalf("expected an admin alert and a user notice, got %+v", posts)
+	}
+
+	budget.charge(1.5)
+	routed, ok := budget.admit(ctx, api, "C1", "U1")
+	if m, _ := modelFrom(routed); !ok || m.Model != "llama-8b" {
+		t.Errorf("questions should go to the cheap model from 85%%, got %+v", m)
+	}
+	before := len(api.sent())
+	budget.admit(ctx, api, "C1", "U1")
+	if len(api.sent()) != before {
+		t.Error("users should be told about a tier once")
+	}
+
+	budget.charge(1)
+	if _, ok := budget.admit(ctx, api, "C1", "U1"); ok {
+		t.Error("questions outside priority channels should be shed from 95%")
+	}
+	if _, ok := budget.admit(ctx, api, "CPRIO", "U1"); !ok {
+		t.Error("priority channels should still be answered")
+	}
+
+	// A new day starts the daily budget again.
+	now = now.Add(24 * time.Hour)
+	if tier := budget.tier(ctx, api); tier != tierNormal {
+		t.Errorf("tier after midnight = %s", tier)
+	}
+	if last := api.sent()[len(api.sent())-1]; last.Channel != "CADMIN" || !strings.Contains(last.Text(), "dropped to") {
+		t.Errorf("admins should hear about the recovery, got %q", last.Text())
+	}
+}
+
+func TestCostBudget_ChargesReportedCost(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	defer func(b *costBudget) { budget = b }(budget)
+	budget = newCostBudget(time.Now)
+	budget.configure(1, 0, 0, nil, nil)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(costHeader, "0.25")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "answer"})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	processTask(context.Background(), &fakeSlackClient{}, slackevents.AppMentionEvent{User: "U1", Channel: "CCOST"}, "q")
+	if got := budget.snapshot()["spent_today"]; got != 0.25 {
+		t.Errorf("spent_today = %v, want the reported 0.25", got)
+	}
+	if c := budget.cost("", 4000); c != defaultCostPer1KToken {
+		t.Errorf("estimated cost of 1000 tokens = %v", c)
+	}
+}
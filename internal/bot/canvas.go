@@ -0,0 +1,206 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Q&A Canvases
+//
+// Channels with "qa_canvas" set keep a Q&A log in their channel canvas: each
+// answer posted in the channel is appended as a section with the question,
+// who asked, the answer and a link to the thread. Answers held for review
+// are appended when a reviewer approves them, so rejected, withdrawn and
+// redacted answers never reach the canvas. The canvas is created with the
+// first entry if the channel has none; a channel that already has a canvas
+// gets the entries appended to it. Entries go through the outgoing filters
+// like any other message, and private DMs are never logged. After appending,
+// the entry's heading section is looked up and recorded on the conversation
+// record, so redacting the answer can replace the section with a redaction
+// notice, or delete it when the messages are deleted. Entries are not
+// changed when an answer is later edited, and entries whose section could
+// not be found are left in place. Creating and editing canvases needs the
+// canvases:write scope. Entries, redactions and failures are counted under
+// "qa_canvas" on /debug/vars.
+const (
+	canvasHeader   = "# Q&A log\nQuestions answered in this channel, newest last.\n\n"
+	canvasRedacted = "## Redacted answer\nThis answer was redacted.\n\n---\n"
+)
+
+type canvasLog struct {
+	mu       sync.Mutex
+	canvases map[string]string
+	channels map[string]*sync.Mutex
+}
+
+var (
+	qaCanvases     = &canvasLog{canvases: map[string]string{}, channels: map[string]*sync.Mutex{}}
+	metricQACanvas = expvar.NewMap("qa_canvas")
+
+	canvasLink    = regexp.MustCompile(`<(https?://[^|>]+)\|([^>]+)>`)
+	canvasURL     = regexp.MustCompile(`<(https?://[^|>]+)>`)
+	canvasMention = regexp.MustCompile(`<@([UW][A-Z0-9]+)>`)
+	canvasBold    = regexp.MustCompile(`(^|[\s(])\*([^*\n]+)\*`)
+)
+
+// lock serializes appends to one channel's canvas, so entries keep their
+// order and the canvas is created once.
+func (l *canvasLog) lock(channel string) func() {
+	l.mu.Lock()
+	m, ok := l.channels[channel]
+	if !ok {
+		m = &sync.Mutex{}
+		l.channels[channel] = m
+	}
+	l.mu.Unlock()
+	m.Lock()
+	return m.Unlock
+}
+
+func (l *canvasLog) cached(channel string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.canvases[channel]
+}
+
+func (l *canvasLog) remember(channel, id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if id == "" {
+		delete(l.canvases, channel)
+	} else {
+		l.canvases[channel] = id
+	}
+}
+
+// canvasMarkdown converts Slack mrkdwn to canvas markdown outside code.
+func canvasMarkdown(text string) string {
+	parts := strings.Split(text, "```")
+	for i := 0; i < len(parts); i += 2 {
+		p := canvasLink.ReplaceAllString(parts[i], "[$2]($1)")
+		p = canvasURL.ReplaceAllString(p, "$1")
+		p = canvasMention.ReplaceAllString(p, "![](@$1)")
+		parts[i] = canvasBold.ReplaceAllString(p, "$1**$2**")
+	}
+	return strings.Join(parts, "```")
+}
+
+// canvasEntry formats rec as one Q&A log section.
+func canvasEntry(rec *ConversationRecord, permalink string, at time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s\n", strings.Join(strings.Fields(truncate(rec.Query, 200)), " "))
+	fmt.Fprintf(&b, "Asked by ![](@%s) on %s", rec.User, at.UTC().Format("2006-01-02"))
+	if permalink != "" {
+		fmt.Fprintf(&b, " · [View thread](%s)", permalink)
+	}
+	fmt.Fprintf(&b, "\n\n%s\n\n---\n", canvasMarkdown(strings.TrimSpace(rec.AnswerText())))
+	return b.String()
+}
+
+// appendToQACanvas adds rec to its channel's Q&A canvas when the channel
+// has one configured.
+func appendToQACanvas(ctx context.Context, api SlackClient, rec *ConversationRecord) {
+	if !channelConfigFor(rec.Channel).QACanvas || isSelfTest(ctx) || isPrivateDM(ctx) || len(rec.Answer) == 0 ||
+		len(rec.MessageTS) == 0 || rec.Withdrawn || rec.Redacted {
+		return
+	}
+	ctx, span := otel.Tracer("bot").Start(ctx, "qa_canvas_append")
+	defer span.End()
+	span.SetAttributes(attribute.String("channel.id", rec.Channel))
+
+	permalink, _ := api.GetPermalinkContext(ctx, &slack.PermalinkParameters{Channel: rec.Channel, Ts: rec.MessageTS[0]})
+	entry := filterText(ctx, rec.Channel, rec.User, canvasEntry(rec, permalink, time.Now()))
+	fail := func(what string, err error) {
+		span.RecordError(err)
+		metricQACanvas.Add("errors", 1)
+		slog.ErrorContext(ctx, "Q&A canvas request failed", "action", what, "channel", rec.Channel, "err", err)
+	}
+
+	defer qaCanvases.lock(rec.Channel)()
+	id := qaCanvases.cached(rec.Channel)
+	if id == "" {
+		ch, err := api.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{ChannelID: rec.Channel})
+		if err != nil {
+			fail("find", err)
+			return
+		}
+		if ch.Properties != nil {
+			id = ch.Properties.Canvas.FileId
+		}
+	}
+	if id == "" {
+		created, err := api.CreateChannelCanvasContext(ctx, rec.Channel, slack.DocumentContent{Type: "markdown", Markdown: canvasHeader + entry})
+		if err != nil {
+			fail("create", err)
+			return
+		}
+		qaCanvases.remember(rec.Channel, created)
+		metricQACanvas.Add("created", 1)
+		metricQACanvas.Add("entries", 1)
+		recordCanvasSection(ctx, api, rec, created, entry)
+		return
+	}
+	qaCanvases.remember(rec.Channel, id)
+	err := api.EditCanvasContext(ctx, slack.EditCanvasParams{CanvasID: id, Changes: []slack.CanvasChange{{
+		Operation:       "insert_at_end",
+		DocumentContent: slack.DocumentContent{Type: "markdown", Markdown: entry},
+	}}})
+	if err != nil {
+		// The canvas may have been deleted; look it up again next time.
+		qaCanvases.remember(rec.Channel, "")
+		fail("update", err)
+		return
+	}
+	metricQACanvas.Add("entries", 1)
+	recordCanvasSection(ctx, api, rec, id, entry)
+}
+
+// recordCanvasSection finds the section of the entry just appended to
+// canvas and records it on rec's conversation. Entries are appended under
+// the channel's lock, so the last section with the entry's heading is it.
+func recordCanvasSection(ctx context.Context, api SlackClient, rec *ConversationRecord, canvas, entry string) {
+	heading, _, _ := strings.Cut(strings.TrimPrefix(entry, "## "), "\n")
+	sections, err := api.LookupCanvasSectionsContext(ctx, slack.LookupCanvasSectionsParams{
+		CanvasID: canvas,
+		Criteria: slack.LookupCanvasSectionsCriteria{SectionTypes: []string{"h2"}, ContainsText: heading},
+	})
+	if err == nil && len(sections) == 0 {
+		err = errors.New("entry heading not found")
+	}
+	if err != nil {
+		metricQACanvas.Add("errors", 1)
+		slog.ErrorContext(ctx, "Failed to find the Q&A canvas entry", "channel", rec.Channel, "conversation", rec.ID, "err", err)
+		return
+	}
+	section := sections[len(sections)-1].ID
+	conversations.Update(rec.ID, func(r *ConversationRecord) { r.CanvasID, r.CanvasSection = canvas, section })
+}
+
+// redactQACanvasEntry replaces rec's Q&A canvas entry with a redaction
+// notice, or deletes it for RedactDelete.
+func redactQACanvasEntry(ctx context.Context, api SlackClient, rec *ConversationRecord, mode string) error {
+	if rec.CanvasSection == "" {
+		return nil
+	}
+	change := slack.CanvasChange{Operation: "replace", SectionID: rec.CanvasSection, DocumentContent: slack.DocumentContent{Type: "markdown", Markdown: canvasRedacted}}
+	if mode == RedactDelete {
+		change = slack.CanvasChange{Operation: "delete", SectionID: rec.CanvasSection}
+	}
+	if err := api.EditCanvasContext(ctx, slack.EditCanvasParams{CanvasID: rec.CanvasID, Changes: []slack.CanvasChange{change}}); err != nil {
+		metricQACanvas.Add("errors", 1)
+		return fmt.Errorf("Q&A canvas: %w", err)
+	}
+	metricQACanvas.Add("redacted", 1)
+	return nil
+}
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCanvasMarkdown(t *testing.T) {
+	in := "See *the runbook* at <https://wiki.example.com/rb|the wiki>, ask <@U123>.\n```\n*not bold* <https://x>\n```\nor <https://example.com>"
+	want := "See **the runbook** at [the wiki](https://wiki.example.com/rb), ask ![](@U123).\n```\n*not bold* <https://x>\n```\nor https://example.com"
+	if got := canvasMarkdown(in); got != want {
+		t.Errorf("canvasMarkdown =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAppendToQACanvas(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CQA": {QACanvas: true}}})
+	defer setChannelSettings(channelSettings{})
+	original := qaCanvases
+	qaCanvases = &canvasLog{canvases: map[string]string{}, channels: map[string]*sync.Mutex{}}
+	defer func() { qaCanvases = original }()
+
+	api := &fakeSlackClient{}
+	rec := &ConversationRecord{Channel: "CQA", User: "U1", Query: "How do I\nrotate keys?", Answer: []string{"Use *vault*."}, MessageTS: []string{"5.000100"}}
+	appendToQACanvas(context.Background(), api, rec)
+	appendToQACanvas(context.Background(), api, &ConversationRecord{Channel: "CQA", User: "U2", Query: "Second?", Answer: []string{"Yes."}, MessageTS: []string{"6.000100"}})
+	appendToQACanvas(context.Background(), api, &ConversationRecord{Channel: "COTHER", User: "U2", Query: "q", Answer: []string{"a"}, MessageTS: []string{"7.000100"}})
+	appendToQACanvas(withPrivateDM(context.Background()), api, &ConversationRecord{Channel: "CQA", User: "U3", Query: "secret", Answer: []string{"a"}, MessageTS: []string{"8.000100"}})
+	// Held for review or rejected, withdrawn and redacted answers.
+	appendToQACanvas(context.Background(), api, &ConversationRecord{Channel: "CQA", User: "U4", Query: "held", Answer: []string{"a"}})
+	appendToQACanvas(context.Background(), api, &ConversationRecord{Channel: "CQA", User: "U4", Query: "withdrawn", Answer: []string{"a"}, MessageTS: []string{"9.000100"}, Withdrawn: true})
+	appendToQACanvas(context.Background(), api, &ConversationRecord{Channel: "CQA", User: "U4", Query: "redacted", Answer: []string{"a"}, MessageTS: []string{"9.000200"}, Redacted: true})
+
+	entries := api.canvases["FCQA"]
+	if len(api.canvases) != 1 || len(entries) != 2 {
+		t.Fatalf("canvases = %v", api.canvases)
+	}
+	first := entries[0]
+	for _, want := range []string{canvasHeader, "## How do I rotate keys?", "Asked by ![](@U1)", "[View thread](https://example.slack.com/archives/CQA/p5000100)", "Use **vault**."} {
+		if !strings.Contains(first, want) {
+			t.Errorf("first entry is missing %q:\n%s", want, first)
+		}
+	}
+	if strings.Contains(entries[1], canvasHeader) || !strings.HasPrefix(entries[1], "## Second?") {
+		t.Errorf("second entry = %q", entries[1])
+	}
+}
+
+func TestReviewApproval_AppendsToQACanvas(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CQA": {QACanvas: true, ReviewMode: true, Reviewers: []string{"UREV"}}}})
+	defer setChannelSettings(channelSettings{})
+	defer func(old *canvasLog) { qaCanvases = old }(qaCanvases)
+	qaCanvases = &canvasLog{canvases: map[string]string{}, channels: map[string]*sync.Mutex{}}
+	defer func(old *ConversationStore) { conversations = old }(conversations)
+	conversations = NewConversationStore()
+
+	ctx := context.Background()
+	api := &fakeSlackClient{}
+	held := &ConversationRecord{ID: "c1", Channel: "CQA", User: "U1", Query: "Rotate keys?", Answer: []string{"Use vault."}}
+	conversations.Save(held)
+	appendToQACanvas(ctx, api, held)
+	if len(api.canvases) != 0 {
+		t.Fatalf("held answer was logged: %v", api.canvases)
+	}
+
+	rejected := &pendingReview{ID: newID(), Channel: "CQA", User: "U1", Query: "Rotate keys?", Chunks: []string{"Use vault."}, Conversation: "c1"}
+	reviews.add(rejected)
+	handleInteraction(ctx, api, reviewCallback("UREV", ActionReviewReject, rejected.ID))
+	if len(api.canvases) != 0 {
+		t.Fatalf("rejected answer was logged: %v", api.canvases)
+	}
+
+	approved := &pendingReview{ID: newID(), Channel: "CQA", User: "U1", Query: "Rotate keys?", Chunks: []string{"Use vault."}, Conversation: "c1"}
+	reviews.add(approved)
+	handleInteraction(ctx, api, reviewCallback("UREV", ActionReviewApprove, approved.ID))
+	if entries := api.canvases["FCQA"]; len(entries) != 1 || !strings.Contains(entries[0], "Use vault.") {
+		t.Errorf("expected the approved answer logged, got %v", api.canvases)
+	}
+	if rec, _ := conversations.Get("c1"); len(rec.MessageTS) != 1 {
+		t.Errorf("published message not recorded: %v", rec.MessageTS)
+	}
+}
+
+func TestCanvasEntry_Date(t *testing.T) {
+	got := canvasEntry(&ConversationRecord{User: "U1", Query: "q", Answer: []string{"a"}}, "", time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC))
+	if !strings.Contains(got, "on 2024-03-01\n") || strings.Contains(got, "View thread") {
+		t.Errorf("canvasEntry = %q", got)
+	}
+}
+
+func TestRedactAnswer_UpdatesQACanvasEntry(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CQA": {QACanvas: true}}})
+	defer setChannelSettings(channelSettings{})
+	defer func(old *canvasLog) { qaCanvases = old }(qaCanvases)
+	qaCanvases = &canvasLog{canvases: map[string]string{}, channels: map[string]*sync.Mutex{}}
+	defer func(old *ConversationStore) { conversations = old }(conversations)
+	conversations = NewConversationStore()
+
+	ctx := context.Background()
+	api := &fakeSlackClient{}
+	for i, answer := range []string{"Use vault.", "The token is hunter2.", "Ask ops."} {
+		rec := &ConversationRecord{ID: fmt.Sprintf("c%d", i), Channel: "CQA", User: "U1", Query: "Rotate keys?", Answer: []string{answer}, MessageTS: []string{fmt.Sprintf("%d.000100", i+1)}}
+		conversations.Save(rec)
+		appendToQACanvas(ctx, api, rec)
+	}
+	leaked, _ := conversations.Get("c1")
+	if leaked.CanvasID != "FCQA" || leaked.CanvasSection != "FCQA:1" {
+		t.Fatalf("canvas section not recorded: %q %q", leaked.CanvasID, leaked.CanvasSection)
+	}
+
+	if err := redactAnswer(ctx, api, "CQA", "2.000100", &leaked, "UADMIN", RedactReplace, ""); err != nil {
+		t.Fatal(err)
+	}
+	entries := api.canvases["FCQA"]
+	if entries[1] != canvasRedacted || !strings.Contains(entries[0], "Use vault.") || !strings.Contains(entries[2], "Ask ops.") {
+		t.Errorf("expected only the redacted entry replaced, got %q", entries)
+	}
+
+	deleted, _ := conversations.Get("c2")
+	if err := redactAnswer(ctx, api, "CQA", "3.000100", &deleted, "UADMIN", RedactDelete, ""); err != nil {
+		t.Fatal(err)
+	}
+	if entries := api.canvases["FCQA"]; entries[2] != "" {
+		t.Errorf("expected the entry deleted, got %q", entries[2])
+	}
+}
@@ -0,0 +1,310 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+)
+
+// Channel Configuration
+//
+// Per-channel behaviour is loaded from the JSON file named by CHANNEL_CONFIG.
+// An entry under "channels" replaces "default" entirely for that channel.
+type ChannelConfig struct {
+	ReviewMode    bool     `json:"review_mode,omitempty"`
+	ReviewChannel string   `json:"review_channel,omitempty"`
+	Reviewers     []string `json:"reviewers,omitempty"`
+
+	DisableInternalRetrieval bool   `json:"disable_internal_retrieval,omitempty"`
+	Disclaimer               string `json:"disclaimer,omitempty"`
+
+	// DisableGlossary turns off glossary context and links; see glossary.go.
+	DisableGlossary bool `json:"disable_glossary,omitempty"`
+
+	Generation backend.GenerationParams `json:"generation,omitempty"`
+
+	// Locale ("de-DE") and Timezone ("Europe/Berlin") format dates and
+	// numbers in scheduled messages to the channel; see localefmt.go.
+	Locale   string `json:"locale,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+
+	// ConvertUnits adds metric/imperial equivalents for the asker's locale.
+	ConvertUnits bool `json:"convert_units,omitempty"`
+
+	// EmojiPolicy is "any" (default), "custom" or "none"; see emoji.go.
+	EmojiPolicy string `json:"emoji_policy,omitempty"`
+
+	// ReplyInThread answers top-level mentions in a thread under the
+	// question; see replythread.go.
+	ReplyInThread bool `json:"reply_in_thread,omitempty"`
+
+	// SerializeThreads answers questions in one thread in order; see serialize.go.
+	SerializeThreads bool `json:"serialize_threads,omitempty"`
+
+	// NumberParts prefixes answer chunks with "(n/…)" and posts a done
+	// message; see presentation.go.
+	NumberParts bool `json:"number_parts,omitempty"`
+
+	// LiveEdit streams each answer into one message edited as chunks
+	// arrive; see liveedit.go.
+	LiveEdit bool `json:"live_edit,omitempty"`
+
+	// Format is "text" (default) or "blocks" to lay answers out in Block
+	// Kit; see answerblocks.go.
+	Format string `json:"format,omitempty"`
+
+	// ThreadSummary keeps a pinned rolling summary in long threads; see
+	// threadsummary.go.
+	ThreadSummary ThreadSummaryConfig `json:"thread_summary,omitempty"`
+
+	// QACanvas appends finished answers to the channel canvas; see canvas.go.
+	QACanvas bool `json:"qa_canvas,omitempty"`
+
+	// SmallTalk answers greetings and thanks without the backend; see smalltalk.go.
+	SmallTalk SmallTalkConfig `json:"small_talk,omitempty"`
+
+	// Drafts offers the asker 2 or 3 candidate answers to publish; see drafts.go.
+	Drafts int `json:"drafts,omitempty"`
+
+	// KnowledgeGaps names who hears about questions the bot couldn't
+	// answer; see knowledgegap.go.
+	KnowledgeGaps KnowledgeGapConfig `json:"knowledge_gaps,omitempty"`
+
+	// ResetButton adds a "Start over" button under answers; see reset.go.
+	ResetButton bool `json:"reset_button,omitempty"`
+
+	// External is set at runtime for Slack Connect channels.
+	External bool `json:"-"`
+}
+
+type channelSettings struct {
+	Default  ChannelConfig            `json:"default"`
+	Channels map[string]ChannelConfig `json:"channels"`
+	External ExternalPolicy           `json:"external"`
+}
+
+var (
+	channelMu      sync.RWMutex
+	channelConfigs channelSettings
+)
+
+func loadChannelConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings channelSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	if err := validateChannelSettings(settings); err != nil {
+		return err
+	}
+	setChannelSettings(settings)
+	return nil
+}
+
+func validateChannelSettings(settings channelSettings) error {
+	if err := settings.Default.Generation.Validate(defaultParamRanges); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	if err := validateEmojiPolicy(settings.Default.EmojiPolicy); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	if err := validateDrafts(settings.Default.Drafts); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	if err := validateTimezone(settings.Default.Timezone); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	if err := validateAnswerFormat(settings.Default.Format); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	if err := settings.External.validate(); err != nil {
+		return fmt.Errorf("external: %w", err)
+	}
+	for id, cc := range settings.Channels {
+		if err := cc.Generation.Validate(defaultParamRanges); err != nil {
+			return fmt.Errorf("channel %s: %w", id, err)
+		}
+		if err := validateEmojiPolicy(cc.EmojiPolicy); err != nil {
+			return fmt.Errorf("channel %s: %w", id, err)
+		}
+		if err := validateDrafts(cc.Drafts); err != nil {
+			return fmt.Errorf("channel %s: %w", id, err)
+		}
+		if err := validateTimezone(cc.Timezone); err != nil {
+			return fmt.Errorf("channel %s: %w", id, err)
+		}
+		if err := validateAnswerFormat(cc.Format); err != nil {
+			return fmt.Errorf("channel %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func setChannelSettings(settings channelSettings) {
+	channelMu.Lock()
+	defer channelMu.Unlock()
+	channelConfigs = settings
+}
+
+func currentChannelSettings() channelSettings {
+	channelMu.RLock()
+	defer channelMu.RUnlock()
+	return channelConfigs
+}
+
+func channelConfigFor(channelID string) ChannelConfig {
+	channelMu.RLock()
+	defer channelMu.RUnlock()
+	if cc, ok := channelConfigs.Channels[channelID]; ok {
+		return cc
+	}
+	return channelConfigs.Default
+}
+
+func (cc ChannelConfig) isReviewer(userID string) bool {
+	for _, id := range cc.Reviewers {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Externally shared channels (Slack Connect) get the "external" policy block
+// layered on top of their own configuration. RequireReview needs Reviewers,
+// who review answers in shared channels that have no reviewers of their own.
+type ExternalPolicy struct {
+	DisableInternalRetrieval bool     `json:"disable_internal_retrieval,omitempty"`
+	RequireReview            bool     `json:"require_review,omitempty"`
+	ReviewChannel            string   `json:"review_channel,omitempty"`
+	Reviewers                []string `json:"reviewers,omitempty"`
+	Disclaimer               string   `json:"disclaimer,omitempty"`
+	EmojiPolicy              string   `json:"emoji_policy,omitempty"`
+}
+
+// empty reports whether the policy changes nothing; its reviewers only
+// matter with RequireReview.
+func (p ExternalPolicy) empty() bool {
+	return !p.DisableInternalRetrieval && !p.RequireReview && p.Disclaimer == "" && p.EmojiPolicy == ""
+}
+
+func (p ExternalPolicy) validate() error {
+	if p.RequireReview && len(p.Reviewers) == 0 {
+		return errors.New(`require_review needs "reviewers" to approve answers`)
+	}
+	return validateEmojiPolicy(p.EmojiPolicy)
+}
+
+// Channel details from conversations.info are cached for channelInfoTTL
+// and shared by the external check and the channel context below.
+const channelInfoTTL = 10 * time.Minute
+
+type channelInfo struct {
+	shared  bool
+	private bool
+	name    string
+	topic   string
+	purpose string
+	fetched time.Time
+}
+
+var channelInfoCache sync.Map
+
+func lookupChannelInfo(ctx context.Context, api SlackClient, channelID string) (channelInfo, bool) {
+	if v, ok := channelInfoCache.Load(channelID); ok {
+		if info := v.(channelInfo); time.Since(info.fetched) < channelInfoTTL {
+			return info, true
+		}
+	}
+	ch, err := api.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{ChannelID: channelID})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up channel", "channel", channelID, "err", err)
+		return channelInfo{}, false
+	}
+	info := channelInfo{
+		shared:  ch.IsExtShared || ch.IsPendingExtShared,
+		private: ch.IsPrivate || ch.IsIM || ch.IsMpIM,
+		name:    ch.Name,
+		topic:   strings.TrimSpace(ch.Topic.Value),
+		purpose: strings.TrimSpace(ch.Purpose.Value),
+		fetched: time.Now(),
+	}
+	channelInfoCache.Store(channelID, info)
+	return info, true
+}
+
+// isExternallyShared fails closed: a channel whose details could not be
+// looked up is treated as shared until a later lookup succeeds.
+func isExternallyShared(ctx context.Context, api SlackClient, channelID string) bool {
+	info, ok := lookupChannelInfo(ctx, api, channelID)
+	return !ok || info.shared
+}
+
+// isChannelMember reports whether user is in channelID. A failed lookup
+// counts as not a member.
+func isChannelMember(ctx context.Context, api SlackClient, channelID, user string) bool {
+	params := &slack.GetUsersInConversationParameters{ChannelID: channelID, Limit: 1000}
+	for {
+		members, cursor, err := api.GetUsersInConversationContext(ctx, params)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list channel members", "channel", channelID, "err", err)
+			return false
+		}
+		for _, m := range members {
+			if m == user {
+				return true
+			}
+		}
+		if cursor == "" {
+			return false
+		}
+		params.Cursor = cursor
+	}
+}
+
+// channelContextFor returns nil for DMs and channels with neither a topic
+// nor a purpose.
+func channelContextFor(ctx context.Context, api SlackClient, channelID string) *backend.ChannelContext {
+	info, ok := lookupChannelInfo(ctx, api, channelID)
+	if !ok || (info.topic == "" && info.purpose == "") {
+		return nil
+	}
+	return &backend.ChannelContext{Name: info.name, Topic: info.topic, Purpose: info.purpose}
+}
+
+// effectiveChannelConfig resolves the configuration for a channel, applying
+// the external policy when the channel is shared with other organizations.
+func effectiveChannelConfig(ctx context.Context, api SlackClient, channelID string) ChannelConfig {
+	cc := channelConfigFor(channelID)
+	channelMu.RLock()
+	policy := channelConfigs.External
+	channelMu.RUnlock()
+	if policy.empty() || !isExternallyShared(ctx, api, channelID) {
+		return cc
+	}
+	cc.External = true
+	cc.DisableInternalRetrieval = cc.DisableInternalRetrieval || policy.DisableInternalRetrieval
+	cc.ReviewMode = cc.ReviewMode || policy.RequireReview
+	if policy.RequireReview && len(cc.Reviewers) == 0 {
+		cc.ReviewChannel, cc.Reviewers = policy.ReviewChannel, policy.Reviewers
+	}
+	if policy.Disclaimer != "" {
+		cc.Disclaimer = policy.Disclaimer
+	}
+	if policy.EmojiPolicy != "" {
+		cc.EmojiPolicy = policy.EmojiPolicy
+	}
+	return cc
+}
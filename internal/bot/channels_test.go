@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestLoadChannelConfig_ChannelOverridesDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "channels.json")
+	os.WriteFile(path, []byte(`{
+		"default": {"review_mode": true},
+		"channels": {"C1": {"reviewers": ["U1"]}}
+	}`), 0o600)
+	if err := loadChannelConfig(path); err != nil {
+		t.Fatalf("loadChannelConfig: %v", err)
+	}
+	defer setChannelSettings(channelSettings{})
+
+	if !channelConfigFor("C2").ReviewMode {
+		t.Error("expected default config for unlisted channel")
+	}
+	if cc := channelConfigFor("C1"); cc.ReviewMode || !cc.isReviewer("U1") {
+		t.Errorf("expected channel entry to replace default, got %+v", cc)
+	}
+}
+
+func TestProcessTask_ExternalChannelPolicy(t *testing.T) {
+	setChannelSettings(channelSettings{External: ExternalPolicy{
+		DisableInternalRetrieval: true,
+		Disclaimer:               "Shared with external organizations.",
+	}})
+	defer setChannelSettings(channelSettings{})
+
+	var got backend.ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Answer."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{external: map[string]bool{"CEXT": true}}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CEXT"}, "foo")
+
+	if !got.DisableInternalRetrieval {
+		t.Error("expected internal retrieval to be disabled for shared channel")
+	}
+	posts := api.sent()
+	if len(posts) != 2 || posts[1].Text() != "Shared with external organizations." {
+		t.Errorf("expected answer followed by disclaimer, got %+v", posts)
+	}
+
+	got = backend.ChatRequest{}
+	api = &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CINT"}, "foo")
+	if got.DisableInternalRetrieval || len(api.sent()) != 1 {
+		t.Errorf("internal channel should be unaffected, request %+v posts %d", got, len(api.sent()))
+	}
+}
+
+func TestLoadChannelConfig_RequireReviewNeedsReviewers(t *testing.T) {
+	defer setChannelSettings(channelSettings{})
+	path := filepath.Join(t.TempDir(), "channels.json")
+	os.WriteFile(path, []byte(`{"external": {"require_review": true, "review_channel": "CREVIEW"}}`), 0o600)
+	if err := loadChannelConfig(path); err == nil {
+		t.Error("require_review without reviewers should be rejected")
+	}
+}
+
+func TestProcessTask_ExternalReviewUsesPolicyReviewers(t *testing.T) {
+	setChannelSettings(channelSettings{External: ExternalPolicy{RequireReview: true, ReviewChannel: "CREVIEW", Reviewers: []string{"UREV"}}})
+	defer setChannelSettings(channelSettings{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Internal answer."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{external: map[string]bool{"CEXTREV": true}}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CEXTREV"}, "foo")
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Channel != "CREVIEW" {
+		t.Fatalf("expected the answer held in the policy's review channel, got %+v", posts)
+	}
+
+	var pending *pendingReview
+	reviews.mu.Lock()
+	for _, r := range reviews.pending {
+		if r.Channel == "CEXTREV" {
+			pending = r
+		}
+	}
+	reviews.mu.Unlock()
+	if pending == nil {
+		t.Fatal("no pending review")
+	}
+	handleInteraction(context.Background(), api, reviewCallback("UREV", ActionReviewApprove, pending.ID))
+	var published bool
+	for _, p := range api.sent() {
+		published = published || (p.Channel == "CEXTREV" && p.Text() == "Internal answer.")
+	}
+	if !published {
+		t.Errorf("the policy's reviewer should be able to approve, got %+v", api.sent())
+	}
+}
+
+func TestEffectiveChannelConfig_FailedLookupIsExternal(t *testing.T) {
+	setChannelSettings(channelSettings{External: ExternalPolicy{DisableInternalRetrieval: true}})
+	defer setChannelSettings(channelSettings{})
+
+	api := &fakeSlackClient{infoErr: errors.New("ratelimited")}
+	if cc := effectiveChannelConfig(context.Background(), api, "CFAIL"); !cc.External || !cc.DisableInternalRetrieval {
+		t.Errorf("a failed lookup should apply the external policy, got %+v", cc)
+	}
+	api.infoErr = nil
+	if cc := effectiveChannelConfig(context.Background(), api, "CFAIL"); cc.External {
+		t.Errorf("failed lookup was cached, got %+v", cc)
+	}
+}
+
+func TestProcessTask_SendsChannelContext(t *testing.T) {
+	var got []backend.ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		got = append(got, req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Answer."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+	defer channelInfoCache.Delete("CPAYONCALL")
+	defer channelInfoCache.Delete("CPLAIN")
+
+	api := &fakeSlackClient{topics: map[string]string{
+		"CPAYONCALL":         "Payments incidents: runbooks in #payments-docs",
+		"CPAYONCALL/purpose": "On-call for the card payments platform",
+	}}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CPAYONCALL"}, "why are refunds failing?")
+	// The topic is cached, so a change is picked up only after channelInfoTTL.
+	api.topics["CPAYONCALL"] = "changed"
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CPAYONCALL"}, "and now?")
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CPLAIN"}, "hello?")
+
+	if len(got) != 3 {
+		t.Fatalf("backend received %d requests", len(got))
+	}
+	for _, req := range got[:2] {
+		if c := req.Channel; c == nil || c.Name != "cpayoncall" || c.Topic != "Payments incidents: runbooks in #payments-docs" || c.Purpose != "On-call for the card payments platform" {
+			t.Errorf("channel context = %+v", c)
+		}
+	}
+	if got[2].Channel != nil {
+		t.Errorf("channel without topic or purpose should send no context, got %+v", got[2].Channel)
+	}
+}
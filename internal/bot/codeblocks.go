@@ -0,0 +1,231 @@
+package bot
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+)
+
+// Code Blocks
+//
+// Code pasted into a question in ``` fences is sent to the backend as
+// structured code_blocks as well as in the query: each block's code with
+// Slack's &lt; &gt; &amp; escapes undone, the byte offsets of its fences in
+// the query, and its language. The language is the fence's tag when the
+// asker wrote one (```python), and otherwise a guess from keywords and
+// syntax; a block too short or ambiguous to tell gets none. Code blocks in
+// the answer that have no tag of their own are then tagged with the
+// question's language, so the answer's code is labelled the way the
+// question's was; ANSWER_CODE_TAGS=off leaves answers as they are. Blocks
+// are counted by language under "code_blocks" on /debug/vars, along with
+// the answer fences tagged.
+var metricCodeBlocks = expvar.NewMap("code_blocks")
+
+var answerCodeTags = true
+
+func configureCodeBlocks() error {
+	switch v := strings.ToLower(os.Getenv("ANSWER_CODE_TAGS")); v {
+	case "", "on":
+		answerCodeTags = true
+	case "off":
+		answerCodeTags = false
+	default:
+		return fmt.Errorf("invalid ANSWER_CODE_TAGS %q (use on or off)", v)
+	}
+	return nil
+}
+
+const codeFence = "```"
+
+// codeLanguageAliases maps fence tags to the language names sent to the
+// backend.
+var codeLanguageAliases = map[string]string{
+	"go": "go", "golang": "go",
+	"py": "python", "python": "python", "python3": "python",
+	"js": "javascript", "javascript": "javascript", "jsx": "javascript", "node": "javascript",
+	"ts": "typescript", "typescript": "typescript", "tsx": "typescript",
+	"java": "java", "kotlin": "kotlin", "kt": "kotlin", "scala": "scala",
+	"c": "c", "h": "c", "cpp": "cpp", "c++": "cpp", "cc": "cpp", "cs": "csharp", "csharp": "csharp",
+	"rust": "rust", "rs": "rust", "rb": "ruby", "ruby": "ruby", "php": "php", "swift": "swift",
+	"sh": "bash", "bash": "bash", "shell": "bash", "zsh": "bash", "console": "bash",
+	"sql": "sql", "json": "json", "yaml": "yaml", "yml": "yaml", "toml": "toml",
+	"html": "html", "xml": "xml", "css": "css", "dockerfile": "dockerfile", "docker": "dockerfile",
+	"diff": "diff", "patch": "diff", "hcl": "hcl", "terraform": "hcl", "tf": "hcl", "text": "text",
+}
+
+// codeLanguageHints score a language for each pattern a block matches.
+var codeLanguageHints = []struct {
+	language string
+	pattern  *regexp.Regexp
+	weight   int
+}{
+	{"go", regexp.MustCompile(`(?m)^package \w+$`), 3},
+	{"go", regexp.MustCompile(`\bfunc (\(\w+ \*?\w+\) )?\w+\(`), 3},
+	{"go", regexp.MustCompile(`\w+ := `), 1},
+	{"go", regexp.MustCompile(`\b(fmt|errors|strings|http)\.[A-Z]\w*\(`), 2},
+	{"go", regexp.MustCompile(`\bif err != nil\b`), 3},
+	{"python", regexp.MustCompile(`(?m)^\s*def \w+\(.*\):\s*$`), 3},
+	{"python", regexp.MustCompile(`(?m)^\s*(from [\w.]+ )?import [\w.]+( as \w+)?$`), 1},
+	{"python", regexp.MustCompile(`\bself\.\w+`), 2},
+	{"python", regexp.MustCompile(`(?m)^\s*(elif|except|with) .*:\s*$|\bprint\(`), 2},
+	{"python", regexp.MustCompile(`(?m)^Traceback \(most recent call last\):`), 4},
+	{"javascript", regexp.MustCompile(`\b(const|let) \w+ = `), 2},
+	{"javascript", regexp.MustCompile(`=> \{?|\bconsole\.log\(|\brequire\(['"]`), 2},
+	{"javascript", regexp.MustCompile(`\bfunction \w*\(`), 2},
+	{"typescript", regexp.MustCompile(`(?m)^\s*(export )?(interface|type) \w+ (=|\{)`), 3},
+	{"typescript", regexp.MustCompile(`\w+\??: (string|number|boolean|any)\b`), 3},
+	{"java", regexp.MustCompile(`\b(public|private|protected) (static )?(final )?(class|void|int|String)\b`), 3},
+	{"java", regexp.MustCompile(`\bSystem\.out\.print|\bimport java\.`), 4},
+	{"c", regexp.MustCompile(`(?m)^#include <\w+\.h>`), 3},
+	{"c", regexp.MustCompile(`\b(printf|malloc|free)\(`), 1},
+	{"cpp", regexp.MustCompile(`(?m)^#include <\w+>$|\bstd::|\bcout <<`), 4},
+	{"rust", regexp.MustCompile(`\bfn \w+\(|\blet mut \w+|\bimpl \w+|\w+!\(`), 3},
+	{"ruby", regexp.MustCompile(`(?m)^\s*def \w+[^:(]*$|^\s*end$|\bputs |\.each do\b`), 2},
+	{"php", regexp.MustCompile(`<\?php|\$\w+->\w+`), 4},
+	{"bash", regexp.MustCompile(`(?m)^#!/(usr/)?bin/(env )?(ba|z)?sh|^\s*(\$ )?(sudo|apt-get|apt|brew|kubectl|docker|curl|export|echo|cd|ls|git|npm|pip) `), 2},
+	{"sql", regexp.MustCompile(`(?i)\b(select .+ from|insert into|update \w+ set|create table|delete from)\b`), 4},
+	{"html", regexp.MustCompile(`(?i)</?(html|head|body|div|span|p|a|script)\b[^>]*>`), 3},
+	{"xml", regexp.MustCompile(`^<\?xml `), 5},
+	{"css", regexp.MustCompile(`(?m)^\s*[.#]?[\w-]+\s*\{\s*$|^\s*[\w-]+: [^;]+;\s*$`), 2},
+	{"dockerfile", regexp.MustCompile(`(?m)^(FROM|RUN|COPY|ENTRYPOINT|CMD|WORKDIR) `), 3},
+	{"yaml", regexp.MustCompile(`(?m)^[\w-]+:( [^{}]*)?$|^\s*- \w+`), 1},
+	{"diff", regexp.MustCompile(`(?m)^(@@ .* @@|--- a/|\+\+\+ b/)`), 5},
+}
+
+// minCodeLanguageScore is the score a guess needs; lower scores, like one
+// "key: value" line, are too weak to tag a block.
+const minCodeLanguageScore = 3
+
+// guessCodeLanguage names code's most likely language, or returns "" when
+// no language scores well enough or two tie.
+func guessCodeLanguage(code string) string {
+	trimmed := strings.TrimSpace(code)
+	if trimmed == "" {
+		return ""
+	}
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	scores := map[string]int{}
+	for _, h := range codeLanguageHints {
+		scores[h.language] += h.weight * min(len(h.pattern.FindAllStringIndex(trimmed, -1)), 3)
+	}
+	// TypeScript is a superset of JavaScript and C++ of C.
+	if scores["typescript"] > 0 {
+		scores["typescript"] += scores["javascript"]
+	}
+	if scores["cpp"] > 0 {
+		scores["cpp"] += scores["c"]
+	}
+	best, bestScore, tied := "", 0, false
+	for language, score := range scores {
+		switch {
+		case score > bestScore:
+			best, bestScore, tied = language, score, false
+		case score == bestScore && score > 0:
+			tied = true
+		}
+	}
+	if tied || bestScore < minCodeLanguageScore {
+		return ""
+	}
+	return best
+}
+
+// extractCodeBlocks returns the fenced code blocks in query, which is Slack
+// text. An unclosed fence runs to the end of the query.
+func extractCodeBlocks(query string) []backend.CodeBlock {
+	var blocks []backend.CodeBlock
+	for offset := 0; ; {
+		start := strings.Index(query[offset:], codeFence)
+		if start < 0 {
+			return blocks
+		}
+		start += offset
+		bodyStart := start + len(codeFence)
+		end, next := len(query), len(query)
+		if i := strings.Index(query[bodyStart:], codeFence); i >= 0 {
+			end, next = bodyStart+i, bodyStart+i+len(codeFence)
+		}
+		block := backend.CodeBlock{Start: start, End: next}
+		body := query[bodyStart:end]
+		if header, rest, ok := strings.Cut(body, "\n"); ok {
+			if language, known := codeLanguageAliases[strings.ToLower(strings.TrimSpace(header))]; known {
+				block.Language, block.Tagged, body = language, true, rest
+			}
+		}
+		block.Code = strings.Trim(slackTextUnescaper.Replace(body), "\n")
+		if block.Code != "" {
+			if !block.Tagged {
+				block.Language = guessCodeLanguage(block.Code)
+			}
+			blocks = append(blocks, block)
+		}
+		offset = next
+		if offset >= len(query) {
+			return blocks
+		}
+	}
+}
+
+// questionCodeLanguage is the language answer code blocks are tagged with:
+// that of the question's blocks when they all agree, and otherwise none.
+func questionCodeLanguage(blocks []backend.CodeBlock) string {
+	language := ""
+	for _, b := range blocks {
+		switch {
+		case b.Language == "":
+		case language == "":
+			language = b.Language
+		case language != b.Language:
+			return ""
+		}
+	}
+	return language
+}
+
+// recordCodeBlocks counts blocks by language.
+func recordCodeBlocks(blocks []backend.CodeBlock) {
+	for _, b := range blocks {
+		language := b.Language
+		if language == "" {
+			language = "unknown"
+		}
+		metricCodeBlocks.Add(language, 1)
+	}
+}
+
+// codeFenceTagger adds a language to the untagged code fences of an answer
+// that arrives in chunks, remembering across chunks whether a block is
+// open so closing fences are left alone.
+type codeFenceTagger struct {
+	language string
+	open     bool
+}
+
+func (t *codeFenceTagger) tag(text string) string {
+	if !strings.Contains(text, codeFence) {
+		return text
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(text, codeFence)
+		if i < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		b.WriteString(text[:i+len(codeFence)])
+		text = text[i+len(codeFence):]
+		opening := !t.open
+		t.open = !t.open
+		if opening && strings.HasPrefix(text, "\n") {
+			b.WriteString(t.language)
+			metricCodeBlocks.Add("answer_fences_tagged", 1)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestExtractCodeBlocks(t *testing.T) {
+	query := "Why does this fail?\n```python\nif a &lt; b:\n    print(a)\n```\nand this\n```SELECT id FROM users WHERE name = 'x'```"
+	blocks := extractCodeBlocks(query)
+	if len(blocks) != 2 {
+		t.Fatalf("blocks = %+v", blocks)
+	}
+	if b := blocks[0]; b.Language != "python" || !b.Tagged || b.Code != "if a < b:\n    print(a)" || query[b.Start:b.Start+3] != "```" || query[b.End-3:b.End] != "```" {
+		t.Errorf("tagged block = %+v", b)
+	}
+	if b := blocks[1]; b.Language != "sql" || b.Tagged || b.Code != "SELECT id FROM users WHERE name = 'x'" || b.End != len(query) {
+		t.Errorf("guessed block = %+v", b)
+	}
+	if got := questionCodeLanguage(blocks); got != "" {
+		t.Errorf("blocks in two languages tag answers with %q", got)
+	}
+
+	if blocks := extractCodeBlocks("no code here"); len(blocks) != 0 {
+		t.Errorf("blocks in plain text: %+v", blocks)
+	}
+	if blocks := extractCodeBlocks("unclosed ```\nfunc main() {\n\tfmt.Println(1)\n}"); len(blocks) != 1 || blocks[0].Language != "go" {
+		t.Errorf("unclosed fence: %+v", blocks)
+	}
+}
+
+func TestGuessCodeLanguage(t *testing.T) {
+	for code, want := range map[string]string{
+		"package main\n\nfunc main() {\n\tif err != nil {\n\t\treturn\n\t}\n}":  "go",
+		"def handler(event):\n    return self.process(event)":                   "python",
+		"const total = items.map(i => i.price)\nconsole.log(total)":             "javascript",
+		"interface User {\n  name: string\n  age?: number\n}":                   "typescript",
+		"public static void main(String[] args) {\n  System.out.println(1);\n}": "java",
+		"#include <iostream>\nint main() { std::cout << 1; }":                   "cpp",
+		"fn main() {\n    let mut x = 1;\n    println!(\"{}\", x);\n}":          "rust",
+		"#!/bin/bash\nexport PATH=$HOME/bin\necho done":                         "bash",
+		`{"id": 1, "tags": ["a"]}`:                                              "json",
+		"FROM golang:1.24\nRUN go build ./...\nCMD [\"/app\"]":                  "dockerfile",
+		"--- a/main.go\n+++ b/main.go\n@@ -1,3 +1,3 @@":                         "diff",
+		"hello world": "",
+		"name: relay": "",
+	} {
+		if got := guessCodeLanguage(code); got != want {
+			t.Errorf("guessCodeLanguage(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestCodeFenceTagger(t *testing.T) {
+	tagger := &codeFenceTagger{language: "go"}
+	chunks := []string{"Try this:\n```\nx := 1", "\n```\nor keep ```go\ny := 2\n```", " and ```\nz := 3\n```"}
+	want := []string{"Try this:\n```go\nx := 1", "\n```\nor keep ```go\ny := 2\n```", " and ```go\nz := 3\n```"}
+	for i, chunk := range chunks {
+		if got := tagger.tag(chunk); got != want[i] {
+			t.Errorf("chunk %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestProcessTask_SendsCodeBlocksAndTagsAnswer(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	var got backend.ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"full_response":"Check the error first:\n` + "```" + `\nif err != nil {\n\treturn err\n}\n` + "```" + `"}`))
+	}))
+	defer ts.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.BackendURL = ts.URL
+
+	query := "why does this panic?\n```\nfunc load() {\n\tdata, err := os.ReadFile(name)\n\tfmt.Println(data)\n}\n```"
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CCODE"}, query)
+
+	if len(got.CodeBlocks) != 1 || got.CodeBlocks[0].Language != "go" || got.CodeBlocks[0].Tagged {
+		t.Fatalf("code blocks sent = %+v", got.CodeBlocks)
+	}
+	var answer string
+	for _, p := range api.sent() {
+		answer += p.Text()
+	}
+	if want := "```go\nif err != nil {"; !strings.Contains(answer, want) {
+		t.Errorf("answer %q lacks %q", answer, want)
+	}
+}
@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Commands
+//
+// Mentions starting with "!" are routed to registered commands instead of
+// the backend. Admin commands are limited to users listed in ADMIN_USERS.
+type commandHandler func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string)
+
+type command struct {
+	Admin   bool
+	Usage   string
+	Handler commandHandler
+}
+
+var commands = map[string]command{}
+
+func registerCommand(name string, c command) {
+	commands[name] = c
+}
+
+func isAdmin(userID string) bool {
+	for _, id := range config.AdminUsers {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func dispatchCommand(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, query string) bool {
+	if !strings.HasPrefix(query, "!") {
+		return false
+	}
+	name, args, _ := strings.Cut(strings.TrimPrefix(query, "!"), " ")
+	name = strings.ToLower(name)
+	cmd, ok := commands[name]
+	if !ok {
+		return false
+	}
+
+	ctx, span := otel.Tracer("bot").Start(ctx, "command")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("command.name", name),
+		attribute.String("user.id", ev.User),
+		attribute.String("channel.id", ev.Channel),
+	)
+
+	if cmd.Admin && !isAdmin(ev.User) {
+		span.SetAttributes(attribute.Bool("command.denied", true))
+		notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("`!%s` is restricted to admins.", name))
+		return true
+	}
+	cmd.Handler(ctx, api, ev, strings.TrimSpace(args))
+	return true
+}
+
+func commandHelp() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "• `!%s %s`\n", name, commands[name].Usage)
+	}
+	return b.String()
+}
+
+func init() {
+	registerCommand("help", command{
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			notifyUser(ctx, api, ev.Channel, ev.User, "Available commands:\n"+commandHelp())
+		},
+	})
+}
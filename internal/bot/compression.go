@@ -0,0 +1,157 @@
+package bot
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Backend Compression
+//
+// Backend requests advertise Accept-Encoding: zstd, gzip, deflate and
+// compressed responses, streamed or not, are decoded as they arrive. With
+// BACKEND_COMPRESSION=gzip or zstd, request bodies over
+// backendCompressMinBytes are compressed too, which helps long-context
+// requests to remote backends. zstd uses klauspost/compress, as the standard
+// library has no zstd codec.
+const (
+	backendAcceptEncoding   = "zstd, gzip, deflate"
+	backendCompressMinBytes = 1024
+)
+
+var (
+	metricBackendWireBytes    = expvar.NewInt("backend_response_wire_bytes")
+	metricBackendDecodedBytes = expvar.NewInt("backend_response_decoded_bytes")
+	metricBackendRequestSaved = expvar.NewInt("backend_request_bytes_saved")
+)
+
+// newBackendRequest builds a POST of body to the backend, compressing the
+// body when configured and negotiating compressed responses.
+func newBackendRequest(ctx context.Context, body []byte, accept string) (*http.Request, error) {
+	encoding := ""
+	if config.BackendCompression != "" && len(body) >= backendCompressMinBytes {
+		compressed, err := compressBackendBody(config.BackendCompression, body)
+		if err != nil {
+			return nil, err
+		}
+		metricBackendRequestSaved.Add(int64(len(body) - len(compressed)))
+		body, encoding = compressed, config.BackendCompression
+	}
+	target := backendURLFor(ctx)
+	req, err := http.NewRequestWithContext(withConnTrace(ctx), "POST", target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	backendRegions.annotate(ctx, target)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	// Setting Accept-Encoding ourselves turns off the transport's implicit
+	// gzip handling, so decodeBackendResponse must run on every response.
+	req.Header.Set("Accept-Encoding", backendAcceptEncoding)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if isLowPriority(ctx) {
+		req.Header.Set("X-Request-Priority", "low")
+	}
+	signBackendRequest(req, body)
+	return req, nil
+}
+
+// compressBackendBody encodes body with encoding, gzip or zstd.
+func compressBackendBody(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	switch encoding {
+	case "gzip":
+		zw = gzip.NewWriter(&buf)
+	case "zstd":
+		enc, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		zw = enc
+	default:
+		return nil, fmt.Errorf("unsupported backend compression %q", encoding)
+	}
+	if _, err := zw.Write(body); err != nil {
+		zw.Close()
+		return nil, fmt.Errorf("compress backend request: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compress backend request: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+type countingReader struct {
+	r       io.Reader
+	counter *expvar.Int
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.counter.Add(int64(n))
+	return n, err
+}
+
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d decodedBody) Close() error {
+	var first error
+	for _, c := range d.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// decodeBackendResponse replaces resp.Body with a reader that decompresses
+// it incrementally, so streamed chunks are still seen as they arrive.
+func decodeBackendResponse(resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	wire := countingReader{r: resp.Body, counter: metricBackendWireBytes}
+	var r io.Reader
+	closers := []io.Closer{resp.Body}
+	switch encoding {
+	case "", "identity":
+		r = wire
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(wire)
+		if err != nil {
+			return fmt.Errorf("backend gzip response: %w", err)
+		}
+		r, closers = zr, append(closers, zr)
+	case "deflate":
+		fr := flate.NewReader(wire)
+		r, closers = fr, append(closers, fr)
+	case "zstd":
+		// One goroutine decodes in step with the reads, so a streamed
+		// chunk is returned as soon as its frame block arrives.
+		zr, err := zstd.NewReader(wire, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("backend zstd response: %w", err)
+		}
+		rc := zr.IOReadCloser()
+		r, closers = rc, append(closers, rc)
+	default:
+		return fmt.Errorf("backend used unsupported Content-Encoding %q", encoding)
+	}
+	resp.Body = decodedBody{Reader: countingReader{r: r, counter: metricBackendDecodedBytes}, closers: closers}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/klauspost/compress/zstd"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestProcessTask_GzipStreamAndRequest(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	config.BackendCompression = "gzip"
+	defer func() { config.BackendCompression = "" }()
+
+	var gotQuery, gotEncoding, gotAccept string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding, gotAccept = r.Header.Get("Content-Encoding"), r.Header.Get("Accept-Encoding")
+		body := io.Reader(r.Body)
+		if gotEncoding == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("request body is not gzip: %v", err)
+				return
+			}
+			body = zr
+		}
+		var req backend.ChatRequest
+		json.NewDecoder(body).Decode(&req)
+		gotQuery = req.Query
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		for _, part := range []string{"Compressed one", "Compressed two"} {
+			fmt.Fprintf(zw, "data: {\"event\":\"message_part\",\"text_chunk\":%q}\n\n", part)
+			zw.Flush()
+			w.(http.Flusher).Flush()
+		}
+		zw.Close()
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	longQuery := strings.Repeat("context ", 500)
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CGZ"}, longQuery)
+
+	if gotEncoding != "gzip" || gotQuery != longQuery {
+		t.Errorf("request not gzipped intact: encoding=%q query len=%d", gotEncoding, len(gotQuery))
+	}
+	if gotAccept != backendAcceptEncoding {
+		t.Errorf("Accept-Encoding = %q", gotAccept)
+	}
+	posts := api.sent()
+	if len(posts) != 2 || posts[0].Text() != "Compressed one" || posts[1].Text() != "Compressed two" {
+		t.Errorf("unexpected posts %+v", posts)
+	}
+}
+
+func TestNewBackendRequest_SmallBodyUncompressed(t *testing.T) {
+	config.BackendCompression = "gzip"
+	config.BackendURL = "http://backend.test"
+	defer func() { config.BackendCompression = "" }()
+
+	req, err := newBackendRequest(context.Background(), []byte(`{"query":"hi"}`), "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("Content-Encoding") != "" {
+		t.Error("small bodies should not be compressed")
+	}
+
+	resp := &http.Response{Header: http.Header{"Content-Encoding": {"br"}}, Body: io.NopCloser(strings.NewReader(""))}
+	if err := decodeBackendResponse(resp); err == nil {
+		t.Error("expected an error for an unsupported encoding")
+	}
+}
+
+func TestProcessTask_ZstdStreamAndRequest(t *testing.T) {
+	defer func(d time.Duration, url, compression string) {
+		postInterval, config.BackendURL, config.BackendCompression = d, url, compression
+	}(postInterval, config.BackendURL, config.BackendCompression)
+	postInterval, config.BackendCompression = 0, "zstd"
+
+	var gotQuery, gotEncoding string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		zr, err := zstd.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("request body is not zstd: %v", err)
+			return
+		}
+		defer zr.Close()
+		var req backend.ChatRequest
+		json.NewDecoder(zr).Decode(&req)
+		gotQuery = req.Query
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Encoding", "zstd")
+		zw, _ := zstd.NewWriter(w)
+		for _, part := range []string{"Zstd one", "Zstd two"} {
+			fmt.Fprintf(zw, "data: {\"event\":\"message_part\",\"text_chunk\":%q}\n\n", part)
+			zw.Flush()
+			w.(http.Flusher).Flush()
+		}
+		zw.Close()
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	longQuery := strings.Repeat("context ", 500)
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CZSTD"}, longQuery)
+
+	if gotEncoding != "zstd" || gotQuery != longQuery {
+		t.Errorf("request not zstd-compressed intact: encoding=%q query len=%d", gotEncoding, len(gotQuery))
+	}
+	posts := api.sent()
+	if len(posts) != 2 || posts[0].Text() != "Zstd one" || posts[1].Text() != "Zstd two" {
+		t.Errorf("unexpected posts %+v", posts)
+	}
+}
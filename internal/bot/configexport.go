@@ -0,0 +1,314 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration Export
+//
+// "!config export" DMs the admin the runtime configuration as a YAML file:
+// channel settings, personas and their pins, the weekly digest schedule and
+// feature flag overrides. "!config import" with that file attached (or
+// pasted in a code block) applies it, which moves a setup between
+// environments. Every section in the file is validated before any is
+// applied; sections left out are not touched. Channel settings, personas
+// and flags are also written to CHANNEL_CONFIG, PERSONAS_FILE and
+// FEATURE_FLAGS when those are set, while an imported digest schedule lasts
+// until restart unless the DIGEST_* variables are updated too. Exports and
+// imports are audited and counted under "config_transfers" on /debug/vars.
+const (
+	configFormatVersion = 1
+	maxConfigFileBytes  = 1 << 20
+)
+
+var metricConfigTransfers = expvar.NewMap("config_transfers")
+
+type runtimeConfig struct {
+	Version  int              `json:"version"`
+	Channels *channelSettings `json:"channels,omitempty"`
+	Personas *personaState    `json:"personas,omitempty"`
+	Digest   *digestConfig    `json:"digest,omitempty"`
+	Flags    *flagSettings    `json:"flags,omitempty"`
+}
+
+type digestConfig struct {
+	Channels   []string `json:"channels,omitempty"`
+	Target     string   `json:"target,omitempty"`
+	Recipients []string `json:"recipients,omitempty"`
+	Schedule   string   `json:"schedule"`
+}
+
+// currentRuntimeConfig collects the configuration in effect.
+func currentRuntimeConfig() runtimeConfig {
+	channels := currentChannelSettings()
+	state := personas.snapshot()
+	digest := digests.current()
+	flagOverrides := flags.snapshot()
+	return runtimeConfig{
+		Version:  configFormatVersion,
+		Channels: &channels,
+		Personas: &state,
+		Digest: &digestConfig{
+			Channels:   digest.Channels,
+			Target:     digest.Target,
+			Recipients: digest.Recipients,
+			Schedule:   digest.Schedule.String(),
+		},
+		Flags: &flagOverrides,
+	}
+}
+
+// marshalRuntimeConfig renders rc as YAML with the JSON field names the
+// configuration files use, keeping the struct order.
+func marshalRuntimeConfig(rc runtimeConfig) ([]byte, error) {
+	data, err := json.Marshal(rc)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML; decoding into a node keeps the key order.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	clearYAMLStyle(&doc)
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), enc.Close()
+}
+
+// clearYAMLStyle drops the flow style and quoting carried over from JSON.
+func clearYAMLStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		clearYAMLStyle(c)
+	}
+}
+
+// parseRuntimeConfig reads and validates an exported file.
+func parseRuntimeConfig(data []byte) (runtimeConfig, error) {
+	var raw any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return runtimeConfig{}, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if _, ok := raw.(map[string]any); !ok {
+		return runtimeConfig{}, errors.New("the file is not a configuration export")
+	}
+	js, err := json.Marshal(raw)
+	if err != nil {
+		return runtimeConfig{}, fmt.Errorf("invalid YAML: %w", err)
+	}
+	var rc runtimeConfig
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rc); err != nil {
+		return runtimeConfig{}, err
+	}
+	if rc.Version != configFormatVersion {
+		return runtimeConfig{}, fmt.Errorf("unsupported version %d (expected %d)", rc.Version, configFormatVersion)
+	}
+	if rc.Channels != nil {
+		if err := validateChannelSettings(*rc.Channels); err != nil {
+			return runtimeConfig{}, fmt.Errorf("channels: %w", err)
+		}
+	}
+	if rc.Personas != nil {
+		if err := validatePersonaState(rc.Personas); err != nil {
+			return runtimeConfig{}, fmt.Errorf("personas: %w", err)
+		}
+	}
+	if rc.Digest != nil {
+		if _, err := parseDigestSchedule(rc.Digest.Schedule); err != nil {
+			return runtimeConfig{}, fmt.Errorf("digest: %w", err)
+		}
+	}
+	if rc.Flags != nil {
+		if err := validateFlagSettings(*rc.Flags); err != nil {
+			return runtimeConfig{}, fmt.Errorf("flags: %w", err)
+		}
+	}
+	return rc, nil
+}
+
+// applyRuntimeConfig switches to a validated configuration and returns the
+// sections it changed.
+func applyRuntimeConfig(rc runtimeConfig) ([]string, error) {
+	var applied []string
+	if rc.Channels != nil {
+		if config.ChannelConfig != "" {
+			data, err := json.MarshalIndent(rc.Channels, "", "  ")
+			if err != nil {
+				return applied, err
+			}
+			if err := os.WriteFile(config.ChannelConfig, data, 0o644); err != nil {
+				return applied, fmt.Errorf("channels: %w", err)
+			}
+		}
+		setChannelSettings(*rc.Channels)
+		applied = append(applied, "channels")
+	}
+	if rc.Personas != nil {
+		if err := personas.replace(*rc.Personas); err != nil {
+			return applied, fmt.Errorf("personas: %w", err)
+		}
+		applied = append(applied, "personas")
+	}
+	if rc.Digest != nil {
+		schedule, _ := parseDigestSchedule(rc.Digest.Schedule)
+		digests.configure(digestSettings{
+			Channels:   rc.Digest.Channels,
+			Target:     rc.Digest.Target,
+			Recipients: rc.Digest.Recipients,
+			Schedule:   schedule,
+		})
+		applied = append(applied, "digest")
+	}
+	if rc.Flags != nil {
+		if err := flags.replace(*rc.Flags); err != nil {
+			return applied, fmt.Errorf("flags: %w", err)
+		}
+		applied = append(applied, "flags")
+	}
+	return applied, nil
+}
+
+func exportConfig(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent) {
+	data, err := marshalRuntimeConfig(currentRuntimeConfig())
+	if err != nil {
+		notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Could not export the configuration: %v", err))
+		return
+	}
+	name := "chatrelaybot-config-" + time.Now().UTC().Format("20060102-150405") + ".yaml"
+	// Prompts and channel settings are internal, so the file goes to a DM.
+	if _, err := api.UploadFileV2Context(ctx, slack.UploadFileV2Parameters{
+		Channel:        ev.User,
+		Filename:       name,
+		Title:          "ChatRelayBot configuration",
+		Content:        string(data),
+		FileSize:       len(data),
+		InitialComment: "Attach this file to `!config import` to apply it in another environment.",
+	}); err != nil {
+		slog.ErrorContext(ctx, "Failed to upload configuration export", "err", err)
+		notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Could not upload the configuration: %v", err))
+		return
+	}
+	metricConfigTransfers.Add("exports", 1)
+	auditConfig(ctx, ev, "config_export", name)
+	notifyUser(ctx, api, ev.Channel, ev.User, "I've sent you the configuration as a DM.")
+}
+
+func importConfig(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, pasted string) {
+	data, err := configImportData(ctx, api, ev, pasted)
+	if err == nil {
+		var rc runtimeConfig
+		if rc, err = parseRuntimeConfig(data); err == nil {
+			var applied []string
+			applied, err = applyRuntimeConfig(rc)
+			if err == nil {
+				metricConfigTransfers.Add("imports", 1)
+				auditConfig(ctx, ev, "config_import", strings.Join(applied, ","))
+				if len(applied) == 0 {
+					notifyUser(ctx, api, ev.Channel, ev.User, "The file has no sections to import; nothing changed.")
+					return
+				}
+				notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Imported %s.", strings.Join(applied, ", ")))
+				return
+			}
+			if len(applied) > 0 {
+				// Earlier sections are already live; record them.
+				auditConfig(ctx, ev, "config_import", strings.Join(applied, ",")+" (partial)")
+				err = fmt.Errorf("%w (already applied: %s)", err, strings.Join(applied, ", "))
+			}
+		}
+	}
+	metricConfigTransfers.Add("import_errors", 1)
+	notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Could not import the configuration: %v", err))
+}
+
+var slackTextUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+// configImportData returns the YAML pasted after the command, or else the
+// YAML file attached to the mention.
+func configImportData(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, pasted string) ([]byte, error) {
+	if pasted = strings.TrimSpace(strings.Trim(strings.TrimSpace(pasted), "`")); pasted != "" {
+		pasted = strings.TrimPrefix(pasted, "yaml\n")
+		return []byte(slackTextUnescaper.Replace(pasted)), nil
+	}
+	if slackReader == nil {
+		return nil, errors.New("attach the exported YAML file or paste it in a code block")
+	}
+	// Mention events carry no files, so the message is read back.
+	msgs, err := slackReader.Replies(ctx, ev.Channel, ev.TimeStamp, 1)
+	if err != nil {
+		return nil, fmt.Errorf("read the message: %w", err)
+	}
+	for _, msg := range msgs {
+		if msg.Timestamp != ev.TimeStamp {
+			continue
+		}
+		for _, f := range msg.Files {
+			if !strings.HasSuffix(f.Name, ".yaml") && !strings.HasSuffix(f.Name, ".yml") {
+				continue
+			}
+			if f.Size > maxConfigFileBytes {
+				return nil, fmt.Errorf("%s is larger than %d bytes", f.Name, maxConfigFileBytes)
+			}
+			url := f.URLPrivateDownload
+			if url == "" {
+				url = f.URLPrivate
+			}
+			var buf bytes.Buffer
+			if err := api.GetFileContext(ctx, url, &buf); err != nil {
+				return nil, fmt.Errorf("download %s: %w", f.Name, err)
+			}
+			return buf.Bytes(), nil
+		}
+	}
+	return nil, errors.New("attach the exported YAML file or paste it in a code block")
+}
+
+func auditConfig(ctx context.Context, ev slackevents.AppMentionEvent, action, detail string) {
+	if err := auditLog.record(ctx, AuditEntry{Actor: ev.User, Action: action, Channel: ev.Channel, Detail: detail}); err != nil {
+		slog.ErrorContext(ctx, "Failed to write audit log", "err", err)
+	}
+}
+
+const configUsage = "Usage: `!config export`, or `!config import` with the exported YAML file attached or pasted in a code block"
+
+func init() {
+	registerCommand("config", command{
+		Admin: true,
+		Usage: "export | import (runtime configuration as YAML)",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			sub, rest := args, ""
+			if i := strings.IndexFunc(args, unicode.IsSpace); i >= 0 {
+				sub, rest = args[:i], args[i:]
+			}
+			switch strings.ToLower(sub) {
+			case "export":
+				exportConfig(ctx, api, ev)
+			case "import":
+				importConfig(ctx, api, ev, rest)
+			default:
+				notifyUser(ctx, api, ev.Channel, ev.User, configUsage)
+			}
+		},
+	})
+}
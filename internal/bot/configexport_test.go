@@ -0,0 +1,157 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/slackfetch"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// repliesAPI serves fixed thread replies to slackfetch.
+type repliesAPI struct {
+	slackfetch.API
+	messages []slack.Message
+}
+
+func (r repliesAPI) GetConversationRepliesContext(ctx context.Context, params *slack.GetConversationRepliesParameters) ([]slack.Message, bool, string, error) {
+	return r.messages, false, "", nil
+}
+
+func TestRuntimeConfig_RoundTrip(t *testing.T) {
+	defer func(p *personaStore, f *flagStore, d *digestRunner, cs channelSettings, path string) {
+		personas, flags, digests, config.ChannelConfig = p, f, d, path
+		setChannelSettings(cs)
+	}(personas, flags, digests, currentChannelSettings(), config.ChannelConfig)
+	personas, flags, digests, config.ChannelConfig = newPersonaStore(), &flagStore{}, &digestRunner{}, ""
+	setChannelSettings(channelSettings{})
+	setChannelSettings(channelSettings{
+		Default:  ChannelConfig{Disclaimer: "Check with a human."},
+		Channels: map[string]ChannelConfig{"CREVIEW": {ReviewMode: true, ReviewChannel: "CLEADS", Drafts: 2}},
+	})
+	personas.publish("support", "Answer as the support desk.", "UADMIN")
+	personas.pin("CREVIEW", PersonaPin{Persona: "support", Version: 1})
+	off := false
+	flags.set(FlagStreaming, "channel", "CREVIEW", &off)
+	digests.configure(digestSettings{Channels: []string{"C1", "C2"}, Target: "CLEADS", Schedule: digestSchedule{Weekday: time.Friday, Hour: 16, Minute: 30}})
+
+	data, err := marshalRuntimeConfig(currentRuntimeConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{"version: 1\n", "review_mode: true", "schedule: fri 16:30", "prompt: Answer as the support desk."} {
+		if !strings.Contains(out, want) {
+			t.Errorf("export lacks %q:\n%s", want, out)
+		}
+	}
+
+	personas, flags, digests = newPersonaStore(), &flagStore{}, &digestRunner{}
+	setChannelSettings(channelSettings{})
+	rc, err := parseRuntimeConfig(data)
+	if err != nil {
+		t.Fatalf("parse own export: %v\n%s", err, out)
+	}
+	applied, err := applyRuntimeConfig(rc)
+	if err != nil || strings.Join(applied, ",") != "channels,personas,digest,flags" {
+		t.Fatalf("applied %v, %v", applied, err)
+	}
+	if cc := channelConfigFor("CREVIEW"); !cc.ReviewMode || cc.Drafts != 2 {
+		t.Errorf("channel settings not imported: %+v", cc)
+	}
+	if p, ok := personas.resolve("CREVIEW"); !ok || p.Label() != "support@v1" {
+		t.Errorf("CREVIEW resolved %+v", p)
+	}
+	if flags.enabled(FlagStreaming, "", "CREVIEW") {
+		t.Error("flag override not imported")
+	}
+	if d := digests.current(); d.Schedule.String() != "fri 16:30" || d.Target != "CLEADS" || len(d.Channels) != 2 {
+		t.Errorf("digest settings = %+v", d)
+	}
+}
+
+func TestParseRuntimeConfig_Invalid(t *testing.T) {
+	for name, doc := range map[string]string{
+		"not yaml":        "version: [1",
+		"wrong version":   "version: 2",
+		"unknown section": "version: 1\nwebhooks: {}",
+		"misspelled":      "version: 1\nchannels:\n  default:\n    reveiw_mode: true",
+		"emoji policy":    "version: 1\nchannels:\n  default:\n    emoji_policy: sometimes",
+		"pin":             "version: 1\npersonas:\n  personas: {}\n  pins:\n    C1: {persona: ghost}",
+		"flag":            "version: 1\nflags:\n  defaults: {teleport: true}",
+		"schedule":        "version: 1\ndigest:\n  schedule: someday",
+	} {
+		if _, err := parseRuntimeConfig([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestConfigCommand_ImportValidatesBeforeApplying(t *testing.T) {
+	defer func(p *personaStore, f *flagStore, d *digestRunner, cs channelSettings, path string) {
+		personas, flags, digests, config.ChannelConfig = p, f, d, path
+		setChannelSettings(cs)
+	}(personas, flags, digests, currentChannelSettings(), config.ChannelConfig)
+	personas, flags, digests, config.ChannelConfig = newPersonaStore(), &flagStore{}, &digestRunner{}, ""
+	setChannelSettings(channelSettings{})
+	defer func(admins []string) { config.AdminUsers = admins }(config.AdminUsers)
+	config.AdminUsers = []string{"UADMIN"}
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{User: "UADMIN", Channel: "CADMIN"}
+
+	dispatchCommand(context.Background(), api, ev, "!config import\n```version: 1\nchannels:\n  default: {disclaimer: imported}\nflags:\n  defaults: {teleport: true}```")
+	if channelConfigFor("CANY").Disclaimer != "" {
+		t.Error("channels were applied although flags were invalid")
+	}
+	if last := api.posts[len(api.posts)-1].Text(); !strings.Contains(last, "unknown flag") {
+		t.Errorf("reply = %q", last)
+	}
+}
+
+func TestConfigCommand_ExportAndImportFile(t *testing.T) {
+	defer func(p *personaStore, f *flagStore, d *digestRunner, cs channelSettings, path string) {
+		personas, flags, digests, config.ChannelConfig = p, f, d, path
+		setChannelSettings(cs)
+	}(personas, flags, digests, currentChannelSettings(), config.ChannelConfig)
+	personas, flags, digests, config.ChannelConfig = newPersonaStore(), &flagStore{}, &digestRunner{}, ""
+	setChannelSettings(channelSettings{})
+	defer func(admins []string) { config.AdminUsers = admins }(config.AdminUsers)
+	config.AdminUsers = []string{"UADMIN"}
+	defer func(r *slackfetch.Fetcher) { slackReader = r }(slackReader)
+	config.ChannelConfig = filepath.Join(t.TempDir(), "channels.json")
+	setChannelSettings(channelSettings{Default: ChannelConfig{Disclaimer: "From staging."}})
+
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{User: "UADMIN", Channel: "CADMIN", TimeStamp: "5.000100"}
+	dispatchCommand(context.Background(), api, ev, "!config export")
+	if len(api.uploads) != 1 || api.uploads[0].Channel != "UADMIN" || !strings.HasSuffix(api.uploads[0].Filename, ".yaml") {
+		t.Fatalf("uploads = %+v", api.uploads)
+	}
+	exported := api.uploads[0].Content
+
+	setChannelSettings(channelSettings{})
+	msg := slack.Message{}
+	msg.Timestamp = ev.TimeStamp
+	msg.Files = []slack.File{{ID: "F1", Name: "config.yaml", Size: len(exported), URLPrivateDownload: "https://files.slack.com/F1"}}
+	slackReader = slackfetch.New(repliesAPI{messages: []slack.Message{msg}}, slackfetch.Options{CacheTTL: -1})
+	api.files = map[string][]byte{"https://files.slack.com/F1": []byte(exported)}
+
+	dispatchCommand(context.Background(), api, ev, "!config import")
+	if got := channelConfigFor("CANY").Disclaimer; got != "From staging." {
+		t.Errorf("disclaimer after import = %q", got)
+	}
+	data, err := os.ReadFile(config.ChannelConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved channelSettings
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Default.Disclaimer != "From staging." {
+		t.Errorf("CHANNEL_CONFIG = %s (%v)", data, err)
+	}
+}
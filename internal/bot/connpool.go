@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"context"
+	"crypto/tls"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Backend Connections
+//
+// Backend requests share one keep-alive transport, tuned with
+// BACKEND_MAX_IDLE_CONNS_PER_HOST (default 16, where Go's default of 2 makes
+// concurrent streams open fresh connections), BACKEND_MAX_CONNS_PER_HOST
+// (default unlimited) and BACKEND_IDLE_CONN_TIMEOUT (default 90s). HTTP/2
+// is negotiated over TLS when the backend offers it. Every request is
+// traced: whether its connection was reused and how long DNS, TCP connect
+// and the TLS handshake took are added up under "backend_connections", and
+// the connections currently open to each host are under
+// "backend_open_connections" on /debug/vars. The span gets
+// http.conn_reused, so a streaming backend that re-establishes its
+// connection per request is easy to spot.
+const (
+	defaultBackendMaxIdlePerHost = 16
+	defaultBackendIdleTimeout    = 90 * time.Second
+)
+
+var (
+	metricBackendConns = expvar.NewMap("backend_connections")
+
+	openConnsMu sync.Mutex
+	openConns   = map[string]int{}
+
+	backendClient = &http.Client{Transport: newBackendTransport(backendTransportConfig{})}
+)
+
+func init() {
+	expvar.Publish("backend_open_connections", expvar.Func(func() any {
+		openConnsMu.Lock()
+		defer openConnsMu.Unlock()
+		counts := make(map[string]int, len(openConns))
+		for host, n := range openConns {
+			counts[host] = n
+		}
+		return counts
+	}))
+}
+
+type backendTransportConfig struct {
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+}
+
+func backendTransportConfigFromEnv() (backendTransportConfig, error) {
+	var cfg backendTransportConfig
+	var err error
+	if v := os.Getenv("BACKEND_MAX_IDLE_CONNS_PER_HOST"); v != "" {
+		if cfg.MaxIdleConnsPerHost, err = strconv.Atoi(v); err != nil || cfg.MaxIdleConnsPerHost < 0 {
+			return cfg, fmt.Errorf("invalid BACKEND_MAX_IDLE_CONNS_PER_HOST %q", v)
+		}
+	}
+	if v := os.Getenv("BACKEND_MAX_CONNS_PER_HOST"); v != "" {
+		if cfg.MaxConnsPerHost, err = strconv.Atoi(v); err != nil || cfg.MaxConnsPerHost < 0 {
+			return cfg, fmt.Errorf("invalid BACKEND_MAX_CONNS_PER_HOST %q", v)
+		}
+	}
+	if v := os.Getenv("BACKEND_IDLE_CONN_TIMEOUT"); v != "" {
+		if cfg.IdleConnTimeout, err = time.ParseDuration(v); err != nil || cfg.IdleConnTimeout <= 0 {
+			return cfg, fmt.Errorf("invalid BACKEND_IDLE_CONN_TIMEOUT %q", v)
+		}
+	}
+	return cfg, nil
+}
+
+func newBackendTransport(cfg backendTransportConfig) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConnsPerHost = defaultBackendMaxIdlePerHost
+	if cfg.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	t.MaxIdleConns = max(t.MaxIdleConns, t.MaxIdleConnsPerHost)
+	t.MaxConnsPerHost = cfg.MaxConnsPerHost
+	t.IdleConnTimeout = defaultBackendIdleTimeout
+	if cfg.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		openConnsMu.Lock()
+		openConns[addr]++
+		openConnsMu.Unlock()
+		return &countedConn{Conn: conn, addr: addr}, nil
+	}
+	return t
+}
+
+// countedConn keeps backend_open_connections up to date.
+type countedConn struct {
+	net.Conn
+	addr string
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		openConnsMu.Lock()
+		if openConns[c.addr]--; openConns[c.addr] <= 0 {
+			delete(openConns, c.addr)
+		}
+		openConnsMu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// withConnTrace records how ctx's backend request got its connection.
+func withConnTrace(ctx context.Context) context.Context {
+	var dnsStart, connectStart, tlsStart time.Time
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("http.conn_reused", info.Reused))
+			if info.Reused {
+				metricBackendConns.Add("reused", 1)
+			} else {
+				metricBackendConns.Add("new", 1)
+			}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			metricBackendConns.Add("dns_lookups", 1)
+			metricBackendConns.AddFloat("dns_seconds_total", time.Since(dnsStart).Seconds())
+		},
+		ConnectStart: func(string, string) { connectStart = time.Now() },
+		ConnectDone: func(_, _ string, err error) {
+			if err != nil {
+				metricBackendConns.Add("connect_errors", 1)
+				return
+			}
+			metricBackendConns.Add("connects", 1)
+			metricBackendConns.AddFloat("connect_seconds_total", time.Since(connectStart).Seconds())
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err != nil {
+				metricBackendConns.Add("tls_errors", 1)
+				return
+			}
+			metricBackendConns.Add("tls_handshakes", 1)
+			metricBackendConns.AddFloat("tls_seconds_total", time.Since(tlsStart).Seconds())
+		},
+	})
+}
@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackendTransportConfigFromEnv(t *testing.T) {
+	t.Setenv("BACKEND_MAX_IDLE_CONNS_PER_HOST", "32")
+	t.Setenv("BACKEND_MAX_CONNS_PER_HOST", "64")
+	t.Setenv("BACKEND_IDLE_CONN_TIMEOUT", "2m")
+	cfg, err := backendTransportConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newBackendTransport(cfg)
+	if tr.MaxIdleConnsPerHost != 32 || tr.MaxConnsPerHost != 64 || tr.IdleConnTimeout != 2*time.Minute || !tr.ForceAttemptHTTP2 {
+		t.Errorf("transport = idle/host %d, max/host %d, idle timeout %s", tr.MaxIdleConnsPerHost, tr.MaxConnsPerHost, tr.IdleConnTimeout)
+	}
+	if def := newBackendTransport(backendTransportConfig{}); def.MaxIdleConnsPerHost != defaultBackendMaxIdlePerHost || def.IdleConnTimeout != defaultBackendIdleTimeout {
+		t.Errorf("defaults not applied: %d, %s", def.MaxIdleConnsPerHost, def.IdleConnTimeout)
+	}
+
+	t.Setenv("BACKEND_IDLE_CONN_TIMEOUT", "soon")
+	if _, err := backendTransportConfigFromEnv(); err == nil {
+		t.Error("expected an error for an invalid timeout")
+	}
+}
+
+func TestBackendClient_ReusesConnections(t *testing.T) {
+	defer func(c *http.Client) { backendClient = c }(backendClient)
+	tr := newBackendTransport(backendTransportConfig{})
+	backendClient = &http.Client{Transport: tr}
+	defer tr.CloseIdleConnections()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	reused := func() int64 {
+		if v, ok := metricBackendConns.Get("reused").(interface{ Value() int64 }); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := reused()
+	for i := 0; i < 3; i++ {
+		req, err := newBackendRequest(context.Background(), []byte(`{}`), "application/json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := backendClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Reading to EOF hands the connection back to the pool before the
+		// next request is made.
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	open := func() int {
+		openConnsMu.Lock()
+		defer openConnsMu.Unlock()
+		return openConns[ts.Listener.Addr().String()]
+	}
+	// Goroutines left by earlier tests may still make backend requests, so
+	// wait for at least this test's reuses rather than an exact count.
+	deadline := time.Now().Add(2 * time.Second)
+	for reused()-before < 2 || open() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("reused connections = %d, want at least 2; open connections to the backend = %d, want 1", reused()-before, open())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Content Filter
+//
+// Blocklisted terms in outgoing messages are either masked or cause the
+// whole message to be replaced with a notice asking the user to rephrase.
+// FILTER_MODE_BY_SOURCE overrides the mode for questions from some entry
+// points (see source.go), which are then filtered regardless of the
+// moderation flag.
+const (
+	FilterModeMask   = "mask"
+	FilterModeReject = "reject"
+
+	filterRejectNotice = ":warning: This response was withheld by the content filter. Please rephrase your question and ask again."
+)
+
+type blocklistFilter struct {
+	pattern *regexp.Regexp
+	mode    string
+	// bySource holds per-source modes from FILTER_MODE_BY_SOURCE.
+	bySource map[string]string
+}
+
+func newBlocklistFilter(terms []string, mode string) (*blocklistFilter, error) {
+	var quoted []string
+	for _, term := range terms {
+		term = strings.TrimSpace(term)
+		if term != "" && !strings.HasPrefix(term, "#") {
+			quoted = append(quoted, regexp.QuoteMeta(term))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil, nil
+	}
+	switch mode {
+	case "":
+		mode = FilterModeMask
+	case FilterModeMask, FilterModeReject:
+	default:
+		return nil, fmt.Errorf("unknown FILTER_MODE %q", mode)
+	}
+	pattern, err := regexp.Compile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	if err != nil {
+		return nil, err
+	}
+	return &blocklistFilter{pattern: pattern, mode: mode}, nil
+}
+
+// newBlocklistFilterFromEnv reads terms from BLOCKLIST (comma separated) and
+// BLOCKLIST_FILE (one term per line).
+func newBlocklistFilterFromEnv() (*blocklistFilter, error) {
+	terms := strings.Split(os.Getenv("BLOCKLIST"), ",")
+	if path := os.Getenv("BLOCKLIST_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			terms = append(terms, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	f, err := newBlocklistFilter(terms, strings.ToLower(os.Getenv("FILTER_MODE")))
+	if f == nil || err != nil {
+		return f, err
+	}
+	f.bySource, err = parseSourceFilterModes(os.Getenv("FILTER_MODE_BY_SOURCE"))
+	return f, err
+}
+
+func (f *blocklistFilter) Apply(ctx context.Context, msg *outgoingMessage) {
+	mode, strict := f.bySource[querySourceFrom(ctx)]
+	if !strict {
+		if !flagEnabled(ctx, FlagModeration, msg.Channel) {
+			return
+		}
+		mode = f.mode
+	}
+	matches := f.pattern.FindAllStringIndex(msg.Text, -1)
+	if len(matches) == 0 {
+		return
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("filter.matches", len(matches)),
+		attribute.String("filter.mode", mode),
+	)
+	if mode == FilterModeReject {
+		slog.InfoContext(ctx, "Outgoing message rejected by content filter", "channel", msg.Channel)
+		msg.Text = filterRejectNotice
+		return
+	}
+	msg.Text = f.pattern.ReplaceAllStringFunc(msg.Text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+}
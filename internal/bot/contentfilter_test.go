@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBlocklistFilter_Mask(t *testing.T) {
+	f, err := newBlocklistFilter([]string{"darn", "heck"}, FilterModeMask)
+	if err != nil {
+		t.Fatalf("newBlocklistFilter: %v", err)
+	}
+	msg := &outgoingMessage{Text: "Darn it, what the heck. Checkout still works."}
+	f.Apply(context.Background(), msg)
+	if msg.Text != "**** it, what the ****. Checkout still works." {
+		t.Errorf("unexpected masked text %q", msg.Text)
+	}
+}
+
+func TestBlocklistFilter_RejectAppliesToAllPosts(t *testing.T) {
+	f, _ := newBlocklistFilter([]string{"competitorco"}, FilterModeReject)
+	outgoingFilters = []outgoingFilter{f.Apply}
+	defer func() { outgoingFilters = nil }()
+
+	api := &fakeSlackClient{}
+	notifyUser(context.Background(), api, "C1", "U1", "Try CompetitorCo instead")
+	sendMessage(context.Background(), api, outgoingMessage{Channel: "C1", Text: "clean answer"})
+
+	posts := api.sent()
+	if posts[0].Text() != filterRejectNotice {
+		t.Errorf("expected reject notice, got %q", posts[0].Text())
+	}
+	if posts[1].Text() != "clean answer" {
+		t.Errorf("clean message should pass through, got %q", posts[1].Text())
+	}
+}
+
+func TestNewBlocklistFilter_EmptyOrInvalid(t *testing.T) {
+	if f, err := newBlocklistFilter([]string{"", " # comment"}, ""); f != nil || err != nil {
+		t.Errorf("expected no filter for empty blocklist, got %v %v", f, err)
+	}
+	if _, err := newBlocklistFilter([]string{"x"}, "shout"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
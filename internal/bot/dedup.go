@@ -0,0 +1,47 @@
+package bot
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+)
+
+// Stream Deduplication
+//
+// Backends may resend chunks after reconnecting. Chunks are identified by the
+// event's "id" (from the JSON payload, or the SSE id: field when the payload
+// has none) and a repeated ID within one response is dropped.
+type chunkDeduper struct {
+	seen   map[string]bool
+	lastID string
+}
+
+func newChunkDeduper() *chunkDeduper {
+	return &chunkDeduper{seen: make(map[string]bool)}
+}
+
+// observeLine records SSE id: fields so they can identify the next data line.
+func (d *chunkDeduper) observeLine(line string) {
+	if id, ok := strings.CutPrefix(line, "id: "); ok {
+		d.lastID = strings.TrimSpace(id)
+	}
+}
+
+// duplicate reports whether the chunk has already been seen in this response.
+func (d *chunkDeduper) duplicate(msg backend.ChatResponse) bool {
+	id := d.lastID
+	if msg.ID != 0 {
+		id = strconv.Itoa(msg.ID)
+	}
+	d.lastID = ""
+	if id == "" {
+		return false
+	}
+	if d.seen[id] {
+		metricDuplicateChunks.Add(1)
+		return true
+	}
+	d.seen[id] = true
+	return false
+}
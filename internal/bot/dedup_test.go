@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestProcessTask_SkipsResentChunks(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, resp := range []backend.ChatResponse{
+			{ID: 1, Event: "message_part", Text: "First paragraph"},
+			{ID: 2, Event: "message_part", Text: "Second paragraph"},
+			{ID: 1, Event: "message_part", Text: "First paragraph"},
+			{ID: 2, Event: "message_part", Text: "Second paragraph"},
+			{ID: 3, Event: "message_part", Text: "Third paragraph"},
+		} {
+			data, _ := json.Marshal(resp)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", resp.ID, resp.Event, data)
+		}
+		// Without an id in the payload the SSE id: field identifies the chunk.
+		fmt.Fprintf(w, "id: 3\nevent: message_part\ndata: {\"event\":\"message_part\",\"text_chunk\":\"Third paragraph\"}\n\n")
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	before := metricDuplicateChunks.Value()
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CDUP"}, "foo")
+
+	posts := api.sent()
+	if len(posts) != 3 || posts[0].Text() != "First paragraph" || posts[2].Text() != "Third paragraph" {
+		t.Errorf("expected each chunk posted once, got %+v", posts)
+	}
+	if got := metricDuplicateChunks.Value() - before; got != 3 {
+		t.Errorf("expected 3 suppressed duplicates, got %d", got)
+	}
+}
@@ -0,0 +1,212 @@
+package bot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"syscall"
+	"time"
+
+	relayconfig "github.com/heykvr/chatrelaybot/internal/config"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Diagnostics
+//
+// SIGQUIT or "!diag" writes a tarball with a goroutine dump, recent logs, a
+// redacted config snapshot and runtime/queue stats to DIAG_DIR (default the
+// system temp dir) for attaching to support tickets. SIGQUIT no longer
+// terminates the process.
+var (
+	workerPool *workerpool.Pool
+	startedAt  = time.Now()
+)
+
+// The worker pool scales between WORKERS_MIN and WORKERS; its size and
+// utilization are published under "worker_pool" on /debug/vars.
+func init() {
+	expvar.Publish("worker_pool", expvar.Func(func() any {
+		if workerPool == nil {
+			return nil
+		}
+		st := workerPool.Stats()
+		return struct {
+			workerpool.Stats
+			Utilization float64 `json:"utilization"`
+		}{st, st.Utilization()}
+	}))
+}
+
+// minWorkers is WORKERS_MIN, or DefaultMinWorkers capped at WORKERS.
+func minWorkers() int {
+	if config.MinWorkers > 0 {
+		return config.MinWorkers
+	}
+	return min(relayconfig.DefaultMinWorkers, config.Workers)
+}
+
+type diagStats struct {
+	Time        time.Time         `json:"time"`
+	Uptime      string            `json:"uptime"`
+	GoVersion   string            `json:"go_version"`
+	Goroutines  int               `json:"goroutines"`
+	HeapAlloc   uint64            `json:"heap_alloc_bytes"`
+	HeapObjects uint64            `json:"heap_objects"`
+	NumGC       uint32            `json:"num_gc"`
+	Pool        *workerpool.Stats `json:"pool,omitempty"`
+	Warmup      WarmupReport      `json:"warmup"`
+	Plugins     []string          `json:"plugins,omitempty"`
+}
+
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) <= 8 {
+		return "[redacted]"
+	}
+	return s[:5] + "…[redacted]"
+}
+
+// configSnapshot copies the running config with tokens redacted.
+func configSnapshot() map[string]any {
+	channelMu.RLock()
+	channels, _ := json.Marshal(channelConfigs)
+	channelMu.RUnlock()
+	return map[string]any{
+		"slack_bot_token": redactSecret(config.SlackBotToken),
+		"slack_app_token": redactSecret(config.SlackAppToken),
+		"admin_api_token": redactSecret(config.AdminAPIToken),
+		"slack_api_url":   config.SlackAPIURL,
+		"slack_proxy_url": redactProxyURL(config.SlackProxyURL),
+		"slack_files_url": config.SlackFilesURL,
+		"backend_url":     config.BackendURL,
+		"otel_endpoint":   config.OtelEndpoint,
+		"port":            config.Port,
+		"channel_config":  config.ChannelConfig,
+		"channels":        json.RawMessage(channels),
+		"warmup_requests": config.WarmupCount,
+		"workers":         config.Workers,
+		"min_workers":     minWorkers(),
+		"queue_size":      config.QueueSize,
+		"queue_overflow":  config.QueueOverflow,
+		"queue_block":     config.QueueBlockTimeout.String(),
+		"admin_users":     config.AdminUsers,
+		"ticket_provider": fmt.Sprintf("%T", ticketProvider),
+	}
+}
+
+func collectDiagStats() diagStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := diagStats{
+		Time:        time.Now(),
+		Uptime:      time.Since(startedAt).Round(time.Second).String(),
+		GoVersion:   runtime.Version(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   mem.HeapAlloc,
+		HeapObjects: mem.HeapObjects,
+		NumGC:       mem.NumGC,
+		Warmup:      warmup.snapshot(),
+	}
+	if workerPool != nil {
+		ps := workerPool.Stats()
+		stats.Pool = &ps
+	}
+	for _, p := range plugins {
+		stats.Plugins = append(stats.Plugins, p.manifest.Name)
+	}
+	return stats
+}
+
+// writeDiagBundle writes the bundle into dir and returns its path.
+func writeDiagBundle(dir string) (string, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	path := filepath.Join(dir, fmt.Sprintf("chatrelaybot-diag-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z")))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	var goroutines strings.Builder
+	pprof.Lookup("goroutine").WriteTo(&goroutines, 2)
+	configJSON, _ := json.MarshalIndent(configSnapshot(), "", "  ")
+	statsJSON, _ := json.MarshalIndent(collectDiagStats(), "", "  ")
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"goroutines.txt", []byte(goroutines.String())},
+		{"logs.txt", []byte(strings.Join(recentLogs.Lines(), "\n") + "\n")},
+		{"config.json", configJSON},
+		{"stats.json", statsJSON},
+	}
+	for _, file := range files {
+		hdr := &tar.Header{Name: file.name, Mode: 0o600, Size: int64(len(file.data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+func dumpDiagnosticsOnSignal(ctx context.Context) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigs:
+			path, err := writeDiagBundle(os.Getenv("DIAG_DIR"))
+			if err != nil {
+				slog.Error("Failed to write diagnostics bundle", "err", err)
+				continue
+			}
+			slog.Info("Diagnostics bundle written", "path", path)
+		}
+	}
+}
+
+func init() {
+	registerCommand("diag", command{
+		Admin: true,
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			path, err := writeDiagBundle(os.Getenv("DIAG_DIR"))
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to write diagnostics bundle", "err", err)
+				notifyUser(ctx, api, ev.Channel, ev.User, "Could not write the diagnostics bundle; see the logs.")
+				return
+			}
+			slog.InfoContext(ctx, "Diagnostics bundle written", "path", path)
+			notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Diagnostics bundle written to `%s` on the relay host.", path))
+		},
+	})
+}
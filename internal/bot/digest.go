@@ -0,0 +1,398 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Weekly Digest
+//
+// DIGEST_CHANNELS are summarized once a week into DIGEST_TARGET_CHANNEL.
+// Each channel's week of messages is read through slackReader, split into
+// chunks the backend can take, summarized chunk by chunk and reduced to one
+// summary per channel; the channel summaries are then combined into an
+// overview. The overview is posted to the target channel with each
+// channel's summary as a reply in its thread, and sent the same way as a DM
+// to each of DIGEST_RECIPIENTS. Digests run as low-priority backend work;
+// admins can start one with "!digest".
+//
+// The messages are rendered from a text/template with an "overview" and a
+// "channel" template; DIGEST_TEMPLATE can point at a file defining both to
+// replace the built-in layout. Dates and numbers are formatted for each
+// reader (see localefmt.go): the target channel uses its "locale" and
+// "timezone" settings and each DM recipient the locale and timezone of
+// their Slack profile.
+const (
+	digestPeriod          = 7 * 24 * time.Hour
+	maxDigestMessages     = 2000
+	digestChunkChars      = 8000
+	defaultDigestSchedule = "mon 09:00"
+
+	digestChunkInstruction    = "Summarize this excerpt of a week of Slack messages from one channel. List decisions, open questions and notable announcements as short bullets."
+	digestChannelInstruction  = "Combine these partial summaries of one Slack channel's week into a single summary of short bullets, removing duplicates."
+	digestOverviewInstruction = "Write a short weekly overview for leadership from these per-channel summaries. Lead with the most important decisions and risks, and mention the channel for each point."
+)
+
+const defaultDigestTemplate = `{{define "overview"}}*Weekly digest* for {{date .Since}} – {{date .Until}}
+
+{{.Overview}}{{end}}
+{{- define "channel"}}{{if .Err}}*<#{{.Channel}}>*: the summary could not be generated.
+{{- else if not .Messages}}*<#{{.Channel}}>*: no messages this week.
+{{- else}}*<#{{.Channel}}>* ({{number .Messages}} messages)
+{{.Summary}}{{end}}{{end}}`
+
+var digestTemplate = template.Must(parseDigestTemplate(defaultDigestTemplate))
+
+func parseDigestTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("digest").Funcs(newLocaleFormat("", nil).funcs()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"overview", "channel"} {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("digest template must define %q", name)
+		}
+	}
+	return tmpl, nil
+}
+
+func loadDigestTemplate(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	tmpl, err := parseDigestTemplate(string(data))
+	if err != nil {
+		return err
+	}
+	digestTemplate = tmpl
+	return nil
+}
+
+// digestView is what the digest templates render.
+type digestView struct {
+	Since    time.Time
+	Until    time.Time
+	Overview string
+}
+
+// renderDigest executes the named digest template for a reader.
+func renderDigest(f localeFormat, name string, data any) (string, error) {
+	tmpl, err := digestTemplate.Clone()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Funcs(f.funcs()).ExecuteTemplate(&b, name, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+type digestSchedule struct {
+	Weekday time.Weekday
+	Hour    int
+	Minute  int
+}
+
+type digestSettings struct {
+	Channels   []string
+	Target     string
+	Recipients []string
+	Schedule   digestSchedule
+}
+
+type digestRunner struct {
+	mu       sync.Mutex
+	settings digestSettings
+	lastRun  time.Time
+	running  bool
+}
+
+var digests = &digestRunner{}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseDigestSchedule reads "mon 09:00" (UTC).
+func parseDigestSchedule(spec string) (digestSchedule, error) {
+	if strings.TrimSpace(spec) == "" {
+		spec = defaultDigestSchedule
+	}
+	fields := strings.Fields(strings.ToLower(spec))
+	if len(fields) != 2 {
+		return digestSchedule{}, fmt.Errorf("invalid schedule %q (use e.g. \"mon 09:00\")", spec)
+	}
+	day, ok := weekdays[fields[0][:min(3, len(fields[0]))]]
+	if !ok {
+		return digestSchedule{}, fmt.Errorf("invalid weekday %q", fields[0])
+	}
+	t, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return digestSchedule{}, fmt.Errorf("invalid time %q", fields[1])
+	}
+	return digestSchedule{Weekday: day, Hour: t.Hour(), Minute: t.Minute()}, nil
+}
+
+// String formats s the way parseDigestSchedule reads it.
+func (s digestSchedule) String() string {
+	return fmt.Sprintf("%s %02d:%02d", strings.ToLower(s.Weekday.String()[:3]), s.Hour, s.Minute)
+}
+
+// latest returns the most recent scheduled time at or before now.
+func (s digestSchedule) latest(now time.Time) time.Time {
+	now = now.UTC()
+	at := time.Date(now.Year(), now.Month(), now.Day(), s.Hour, s.Minute, 0, 0, time.UTC)
+	at = at.AddDate(0, 0, -int((7+now.Weekday()-s.Weekday)%7))
+	if at.After(now) {
+		at = at.AddDate(0, 0, -7)
+	}
+	return at
+}
+
+func (d *digestRunner) configure(settings digestSettings) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.settings = settings
+	// The first scheduled digest is the next one, not one already missed.
+	d.lastRun = time.Now()
+}
+
+func (d *digestRunner) current() digestSettings {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.settings
+}
+
+// start claims a run; it fails when one is already in progress.
+func (d *digestRunner) start(now time.Time, scheduled bool) (digestSettings, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running || len(d.settings.Channels) == 0 || (d.settings.Target == "" && len(d.settings.Recipients) == 0) {
+		return digestSettings{}, false
+	}
+	if scheduled && !d.lastRun.Before(d.settings.Schedule.latest(now)) {
+		return digestSettings{}, false
+	}
+	d.running, d.lastRun = true, now
+	return d.settings, true
+}
+
+func (d *digestRunner) finish() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running = false
+}
+
+func runWeeklyDigests(ctx context.Context, api SlackClient) {
+	ctx = withQuerySource(ctx, SourceScheduled)
+	ticker := time.NewTicker(summaryCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !leadership.leads() {
+				continue
+			}
+			if settings, ok := digests.start(now, true); ok {
+				sendWeeklyDigest(ctx, api, settings, now)
+				digests.finish()
+			}
+		}
+	}
+}
+
+type channelDigest struct {
+	Channel  string
+	Messages int
+	Summary  string
+	Err      error
+}
+
+func sendWeeklyDigest(ctx context.Context, api SlackClient, settings digestSettings, now time.Time) {
+	ctx, span := otel.Tracer("bot").Start(withLowPriority(ctx), "weekly_digest")
+	defer span.End()
+	span.SetAttributes(attribute.Int("digest.channels", len(settings.Channels)), attribute.String("digest.target", settings.Target))
+
+	since := now.Add(-digestPeriod)
+	var results []channelDigest
+	var summaries []string
+	for _, channel := range settings.Channels {
+		d := summarizeChannelWeek(ctx, channel, since)
+		if d.Err != nil {
+			span.RecordError(d.Err)
+			slog.ErrorContext(ctx, "Digest failed", "channel", channel, "err", d.Err)
+		} else if d.Summary != "" {
+			summaries = append(summaries, fmt.Sprintf("Channel <#%s> (%d messages):\n%s", channel, d.Messages, d.Summary))
+		}
+		results = append(results, d)
+	}
+
+	overview := "No activity in the digest channels this week."
+	if len(summaries) > 0 {
+		text, err := requestAnswer(ctx, backend.ChatRequest{
+			UserID:                   "digest",
+			ChannelID:                settings.Target,
+			Query:                    strings.Join(summaries, "\n\n"),
+			Instruction:              digestOverviewInstruction,
+			DisableInternalRetrieval: true,
+		})
+		if err != nil || strings.TrimSpace(text) == "" {
+			span.RecordError(err)
+			overview = "The overview could not be generated; per-channel summaries are in the thread."
+		} else {
+			overview = text
+		}
+	}
+
+	view := digestView{Since: since, Until: now, Overview: overview}
+	if settings.Target != "" {
+		postDigest(ctx, api, settings.Target, "", localeFormatFor(settings.Target), view, results)
+	}
+	for _, user := range settings.Recipients {
+		ul := lookupUserLocale(ctx, api, user)
+		postDigest(ctx, api, user, user, newLocaleFormat(ul.Locale, ul.Location), view, results)
+	}
+	slog.InfoContext(ctx, "Weekly digest posted", "channels", len(settings.Channels), "target", settings.Target, "recipients", len(settings.Recipients))
+}
+
+// postDigest posts the overview to channel, or to user's DM, with each
+// channel's summary as a reply in its thread.
+func postDigest(ctx context.Context, api SlackClient, channel, user string, f localeFormat, view digestView, results []channelDigest) {
+	span := trace.SpanFromContext(ctx)
+	header, err := renderDigest(f, "overview", view)
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to render the weekly digest", "channel", channel, "err", err)
+		return
+	}
+	ts, err := sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: header})
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to post the weekly digest", "channel", channel, "err", err)
+		return
+	}
+	for _, d := range results {
+		text, err := renderDigest(f, "channel", d)
+		if err != nil {
+			span.RecordError(err)
+			continue
+		}
+		if _, err := sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: text}, slack.MsgOptionTS(ts)); err != nil {
+			span.RecordError(err)
+		}
+	}
+}
+
+// summarizeChannelWeek is the map step: chunk summaries reduced to one
+// channel summary.
+func summarizeChannelWeek(ctx context.Context, channel string, since time.Time) channelDigest {
+	d := channelDigest{Channel: channel}
+	if slackReader == nil {
+		d.Err = fmt.Errorf("Slack history is not available")
+		return d
+	}
+	msgs, err := slackReader.History(ctx, channel, since, maxDigestMessages)
+	if err != nil {
+		d.Err = err
+		return d
+	}
+	lines := digestLines(msgs)
+	d.Messages = len(lines)
+	if len(lines) == 0 {
+		return d
+	}
+
+	var partials []string
+	for _, chunk := range chunkDigestLines(lines, digestChunkChars) {
+		text, err := requestAnswer(ctx, backend.ChatRequest{UserID: "digest", ChannelID: channel, Query: chunk, Instruction: digestChunkInstruction, DisableInternalRetrieval: true})
+		if err != nil {
+			d.Err = err
+			return d
+		}
+		partials = append(partials, strings.TrimSpace(text))
+	}
+	if len(partials) == 1 {
+		d.Summary = partials[0]
+		return d
+	}
+	d.Summary, d.Err = requestAnswer(ctx, backend.ChatRequest{UserID: "digest", ChannelID: channel, Query: strings.Join(partials, "\n\n"), Instruction: digestChannelInstruction, DisableInternalRetrieval: true})
+	d.Summary = strings.TrimSpace(d.Summary)
+	return d
+}
+
+// digestLines turns history (newest first) into chronological transcript
+// lines, leaving out bots and channel events such as joins.
+func digestLines(msgs []slack.Message) []string {
+	var lines []string
+	for i := len(msgs) - 1; i >= 0; i-- {
+		m := msgs[i]
+		if m.SubType != "" || m.BotID != "" || strings.TrimSpace(m.Text) == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("<@%s>: %s", m.User, strings.TrimSpace(m.Text)))
+	}
+	return lines
+}
+
+// chunkDigestLines groups lines into chunks of at most limit characters; a
+// single longer line is truncated.
+func chunkDigestLines(lines []string, limit int) []string {
+	var chunks []string
+	var b strings.Builder
+	for _, line := range lines {
+		line = truncate(line, limit)
+		if b.Len() > 0 && b.Len()+len(line)+1 > limit {
+			chunks = append(chunks, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(line)
+	}
+	if b.Len() > 0 {
+		chunks = append(chunks, b.String())
+	}
+	return chunks
+}
+
+func init() {
+	registerCommand("digest", command{
+		Admin: true,
+		Usage: "(runs the weekly digest now)",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			settings, ok := digests.start(time.Now(), false)
+			if !ok {
+				notifyUser(ctx, api, ev.Channel, ev.User, "The digest is not configured or is already running.")
+				return
+			}
+			to := fmt.Sprintf("%d recipients", len(settings.Recipients))
+			if settings.Target != "" {
+				to = fmt.Sprintf("<#%s>", settings.Target)
+			}
+			notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Building the digest of %d channels for %s.", len(settings.Channels), to))
+			go func() {
+				defer digests.finish()
+				sendWeeklyDigest(context.WithoutCancel(ctx), api, settings, time.Now())
+			}()
+		},
+	})
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/slackfetch"
+	"github.com/slack-go/slack"
+)
+
+type channelHistoryAPI struct {
+	slackfetch.API
+	channels map[string][]slack.Message
+}
+
+func (h channelHistoryAPI) GetConversationHistoryContext(ctx context.Context, params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error) {
+	return &slack.GetConversationHistoryResponse{Messages: h.channels[params.ChannelID]}, nil
+}
+
+func digestMessage(user, text string) slack.Message {
+	m := slack.Message{}
+	m.User, m.Text = user, text
+	return m
+}
+
+func TestParseDigestSchedule(t *testing.T) {
+	s, err := parseDigestSchedule("Friday 16:30")
+	if err != nil || s != (digestSchedule{Weekday: time.Friday, Hour: 16, Minute: 30}) {
+		t.Fatalf("schedule = %+v, %v", s, err)
+	}
+	// Wednesday 2026-10-14 12:00 UTC: the latest Friday run was Oct 9.
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	if got := s.latest(now); !got.Equal(time.Date(2026, 10, 9, 16, 30, 0, 0, time.UTC)) {
+		t.Errorf("latest = %v", got)
+	}
+	for _, bad := range []string{"mon", "someday 09:00", "mon 25:00"} {
+		if _, err := parseDigestSchedule(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestWeeklyDigest_MapReduceAndThreads(t *testing.T) {
+	var mu sync.Mutex
+	var instructions []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		instructions = append(instructions, req.Instruction)
+		mu.Unlock()
+		if r.Header.Get("X-Request-Priority") != "low" {
+			t.Errorf("digest requests should be low priority")
+		}
+		full := "summary of " + req.ChannelID
+		switch req.Instruction {
+		case digestChannelInstruction:
+			full = "combined summary of " + req.ChannelID
+		case digestOverviewInstruction:
+			full = "overview of the week"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: full})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	long := strings.Repeat("x", digestChunkChars/3)
+	defer func(r *slackfetch.Fetcher) { slackReader = r }(slackReader)
+	slackReader = slackfetch.New(channelHistoryAPI{channels: map[string][]slack.Message{
+		"CENG":   {digestMessage("U2", long), digestMessage("U1", long), digestMessage("U1", long)},
+		"CSALES": {digestMessage("U3", "closed the deal")},
+	}}, slackfetch.Options{CacheTTL: -1})
+
+	api := &fakeSlackClient{}
+	settings := digestSettings{Channels: []string{"CENG", "CSALES", "CQUIET"}, Target: "CLEAD"}
+	sendWeeklyDigest(context.Background(), api, settings, time.Now())
+
+	posts := api.sent()
+	if len(posts) != 4 {
+		t.Fatalf("expected an overview and 3 thread replies, got %+v", posts)
+	}
+	if !strings.Contains(posts[0].Text(), "overview of the week") || posts[0].Channel != "CLEAD" {
+		t.Errorf("unexpected overview %q", posts[0].Text())
+	}
+	for i, want := range []string{"combined summary of CENG", "summary of CSALES", "no messages this week"} {
+		p := posts[i+1]
+		if p.Values.Get("thread_ts") == "" || !strings.Contains(p.Text(), want) {
+			t.Errorf("reply %d = %q (thread %q), want %q", i, p.Text(), p.Values.Get("thread_ts"), want)
+		}
+	}
+	// CENG needs two chunk summaries and a reduce step; CSALES one chunk.
+	mu.Lock()
+	defer mu.Unlock()
+	if len(instructions) != 5 || instructions[2] != digestChannelInstruction || instructions[4] != digestOverviewInstruction {
+		t.Errorf("unexpected backend calls %q", instructions)
+	}
+}
+
+func TestDigestRunner_RunsOncePerSchedule(t *testing.T) {
+	d := &digestRunner{}
+	d.configure(digestSettings{Channels: []string{"C1"}, Target: "CLEAD", Schedule: digestSchedule{Weekday: time.Monday, Hour: 9}})
+	d.lastRun = time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	monday := time.Date(2026, 10, 12, 9, 5, 0, 0, time.UTC)
+	if _, ok := d.start(monday, true); !ok {
+		t.Fatal("expected the digest to be due")
+	}
+	if _, ok := d.start(monday, false); ok {
+		t.Error("a second run should not start while one is running")
+	}
+	d.finish()
+	if _, ok := d.start(monday.Add(time.Hour), true); ok {
+		t.Error("the digest should run once per week")
+	}
+}
+
+func TestPostDigest_LocalizedForChannelAndRecipients(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CDE": {Locale: "de-DE", Timezone: "Europe/Berlin"}}})
+	defer setChannelSettings(channelSettings{})
+
+	view := digestView{Since: time.Date(2026, 2, 23, 23, 30, 0, 0, time.UTC), Until: time.Date(2026, 3, 2, 23, 30, 0, 0, time.UTC), Overview: "overview"}
+	results := []channelDigest{{Channel: "CENG", Messages: 1234, Summary: "busy week"}}
+	api := &fakeSlackClient{}
+	postDigest(context.Background(), api, "CDE", "", localeFormatFor("CDE"), view, results)
+	postDigest(context.Background(), api, "U1", "U1", newLocaleFormat("en-US", time.UTC), view, results)
+
+	posts := api.sent()
+	if len(posts) != 4 {
+		t.Fatalf("expected two overviews with one reply each, got %+v", posts)
+	}
+	// Berlin is an hour ahead, so both dates move to the next day.
+	if want := "*Weekly digest* for 24. Feb. – 3. März"; !strings.HasPrefix(posts[0].Text(), want) {
+		t.Errorf("German overview %q, want prefix %q", posts[0].Text(), want)
+	}
+	if want := "(1.234 messages)"; !strings.Contains(posts[1].Text(), want) {
+		t.Errorf("German reply %q, want %q", posts[1].Text(), want)
+	}
+	if posts[2].Channel != "U1" || !strings.HasPrefix(posts[2].Text(), "*Weekly digest* for Feb 23 – Mar 2") {
+		t.Errorf("DM overview %q to %s", posts[2].Text(), posts[2].Channel)
+	}
+	if !strings.Contains(posts[3].Text(), "(1,234 messages)") || posts[3].Values.Get("thread_ts") == "" {
+		t.Errorf("DM reply %q", posts[3].Text())
+	}
+}
+
+func TestParseDigestTemplate_RequiresBothParts(t *testing.T) {
+	if _, err := parseDigestTemplate(`{{define "overview"}}{{date .Since}}{{end}}`); err == nil {
+		t.Error("expected an error for a template without \"channel\"")
+	}
+}
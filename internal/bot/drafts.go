@@ -0,0 +1,212 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Answer Drafts
+//
+// Channels with "drafts" set to 2 or 3 don't answer right away: the
+// backend writes that many candidate answers in parallel, and the asker
+// sees them in an ephemeral message with a "Publish this one" button under
+// each. The chosen draft is posted in the thread like a normal answer
+// (with the channel's disclaimer), and only then recorded, so follow-ups
+// and feedback see the published wording. Drafts vary by temperature
+// unless the channel fixes one. Review mode and private DMs ignore the
+// setting. Offered, published and discarded drafts are counted under
+// "answer_drafts" on /debug/vars.
+const (
+	ActionDraftPublish = "draft_publish"
+	ActionDraftDiscard = "draft_discard"
+
+	maxDrafts         = 3
+	draftPreviewChars = 2800
+)
+
+var errNoDrafts = errors.New("no draft could be written")
+
+// draftTemperatures spread the drafts when the channel sets no temperature.
+var draftTemperatures = []float64{0.4, 0.8, 1.2}
+
+type pendingDrafts struct {
+	ID         string
+	RequestID  string
+	Channel    string
+	User       string
+	ThreadTS   string
+	Query      string
+	QueryTS    string
+	Model      string
+	Disclaimer string
+	Drafts     []string
+	Options    []slack.MsgOption
+}
+
+type draftQueue struct {
+	mu      sync.Mutex
+	pending map[string]*pendingDrafts
+}
+
+var (
+	drafts            = &draftQueue{pending: make(map[string]*pendingDrafts)}
+	metricAnswerDraft = expvar.NewMap("answer_drafts")
+)
+
+func (q *draftQueue) add(d *pendingDrafts) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending[d.ID] = d
+}
+
+func (q *draftQueue) get(id string) (*pendingDrafts, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d, ok := q.pending[id]
+	return d, ok
+}
+
+// take removes the drafts so that only the first choice is applied.
+func (q *draftQueue) take(id string) (*pendingDrafts, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d, ok := q.pending[id]
+	if ok {
+		delete(q.pending, id)
+	}
+	return d, ok
+}
+
+func validateDrafts(n int) error {
+	if n < 0 || n == 1 || n > maxDrafts {
+		return fmt.Errorf("drafts must be 0 (off), 2 or %d, not %d", maxDrafts, n)
+	}
+	return nil
+}
+
+// offerDrafts asks the backend for cc.Drafts answers to chatReq and shows
+// them to the asker.
+func offerDrafts(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, query string, chatReq backend.ChatRequest, cc ChannelConfig, replyOptions ...slack.MsgOption) error {
+	n := min(cc.Drafts, maxDrafts)
+	ctx, span := otel.Tracer("bot").Start(ctx, "answer_drafts")
+	defer span.End()
+	span.SetAttributes(attribute.Int("drafts.requested", n))
+
+	answers := make([]string, n)
+	runParallel(workerPool, n, func(i int) {
+		req := chatReq
+		if req.Temperature == nil {
+			req.Temperature = &draftTemperatures[i]
+		}
+		answer, err := requestAnswer(ctx, req)
+		if err != nil {
+			span.RecordError(err)
+			slog.ErrorContext(ctx, "Failed to write draft", "draft", i+1, "of", n, "err", err)
+			return
+		}
+		answers[i] = strings.TrimSpace(answer)
+	})
+	d := &pendingDrafts{ID: newID(), RequestID: conversationIDFrom(ctx), Channel: ev.Channel, User: ev.User, ThreadTS: ev.ThreadTimeStamp,
+		Query: query, QueryTS: ev.TimeStamp, Model: chatReq.Model, Disclaimer: cc.Disclaimer, Options: replyOptions}
+	for _, a := range answers {
+		if a != "" {
+			d.Drafts = append(d.Drafts, filterText(ctx, ev.Channel, ev.User, a))
+		}
+	}
+	span.SetAttributes(attribute.Int("drafts.written", len(d.Drafts)))
+	if len(d.Drafts) == 0 {
+		return errNoDrafts
+	}
+	drafts.add(d)
+	requests.record(ctx, "drafts_offered", fmt.Sprintf("%d of %d drafts", len(d.Drafts), n))
+	metricAnswerDraft.Add("offered", 1)
+
+	options := append([]slack.MsgOption{slack.MsgOptionBlocks(draftBlocks(d)...), slack.MsgOptionPostEphemeral(ev.User)}, replyOptions...)
+	if _, err := sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: "Choose a draft to publish"}, options...); err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to show drafts", "drafts", d.ID, "err", err)
+	}
+	return nil
+}
+
+func draftBlocks(d *pendingDrafts) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("*Choose an answer to publish* (only you can see these drafts)\n*You asked:* %s", truncate(d.Query, 300)), false, false), nil, nil),
+	}
+	for i, draft := range d.Drafts {
+		value := d.ID + ":" + strconv.Itoa(i)
+		blocks = append(blocks,
+			slack.NewDividerBlock(),
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("*Draft %d*\n%s", i+1, truncate(draft, draftPreviewChars)), false, false), nil,
+				slack.NewAccessory(slack.NewButtonBlockElement(ActionDraftPublish, value, slack.NewTextBlockObject(slack.PlainTextType, "Publish this one", false, false)).WithStyle(slack.StylePrimary))),
+		)
+	}
+	return append(blocks, slack.NewActionBlock("drafts_"+d.ID,
+		slack.NewButtonBlockElement(ActionDraftDiscard, d.ID, slack.NewTextBlockObject(slack.PlainTextType, "Discard all", false, false)),
+	))
+}
+
+func handleDraftAction(ctx context.Context, api SlackClient, callback slack.InteractionCallback, action *slack.BlockAction) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "draft_choice")
+	defer span.End()
+
+	id, index, _ := strings.Cut(action.Value, ":")
+	user := callback.User.ID
+	span.SetAttributes(attribute.String("drafts.id", id), attribute.String("user.id", user), attribute.String("drafts.action", action.ActionID))
+
+	d, ok := drafts.get(id)
+	if !ok {
+		notifyUser(ctx, api, callback.Channel.ID, user, "These drafts were already handled.")
+		return
+	}
+	if user != d.User {
+		notifyUser(ctx, api, callback.Channel.ID, user, "Only the person who asked can publish a draft.")
+		return
+	}
+	i, err := strconv.Atoi(index)
+	if action.ActionID == ActionDraftPublish && (err != nil || i < 0 || i >= len(d.Drafts)) {
+		return
+	}
+	if d, ok = drafts.take(id); !ok {
+		return
+	}
+	ctx = withConversationID(ctx, d.RequestID)
+	confirm := "Drafts discarded; nothing was published."
+
+	if action.ActionID == ActionDraftPublish {
+		rec := &ConversationRecord{ID: d.RequestID, Channel: d.Channel, ThreadTS: d.ThreadTS, User: d.User, Query: d.Query, QueryTS: d.QueryTS, Model: d.Model}
+		if ts, err := sendAnswer(ctx, api, d.Channel, d.User, d.Drafts[i], d.Options...); err == nil {
+			rec.MessageTS = append(rec.MessageTS, ts)
+			rec.AnswerTS = append(rec.AnswerTS, ts)
+			rec.Answer = append(rec.Answer, d.Drafts[i])
+		} else {
+			span.RecordError(err)
+		}
+		if d.Disclaimer != "" && len(rec.Answer) > 0 {
+			sendMessage(ctx, api, outgoingMessage{Channel: d.Channel, User: d.User, Text: d.Disclaimer}, d.Options...)
+		}
+		finishConversation(ctx, api, rec)
+		span.SetAttributes(attribute.Int("drafts.published", i+1))
+		metricAnswerDraft.Add("published", 1)
+		confirm = fmt.Sprintf("Draft %d published.", i+1)
+	} else {
+		metricAnswerDraft.Add("discarded", 1)
+	}
+	slog.InfoContext(ctx, "Drafts resolved", "drafts", d.ID, "result", confirm)
+	if callback.ResponseURL != "" {
+		// Replaces the ephemeral drafts so they can't be chosen twice.
+		sendMessage(ctx, api, outgoingMessage{Channel: d.Channel, User: user, Text: confirm}, slack.MsgOptionReplaceOriginal(callback.ResponseURL))
+	}
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestProcessTask_DraftModeOffersAndPublishes(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CDRAFT": {Drafts: 2}}})
+	defer setChannelSettings(channelSettings{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: fmt.Sprintf("answer at %.1f", *req.Temperature)})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CDRAFT", TimeStamp: "1.0"}, "how do refunds work?")
+
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Values.Get("user") != "U1" || !strings.Contains(posts[0].Values.Get("blocks"), "Publish this one") {
+		t.Fatalf("expected one ephemeral drafts message, got %+v", posts)
+	}
+	var pending *pendingDrafts
+	drafts.mu.Lock()
+	for _, d := range drafts.pending {
+		if d.Channel == "CDRAFT" {
+			pending = d
+		}
+	}
+	drafts.mu.Unlock()
+	if pending == nil || len(pending.Drafts) != 2 || pending.Drafts[0] == pending.Drafts[1] {
+		t.Fatalf("expected 2 distinct drafts, got %+v", pending)
+	}
+
+	handleInteraction(context.Background(), api, reviewCallback("U2", ActionDraftPublish, pending.ID+":1"))
+	for _, p := range api.sent() {
+		if p.Channel == "CDRAFT" && p.Values.Get("user") == "" {
+			t.Fatal("only the asker may publish a draft")
+		}
+	}
+
+	handleInteraction(context.Background(), api, reviewCallback("U1", ActionDraftPublish, pending.ID+":1"))
+	var published []string
+	for _, p := range api.sent()[1:] {
+		if p.Channel == "CDRAFT" && p.Values.Get("user") == "" {
+			published = append(published, p.Values.Get("text"))
+		}
+	}
+	if len(published) != 1 || published[0] != pending.Drafts[1] {
+		t.Errorf("published %q, want draft 2 %q", published, pending.Drafts[1])
+	}
+	if _, ok := drafts.get(pending.ID); ok {
+		t.Error("drafts should be removed once one is published")
+	}
+}
+
+func TestValidateDrafts(t *testing.T) {
+	for n, ok := range map[int]bool{0: true, 1: false, 2: true, 3: true, 4: false, -1: false} {
+		if err := validateDrafts(n); (err == nil) != ok {
+			t.Errorf("validateDrafts(%d) = %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Emoji Policy
+//
+// Channels choose how much emoji answers may carry: "any" leaves answers
+// alone, "custom" keeps only the workspace's custom :shortcodes:, and "none"
+// strips shortcodes and Unicode emoji alike. Code spans are never touched.
+// Each workspace's custom emoji are listed and cached on their own.
+const (
+	EmojiAny    = "any"
+	EmojiCustom = "custom"
+	EmojiNone   = "none"
+
+	customEmojiTTL = 30 * time.Minute
+)
+
+func validateEmojiPolicy(policy string) error {
+	switch policy {
+	case "", EmojiAny, EmojiCustom, EmojiNone:
+		return nil
+	}
+	return fmt.Errorf("unknown emoji_policy %q", policy)
+}
+
+type emojiList struct {
+	names   map[string]bool
+	fetched time.Time
+}
+
+// customEmoji caches each workspace's custom emoji by team ID, "" for
+// events without one.
+var customEmoji = struct {
+	sync.Mutex
+	teams map[string]*emojiList
+}{teams: make(map[string]*emojiList)}
+
+// workspaceCustomEmoji returns the custom emoji of the workspace in ctx,
+// listed with api, that workspace's client.
+func workspaceCustomEmoji(ctx context.Context, api SlackClient) map[string]bool {
+	team := workspaceFrom(ctx)
+	customEmoji.Lock()
+	defer customEmoji.Unlock()
+	cached := customEmoji.teams[team]
+	if cached != nil && time.Since(cached.fetched) < customEmojiTTL {
+		return cached.names
+	}
+	list, err := api.GetEmojiContext(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list custom emoji", "workspace", team, "err", err)
+		if cached == nil {
+			return nil
+		}
+		return cached.names
+	}
+	names := make(map[string]bool, len(list))
+	for name := range list {
+		names[name] = true
+	}
+	customEmoji.teams[team] = &emojiList{names: names, fetched: time.Now()}
+	return names
+}
+
+var (
+	shortcodePattern = regexp.MustCompile(`:[a-z0-9_+'-]*[a-z][a-z0-9_+'-]*:`)
+	extraSpace       = regexp.MustCompile(`[ \t]{2,}`)
+)
+
+func isEmojiRune(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF, // pictographs, emoticons, flags
+		r >= 0x2600 && r <= 0x27BF,   // misc symbols, dingbats
+		r >= 0x2B05 && r <= 0x2B55,   // arrows, stars, circles
+		r >= 0xE0020 && r <= 0xE007F, // tag sequences
+		r == 0xFE0F, r == 0x20E3:     // presentation selector, keycap
+		return true
+	}
+	return false
+}
+
+// stripUnicodeEmoji removes emoji runes, and joiners inside emoji sequences.
+func stripUnicodeEmoji(s string) string {
+	var b strings.Builder
+	prevEmoji := false
+	for _, r := range s {
+		if isEmojiRune(r) || (r == 0x200D && prevEmoji) {
+			prevEmoji = true
+			continue
+		}
+		prevEmoji = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func applyEmojiPolicy(text, policy string, custom map[string]bool) string {
+	if policy == "" || policy == EmojiAny {
+		return text
+	}
+	out := outsideCode(text, func(s string) string {
+		stripped := shortcodePattern.ReplaceAllStringFunc(s, func(code string) string {
+			if policy == EmojiCustom && custom[strings.Trim(code, ":")] {
+				return code
+			}
+			return ""
+		})
+		stripped = stripUnicodeEmoji(stripped)
+		if stripped == s {
+			return s
+		}
+		lines := strings.Split(extraSpace.ReplaceAllString(stripped, " "), "\n")
+		// The last line continues into the next code span, so keep its spacing.
+		for i := 0; i < len(lines)-1; i++ {
+			lines[i] = strings.TrimRight(lines[i], " \t")
+		}
+		return strings.Join(lines, "\n")
+	})
+	if out == text {
+		return text
+	}
+	return strings.TrimRight(out, " \t")
+}
+
+func enforceEmojiPolicy(ctx context.Context, api SlackClient, channel, text string) string {
+	policy := effectiveChannelConfig(ctx, api, channel).EmojiPolicy
+	var custom map[string]bool
+	if policy == EmojiCustom {
+		custom = workspaceCustomEmoji(ctx, api)
+	}
+	return applyEmojiPolicy(text, policy, custom)
+}
@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"testing"
+)
+
+func TestApplyEmojiPolicy(t *testing.T) {
+	custom := map[string]bool{"shipit": true}
+	text := "Done :tada: :shipit: 🚀 at 10:30:00 👩‍💻 `:tada:`"
+
+	if got := applyEmojiPolicy(text, EmojiAny, custom); got != text {
+		t.Errorf("any: got %q", got)
+	}
+	if got := applyEmojiPolicy(text, EmojiCustom, custom); got != "Done :shipit: at 10:30:00 `:tada:`" {
+		t.Errorf("custom: got %q", got)
+	}
+	if got := applyEmojiPolicy(text, EmojiNone, custom); got != "Done at 10:30:00 `:tada:`" {
+		t.Errorf("none: got %q", got)
+	}
+	if got := applyEmojiPolicy("Ready ✅\nNext", EmojiNone, nil); got != "Ready\nNext" {
+		t.Errorf("none: got %q", got)
+	}
+}
+
+func TestEnforceEmojiPolicy_ExternalChannels(t *testing.T) {
+	setChannelSettings(channelSettings{
+		Default:  ChannelConfig{EmojiPolicy: EmojiCustom},
+		External: ExternalPolicy{EmojiPolicy: EmojiNone},
+	})
+	defer setChannelSettings(channelSettings{})
+	channelInfoCache.Delete("CEMOJIEXT")
+	defer channelInfoCache.Delete("CEMOJIEXT")
+
+	api := &fakeSlackClient{external: map[string]bool{"CEMOJIEXT": true}}
+	if got := enforceEmojiPolicy(context.Background(), api, "CEMOJIINT", "Go :shipit: :tada:"); got != "Go :shipit:" {
+		t.Errorf("internal channel: got %q", got)
+	}
+	if got := enforceEmojiPolicy(context.Background(), api, "CEMOJIEXT", "Go :shipit: :tada:"); got != "Go" {
+		t.Errorf("external channel: got %q", got)
+	}
+}
+
+// teamEmojiClient lists its own workspace's custom emoji.
+type teamEmojiClient struct {
+	*fakeSlackClient
+	emoji map[string]string
+}
+
+func (c teamEmojiClient) GetEmojiContext(ctx context.Context) (map[string]string, error) {
+	return c.emoji, nil
+}
+
+func TestEnforceEmojiPolicy_CustomEmojiPerWorkspace(t *testing.T) {
+	setChannelSettings(channelSettings{Default: ChannelConfig{EmojiPolicy: EmojiCustom}})
+	defer setChannelSettings(channelSettings{})
+
+	acme := teamEmojiClient{&fakeSlackClient{}, map[string]string{"acme": "https://emoji.example.com/acme.png"}}
+	globex := teamEmojiClient{&fakeSlackClient{}, map[string]string{"globex": "https://emoji.example.com/globex.png"}}
+	text := "Hi :acme: :globex:"
+	if got := enforceEmojiPolicy(withWorkspace(context.Background(), "TACME"), acme, "CEMOJI", text); got != "Hi :acme:" {
+		t.Errorf("acme: got %q", got)
+	}
+	if got := enforceEmojiPolicy(withWorkspace(context.Background(), "TGLOBEX"), globex, "CEMOJI", text); got != "Hi :globex:" {
+		t.Errorf("globex: got %q", got)
+	}
+}
+
+func TestValidateEmojiPolicy(t *testing.T) {
+	if err := validateEmojiPolicy("some"); err == nil {
+		t.Error("expected unknown policy to be rejected")
+	}
+}
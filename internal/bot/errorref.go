@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Error References
+//
+// Error messages posted to users end with a short reference code, such as
+// "ref `7F3A-91C2`", taken from the first eight hex digits of the request's
+// trace ID (or its request ID when tracing is off). The code is logged and
+// recorded on the request's timeline, and admins paste it into "!trace" to
+// get the timeline, the trace link and the matching recent log lines, so a
+// user's screenshot leads straight to what happened.
+var refCodePattern = regexp.MustCompile(`^[0-9A-Fa-f]{4}-?[0-9A-Fa-f]{4}$`)
+
+// refCode formats the start of a hex ID as a reference code.
+func refCode(id string) string {
+	if len(id) < 8 || strings.Trim(id, "0") == "" {
+		return ""
+	}
+	id = strings.ToUpper(id[:8])
+	return id[:4] + "-" + id[4:]
+}
+
+// normalizeRefCode returns key as a reference code, if it is one.
+func normalizeRefCode(key string) (string, bool) {
+	if !refCodePattern.MatchString(key) {
+		return "", false
+	}
+	key = strings.ToUpper(strings.ReplaceAll(key, "-", ""))
+	return key[:4] + "-" + key[4:], true
+}
+
+// errorReference returns the reference code for the request in ctx.
+func errorReference(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return refCode(sc.TraceID().String())
+	}
+	return refCode(conversationIDFrom(ctx))
+}
+
+// withErrorReference appends the request's reference code to an error
+// message for the user, logging and recording it.
+func withErrorReference(ctx context.Context, text string) string {
+	code := errorReference(ctx)
+	if code == "" {
+		return text
+	}
+	slog.ErrorContext(ctx, "Error reference", "code", code, "message", text)
+	requests.record(ctx, "error_ref", code)
+	return fmt.Sprintf("%s (ref `%s`)", text, code)
+}
+
+// findByRefCode returns the newest timeline whose trace or request ID gives
+// code.
+func (t *requestTracker) findByRefCode(code string) (RequestTimeline, bool) {
+	t.mu.Lock()
+	var id string
+	for i := len(t.order) - 1; i >= 0; i-- {
+		if tl := t.byID[t.order[i]]; refCode(tl.TraceID) == code || refCode(tl.ID) == code {
+			id = tl.ID
+			break
+		}
+	}
+	t.mu.Unlock()
+	if id == "" {
+		return RequestTimeline{}, false
+	}
+	return t.find(id)
+}
+
+// logsForRefCode returns the recent log lines of the trace code came from.
+func logsForRefCode(code string) []LogEntry {
+	prefix := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	var out []LogEntry
+	for _, e := range recentLogs.Entries() {
+		if strings.HasPrefix(e.TraceID, prefix) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func formatRefLogs(entries []LogEntry, limit int) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	var b strings.Builder
+	b.WriteString("\n*Logs:*\n```\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s %-5s %s\n", e.Time.Format("15:04:05.000"), e.Level, truncate(e.Message, 200))
+	}
+	b.WriteString("```")
+	return b.String()
+}
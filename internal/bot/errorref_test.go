@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestRefCode(t *testing.T) {
+	if got := refCode("7f3a91c2deadbeef"); got != "7F3A-91C2" {
+		t.Errorf("refCode = %q", got)
+	}
+	if refCode("00000000000000000000000000000000") != "" || refCode("abc") != "" {
+		t.Error("zero and short IDs have no code")
+	}
+	for _, key := range []string{"7F3A-91C2", "7f3a91c2", "`7f3a-91c2`"} {
+		if code, ok := normalizeRefCode(strings.Trim(key, "`")); !ok || code != "7F3A-91C2" {
+			t.Errorf("normalizeRefCode(%q) = %q, %v", key, code, ok)
+		}
+	}
+	if _, ok := normalizeRefCode("7f3a91c2deadbeef"); ok {
+		t.Error("a request ID is not a reference code")
+	}
+}
+
+func TestErrorReference_FindsRequest(t *testing.T) {
+	defer func(d time.Duration, mode string) { postInterval, config.StreamValidation = d, mode }(postInterval, config.StreamValidation)
+	postInterval = 0
+	config.StreamValidation = StreamValidationStrict
+	config.AdminUsers = []string{"UADMIN"}
+	defer func() { config.AdminUsers = nil }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"event\":\"bogus\"}\n\n")
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	ctx := withRequestID(context.Background())
+	processTask(ctx, api, slackevents.AppMentionEvent{User: "U1", Channel: "CREF"}, "q")
+	posts := api.sent()
+	code := refCode(conversationIDFrom(ctx))
+	if len(posts) != 1 || !strings.HasSuffix(posts[0].Text(), "(ref `"+code+"`)") {
+		t.Fatalf("expected the error to end with ref %s, got %+v", code, posts)
+	}
+
+	dispatchCommand(context.Background(), api, slackevents.AppMentionEvent{User: "UADMIN", Channel: "CREF"}, "!trace "+strings.ToLower(code))
+	reply := api.sent()[len(api.sent())-1].Text()
+	if !strings.Contains(reply, conversationIDFrom(ctx)) || !strings.Contains(reply, "error_ref") {
+		t.Errorf("reference lookup should show the request timeline:\n%s", reply)
+	}
+}
+
+func TestTraceCommand_RefCodeFallsBackToLogs(t *testing.T) {
+	config.AdminUsers = []string{"UADMIN"}
+	defer func() { config.AdminUsers = nil }()
+	recentLogs.Write([]byte("2026/03/10 12:00:00 [trace_id=c0ffee00112233445566778899aabbcc span_id=0011223344556677] Failed to reach backend\n"))
+
+	api := &fakeSlackClient{}
+	dispatchCommand(context.Background(), api, slackevents.AppMentionEvent{User: "UADMIN", Channel: "CREF"}, "!trace C0FF-EE00")
+	reply := api.sent()[0].Text()
+	if !strings.Contains(reply, "matches no tracked request") || !strings.Contains(reply, "Failed to reach backend") {
+		t.Errorf("expected the trace's log lines, got:\n%s", reply)
+	}
+}
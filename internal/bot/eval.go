@@ -0,0 +1,344 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Evaluation Sampling
+//
+// EVAL_SAMPLE_PERCENT of answered questions (0 by default) are copied, with
+// personal data and secrets redacted, into an evaluation set. Admins label
+// samples good, bad or needs-source from the bot's Home tab, and
+// /admin/eval returns the samples with a per-model label report so answer
+// quality can be compared across the models offered by "Ask with…". With
+// EVAL_FILE set the set survives restarts.
+const (
+	EvalGood        = "good"
+	EvalBad         = "bad"
+	EvalNeedsSource = "needs_source"
+
+	ActionEvalGood        = "eval_good"
+	ActionEvalBad         = "eval_bad"
+	ActionEvalNeedsSource = "eval_needs_source"
+
+	evalHomeLimit   = 10
+	evalPreviewSize = 1200
+)
+
+var evalActionLabels = map[string]string{
+	ActionEvalGood:        EvalGood,
+	ActionEvalBad:         EvalBad,
+	ActionEvalNeedsSource: EvalNeedsSource,
+}
+
+type EvalSample struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	Channel        string    `json:"channel"`
+	Model          string    `json:"model,omitempty"`
+	Query          string    `json:"query"`
+	Answer         string    `json:"answer"`
+	SampledAt      time.Time `json:"sampled_at"`
+	Label          string    `json:"label,omitempty"`
+	LabeledBy      string    `json:"labeled_by,omitempty"`
+	LabeledAt      time.Time `json:"labeled_at,omitempty"`
+}
+
+type evalStore struct {
+	mu      sync.Mutex
+	path    string
+	percent float64
+	samples map[string]*EvalSample
+}
+
+func newEvalStore() *evalStore {
+	return &evalStore{samples: make(map[string]*EvalSample)}
+}
+
+var evals = newEvalStore()
+
+// evalRoll returns a number in [0, 100); tests replace it.
+var evalRoll = func() float64 { return rand.Float64() * 100 }
+
+func (s *evalStore) configure(percent float64) {
+	s.mu.Lock()
+	s.percent = percent
+	s.mu.Unlock()
+}
+
+// load reads a persisted evaluation set; a missing file is an empty set.
+func (s *evalStore) load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var samples []*EvalSample
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		s.samples[sample.ID] = sample
+	}
+	return nil
+}
+
+// saveLocked writes the set atomically; callers hold s.mu.
+func (s *evalStore) saveLocked() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.Marshal(s.listLocked())
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(context.Background(), "Failed to save evaluation set", "err", err)
+	}
+}
+
+func (s *evalStore) listLocked() []EvalSample {
+	out := make([]EvalSample, 0, len(s.samples))
+	for _, sample := range s.samples {
+		out = append(out, *sample)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SampledAt.Before(out[j].SampledAt) })
+	return out
+}
+
+func (s *evalStore) list() []EvalSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listLocked()
+}
+
+// sample copies a redacted answered conversation into the set at the
+// configured rate and reports whether it did.
+func (s *evalStore) sample(rec *ConversationRecord) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.percent <= 0 || evalRoll() >= s.percent {
+		return false
+	}
+	sample := &EvalSample{
+		ID:             newID(),
+		ConversationID: rec.ID,
+		Channel:        rec.Channel,
+		Model:          rec.Model,
+		Query:          redactForEval(rec.Query),
+		Answer:         redactForEval(rec.AnswerText()),
+		SampledAt:      time.Now(),
+	}
+	s.samples[sample.ID] = sample
+	s.saveLocked()
+	return true
+}
+
+// forget drops the samples taken from a conversation.
+func (s *evalStore) forget(conversationID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sample := range s.samples {
+		if sample.ConversationID == conversationID {
+			delete(s.samples, id)
+		}
+	}
+	s.saveLocked()
+}
+
+// label labels a sample and returns it.
+func (s *evalStore) label(id, label, by string) (EvalSample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sample, ok := s.samples[id]
+	if !ok {
+		return EvalSample{}, fmt.Errorf("no evaluation sample %s", id)
+	}
+	sample.Label, sample.LabeledBy, sample.LabeledAt = label, by, time.Now()
+	s.saveLocked()
+	return *sample, nil
+}
+
+// unlabeled returns up to limit samples still waiting for a label, oldest
+// first, and how many are waiting in total.
+func (s *evalStore) unlabeled(limit int) ([]EvalSample, int) {
+	var out []EvalSample
+	total := 0
+	for _, sample := range s.list() {
+		if sample.Label != "" {
+			continue
+		}
+		total++
+		if len(out) < limit {
+			out = append(out, sample)
+		}
+	}
+	return out, total
+}
+
+type EvalModelReport struct {
+	Model       string `json:"model"`
+	Good        int    `json:"good"`
+	Bad         int    `json:"bad"`
+	NeedsSource int    `json:"needs_source"`
+	Unlabeled   int    `json:"unlabeled"`
+}
+
+// report counts labels per model; answers from the default model are
+// reported as "default".
+func (s *evalStore) report() []EvalModelReport {
+	byModel := map[string]*EvalModelReport{}
+	for _, sample := range s.list() {
+		model := sample.Model
+		if model == "" {
+			model = "default"
+		}
+		r, ok := byModel[model]
+		if !ok {
+			r = &EvalModelReport{Model: model}
+			byModel[model] = r
+		}
+		switch sample.Label {
+		case EvalGood:
+			r.Good++
+		case EvalBad:
+			r.Bad++
+		case EvalNeedsSource:
+			r.NeedsSource++
+		default:
+			r.Unlabeled++
+		}
+	}
+	out := make([]EvalModelReport, 0, len(byModel))
+	for _, r := range byModel {
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Model < out[j].Model })
+	return out
+}
+
+var evalRedactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`xox[abpre]-[A-Za-z0-9-]+`), "[secret]"},
+	{regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]+=*`), "Bearer [secret]"},
+	{regexp.MustCompile(`(?i)\b(token|secret|password|api[_-]?key)(\s*[:=]\s*)\S+`), "$1$2[secret]"},
+	{regexp.MustCompile(`<@[UW][A-Z0-9]+(\|[^>]*)?>`), "[user]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[email]"},
+	{regexp.MustCompile(`\+?\d[\d ().-]{7,}\d`), "[number]"},
+}
+
+// redactForEval removes Slack tokens, credentials, user mentions, email
+// addresses and long numbers (phone and card numbers) from sampled text.
+func redactForEval(text string) string {
+	for _, r := range evalRedactions {
+		text = r.pattern.ReplaceAllString(text, r.replacement)
+	}
+	return text
+}
+
+func evalHomeView(report []EvalModelReport, samples []EvalSample, waiting int) slack.HomeTabViewRequest {
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Evaluation set", false, false)),
+	}
+	if len(report) == 0 {
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType,
+			"No answers have been sampled yet. Set `EVAL_SAMPLE_PERCENT` to start collecting them.", false, false), nil, nil))
+		return slack.HomeTabViewRequest{Type: slack.VTHomeTab, Blocks: slack.Blocks{BlockSet: blocks}}
+	}
+	var summary string
+	for _, r := range report {
+		summary += fmt.Sprintf("*%s*: %d good, %d bad, %d needs source, %d unlabeled\n", r.Model, r.Good, r.Bad, r.NeedsSource, r.Unlabeled)
+	}
+	blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, summary, false, false), nil, nil))
+	if waiting > len(samples) {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("Showing the oldest %d of %d unlabeled samples.", len(samples), waiting), false, false)))
+	}
+	for _, sample := range samples {
+		answer := truncate(sample.Answer, evalPreviewSize)
+		model := sample.Model
+		if model == "" {
+			model = "default"
+		}
+		button := func(action, text string, style slack.Style) *slack.ButtonBlockElement {
+			b := slack.NewButtonBlockElement(action, sample.ID, slack.NewTextBlockObject(slack.PlainTextType, text, false, false))
+			b.Style = style
+			return b
+		}
+		blocks = append(blocks,
+			slack.NewDividerBlock(),
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("*Q:* %s\n*A:* %s", truncate(sample.Query, evalPreviewSize/2), answer), false, false), nil, nil),
+			slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType,
+				fmt.Sprintf("%s · <#%s> · sampled %s", model, sample.Channel, sample.SampledAt.UTC().Format("2006-01-02 15:04 UTC")), false, false)),
+			slack.NewActionBlock("eval_"+sample.ID,
+				button(ActionEvalGood, "Good", slack.StylePrimary),
+				button(ActionEvalBad, "Bad", slack.StyleDanger),
+				button(ActionEvalNeedsSource, "Needs source", slack.StyleDefault),
+			),
+		)
+	}
+	return slack.HomeTabViewRequest{Type: slack.VTHomeTab, Blocks: slack.Blocks{BlockSet: blocks}}
+}
+
+// publishEvalHome shows the labelling queue on an admin's Home tab.
+func publishEvalHome(ctx context.Context, api SlackClient, user string) {
+	if !isAdmin(user) {
+		return
+	}
+	samples, waiting := evals.unlabeled(evalHomeLimit)
+	if _, err := api.PublishViewContext(ctx, user, evalHomeView(evals.report(), samples, waiting), ""); err != nil {
+		slog.ErrorContext(ctx, "Failed to publish Home tab", "user", user, "err", err)
+	}
+}
+
+func handleEvalLabel(ctx context.Context, api SlackClient, callback slack.InteractionCallback, action *slack.BlockAction) {
+	if !isAdmin(callback.User.ID) {
+		return
+	}
+	sample, err := evals.label(action.Value, evalActionLabels[action.ActionID], callback.User.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to label evaluation sample", "err", err)
+	} else {
+		conversations.AddFeedback(sample.ConversationID, AnswerFeedback{By: sample.LabeledBy, Rating: sample.Label, Source: "eval"})
+		emitWebhook(withConversationID(ctx, sample.ConversationID), EventFeedbackReceived, sample.Channel, "", "", map[string]any{
+			"source": "eval", "rating": sample.Label, "by": sample.LabeledBy, "model": sample.Model,
+		})
+	}
+	publishEvalHome(ctx, api, callback.User.ID)
+}
+
+// adminEvalHandler returns the per-model report and every sample.
+func adminEvalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Report  []EvalModelReport `json:"report"`
+		Samples []EvalSample      `json:"samples"`
+	}{evals.report(), evals.list()})
+}
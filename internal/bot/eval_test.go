@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestRedactForEval(t *testing.T) {
+	in := "Ask <@U12345|jo> at jo.smith@example.com or +1 (555) 010-9999, card 4111 1111 1111 1111. password: hunter2, Authorization: Bearer abc.def, xoxb-123-abc. Pi is 3.14159 and the token bucket refills."
+	got := redactForEval(in)
+	for _, leaked := range []string{"U12345", "jo.smith", "555", "4111", "hunter2", "abc.def", "xoxb"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("%q leaked in %q", leaked, got)
+		}
+	}
+	if !strings.Contains(got, "3.14159") || !strings.Contains(got, "token bucket") {
+		t.Errorf("ordinary text was redacted: %q", got)
+	}
+}
+
+func TestEvalSampling_RateAndPersistence(t *testing.T) {
+	defer func(roll func() float64) { evalRoll = roll }(evalRoll)
+	s := newEvalStore()
+	s.configure(25)
+	path := filepath.Join(t.TempDir(), "eval.json")
+	if err := s.load(path); err != nil {
+		t.Fatal(err)
+	}
+	rolls := []float64{10, 80, 24.9, 25}
+	evalRoll = func() float64 { r := rolls[0]; rolls = rolls[1:]; return r }
+	for i := 0; i < 4; i++ {
+		s.sample(&ConversationRecord{ID: newID(), Channel: "CEVAL", Query: "mail me at a@b.io", Answer: []string{"Sure."}})
+	}
+	if n := len(s.list()); n != 2 {
+		t.Fatalf("sampled %d, want 2", n)
+	}
+	if q := s.list()[0].Query; q != "mail me at [email]" {
+		t.Errorf("sample not redacted: %q", q)
+	}
+
+	reloaded := newEvalStore()
+	if err := reloaded.load(path); err != nil || len(reloaded.list()) != 2 {
+		t.Errorf("reloaded %d samples, err %v", len(reloaded.list()), err)
+	}
+}
+
+func TestEvalHomeTab_LabelFlow(t *testing.T) {
+	defer func(e *evalStore, roll func() float64) { evals, evalRoll = e, roll }(evals, evalRoll)
+	evals, evalRoll = newEvalStore(), func() float64 { return 0 }
+	evals.configure(100)
+	s := evals
+	config.AdminUsers = []string{"UADMIN"}
+	defer func() { config.AdminUsers = nil }()
+	s.sample(&ConversationRecord{ID: newID(), Channel: "CEVAL", Query: "q1", Answer: []string{"a1"}})
+	s.sample(&ConversationRecord{ID: newID(), Channel: "CEVAL", Query: "q2", Answer: []string{"a2"}, Model: "llama-70b"})
+
+	api := &fakeSlackClient{}
+	publishEvalHome(context.Background(), api, "U1")
+	if len(api.homeViews) != 0 {
+		t.Fatal("non-admins should not get the labelling view")
+	}
+	publishEvalHome(context.Background(), api, "UADMIN")
+	if len(api.homeViews) != 1 {
+		t.Fatalf("expected one Home tab view, got %d", len(api.homeViews))
+	}
+	data, _ := json.Marshal(api.homeViews[0])
+	if !strings.Contains(string(data), ActionEvalNeedsSource) || !strings.Contains(string(data), "q2") {
+		t.Errorf("view is missing samples or buttons: %s", data)
+	}
+
+	first := s.list()[0]
+	callback := slack.InteractionCallback{Type: slack.InteractionTypeBlockActions}
+	callback.User.ID = "UADMIN"
+	callback.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: ActionEvalNeedsSource, Value: first.ID}}
+	handleInteraction(context.Background(), api, callback)
+
+	if got := s.list()[0]; got.Label != EvalNeedsSource || got.LabeledBy != "UADMIN" {
+		t.Errorf("sample not labeled: %+v", got)
+	}
+	if len(api.homeViews) != 2 {
+		t.Error("Home tab should be refreshed after labelling")
+	}
+
+	rec := httptest.NewRecorder()
+	adminEvalHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/eval", nil))
+	var body struct {
+		Report []EvalModelReport `json:"report"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	want := []EvalModelReport{{Model: "default", NeedsSource: 1}, {Model: "llama-70b", Unlabeled: 1}}
+	if len(body.Report) != 2 || body.Report[0] != want[0] || body.Report[1] != want[1] {
+		t.Errorf("report = %+v, want %+v", body.Report, want)
+	}
+}
@@ -0,0 +1,213 @@
+package bot
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Event Deduplication
+//
+// Slack redelivers an event when its ack is slow, and the same message can
+// reach the relay under more than one event ID, such as after a socket
+// reconnect. Each accepted event is claimed twice: by its event_id, and by
+// what it is about (a mention or message by channel and ts, a reaction by
+// user, emoji and message). A claim on either key that was already made
+// within EVENT_DEDUP_TTL (default eventDedupTTL) marks the event as a
+// duplicate. Claims are kept in an in-memory LRU of EVENT_DEDUP_SIZE keys
+// (default defaultEventDedupSize). Replicas share claims by setting
+// EVENT_DEDUP_STORE to a redis:// (or rediss://) URL, which keeps each claim
+// as a key under redisDedupPrefix that Redis expires after the TTL; other
+// shared stores are passed to the relay with relay.WithEventDedupStore. If
+// the store fails, the event is let through rather than lost, and the
+// failure is counted as "dedup_errors" under "event_intake" on /debug/vars.
+const (
+	defaultEventDedupSize = 10000
+	redisDedupPrefix      = "chatrelay:dedup:"
+)
+
+// EventDedupStore remembers claimed event keys.
+type EventDedupStore interface {
+	// Claim records key for ttl and reports whether it was not already
+	// claimed.
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Forget drops a claim so a redelivery of the event is let through.
+	Forget(ctx context.Context, key string) error
+}
+
+type lruDedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// lruDedupStore keeps at most size claims, evicting the least recently
+// claimed first.
+type lruDedupStore struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	keys  map[string]*list.Element
+	now   func() time.Time
+}
+
+func newLRUDedupStore(size int) *lruDedupStore {
+	return &lruDedupStore{size: size, order: list.New(), keys: make(map[string]*list.Element), now: time.Now}
+}
+
+func (s *lruDedupStore) Claim(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if el, ok := s.keys[key]; ok {
+		if now.Before(el.Value.(*lruDedupEntry).expires) {
+			return false, nil
+		}
+		s.order.Remove(el)
+		delete(s.keys, key)
+	}
+	s.keys[key] = s.order.PushFront(&lruDedupEntry{key: key, expires: now.Add(ttl)})
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.keys, oldest.Value.(*lruDedupEntry).key)
+		metricEventIntake.Add("dedup_evictions", 1)
+	}
+	return true, nil
+}
+
+func (s *lruDedupStore) Forget(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.keys[key]; ok {
+		s.order.Remove(el)
+		delete(s.keys, key)
+	}
+	return nil
+}
+
+func (s *lruDedupStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// redisDedupStore claims keys with SET NX, so of several replicas given
+// the same event only one is told it is fresh.
+type redisDedupStore struct {
+	client *redis.Client
+}
+
+func (s *redisDedupStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, redisDedupPrefix+key, 1, ttl).Result()
+}
+
+func (s *redisDedupStore) Forget(ctx context.Context, key string) error {
+	return s.client.Del(ctx, redisDedupPrefix+key).Err()
+}
+
+// eventDedupKeys returns the keys an event is claimed under.
+func eventDedupKeys(ev slackevents.EventsAPIEvent) []string {
+	var keys []string
+	if cb, ok := ev.Data.(*slackevents.EventsAPICallbackEvent); ok && cb.EventID != "" {
+		keys = append(keys, "event:"+cb.EventID)
+	}
+	team := ev.TeamID
+	switch inner := ev.InnerEvent.Data.(type) {
+	case *slackevents.AppMentionEvent:
+		if inner.TimeStamp != "" {
+			keys = append(keys, fmt.Sprintf("mention:%s/%s/%s", team, inner.Channel, inner.TimeStamp))
+		}
+	case *slackevents.MessageEvent:
+		// Edits and deletions share the original message's ts.
+		if inner.SubType == "" && inner.TimeStamp != "" {
+			keys = append(keys, fmt.Sprintf("message:%s/%s/%s", team, inner.Channel, inner.TimeStamp))
+		}
+	case *slackevents.ReactionAddedEvent:
+		keys = append(keys, fmt.Sprintf("reaction:%s/%s/%s/%s/%s", team, inner.User, inner.Reaction, inner.Item.Channel, inner.Item.Timestamp))
+	}
+	return keys
+}
+
+// UseEventDedupStore replaces the in-memory dedup store, such as with one
+// shared by every replica.
+func UseEventDedupStore(store EventDedupStore) {
+	intake.mu.Lock()
+	defer intake.mu.Unlock()
+	intake.dedup = store
+}
+
+// configureEventDedup reads EVENT_DEDUP_TTL, EVENT_DEDUP_SIZE and
+// EVENT_DEDUP_STORE.
+func configureEventDedup() error {
+	ttl, size := eventDedupTTL, defaultEventDedupSize
+	if v := os.Getenv("EVENT_DEDUP_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid EVENT_DEDUP_TTL %q: use a positive duration such as 10m", v)
+		}
+		ttl = d
+	}
+	if v := os.Getenv("EVENT_DEDUP_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid EVENT_DEDUP_SIZE %q: use a positive number of events", v)
+		}
+		size = n
+	}
+	var store EventDedupStore = newLRUDedupStore(size)
+	switch v := strings.TrimSpace(os.Getenv("EVENT_DEDUP_STORE")); {
+	case v == "" || v == "memory":
+	case strings.HasPrefix(v, "redis://") || strings.HasPrefix(v, "rediss://"):
+		client, err := newRedisClient(v)
+		if err != nil {
+			return fmt.Errorf("invalid EVENT_DEDUP_STORE: %w", err)
+		}
+		store = &redisDedupStore{client: client}
+	default:
+		return fmt.Errorf("invalid EVENT_DEDUP_STORE %q: use memory or redis://host:port", v)
+	}
+	intake.mu.Lock()
+	defer intake.mu.Unlock()
+	intake.ttl, intake.dedup = ttl, store
+	return nil
+}
+
+// claim claims every key and reports whether none had been claimed. A
+// duplicate keeps the claims it made, so its redeliveries are caught too.
+func (in *eventIntake) claim(ctx context.Context, keys []string) bool {
+	in.mu.Lock()
+	store, ttl := in.dedup, in.ttl
+	in.mu.Unlock()
+	fresh := true
+	for _, key := range keys {
+		ok, err := store.Claim(ctx, key, ttl)
+		if err != nil {
+			metricEventIntake.Add("dedup_errors", 1)
+			slog.WarnContext(ctx, "Event dedup store failed, letting the event through", "key", key, "err", err)
+			continue
+		}
+		fresh = fresh && ok
+	}
+	return fresh
+}
+
+// forget lets a delayed event through when Slack redelivers it.
+func (in *eventIntake) forget(ctx context.Context, keys []string) {
+	in.mu.Lock()
+	store := in.dedup
+	in.mu.Unlock()
+	for _, key := range keys {
+		if err := store.Forget(ctx, key); err != nil {
+			metricEventIntake.Add("dedup_errors", 1)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+type failingDedupStore struct{}
+
+func (failingDedupStore) Claim(context.Context, string, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (failingDedupStore) Forget(context.Context, string) error {
+	return errors.New("connection refused")
+}
+
+func TestLRUDedupStore_EvictsOldest(t *testing.T) {
+	ctx := context.Background()
+	store := newLRUDedupStore(2)
+	for _, key := range []string{"a", "b", "c"} {
+		if ok, _ := store.Claim(ctx, key, time.Minute); !ok {
+			t.Fatalf("first claim of %s refused", key)
+		}
+	}
+	if store.len() != 2 {
+		t.Errorf("store holds %d keys, want 2", store.len())
+	}
+	if ok, _ := store.Claim(ctx, "c", time.Minute); ok {
+		t.Error("recent key claimed twice")
+	}
+	if ok, _ := store.Claim(ctx, "a", time.Minute); !ok {
+		t.Error("evicted key still claimed")
+	}
+	store.Forget(ctx, "c")
+	if ok, _ := store.Claim(ctx, "c", time.Minute); !ok {
+		t.Error("forgotten key still claimed")
+	}
+}
+
+func TestEventIntake_DedupsByContent(t *testing.T) {
+	in := newEventIntake(ackOverflowDrop)
+	ctx := context.Background()
+	room := workerpool.Stats{QueueCapacity: 4}
+	mention := func(eventID, ts string) slackevents.EventsAPIEvent {
+		ev := mentionEnvelope(eventID)
+		ev.TeamID = "T1"
+		ev.InnerEvent.Data = &slackevents.AppMentionEvent{User: "U1", Channel: "C1", TimeStamp: ts}
+		return ev
+	}
+	if d := in.decide(ctx, socketmode.Request{}, mention("Ev1", "1700000000.000100"), room); d != intakeAccept {
+		t.Fatalf("first delivery = %v, want accept", d)
+	}
+	if d := in.decide(ctx, socketmode.Request{}, mention("Ev2", "1700000000.000100"), room); d != intakeIgnore {
+		t.Errorf("same message under a new event ID = %v, want ignore", d)
+	}
+	if d := in.decide(ctx, socketmode.Request{}, mention("Ev3", "1700000000.000200"), room); d != intakeAccept {
+		t.Errorf("another message = %v, want accept", d)
+	}
+
+	reaction := mentionEnvelope("Ev4")
+	reaction.InnerEvent.Data = &slackevents.ReactionAddedEvent{User: "U1", Reaction: "eyes",
+		Item: slackevents.Item{Channel: "C1", Timestamp: "1700000000.000100"}}
+	if d := in.decide(ctx, socketmode.Request{}, reaction, room); d != intakeAccept {
+		t.Errorf("reaction = %v, want accept", d)
+	}
+	reaction.Data = &slackevents.EventsAPICallbackEvent{EventID: "Ev5"}
+	if d := in.decide(ctx, socketmode.Request{}, reaction, room); d != intakeIgnore {
+		t.Errorf("same reaction under a new event ID = %v, want ignore", d)
+	}
+}
+
+func TestEventIntake_FailsOpenWhenStoreFails(t *testing.T) {
+	in := newEventIntake(ackOverflowDrop)
+	in.dedup = failingDedupStore{}
+	room := workerpool.Stats{QueueCapacity: 4}
+	for i := 0; i < 2; i++ {
+		if d := in.decide(context.Background(), socketmode.Request{}, mentionEnvelope("Ev1"), room); d != intakeAccept {
+			t.Fatalf("delivery %d with a failing store = %v, want accept", i, d)
+		}
+	}
+}
+
+func TestConfigureEventDedup(t *testing.T) {
+	saved := intake
+	intake = newEventIntake(ackOverflowDrop)
+	t.Cleanup(func() { intake = saved })
+
+	t.Setenv("EVENT_DEDUP_TTL", "30s")
+	t.Setenv("EVENT_DEDUP_SIZE", "5")
+	if err := configureEventDedup(); err != nil {
+		t.Fatal(err)
+	}
+	if store, ok := intake.dedup.(*lruDedupStore); !ok || store.size != 5 || intake.ttl != 30*time.Second {
+		t.Errorf("intake = %+v", intake)
+	}
+	for env, v := range map[string]string{"EVENT_DEDUP_TTL": "-1m", "EVENT_DEDUP_SIZE": "lots", "EVENT_DEDUP_STORE": "memcached://localhost"} {
+		t.Setenv(env, v)
+		if err := configureEventDedup(); err == nil {
+			t.Errorf("%s=%s accepted", env, v)
+		}
+		t.Setenv(env, "")
+	}
+}
+
+func TestRedisDedupStore_SharesClaims(t *testing.T) {
+	saved := intake
+	intake = newEventIntake(ackOverflowDrop)
+	t.Cleanup(func() { intake = saved })
+	server := miniredis.RunT(t)
+	t.Setenv("EVENT_DEDUP_STORE", "redis://"+server.Addr())
+	if err := configureEventDedup(); err != nil {
+		t.Fatal(err)
+	}
+	first := intake.dedup
+	if err := configureEventDedup(); err != nil {
+		t.Fatal(err)
+	}
+	// Two replicas with their own clients on one server.
+	second := intake.dedup
+	if _, ok := second.(*redisDedupStore); !ok {
+		t.Fatalf("store = %T", second)
+	}
+
+	ctx := context.Background()
+	if ok, err := first.Claim(ctx, "event:Ev1", time.Minute); !ok || err != nil {
+		t.Fatalf("first claim = %v, %v", ok, err)
+	}
+	if ok, err := second.Claim(ctx, "event:Ev1", time.Minute); ok || err != nil {
+		t.Errorf("other replica's claim = %v, %v, want duplicate", ok, err)
+	}
+	if ttl := server.TTL(redisDedupPrefix + "event:Ev1"); ttl != time.Minute {
+		t.Errorf("claim TTL = %v", ttl)
+	}
+	server.FastForward(time.Minute)
+	if ok, _ := second.Claim(ctx, "event:Ev1", time.Minute); !ok {
+		t.Error("expired claim still held")
+	}
+	if err := first.Forget(ctx, "event:Ev1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := second.Claim(ctx, "event:Ev1", time.Minute); !ok {
+		t.Error("forgotten claim still held")
+	}
+
+	server.Close()
+	if _, err := first.Claim(ctx, "event:Ev2", time.Minute); err == nil {
+		t.Error("claim succeeded with the server down")
+	}
+}
@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Explain This Error
+//
+// The "Explain this error" message shortcut (callback ID "explain_error")
+// works on any message with a stack trace or error log. The message text
+// and its text snippets are sent to the backend with an instruction to
+// explain the failure and suggest fixes, and the answer is posted in the
+// message's thread like any other answer, so follow-ups work. Logs too
+// long for the backend are summarized in parts (see summarize.go); past
+// maxExplainChars they keep only their beginning and end, where errors and
+// their causes usually are. Reading snippets needs the files:read scope.
+// Uses are counted under "explain_error" on /debug/vars.
+const (
+	CallbackExplainError = "explain_error"
+
+	maxExplainChars     = 400000
+	maxExplainFileBytes = 1 << 20
+
+	explainInstruction = "The question is an error message, log excerpt or stack trace. Explain in plain language what went wrong, point to the most likely cause, and suggest concrete fixes. Quote the relevant lines."
+)
+
+var metricExplain = expvar.NewMap("explain_error")
+
+type instructionKey struct{}
+
+// withInstruction sends instruction with ctx's backend request.
+func withInstruction(ctx context.Context, instruction string) context.Context {
+	return context.WithValue(ctx, instructionKey{}, instruction)
+}
+
+func instructionFrom(ctx context.Context) string {
+	s, _ := ctx.Value(instructionKey{}).(string)
+	return s
+}
+
+// clipMiddle shortens s to about n characters by dropping its middle.
+func clipMiddle(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	keep := n / 2
+	return fmt.Sprintf("%s\n… [%d characters omitted] …\n%s", string(r[:keep]), len(r)-2*keep, string(r[len(r)-keep:]))
+}
+
+func isTextSnippet(f slack.File) bool {
+	return f.Mode == "snippet" || strings.HasPrefix(f.Mimetype, "text/")
+}
+
+// errorText returns the message's text followed by its text snippets.
+func errorText(ctx context.Context, api SlackClient, msg slack.Message) string {
+	parts := []string{strings.TrimSpace(msg.Text)}
+	for _, f := range msg.Files {
+		if !isTextSnippet(f) {
+			continue
+		}
+		url := f.URLPrivateDownload
+		if url == "" {
+			url = f.URLPrivate
+		}
+		if f.Size > maxExplainFileBytes || url == "" {
+			metricExplain.Add("files_skipped", 1)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := api.GetFileContext(ctx, url, &buf); err != nil {
+			metricExplain.Add("files_skipped", 1)
+			slog.ErrorContext(ctx, "Failed to download snippet", "file", f.ID, "err", err)
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("--- %s ---\n%s", f.Name, strings.TrimSpace(buf.String())))
+	}
+	return clipMiddle(strings.TrimSpace(strings.Join(parts, "\n\n")), maxExplainChars)
+}
+
+func handleExplainShortcut(ctx context.Context, api SlackClient, callback slack.InteractionCallback) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "explain_error")
+	defer span.End()
+
+	channel, user := callback.Channel.ID, callback.User.ID
+	span.SetAttributes(attribute.String("user.id", user), attribute.String("channel.id", channel))
+	threadTS := callback.Message.ThreadTimestamp
+	if threadTS == "" {
+		threadTS = callback.Message.Timestamp
+	}
+	msg := callback.Message
+	ctx = withInstruction(withRequestID(ctx), explainInstruction)
+	requests.record(ctx, "queued", "explain error")
+	submitOrdered(ctx, api, workerPool, channel, threadTS, user, func() {
+		query := errorText(ctx, api, msg)
+		if query == "" {
+			notifyUser(ctx, api, channel, user, "That message has no text or snippet to explain.")
+			return
+		}
+		metricExplain.Add("requests", 1)
+		ev := slackevents.AppMentionEvent{User: user, Channel: channel, ThreadTimeStamp: threadTS, TimeStamp: msg.Timestamp, Text: query}
+		processTask(ctx, api, ev, query, slack.MsgOptionTS(threadTS))
+	})
+}
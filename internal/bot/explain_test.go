@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+)
+
+func TestClipMiddle(t *testing.T) {
+	if got := clipMiddle("short", 10); got != "short" {
+		t.Errorf("clipMiddle = %q", got)
+	}
+	got := clipMiddle(strings.Repeat("a", 10)+strings.Repeat("b", 10), 10)
+	if !strings.HasPrefix(got, "aaaaa\n") || !strings.HasSuffix(got, "\nbbbbb") || !strings.Contains(got, "[10 characters omitted]") {
+		t.Errorf("clipMiddle = %q", got)
+	}
+}
+
+func TestExplainShortcut_SendsLogAndSnippets(t *testing.T) {
+	defer func(d time.Duration, p *workerpool.Pool) { postInterval, workerPool = d, p }(postInterval, workerPool)
+	postInterval = 0
+	workerPool = workerpool.New(1)
+
+	var got backend.ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "The pool is exhausted."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{files: map[string][]byte{"https://files.slack.com/trace.txt": []byte("goroutine 1 [running]:\nmain.main()")}}
+	callback := slack.InteractionCallback{Type: slack.InteractionTypeMessageAction, CallbackID: CallbackExplainError}
+	callback.Channel.ID = "CEXPLAIN"
+	callback.User.ID = "U1"
+	callback.Message.Text = "panic: connection pool exhausted"
+	callback.Message.Timestamp = "500.000100"
+	callback.Message.Files = []slack.File{
+		{ID: "F1", Name: "trace.txt", Mode: "snippet", URLPrivateDownload: "https://files.slack.com/trace.txt"},
+		{ID: "F2", Name: "screenshot.png", Mimetype: "image/png", URLPrivateDownload: "https://files.slack.com/shot.png"},
+	}
+	handleInteraction(context.Background(), api, callback)
+	workerPool.Shutdown()
+
+	if got.Instruction != explainInstruction {
+		t.Errorf("instruction = %q", got.Instruction)
+	}
+	if !strings.Contains(got.Query, "panic: connection pool exhausted") || !strings.Contains(got.Query, "--- trace.txt ---\ngoroutine 1") {
+		t.Errorf("query = %q", got.Query)
+	}
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Text() != "The pool is exhausted." || posts[0].Values.Get("thread_ts") != "500.000100" {
+		t.Fatalf("expected the explanation in the thread, got %+v", posts)
+	}
+	if _, ok := conversations.LatestInThread("CEXPLAIN", "500.000100"); !ok {
+		t.Error("the explanation should be recorded for follow-ups")
+	}
+}
@@ -0,0 +1,148 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+// Per-User Fairness
+//
+// USER_MAX_IN_FLIGHT caps how many questions one user can have being
+// answered at once (default 2, 0 for no cap), so a single power user
+// cannot occupy every worker. Questions over the cap wait in a per-user
+// queue, outside the worker pool, and the asker is told their place in
+// it; when one of their answers finishes the next one is handed back to
+// the pool, behind everyone else's. At most USER_MAX_WAITING questions
+// (default 5, 0 for no cap) wait per user; further ones are turned away
+// with the busy notice, so one user cannot queue unbounded work. How many
+// users are answered, how many are at their cap and how many questions
+// wait are under "user_fairness" on /debug/vars, and admitted, deferred and
+// rejected questions are counted under "user_fairness_events".
+const (
+	defaultUserMaxInFlight = 2
+	defaultUserMaxWaiting  = 5
+)
+
+type userSlots struct {
+	mu         sync.Mutex
+	limit      int
+	maxWaiting int
+	inFlight   map[string]int
+	waiting    map[string][]func()
+}
+
+var (
+	fairness           = newUserSlots(defaultUserMaxInFlight, defaultUserMaxWaiting)
+	metricFairness     = expvar.NewMap("user_fairness_events")
+	metricFairnessPeak = new(expvar.Int)
+)
+
+func init() {
+	metricFairness.Set("max_waiting", metricFairnessPeak)
+	expvar.Publish("user_fairness", expvar.Func(func() any { return fairness.snapshot() }))
+}
+
+func newUserSlots(limit, maxWaiting int) *userSlots {
+	return &userSlots{limit: limit, maxWaiting: maxWaiting, inFlight: map[string]int{}, waiting: map[string][]func(){}}
+}
+
+// submit runs start now when user is under the cap and otherwise queues it,
+// returning the question's place in the user's queue (0 when it started).
+// It returns false, without queueing start, when the user's queue is full.
+// start must call release for user when its question is done.
+func (s *userSlots) submit(user string, start func()) (int, bool) {
+	s.mu.Lock()
+	if s.limit <= 0 || user == "" || s.inFlight[user] < s.limit {
+		s.inFlight[user]++
+		s.mu.Unlock()
+		metricFairness.Add("admitted", 1)
+		start()
+		return 0, true
+	}
+	if s.maxWaiting > 0 && len(s.waiting[user]) >= s.maxWaiting {
+		s.mu.Unlock()
+		metricFairness.Add("rejected", 1)
+		return 0, false
+	}
+	s.waiting[user] = append(s.waiting[user], start)
+	position := len(s.waiting[user])
+	waiting := 0
+	for _, queue := range s.waiting {
+		waiting += len(queue)
+	}
+	s.mu.Unlock()
+	metricFairness.Add("deferred", 1)
+	if int64(waiting) > metricFairnessPeak.Value() {
+		metricFairnessPeak.Set(int64(waiting))
+	}
+	return position, true
+}
+
+// release frees one of user's slots, starting their next waiting question.
+func (s *userSlots) release(user string) {
+	s.mu.Lock()
+	if queue := s.waiting[user]; len(queue) > 0 {
+		next := queue[0]
+		if len(queue) == 1 {
+			delete(s.waiting, user)
+		} else {
+			s.waiting[user] = queue[1:]
+		}
+		s.mu.Unlock()
+		// Submitting from a worker could block on a full pool queue, so the
+		// next question joins the queue from its own goroutine.
+		go next()
+		return
+	}
+	if s.inFlight[user]--; s.inFlight[user] <= 0 {
+		delete(s.inFlight, user)
+	}
+	s.mu.Unlock()
+}
+
+// pending counts questions holding or waiting for a slot.
+func (s *userSlots) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for user, inFlight := range s.inFlight {
+		n += inFlight + len(s.waiting[user])
+	}
+	return n
+}
+
+func (s *userSlots) snapshot() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	atCap, waiting := 0, 0
+	for user, n := range s.inFlight {
+		if s.limit > 0 && n >= s.limit {
+			atCap++
+		}
+		waiting += len(s.waiting[user])
+	}
+	return map[string]any{
+		"max_in_flight":  s.limit,
+		"max_waiting":    s.maxWaiting,
+		"users_answered": len(s.inFlight),
+		"users_at_cap":   atCap,
+		"waiting":        waiting,
+	}
+}
+
+// notifyDeferred tells user their question waits behind their own.
+func notifyDeferred(ctx context.Context, api SlackClient, channel, threadTS, user string, position, limit int) {
+	text := fmt.Sprintf("You already have %d questions being answered, so this one will start when one of them finishes.", limit)
+	if position > 1 {
+		text = fmt.Sprintf("You already have %d questions being answered and %d more waiting, so this one is number %d in your queue.", limit, position-1, position)
+	}
+	opts := []slack.MsgOption{slack.MsgOptionPostEphemeral(user)}
+	if threadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(threadTS))
+	}
+	sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: text}, opts...)
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+)
+
+func TestUserSlots_CapsInFlightPerUser(t *testing.T) {
+	s := newUserSlots(2, 0)
+	var started []string
+	var mu sync.Mutex
+	start := func(name string) func() {
+		return func() {
+			mu.Lock()
+			started = append(started, name)
+			mu.Unlock()
+		}
+	}
+
+	for i, name := range []string{"a1", "a2", "a3", "a4"} {
+		want := max(0, i-1)
+		if got, _ := s.submit("UA", start(name)); got != want {
+			t.Errorf("%s: position %d, want %d", name, got, want)
+		}
+	}
+	if got, _ := s.submit("UB", start("b1")); got != 0 {
+		t.Errorf("another user should not wait, got position %d", got)
+	}
+	if len(started) != 3 {
+		t.Fatalf("started = %v, want a1 a2 b1", started)
+	}
+	if snap := s.snapshot(); snap["users_at_cap"] != 1 || snap["waiting"] != 2 {
+		t.Errorf("snapshot = %v", snap)
+	}
+}
+
+func TestUserSlots_ReleaseStartsNext(t *testing.T) {
+	s := newUserSlots(1, 0)
+	done := make(chan string, 2)
+	s.submit("UA", func() {})
+	s.submit("UA", func() { done <- "second" })
+	s.release("UA")
+	if got := <-done; got != "second" {
+		t.Fatalf("got %q", got)
+	}
+	s.release("UA")
+	if len(s.inFlight) != 0 || len(s.waiting) != 0 {
+		t.Errorf("slots not cleaned up: %v %v", s.inFlight, s.waiting)
+	}
+}
+
+func TestUserSlots_CapsWaitingQueue(t *testing.T) {
+	s := newUserSlots(1, 2)
+	for i, want := range []bool{true, true, true, false} {
+		if _, ok := s.submit("UA", func() {}); ok != want {
+			t.Errorf("question %d admitted = %v, want %v", i+1, ok, want)
+		}
+	}
+	if _, ok := s.submit("UB", func() {}); !ok {
+		t.Error("another user's question was turned away")
+	}
+	if snap := s.snapshot(); snap["waiting"] != 2 {
+		t.Errorf("snapshot = %v", snap)
+	}
+}
+
+func TestUserSlots_NoCap(t *testing.T) {
+	s := newUserSlots(0, 0)
+	for i := 0; i < 5; i++ {
+		if got, _ := s.submit("UA", func() {}); got != 0 {
+			t.Fatalf("uncapped submit waited at position %d", got)
+		}
+	}
+}
+
+func TestSubmitConversationTask_DefersOverCap(t *testing.T) {
+	original := fairness
+	fairness = newUserSlots(1, 1)
+	defer func() { fairness = original }()
+
+	pool := workerpool.New(4)
+	api := &fakeSlackClient{}
+	release := make(chan struct{})
+	finished := make(chan int, 2)
+	ctx := context.Background()
+
+	submitConversationTask(ctx, api, pool, "C1", "", "U1", func() {
+		<-release
+		finished <- 1
+	})
+	submitConversationTask(ctx, api, pool, "C1", "", "U1", func() { finished <- 2 })
+	if posts := api.sent(); len(posts) != 1 || !strings.Contains(posts[0].Text(), "start when one of them finishes") {
+		t.Fatalf("expected a deferral notice, got %v", posts)
+	} else if posts[0].Values.Get("user") != "U1" {
+		t.Errorf("notice should be ephemeral to U1, got %v", posts[0].Values)
+	}
+	submitConversationTask(ctx, api, pool, "C1", "", "U1", func() { finished <- 3 })
+	if posts := api.sent(); len(posts) != 2 || posts[1].Text() != queueBusyText || posts[1].Values.Get("user") != "U1" {
+		t.Fatalf("expected an ephemeral busy notice once the user's queue is full, got %v", posts)
+	}
+	close(release)
+	if first, second := <-finished, <-finished; first != 1 || second != 2 {
+		t.Errorf("order = [%d %d], want [1 2]", first, second)
+	}
+	pool.Shutdown()
+}
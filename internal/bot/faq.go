@@ -0,0 +1,239 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Semantic FAQ
+//
+// With EMBEDDINGS_URL set, every question is embedded before the backend is
+// called and compared by cosine similarity with the curated entries in
+// FAQ_FILE and with questions already answered in the same channel. When
+// the best match reaches FAQ_THRESHOLD the stored answer is posted through
+// the same delivery as a backend answer (disclaimer, part numbers,
+// attribution) and the backend is skipped. Channels in review mode or
+// without internal retrieval are never answered from stored answers. The endpoint must accept OpenAI-style embedding
+// requests ({"model", "input"} in, {"data": [{"embedding"}]} out).
+// Question vectors are kept on the conversation records, so duplicates are
+// found in the same store that serves follow-ups. If the endpoint fails
+// the question goes to the backend as usual. Matches and misses are
+// counted under "faq_matches" on /debug/vars.
+const (
+	defaultFAQThreshold = 0.85
+	embeddingsTimeout   = 5 * time.Second
+)
+
+var metricFAQ = expvar.NewMap("faq_matches")
+
+// FAQEntry is one curated question and answer in FAQ_FILE.
+type FAQEntry struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+
+	vector []float32
+}
+
+func loadFAQ(path string) ([]FAQEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []FAQEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, e := range entries {
+		if strings.TrimSpace(e.Question) == "" || strings.TrimSpace(e.Answer) == "" {
+			return nil, fmt.Errorf("%s: entry %d needs a question and an answer", path, i+1)
+		}
+	}
+	return entries, nil
+}
+
+type embeddingsClient struct {
+	URL    string
+	Model  string
+	APIKey string
+}
+
+// embed returns one vector per text, in order.
+func (c *embeddingsClient) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	ctx, cancel := context.WithTimeout(ctx, embeddingsTimeout)
+	defer cancel()
+	body, _ := json.Marshal(map[string]any{"model": c.Model, "input": texts})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("embeddings endpoint returned %s", resp.Status)
+	}
+	var result struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode embeddings: %w", err)
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings endpoint returned %d vectors for %d inputs", len(result.Data), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for i, d := range result.Data {
+		vectors[i] = d.Embedding
+	}
+	return vectors, nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+type faqMatcher struct {
+	mu        sync.RWMutex
+	client    *embeddingsClient
+	entries   []FAQEntry
+	threshold float64
+}
+
+var faqs = &faqMatcher{threshold: defaultFAQThreshold}
+
+// configure embeds the FAQ questions and enables matching.
+func (m *faqMatcher) configure(ctx context.Context, client *embeddingsClient, entries []FAQEntry, threshold float64) error {
+	if len(entries) > 0 {
+		questions := make([]string, len(entries))
+		for i, e := range entries {
+			questions[i] = e.Question
+		}
+		vectors, err := client.embed(ctx, questions)
+		if err != nil {
+			return fmt.Errorf("embed FAQ questions: %w", err)
+		}
+		for i := range entries {
+			entries[i].vector = vectors[i]
+		}
+	}
+	if threshold <= 0 || threshold > 1 {
+		threshold = defaultFAQThreshold
+	}
+	m.mu.Lock()
+	m.client, m.entries, m.threshold = client, entries, threshold
+	m.mu.Unlock()
+	return nil
+}
+
+type faqMatch struct {
+	Question string
+	Answer   string
+	Score    float64
+	// Previous is set when the match is an earlier answer in the channel
+	// rather than a curated entry.
+	Previous *ConversationRecord
+}
+
+// match embeds query and returns its vector and the best match at or
+// above the threshold, if any. Both are nil when matching is disabled or
+// the endpoint fails.
+func (m *faqMatcher) match(ctx context.Context, channel, query string) ([]float32, *faqMatch) {
+	m.mu.RLock()
+	client, entries, threshold := m.client, m.entries, m.threshold
+	m.mu.RUnlock()
+	if client == nil {
+		return nil, nil
+	}
+	vectors, err := client.embed(ctx, []string{query})
+	if err != nil {
+		metricFAQ.Add("embed_errors", 1)
+		slog.WarnContext(ctx, "FAQ matching skipped", "err", err)
+		return nil, nil
+	}
+	vec := vectors[0]
+	var best *faqMatch
+	for _, e := range entries {
+		if score := cosineSimilarity(vec, e.vector); score >= threshold && (best == nil || score > best.Score) {
+			best = &faqMatch{Question: e.Question, Answer: e.Answer, Score: score}
+		}
+	}
+	if prev, score := conversations.MostSimilar(channel, vec); score >= threshold && (best == nil || score > best.Score) {
+		best = &faqMatch{Question: prev.Query, Answer: prev.AnswerText(), Score: score, Previous: &prev}
+	}
+	return vec, best
+}
+
+// answerFromFAQ posts a matching FAQ or earlier answer and returns its
+// record. Otherwise it returns the question's vector, if one was computed,
+// for the record of the backend's answer. Stored answers are internal
+// content no reviewer has seen this time, so channels in review mode or
+// without internal retrieval always ask the backend.
+func answerFromFAQ(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, cc ChannelConfig, query string, replyOptions ...slack.MsgOption) ([]float32, *ConversationRecord) {
+	// Instructed requests, such as error explanations, are not questions.
+	if isSelfTest(ctx) || instructionFrom(ctx) != "" || cc.ReviewMode || cc.DisableInternalRetrieval {
+		return nil, nil
+	}
+	vec, m := faqs.match(ctx, ev.Channel, query)
+	if m == nil {
+		if vec != nil {
+			metricFAQ.Add("misses", 1)
+		}
+		return vec, nil
+	}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Float64("faq.score", m.Score))
+	kind, intro := "faq", fmt.Sprintf(":bulb: From the FAQ (_%s_):", m.Question)
+	if m.Previous != nil {
+		kind, intro = "duplicate", fmt.Sprintf(":bulb: This was asked here before (_%s_):", truncate(m.Question, 120))
+	}
+	metricFAQ.Add(kind, 1)
+	requests.record(ctx, "faq_match", fmt.Sprintf("%s, score %.2f", kind, m.Score))
+
+	rec := &ConversationRecord{ID: conversationIDFrom(ctx), Channel: ev.Channel, ThreadTS: ev.ThreadTimeStamp, User: ev.User,
+		Query: query, QueryTS: ev.TimeStamp, Model: kind, Embedding: vec}
+	// Posted like a backend answer, with the channel's disclaimer, part
+	// numbers and attribution.
+	answer := newAnswerDelivery(ctx, api, cc, rec, nil, "", replyOptions...)
+	answer.post(intro + "\n" + m.Answer)
+	answer.progress.complete()
+	// The record keeps the answer without the intro, so that a later
+	// duplicate quotes it as it was first given.
+	rec.Answer = []string{m.Answer}
+	answer.finish()
+	return vec, rec
+}
@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// fakeEmbeddings maps texts onto a few topic axes, so questions about the
+// same topic score close to 1.
+func fakeEmbeddings(t *testing.T) *httptest.Server {
+	t.Helper()
+	topics := []string{"vpn", "password", "invoice"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		type item struct {
+			Embedding []float32 `json:"embedding"`
+		}
+		var out struct {
+			Data []item `json:"data"`
+		}
+		for _, text := range req.Input {
+			vec := make([]float32, len(topics)+1)
+			vec[len(topics)] = 0.1
+			for i, topic := range topics {
+				if strings.Contains(strings.ToLower(text), topic) {
+					vec[i] = 1
+				}
+			}
+			out.Data = append(out.Data, item{Embedding: vec})
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	if s := cosineSimilarity([]float32{1, 0}, []float32{2, 0}); s < 0.999 {
+		t.Errorf("parallel vectors = %f", s)
+	}
+	if s := cosineSimilarity([]float32{1, 0}, []float32{0, 1}); s != 0 {
+		t.Errorf("orthogonal vectors = %f", s)
+	}
+	if s := cosineSimilarity([]float32{1}, []float32{1, 0}); s != 0 {
+		t.Errorf("mismatched dimensions = %f", s)
+	}
+}
+
+func TestProcessTask_AnswersFromFAQ(t *testing.T) {
+	var backendCalls int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backendCalls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Submit invoices through the finance portal."})
+	}))
+	defer backendServer.Close()
+	defer func(url string, d time.Duration) { config.BackendURL, postInterval = url, d }(config.BackendURL, postInterval)
+	config.BackendURL, postInterval = backendServer.URL, 0
+	defer func(m *faqMatcher) { faqs = m }(faqs)
+	faqs = &faqMatcher{}
+	entries := []FAQEntry{{Question: "How do I connect to the VPN?", Answer: "Use the corporate VPN client."}}
+	if err := faqs.configure(context.Background(), &embeddingsClient{URL: fakeEmbeddings(t).URL}, entries, 0.9); err != nil {
+		t.Fatal(err)
+	}
+
+	api := &fakeSlackClient{}
+	rec := processTask(withRequestID(context.Background()), api, slackevents.AppMentionEvent{User: "U1", Channel: "CFAQ"}, "vpn is not connecting, help?")
+	if rec == nil || rec.Model != "faq" || rec.AnswerText() != "Use the corporate VPN client." {
+		t.Fatalf("expected the FAQ answer, got %+v", rec)
+	}
+	if atomic.LoadInt32(&backendCalls) != 0 {
+		t.Error("the backendServer should not be called for an FAQ match")
+	}
+
+	// A new question goes to the backendServer, and asking it again reuses the answer.
+	rec = processTask(withRequestID(context.Background()), api, slackevents.AppMentionEvent{User: "U1", Channel: "CFAQ"}, "Where do I send an invoice?")
+	if rec == nil || len(rec.Embedding) == 0 {
+		t.Fatalf("backend answers should keep the question's vector, got %+v", rec)
+	}
+	rec = processTask(withRequestID(context.Background()), api, slackevents.AppMentionEvent{User: "U2", Channel: "CFAQ"}, "invoice: who gets it?")
+	if rec == nil || rec.Model != "duplicate" || atomic.LoadInt32(&backendCalls) != 1 {
+		t.Fatalf("expected the earlier answer to be reused, got %+v after %d backendServer calls", rec, backendCalls)
+	}
+	rec = processTask(withRequestID(context.Background()), api, slackevents.AppMentionEvent{User: "U2", Channel: "COTHER"}, "invoice: who gets it?")
+	if rec == nil || rec.Model == "duplicate" {
+		t.Error("earlier answers should only be reused in the same channel")
+	}
+}
+
+func TestProcessTask_FAQFollowsChannelPolicy(t *testing.T) {
+	var backendCalls int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backendCalls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Ask IT."})
+	}))
+	defer backendServer.Close()
+	defer func(url string, d time.Duration) { config.BackendURL, postInterval = url, d }(config.BackendURL, postInterval)
+	config.BackendURL, postInterval = backendServer.URL, 0
+	defer func(m *faqMatcher) { faqs = m }(faqs)
+	faqs = &faqMatcher{}
+	entries := []FAQEntry{{Question: "How do I connect to the VPN?", Answer: "Use the corporate VPN client."}}
+	if err := faqs.configure(context.Background(), &embeddingsClient{URL: fakeEmbeddings(t).URL}, entries, 0.9); err != nil {
+		t.Fatal(err)
+	}
+	setChannelSettings(channelSettings{
+		Channels: map[string]ChannelConfig{
+			"CNOTE":   {Disclaimer: "Check with IT.", NumberParts: true},
+			"CREVIEW": {ReviewMode: true, ReviewChannel: "CREVIEWERS"},
+		},
+		External: ExternalPolicy{DisableInternalRetrieval: true, Disclaimer: "Shared with external organizations."},
+	})
+	defer setChannelSettings(channelSettings{})
+
+	api := &fakeSlackClient{external: map[string]bool{"CEXT": true}}
+	rec := processTask(withRequestID(context.Background()), api, slackevents.AppMentionEvent{User: "U1", Channel: "CNOTE"}, "vpn is not connecting, help?")
+	if rec == nil || rec.Model != "faq" || rec.AnswerText() != "Use the corporate VPN client." {
+		t.Fatalf("expected the FAQ answer, got %+v", rec)
+	}
+	var texts []string
+	for _, p := range api.sent() {
+		texts = append(texts, p.Text())
+	}
+	if len(texts) != 3 || !strings.HasPrefix(texts[0], "(1/") || texts[1] != "Check with IT." || !strings.Contains(texts[2], "done") {
+		t.Errorf("FAQ answer should be numbered and followed by the disclaimer and done notice, got %q", texts)
+	}
+
+	for _, channel := range []string{"CREVIEW", "CEXT"} {
+		rec := processTask(withRequestID(context.Background()), api, slackevents.AppMentionEvent{User: "U1", Channel: channel}, "vpn is not connecting, help?")
+		if rec != nil && (rec.Model == "faq" || rec.Model == "duplicate") {
+			t.Errorf("%s: stored answer posted without the channel's policy: %+v", channel, rec)
+		}
+	}
+	if n := atomic.LoadInt32(&backendCalls); n != 2 {
+		t.Errorf("backend called %d times, want once each for the review and external channels", n)
+	}
+}
+
+func TestFAQ_FallsBackWhenEmbeddingsFail(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer down.Close()
+	faqs.mu.Lock()
+	faqs.client = &embeddingsClient{URL: down.URL}
+	faqs.mu.Unlock()
+	t.Cleanup(func() { faqs.configure(context.Background(), nil, nil, 0) })
+
+	vec, rec := answerFromFAQ(context.Background(), &fakeSlackClient{}, slackevents.AppMentionEvent{User: "U1", Channel: "C1"}, ChannelConfig{}, "vpn?")
+	if vec != nil || rec != nil {
+		t.Errorf("a failing endpoint should fall through to the backendServer, got %v %+v", vec, rec)
+	}
+}
@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Inline Answer Editing
+//
+// The original asker can reply in an answer's thread with "fix: <instruction>"
+// to have the answer rewritten and edited in place. Redacted answers can't
+// be fixed.
+const fixPrefix = "fix:"
+
+func parseFixInstruction(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if len(text) < len(fixPrefix) || !strings.EqualFold(text[:len(fixPrefix)], fixPrefix) {
+		return "", false
+	}
+	instruction := strings.TrimSpace(text[len(fixPrefix):])
+	return instruction, instruction != ""
+}
+
+// processFixRequest reports whether the message was a fix request for an
+// answer in this thread, in which case no other handler should see it.
+func processFixRequest(ctx context.Context, api SlackClient, ev *slackevents.MessageEvent, pool *workerpool.Pool) bool {
+	if ev.BotID != "" || ev.ThreadTimeStamp == "" {
+		return false
+	}
+	ctx = withLogFields(ctx, ev.User, ev.Channel)
+	instruction, ok := parseFixInstruction(ev.Text)
+	if !ok {
+		return false
+	}
+	rec, ok := conversations.ByMessage(ev.Channel, ev.ThreadTimeStamp)
+	if !ok {
+		rec, ok = conversations.LatestInThread(ev.Channel, ev.ThreadTimeStamp)
+	}
+	if !ok || len(rec.MessageTS) == 0 {
+		return false
+	}
+
+	ctx, span := otel.Tracer("bot").Start(withDefaultQuerySource(ctx, SourceThread), "process_fix_request")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("user.id", ev.User),
+		attribute.String("channel.id", ev.Channel),
+		attribute.String("conversation.id", rec.ID),
+	)
+
+	if ev.User != rec.User {
+		notifyUser(ctx, api, ev.Channel, ev.User, "Only the person who asked the question can request a fix.")
+		return true
+	}
+	if rec.Redacted {
+		notifyUser(ctx, api, ev.Channel, ev.User, "That answer was redacted and can't be fixed.")
+		return true
+	}
+	if !budget.allowRegeneration(ctx, api, ev.Channel, ev.User) {
+		return true
+	}
+	slog.InfoContext(ctx, "Received fix request", "conversation", rec.ID, "instruction", instruction)
+
+	submitOrdered(ctx, api, pool, rec.Channel, rec.ThreadTS, ev.User, func() {
+		applyFix(ctx, api, rec.ID, ev.User, instruction)
+	})
+	return true
+}
+
+func applyFix(ctx context.Context, api SlackClient, recordID, user, instruction string) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "apply_fix")
+	defer span.End()
+
+	// The answer may have been redacted while the fix was queued.
+	rec, ok := conversations.Get(recordID)
+	if !ok || rec.Redacted {
+		return
+	}
+	revised, err := requestAnswer(ctx, backend.ChatRequest{
+		UserID:         user,
+		Query:          rec.Query,
+		ChannelID:      rec.Channel,
+		PreviousAnswer: rec.AnswerText(),
+		Instruction:    instruction,
+	})
+	if err != nil || strings.TrimSpace(revised) == "" {
+		if err != nil {
+			span.RecordError(err)
+		}
+		notifyUser(ctx, api, rec.Channel, user, withErrorReference(ctx, "Sorry, I couldn't revise that answer. Please try again later."))
+		return
+	}
+
+	// Rendered like the first answer, with the channel's emoji policy,
+	// glossary links and tables.
+	rendered, files := renderAnswer(ctx, api, rec.Channel, user, revised)
+	text := filterText(ctx, rec.Channel, user, rendered)
+	// Disclaimers and notices stay; only the answer's own messages change.
+	chunks := rec.answerMessages()
+	if len(chunks) == 0 {
+		return
+	}
+	first := chunks[0]
+	if _, _, _, err := api.UpdateMessageContext(ctx, rec.Channel, first, slack.MsgOptionText(text, false)); err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to edit answer", "conversation", rec.ID, "err", err)
+		notifyUser(ctx, api, rec.Channel, user, withErrorReference(ctx, "Sorry, I couldn't edit the original answer."))
+		return
+	}
+	uploadTables(ctx, api, rec.Channel, user, first, files)
+	// The revision replaces the whole answer, so extra chunk messages go.
+	deleted := map[string]bool{}
+	for _, ts := range chunks[1:] {
+		if _, _, err := api.DeleteMessageContext(ctx, rec.Channel, ts); err != nil {
+			span.RecordError(err)
+			continue
+		}
+		deleted[ts] = true
+	}
+
+	conversations.Update(rec.ID, func(r *ConversationRecord) {
+		r.Edits = append(r.Edits, AnswerEdit{
+			At:          time.Now(),
+			By:          user,
+			Instruction: instruction,
+			Previous:    r.Answer,
+		})
+		r.Answer = []string{revised}
+		// Chunks that could not be deleted are tried again by the next fix.
+		gone := func(ts string) bool { return deleted[ts] }
+		r.MessageTS = slices.DeleteFunc(slices.Clone(r.MessageTS), gone)
+		r.AnswerTS = slices.DeleteFunc(slices.Clone(chunks), gone)
+	})
+	if updated, ok := conversations.Get(rec.ID); ok {
+		searchIndex.add(ctx, &updated)
+	}
+	span.SetAttributes(attribute.Int("answer.edits", len(rec.Edits)+1))
+	slog.InfoContext(ctx, "Answer edited in place", "conversation", rec.ID)
+}
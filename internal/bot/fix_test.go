@@ -0,0 +1,153 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestParseFixInstruction(t *testing.T) {
+	if got, ok := parseFixInstruction("  FIX: use metric units"); !ok || got != "use metric units" {
+		t.Errorf("unexpected parse result %q %v", got, ok)
+	}
+	for _, text := range []string{"fix:", "please fix: this", "fixture"} {
+		if _, ok := parseFixInstruction(text); ok {
+			t.Errorf("%q should not be a fix request", text)
+		}
+	}
+}
+
+func TestProcessFixRequest_EditsAnswerInPlace(t *testing.T) {
+	var got backend.ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Revised answer in km."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	rec := &ConversationRecord{ID: newID(), Channel: "C1", User: "U1", Query: "How far?", Answer: []string{"Ten miles.", "Roughly."},
+		MessageTS: []string{"10.000100", "11.000100", "12.000100"}, AnswerTS: []string{"10.000100", "11.000100"}}
+	conversations.Save(rec)
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+
+	other := &slackevents.MessageEvent{User: "U2", Channel: "C1", ThreadTimeStamp: "10.000100", Text: "fix: shorter"}
+	if !processFixRequest(context.Background(), api, other, pool) {
+		t.Fatal("fix request in an answer thread should be handled")
+	}
+
+	ev := &slackevents.MessageEvent{User: "U1", Channel: "C1", ThreadTimeStamp: "11.000100", Text: "fix: use kilometres"}
+	processFixRequest(context.Background(), api, ev, pool)
+	pool.Shutdown()
+
+	if got.Instruction != "use kilometres" || got.PreviousAnswer != "Ten miles.\nRoughly." {
+		t.Errorf("backend did not receive previous answer and instruction: %+v", got)
+	}
+	if len(api.updates) != 1 || api.updates[0].Values.Get("ts") != "10.000100" || api.updates[0].Text() != "Revised answer in km." {
+		t.Errorf("expected first answer message to be edited, got %+v", api.updates)
+	}
+	if len(api.deleted) != 1 || api.deleted[0] != "11.000100" {
+		t.Errorf("expected only the extra chunk message to be deleted, got %v", api.deleted)
+	}
+	updated, _ := conversations.Get(rec.ID)
+	if len(updated.Edits) != 1 || updated.Edits[0].Previous[0] != "Ten miles." || updated.AnswerText() != "Revised answer in km." {
+		t.Errorf("edit history not recorded: %+v", updated)
+	}
+	if !slices.Equal(updated.MessageTS, []string{"10.000100", "12.000100"}) || !slices.Equal(updated.AnswerTS, []string{"10.000100"}) {
+		t.Errorf("messages = %v, answer messages = %v", updated.MessageTS, updated.AnswerTS)
+	}
+}
+
+func TestProcessFixRequest_RefusesRedactedAnswers(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "the secret is hunter2"})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	rec := &ConversationRecord{ID: newID(), Channel: "CFIXR", ThreadTS: "1.000100", User: "U1", Query: "password?",
+		Answer: []string{"the secret is hunter2"}, MessageTS: []string{"2.000100"}}
+	conversations.Save(rec)
+	conversations.Redact(rec.ID)
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+	// Replies under the redaction notice and in the question's thread.
+	for _, thread := range []string{"2.000100", "1.000100"} {
+		processFixRequest(context.Background(), api, &slackevents.MessageEvent{User: "U1", Channel: "CFIXR", ThreadTimeStamp: thread, Text: "fix: again"}, pool)
+	}
+	pool.Shutdown()
+
+	if calls != 0 || len(api.updates) != 0 {
+		t.Errorf("redacted answer was regenerated: %d backend calls, updates %+v", calls, api.updates)
+	}
+	if _, ok := conversations.LatestInThread("CFIXR", "1.000100"); ok {
+		t.Error("a redacted answer should not be the thread's latest")
+	}
+}
+
+func TestApplyFix_KeepsDisclaimerAndNotices(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CFIXD": {NumberParts: true, Disclaimer: "Generated answer."}}})
+	defer setChannelSettings(channelSettings{})
+	answer := "First part.\n\nSecond part."
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: answer})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	ctx := withConversationID(context.Background(), newID())
+	processTask(ctx, api, slackevents.AppMentionEvent{User: "U1", Channel: "CFIXD"}, "two parts please")
+	rec, ok := conversations.Get(conversationIDFrom(ctx))
+	if !ok || len(rec.AnswerTS) < 2 || len(rec.MessageTS) <= len(rec.AnswerTS) {
+		t.Fatalf("expected answer messages apart from the notices, got %+v", rec)
+	}
+
+	answer = "One part."
+	applyFix(ctx, api, rec.ID, "U1", "shorter")
+	notices := map[string]bool{}
+	for _, ts := range rec.MessageTS {
+		notices[ts] = !slices.Contains(rec.AnswerTS, ts)
+	}
+	for _, ts := range api.deleted {
+		if notices[ts] {
+			t.Errorf("fix deleted a disclaimer or notice %s", ts)
+		}
+	}
+	if len(api.deleted) != len(rec.AnswerTS)-1 {
+		t.Errorf("expected the extra chunks deleted, got %v of %v", api.deleted, rec.AnswerTS)
+	}
+}
+
+func TestApplyFix_RendersLikeTheAnswer(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CFIXE": {EmojiPolicy: EmojiNone}}})
+	defer setChannelSettings(channelSettings{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Fixed :tada: now."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	rec := &ConversationRecord{ID: newID(), Channel: "CFIXE", User: "U1", Query: "q", Answer: []string{"Broken."}, MessageTS: []string{"3.000100"}}
+	conversations.Save(rec)
+	api := &fakeSlackClient{}
+	applyFix(context.Background(), api, rec.ID, "U1", "fix it")
+	if len(api.updates) != 1 || api.updates[0].Text() != "Fixed now." {
+		t.Errorf("expected the emoji policy applied to the fix, got %+v", api.updates)
+	}
+}
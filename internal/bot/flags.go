@@ -0,0 +1,316 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Feature Flags
+//
+// Subsystems check a flag before running, so they can be switched off per
+// workspace or per channel without a redeploy. State lives in the JSON file
+// named by FEATURE_FLAGS, which is re-read when it changes and rewritten by
+// the /admin/flags endpoint. A channel setting beats a workspace setting,
+// which beats the file's defaults, which beat the registered default. The
+// flags in effect are recorded on each request's span.
+const flagReloadInterval = 10 * time.Second
+
+const (
+	FlagStreaming  = "streaming"
+	FlagRetrieval  = "retrieval"
+	FlagModeration = "moderation"
+)
+
+type featureFlag struct {
+	Name        string `json:"name"`
+	Default     bool   `json:"default"`
+	Description string `json:"description"`
+}
+
+var flagRegistry = map[string]featureFlag{}
+
+func registerFlag(name string, def bool, description string) {
+	flagRegistry[name] = featureFlag{Name: name, Default: def, Description: description}
+}
+
+func init() {
+	registerFlag(FlagStreaming, true, "request streamed (SSE) answers from the backend")
+	registerFlag(FlagRetrieval, true, "let the backend and retriever plugins add internal context")
+	registerFlag(FlagModeration, true, "apply the blocklist content filter to outgoing messages")
+}
+
+type flagSettings struct {
+	Defaults   map[string]bool            `json:"defaults,omitempty"`
+	Workspaces map[string]map[string]bool `json:"workspaces,omitempty"`
+	Channels   map[string]map[string]bool `json:"channels,omitempty"`
+}
+
+type flagStore struct {
+	mu       sync.RWMutex
+	path     string
+	modified time.Time
+	settings flagSettings
+}
+
+var flags = &flagStore{}
+
+func validateFlagSettings(s flagSettings) error {
+	check := func(scope string, m map[string]bool) error {
+		for name := range m {
+			if _, ok := flagRegistry[name]; !ok {
+				return fmt.Errorf("%s: unknown flag %q", scope, name)
+			}
+		}
+		return nil
+	}
+	if err := check("defaults", s.Defaults); err != nil {
+		return err
+	}
+	for id, m := range s.Workspaces {
+		if err := check("workspace "+id, m); err != nil {
+			return err
+		}
+	}
+	for id, m := range s.Channels {
+		if err := check("channel "+id, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads path when it changed since the last load; a missing file means
+// no overrides.
+func (f *flagStore) load(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		f.mu.Lock()
+		f.path = path
+		f.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f.mu.RLock()
+	unchanged := f.path == path && info.ModTime().Equal(f.modified)
+	f.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var s flagSettings
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if err := validateFlagSettings(s); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.path, f.modified, f.settings = path, info.ModTime(), s
+	return nil
+}
+
+// watch re-reads the flag file until ctx is done.
+func (f *flagStore) watch(ctx context.Context) {
+	ticker := time.NewTicker(flagReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.mu.RLock()
+			path := f.path
+			f.mu.RUnlock()
+			if err := f.load(path); err != nil {
+				slog.ErrorContext(ctx, "Failed to reload feature flags", "err", err)
+			}
+		}
+	}
+}
+
+func (f *flagStore) enabled(name, workspace, channel string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if v, ok := f.settings.Channels[channel][name]; ok && channel != "" {
+		return v
+	}
+	if v, ok := f.settings.Workspaces[workspace][name]; ok && workspace != "" {
+		return v
+	}
+	if v, ok := f.settings.Defaults[name]; ok {
+		return v
+	}
+	return flagRegistry[name].Default
+}
+
+// set changes a flag for a scope ("default", "workspace" or "channel"); a
+// nil value removes the override. The file is rewritten when configured.
+func (f *flagStore) set(name, scope, id string, value *bool) error {
+	if _, ok := flagRegistry[name]; !ok {
+		return fmt.Errorf("unknown flag %q", name)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var m map[string]bool
+	switch scope {
+	case "default":
+		if f.settings.Defaults == nil {
+			f.settings.Defaults = map[string]bool{}
+		}
+		m = f.settings.Defaults
+	case "workspace", "channel":
+		if id == "" {
+			return fmt.Errorf("%s scope needs an id", scope)
+		}
+		scopes := &f.settings.Workspaces
+		if scope == "channel" {
+			scopes = &f.settings.Channels
+		}
+		if *scopes == nil {
+			*scopes = map[string]map[string]bool{}
+		}
+		if (*scopes)[id] == nil {
+			(*scopes)[id] = map[string]bool{}
+		}
+		m = (*scopes)[id]
+	default:
+		return fmt.Errorf("unknown scope %q", scope)
+	}
+	if value == nil {
+		delete(m, name)
+	} else {
+		m[name] = *value
+	}
+	return f.saveLocked()
+}
+
+// snapshot returns a copy of the flag overrides.
+func (f *flagStore) snapshot() flagSettings {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	copyScopes := func(in map[string]map[string]bool) map[string]map[string]bool {
+		if in == nil {
+			return nil
+		}
+		out := make(map[string]map[string]bool, len(in))
+		for id, m := range in {
+			out[id] = maps.Clone(m)
+		}
+		return out
+	}
+	return flagSettings{
+		Defaults:   maps.Clone(f.settings.Defaults),
+		Workspaces: copyScopes(f.settings.Workspaces),
+		Channels:   copyScopes(f.settings.Channels),
+	}
+}
+
+// replace swaps in validated overrides, rewriting the file when configured.
+func (f *flagStore) replace(s flagSettings) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.settings = s
+	return f.saveLocked()
+}
+
+func (f *flagStore) saveLocked() error {
+	if f.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(f.settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(f.path, data, 0o644); err != nil {
+		return err
+	}
+	if info, err := os.Stat(f.path); err == nil {
+		f.modified = info.ModTime()
+	}
+	return nil
+}
+
+type workspaceKey struct{}
+
+// withWorkspace records the Slack workspace an event came from.
+func withWorkspace(ctx context.Context, teamID string) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, teamID)
+}
+
+func workspaceFrom(ctx context.Context) string {
+	team, _ := ctx.Value(workspaceKey{}).(string)
+	return team
+}
+
+// flagEnabled resolves a flag for the workspace in ctx and channel.
+func flagEnabled(ctx context.Context, name, channel string) bool {
+	return flags.enabled(name, workspaceFrom(ctx), channel)
+}
+
+// recordFlags adds every flag's state for the request to span.
+func recordFlags(ctx context.Context, span trace.Span, channel string) {
+	for name := range flagRegistry {
+		span.SetAttributes(attribute.Bool("flag."+name, flagEnabled(ctx, name, channel)))
+	}
+}
+
+type flagState struct {
+	featureFlag
+	Enabled bool `json:"enabled"`
+}
+
+type flagUpdate struct {
+	Flag    string `json:"flag"`
+	Scope   string `json:"scope"`
+	ID      string `json:"id,omitempty"`
+	Enabled *bool  `json:"enabled"`
+}
+
+// adminFlagsHandler lists flags and their state for ?workspace= and
+// ?channel= (GET) or changes one (POST, a flagUpdate body).
+func adminFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		out := []flagState{}
+		for _, fl := range flagRegistry {
+			out = append(out, flagState{featureFlag: fl, Enabled: flags.enabled(fl.Name, q.Get("workspace"), q.Get("channel"))})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+		json.NewEncoder(w).Encode(out)
+	case http.MethodPost:
+		var u flagUpdate
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&u); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := flags.set(u.Flag, u.Scope, u.ID, u.Enabled); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.InfoContext(r.Context(), "Feature flag changed", "flag", u.Flag, "scope", u.Scope, "id", u.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
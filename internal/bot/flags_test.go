@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestFlagStore_Precedence(t *testing.T) {
+	f := &flagStore{}
+	path := filepath.Join(t.TempDir(), "flags.json")
+	os.WriteFile(path, []byte(`{
+		"defaults": {"streaming": false},
+		"workspaces": {"T1": {"moderation": false, "streaming": true}},
+		"channels": {"C1": {"moderation": true}}
+	}`), 0o644)
+	if err := f.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, workspace, channel string
+		want                     bool
+	}{
+		{FlagRetrieval, "T1", "C1", true},   // registered default
+		{FlagStreaming, "T2", "C2", false},  // file default
+		{FlagStreaming, "T1", "C2", true},   // workspace beats default
+		{FlagModeration, "T1", "C2", false}, // workspace
+		{FlagModeration, "T1", "C1", true},  // channel beats workspace
+		{FlagModeration, "", "CX", true},    // unknown workspace
+		{FlagModeration, "T1", "", false},   // no channel
+		{"no_such_flag", "", "", false},
+	} {
+		if got := f.enabled(tc.name, tc.workspace, tc.channel); got != tc.want {
+			t.Errorf("%s for %s/%s = %v, want %v", tc.name, tc.workspace, tc.channel, got, tc.want)
+		}
+	}
+
+	os.WriteFile(path, []byte(`{"defaults": {"suggestions": true}}`), 0o644)
+	os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if err := f.load(path); err == nil || !strings.Contains(err.Error(), "unknown flag") {
+		t.Errorf("expected unknown flag error, got %v", err)
+	}
+}
+
+func TestAdminFlagsHandler_SetAndPersist(t *testing.T) {
+	defer func(f *flagStore) { flags = f }(flags)
+	flags = &flagStore{}
+	f := flags
+	path := filepath.Join(t.TempDir(), "flags.json")
+	if err := f.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(body string) int {
+		rr := httptest.NewRecorder()
+		adminFlagsHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/flags", strings.NewReader(body)))
+		return rr.Code
+	}
+	if code := post(`{"flag":"retrieval","scope":"channel","id":"C9","enabled":false}`); code != http.StatusNoContent {
+		t.Fatalf("POST returned %d", code)
+	}
+	if code := post(`{"flag":"retrieval","scope":"channel","enabled":false}`); code != http.StatusBadRequest {
+		t.Errorf("missing id should be rejected, got %d", code)
+	}
+	if code := post(`{"flag":"telepathy","scope":"default","enabled":true}`); code != http.StatusBadRequest {
+		t.Errorf("unknown flag should be rejected, got %d", code)
+	}
+
+	rr := httptest.NewRecorder()
+	adminFlagsHandler(rr, httptest.NewRequest(http.MethodGet, "/admin/flags?channel=C9", nil))
+	var states []flagState
+	json.NewDecoder(rr.Body).Decode(&states)
+	for _, s := range states {
+		if s.Name == FlagRetrieval && s.Enabled {
+			t.Errorf("retrieval should be off for C9")
+		}
+	}
+
+	reloaded := &flagStore{}
+	if err := reloaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.enabled(FlagRetrieval, "", "C9") {
+		t.Error("flag change was not persisted")
+	}
+
+	// A null value clears the override.
+	post(`{"flag":"retrieval","scope":"channel","id":"C9","enabled":null}`)
+	if !f.enabled(FlagRetrieval, "", "C9") {
+		t.Error("override was not cleared")
+	}
+}
+
+func TestFlags_GateSubsystems(t *testing.T) {
+	defer func(f *flagStore) { flags = f }(flags)
+	flags = &flagStore{}
+	f := flags
+	off := false
+	f.set(FlagModeration, "workspace", "TOFF", &off)
+	f.set(FlagRetrieval, "channel", "CNORET", &off)
+	f.set(FlagStreaming, "channel", "CNORET", &off)
+
+	filter, _ := newBlocklistFilter([]string{"darn"}, FilterModeMask)
+	msg := &outgoingMessage{Channel: "C1", Text: "darn"}
+	filter.Apply(withWorkspace(context.Background(), "TOFF"), msg)
+	if msg.Text != "darn" {
+		t.Errorf("moderation off should leave text alone, got %q", msg.Text)
+	}
+	filter.Apply(withWorkspace(context.Background(), "TON"), msg)
+	if msg.Text != "****" {
+		t.Errorf("moderation on should mask, got %q", msg.Text)
+	}
+
+	var got backend.ChatRequest
+	var accept string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		accept = r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"full_response":"ok"}`))
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	processTask(context.Background(), &fakeSlackClient{}, slackevents.AppMentionEvent{User: "U1", Channel: "CNORET"}, "q")
+	if !got.DisableInternalRetrieval || accept != "application/json" {
+		t.Errorf("flags not applied to backend request: retrieval disabled=%v accept=%q", got.DisableInternalRetrieval, accept)
+	}
+}
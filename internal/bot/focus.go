@@ -0,0 +1,228 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Focus Answers
+//
+// "!focus [duration]" in a thread turns on focus mode there for a while
+// (FOCUS_WINDOW, 15 minutes by default, at most an hour). While an answer
+// is being written in a focused thread, further questions asked there are
+// held instead of being answered alongside it, and their askers are told
+// so. When the answer completes, the held questions are posted as "queued
+// follow-ups" and answered one at a time in the order they were asked, so
+// a long technical answer is not interleaved with others. "!focus off"
+// ends focus mode; questions already held are still answered. Threads
+// focused, questions held and follow-ups released are counted under
+// "focus_mode" on /debug/vars.
+const (
+	defaultFocusWindow = 15 * time.Minute
+	maxFocusWindow     = time.Hour
+)
+
+var metricFocusMode = expvar.NewMap("focus_mode")
+
+type heldFollowUp struct {
+	User  string
+	Query string
+	Run   func()
+}
+
+type focusThread struct {
+	Until time.Time
+	// Answering counts answers in progress in the thread.
+	Answering int
+	Held      []heldFollowUp
+}
+
+type focusThreads struct {
+	mu      sync.Mutex
+	window  time.Duration
+	threads map[string]*focusThread
+	now     func() time.Time
+}
+
+func newFocusThreads(window time.Duration, now func() time.Time) *focusThreads {
+	return &focusThreads{window: window, threads: make(map[string]*focusThread), now: now}
+}
+
+var focus = newFocusThreads(defaultFocusWindow, time.Now)
+
+func (f *focusThreads) configure(window time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.window = window
+}
+
+// enable focuses the thread for d, or the configured window when d is zero,
+// and returns when focus ends.
+func (f *focusThreads) enable(key string, d time.Duration) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d <= 0 {
+		d = f.window
+	}
+	t, ok := f.threads[key]
+	if !ok {
+		t = &focusThread{}
+		f.threads[key] = t
+	}
+	t.Until = f.now().Add(d)
+	return t.Until
+}
+
+func (f *focusThreads) disable(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t, ok := f.threads[key]; ok {
+		t.Until = time.Time{}
+		f.forgetLocked(key, t)
+	}
+}
+
+// forgetLocked drops an idle thread whose focus has ended.
+func (f *focusThreads) forgetLocked(key string, t *focusThread) {
+	if t.Answering == 0 && len(t.Held) == 0 && !f.now().Before(t.Until) {
+		delete(f.threads, key)
+	}
+}
+
+// hold keeps run for later when the thread is focused and an answer is in
+// progress there, and returns how many follow-ups are now held.
+func (f *focusThreads) hold(key, user, query string, run func()) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.threads[key]
+	if !ok {
+		return 0
+	}
+	if t.Answering == 0 || !f.now().Before(t.Until) {
+		f.forgetLocked(key, t)
+		return 0
+	}
+	t.Held = append(t.Held, heldFollowUp{User: user, Query: query, Run: run})
+	return len(t.Held)
+}
+
+// begin marks an answer in progress, reporting whether the thread is
+// tracked; only then must finish be called.
+func (f *focusThreads) begin(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.threads[key]
+	if !ok {
+		return false
+	}
+	t.Answering++
+	return true
+}
+
+// finish ends an answer and, once none are left in progress, hands back
+// the held follow-ups.
+func (f *focusThreads) finish(key string) []heldFollowUp {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.threads[key]
+	if !ok {
+		return nil
+	}
+	t.Answering--
+	if t.Answering > 0 {
+		return nil
+	}
+	held := t.Held
+	t.Held = nil
+	f.forgetLocked(key, t)
+	return held
+}
+
+// focusedTask wraps an answer so that follow-ups held while it runs are
+// released when it completes.
+func focusedTask(ctx context.Context, api SlackClient, pool *workerpool.Pool, channel, threadTS string, task func()) func() {
+	key := conversationKey(channel, threadTS)
+	return func() {
+		if !focus.begin(key) {
+			task()
+			return
+		}
+		defer func() {
+			releaseFollowUps(ctx, api, pool, channel, threadTS, focus.finish(key))
+		}()
+		task()
+	}
+}
+
+// holdFollowUp holds query when its thread is focused on another answer and
+// tells the asker it will be answered afterwards.
+func holdFollowUp(ctx context.Context, api SlackClient, channel, threadTS, user, query string, run func()) bool {
+	n := focus.hold(conversationKey(channel, threadTS), user, query, run)
+	if n == 0 {
+		return false
+	}
+	metricFocusMode.Add("held", 1)
+	requests.record(ctx, "focus_hold", fmt.Sprintf("follow-up %d in a focused thread", n))
+	sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: "This thread is in focus mode. I'll answer your question as a queued follow-up once the current answer is done."},
+		append([]slack.MsgOption{slack.MsgOptionPostEphemeral(user)}, threadOptions(threadTS)...)...,
+	)
+	return true
+}
+
+func formatQueuedFollowUps(held []heldFollowUp) string {
+	var b strings.Builder
+	b.WriteString("*Queued follow-ups*, answering in order:\n")
+	for i, h := range held {
+		fmt.Fprintf(&b, "%d. <@%s>: %s\n", i+1, h.User, h.Query)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// releaseFollowUps posts the held questions and answers them one at a time.
+func releaseFollowUps(ctx context.Context, api SlackClient, pool *workerpool.Pool, channel, threadTS string, held []heldFollowUp) {
+	if len(held) == 0 {
+		return
+	}
+	metricFocusMode.Add("released", int64(len(held)))
+	slog.InfoContext(ctx, "Releasing queued follow-ups", "count", len(held), "thread", messageKey(channel, threadTS))
+	sendMessage(ctx, api, outgoingMessage{Channel: channel, Text: formatQueuedFollowUps(held)}, threadOptions(threadTS)...)
+	for _, h := range held {
+		queueTask(ctx, api, pool, laneKey(channel, threadTS), channel, threadTS, h.User, h.Run, nil)
+	}
+}
+
+func init() {
+	registerCommand("focus", command{
+		Usage: "[duration] | off (in a thread; holds follow-ups while an answer is written)",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			key := conversationKey(ev.Channel, ev.ThreadTimeStamp)
+			if strings.EqualFold(args, "off") {
+				focus.disable(key)
+				sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: "Focus mode is off in this thread."}, threadOptions(ev.ThreadTimeStamp)...)
+				return
+			}
+			var d time.Duration
+			if args != "" {
+				var err error
+				if d, err = time.ParseDuration(args); err != nil || d <= 0 || d > maxFocusWindow {
+					notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Usage: `!focus [duration]` with a duration up to %s, or `!focus off`", maxFocusWindow))
+					return
+				}
+			}
+			until := focus.enable(key, d)
+			metricFocusMode.Add("enabled", 1)
+			slog.InfoContext(ctx, "Thread focused", "user", ev.User, "thread", messageKey(ev.Channel, ev.ThreadTimeStamp), "until", until.Format(time.RFC3339))
+			text := fmt.Sprintf("Focus mode is on in this thread until %s. Questions asked while I'm answering will be queued as follow-ups.", until.Format("15:04 MST"))
+			sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: text}, threadOptions(ev.ThreadTimeStamp)...)
+		},
+	})
+}
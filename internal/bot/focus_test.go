@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestFocus_HoldsFollowUpsUntilAnswerCompletes(t *testing.T) {
+	defer func(f *focusThreads) { focus = f }(focus)
+	focus = newFocusThreads(time.Minute, time.Now)
+	focus.enable(conversationKey("CFOC", "5.0"), 0)
+
+	pool := workerpool.New(2)
+	api := &fakeSlackClient{}
+	ctx := context.Background()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	answer := func(name string) func() {
+		return func() {
+			if name == "long" {
+				close(started)
+				<-release
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+
+	pool.Submit(focusedTask(ctx, api, pool, "CFOC", "5.0", answer("long")))
+	<-started
+	for _, q := range []string{"first", "second"} {
+		run := focusedTask(ctx, api, pool, "CFOC", "5.0", answer(q))
+		if !holdFollowUp(ctx, api, "CFOC", "5.0", "U2", q, run) {
+			t.Fatalf("%s follow-up not held", q)
+		}
+	}
+	if held := holdFollowUp(ctx, api, "CFOC", "6.0", "U2", "elsewhere", func() {}); held {
+		t.Error("question in an unfocused thread held")
+	}
+	close(release)
+	waitIdle(pool, time.Second)
+	pool.Shutdown()
+
+	if strings.Join(order, ",") != "long,first,second" {
+		t.Errorf("answered %v, want long,first,second", order)
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	last := api.posts[len(api.posts)-1]
+	if !strings.Contains(last.Text(), "Queued follow-ups") || !strings.Contains(last.Text(), "2. <@U2>: second") || last.Values.Get("thread_ts") != "5.0" {
+		t.Errorf("follow-up list %q in %v", last.Text(), last.Values)
+	}
+}
+
+func TestFocus_ExpiresAndTurnsOff(t *testing.T) {
+	now := time.Now()
+	focus := newFocusThreads(time.Minute, func() time.Time { return now })
+	key := conversationKey("CFOC", "7.0")
+
+	focus.enable(key, time.Minute)
+	focus.begin(key)
+	if n := focus.hold(key, "U1", "q", func() {}); n != 1 {
+		t.Fatalf("held %d, want 1", n)
+	}
+	now = now.Add(2 * time.Minute)
+	if n := focus.hold(key, "U1", "late", func() {}); n != 0 {
+		t.Error("question held after focus expired")
+	}
+	if held := focus.finish(key); len(held) != 1 {
+		t.Errorf("released %d follow-ups, want the one held before expiry", len(held))
+	}
+	if _, ok := focus.threads[key]; ok {
+		t.Error("expired thread still tracked")
+	}
+
+	focus.enable(key, time.Minute)
+	focus.disable(key)
+	if focus.begin(key) {
+		t.Error("thread still focused after !focus off")
+	}
+}
+
+func TestFocusCommand(t *testing.T) {
+	defer func(f *focusThreads) { focus = f }(focus)
+	focus = newFocusThreads(time.Minute, time.Now)
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{User: "U1", Channel: "CFOC", ThreadTimeStamp: "8.0"}
+
+	dispatchCommand(context.Background(), api, ev, "!focus 2h")
+	if len(focus.threads) != 0 || !strings.Contains(api.posts[0].Text(), "Usage") {
+		t.Errorf("over-long focus accepted: %q", api.posts[0].Text())
+	}
+	dispatchCommand(context.Background(), api, ev, "!focus 10m")
+	if _, ok := focus.threads[conversationKey("CFOC", "8.0")]; !ok {
+		t.Fatal("thread not focused")
+	}
+	if post := api.posts[1]; !strings.Contains(post.Text(), "Focus mode is on") || post.Values.Get("thread_ts") != "8.0" {
+		t.Errorf("announcement %q in %v", post.Text(), post.Values)
+	}
+}
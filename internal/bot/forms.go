@@ -0,0 +1,260 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Backend Forms
+//
+// A backend can ask the user for structured input by sending a "form"
+// event (or a "form" field on a JSON response) instead of guessing. A form
+// with a single choice field of up to maxFormButtons options is shown as
+// buttons; anything else gets a "Fill in" button that opens a modal with
+// one input per field. Only the person who asked can answer. Their answer
+// is sent back to the backend as form_response on a new request in the
+// same place, which can in turn send another form, so multi-step flows
+// such as incident intake run entirely through the relay. Unanswered forms
+// expire after formTTL. Forms are counted under "backend_forms" on
+// /debug/vars.
+const (
+	ActionFormChoice  = "form_choice"
+	ActionFormOpen    = "form_open"
+	CallbackFormModal = "backend_form"
+
+	maxFormButtons = 5
+	formTTL        = 24 * time.Hour
+)
+
+var metricForms = expvar.NewMap("backend_forms")
+
+// formButtons reports whether f can be answered with one click.
+func formButtons(f *backend.Form) bool {
+	return len(f.Fields) == 1 && f.Fields[0].Type == backend.FormFieldChoice && len(f.Fields[0].Options) <= maxFormButtons
+}
+
+type pendingForm struct {
+	Form         backend.Form
+	Channel      string
+	User         string
+	ThreadTS     string
+	MessageTS    string
+	Query        string
+	ReplyOptions []slack.MsgOption
+	Created      time.Time
+}
+
+var (
+	pendingFormsMu sync.Mutex
+	pendingForms   = map[string]*pendingForm{}
+)
+
+type formResponseKey struct{}
+
+func withFormResponse(ctx context.Context, r *backend.FormResponse) context.Context {
+	return context.WithValue(ctx, formResponseKey{}, r)
+}
+
+func formResponseFrom(ctx context.Context) *backend.FormResponse {
+	r, _ := ctx.Value(formResponseKey{}).(*backend.FormResponse)
+	return r
+}
+
+func formBlocks(key string, f backend.Form) []slack.Block {
+	prompt := f.Prompt
+	if f.Title != "" {
+		prompt = "*" + f.Title + "*\n" + prompt
+	}
+	var buttons []slack.BlockElement
+	if formButtons(&f) {
+		for _, opt := range f.Fields[0].Options {
+			buttons = append(buttons, slack.NewButtonBlockElement(ActionFormChoice, key+"|"+opt, slack.NewTextBlockObject(slack.PlainTextType, opt, false, false)))
+		}
+	} else {
+		buttons = append(buttons, slack.NewButtonBlockElement(ActionFormOpen, key, slack.NewTextBlockObject(slack.PlainTextType, "Fill in", false, false)).WithStyle(slack.StylePrimary))
+	}
+	return []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, prompt, false, false), nil, nil),
+		slack.NewActionBlock("form_"+key, buttons...),
+	}
+}
+
+// postForm shows a backend form to the asker and waits for their answer.
+func postForm(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, query string, f *backend.Form, replyOptions ...slack.MsgOption) {
+	if err := f.Validate(); err != nil {
+		metricForms.Add("invalid", 1)
+		slog.WarnContext(ctx, "Ignored invalid backend form", "err", err)
+		requests.recordError(ctx, fmt.Sprintf("invalid form: %v", err))
+		return
+	}
+	key := newID()
+	ts, err := sendBlocks(ctx, api, ev.Channel, ev.User, f.Prompt, formBlocks(key, *f), replyOptions...)
+	if err != nil {
+		return
+	}
+	pendingFormsMu.Lock()
+	for k, p := range pendingForms {
+		if time.Since(p.Created) > formTTL {
+			delete(pendingForms, k)
+		}
+	}
+	pendingForms[key] = &pendingForm{Form: *f, Channel: ev.Channel, User: ev.User, ThreadTS: ev.ThreadTimeStamp, MessageTS: ts,
+		Query: query, ReplyOptions: replyOptions, Created: time.Now()}
+	pendingFormsMu.Unlock()
+	metricForms.Add("shown", 1)
+	requests.record(ctx, "form", fmt.Sprintf("%s, %d fields", f.ID, len(f.Fields)))
+}
+
+// claimForm returns the pending form for user, removing it so it is
+// answered once. A notice is returned when the user cannot answer it.
+func claimForm(key, user string) (*pendingForm, string) {
+	pendingFormsMu.Lock()
+	defer pendingFormsMu.Unlock()
+	p, ok := pendingForms[key]
+	switch {
+	case !ok || time.Since(p.Created) > formTTL:
+		delete(pendingForms, key)
+		return nil, "This form has expired or was already answered."
+	case p.User != user:
+		return nil, fmt.Sprintf("Only <@%s> can answer this form.", p.User)
+	}
+	delete(pendingForms, key)
+	return p, ""
+}
+
+func peekForm(key string) (*pendingForm, bool) {
+	pendingFormsMu.Lock()
+	defer pendingFormsMu.Unlock()
+	p, ok := pendingForms[key]
+	return p, ok
+}
+
+func formModal(key string, f backend.Form) slack.ModalViewRequest {
+	blocks := []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, f.Prompt, false, false), nil, nil)}
+	for _, field := range f.Fields {
+		label := slack.NewTextBlockObject(slack.PlainTextType, field.Label, false, false)
+		if field.Label == "" {
+			label = slack.NewTextBlockObject(slack.PlainTextType, field.Name, false, false)
+		}
+		var element slack.BlockElement
+		if field.Type == backend.FormFieldChoice {
+			options := make([]*slack.OptionBlockObject, 0, len(field.Options))
+			for _, opt := range field.Options {
+				options = append(options, slack.NewOptionBlockObject(opt, slack.NewTextBlockObject(slack.PlainTextType, opt, false, false), nil))
+			}
+			element = slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, slack.NewTextBlockObject(slack.PlainTextType, "Choose", false, false), field.Name, options...)
+		} else {
+			input := slack.NewPlainTextInputBlockElement(nil, field.Name)
+			input.Multiline = field.Multiline
+			element = input
+		}
+		block := slack.NewInputBlock(field.Name, label, nil, element)
+		block.Optional = field.Optional
+		blocks = append(blocks, block)
+	}
+	title := f.Title
+	if title == "" {
+		title = "More details"
+	}
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      CallbackFormModal,
+		PrivateMetadata: key,
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, truncate(title, 24), false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Send", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks:          slack.Blocks{BlockSet: blocks},
+	}
+}
+
+// handleFormAction handles the buttons on a form message.
+func handleFormAction(ctx context.Context, api SlackClient, callback slack.InteractionCallback, action *slack.BlockAction) {
+	channel, user := callback.Channel.ID, callback.User.ID
+	switch action.ActionID {
+	case ActionFormChoice:
+		key, choice, _ := strings.Cut(action.Value, "|")
+		p, notice := claimForm(key, user)
+		if notice != "" {
+			notifyUser(ctx, api, channel, user, notice)
+			return
+		}
+		continueForm(ctx, api, p, user, map[string]string{p.Form.Fields[0].Name: choice})
+	case ActionFormOpen:
+		p, ok := peekForm(action.Value)
+		switch {
+		case !ok:
+			notifyUser(ctx, api, channel, user, "This form has expired or was already answered.")
+		case p.User != user:
+			notifyUser(ctx, api, channel, user, fmt.Sprintf("Only <@%s> can answer this form.", p.User))
+		default:
+			if _, err := api.OpenViewContext(ctx, callback.TriggerID, formModal(action.Value, p.Form)); err != nil {
+				slog.ErrorContext(ctx, "Failed to open the form", "err", err)
+			}
+		}
+	}
+}
+
+func handleFormSubmission(ctx context.Context, api SlackClient, callback slack.InteractionCallback) {
+	p, notice := claimForm(callback.View.PrivateMetadata, callback.User.ID)
+	if notice != "" {
+		return
+	}
+	values := map[string]string{}
+	for _, field := range p.Form.Fields {
+		v := callback.View.State.Values[field.Name][field.Name]
+		if field.Type == backend.FormFieldChoice {
+			values[field.Name] = v.SelectedOption.Value
+		} else {
+			values[field.Name] = strings.TrimSpace(v.Value)
+		}
+	}
+	continueForm(ctx, api, p, callback.User.ID, values)
+}
+
+// continueForm records the answer on the form message and sends it to the
+// backend.
+func continueForm(ctx context.Context, api SlackClient, p *pendingForm, user string, values map[string]string) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "form_response")
+	defer span.End()
+	span.SetAttributes(attribute.String("form.id", p.Form.ID), attribute.String("user.id", user))
+	metricForms.Add("answered", 1)
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var summary []string
+	for _, name := range names {
+		if values[name] != "" {
+			summary = append(summary, fmt.Sprintf("%s: %s", name, values[name]))
+		}
+	}
+	answered := fmt.Sprintf("%s\n:white_check_mark: <@%s> answered: %s", p.Form.Prompt, user, strings.Join(summary, ", "))
+	msg := outgoingMessage{Channel: p.Channel, User: user, Text: answered}
+	applyOutgoingFilters(ctx, &msg)
+	if _, _, _, err := api.UpdateMessageContext(ctx, p.Channel, p.MessageTS, slack.MsgOptionText(msg.Text, false),
+		slack.MsgOptionBlocks(slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, msg.Text, false, false), nil, nil))); err != nil {
+		slog.ErrorContext(ctx, "Failed to mark the form as answered", "err", err)
+	}
+
+	ev := slackevents.AppMentionEvent{User: p.User, Channel: p.Channel, ThreadTimeStamp: p.ThreadTS, TimeStamp: p.MessageTS}
+	ctx = withRequestID(withFormResponse(ctx, &backend.FormResponse{ID: p.Form.ID, State: p.Form.State, Values: values}))
+	requests.record(ctx, "queued", "form response "+p.Form.ID)
+	query := p.Query
+	submitOrdered(ctx, api, workerPool, p.Channel, p.ThreadTS, user, func() {
+		processTask(ctx, api, ev, query, p.ReplyOptions...)
+	})
+}
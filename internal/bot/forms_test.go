@@ -0,0 +1,163 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestBackendForm_Validate(t *testing.T) {
+	valid := backend.Form{ID: "f", Prompt: "Severity?", Fields: []backend.FormField{{Name: "sev", Type: backend.FormFieldChoice, Options: []string{"low", "high"}}}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid form rejected: %v", err)
+	}
+	if !formButtons(&valid) {
+		t.Error("a single choice with few options should be shown as buttons")
+	}
+	for name, f := range map[string]backend.Form{
+		"no fields":      {ID: "f", Prompt: "p"},
+		"no prompt":      {ID: "f", Fields: valid.Fields},
+		"duplicate name": {ID: "f", Prompt: "p", Fields: []backend.FormField{{Name: "a", Type: backend.FormFieldText}, {Name: "a", Type: backend.FormFieldText}}},
+		"no options":     {ID: "f", Prompt: "p", Fields: []backend.FormField{{Name: "a", Type: backend.FormFieldChoice}}},
+		"unknown type":   {ID: "f", Prompt: "p", Fields: []backend.FormField{{Name: "a", Type: "date"}}},
+	} {
+		if err := f.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func pendingFormKey(t *testing.T) string {
+	t.Helper()
+	pendingFormsMu.Lock()
+	defer pendingFormsMu.Unlock()
+	for key := range pendingForms {
+		return key
+	}
+	t.Fatal("no pending form")
+	return ""
+}
+
+func TestProcessTask_FormContinuesConversation(t *testing.T) {
+	defer func(d time.Duration, p *workerpool.Pool) { postInterval, workerPool = d, p }(postInterval, workerPool)
+	postInterval = 0
+	workerPool = workerpool.New(1)
+
+	var mu sync.Mutex
+	var responses []*backend.FormResponse
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		responses = append(responses, req.FormResponse)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		if req.FormResponse == nil {
+			fmt.Fprint(w, `data: {"event":"message_part","text_chunk":"Let's open an incident."}`+"\n\n")
+			fmt.Fprint(w, `data: {"event":"form","form":{"id":"intake","prompt":"How bad is it?","state":"step1","fields":[{"name":"severity","type":"choice","options":["minor","major"]}]}}`+"\n\n")
+			return
+		}
+		fmt.Fprintf(w, "data: {\"event\":\"message_part\",\"text_chunk\":\"Filed a %s incident.\"}\n\n", req.FormResponse.Values["severity"])
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "UASK", Channel: "CFORM", TimeStamp: "300.000100"}, "open an incident",
+		slack.MsgOptionTS("300.000100"))
+	if len(api.posts) != 2 || !strings.Contains(api.posts[1].Values.Get("blocks"), ActionFormChoice) {
+		t.Fatalf("expected the answer and a form with buttons, got %+v", api.posts)
+	}
+	key := pendingFormKey(t)
+
+	click := func(user string) {
+		callback := slack.InteractionCallback{Type: slack.InteractionTypeBlockActions}
+		callback.Channel.ID = "CFORM"
+		callback.User.ID = user
+		callback.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: ActionFormChoice, Value: key + "|major"}}
+		handleInteraction(context.Background(), api, callback)
+	}
+	click("USOMEONE")
+	if _, ok := peekForm(key); !ok {
+		t.Fatal("only the asker may answer the form")
+	}
+	click("UASK")
+	workerPool.Shutdown()
+
+	if len(api.updates) != 1 || !strings.Contains(api.updates[0].Text(), "answered: severity: major") {
+		t.Errorf("form message should show the answer, got %+v", api.updates)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(responses) != 2 || responses[1] == nil || responses[1].ID != "intake" || responses[1].State != "step1" || responses[1].Values["severity"] != "major" {
+		t.Fatalf("backend did not get the form response: %+v", responses)
+	}
+	last := api.sent()[len(api.sent())-1]
+	if last.Text() != "Filed a major incident." || last.Values.Get("thread_ts") != "300.000100" {
+		t.Errorf("continuation not posted in the thread: %v", last.Values)
+	}
+	if _, ok := peekForm(key); ok {
+		t.Error("an answered form should be forgotten")
+	}
+}
+
+func TestFormModal_CollectsFields(t *testing.T) {
+	defer func(p *workerpool.Pool) { workerPool = p }(workerPool)
+	workerPool = workerpool.New(1)
+	var got *backend.FormResponse
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		got = req.FormResponse
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"full_response":"Thanks."}`)
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	form := backend.Form{ID: "details", Prompt: "Tell me more", Fields: []backend.FormField{
+		{Name: "service", Type: backend.FormFieldChoice, Options: []string{"api", "web"}},
+		{Name: "summary", Type: backend.FormFieldText, Multiline: true},
+	}}
+	api := &fakeSlackClient{}
+	postForm(context.Background(), api, slackevents.AppMentionEvent{User: "UASK", Channel: "CFORM2"}, "q", &form)
+	if formButtons(&form) || !strings.Contains(api.posts[0].Values.Get("blocks"), ActionFormOpen) {
+		t.Fatalf("a multi-field form should offer a modal, got %v", api.posts[0].Values)
+	}
+	key := pendingFormKey(t)
+
+	open := slack.InteractionCallback{Type: slack.InteractionTypeBlockActions, TriggerID: "trigger"}
+	open.Channel.ID = "CFORM2"
+	open.User.ID = "UASK"
+	open.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: ActionFormOpen, Value: key}}
+	handleInteraction(context.Background(), api, open)
+	if len(api.views) != 1 {
+		t.Fatalf("expected the form modal to open, got %d views", len(api.views))
+	}
+
+	submit := slack.InteractionCallback{Type: slack.InteractionTypeViewSubmission}
+	submit.User.ID = "UASK"
+	submit.View.CallbackID = CallbackFormModal
+	submit.View.PrivateMetadata = key
+	submit.View.State = &slack.ViewState{Values: map[string]map[string]slack.BlockAction{
+		"service": {"service": {SelectedOption: slack.OptionBlockObject{Value: "api"}}},
+		"summary": {"summary": {Value: " 500s on login "}},
+	}}
+	handleInteraction(context.Background(), api, submit)
+	workerPool.Shutdown()
+
+	if got == nil || got.Values["service"] != "api" || got.Values["summary"] != "500s on login" {
+		t.Errorf("backend got %+v", got)
+	}
+}
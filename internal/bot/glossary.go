@@ -0,0 +1,286 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Glossary
+//
+// GLOSSARY_FILE holds the organization's terms as a JSON list of
+// {"term", "definition", "url", "aliases"}. When a question mentions a term
+// (or an alias) its definition is sent to the backend as context, and the
+// first mention of each term with a URL in an answer is linked to that
+// page. Code, inline code and existing links are left alone. Admins edit
+// the glossary through /admin/glossary, which rewrites the file; channels
+// opt out with "disable_glossary". Injected definitions and links are
+// counted under "glossary" on /debug/vars.
+const maxGlossaryContext = 10
+
+// GlossaryEntry is one term in GLOSSARY_FILE.
+type GlossaryEntry struct {
+	Term       string   `json:"term"`
+	Definition string   `json:"definition,omitempty"`
+	URL        string   `json:"url,omitempty"`
+	Aliases    []string `json:"aliases,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+type glossaryStore struct {
+	mu      sync.RWMutex
+	path    string
+	entries []GlossaryEntry
+}
+
+var (
+	glossary       = &glossaryStore{}
+	metricGlossary = expvar.NewMap("glossary")
+
+	// glossaryProtected matches code blocks, inline code and Slack links,
+	// which are never linkified.
+	glossaryProtected = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`|<[^>\n]*>")
+)
+
+// compile validates e and builds the pattern that finds it as a whole word.
+func (e *GlossaryEntry) compile() error {
+	e.Term = strings.TrimSpace(e.Term)
+	if e.Term == "" {
+		return errors.New("term is empty")
+	}
+	if e.Definition == "" && e.URL == "" {
+		return fmt.Errorf("%s needs a definition or a URL", e.Term)
+	}
+	if e.URL != "" {
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("%s: invalid URL %q", e.Term, e.URL)
+		}
+	}
+	names := []string{regexp.QuoteMeta(e.Term)}
+	for _, a := range e.Aliases {
+		if a = strings.TrimSpace(a); a != "" {
+			names = append(names, regexp.QuoteMeta(a))
+		}
+	}
+	// Longer names first, so "SLO burn" wins over "SLO".
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	e.pattern = regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}_])(` + strings.Join(names, "|") + `)(?:[^\p{L}\p{N}_]|$)`)
+	return nil
+}
+
+func (g *glossaryStore) load(path string) error {
+	g.mu.Lock()
+	g.path = path
+	g.mu.Unlock()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []GlossaryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return g.replace(entries)
+}
+
+func (g *glossaryStore) replace(entries []GlossaryEntry) error {
+	for i := range entries {
+		if err := entries[i].compile(); err != nil {
+			return err
+		}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.entries = entries
+	return nil
+}
+
+func (g *glossaryStore) list() []GlossaryEntry {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append([]GlossaryEntry(nil), g.entries...)
+}
+
+// upsert adds e or replaces the entry with the same term, and saves.
+func (g *glossaryStore) upsert(e GlossaryEntry) error {
+	if err := e.compile(); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i := range g.entries {
+		if strings.EqualFold(g.entries[i].Term, e.Term) {
+			g.entries[i] = e
+			return g.saveLocked()
+		}
+	}
+	g.entries = append(g.entries, e)
+	sort.Slice(g.entries, func(i, j int) bool { return strings.ToLower(g.entries[i].Term) < strings.ToLower(g.entries[j].Term) })
+	return g.saveLocked()
+}
+
+// remove deletes term and saves, reporting whether it existed.
+func (g *glossaryStore) remove(term string) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i := range g.entries {
+		if strings.EqualFold(g.entries[i].Term, term) {
+			g.entries = append(g.entries[:i], g.entries[i+1:]...)
+			return true, g.saveLocked()
+		}
+	}
+	return false, nil
+}
+
+func (g *glossaryStore) saveLocked() error {
+	if g.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(g.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(g.path, data, 0o644)
+}
+
+// glossaryEnabled reports whether channel uses the glossary.
+func glossaryEnabled(channel string) bool {
+	return !channelConfigFor(channel).DisableGlossary
+}
+
+// glossaryContext returns the definitions of the terms query mentions.
+func glossaryContext(ctx context.Context, channel, query string) []string {
+	if !glossaryEnabled(channel) {
+		return nil
+	}
+	var out []string
+	for _, e := range glossary.list() {
+		if e.Definition == "" || !e.pattern.MatchString(query) {
+			continue
+		}
+		line := fmt.Sprintf("Glossary: %s means %s", e.Term, e.Definition)
+		if e.URL != "" {
+			line += " (" + e.URL + ")"
+		}
+		out = append(out, line)
+		if len(out) == maxGlossaryContext {
+			break
+		}
+	}
+	if len(out) > 0 {
+		metricGlossary.Add("definitions_injected", int64(len(out)))
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("glossary.terms", len(out)))
+	}
+	return out
+}
+
+// linkifyGlossary links the first mention of each glossary term with a URL
+// to its page, outside code and existing links.
+func linkifyGlossary(channel, text string) string {
+	if !glossaryEnabled(channel) {
+		return text
+	}
+	entries := glossary.list()
+	if len(entries) == 0 {
+		return text
+	}
+	linked := map[string]bool{}
+	var b strings.Builder
+	last := 0
+	link := func(plain string) {
+		type span struct {
+			start, end int
+			url        string
+		}
+		var spans []span
+	next:
+		for _, e := range entries {
+			if e.URL == "" || linked[e.Term] {
+				continue
+			}
+			for _, m := range e.pattern.FindAllStringSubmatchIndex(plain, -1) {
+				overlaps := false
+				for _, s := range spans {
+					overlaps = overlaps || (m[2] < s.end && s.start < m[3])
+				}
+				if !overlaps {
+					linked[e.Term] = true
+					spans = append(spans, span{m[2], m[3], e.URL})
+					continue next
+				}
+			}
+		}
+		sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+		at := 0
+		for _, s := range spans {
+			fmt.Fprintf(&b, "%s<%s|%s>", plain[at:s.start], s.url, plain[s.start:s.end])
+			at = s.end
+		}
+		b.WriteString(plain[at:])
+	}
+	for _, p := range glossaryProtected.FindAllStringIndex(text, -1) {
+		link(text[last:p[0]])
+		b.WriteString(text[p[0]:p[1]])
+		last = p[1]
+	}
+	link(text[last:])
+	if len(linked) > 0 {
+		metricGlossary.Add("terms_linked", int64(len(linked)))
+	}
+	return b.String()
+}
+
+// adminGlossaryHandler lists (GET), adds or replaces (POST) and deletes
+// (DELETE ?term=) glossary entries.
+func adminGlossaryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(glossary.list())
+	case http.MethodPost:
+		var e GlossaryEntry
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&e); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := glossary.upsert(e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.InfoContext(r.Context(), "Glossary term updated", "term", e.Term)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		term := r.URL.Query().Get("term")
+		found, err := glossary.remove(term)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "no such term", http.StatusNotFound)
+			return
+		}
+		slog.InfoContext(r.Context(), "Glossary term deleted", "term", term)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
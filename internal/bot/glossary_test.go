@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLinkifyGlossary(t *testing.T) {
+	defer func(g *glossaryStore) { glossary = g }(glossary)
+	glossary = &glossaryStore{}
+	if err := glossary.replace([]GlossaryEntry{
+		{Term: "SLO", Definition: "service level objective", URL: "https://wiki.example.com/slo"},
+		{Term: "error budget", URL: "https://wiki.example.com/budget", Aliases: []string{"budget"}},
+		{Term: "PagerDuty", Definition: "our paging tool"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ in, want string }{
+		{"Check the SLO, then the SLO dashboard.", "Check the <https://wiki.example.com/slo|SLO>, then the SLO dashboard."},
+		{"slos are not slo.", "slos are not <https://wiki.example.com/slo|slo>."},
+		{"Your Error Budget is spent.", "Your <https://wiki.example.com/budget|Error Budget> is spent."},
+		{"Run `slo status` first, then read the SLO.", "Run `slo status` first, then read the <https://wiki.example.com/slo|SLO>."},
+		{"See <https://example.com|the SLO page>.", "See <https://example.com|the SLO page>."},
+		{"Page via PagerDuty.", "Page via PagerDuty."},
+	}
+	for _, tt := range tests {
+		if got := linkifyGlossary("C1", tt.in); got != tt.want {
+			t.Errorf("linkifyGlossary(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGlossary_DisabledPerChannel(t *testing.T) {
+	defer func(g *glossaryStore) { glossary = g }(glossary)
+	glossary = &glossaryStore{}
+	if err := glossary.replace([]GlossaryEntry{{Term: "SLO", Definition: "service level objective", URL: "https://wiki.example.com/slo"}}); err != nil {
+		t.Fatal(err)
+	}
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"COFF": {DisableGlossary: true}}})
+	defer setChannelSettings(channelSettings{})
+
+	if got := linkifyGlossary("COFF", "the SLO"); got != "the SLO" {
+		t.Errorf("disabled channel was linkified: %q", got)
+	}
+	if got := glossaryContext(context.Background(), "COFF", "what is our SLO?"); got != nil {
+		t.Errorf("disabled channel got context %v", got)
+	}
+	got := glossaryContext(context.Background(), "CON", "what is our SLO?")
+	if len(got) != 1 || got[0] != "Glossary: SLO means service level objective (https://wiki.example.com/slo)" {
+		t.Errorf("glossaryContext = %v", got)
+	}
+}
+
+func TestAdminGlossaryHandler_EditsFile(t *testing.T) {
+	defer func(g *glossaryStore) { glossary = g }(glossary)
+	glossary = &glossaryStore{}
+	path := filepath.Join(t.TempDir(), "glossary.json")
+	if err := glossary.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	adminGlossaryHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/glossary", strings.NewReader(`{"term":"SLO","definition":"service level objective"}`)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("POST status %d: %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	adminGlossaryHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/glossary", strings.NewReader(`{"term":"bad","url":"javascript:alert(1)"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid URL accepted with status %d", rec.Code)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "service level objective") {
+		t.Errorf("glossary file not written: %s", data)
+	}
+
+	reloaded := &glossaryStore{}
+	if err := reloaded.load(path); err != nil || len(reloaded.list()) != 1 {
+		t.Fatalf("reload: %v, %v", reloaded.list(), err)
+	}
+
+	rec = httptest.NewRecorder()
+	adminGlossaryHandler(rec, httptest.NewRequest(http.MethodDelete, "/admin/glossary?term=slo", nil))
+	if rec.Code != http.StatusNoContent || len(glossary.list()) != 0 {
+		t.Errorf("DELETE status %d, entries %v", rec.Code, glossary.list())
+	}
+}
@@ -0,0 +1,432 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Leadership and Handover
+//
+// When several replicas share a workspace, only one of them should run the
+// scheduled jobs (daily summaries, weekly digests, self-tests). With
+// LEADER_LEASE_FILE pointing at a file every replica can reach (a shared
+// volume), the replicas compete for a lease stored there: the holder
+// renews it every third of LEADER_LEASE_TTL (default 30 seconds) and the
+// others take it over once it expires or is released. Reading, checking
+// and rewriting the lease happen while holding a lock file next to it,
+// created with O_EXCL, so two replicas cannot both take a free lease. A
+// replica that finds the lock taken keeps the lease it already holds until
+// it expires and tries again next time; a lock file older than the TTL was
+// left by a replica that died holding it and is removed. Without the file
+// every replica leads, as before. REPLICA_ID names this replica in the
+// lease (default hostname and PID).
+//
+// POST /admin/handover retires a replica for a rolling restart without
+// dropping work: it stops claiming new events, so Slack redelivers them to
+// the other connections, releases the lease and waits up to two lease
+// periods for a peer to take it, then disconnects and drains queued tasks
+// before exiting. An optional JSON body {"at": "<RFC 3339 time>"} schedules
+// the handover instead of starting it right away. GET shows the state.
+// Handovers and lease changes are counted under "handover" on /debug/vars.
+const (
+	defaultLeaseTTL = 30 * time.Second
+
+	leaseLockAttempts = 5
+	leaseLockRetry    = 20 * time.Millisecond
+)
+
+var (
+	metricHandover = expvar.NewMap("handover")
+
+	errLeaseLocked = errors.New("lease file is locked by another replica")
+)
+
+type leaseRecord struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+type leaderLease struct {
+	mu     sync.Mutex
+	path   string
+	id     string
+	ttl    time.Duration
+	held   bool
+	frozen bool
+	// expires is when the lease this replica last wrote runs out.
+	expires time.Time
+	now     func() time.Time
+}
+
+var leadership = &leaderLease{ttl: defaultLeaseTTL, now: time.Now}
+
+func defaultReplicaID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+func (l *leaderLease) configure(path, id string, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.path, l.id = path, id
+	if ttl > 0 {
+		l.ttl = ttl
+	}
+}
+
+// leads reports whether this replica should run the scheduled jobs.
+func (l *leaderLease) leads() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.path == "" || l.held
+}
+
+func (l *leaderLease) read() (leaseRecord, error) {
+	var rec leaseRecord
+	data, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return rec, nil
+	}
+	if err != nil {
+		return rec, err
+	}
+	if len(data) == 0 {
+		return rec, nil
+	}
+	return rec, json.Unmarshal(data, &rec)
+}
+
+// write replaces the lease file in one rename, so readers never see half
+// of it.
+func (l *leaderLease) write(rec leaseRecord) error {
+	data, _ := json.Marshal(rec)
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), ".lease-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+// lock takes the lease's lock file and returns the function that drops it.
+func (l *leaderLease) lock() (func(), error) {
+	path := l.path + ".lock"
+	for attempt := 1; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > l.ttl {
+			metricHandover.Add("stale_locks_removed", 1)
+			slog.Warn("Removing stale leader lease lock", "path", path, "age", time.Since(info.ModTime()))
+			os.Remove(path)
+			continue
+		}
+		if attempt == leaseLockAttempts {
+			return nil, errLeaseLocked
+		}
+		time.Sleep(leaseLockRetry)
+	}
+}
+
+// renew takes or extends the lease when it is free, expired or already
+// ours, and reports whether this replica holds it.
+func (l *leaderLease) renew() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path == "" {
+		return true
+	}
+	was := l.held
+	l.held = l.renewLocked()
+	if l.held != was {
+		if l.held {
+			metricHandover.Add("leadership_taken", 1)
+			slog.Info("This replica now leads the scheduled jobs", "replica", l.id)
+		} else {
+			metricHandover.Add("leadership_lost", 1)
+			slog.Info("This replica no longer leads the scheduled jobs", "replica", l.id)
+		}
+	}
+	return l.held
+}
+
+func (l *leaderLease) renewLocked() bool {
+	if l.frozen {
+		return false
+	}
+	now := l.now()
+	unlock, err := l.lock()
+	if err != nil {
+		slog.Warn("Failed to lock leader lease", "path", l.path, "err", err)
+		return l.held && now.Before(l.expires)
+	}
+	defer unlock()
+	rec, err := l.read()
+	if err != nil {
+		slog.Error("Failed to read leader lease", "path", l.path, "err", err)
+		return false
+	}
+	if rec.Holder != "" && rec.Holder != l.id && now.Before(rec.Expires) {
+		return false
+	}
+	expires := now.Add(l.ttl)
+	if err := l.write(leaseRecord{Holder: l.id, Expires: expires}); err != nil {
+		slog.Error("Failed to write leader lease", "path", l.path, "err", err)
+		return false
+	}
+	l.expires = expires
+	return true
+}
+
+// release gives the lease up for a peer to take and stops renewing it.
+func (l *leaderLease) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.frozen = true
+	if l.path == "" || !l.held {
+		return
+	}
+	l.held = false
+	unlock, err := l.lock()
+	if err != nil {
+		slog.Error("Failed to lock leader lease for release", "path", l.path, "err", err)
+		return
+	}
+	defer unlock()
+	if rec, err := l.read(); err == nil && rec.Holder == l.id {
+		if err := l.write(leaseRecord{}); err != nil {
+			slog.Error("Failed to release leader lease", "path", l.path, "err", err)
+			return
+		}
+	}
+	metricHandover.Add("leadership_released", 1)
+	slog.Info("Released the leader lease", "replica", l.id)
+}
+
+// holder returns the replica currently named in the lease.
+func (l *leaderLease) holder() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path == "" {
+		return l.id
+	}
+	rec, err := l.read()
+	if err != nil || !l.now().Before(rec.Expires) {
+		return ""
+	}
+	return rec.Holder
+}
+
+// waitForPeer polls until another replica holds the lease or timeout passes.
+func (l *leaderLease) waitForPeer(ctx context.Context, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if h := l.holder(); h != "" && h != l.id {
+			return true
+		}
+		if l.path == "" || time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+func (l *leaderLease) watch(ctx context.Context) {
+	l.renew()
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.release()
+			return
+		case <-ticker.C:
+			l.renew()
+		}
+	}
+}
+
+type handoverState string
+
+const (
+	handoverServing   handoverState = "serving"
+	handoverScheduled handoverState = "scheduled"
+	handoverDraining  handoverState = "handing_over"
+)
+
+type handoverControl struct {
+	mu     sync.Mutex
+	state  handoverState
+	at     time.Time
+	peer   string
+	stop   context.CancelFunc
+	cancel chan struct{}
+}
+
+var handover = &handoverControl{state: handoverServing}
+
+// attach gives the control the function that disconnects from Slack.
+func (h *handoverControl) attach(stop context.CancelFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stop = stop
+}
+
+func (h *handoverControl) current() handoverState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+// paused reports whether new events should be left for other replicas.
+func (h *handoverControl) paused() bool {
+	return h.current() == handoverDraining
+}
+
+type handoverStatus struct {
+	State   handoverState `json:"state"`
+	At      time.Time     `json:"at,omitzero"`
+	Replica string        `json:"replica"`
+	Leader  string        `json:"leader,omitempty"`
+	Leads   bool          `json:"leads"`
+	Peer    string        `json:"peer,omitempty"`
+	Pending int           `json:"pending_tasks"`
+}
+
+func (h *handoverControl) status() handoverStatus {
+	h.mu.Lock()
+	st := handoverStatus{State: h.state, At: h.at, Peer: h.peer}
+	h.mu.Unlock()
+	st.Replica, st.Leader, st.Leads = leadership.id, leadership.holder(), leadership.leads()
+	if workerPool != nil {
+		st.Pending = tasksPending(workerPool)
+	}
+	return st
+}
+
+// schedule starts the handover at at, or right away when at has passed.
+// A later call replaces a pending schedule; a running handover can't be
+// changed.
+func (h *handoverControl) schedule(at time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state == handoverDraining {
+		return fmt.Errorf("a handover is already in progress")
+	}
+	if h.cancel != nil {
+		close(h.cancel)
+	}
+	cancel := make(chan struct{})
+	h.state, h.at, h.cancel = handoverScheduled, at, cancel
+	go func() {
+		select {
+		case <-time.After(time.Until(at)):
+			h.run()
+		case <-cancel:
+		}
+	}()
+	return nil
+}
+
+// abort cancels a scheduled handover.
+func (h *handoverControl) abort() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.state == handoverScheduled {
+		close(h.cancel)
+		h.state, h.at, h.cancel = handoverServing, time.Time{}, nil
+	}
+}
+
+func (h *handoverControl) run() {
+	h.mu.Lock()
+	if h.state != handoverScheduled {
+		h.mu.Unlock()
+		return
+	}
+	h.state, h.cancel = handoverDraining, nil
+	stop := h.stop
+	h.mu.Unlock()
+	metricHandover.Add("started", 1)
+	slog.Info("Handover started: no longer claiming new events")
+
+	leadership.release()
+	peer := ""
+	if leadership.waitForPeer(context.Background(), 2*leadership.ttl) {
+		peer = leadership.holder()
+		slog.Info("Peer took over the scheduled jobs", "replica", peer)
+	} else if leadership.path != "" {
+		metricHandover.Add("no_peer", 1)
+		slog.Warn("No replica took over the leader lease; scheduled jobs pause until one does")
+	}
+	h.mu.Lock()
+	h.peer = peer
+	h.mu.Unlock()
+	if stop != nil {
+		// Disconnecting ends the event loop; main drains and exits.
+		stop()
+	}
+}
+
+type handoverRequest struct {
+	At    time.Time `json:"at"`
+	Abort bool      `json:"abort"`
+}
+
+func adminHandoverHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req handoverRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if req.Abort {
+			handover.abort()
+			slog.InfoContext(r.Context(), "Scheduled handover cancelled via the admin API")
+			break
+		}
+		at := req.At
+		if at.IsZero() {
+			at = time.Now()
+		}
+		if err := handover.schedule(at); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		slog.InfoContext(r.Context(), "Handover scheduled via the admin API", "at", at.UTC().Format(time.RFC3339))
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(handover.status())
+}
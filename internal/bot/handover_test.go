@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaderLease_OneHolderAndTakeover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease.json")
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	a := &leaderLease{now: clock}
+	b := &leaderLease{now: clock}
+	a.configure(path, "a", time.Minute)
+	b.configure(path, "b", time.Minute)
+
+	if !a.renew() || b.renew() {
+		t.Fatal("expected a to take the free lease and b to be refused")
+	}
+	if !a.leads() || b.leads() || b.holder() != "a" {
+		t.Fatalf("leads: a=%t b=%t, holder %q", a.leads(), b.leads(), b.holder())
+	}
+
+	now = now.Add(2 * time.Minute)
+	if !b.renew() || a.renew() {
+		t.Fatal("b should take over the expired lease")
+	}
+
+	b.release()
+	if b.leads() || b.renew() {
+		t.Error("a released lease must not be renewed by its old holder")
+	}
+	if !a.renew() {
+		t.Error("a peer should take a released lease at once")
+	}
+}
+
+func TestLeaderLease_RacingReplicasElectOne(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease.json")
+	replicas := make([]*leaderLease, 8)
+	for i := range replicas {
+		replicas[i] = &leaderLease{now: time.Now}
+		replicas[i].configure(path, fmt.Sprintf("r%d", i), time.Minute)
+	}
+	var wg sync.WaitGroup
+	var held atomic.Int32
+	for _, l := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.renew() {
+				held.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if held.Load() != 1 {
+		t.Fatalf("%d replicas took the free lease, want exactly one", held.Load())
+	}
+}
+
+func TestLeaderLease_LockedFileKeepsCurrentHolder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease.json")
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	a := &leaderLease{now: clock}
+	b := &leaderLease{now: clock}
+	a.configure(path, "a", time.Minute)
+	b.configure(path, "b", time.Minute)
+	if !a.renew() {
+		t.Fatal("a should take the free lease")
+	}
+
+	// A peer is in the middle of its own renewal.
+	if err := os.WriteFile(path+".lock", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Second)
+	if !a.renew() || b.renew() {
+		t.Fatal("a should keep its unexpired lease while the file is locked")
+	}
+	now = now.Add(time.Minute)
+	if a.renew() {
+		t.Fatal("a must not lead past its lease without renewing it")
+	}
+
+	// A lock older than the TTL is left over from a crash.
+	old := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(path+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !b.renew() {
+		t.Fatal("b should take the expired lease once the stale lock is removed")
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
+
+func TestLeaderLease_WithoutFileAlwaysLeads(t *testing.T) {
+	l := &leaderLease{now: time.Now}
+	l.configure("", "solo", 0)
+	if !l.leads() || !l.renew() {
+		t.Error("a replica without a lease file must lead")
+	}
+}
+
+func TestAdminHandover_ReleasesLeaseAndDisconnects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease.json")
+	defer func(l *leaderLease, h *handoverControl) { leadership, handover = l, h }(leadership, handover)
+	leadership = &leaderLease{now: time.Now}
+	leadership.configure(path, "old", time.Second)
+	leadership.renew()
+	peer := &leaderLease{now: time.Now}
+	peer.configure(path, "new", time.Second)
+	handover = &handoverControl{state: handoverServing}
+	stopped := make(chan struct{})
+	handover.attach(func() { close(stopped) })
+
+	rec := httptest.NewRecorder()
+	adminHandoverHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/handover", strings.NewReader(`{"at":"`+time.Now().Add(time.Hour).Format(time.RFC3339)+`"}`)))
+	if rec.Code != http.StatusAccepted || handover.current() != handoverScheduled || handover.paused() {
+		t.Fatalf("schedule: %d %s", rec.Code, rec.Body)
+	}
+	adminHandoverHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/admin/handover", strings.NewReader(`{"abort":true}`)))
+	if handover.current() != handoverServing {
+		t.Fatal("scheduled handover not cancelled")
+	}
+
+	adminHandoverHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/admin/handover", nil))
+	deadline := time.Now().Add(time.Second)
+	for !handover.paused() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !handover.paused() || leadership.leads() {
+		t.Fatal("handover should stop intake and release the lease")
+	}
+	if !peer.renew() {
+		t.Fatal("peer could not take the released lease")
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("replica did not disconnect after the peer took over")
+	}
+	if st := handover.status(); st.Peer != "new" {
+		t.Errorf("status %+v", st)
+	}
+	rec = httptest.NewRecorder()
+	readyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d during handover", rec.Code)
+	}
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// HTTP Events
+//
+// With SLACK_SIGNING_SECRET set, the relay also takes Events API deliveries
+// over HTTP at /slack/events, as Slack apps without Socket Mode send them.
+// Requests are checked against the signing secret and go through the same
+// intake as Socket Mode events. X-Slack-Retry-Num is the redelivery attempt,
+// so a retry of an event that was already accepted is acknowledged without
+// being answered twice. Where Socket Mode would leave an event
+// unacknowledged, during a handover or when ACK_OVERFLOW=delay finds the
+// queue full, the response is 503 so that Slack retries it; duplicates and
+// dropped events get 200 so that it does not. Deliveries are counted under
+// "http_events" on /debug/vars.
+const maxSlackEventBody = 1 << 20
+
+var slackSigningSecret string
+
+func configureHTTPEvents() {
+	slackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
+}
+
+var metricHTTPEvents = expvar.NewMap("http_events")
+
+// slackEventsHandler serves /slack/events, dispatching admitted events
+// like handleEvents does.
+func slackEventsHandler(api SlackClient, pool *workerpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if slackSigningSecret == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxSlackEventBody))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		verifier, err := slack.NewSecretsVerifier(r.Header, slackSigningSecret)
+		if err == nil {
+			verifier.Write(body)
+			err = verifier.Ensure()
+		}
+		if err != nil {
+			metricHTTPEvents.Add("unauthorized", 1)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		ev, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+		if err != nil {
+			metricHTTPEvents.Add("bad_requests", 1)
+			http.Error(w, "invalid event", http.StatusBadRequest)
+			return
+		}
+		if ev.Type == slackevents.URLVerification {
+			challenge, _ := ev.Data.(*slackevents.EventsAPIURLVerificationEvent)
+			if challenge == nil {
+				http.Error(w, "invalid challenge", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, challenge.Challenge)
+			return
+		}
+		metricHTTPEvents.Add("received", 1)
+		if handover.paused() {
+			// Slack retries the event, by then on another replica.
+			http.Error(w, "handing over", http.StatusServiceUnavailable)
+			return
+		}
+		req := socketmode.Request{RetryReason: r.Header.Get("X-Slack-Retry-Reason")}
+		if v := r.Header.Get("X-Slack-Retry-Num"); v != "" {
+			req.RetryAttempt, _ = strconv.Atoi(v)
+			metricHTTPEvents.Add("retries", 1)
+		}
+		switch intake.decide(r.Context(), req, ev, pool.Stats()) {
+		case intakeDelay:
+			http.Error(w, "queue full", http.StatusServiceUnavailable)
+		case intakeIgnore:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusOK)
+			go dispatchEventsAPI(r.Context(), api, ev, pool)
+		}
+	}
+}
+
+func registerHTTPEvents(mux *http.ServeMux, api SlackClient, pool *workerpool.Pool) {
+	mux.HandleFunc("/slack/events", slackEventsHandler(api, pool))
+	if slackSigningSecret != "" {
+		slog.Info("Accepting Events API deliveries over HTTP at /slack/events")
+	}
+}
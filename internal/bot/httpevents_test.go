@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+)
+
+func signedEventRequest(body, secret string, retry int) *http.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+	req := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(body))
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	if retry > 0 {
+		req.Header.Set("X-Slack-Retry-Num", strconv.Itoa(retry))
+		req.Header.Set("X-Slack-Retry-Reason", "http_timeout")
+	}
+	return req
+}
+
+// homeOpened is an event that is admitted without queueing work.
+const homeOpened = `{"type":"event_callback","team_id":"T1","event_id":"Ev1","event":{"type":"app_home_opened","user":"U1","tab":"messages"}}`
+
+func TestSlackEvents_VerifiesAndAnswersChallenge(t *testing.T) {
+	defer func(secret string, in *eventIntake) { slackSigningSecret, intake = secret, in }(slackSigningSecret, intake)
+	slackSigningSecret, intake = "signing-secret", newEventIntake(ackOverflowDelay)
+	handler := slackEventsHandler(&fakeSlackClient{}, workerpool.New(1))
+	challenge := `{"type":"url_verification","challenge":"abc123","token":"x"}`
+
+	rec := httptest.NewRecorder()
+	handler(rec, signedEventRequest(challenge, "signing-secret", 0))
+	if rec.Code != http.StatusOK || rec.Body.String() != "abc123" {
+		t.Errorf("challenge = %d %q", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	handler(rec, signedEventRequest(challenge, "wrong-secret", 0))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("bad signature = %d", rec.Code)
+	}
+
+	slackSigningSecret = ""
+	rec = httptest.NewRecorder()
+	handler(rec, signedEventRequest(challenge, "", 0))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without SLACK_SIGNING_SECRET = %d", rec.Code)
+	}
+}
+
+func TestSlackEvents_RetriesAreDeduplicated(t *testing.T) {
+	defer func(secret string, in *eventIntake) { slackSigningSecret, intake = secret, in }(slackSigningSecret, intake)
+	slackSigningSecret, intake = "signing-secret", newEventIntake(ackOverflowDelay)
+	handler := slackEventsHandler(&fakeSlackClient{}, workerpool.New(1))
+	for retry, want := range []int{http.StatusOK, http.StatusOK} {
+		rec := httptest.NewRecorder()
+		handler(rec, signedEventRequest(homeOpened, "signing-secret", retry))
+		if rec.Code != want {
+			t.Errorf("attempt %d = %d, want %d", retry, rec.Code, want)
+		}
+	}
+	if got := metricEventIntake.Get("duplicate"); got == nil || got.String() == "0" {
+		t.Errorf("redelivery was not counted as a duplicate: %v", got)
+	}
+}
+
+func TestSlackEvents_FullQueueAsksForRetry(t *testing.T) {
+	defer func(secret string, in *eventIntake) { slackSigningSecret, intake = secret, in }(slackSigningSecret, intake)
+	slackSigningSecret, intake = "signing-secret", newEventIntake(ackOverflowDelay)
+	pool := workerpool.New(1)
+	block := make(chan struct{})
+	defer close(block)
+	for pool.Stats().QueueDepth < pool.Stats().QueueCapacity {
+		pool.Submit(func() { <-block })
+	}
+	handler := slackEventsHandler(&fakeSlackClient{}, pool)
+	mention := `{"type":"event_callback","team_id":"T1","event_id":"Ev9","event":{"type":"app_mention","user":"U1","channel":"C1","ts":"1700000000.000100","text":"hi"}}`
+
+	rec := httptest.NewRecorder()
+	handler(rec, signedEventRequest(mention, "signing-secret", 0))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("full queue = %d, want 503", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler(rec, signedEventRequest(mention, "signing-secret", slackMaxRetries))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Slack's last retry = %d, want 200", rec.Code)
+	}
+}
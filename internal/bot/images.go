@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Images and Charts
+//
+// A backend can answer visually with an "image" event carrying base64 PNG,
+// JPEG, GIF or WebP bytes, or a "chart" event carrying a Vega-Lite spec.
+// Charts are rendered to PNG by CHART_RENDER_URL, which must accept the
+// spec as a JSON POST body and return the image (Kroki's
+// https://kroki.io/vegalite/png does). Images are uploaded into the
+// answer's thread with the backend's title and alt text. A chart that
+// cannot be rendered is attached as its .vl.json spec so it can still be
+// opened in the Vega editor. Uploads are counted by kind under
+// "backend_images" on /debug/vars.
+const (
+	maxImageBytes = 5 << 20
+	chartTimeout  = 10 * time.Second
+)
+
+var (
+	metricImages = expvar.NewMap("backend_images")
+
+	// chartRenderURL is CHART_RENDER_URL.
+	chartRenderURL string
+)
+
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// decodeImage returns the image bytes and their file extension.
+func decodeImage(data string) ([]byte, string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(raw) == 0 || len(raw) > maxImageBytes {
+		return nil, "", fmt.Errorf("image is %d bytes, limit is %d", len(raw), maxImageBytes)
+	}
+	ext, ok := imageExtensions[http.DetectContentType(raw)]
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported image type %s", http.DetectContentType(raw))
+	}
+	return raw, ext, nil
+}
+
+// renderChart turns a Vega-Lite spec into a PNG with CHART_RENDER_URL.
+func renderChart(ctx context.Context, spec json.RawMessage) ([]byte, error) {
+	if chartRenderURL == "" {
+		return nil, errors.New("CHART_RENDER_URL is not set")
+	}
+	ctx, cancel := context.WithTimeout(ctx, chartTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, chartRenderURL, bytes.NewReader(spec))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "image/png")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("chart renderer returned %s", resp.Status)
+	}
+	png, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(png) > maxImageBytes || http.DetectContentType(png) != "image/png" {
+		return nil, fmt.Errorf("chart renderer did not return a PNG under %d bytes", maxImageBytes)
+	}
+	return png, nil
+}
+
+// threadOf returns the thread the reply options post into, if any.
+func threadOf(options []slack.MsgOption) string {
+	_, values, _ := slack.UnsafeApplyMsgOptions("", "", "", options...)
+	return values.Get("thread_ts")
+}
+
+// postImage uploads a backend image or chart next to the answer and returns
+// the text that stands in for it in the conversation record.
+func postImage(ctx context.Context, api SlackClient, channel, user, kind string, img *backend.Image, replyOptions ...slack.MsgOption) (string, error) {
+	span := trace.SpanFromContext(ctx)
+	if img == nil {
+		return "", fmt.Errorf("%s event without an image", kind)
+	}
+	title := filterText(ctx, channel, user, img.Title)
+	base := path.Base(img.Filename)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	if base == "" || base == "." || base == "/" {
+		base = kind
+	}
+	var content []byte
+	var ext string
+	switch kind {
+	case "chart":
+		if !json.Valid(img.Spec) {
+			return "", errors.New("chart spec is not valid JSON")
+		}
+		png, err := renderChart(ctx, img.Spec)
+		if err != nil {
+			// The spec still carries the data, so attach it instead.
+			slog.ErrorContext(ctx, "Failed to render chart, attaching its spec", "err", err)
+			metricImages.Add("chart_unrendered", 1)
+			content, ext = img.Spec, ".vl.json"
+			break
+		}
+		content, ext = png, ".png"
+	default:
+		raw, e, err := decodeImage(img.Data)
+		if err != nil {
+			return "", err
+		}
+		content, ext = raw, e
+	}
+	name := base + ext
+	if title == "" {
+		title = name
+	}
+	span.SetAttributes(attribute.String("answer.image", kind))
+	_, err := api.UploadFileV2Context(ctx, slack.UploadFileV2Parameters{
+		Channel:         channel,
+		ThreadTimestamp: threadOf(replyOptions),
+		Filename:        name,
+		Title:           title,
+		AltTxt:          filterText(ctx, channel, user, img.AltText),
+		Reader:          bytes.NewReader(content),
+		FileSize:        len(content),
+	})
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to upload file", "file", name, "channel", channel, "err", err)
+		return "", err
+	}
+	metricImages.Add(kind, 1)
+	requests.record(ctx, "image", fmt.Sprintf("%s %s, %d bytes", kind, name, len(content)))
+	return fmt.Sprintf("[%s: %s]", kind, title), nil
+}
@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// pngHeader is enough for content sniffing to see a PNG.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestDecodeImage(t *testing.T) {
+	raw, ext, err := decodeImage(base64.StdEncoding.EncodeToString(pngHeader))
+	if err != nil || ext != ".png" || len(raw) != len(pngHeader) {
+		t.Fatalf("decodeImage = %d bytes, %q, %v", len(raw), ext, err)
+	}
+	for name, data := range map[string]string{
+		"not base64": "%%%",
+		"empty":      "",
+		"not image":  base64.StdEncoding.EncodeToString([]byte("hello world")),
+	} {
+		if _, _, err := decodeImage(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestProcessTask_UploadsImagesAndCharts(t *testing.T) {
+	defer func(d time.Duration, u string) { postInterval, chartRenderURL = d, u }(postInterval, chartRenderURL)
+	postInterval = 0
+
+	var gotSpec string
+	renderer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotSpec = string(body)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngHeader)
+	}))
+	defer renderer.Close()
+	chartRenderURL = renderer.URL
+
+	spec := `{"mark":"line","data":{"values":[{"x":1,"y":2}]}}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"event\":\"message_part\",\"text_chunk\":\"Here is the error rate.\"}\n\n")
+		fmt.Fprintf(w, "data: {\"event\":\"image\",\"image\":{\"data\":%q,\"filename\":\"errors.png\",\"title\":\"Errors\",\"alt_text\":\"Error rate\"}}\n\n",
+			base64.StdEncoding.EncodeToString(pngHeader))
+		fmt.Fprintf(w, "data: {\"event\":\"chart\",\"image\":{\"spec\":%s,\"title\":\"Trend\"}}\n\n", spec)
+		fmt.Fprint(w, "data: {\"event\":\"image\",\"image\":{\"data\":\"bm90IGFuIGltYWdl\"}}\n\n")
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	rec := processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CIMG"}, "plot our error rate",
+		slack.MsgOptionTS("400.000100"))
+
+	if len(api.uploads) != 2 {
+		t.Fatalf("expected 2 uploads, got %+v", api.uploads)
+	}
+	img, chart := api.uploads[0], api.uploads[1]
+	if img.Filename != "errors.png" || img.Title != "Errors" || img.AltTxt != "Error rate" || img.ThreadTimestamp != "400.000100" {
+		t.Errorf("image upload = %+v", img)
+	}
+	if chart.Filename != "chart.png" || chart.Title != "Trend" || gotSpec != spec {
+		t.Errorf("chart upload = %+v, renderer got %s", chart, gotSpec)
+	}
+	if rec == nil || len(rec.Answer) != 3 || rec.Answer[1] != "[image: Errors]" || rec.Answer[2] != "[chart: Trend]" {
+		t.Errorf("answer = %+v", rec)
+	}
+
+	// Without a renderer the spec is attached instead.
+	chartRenderURL = ""
+	api = &fakeSlackClient{}
+	if _, err := postImage(context.Background(), api, "CIMG", "U1", "chart", &backend.Image{Spec: []byte(spec)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.uploads) != 1 || api.uploads[0].Filename != "chart.vl.json" {
+		t.Errorf("expected the spec to be attached, got %+v", api.uploads)
+	}
+}
@@ -0,0 +1,351 @@
+package bot
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Bulk Import
+//
+// A CSV of questions (question, channel, optional thread_ts) is answered one
+// row at a time as low-priority work: each row waits until the worker queue
+// is empty, and backend requests carry X-Request-Priority: low. Imports are
+// started with the "!import" admin command or POST /admin/import, and the
+// requester gets a completion report by DM.
+const (
+	maxImportRows      = 1000
+	importIdlePoll     = 200 * time.Millisecond
+	importQueueBacklog = 16
+
+	ImportPending  = "pending"
+	ImportAnswered = "answered"
+	ImportFailed   = "failed"
+)
+
+type lowPriorityKey struct{}
+
+func withLowPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, lowPriorityKey{}, true)
+}
+
+func isLowPriority(ctx context.Context) bool {
+	low, _ := ctx.Value(lowPriorityKey{}).(bool)
+	return low
+}
+
+type ImportRow struct {
+	Question string `json:"question"`
+	Channel  string `json:"channel"`
+	ThreadTS string `json:"thread_ts,omitempty"`
+}
+
+type ImportResult struct {
+	ImportRow
+	Status         string `json:"status"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	Permalink      string `json:"permalink,omitempty"`
+}
+
+type ImportJob struct {
+	ID        string `json:"id"`
+	Requester string `json:"requester,omitempty"`
+	// Source is where the import was started; see source.go.
+	Source   string         `json:"source"`
+	Created  time.Time      `json:"created"`
+	Finished time.Time      `json:"finished,omitempty"`
+	Results  []ImportResult `json:"results"`
+}
+
+func (j ImportJob) counts() (answered, failed, pending int) {
+	for _, r := range j.Results {
+		switch r.Status {
+		case ImportAnswered:
+			answered++
+		case ImportFailed:
+			failed++
+		default:
+			pending++
+		}
+	}
+	return
+}
+
+var channelMentionPattern = regexp.MustCompile(`^<#([A-Z0-9]+)(?:\|[^>]*)?>$`)
+
+// parseImportCSV reads question,channel[,thread_ts] rows, skipping an
+// optional header row. Channels may be IDs or Slack channel mentions.
+func parseImportCSV(r io.Reader) ([]ImportRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var rows []ImportRow
+	for i, rec := range records {
+		if i == 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "question") {
+			continue
+		}
+		if len(rec) < 2 || strings.TrimSpace(rec[0]) == "" || strings.TrimSpace(rec[1]) == "" {
+			return nil, fmt.Errorf("line %d: expected question,channel[,thread_ts]", i+1)
+		}
+		row := ImportRow{Question: strings.TrimSpace(rec[0]), Channel: strings.TrimSpace(rec[1])}
+		if m := channelMentionPattern.FindStringSubmatch(row.Channel); m != nil {
+			row.Channel = m[1]
+		}
+		if len(rec) > 2 {
+			row.ThreadTS = strings.TrimSpace(rec[2])
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no questions found")
+	}
+	if len(rows) > maxImportRows {
+		return nil, fmt.Errorf("%d questions exceeds the limit of %d", len(rows), maxImportRows)
+	}
+	return rows, nil
+}
+
+type importQueue struct {
+	mu   sync.Mutex
+	jobs map[string]*ImportJob
+	next chan string
+}
+
+var imports = &importQueue{jobs: make(map[string]*ImportJob), next: make(chan string, importQueueBacklog)}
+
+func (q *importQueue) enqueue(requester, source string, rows []ImportRow) (ImportJob, error) {
+	job := &ImportJob{ID: newID(), Requester: requester, Source: source, Created: time.Now()}
+	for _, row := range rows {
+		job.Results = append(job.Results, ImportResult{ImportRow: row, Status: ImportPending})
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.next <- job.ID:
+	default:
+		return ImportJob{}, errors.New("too many imports queued, try again later")
+	}
+	q.jobs[job.ID] = job
+	return q.copyLocked(job), nil
+}
+
+func (q *importQueue) get(id string) (ImportJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return ImportJob{}, false
+	}
+	return q.copyLocked(job), true
+}
+
+func (q *importQueue) copyLocked(job *ImportJob) ImportJob {
+	c := *job
+	c.Results = append([]ImportResult(nil), job.Results...)
+	return c
+}
+
+func (q *importQueue) update(id string, fn func(job *ImportJob)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// run processes queued imports one at a time until ctx is done.
+func (q *importQueue) run(ctx context.Context, api SlackClient, pool *workerpool.Pool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-q.next:
+			q.process(ctx, api, pool, id)
+		}
+	}
+}
+
+func (q *importQueue) process(ctx context.Context, api SlackClient, pool *workerpool.Pool, id string) {
+	job, ok := q.get(id)
+	if !ok {
+		return
+	}
+	ctx, span := otel.Tracer("bot").Start(ctx, "bulk_import")
+	defer span.End()
+	span.SetAttributes(attribute.String("import.id", id), attribute.Int("import.rows", len(job.Results)))
+	slog.InfoContext(ctx, "Starting import", "import", id, "questions", len(job.Results))
+
+	for i, row := range job.Results {
+		// Interactive questions go first: only submit when nothing is waiting.
+		for pool.QueueDepth() > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(importIdlePoll):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		result, ok := row, false
+		for !ok {
+			done := make(chan struct{})
+			// A row turned away or discarded by a full queue is submitted
+			// again once the queue has drained.
+			_, err := pool.SubmitTask(workerpool.Task{
+				Run: func() {
+					defer close(done)
+					result, ok = answerImportRow(withQuerySource(withLowPriority(ctx), job.Source), api, job.Requester, row), true
+				},
+				Dropped: func() { close(done) },
+			})
+			if err != nil {
+				close(done)
+			}
+			<-done
+			if !ok {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(importIdlePoll):
+				}
+			}
+		}
+		q.update(id, func(j *ImportJob) { j.Results[i] = result })
+	}
+
+	q.update(id, func(j *ImportJob) { j.Finished = time.Now() })
+	job, _ = q.get(id)
+	answered, failed, _ := job.counts()
+	slog.InfoContext(ctx, "Import finished", "import", id, "answered", answered, "failed", failed)
+	if job.Requester != "" {
+		sendImportReport(ctx, api, job)
+	}
+}
+
+func answerImportRow(ctx context.Context, api SlackClient, requester string, row ImportResult) ImportResult {
+	ev := slackevents.AppMentionEvent{User: requester, Channel: row.Channel, ThreadTimeStamp: row.ThreadTS}
+	var opts []slack.MsgOption
+	if row.ThreadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(row.ThreadTS))
+	}
+	rec := processTask(ctx, api, ev, row.Question, opts...)
+	if rec == nil || len(rec.Answer) == 0 {
+		row.Status = ImportFailed
+		return row
+	}
+	row.Status = ImportAnswered
+	row.ConversationID = rec.ID
+	if len(rec.MessageTS) > 0 {
+		if link, err := api.GetPermalinkContext(ctx, &slack.PermalinkParameters{Channel: rec.Channel, Ts: rec.MessageTS[0]}); err == nil {
+			row.Permalink = link
+		}
+	}
+	return row
+}
+
+func importReportCSV(w io.Writer, job ImportJob) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"question", "channel", "thread_ts", "status", "permalink"})
+	for _, r := range job.Results {
+		cw.Write([]string{r.Question, r.Channel, r.ThreadTS, r.Status, r.Permalink})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func sendImportReport(ctx context.Context, api SlackClient, job ImportJob) {
+	answered, failed, _ := job.counts()
+	text := fmt.Sprintf("Import `%s` finished: %d of %d questions answered, %d failed.", job.ID, answered, len(job.Results), failed)
+	ts, err := sendMessage(ctx, api, outgoingMessage{Channel: job.Requester, User: job.Requester, Text: text})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to send import report", "import", job.ID, "err", err)
+		return
+	}
+	report := newTranscriptBuffer(ctx)
+	defer report.Close()
+	if err := importReportCSV(report, job); err != nil {
+		slog.ErrorContext(ctx, "Failed to write import report", "import", job.ID, "err", err)
+		return
+	}
+	if _, err := uploadTranscript(ctx, api, slack.UploadFileV2Parameters{
+		Channel:         job.Requester,
+		ThreadTimestamp: ts,
+		Filename:        "import-" + job.ID + ".csv",
+		Title:           "Import report " + job.ID,
+	}, report); err != nil {
+		slog.ErrorContext(ctx, "Failed to upload import report", "import", job.ID, "err", err)
+	}
+}
+
+// adminImportHandler starts an import from a CSV body (POST) or returns an
+// import's progress (GET ?id=). ?notify=U123 sends the report to that user.
+func adminImportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		job, ok := imports.get(r.URL.Query().Get("id"))
+		if !ok {
+			http.Error(w, "import not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(job)
+	case http.MethodPost:
+		rows, err := parseImportCSV(io.LimitReader(r.Body, 10<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		job, err := imports.enqueue(r.URL.Query().Get("notify"), SourceAPI, rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	registerCommand("import", command{
+		Admin: true,
+		Usage: "<CSV: question,channel[,thread_ts] per line>",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			// CSV pasted into Slack usually arrives inside a code block.
+			args = strings.TrimSpace(strings.Trim(args, "`"))
+			rows, err := parseImportCSV(strings.NewReader(args))
+			if err != nil {
+				notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Could not read the import: %v", err))
+				return
+			}
+			job, err := imports.enqueue(ev.User, querySourceFrom(ctx), rows)
+			if err != nil {
+				notifyUser(ctx, api, ev.Channel, ev.User, err.Error())
+				return
+			}
+			notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Import `%s` queued with %d questions; I'll DM you a report when it's done.", job.ID, len(rows)))
+		},
+	})
+}
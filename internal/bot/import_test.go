@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	rows, err := parseImportCSV(strings.NewReader("question,channel,thread_ts\n\"How do I reset, my password?\",<#C123|help>\nWhat is SSO?,C456,1700000000.000100\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Question != "How do I reset, my password?" || rows[0].Channel != "C123" || rows[1].ThreadTS != "1700000000.000100" {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+	if _, err := parseImportCSV(strings.NewReader("only a question\n")); err == nil {
+		t.Error("expected an error for a row without a channel")
+	}
+}
+
+func TestImport_AnswersRowsAndReports(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+
+	var priorities []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priorities = append(priorities, r.Header.Get("X-Request-Priority"))
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if req.Query == "broken" {
+			json.NewEncoder(w).Encode(backend.ChatResponse{})
+			return
+		}
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Answer to " + req.Query})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+	config.AdminUsers = []string{"UADMIN"}
+	defer func() { config.AdminUsers = nil }()
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+	defer pool.Shutdown()
+
+	ev := slackevents.AppMentionEvent{User: "UADMIN", Channel: "CADMIN"}
+	dispatchCommand(context.Background(), api, ev, "!import ```question,channel,thread_ts\nWhat is SSO?,CIMP,1700000000.000100\nbroken,CIMP\n```")
+	id := <-imports.next
+	imports.process(context.Background(), api, pool, id)
+
+	job, _ := imports.get(id)
+	if len(job.Results) != 2 || job.Results[0].Status != ImportAnswered || job.Results[1].Status != ImportFailed || job.Finished.IsZero() {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	if !strings.Contains(job.Results[0].Permalink, "archives/CIMP/") {
+		t.Errorf("missing permalink: %+v", job.Results[0])
+	}
+	for _, p := range priorities {
+		if p != "low" {
+			t.Errorf("import requests must be low priority, got %q", p)
+		}
+	}
+
+	var threaded, report bool
+	for _, p := range api.sent() {
+		if p.Channel == "CIMP" && p.Values.Get("thread_ts") == "1700000000.000100" {
+			threaded = true
+		}
+		if p.Channel == "UADMIN" && strings.Contains(p.Text(), "1 of 2 questions answered, 1 failed") {
+			report = true
+		}
+	}
+	if !threaded || !report {
+		t.Errorf("expected threaded answer and report DM (threaded=%v report=%v)", threaded, report)
+	}
+	if len(api.uploads) != 1 || !strings.Contains(api.uploads[0].Content, "What is SSO?,CIMP,1700000000.000100,answered,") {
+		t.Errorf("expected CSV report upload, got %+v", api.uploads)
+	}
+}
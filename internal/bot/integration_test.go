@@ -1,43 +1,59 @@
-package main
+package bot
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
 	"github.com/joho/godotenv"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 )
 
-// Mock backend for integration
-func loadBotToken(t *testing.T) string {
-	err := godotenv.Load()
-	if err != nil {
+// loadSlackEnv returns the live Slack test settings from the .env file at
+// the repository root, overridden by the environment, and skips the test
+// when there is no bot token to post to Slack with. The file is read rather
+// than loaded so its settings don't leak into the other tests.
+func loadSlackEnv(t *testing.T) map[string]string {
+	t.Helper()
+	env, err := godotenv.Read("../../.env")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		t.Fatalf("Error loading .env file: %v", err)
 	}
-	token := os.Getenv("SLACK_BOT_TOKEN")
-	if token == "" {
-		t.Fatal("SLACK_BOT_TOKEN not set in environment")
+	if env == nil {
+		env = map[string]string{}
+	}
+	for _, key := range []string{"SLACK_BOT_TOKEN", "SLACK_CHANNEL", "SLACK_BOT_USER_ID"} {
+		if v := os.Getenv(key); v != "" {
+			env[key] = v
+		}
 	}
-	return token
+	if !strings.HasPrefix(env["SLACK_BOT_TOKEN"], "xoxb-") {
+		t.Skip("SLACK_BOT_TOKEN is not set to a bot token; skipping the live Slack test")
+	}
+	return env
 }
 
 func TestIntegration_ProcessMention_EndToEnd(t *testing.T) {
-	// Start a mock backend server
+	// Start a fake backend that answers every request.
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ChatResponse{Full: "Integration test reply."})
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Integration test reply."})
 	}))
 	defer ts.Close()
 	config.BackendURL = ts.URL
 
 	api := &fakeSlackClient{}
-	pool := NewWorkerPool(1)
+	pool := workerpool.New(1)
 	defer pool.Shutdown()
 
 	ev := slackevents.AppMentionEvent{
@@ -57,14 +73,14 @@ func TestIntegration_ProcessMention_EndToEnd(t *testing.T) {
 func TestIntegration_ProcessDirectMessage_EndToEnd(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ChatResponse{Full: "DM integration reply."})
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "DM integration reply."})
 	}))
 	t.Log("Backend URL:", ts.URL)
 	defer ts.Close()
 	config.BackendURL = ts.URL
 
 	api := &fakeSlackClient{}
-	pool := NewWorkerPool(1)
+	pool := workerpool.New(1)
 	defer pool.Shutdown()
 
 	ev := &slackevents.MessageEvent{
@@ -82,15 +98,15 @@ func TestIntegration_ProcessDirectMessage_EndToEnd(t *testing.T) {
 }
 
 func TestIntegration_MentionWithBackend(t *testing.T) {
-	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ChatResponse{Full: "integration reply"})
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "integration reply"})
 	}))
-	defer backend.Close()
-	config.BackendURL = backend.URL
+	defer backendServer.Close()
+	config.BackendURL = backendServer.URL
 
 	api := &fakeSlackClient{}
-	pool := NewWorkerPool(1)
+	pool := workerpool.New(1)
 	defer pool.Shutdown()
 
 	ev := slackevents.AppMentionEvent{
@@ -106,15 +122,15 @@ func TestIntegration_MentionWithBackend(t *testing.T) {
 	}
 }
 func TestIntegration_DirectMessageWithBackend(t *testing.T) {
-	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ChatResponse{Full: "integration reply"})
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "integration reply"})
 	}))
-	defer backend.Close()
-	config.BackendURL = backend.URL
+	defer backendServer.Close()
+	config.BackendURL = backendServer.URL
 
 	api := &fakeSlackClient{}
-	pool := NewWorkerPool(1)
+	pool := workerpool.New(1)
 	defer pool.Shutdown()
 
 	ev := &slackevents.MessageEvent{
@@ -139,10 +155,10 @@ func TestProcessTask_BackendError(t *testing.T) {
 }
 
 func TestProcessTask_SuccessfulResponse(t *testing.T) {
-	// Mock backend server returns valid JSON
+	// The fake backend answers with valid JSON.
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ChatResponse{Full: "valid response"})
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "valid response"})
 	})
 	ts := httptest.NewServer(handler)
 	defer ts.Close()
@@ -174,9 +190,9 @@ func TestSendMessageToChannel(t *testing.T) {
 }
 
 func TestSendRealMessageToChannel(t *testing.T) {
-	token := loadBotToken(t)
-	api := slack.New(token)
-	channel := os.Getenv("SLACK_CHANNEL")
+	env := loadSlackEnv(t)
+	api := slack.New(env["SLACK_BOT_TOKEN"])
+	channel := env["SLACK_CHANNEL"]
 	text := "Hello from Go integration test!"
 
 	_, _, err := api.PostMessage(
@@ -191,10 +207,10 @@ func TestSendRealMessageToChannel(t *testing.T) {
 }
 
 func TestSendMentionToBotInChannel(t *testing.T) {
-	token := loadBotToken(t)
-	api := slack.New(token)
-	channel := os.Getenv("SLACK_CHANNEL")                  
-	botUserID := os.Getenv("SLACK_BOT_USER_ID")
+	env := loadSlackEnv(t)
+	api := slack.New(env["SLACK_BOT_TOKEN"])
+	channel := env["SLACK_CHANNEL"]
+	botUserID := env["SLACK_BOT_USER_ID"]
 	if botUserID == "" {
 		botUserID = "chatrelaybot" 
 	}
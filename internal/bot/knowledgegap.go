@@ -0,0 +1,294 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Knowledge Gaps
+//
+// When the backend can't answer, because it flags the response with
+// "no_answer" or the answer opens with "I don't know" or similar, the
+// asker is offered a "Report missing answer" button. Reports are filed in a
+// knowledge-gap store, where the same question in the same channel is
+// counted once with every reporter. The content owners of the channel are
+// sent a DM. Channels set them with "knowledge_gaps": "owners" always hear
+// about gaps, and "topics" maps a keyword, matched against the channel's
+// topic, purpose and the question, to more owners. Without owners the
+// report goes to ADMIN_CHANNEL. GET /admin/gaps lists the gaps, most
+// reported first, and GAPS_FILE keeps them across restarts. Offers,
+// reports and notifications are counted under "knowledge_gaps" on
+// /debug/vars.
+const (
+	ActionReportGap = "report_gap"
+
+	// unansweredPrefix is how far into an answer a refusal is looked for.
+	unansweredPrefix = 300
+)
+
+var (
+	metricKnowledgeGaps = expvar.NewMap("knowledge_gaps")
+
+	unansweredPattern = regexp.MustCompile(`(?i)\b(i (don't|do not) know|i'm not sure|i am not sure|i (couldn't|could not|can't|cannot) find|i (don't|do not) have (enough |any )?information|there is no (information|documentation)|no (information|documentation) (is )?(available )?(about|on|for))\b`)
+)
+
+// KnowledgeGapConfig is the per-channel knowledge_gaps setting.
+type KnowledgeGapConfig struct {
+	Owners []string            `json:"owners,omitempty"`
+	Topics map[string][]string `json:"topics,omitempty"`
+}
+
+type KnowledgeGap struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	Channel        string    `json:"channel"`
+	ThreadTS       string    `json:"thread_ts,omitempty"`
+	Question       string    `json:"question"`
+	Answer         string    `json:"answer"`
+	Topics         []string  `json:"topics,omitempty"`
+	Owners         []string  `json:"owners,omitempty"`
+	ReportedBy     []string  `json:"reported_by"`
+	Reports        int       `json:"reports"`
+	FirstReported  time.Time `json:"first_reported"`
+	LastReported   time.Time `json:"last_reported"`
+}
+
+type knowledgeGapStore struct {
+	mu   sync.Mutex
+	path string
+	gaps map[string]*KnowledgeGap
+}
+
+var knowledgeGaps = &knowledgeGapStore{gaps: make(map[string]*KnowledgeGap)}
+
+// looksUnanswered reports whether answer text opens with a refusal.
+func looksUnanswered(text string) bool {
+	return unansweredPattern.MatchString(truncate(text, unansweredPrefix))
+}
+
+// gapKey identifies a question in a channel regardless of case and spacing.
+func gapKey(channel, question string) string {
+	return channel + "/" + strings.Join(strings.Fields(strings.ToLower(question)), " ")
+}
+
+// load reads persisted gaps; a missing file is an empty store.
+func (s *knowledgeGapStore) load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var gaps []*KnowledgeGap
+	if err := json.Unmarshal(data, &gaps); err != nil {
+		return err
+	}
+	for _, g := range gaps {
+		s.gaps[gapKey(g.Channel, g.Question)] = g
+	}
+	return nil
+}
+
+// saveLocked writes the store atomically; callers hold s.mu.
+func (s *knowledgeGapStore) saveLocked() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.Marshal(s.listLocked())
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(context.Background(), "Failed to save knowledge gaps", "err", err)
+	}
+}
+
+func (s *knowledgeGapStore) listLocked() []KnowledgeGap {
+	out := make([]KnowledgeGap, 0, len(s.gaps))
+	for _, g := range s.gaps {
+		c := *g
+		c.ReportedBy = append([]string(nil), g.ReportedBy...)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Reports != out[j].Reports {
+			return out[i].Reports > out[j].Reports
+		}
+		return out[i].LastReported.After(out[j].LastReported)
+	})
+	return out
+}
+
+func (s *knowledgeGapStore) list() []KnowledgeGap {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listLocked()
+}
+
+// report files rec's question as reported by user and returns the gap and
+// whether this was its first report.
+func (s *knowledgeGapStore) report(rec ConversationRecord, user string, topics, owners []string) (KnowledgeGap, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	key := gapKey(rec.Channel, rec.Query)
+	g, ok := s.gaps[key]
+	if !ok {
+		g = &KnowledgeGap{
+			ID:             newID(),
+			ConversationID: rec.ID,
+			Channel:        rec.Channel,
+			ThreadTS:       rec.ThreadTS,
+			Question:       rec.Query,
+			Answer:         rec.AnswerText(),
+			Topics:         topics,
+			Owners:         owners,
+			FirstReported:  now,
+		}
+		s.gaps[key] = g
+	}
+	for _, u := range g.ReportedBy {
+		if u == user {
+			return *g, false
+		}
+	}
+	g.ReportedBy = append(g.ReportedBy, user)
+	g.Reports++
+	g.LastReported = now
+	s.saveLocked()
+	return *g, !ok
+}
+
+// gapOwners returns the matched topics and the owners to notify about a
+// question in channel.
+func gapOwners(ctx context.Context, api SlackClient, channel, question string) (topics, owners []string) {
+	cfg := channelConfigFor(channel).KnowledgeGaps
+	seen := make(map[string]bool)
+	add := func(users []string) {
+		for _, u := range users {
+			if !seen[u] {
+				seen[u] = true
+				owners = append(owners, u)
+			}
+		}
+	}
+	add(cfg.Owners)
+	if len(cfg.Topics) == 0 {
+		return nil, owners
+	}
+	haystack := strings.ToLower(question)
+	if info, ok := lookupChannelInfo(ctx, api, channel); ok {
+		haystack += "\n" + strings.ToLower(info.topic+"\n"+info.purpose)
+	}
+	names := make([]string, 0, len(cfg.Topics))
+	for name := range cfg.Topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.Contains(haystack, strings.ToLower(name)) {
+			topics = append(topics, name)
+			add(cfg.Topics[name])
+		}
+	}
+	return topics, owners
+}
+
+func reportGapBlock(recordID string) slack.Block {
+	return slack.NewActionBlock("gap_"+recordID,
+		slack.NewButtonBlockElement(ActionReportGap, recordID, slack.NewTextBlockObject(slack.PlainTextType, "Report missing answer", false, false)),
+	)
+}
+
+// offerGapReport shows the asker the report button when rec went
+// unanswered.
+func offerGapReport(ctx context.Context, api SlackClient, rec *ConversationRecord) {
+	if isPrivateDM(ctx) || isSelfTest(ctx) || len(rec.MessageTS) == 0 {
+		return
+	}
+	if !rec.Unanswered && !looksUnanswered(rec.AnswerText()) {
+		return
+	}
+	metricKnowledgeGaps.Add("offered", 1)
+	options := append([]slack.MsgOption{slack.MsgOptionBlocks(reportGapBlock(rec.ID)), slack.MsgOptionPostEphemeral(rec.User)}, threadOptions(rec.ThreadTS)...)
+	sendMessage(ctx, api, outgoingMessage{Channel: rec.Channel, User: rec.User, Text: "Looks like I couldn't answer that. Report it so the right people can fill the gap."}, options...)
+}
+
+func handleGapReport(ctx context.Context, api SlackClient, callback slack.InteractionCallback, action *slack.BlockAction) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "report_knowledge_gap")
+	defer span.End()
+
+	user := callback.User.ID
+	span.SetAttributes(attribute.String("conversation.id", action.Value), attribute.String("user.id", user))
+	rec, ok := conversations.Get(action.Value)
+	if !ok {
+		notifyUser(ctx, api, callback.Channel.ID, user, "I no longer have that question, so it can't be reported.")
+		return
+	}
+	topics, owners := gapOwners(ctx, api, rec.Channel, rec.Query)
+	gap, first := knowledgeGaps.report(rec, user, topics, owners)
+	metricKnowledgeGaps.Add("reported", 1)
+	span.SetAttributes(attribute.String("gap.id", gap.ID), attribute.Int("gap.reports", gap.Reports))
+	slog.InfoContext(ctx, "Missing answer reported", "user", user, "channel", rec.Channel, "gap", gap.ID, "reports", gap.Reports)
+
+	if first {
+		notifyGapOwners(ctx, api, rec, gap)
+	}
+	if callback.ResponseURL != "" {
+		sendMessage(ctx, api, outgoingMessage{Channel: rec.Channel, User: user, Text: ":memo: Thanks, the missing answer was reported."}, slack.MsgOptionReplaceOriginal(callback.ResponseURL))
+	}
+}
+
+// notifyGapOwners tells a gap's owners about it, or the admins when it has
+// none.
+func notifyGapOwners(ctx context.Context, api SlackClient, rec ConversationRecord, gap KnowledgeGap) {
+	text := fmt.Sprintf(":mag: A missing answer was reported in <#%s>:\n>%s", rec.Channel, truncate(rec.Query, maxSectionText))
+	if len(gap.Topics) > 0 {
+		text += fmt.Sprintf("\nTopics: %s", strings.Join(gap.Topics, ", "))
+	}
+	if link := questionPermalink(ctx, api, rec); link != "" {
+		text += "\n" + link
+	}
+	if len(gap.Owners) == 0 {
+		alertAdmins(ctx, api, text)
+		return
+	}
+	for _, owner := range gap.Owners {
+		if _, err := sendMessage(ctx, api, outgoingMessage{Channel: owner, User: owner, Text: text}); err != nil {
+			slog.ErrorContext(ctx, "Failed to notify gap owner", "owner", owner, "gap", gap.ID, "err", err)
+			continue
+		}
+		metricKnowledgeGaps.Add("notified", 1)
+	}
+}
+
+func adminGapsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(knowledgeGaps.list())
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestLooksUnanswered(t *testing.T) {
+	for text, want := range map[string]bool{
+		"I don't know how the billing export works.":            true,
+		"Sorry, I couldn't find anything about VPN quotas.":     true,
+		"There is no documentation on the legacy API.":          true,
+		"Run `make deploy` and check the dashboard.":            false,
+		strings.Repeat("Step. ", 80) + "I don't know the rest.": false,
+	} {
+		if got := looksUnanswered(text); got != want {
+			t.Errorf("looksUnanswered(%.40q) = %t, want %t", text, got, want)
+		}
+	}
+}
+
+func TestKnowledgeGap_ReportNotifiesTopicOwners(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	defer func(s *ConversationStore, g *knowledgeGapStore) { conversations, knowledgeGaps = s, g }(conversations, knowledgeGaps)
+	conversations = NewConversationStore()
+	knowledgeGaps = &knowledgeGapStore{gaps: make(map[string]*KnowledgeGap)}
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CGAP": {KnowledgeGaps: KnowledgeGapConfig{
+		Owners: []string{"UDOCS"},
+		Topics: map[string][]string{"billing": {"UBILL"}, "vpn": {"UNET"}},
+	}}}})
+	defer setChannelSettings(channelSettings{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Here is what I have on exports.", NoAnswer: true})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	rec := processTask(context.Background(), api, slackevents.AppMentionEvent{User: "UASK", Channel: "CGAP", ThreadTimeStamp: "9.9"}, "How do Billing exports work?")
+	if rec == nil || !rec.Unanswered {
+		t.Fatalf("record %+v not flagged unanswered", rec)
+	}
+	offer := api.posts[len(api.posts)-1]
+	if offer.Values.Get("user") != "UASK" || !strings.Contains(offer.Values.Get("blocks"), ActionReportGap) || offer.Values.Get("thread_ts") != "9.9" {
+		t.Fatalf("expected an ephemeral report button in the thread, got %v", offer.Values)
+	}
+
+	api = &fakeSlackClient{}
+	callback := reviewCallback("UASK", ActionReportGap, rec.ID)
+	handleInteraction(context.Background(), api, callback)
+	handleInteraction(context.Background(), api, callback)
+	handleInteraction(context.Background(), api, reviewCallback("UOTHER", ActionReportGap, rec.ID))
+
+	var notified []string
+	for _, p := range api.sent() {
+		notified = append(notified, p.Channel)
+	}
+	if strings.Join(notified, ",") != "UDOCS,UBILL" {
+		t.Errorf("notified %v, want the channel and billing owners once", notified)
+	}
+	gaps := knowledgeGaps.list()
+	if len(gaps) != 1 || gaps[0].Reports != 2 || gaps[0].Question != "How do Billing exports work?" || strings.Join(gaps[0].Topics, ",") != "billing" {
+		t.Errorf("gaps %+v", gaps)
+	}
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Live-Edit Streaming
+//
+// Channels with "live_edit" set get each answer as one message that grows
+// while the backend streams, instead of one message per chunk. A
+// placeholder is posted when the stream starts, chunks are appended to it
+// with chat.update as often as updatePacing allows for the channel, and the
+// finished text is written when the stream ends, rendered like any other
+// answer (locale, glossary, emoji policy, tables); the placeholder and
+// intermediate edits already follow the content filter and emoji policy. An answer longer than
+// liveEditMaxChars continues in a new message. The disclaimer is appended
+// to the last message, blocks are still posted on their own, and numbered
+// parts are turned off since there is only one part. Live edits and
+// placeholders left without an answer are counted under "live_edit" on
+// /debug/vars.
+const (
+	liveEditPlaceholder = ":hourglass_flowing_sand: _Working on it…_"
+	liveEditCursor      = " …"
+	liveEditMaxChars    = 3800
+)
+
+var metricLiveEdit = expvar.NewMap("live_edit")
+
+// liveAnswer is the message an answer is being streamed into.
+type liveAnswer struct {
+	ctx     context.Context
+	api     SlackClient
+	channel string
+	user    string
+	options []slack.MsgOption
+	// posted is called with the timestamp of every message started.
+	posted func(ts string)
+
+	ts      string
+	text    string
+	written string
+}
+
+func newLiveAnswer(ctx context.Context, api SlackClient, channel, user string, posted func(ts string), options ...slack.MsgOption) *liveAnswer {
+	return &liveAnswer{ctx: ctx, api: api, channel: channel, user: user, options: options, posted: posted}
+}
+
+// start posts the placeholder the answer will be written into.
+func (l *liveAnswer) start() {
+	if l.ts != "" {
+		return
+	}
+	l.open(enforceEmojiPolicy(l.ctx, l.api, l.channel, liveEditPlaceholder))
+}
+
+func (l *liveAnswer) open(text string) {
+	ts, err := sendAnswerMessage(l.ctx, l.api, outgoingMessage{Channel: l.channel, User: l.user, Text: text}, l.options...)
+	if err != nil {
+		trace.SpanFromContext(l.ctx).RecordError(err)
+		slog.ErrorContext(l.ctx, "Failed to start live answer", "channel", l.channel, "err", err)
+		return
+	}
+	l.ts, l.written = ts, text
+	l.posted(ts)
+}
+
+// add appends a chunk on its own line, editing the message when the
+// channel's pacing allows and moving on to a new message when this one is
+// full.
+func (l *liveAnswer) add(chunk string) {
+	if l.text != "" && len(l.text)+len(chunk) > liveEditMaxChars {
+		l.close()
+	}
+	if l.text != "" {
+		// Chunks were separate messages, so each starts on a new line.
+		l.text += "\n"
+	}
+	l.text += chunk
+	if l.ts == "" {
+		l.open(filterText(l.ctx, l.channel, l.user, l.partial()))
+		return
+	}
+	l.flush(false)
+}
+
+// partial is the text streamed so far with a cursor. Only the final text
+// is fully rendered, but the emoji policy applies to every edit.
+func (l *liveAnswer) partial() string {
+	return enforceEmojiPolicy(l.ctx, l.api, l.channel, l.text) + liveEditCursor
+}
+
+// flush writes the current text, finished or with a cursor; intermediate
+// writes are skipped while the channel's pacing doesn't allow an edit.
+func (l *liveAnswer) flush(final bool) {
+	if l.ts == "" {
+		return
+	}
+	now := time.Now()
+	if !final && !updatePacing.due(l.channel, now) {
+		return
+	}
+	text, files := l.partial(), []tableAttachment(nil)
+	if final {
+		text, files = renderAnswer(l.ctx, l.api, l.channel, l.user, l.text)
+	}
+	text = filterText(l.ctx, l.channel, l.user, text)
+	if text == l.written {
+		return
+	}
+	_, _, _, err := l.api.UpdateMessageContext(l.ctx, l.channel, l.ts, slack.MsgOptionText(text, false))
+	updatePacing.observe(l.ctx, l.channel, err, now)
+	if err != nil {
+		metricLiveEdit.Add("errors", 1)
+		if final {
+			trace.SpanFromContext(l.ctx).RecordError(err)
+			slog.ErrorContext(l.ctx, "Failed to finish live answer", "channel", l.channel, "ts", l.ts, "err", err)
+		}
+		return
+	}
+	l.written = text
+	metricLiveEdit.Add("updates", 1)
+	if final {
+		uploadTables(l.ctx, l.api, l.channel, l.user, l.ts, files)
+	}
+}
+
+// close finishes the current message so that the next chunk starts a new
+// one.
+func (l *liveAnswer) close() {
+	if l.text != "" {
+		l.flush(true)
+		l.ts, l.text, l.written = "", "", ""
+	}
+}
+
+// finish writes the final text, or removes the placeholder when nothing
+// was streamed into it.
+func (l *liveAnswer) finish() {
+	if l.ts == "" {
+		return
+	}
+	if l.text == "" {
+		metricLiveEdit.Add("empty", 1)
+		if _, _, err := l.api.DeleteMessageContext(l.ctx, l.channel, l.ts); err != nil {
+			slog.ErrorContext(l.ctx, "Failed to remove live answer placeholder", "channel", l.channel, "ts", l.ts, "err", err)
+		}
+		return
+	}
+	trace.SpanFromContext(l.ctx).SetAttributes(attribute.Bool("answer.live_edit", true))
+	l.flush(true)
+}
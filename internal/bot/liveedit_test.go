@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestProcessTask_LiveEditUpdatesOneMessage(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CLIVE": {LiveEdit: true, Disclaimer: "_Check with support._"}}})
+	defer setChannelSettings(channelSettings{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, part := range []string{"First part.", "Second part.", "Third part."} {
+			fmt.Fprintf(w, "data: {\"event\":\"message_part\",\"text_chunk\":%q}\n\n", part)
+		}
+		fmt.Fprint(w, "data: {\"event\":\"stream_end\",\"status\":\"done\"}\n\n")
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	rec := processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CLIVE"}, "foo")
+
+	if len(api.posts) != 1 || api.posts[0].Text() != liveEditPlaceholder {
+		t.Fatalf("expected only the placeholder to be posted, got %+v", api.posts)
+	}
+	if len(api.updates) == 0 {
+		t.Fatal("the placeholder was never updated")
+	}
+	final := api.updates[len(api.updates)-1]
+	want := "First part.\nSecond part.\nThird part.\n_Check with support._"
+	if final.Values.Get("ts") != "1.000100" || final.Text() != want {
+		t.Errorf("final update %q on %s, want %q", final.Text(), final.Values.Get("ts"), want)
+	}
+	if rec == nil || len(rec.MessageTS) != 1 || len(rec.Answer) != 3 {
+		t.Errorf("record %+v", rec)
+	}
+}
+
+func TestLiveAnswer_RollsOverAndRemovesEmptyPlaceholder(t *testing.T) {
+	api := &fakeSlackClient{}
+	var started []string
+	live := newLiveAnswer(context.Background(), api, "CLIVE2", "U1", func(ts string) { started = append(started, ts) })
+	live.start()
+	long := strings.Repeat("x", liveEditMaxChars-5)
+	live.add(long)
+	live.add("overflow")
+	live.finish()
+	if len(started) != 2 {
+		t.Fatalf("expected the answer to continue in a second message, started %v", started)
+	}
+	last := api.updates[len(api.updates)-1]
+	if last.Values.Get("ts") != started[1] || last.Text() != "overflow" {
+		t.Errorf("last update %q on %s", last.Text(), last.Values.Get("ts"))
+	}
+
+	empty := newLiveAnswer(context.Background(), api, "CLIVE2", "U1", func(string) {})
+	empty.start()
+	empty.finish()
+	if len(api.deleted) != 1 {
+		t.Errorf("empty placeholder not removed: %v", api.deleted)
+	}
+}
+
+func TestLiveAnswer_IntermediateEditsFollowEmojiPolicy(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CLIVEE": {LiveEdit: true, EmojiPolicy: EmojiNone}}})
+	defer setChannelSettings(channelSettings{})
+
+	api := &fakeSlackClient{}
+	live := newLiveAnswer(context.Background(), api, "CLIVEE", "U1", func(string) {})
+	live.start()
+	live.add("Great :tada:")
+	live.add("Shipped 🎉")
+	if len(api.updates) == 0 {
+		t.Fatal("no intermediate edit was written")
+	}
+	for _, p := range append(api.sent(), api.updates...) {
+		if text := p.Text(); strings.Contains(text, ":tada:") || strings.Contains(text, "🎉") || strings.Contains(text, ":hourglass") {
+			t.Errorf("live edit shows emoji the channel forbids: %q", text)
+		}
+	}
+	live.finish()
+}
@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Locale Formatting
+//
+// Times the backend writes with an explicit zone (ISO 8601, or "14:00 UTC")
+// are rewritten into the asker's Slack profile timezone, on a 12- or 24-hour
+// clock depending on their locale. Channels with "convert_units" also get
+// imperial or metric equivalents added next to measurements. Code is left
+// untouched.
+const userLocaleTTL = time.Hour
+
+type userLocale struct {
+	Location *time.Location
+	Locale   string
+	fetched  time.Time
+}
+
+var userLocaleCache sync.Map
+
+// Locales that conventionally use a 12-hour clock or imperial units.
+var (
+	twelveHourLocales = map[string]bool{"en-US": true, "en-CA": true, "en-AU": true, "en-NZ": true, "en-IN": true, "en-PH": true, "hi-IN": true}
+	imperialLocales   = map[string]bool{"en-US": true, "en-LR": true, "my-MM": true}
+)
+
+func lookupUserLocale(ctx context.Context, api SlackClient, user string) userLocale {
+	if v, ok := userLocaleCache.Load(user); ok {
+		if ul := v.(userLocale); time.Since(ul.fetched) < userLocaleTTL {
+			return ul
+		}
+	}
+	ul := userLocale{Location: time.UTC, Locale: "en-US", fetched: time.Now()}
+	info, err := api.GetUserInfoContext(ctx, user)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up user", "user", user, "err", err)
+		return ul
+	}
+	if loc, err := time.LoadLocation(info.TZ); err == nil && info.TZ != "" {
+		ul.Location = loc
+	}
+	if info.Locale != "" {
+		ul.Locale = info.Locale
+	}
+	userLocaleCache.Store(user, ul)
+	return ul
+}
+
+func (ul userLocale) clockLayout() string {
+	if twelveHourLocales[ul.Locale] {
+		return "3:04 PM MST"
+	}
+	return "15:04 MST"
+}
+
+var (
+	isoTimePattern   = regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}T\d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?(?:Z|[+-]\d{2}:\d{2})`)
+	clockTimePattern = regexp.MustCompile(`(?i)\b(\d{1,2}):(\d{2})(?:\s*([ap]m))?\s+(UTC|GMT)\b`)
+	codePattern      = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+)
+
+// outsideCode applies fn to the parts of text that are not code spans.
+func outsideCode(text string, fn func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range codePattern.FindAllStringIndex(text, -1) {
+		b.WriteString(fn(text[last:loc[0]]))
+		b.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(fn(text[last:]))
+	return b.String()
+}
+
+func localizeTimes(text string, ul userLocale, now time.Time) string {
+	text = isoTimePattern.ReplaceAllStringFunc(text, func(s string) string {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return s
+		}
+		t = t.In(ul.Location)
+		return t.Format("Mon Jan 2 ") + t.Format(ul.clockLayout())
+	})
+	return clockTimePattern.ReplaceAllStringFunc(text, func(s string) string {
+		m := clockTimePattern.FindStringSubmatch(s)
+		hour, _ := strconv.Atoi(m[1])
+		minute, _ := strconv.Atoi(m[2])
+		switch strings.ToLower(m[3]) {
+		case "pm":
+			if hour < 12 {
+				hour += 12
+			}
+		case "am":
+			if hour == 12 {
+				hour = 0
+			}
+		}
+		if hour > 23 || minute > 59 {
+			return s
+		}
+		// A bare clock time is taken to mean today (UTC) so the offset
+		// reflects daylight saving at the time of the answer.
+		utc := now.UTC()
+		t := time.Date(utc.Year(), utc.Month(), utc.Day(), hour, minute, 0, 0, time.UTC).In(ul.Location)
+		return t.Format(ul.clockLayout())
+	})
+}
+
+type unitRule struct {
+	pattern  *regexp.Regexp
+	imperial bool // whether the matched unit is imperial
+	convert  func(float64) float64
+	target   string
+}
+
+func newUnitRule(units string, imperial bool, target string, convert func(float64) float64) unitRule {
+	// A following "(" means the conversion is already present.
+	return unitRule{
+		pattern:  regexp.MustCompile(`(-?\d+(?:[.,]\d+)?)\s?(?:` + units + `)\b(\s*\()?`),
+		imperial: imperial,
+		convert:  convert,
+		target:   target,
+	}
+}
+
+var unitRules = []unitRule{
+	newUnitRule(`km|kilomet(?:er|re)s?`, false, "mi", func(v float64) float64 { return v * 0.621371 }),
+	newUnitRule(`kg|kilograms?`, false, "lb", func(v float64) float64 { return v * 2.20462 }),
+	newUnitRule(`°C`, false, "°F", func(v float64) float64 { return v*9/5 + 32 }),
+	newUnitRule(`L|litres?|liters?`, false, "gal", func(v float64) float64 { return v * 0.264172 }),
+	newUnitRule(`mi|miles?`, true, "km", func(v float64) float64 { return v / 0.621371 }),
+	newUnitRule(`lbs?|pounds?`, true, "kg", func(v float64) float64 { return v / 2.20462 }),
+	newUnitRule(`°F`, true, "°C", func(v float64) float64 { return (v - 32) * 5 / 9 }),
+	newUnitRule(`gal|gallons?`, true, "L", func(v float64) float64 { return v / 0.264172 }),
+}
+
+// convertUnits adds the reader's preferred unit after measurements in the
+// other system, e.g. "10 km" becomes "10 km (6.2 mi)" for imperial locales.
+func convertUnits(text string, ul userLocale) string {
+	wantImperial := imperialLocales[ul.Locale]
+	for _, rule := range unitRules {
+		if rule.imperial == wantImperial {
+			continue
+		}
+		text = rule.pattern.ReplaceAllStringFunc(text, func(s string) string {
+			m := rule.pattern.FindStringSubmatch(s)
+			if m[2] != "" {
+				return s
+			}
+			v, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", "."), 64)
+			if err != nil {
+				return s
+			}
+			return fmt.Sprintf("%s (%s %s)", s, strconv.FormatFloat(rule.convert(v), 'f', 1, 64), rule.target)
+		})
+	}
+	return text
+}
+
+func localizeAnswer(ctx context.Context, api SlackClient, channel, user, text string) string {
+	if user == "" {
+		return text
+	}
+	ul := lookupUserLocale(ctx, api, user)
+	units := channelConfigFor(channel).ConvertUnits
+	now := time.Now()
+	return outsideCode(text, func(s string) string {
+		s = localizeTimes(s, ul, now)
+		if units {
+			s = convertUnits(s, ul)
+		}
+		return s
+	})
+}
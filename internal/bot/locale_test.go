@@ -0,0 +1,54 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalizeTimes(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	now := time.Date(2026, 7, 1, 8, 0, 0, 0, time.UTC)
+	de := userLocale{Location: berlin, Locale: "de-DE"}
+	us := userLocale{Location: time.UTC, Locale: "en-US"}
+
+	if got := localizeTimes("The window opens at 14:00 UTC.", de, now); got != "The window opens at 16:00 CEST." {
+		t.Errorf("got %q", got)
+	}
+	if got := localizeTimes("Deploy at 2026-12-01T09:30:00Z", de, now); got != "Deploy at Tue Dec 1 10:30 CET" {
+		t.Errorf("got %q", got)
+	}
+	if got := localizeTimes("Standup is at 9:15 am GMT", us, now); got != "Standup is at 9:15 AM UTC" {
+		t.Errorf("got %q", got)
+	}
+	if got := localizeTimes("Meet at 14:00 tomorrow", de, now); got != "Meet at 14:00 tomorrow" {
+		t.Errorf("times without a zone must be left alone, got %q", got)
+	}
+}
+
+func TestConvertUnits(t *testing.T) {
+	us := userLocale{Location: time.UTC, Locale: "en-US"}
+	fr := userLocale{Location: time.UTC, Locale: "fr-FR"}
+	if got := convertUnits("It is 10 km away and 25°C.", us); got != "It is 10 km (6.2 mi) away and 25°C (77.0 °F)." {
+		t.Errorf("got %q", got)
+	}
+	if got := convertUnits("Carry 5 lbs for 3 miles.", fr); got != "Carry 5 lbs (2.3 kg) for 3 miles (4.8 km)." {
+		t.Errorf("got %q", got)
+	}
+	if got := convertUnits("It is 10 km (6.2 mi) away, 5 minutes.", us); got != "It is 10 km (6.2 mi) away, 5 minutes." {
+		t.Errorf("existing conversions must not be repeated, got %q", got)
+	}
+}
+
+func TestLocalizeAnswer_SkipsCode(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CUNITS": {ConvertUnits: true}}})
+	defer setChannelSettings(channelSettings{})
+	api := &fakeSlackClient{}
+	got := localizeAnswer(context.Background(), api, "CUNITS", "UFR", "Run `sleep 10 km` then walk 10 km.")
+	if got != "Run `sleep 10 km` then walk 10 km (6.2 mi)." {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,146 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Locale Patterns
+//
+// Scheduled messages (digests) are rendered for their reader: dates, clock
+// times and numbers follow the conventions of the reader's locale, taken
+// from the CLDR patterns below, in the reader's timezone. Locales are
+// matched exactly ("pt-BR"), then by language ("pt"), then fall back to
+// en-US. Templates get the formatting as functions:
+//
+//	{{date .Since}}      Jan 2 / 2. Jan. / 2 janv.
+//	{{time .Until}}      3:04 PM CET / 15:04 CET
+//	{{datetime .Until}}  date and time together
+//	{{number .Messages}} 12,345 / 12.345 / 12 345 (narrow space)
+type localePatterns struct {
+	// monthDay is the CLDR "MMMd" skeleton, with {d}, {M} and {MMM}.
+	monthDay string
+	months   [12]string
+	group    string
+	decimal  string
+}
+
+var cldrPatterns = map[string]localePatterns{
+	"en":    {monthDay: "{MMM} {d}", months: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}, group: ",", decimal: "."},
+	"en-GB": {monthDay: "{d} {MMM}", months: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sept", "Oct", "Nov", "Dec"}, group: ",", decimal: "."},
+	"de":    {monthDay: "{d}. {MMM}", months: [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."}, group: ".", decimal: ","},
+	"fr":    {monthDay: "{d} {MMM}", months: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."}, group: "\u202f", decimal: ","},
+	"es":    {monthDay: "{d} {MMM}", months: [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"}, group: ".", decimal: ","},
+	"pt":    {monthDay: "{d} de {MMM}", months: [12]string{"jan.", "fev.", "mar.", "abr.", "mai.", "jun.", "jul.", "ago.", "set.", "out.", "nov.", "dez."}, group: ".", decimal: ","},
+	"ja":    {monthDay: "{M}月{d}日", group: ",", decimal: "."},
+}
+
+// localeFormat renders values for one reader.
+type localeFormat struct {
+	Locale   string
+	Location *time.Location
+	patterns localePatterns
+}
+
+func newLocaleFormat(locale string, loc *time.Location) localeFormat {
+	locale = strings.ReplaceAll(locale, "_", "-")
+	if locale == "" {
+		locale = "en-US"
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	p, ok := cldrPatterns[locale]
+	if !ok {
+		lang, _, _ := strings.Cut(locale, "-")
+		if p, ok = cldrPatterns[lang]; !ok {
+			p = cldrPatterns["en"]
+		}
+	}
+	return localeFormat{Locale: locale, Location: loc, patterns: p}
+}
+
+// localeFormatFor uses channel's locale and timezone settings.
+func localeFormatFor(channel string) localeFormat {
+	cc := channelConfigFor(channel)
+	loc, err := time.LoadLocation(cc.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return newLocaleFormat(cc.Locale, loc)
+}
+
+func (f localeFormat) date(t time.Time) string {
+	t = t.In(f.Location)
+	return strings.NewReplacer(
+		"{d}", strconv.Itoa(t.Day()),
+		"{MMM}", f.patterns.months[t.Month()-1],
+		"{M}", strconv.Itoa(int(t.Month())),
+	).Replace(f.patterns.monthDay)
+}
+
+func (f localeFormat) clock(t time.Time) string {
+	layout := "15:04 MST"
+	if twelveHourLocales[f.Locale] {
+		layout = "3:04 PM MST"
+	}
+	return t.In(f.Location).Format(layout)
+}
+
+func (f localeFormat) datetime(t time.Time) string {
+	return f.date(t) + " " + f.clock(t)
+}
+
+// number groups thousands of an integer or float with the locale's
+// separators; floats keep up to two decimals.
+func (f localeFormat) number(v any) string {
+	var s string
+	switch n := v.(type) {
+	case int:
+		s = strconv.Itoa(n)
+	case int64:
+		s = strconv.FormatInt(n, 10)
+	case float64:
+		s = strings.TrimRight(strings.TrimRight(strconv.FormatFloat(n, 'f', 2, 64), "0"), ".")
+	default:
+		return fmt.Sprint(v)
+	}
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(f.patterns.group)
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteString(f.patterns.decimal + frac)
+	}
+	return sign + b.String()
+}
+
+// funcs are the template functions for f; templates are parsed with
+// newLocaleFormat("", nil).funcs() and rebound per reader.
+func (f localeFormat) funcs() template.FuncMap {
+	return template.FuncMap{
+		"date":     f.date,
+		"time":     f.clock,
+		"datetime": f.datetime,
+		"number":   f.number,
+	}
+}
+
+// validateTimezone rejects timezone names the runtime doesn't know.
+func validateTimezone(name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocaleFormat(t *testing.T) {
+	at := time.Date(2026, 3, 2, 14, 5, 0, 0, time.UTC)
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	for _, tc := range []struct {
+		locale     string
+		loc        *time.Location
+		date, time string
+		number     string
+	}{
+		{"", nil, "Mar 2", "2:05 PM UTC", "1,234,567.5"},
+		{"en-GB", time.UTC, "2 Mar", "14:05 UTC", "1,234,567.5"},
+		{"de_DE", time.UTC, "2. März", "14:05 UTC", "1.234.567,5"},
+		{"fr-CA", time.UTC, "2 mars", "14:05 UTC", "1\u202f234\u202f567,5"},
+		{"ja-JP", tokyo, "3月2日", "23:05 JST", "1,234,567.5"},
+		{"xx", time.UTC, "Mar 2", "14:05 UTC", "1,234,567.5"},
+	} {
+		f := newLocaleFormat(tc.locale, tc.loc)
+		if got := f.date(at); got != tc.date {
+			t.Errorf("%q date = %q, want %q", tc.locale, got, tc.date)
+		}
+		if got := f.clock(at); got != tc.time {
+			t.Errorf("%q time = %q, want %q", tc.locale, got, tc.time)
+		}
+		if got := f.number(1234567.5); got != tc.number {
+			t.Errorf("%q number = %q, want %q", tc.locale, got, tc.number)
+		}
+	}
+	if got := newLocaleFormat("de-DE", nil).number(-1000); got != "-1.000" {
+		t.Errorf("negative number = %q", got)
+	}
+}
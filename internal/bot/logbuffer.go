@@ -0,0 +1,220 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recent Logs
+//
+// The log output is teed into a fixed-size ring of parsed entries so recent
+// output can be pulled from GET /admin/logs or included in diagnostics
+// without a log aggregator. JSON lines from slog (see logging.go) keep their
+// level, time and trace fields; debug lines count as info. For plain text
+// lines the level is inferred from the message: "Failed…"/"Error…" lines
+// are errors and "Warning…" lines warnings.
+const defaultLogBufferSize = 1000
+
+const (
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	TraceID string    `json:"trace_id,omitempty"`
+	SpanID  string    `json:"span_id,omitempty"`
+	Message string    `json:"message"`
+
+	line string
+}
+
+var (
+	logTimestampPattern = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(\.\d+)? `)
+	logTracePattern     = regexp.MustCompile(`^\[trace_id=([0-9a-f]+) span_id=([0-9a-f]+)\] `)
+)
+
+// jsonLogLine is the part of a slog JSON line the ring keeps.
+type jsonLogLine struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Msg     string    `json:"msg"`
+	TraceID string    `json:"trace_id"`
+	SpanID  string    `json:"span_id"`
+}
+
+func parseLogLine(line string, at time.Time) LogEntry {
+	e := LogEntry{Time: at, Level: LogLevelInfo, line: line}
+	var j jsonLogLine
+	if strings.HasPrefix(line, "{") && json.Unmarshal([]byte(line), &j) == nil {
+		if !j.Time.IsZero() {
+			e.Time = j.Time
+		}
+		switch level := strings.ToLower(j.Level); {
+		case strings.HasPrefix(level, "error"):
+			e.Level = LogLevelError
+		case strings.HasPrefix(level, "warn"):
+			e.Level = LogLevelWarn
+		}
+		e.TraceID, e.SpanID, e.Message = j.TraceID, j.SpanID, j.Msg
+		return e
+	}
+	msg := logTimestampPattern.ReplaceAllString(line, "")
+	if m := logTracePattern.FindStringSubmatch(msg); m != nil {
+		if strings.Trim(m[1], "0") != "" {
+			e.TraceID, e.SpanID = m[1], m[2]
+		}
+		msg = msg[len(m[0]):]
+	}
+	switch {
+	case strings.HasPrefix(msg, "Failed"), strings.HasPrefix(msg, "Error"):
+		e.Level = LogLevelError
+	case strings.HasPrefix(msg, "Warning"):
+		e.Level = LogLevelWarn
+	}
+	e.Message = msg
+	return e
+}
+
+func logLevelRank(level string) int {
+	switch level {
+	case LogLevelError:
+		return 2
+	case LogLevelWarn:
+		return 1
+	}
+	return 0
+}
+
+type logRing struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	next    int
+	full    bool
+}
+
+var recentLogs = newLogRing(defaultLogBufferSize)
+
+func newLogRing(n int) *logRing {
+	return &logRing{entries: make([]LogEntry, n)}
+}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		r.entries[r.next] = parseLogLine(line, now)
+		r.next = (r.next + 1) % len(r.entries)
+		if r.next == 0 {
+			r.full = true
+		}
+	}
+	return len(p), nil
+}
+
+// Entries returns the buffered entries, oldest first.
+func (r *logRing) Entries() []LogEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]LogEntry(nil), r.entries[:r.next]...)
+	}
+	return append(append([]LogEntry(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// Lines returns the raw buffered log lines, oldest first.
+func (r *logRing) Lines() []string {
+	entries := r.Entries()
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = e.line
+	}
+	return lines
+}
+
+type logQuery struct {
+	MinLevel string
+	Since    time.Time
+	TraceID  string
+	Limit    int
+}
+
+// Query returns matching entries, oldest first, keeping the newest Limit.
+func (r *logRing) Query(q logQuery) []LogEntry {
+	out := []LogEntry{}
+	for _, e := range r.Entries() {
+		if logLevelRank(e.Level) < logLevelRank(q.MinLevel) || e.Time.Before(q.Since) {
+			continue
+		}
+		if q.TraceID != "" && e.TraceID != q.TraceID {
+			continue
+		}
+		out = append(out, e)
+	}
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[len(out)-q.Limit:]
+	}
+	return out
+}
+
+// parseSince accepts an RFC 3339 time or a duration back from now ("15m").
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// requireAdminToken guards /admin endpoints with ADMIN_API_TOKEN; without a
+// token configured they are disabled.
+func requireAdminToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminAPIToken == "" {
+			http.Error(w, "admin API disabled", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminAPIToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func adminLogsHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := logQuery{MinLevel: strings.ToLower(params.Get("level")), TraceID: params.Get("trace_id")}
+	switch q.MinLevel {
+	case "", LogLevelInfo, LogLevelWarn, LogLevelError:
+	default:
+		http.Error(w, "level must be info, warn or error", http.StatusBadRequest)
+		return
+	}
+	since, err := parseSince(params.Get("since"), time.Now())
+	if err != nil {
+		http.Error(w, "since must be an RFC 3339 time or a duration", http.StatusBadRequest)
+		return
+	}
+	q.Since = since
+	if limit := params.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recentLogs.Query(q))
+}
@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLogLine(t *testing.T) {
+	now := time.Now()
+	e := parseLogLine("2026/10/16 09:00:00 [trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7] Failed to reach backend", now)
+	if e.Level != LogLevelError || e.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || e.SpanID != "00f067aa0ba902b7" || e.Message != "Failed to reach backend" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	e = parseLogLine("2026/10/16 09:00:00 [trace_id=00000000000000000000000000000000 span_id=0000000000000000] Received mention: hi", now)
+	if e.Level != LogLevelInfo || e.TraceID != "" || e.Message != "Received mention: hi" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := parseLogLine("Warning: No .env file loaded", now); e.Level != LogLevelWarn {
+		t.Errorf("expected warn level, got %+v", e)
+	}
+}
+
+func TestAdminLogsHandler(t *testing.T) {
+	defer func(ring *logRing, token string) { recentLogs, config.AdminAPIToken = ring, token }(recentLogs, config.AdminAPIToken)
+	recentLogs = newLogRing(10)
+	recentLogs.Write([]byte("[trace_id=aa11 span_id=bb22] Received mention: hi\n"))
+	recentLogs.Write([]byte("[trace_id=aa11 span_id=bb33] Failed to reach backend\n"))
+	recentLogs.Write([]byte("Failed to post review 1: timeout\n"))
+
+	handler := requireAdminToken(adminLogsHandler)
+	get := func(url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	config.AdminAPIToken = ""
+	if w := get("/admin/logs", ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected admin API disabled without a token, got %d", w.Code)
+	}
+	config.AdminAPIToken = "s3cret"
+	if w := get("/admin/logs", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a bad token, got %d", w.Code)
+	}
+
+	var entries []LogEntry
+	w := get("/admin/logs?level=error&since=5m&trace_id=aa11", "s3cret")
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Message != "Failed to reach backend" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	w = get("/admin/logs?level=error&limit=1", "s3cret")
+	json.NewDecoder(w.Body).Decode(&entries)
+	if len(entries) != 1 || entries[0].Message != "Failed to post review 1: timeout" {
+		t.Errorf("limit should keep the newest entries, got %+v", entries)
+	}
+
+	if w := get("/admin/logs?since=yesterday", "s3cret"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad since, got %d", w.Code)
+	}
+}
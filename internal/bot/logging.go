@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Structured Logging
+//
+// Logs are written with log/slog as JSON lines on stderr, and teed into the
+// recent-logs ring (see logbuffer.go). LOG_LEVEL (debug, info, warn or
+// error; default info) drops quieter lines. Every line logged with a
+// context carries the trace_id and span_id of its span and, once an entry
+// point has named them, the user_id and channel_id of the request, so
+// lines can be joined with traces and filtered per user or channel without
+// parsing messages. What still goes through the standard log package,
+// namely startup failures from log.Fatal, is logged at error level.
+
+type logFieldsKey struct{}
+
+type logFields struct {
+	User    string
+	Channel string
+}
+
+// withLogFields names the user and channel that later log lines under ctx
+// are about; empty values keep what an outer entry point set.
+func withLogFields(ctx context.Context, user, channel string) context.Context {
+	f, _ := ctx.Value(logFieldsKey{}).(logFields)
+	if user != "" {
+		f.User = user
+	}
+	if channel != "" {
+		f.Channel = channel
+	}
+	return context.WithValue(ctx, logFieldsKey{}, f)
+}
+
+// contextHandler adds the trace and request fields of the record's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+		if f, ok := ctx.Value(logFieldsKey{}).(logFields); ok {
+			if f.User != "" {
+				r.AddAttrs(slog.String("user_id", f.User))
+			}
+			if f.Channel != "" {
+				r.AddAttrs(slog.String("channel_id", f.Channel))
+			}
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid LOG_LEVEL %q: use debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})})
+}
+
+// setupLogging installs the default logger; an invalid LOG_LEVEL is
+// reported after falling back to info.
+func setupLogging() error {
+	level, err := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	logger := newLogger(io.MultiWriter(os.Stderr, recentLogs), level)
+	slog.SetDefault(logger)
+	log.SetOutput(slog.NewLogLogger(logger.Handler(), slog.LevelError).Writer())
+	log.SetFlags(0)
+	return err
+}
+
+// socketLogger sends the socketmode client's chatter to the default logger
+// at debug level.
+func socketLogger() *log.Logger {
+	l := slog.NewLogLogger(slog.Default().Handler(), slog.LevelDebug)
+	l.SetPrefix("socketmode: ")
+	return l
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestContextHandler_AddsTraceAndRequestFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, slog.LevelInfo)
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "op")
+	defer span.End()
+	ctx = withLogFields(ctx, "U1", "C1")
+	ctx = withLogFields(ctx, "", "C2")
+	logger.InfoContext(ctx, "answered")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("not a JSON line: %q", buf.String())
+	}
+	sc := span.SpanContext()
+	want := map[string]string{
+		"level":      "INFO",
+		"msg":        "answered",
+		"trace_id":   sc.TraceID().String(),
+		"span_id":    sc.SpanID().String(),
+		"user_id":    "U1",
+		"channel_id": "C2",
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %q", k, line[k], v)
+		}
+	}
+}
+
+func TestNewLogger_DropsLinesBelowLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, slog.LevelWarn)
+	logger.Info("quiet")
+	if buf.Len() != 0 {
+		t.Errorf("info line logged at warn level: %q", buf.String())
+	}
+	logger.Debug("quieter")
+	logger.Warn("loud")
+	if !bytes.Contains(buf.Bytes(), []byte(`"msg":"loud"`)) {
+		t.Errorf("warn line missing: %q", buf.String())
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "WARN": slog.LevelWarn, "error": slog.LevelError} {
+		if got, err := parseLogLevel(in); err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if level, err := parseLogLevel("loud"); err == nil || level != slog.LevelInfo {
+		t.Errorf("parseLogLevel(loud) = %v, %v; want info and an error", level, err)
+	}
+}
+
+func TestLogRing_ParsesJSONLines(t *testing.T) {
+	ring := newLogRing(4)
+	logger := newLogger(ring, slog.LevelDebug)
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "op")
+	defer span.End()
+	logger.WarnContext(ctx, "Queue full")
+	logger.Debug("tick")
+
+	entries := ring.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	e := entries[0]
+	if e.Level != LogLevelWarn || e.Message != "Queue full" || e.TraceID != span.SpanContext().TraceID().String() {
+		t.Errorf("entry %+v", e)
+	}
+	if time.Since(e.Time) > time.Minute {
+		t.Errorf("entry time %v not taken from the line", e.Time)
+	}
+	if entries[1].Level != LogLevelInfo {
+		t.Errorf("debug line level %q, want info", entries[1].Level)
+	}
+}
@@ -0,0 +1,275 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Maintenance Mode
+//
+// In maintenance mode the bot still acks every event and runs commands, but
+// answers questions with a notice instead of calling the backend. It is
+// turned on by an admin ("!maintenance on", POST /admin/maintenance), by the
+// existence of MAINTENANCE_FILE (its contents replace the notice), or
+// automatically when more than PANIC_ERROR_RATE of the backend requests in
+// the last panicWindow failed. Automatic and admin maintenance end only
+// when an admin turns it off; file maintenance ends when the file is gone.
+const (
+	defaultMaintenanceNotice   = ":construction: I'm down for maintenance right now. Please try again later."
+	defaultPanicMinRequests    = 20
+	panicWindow                = 5 * time.Minute
+	maintenanceFileCheckPeriod = 10 * time.Second
+)
+
+var errMaintenance = errors.New("maintenance mode is on")
+
+var (
+	metricMaintenanceNotices = expvar.NewInt("maintenance_notices")
+	metricMaintenanceTrips   = expvar.NewInt("maintenance_auto_trips")
+)
+
+type backendOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+type maintenanceMode struct {
+	mu sync.Mutex
+
+	notice      string
+	path        string
+	threshold   float64
+	minRequests int
+
+	manual       bool
+	manualNotice string
+	by           string
+	since        time.Time
+
+	fileActive bool
+	fileNotice string
+
+	auto       bool
+	autoReason string
+
+	outcomes []backendOutcome
+}
+
+func newMaintenanceMode() *maintenanceMode {
+	return &maintenanceMode{notice: defaultMaintenanceNotice, minRequests: defaultPanicMinRequests}
+}
+
+var maintenance = newMaintenanceMode()
+
+// configure applies the environment settings; empty or zero values keep
+// the defaults and a zero threshold disables automatic maintenance.
+func (m *maintenanceMode) configure(notice, path string, threshold float64, minRequests int) {
+	m.mu.Lock()
+	if notice != "" {
+		m.notice = notice
+	}
+	if minRequests > 0 {
+		m.minRequests = minRequests
+	}
+	m.path, m.threshold = path, threshold
+	m.mu.Unlock()
+	m.checkFile()
+}
+
+type maintenanceStatus struct {
+	Active bool      `json:"active"`
+	Source string    `json:"source,omitempty"`
+	Notice string    `json:"notice,omitempty"`
+	By     string    `json:"by,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+func (m *maintenanceMode) status() maintenanceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.manual:
+		notice := m.manualNotice
+		if notice == "" {
+			notice = m.notice
+		}
+		return maintenanceStatus{Active: true, Source: "admin", Notice: notice, By: m.by, Since: m.since}
+	case m.fileActive:
+		notice := m.fileNotice
+		if notice == "" {
+			notice = m.notice
+		}
+		return maintenanceStatus{Active: true, Source: "file", Notice: notice}
+	case m.auto:
+		return maintenanceStatus{Active: true, Source: "auto", Notice: m.notice, Since: m.since, Reason: m.autoReason}
+	}
+	return maintenanceStatus{}
+}
+
+// set turns admin maintenance on or off. Turning it off also clears
+// automatic maintenance and the error history that triggered it.
+func (m *maintenanceMode) set(enabled bool, notice, by string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.manual, m.manualNotice, m.by = enabled, notice, by
+	if enabled {
+		m.since = time.Now()
+		return
+	}
+	m.auto, m.autoReason, m.outcomes = false, "", nil
+}
+
+// checkFile turns file maintenance on while MAINTENANCE_FILE exists.
+func (m *maintenanceMode) checkFile() {
+	m.mu.Lock()
+	path := m.path
+	m.mu.Unlock()
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	active := err == nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case active && !m.fileActive:
+		slog.InfoContext(context.Background(), "Maintenance file found, entering maintenance mode", "path", path)
+	case !active && m.fileActive:
+		slog.InfoContext(context.Background(), "Maintenance file removed, leaving maintenance mode", "path", path)
+	}
+	m.fileActive, m.fileNotice = active, strings.TrimSpace(string(data))
+}
+
+func (m *maintenanceMode) watchFile(ctx context.Context) {
+	ticker := time.NewTicker(maintenanceFileCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.checkFile()
+		}
+	}
+}
+
+// recordBackend tracks backend outcomes and enters maintenance when the
+// failure rate over panicWindow reaches the panic threshold.
+func (m *maintenanceMode) recordBackend(failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.threshold <= 0 || m.auto {
+		return
+	}
+	now := time.Now()
+	m.outcomes = append(m.outcomes, backendOutcome{at: now, failed: failed})
+	cutoff := now.Add(-panicWindow)
+	for len(m.outcomes) > 0 && m.outcomes[0].at.Before(cutoff) {
+		m.outcomes = m.outcomes[1:]
+	}
+	failures := 0
+	for _, o := range m.outcomes {
+		if o.failed {
+			failures++
+		}
+	}
+	if len(m.outcomes) < m.minRequests || float64(failures)/float64(len(m.outcomes)) < m.threshold {
+		return
+	}
+	m.auto, m.since = true, now
+	m.autoReason = fmt.Sprintf("%d of %d backend requests failed in the last %s", failures, len(m.outcomes), panicWindow)
+	metricMaintenanceTrips.Add(1)
+	slog.WarnContext(context.Background(), "Entering maintenance mode automatically: "+m.autoReason)
+}
+
+// handleMaintenance answers with the maintenance notice when maintenance
+// is on and reports whether it did.
+func handleMaintenance(ctx context.Context, api SlackClient, channel, user string) bool {
+	st := maintenance.status()
+	if !st.Active {
+		return false
+	}
+	metricMaintenanceNotices.Add(1)
+	notifyUser(ctx, api, channel, user, st.Notice)
+	return true
+}
+
+func formatMaintenanceStatus(st maintenanceStatus) string {
+	if !st.Active {
+		return "Maintenance mode is off."
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Maintenance mode is *on* (%s)", st.Source)
+	if st.By != "" {
+		fmt.Fprintf(&b, ", set by <@%s>", st.By)
+	}
+	if !st.Since.IsZero() {
+		fmt.Fprintf(&b, " since %s", st.Since.UTC().Format(time.RFC3339))
+	}
+	if st.Reason != "" {
+		fmt.Fprintf(&b, ": %s", st.Reason)
+	}
+	fmt.Fprintf(&b, ".\nNotice: %s", st.Notice)
+	return b.String()
+}
+
+type maintenanceUpdate struct {
+	Enabled bool   `json:"enabled"`
+	Notice  string `json:"notice,omitempty"`
+}
+
+// adminMaintenanceHandler serves GET (status) and POST {"enabled", "notice"}.
+func adminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var u maintenanceUpdate
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&u); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		maintenance.set(u.Enabled, strings.TrimSpace(u.Notice), "admin-api")
+		slog.InfoContext(r.Context(), "Maintenance mode set via the admin API", "enabled", u.Enabled)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(maintenance.status())
+}
+
+func init() {
+	registerCommand("maintenance", command{
+		Admin: true,
+		Usage: "on [notice] | off",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			mode, notice, _ := strings.Cut(args, " ")
+			switch strings.ToLower(mode) {
+			case "":
+			case "on":
+				maintenance.set(true, strings.TrimSpace(notice), ev.User)
+				slog.InfoContext(ctx, "Maintenance mode turned on", "user", ev.User)
+			case "off":
+				maintenance.set(false, "", ev.User)
+				slog.InfoContext(ctx, "Maintenance mode turned off", "user", ev.User)
+			default:
+				notifyUser(ctx, api, ev.Channel, ev.User, "Usage: `!maintenance on [notice]` or `!maintenance off`")
+				return
+			}
+			notifyUser(ctx, api, ev.Channel, ev.User, formatMaintenanceStatus(maintenance.status()))
+		},
+	})
+}
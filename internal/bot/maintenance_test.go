@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestMaintenance_NoticeInsteadOfBackend(t *testing.T) {
+	defer func(m *maintenanceMode) { maintenance = m }(maintenance)
+	maintenance = newMaintenanceMode()
+	config.AdminUsers = []string{"UADMIN"}
+	defer func() { config.AdminUsers = nil }()
+
+	var backendCalls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backendCalls, 1)
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+	processMention(context.Background(), api, slackevents.AppMentionEvent{User: "UADMIN", Channel: "CMAINT", Text: "!maintenance on Upgrading the backend"}, pool)
+	processMention(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CMAINT", Text: "what is the status?"}, pool)
+	if _, err := requestAnswer(context.Background(), backend.ChatRequest{Query: "rewrite"}); err != errMaintenance {
+		t.Errorf("requestAnswer error = %v", err)
+	}
+	pool.Shutdown()
+
+	if n := atomic.LoadInt32(&backendCalls); n != 0 {
+		t.Errorf("backend called %d times in maintenance", n)
+	}
+	posts := api.sent()
+	if len(posts) != 2 || posts[1].Text() != "Upgrading the backend" || posts[1].Values.Get("user") != "U1" {
+		t.Fatalf("unexpected posts %+v", posts)
+	}
+
+	// Commands keep working, so an admin can end maintenance from Slack.
+	api = &fakeSlackClient{}
+	processMention(context.Background(), api, slackevents.AppMentionEvent{User: "UADMIN", Channel: "CMAINT", Text: "!maintenance off"}, workerpool.New(1))
+	if maintenance.status().Active {
+		t.Error("maintenance should be off")
+	}
+}
+
+func TestMaintenance_AutoTripAndFile(t *testing.T) {
+	m := newMaintenanceMode()
+	path := filepath.Join(t.TempDir(), "maintenance")
+	m.configure("", path, 0.5, 4)
+
+	m.recordBackend(false)
+	m.recordBackend(true)
+	m.recordBackend(false)
+	if m.status().Active {
+		t.Fatal("should not trip below the minimum request count")
+	}
+	m.recordBackend(true)
+	st := m.status()
+	if !st.Active || st.Source != "auto" || !strings.Contains(st.Reason, "2 of 4") {
+		t.Fatalf("expected automatic maintenance, got %+v", st)
+	}
+	m.set(false, "", "UADMIN")
+	if m.status().Active {
+		t.Fatal("admin off should clear automatic maintenance")
+	}
+
+	if err := os.WriteFile(path, []byte("Planned downtime until 18:00 UTC\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m.checkFile()
+	if st := m.status(); st.Source != "file" || st.Notice != "Planned downtime until 18:00 UTC" {
+		t.Errorf("file maintenance = %+v", st)
+	}
+	os.Remove(path)
+	m.checkFile()
+	if m.status().Active {
+		t.Error("removing the file should end maintenance")
+	}
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+)
+
+// Conversation Memory
+//
+// Follow-up questions are sent with the turns before them, so the backend
+// can resolve "what about the other one?". The last MEMORY_TURNS (default
+// 5) questions and answers of a conversation are kept for MEMORY_TTL
+// (default 1h) after its last turn and sent as "history" in the chat
+// request, oldest first. A conversation is a thread. At a channel root or
+// in a DM outside threads, each user has their own conversation. Long
+// answers are cut to memoryMaxChars. "reset" clears a conversation's
+// memory (see reset.go). MEMORY_TURNS=0 turns memory off. Private DMs and
+// self-tests are never remembered. Remembered conversations and requests
+// sent with history are counted under "conversation_memory" on
+// /debug/vars.
+const (
+	defaultMemoryTurns = 5
+	defaultMemoryTTL   = time.Hour
+	memoryMaxChars     = 4000
+)
+
+var metricConversationMemory = expvar.NewMap("conversation_memory")
+
+type memoryConversation struct {
+	turns   []backend.HistoryTurn
+	updated time.Time
+}
+
+type conversationMemory struct {
+	mu            sync.Mutex
+	turns         int
+	ttl           time.Duration
+	conversations map[string]*memoryConversation
+	now           func() time.Time
+}
+
+var memory = &conversationMemory{turns: defaultMemoryTurns, ttl: defaultMemoryTTL, conversations: make(map[string]*memoryConversation), now: time.Now}
+
+// memoryKey names the conversation a message in channel belongs to: its
+// thread, or the user's own conversation outside threads.
+func memoryKey(channel, threadTS, user string) string {
+	if threadTS != "" {
+		return messageKey(channel, threadTS)
+	}
+	return messageKey(channel, "user:"+user)
+}
+
+// configure sets how many turns are kept, and for how long; a negative
+// ttl keeps the default.
+func (m *conversationMemory) configure(turns int, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.turns = turns
+	if ttl > 0 {
+		m.ttl = ttl
+	}
+	if turns <= 0 {
+		m.conversations = make(map[string]*memoryConversation)
+	}
+}
+
+// history returns the remembered turns of key's conversation, oldest first.
+func (m *conversationMemory) history(key string) []backend.HistoryTurn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.conversations[key]
+	if !ok {
+		return nil
+	}
+	if m.now().Sub(c.updated) > m.ttl {
+		delete(m.conversations, key)
+		metricConversationMemory.Add("expired", 1)
+		return nil
+	}
+	return append([]backend.HistoryTurn(nil), c.turns...)
+}
+
+// remember appends a turn to key's conversation, dropping the oldest turns
+// beyond the limit and any expired conversations.
+func (m *conversationMemory) remember(key, query, answer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.turns <= 0 {
+		return
+	}
+	now := m.now()
+	for k, c := range m.conversations {
+		if now.Sub(c.updated) > m.ttl {
+			delete(m.conversations, k)
+			metricConversationMemory.Add("expired", 1)
+		}
+	}
+	c, ok := m.conversations[key]
+	if !ok {
+		c = &memoryConversation{}
+		m.conversations[key] = c
+		metricConversationMemory.Add("conversations", 1)
+	}
+	c.turns = append(c.turns, backend.HistoryTurn{Query: query, Answer: truncate(answer, memoryMaxChars)})
+	if len(c.turns) > m.turns {
+		c.turns = append([]backend.HistoryTurn(nil), c.turns[len(c.turns)-m.turns:]...)
+	}
+	c.updated = now
+}
+
+// forget drops key's conversation.
+func (m *conversationMemory) forget(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conversations, key)
+}
+
+// forgetTurn drops the latest turn of key's conversation asking query,
+// such as an answer that was undone.
+func (m *conversationMemory) forgetTurn(key, query string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.conversations[key]
+	if !ok {
+		return
+	}
+	for i := len(c.turns) - 1; i >= 0; i-- {
+		if c.turns[i].Query == query {
+			c.turns = append(c.turns[:i:i], c.turns[i+1:]...)
+			return
+		}
+	}
+}
+
+// conversationHistory returns the history to send with a question from
+// user in channel's thread.
+func conversationHistory(ctx context.Context, channel, threadTS, user string) []backend.HistoryTurn {
+	if isPrivateDM(ctx) || isSelfTest(ctx) {
+		return nil
+	}
+	history := memory.history(memoryKey(channel, threadTS, user))
+	if len(history) > 0 {
+		metricConversationMemory.Add("requests_with_history", 1)
+	}
+	return history
+}
+
+// rememberConversation keeps rec's question and answer for follow-ups.
+func rememberConversation(ctx context.Context, rec *ConversationRecord) {
+	if isPrivateDM(ctx) || isSelfTest(ctx) || rec.Query == "" {
+		return
+	}
+	memory.remember(memoryKey(rec.Channel, rec.ThreadTS, rec.User), rec.Query, rec.AnswerText())
+}
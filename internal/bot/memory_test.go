@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestConversationMemory_KeepsLastTurnsUntilExpiry(t *testing.T) {
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	m := &conversationMemory{conversations: make(map[string]*memoryConversation), now: func() time.Time { return now }}
+	m.configure(2, time.Hour)
+
+	for _, q := range []string{"one", "two", "three"} {
+		m.remember("C1/1.1", q, "answer "+q)
+	}
+	h := m.history("C1/1.1")
+	if len(h) != 2 || h[0].Query != "two" || h[1].Answer != "answer three" {
+		t.Fatalf("history %+v", h)
+	}
+	if len(m.history("C1/2.2")) != 0 {
+		t.Error("conversations must not share memory")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if h := m.history("C1/1.1"); h != nil {
+		t.Errorf("expired history %+v", h)
+	}
+
+	m.remember("C1/3.3", "q", "a")
+	m.forget("C1/3.3")
+	if h := m.history("C1/3.3"); h != nil {
+		t.Errorf("forgotten history %+v", h)
+	}
+}
+
+func TestProcessTask_SendsHistoryUntilReset(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	defer func(m *conversationMemory) { memory = m }(memory)
+	memory = &conversationMemory{turns: defaultMemoryTurns, ttl: defaultMemoryTTL, conversations: make(map[string]*memoryConversation), now: time.Now}
+
+	var mu sync.Mutex
+	var histories [][]backend.HistoryTurn
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		histories = append(histories, req.History)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Answer to " + req.Query})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{User: "UMEM", Channel: "CMEM", ThreadTimeStamp: "4.4"}
+	processTask(context.Background(), api, ev, "Which plan am I on?")
+	processTask(context.Background(), api, ev, "And its limits?")
+	// The same user at the channel root has a separate conversation.
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "UMEM", Channel: "CMEM"}, "Unrelated?")
+	resetConversation(context.Background(), api, "CMEM", "4.4", "UMEM", "command")
+	processTask(context.Background(), api, ev, "Start again?")
+
+	if len(histories) != 4 {
+		t.Fatalf("expected 4 backend requests, got %d", len(histories))
+	}
+	if len(histories[0]) != 0 || len(histories[2]) != 0 || len(histories[3]) != 0 {
+		t.Errorf("unexpected history: %+v", histories)
+	}
+	if h := histories[1]; len(h) != 1 || h[0].Query != "Which plan am I on?" || h[0].Answer != "Answer to Which plan am I on?" {
+		t.Errorf("follow-up history %+v", h)
+	}
+}
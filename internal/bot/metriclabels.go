@@ -0,0 +1,155 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+// Metric Labels
+//
+// Per-channel and per-user counters go through labelPolicy so their
+// cardinality stays bounded however many channels and users the bot sees.
+// A channel keeps its own label when it is listed in METRIC_CHANNELS or is
+// among the METRIC_TOP_CHANNELS busiest channels (default 10); every other
+// channel is counted as "other". Busy channels are found with a
+// space-saving sketch of a few times that many entries, so memory does not
+// grow with the number of channels either. As busy channels change over
+// time, at most that many channel labels are ever published; later
+// newcomers count as "other". Users are never labelled by ID:
+// METRIC_USER_LABELS=hash counts them in METRIC_USER_BUCKETS hashed buckets
+// (default 16), and the default, none, leaves users out. The counters are
+// published as "channel_requests" and "user_requests" on /debug/vars.
+const (
+	otherLabel            = "other"
+	defaultTopChannels    = 10
+	defaultUserBuckets    = 16
+	channelSketchPerLabel = 4
+
+	userLabelsNone = "none"
+	userLabelsHash = "hash"
+)
+
+var (
+	metricChannelRequests = expvar.NewMap("channel_requests")
+	metricUserRequests    = expvar.NewMap("user_requests")
+)
+
+type labelPolicy struct {
+	mu          sync.Mutex
+	allow       map[string]bool
+	topN        int
+	sketch      map[string]int64
+	published   map[string]bool
+	userMode    string
+	userBuckets int
+}
+
+var metricLabels = newLabelPolicy(nil, defaultTopChannels, userLabelsNone, defaultUserBuckets)
+
+func newLabelPolicy(allow []string, topN int, userMode string, userBuckets int) *labelPolicy {
+	p := &labelPolicy{allow: map[string]bool{}, topN: topN, sketch: map[string]int64{}, published: map[string]bool{}, userMode: userMode, userBuckets: userBuckets}
+	for _, ch := range allow {
+		p.allow[ch] = true
+	}
+	if p.userBuckets <= 0 {
+		p.userBuckets = defaultUserBuckets
+	}
+	return p
+}
+
+func parseUserLabelMode(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "", userLabelsNone:
+		return userLabelsNone, nil
+	case userLabelsHash:
+		return userLabelsHash, nil
+	}
+	return "", fmt.Errorf("invalid METRIC_USER_LABELS %q (use none or hash)", s)
+}
+
+// channelLabel counts a request in channel and returns the label to record
+// it under.
+func (p *labelPolicy) channelLabel(channel string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	count := p.observeLocked(channel)
+	if p.allow[channel] {
+		return channel
+	}
+	if p.topN <= 0 {
+		return otherLabel
+	}
+	busier := 0
+	for ch, c := range p.sketch {
+		if c > count || (c == count && ch < channel) {
+			busier++
+		}
+	}
+	if busier >= p.topN {
+		return otherLabel
+	}
+	if !p.published[channel] {
+		if len(p.published) >= p.topN*channelSketchPerLabel {
+			return otherLabel
+		}
+		p.published[channel] = true
+	}
+	return channel
+}
+
+// observeLocked adds one to channel in the space-saving sketch: when the
+// sketch is full, the least counted entry is replaced and its count
+// inherited. Callers hold p.mu.
+func (p *labelPolicy) observeLocked(channel string) int64 {
+	if _, ok := p.sketch[channel]; !ok && len(p.sketch) >= max(p.topN, 1)*channelSketchPerLabel {
+		var minCh string
+		var minCount int64 = -1
+		for ch, c := range p.sketch {
+			if minCount < 0 || c < minCount {
+				minCh, minCount = ch, c
+			}
+		}
+		delete(p.sketch, minCh)
+		p.sketch[channel] = minCount
+	}
+	p.sketch[channel]++
+	return p.sketch[channel]
+}
+
+// userLabel returns the user's bucket, or "" when users are not labelled.
+func (p *labelPolicy) userLabel(user string) string {
+	if p.userMode != userLabelsHash || user == "" {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(user))
+	return fmt.Sprintf("bucket-%02d", h.Sum32()%uint32(p.userBuckets))
+}
+
+// countRequest records event ("questions", "answers", "errors") for
+// channel and user under the policy's labels, and for the request's source
+// (see source.go).
+func countRequest(ctx context.Context, channel, user, event string) {
+	addLabelled(metricSourceRequests, querySourceFrom(ctx), event)
+	addLabelled(metricChannelRequests, metricLabels.channelLabel(channel), event)
+	if label := metricLabels.userLabel(user); label != "" {
+		addLabelled(metricUserRequests, label, event)
+	}
+}
+
+var labelledMu sync.Mutex
+
+func addLabelled(m *expvar.Map, label, event string) {
+	labelledMu.Lock()
+	sub, ok := m.Get(label).(*expvar.Map)
+	if !ok {
+		sub = new(expvar.Map).Init()
+		m.Set(label, sub)
+	}
+	labelledMu.Unlock()
+	sub.Add(event, 1)
+}
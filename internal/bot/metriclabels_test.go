@@ -0,0 +1,60 @@
+package bot
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLabelPolicy_TopChannelsAndAllowlist(t *testing.T) {
+	p := newLabelPolicy([]string{"CPINNED"}, 2, userLabelsNone, 0)
+	for i := 0; i < 20; i++ {
+		p.channelLabel("CBUSY")
+	}
+	for i := 0; i < 10; i++ {
+		p.channelLabel("CWARM")
+	}
+	if got := p.channelLabel("CBUSY"); got != "CBUSY" {
+		t.Errorf("busiest channel label = %q", got)
+	}
+	if got := p.channelLabel("CQUIET"); got != otherLabel {
+		t.Errorf("quiet channel label = %q, want %q", got, otherLabel)
+	}
+	if got := p.channelLabel("CPINNED"); got != "CPINNED" {
+		t.Errorf("allowlisted channel label = %q", got)
+	}
+
+	// A flood of one-off channels keeps the sketch and the labels bounded.
+	labels := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		labels[p.channelLabel(fmt.Sprintf("C%04d", i))] = true
+	}
+	if len(p.sketch) > 2*channelSketchPerLabel {
+		t.Errorf("sketch grew to %d entries", len(p.sketch))
+	}
+	if len(p.published) > 2*channelSketchPerLabel || len(labels) > 2*channelSketchPerLabel+1 {
+		t.Errorf("%d labels published, %d seen", len(p.published), len(labels))
+	}
+	if got := p.channelLabel("CBUSY"); got != "CBUSY" {
+		t.Errorf("the busiest channel should survive the flood, got %q", got)
+	}
+}
+
+func TestLabelPolicy_UserBuckets(t *testing.T) {
+	if got := newLabelPolicy(nil, 0, userLabelsNone, 0).userLabel("U1"); got != "" {
+		t.Errorf("users should not be labelled by default, got %q", got)
+	}
+	p := newLabelPolicy(nil, 0, userLabelsHash, 4)
+	buckets := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		buckets[p.userLabel(fmt.Sprintf("U%d", i))] = true
+	}
+	if len(buckets) != 4 {
+		t.Errorf("expected 4 buckets, got %v", buckets)
+	}
+	if p.userLabel("U1") != p.userLabel("U1") {
+		t.Error("bucketing should be stable")
+	}
+	if _, err := parseUserLabelMode("id"); err == nil {
+		t.Error("raw user IDs should not be an option")
+	}
+}
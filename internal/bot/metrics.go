@@ -0,0 +1,14 @@
+package bot
+
+import "expvar"
+
+// Metrics
+//
+// Counters are published with expvar and served as JSON on /debug/vars.
+var (
+	metricDuplicateChunks  = expvar.NewInt("stream_duplicate_chunks_suppressed")
+	metricOutboxQueued     = expvar.NewInt("outbox_queued")
+	metricOutboxDelivered  = expvar.NewInt("outbox_delivered")
+	metricOutboxDead       = expvar.NewInt("outbox_dead_letters")
+	metricSmallTalkReplies = expvar.NewInt("small_talk_replies")
+)
@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"gopkg.in/yaml.v3"
+)
+
+// Mock Backend Scenarios
+//
+// MOCK_SCENARIOS points the mock backend at a YAML file of scripted
+// behaviours, so streaming edge cases can be reproduced and kept as a
+// regression suite:
+//
+//	scenarios:
+//	  - name: flaky then slow
+//	    match: "(?i)deploy"   # regular expression on the query
+//	    times: 2              # only the first 2 matching requests
+//	    status: 503
+//	    body: "overloaded"
+//	  - name: garbled stream
+//	    match: "(?i)deploy"
+//	    delay: 500ms          # before the response starts
+//	    stream:
+//	      - text: "Looking into {{query}}"
+//	        delay: 200ms      # before this frame
+//	      - raw: "data: {not json\n\n"
+//	      - event: error
+//	        error: "model overloaded"
+//	      - close: true       # drop the connection, no stream_end
+//	    response: "Used for non-streaming requests"
+//
+// The first scenario whose match (and optional channel) fits a request
+// answers it; requests matching none get the built-in responses. Streams
+// without a close frame end with stream_end unless they send one
+// themselves. "{{query}}" in text, error, body and response is replaced by
+// the question.
+type MockScenario struct {
+	Name     string            `yaml:"name"`
+	Match    string            `yaml:"match"`
+	Channel  string            `yaml:"channel"`
+	Times    int               `yaml:"times"`
+	Status   int               `yaml:"status"`
+	Headers  map[string]string `yaml:"headers"`
+	Delay    time.Duration     `yaml:"delay"`
+	Body     string            `yaml:"body"`
+	Response string            `yaml:"response"`
+	Stream   []MockFrame       `yaml:"stream"`
+
+	pattern *regexp.Regexp
+}
+
+// MockFrame is one server-sent event of a scripted stream.
+type MockFrame struct {
+	Event  string        `yaml:"event"`
+	Text   string        `yaml:"text"`
+	Status string        `yaml:"status"`
+	Error  string        `yaml:"error"`
+	Raw    string        `yaml:"raw"`
+	Delay  time.Duration `yaml:"delay"`
+	Close  bool          `yaml:"close"`
+}
+
+type mockScenarioSet struct {
+	mu        sync.Mutex
+	scenarios []MockScenario
+	used      []int
+}
+
+var mockScenarios = &mockScenarioSet{}
+
+func loadMockScenarios(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return mockScenarios.parse(data)
+}
+
+func (s *mockScenarioSet) parse(data []byte) error {
+	var file struct {
+		Scenarios []MockScenario `yaml:"scenarios"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	for i := range file.Scenarios {
+		sc := &file.Scenarios[i]
+		if sc.Name == "" {
+			sc.Name = fmt.Sprintf("scenario %d", i+1)
+		}
+		pattern, err := regexp.Compile(sc.Match)
+		if err != nil {
+			return fmt.Errorf("%s: invalid match: %w", sc.Name, err)
+		}
+		sc.pattern = pattern
+		if sc.Status != 0 && (sc.Status < 100 || sc.Status > 599) {
+			return fmt.Errorf("%s: invalid status %d", sc.Name, sc.Status)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scenarios, s.used = file.Scenarios, make([]int, len(file.Scenarios))
+	return nil
+}
+
+// find returns the scenario for req, counting it against its times.
+func (s *mockScenarioSet) find(req backend.ChatRequest) (MockScenario, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sc := range s.scenarios {
+		if !sc.pattern.MatchString(req.Query) || (sc.Channel != "" && sc.Channel != req.ChannelID) {
+			continue
+		}
+		if sc.Times > 0 && s.used[i] >= sc.Times {
+			continue
+		}
+		s.used[i]++
+		return sc, true
+	}
+	return MockScenario{}, false
+}
+
+// serve writes sc's response to req.
+func (sc MockScenario) serve(w http.ResponseWriter, r *http.Request, req backend.ChatRequest) {
+	fill := func(s string) string { return strings.ReplaceAll(s, "{{query}}", req.Query) }
+	for k, v := range sc.Headers {
+		w.Header().Set(k, v)
+	}
+	time.Sleep(sc.Delay)
+	if sc.Status >= 300 {
+		http.Error(w, fill(sc.Body), sc.Status)
+		return
+	}
+	if r.Header.Get("Accept") != "text/event-stream" || len(sc.Stream) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		if sc.Status != 0 {
+			w.WriteHeader(sc.Status)
+		}
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: fill(sc.Response)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	if sc.Status != 0 {
+		w.WriteHeader(sc.Status)
+	}
+	flusher, _ := w.(http.Flusher)
+	ended := false
+	for i, f := range sc.Stream {
+		time.Sleep(f.Delay)
+		if f.Close {
+			// Aborting the handler drops the connection mid-stream.
+			panic(http.ErrAbortHandler)
+		}
+		if f.Raw != "" {
+			fmt.Fprint(w, f.Raw)
+			ended = ended || strings.Contains(f.Raw, "event: stream_end")
+		} else {
+			resp := backend.ChatResponse{ID: i + 1, Event: f.Event, Text: fill(f.Text), Status: f.Status, Error: fill(f.Error)}
+			if resp.Event == "" {
+				resp.Event = "message_part"
+			}
+			ended = ended || resp.Event == "stream_end"
+			data, _ := json.Marshal(resp)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", resp.ID, resp.Event, data)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if !ended {
+		fmt.Fprintf(w, "id: %d\nevent: stream_end\ndata: {\"event\":\"stream_end\",\"status\":\"done\"}\n\n", len(sc.Stream)+1)
+	}
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+)
+
+func askMock(t *testing.T, url, query, accept string) (*http.Response, string, error) {
+	t.Helper()
+	body, _ := json.Marshal(backend.ChatRequest{Query: query, ChannelID: "C1"})
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(string(body)))
+	req.Header.Set("Accept", accept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp, string(data), err
+}
+
+func TestMockScenarios_ExampleFile(t *testing.T) {
+	defer func(s *mockScenarioSet) { mockScenarios = s }(mockScenarios)
+	mockScenarios = &mockScenarioSet{}
+	if err := loadMockScenarios("../../examples/mock-scenarios.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if len(mockScenarios.scenarios) != 5 {
+		t.Errorf("loaded %d scenarios", len(mockScenarios.scenarios))
+	}
+	if err := mockScenarios.parse([]byte("scenarios:\n  - match: \"(\"\n")); err == nil {
+		t.Error("invalid match accepted")
+	}
+}
+
+func TestMockScenario_ScriptedStream(t *testing.T) {
+	defer func(s *mockScenarioSet) { mockScenarios = s }(mockScenarios)
+	mockScenarios = &mockScenarioSet{}
+	if err := mockScenarios.parse([]byte(`
+scenarios:
+  - name: garbled
+    match: "(?i)garbled"
+    stream:
+      - text: "About {{query}}"
+      - raw: "data: {not json\n\n"
+      - event: error
+        error: "model crashed"
+    response: "plain {{query}}"
+`)); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(mockBackendHandler))
+	defer server.Close()
+	_, body, err := askMock(t, server.URL, "garbled please", "text/event-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"text_chunk":"About garbled please"`, "data: {not json\n\n", "event: error", `"error":"model crashed"`, "event: stream_end"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream is missing %q:\n%s", want, body)
+		}
+	}
+	_, body, _ = askMock(t, server.URL, "garbled please", "application/json")
+	if !strings.Contains(body, `"full_response":"plain garbled please"`) {
+		t.Errorf("non-streaming body %s", body)
+	}
+	_, body, _ = askMock(t, server.URL, "something else", "application/json")
+	if !strings.Contains(body, "Goroutines enable concurrency") {
+		t.Errorf("unmatched query did not get the built-in answer: %s", body)
+	}
+}
+
+func TestMockScenario_TimesAndErrors(t *testing.T) {
+	defer func(s *mockScenarioSet) { mockScenarios = s }(mockScenarios)
+	mockScenarios = &mockScenarioSet{}
+	if err := mockScenarios.parse([]byte(`
+scenarios:
+  - match: retry
+    times: 1
+    status: 503
+    body: overloaded
+  - match: retry
+    response: recovered
+  - match: hang up
+    stream:
+      - text: partial
+      - close: true
+`)); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(mockBackendHandler))
+	defer server.Close()
+	resp, body, _ := askMock(t, server.URL, "retry", "application/json")
+	if resp.StatusCode != http.StatusServiceUnavailable || strings.TrimSpace(body) != "overloaded" {
+		t.Errorf("first attempt: %d %q", resp.StatusCode, body)
+	}
+	resp, body, _ = askMock(t, server.URL, "retry", "application/json")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "recovered") {
+		t.Errorf("second attempt: %d %q", resp.StatusCode, body)
+	}
+	if _, body, err := askMock(t, server.URL, "hang up", "text/event-stream"); err == nil || strings.Contains(body, "stream_end") {
+		t.Errorf("connection not dropped: %v, %q", err, body)
+	}
+}
@@ -0,0 +1,204 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Model Overrides
+//
+// BACKEND_MODELS lists the models users can pick with the "Ask with…"
+// message shortcut, as label=model pairs. A model served by a different
+// backend is written label=model@url. The chosen model answers that one
+// message in its thread; the model name is sent to the backend as "model"
+// and recorded on the conversation and in the "model_overrides" map on
+// /debug/vars.
+const (
+	CallbackAskWith      = "ask_with"
+	CallbackAskWithModal = "ask_with_model"
+
+	askWithBlockID  = "ask_with_model"
+	askWithActionID = "model"
+)
+
+type ModelOption struct {
+	Label string `json:"label"`
+	Model string `json:"model"`
+	URL   string `json:"url,omitempty"`
+}
+
+var (
+	backendModels        []ModelOption
+	modelOverrideMetrics = expvar.NewMap("model_overrides")
+)
+
+func parseBackendModels(spec string) ([]ModelOption, error) {
+	var models []ModelOption
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		label, model, found := strings.Cut(part, "=")
+		if !found || strings.TrimSpace(label) == "" || strings.TrimSpace(model) == "" {
+			return nil, fmt.Errorf("invalid model %q (use label=model or label=model@url)", part)
+		}
+		opt := ModelOption{Label: strings.TrimSpace(label), Model: strings.TrimSpace(model)}
+		if m, rawURL, found := strings.Cut(opt.Model, "@"); found {
+			u, err := url.Parse(rawURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid backend URL for model %s: %q", opt.Label, rawURL)
+			}
+			opt.Model, opt.URL = m, rawURL
+		}
+		if seen[opt.Label] {
+			return nil, fmt.Errorf("duplicate model label %q", opt.Label)
+		}
+		seen[opt.Label] = true
+		models = append(models, opt)
+	}
+	return models, nil
+}
+
+func findModel(label string) (ModelOption, bool) {
+	for _, m := range backendModels {
+		if m.Label == label {
+			return m, true
+		}
+	}
+	return ModelOption{}, false
+}
+
+type modelKey struct{}
+
+func withModel(ctx context.Context, m ModelOption) context.Context {
+	return context.WithValue(ctx, modelKey{}, m)
+}
+
+func modelFrom(ctx context.Context) (ModelOption, bool) {
+	m, ok := ctx.Value(modelKey{}).(ModelOption)
+	return m, ok
+}
+
+// backendURLFor returns the backend that should serve ctx's request.
+func backendURLFor(ctx context.Context) string {
+	if m, ok := modelFrom(ctx); ok && m.URL != "" {
+		return m.URL
+	}
+	if r := backendRegions.pick(ctx); r != nil {
+		return r.URL
+	}
+	return config.BackendURL
+}
+
+// pendingAsk is a message waiting for the user to pick a model.
+type pendingAsk struct {
+	Channel   string
+	ThreadTS  string
+	MessageTS string
+	Text      string
+}
+
+var (
+	pendingAsksMu sync.Mutex
+	pendingAsks   = map[string]pendingAsk{}
+)
+
+func askWithModal(id string) slack.ModalViewRequest {
+	options := make([]*slack.OptionBlockObject, 0, len(backendModels))
+	for _, m := range backendModels {
+		options = append(options, slack.NewOptionBlockObject(m.Label, slack.NewTextBlockObject(slack.PlainTextType, m.Label, false, false), nil))
+	}
+	selectModel := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, slack.NewTextBlockObject(slack.PlainTextType, "Choose a model", false, false), askWithActionID, options...)
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      CallbackAskWithModal,
+		PrivateMetadata: id,
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, "Ask with…", false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Ask", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		NotifyOnClose:   true,
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			slack.NewInputBlock(askWithBlockID, slack.NewTextBlockObject(slack.PlainTextType, "Model", false, false), nil, selectModel),
+		}},
+	}
+}
+
+// handleAskWithShortcut opens the model picker for the message the shortcut
+// was used on.
+func handleAskWithShortcut(ctx context.Context, api SlackClient, callback slack.InteractionCallback) {
+	channel, user := callback.Channel.ID, callback.User.ID
+	if len(backendModels) == 0 {
+		notifyUser(ctx, api, channel, user, "No models are configured for \"Ask with…\".")
+		return
+	}
+	text := strings.TrimSpace(callback.Message.Text)
+	if text == "" {
+		notifyUser(ctx, api, channel, user, "That message has no text to ask about.")
+		return
+	}
+	threadTS := callback.Message.ThreadTimestamp
+	if threadTS == "" {
+		threadTS = callback.Message.Timestamp
+	}
+	id := newID()
+	pendingAsksMu.Lock()
+	pendingAsks[id] = pendingAsk{Channel: channel, ThreadTS: threadTS, MessageTS: callback.Message.Timestamp, Text: text}
+	pendingAsksMu.Unlock()
+
+	if _, err := api.OpenViewContext(ctx, callback.TriggerID, askWithModal(id)); err != nil {
+		slog.ErrorContext(ctx, "Failed to open the model picker", "err", err)
+		takePendingAsk(id)
+	}
+}
+
+func takePendingAsk(id string) (pendingAsk, bool) {
+	pendingAsksMu.Lock()
+	defer pendingAsksMu.Unlock()
+	ask, ok := pendingAsks[id]
+	delete(pendingAsks, id)
+	return ask, ok
+}
+
+// handleAskWithSubmission answers the pending message with the chosen model.
+func handleAskWithSubmission(ctx context.Context, api SlackClient, callback slack.InteractionCallback) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "ask_with_model")
+	defer span.End()
+
+	ask, ok := takePendingAsk(callback.View.PrivateMetadata)
+	if !ok {
+		return
+	}
+	label := callback.View.State.Values[askWithBlockID][askWithActionID].SelectedOption.Value
+	model, ok := findModel(label)
+	if !ok {
+		notifyUser(ctx, api, ask.Channel, callback.User.ID, fmt.Sprintf("The model %q is no longer available.", label))
+		return
+	}
+	span.SetAttributes(
+		attribute.String("user.id", callback.User.ID),
+		attribute.String("channel.id", ask.Channel),
+		attribute.String("model.override", model.Label),
+	)
+	modelOverrideMetrics.Add(model.Label, 1)
+	slog.InfoContext(ctx, "Answering with picked model", "channel", ask.Channel, "ts", ask.MessageTS, "model", model.Label, "user", callback.User.ID)
+
+	ev := slackevents.AppMentionEvent{User: callback.User.ID, Channel: ask.Channel, ThreadTimeStamp: ask.ThreadTS, TimeStamp: ask.MessageTS, Text: ask.Text}
+	ctx = withRequestID(withModel(ctx, model))
+	requests.record(ctx, "queued", fmt.Sprintf("model %s", model.Label))
+	emitWebhook(ctx, EventQueryReceived, ev.Channel, ev.User, ev.ThreadTimeStamp, map[string]any{"source": "ask_with", "query": ask.Text, "model": model.Label})
+	submitOrdered(ctx, api, workerPool, ask.Channel, ask.ThreadTS, ev.User, func() {
+		processTask(ctx, api, ev, ask.Text, slack.MsgOptionTS(ask.ThreadTS))
+	})
+}
@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+)
+
+func TestParseBackendModels(t *testing.T) {
+	models, err := parseBackendModels("fast=llama-8b, deep=llama-70b@http://gpu2:8080/v1/chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 || models[0] != (ModelOption{Label: "fast", Model: "llama-8b"}) ||
+		models[1] != (ModelOption{Label: "deep", Model: "llama-70b", URL: "http://gpu2:8080/v1/chat"}) {
+		t.Errorf("unexpected models %+v", models)
+	}
+	for _, bad := range []string{"fast", "=x", "fast=a,fast=b", "deep=m@gpu2:8080"} {
+		if _, err := parseBackendModels(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestAskWithShortcut_AnswersWithChosenModel(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+
+	var gotModel string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		gotModel = req.Model
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"event\":\"message_part\",\"text_chunk\":\"From the big model\"}\n\n")
+	}))
+	defer ts.Close()
+	config.BackendURL = "http://unused.invalid"
+
+	defer func(m []ModelOption, p *workerpool.Pool) { backendModels, workerPool = m, p }(backendModels, workerPool)
+	backendModels = []ModelOption{{Label: "deep", Model: "llama-70b", URL: ts.URL}}
+	workerPool = workerpool.New(1)
+
+	api := &fakeSlackClient{}
+	shortcut := slack.InteractionCallback{Type: slack.InteractionTypeMessageAction, CallbackID: CallbackAskWith, TriggerID: "trigger"}
+	shortcut.Channel.ID = "CASK"
+	shortcut.User.ID = "U1"
+	shortcut.Message.Text = "why is the build slow?"
+	shortcut.Message.Timestamp = "200.000100"
+	handleInteraction(context.Background(), api, shortcut)
+	if len(api.views) != 1 {
+		t.Fatalf("expected the model picker to open, got %d views", len(api.views))
+	}
+
+	submit := slack.InteractionCallback{Type: slack.InteractionTypeViewSubmission}
+	submit.User.ID = "U1"
+	submit.View.CallbackID = CallbackAskWithModal
+	submit.View.PrivateMetadata = api.views[0].PrivateMetadata
+	submit.View.State = &slack.ViewState{Values: map[string]map[string]slack.BlockAction{
+		askWithBlockID: {askWithActionID: {SelectedOption: slack.OptionBlockObject{Value: "deep"}}},
+	}}
+	handleInteraction(context.Background(), api, submit)
+	workerPool.Shutdown()
+
+	if gotModel != "llama-70b" {
+		t.Errorf("backend got model %q", gotModel)
+	}
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Text() != "From the big model" || posts[0].Values.Get("thread_ts") != "200.000100" {
+		t.Fatalf("unexpected posts %+v", posts)
+	}
+	rec, ok := conversations.LatestInThread("CASK", "200.000100")
+	if !ok || rec.Model != "llama-70b" {
+		t.Errorf("override not recorded: %+v", rec)
+	}
+	if _, ok := takePendingAsk(submit.View.PrivateMetadata); ok {
+		t.Error("pending ask not cleared")
+	}
+}
@@ -0,0 +1,401 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Answer Outbox
+//
+// An answer whose Slack post fails for a transient reason (network error,
+// timeout, rate limit, Slack 5xx) is kept in the outbox and retried in the
+// background with exponential backoff instead of being lost. After
+// OUTBOX_MAX_ATTEMPTS failures it becomes a dead letter that admins can
+// inspect and requeue through /admin/outbox. With OUTBOX_FILE set the outbox
+// survives restarts. A post that timed out may still have reached Slack, so
+// before retrying it the conversation is checked for the same text.
+const (
+	defaultOutboxMaxAttempts = 8
+	outboxBaseBackoff        = 5 * time.Second
+	outboxMaxBackoff         = 10 * time.Minute
+	outboxPollInterval       = time.Second
+
+	OutboxPending = "pending"
+	OutboxDead    = "dead"
+)
+
+type OutboxEntry struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id,omitempty"`
+	Channel        string    `json:"channel"`
+	User           string    `json:"user,omitempty"`
+	Text           string    `json:"text"`
+	ThreadTS       string    `json:"thread_ts,omitempty"`
+	Broadcast      bool      `json:"broadcast,omitempty"`
+	Blocks         string    `json:"blocks,omitempty"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	Uncertain      bool      `json:"uncertain,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+	Created        time.Time `json:"created"`
+	NextAttempt    time.Time `json:"next_attempt"`
+}
+
+type answerOutbox struct {
+	mu          sync.Mutex
+	path        string
+	maxAttempts int
+	entries     map[string]*OutboxEntry
+}
+
+func newAnswerOutbox(maxAttempts int) *answerOutbox {
+	return &answerOutbox{maxAttempts: maxAttempts, entries: make(map[string]*OutboxEntry)}
+}
+
+var outbox = newAnswerOutbox(defaultOutboxMaxAttempts)
+
+type conversationIDKey struct{}
+
+// withConversationID marks posts made under ctx as part of a conversation so
+// a late delivery can be added to its record.
+func withConversationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, conversationIDKey{}, id)
+}
+
+func conversationIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(conversationIDKey{}).(string)
+	return id
+}
+
+// retryablePostError reports whether a failed post is worth retrying, and
+// whether Slack may have received it anyway.
+func retryablePostError(err error) (retry, uncertain bool) {
+	var rateLimited *slack.RateLimitedError
+	var status slack.StatusCodeError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false, false
+	case errors.As(err, &rateLimited):
+		return true, false
+	case errors.As(err, &status):
+		return status.Code >= 500, status.Code >= 500
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return true, true
+	case errors.As(err, &netErr):
+		return true, false
+	}
+	return false, false
+}
+
+// load reads a persisted outbox; a missing file is an empty outbox.
+func (o *answerOutbox) load(path string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*OutboxEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		o.entries[e.ID] = e
+	}
+	return nil
+}
+
+// saveLocked writes the outbox atomically; callers hold o.mu.
+func (o *answerOutbox) saveLocked() {
+	if o.path == "" {
+		return
+	}
+	data, err := json.Marshal(o.listLocked())
+	if err == nil {
+		tmp := o.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, o.path)
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(context.Background(), "Failed to save outbox", "err", err)
+	}
+}
+
+func (o *answerOutbox) listLocked() []OutboxEntry {
+	out := make([]OutboxEntry, 0, len(o.entries))
+	for _, e := range o.entries {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+func (o *answerOutbox) list() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.listLocked()
+}
+
+func (o *answerOutbox) add(e *OutboxEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entries[e.ID] = e
+	o.saveLocked()
+	metricOutboxQueued.Add(1)
+}
+
+// due returns pending entries whose next attempt has come.
+func (o *answerOutbox) due(now time.Time) []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var out []OutboxEntry
+	for _, e := range o.entries {
+		if e.Status == OutboxPending && !e.NextAttempt.After(now) {
+			out = append(out, *e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+func (o *answerOutbox) delivered(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.entries, id)
+	o.saveLocked()
+	metricOutboxDelivered.Add(1)
+}
+
+// failed records a failed attempt and schedules the next one, or moves the
+// entry to the dead letters once it is out of attempts or not retryable.
+func (o *answerOutbox) failed(id string, err error, now time.Time) (dead bool) {
+	retry, uncertain := retryablePostError(err)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e, ok := o.entries[id]
+	if !ok {
+		return false
+	}
+	e.Attempts++
+	e.LastError = err.Error()
+	e.Uncertain = uncertain
+	if !retry || e.Attempts >= o.maxAttempts {
+		e.Status = OutboxDead
+		metricOutboxDead.Add(1)
+	} else {
+		e.NextAttempt = now.Add(outboxBackoff(e.Attempts))
+	}
+	o.saveLocked()
+	return e.Status == OutboxDead
+}
+
+// forget drops every entry of a conversation, delivered or not.
+func (o *answerOutbox) forget(conversationID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for id, e := range o.entries {
+		if e.ConversationID == conversationID {
+			delete(o.entries, id)
+		}
+	}
+	o.saveLocked()
+}
+
+// requeue gives a dead letter a fresh set of attempts.
+func (o *answerOutbox) requeue(id string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e, ok := o.entries[id]
+	if !ok || e.Status != OutboxDead {
+		return false
+	}
+	e.Status, e.Attempts, e.NextAttempt = OutboxPending, 0, time.Now()
+	o.saveLocked()
+	return true
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	d := outboxBaseBackoff << (attempts - 1)
+	if d <= 0 || d > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+	return d
+}
+
+// sendAnswerMessage posts an answer like sendMessage and, when the post fails
+// transiently, hands it to the outbox for background delivery.
+func sendAnswerMessage(ctx context.Context, api SlackClient, msg outgoingMessage, options ...slack.MsgOption) (string, error) {
+	ts, err := sendMessage(ctx, api, msg, options...)
+	if err == nil {
+		requests.record(ctx, "slack_post", "ts "+ts)
+		return ts, nil
+	}
+	requests.recordError(ctx, fmt.Sprintf("Slack post failed: %v", err))
+	retry, uncertain := retryablePostError(err)
+	if !retry || isPrivateDM(ctx) {
+		// Private DM answers are never written to the outbox.
+		return ts, err
+	}
+	endpoint, values, _ := slack.UnsafeApplyMsgOptions("", msg.Channel, "", filterBlockOptions(ctx, msg, options)...)
+	if endpoint != "chat.postMessage" {
+		// Ephemeral and other special posts cannot be replayed faithfully.
+		return ts, err
+	}
+	applyOutgoingFilters(ctx, &msg)
+	now := time.Now()
+	e := &OutboxEntry{
+		ID:             newID(),
+		ConversationID: conversationIDFrom(ctx),
+		Channel:        msg.Channel,
+		User:           msg.User,
+		Text:           msg.Text,
+		ThreadTS:       values.Get("thread_ts"),
+		Broadcast:      values.Get("reply_broadcast") == "true",
+		Blocks:         values.Get("blocks"),
+		Status:         OutboxPending,
+		Attempts:       1,
+		Uncertain:      uncertain,
+		LastError:      err.Error(),
+		Created:        now,
+		NextAttempt:    now.Add(outboxBackoff(1)),
+	}
+	outbox.add(e)
+	slog.WarnContext(ctx, "Answer post failed; queued in the outbox", "channel", msg.Channel, "entry", e.ID, "err", err)
+	return ts, err
+}
+
+func (e OutboxEntry) options() []slack.MsgOption {
+	opts := []slack.MsgOption{slack.MsgOptionText(e.Text, false)}
+	if e.ThreadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(e.ThreadTS))
+	}
+	if e.Broadcast {
+		opts = append(opts, slack.MsgOptionBroadcast())
+	}
+	var raw []json.RawMessage
+	if json.Unmarshal([]byte(e.Blocks), &raw) == nil && len(raw) > 0 {
+		blocks := make([]slack.Block, len(raw))
+		for i, b := range raw {
+			blocks[i] = rawBlock{raw: b}
+		}
+		opts = append(opts, slack.MsgOptionBlocks(blocks...))
+	}
+	return opts
+}
+
+// findPostedCopy looks for an earlier attempt that reached Slack despite
+// failing on our side.
+func findPostedCopy(ctx context.Context, e OutboxEntry) (string, bool) {
+	if slackReader == nil {
+		return "", false
+	}
+	var msgs []slack.Message
+	var err error
+	if e.ThreadTS != "" {
+		msgs, err = slackReader.Replies(ctx, e.Channel, e.ThreadTS, 0)
+	} else {
+		msgs, err = slackReader.History(ctx, e.Channel, e.Created.Add(-time.Minute), 0)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check channel for outbox entry", "channel", e.Channel, "entry", e.ID, "err", err)
+		return "", false
+	}
+	for _, m := range msgs {
+		if m.BotID != "" && m.Text == e.Text {
+			return m.Timestamp, true
+		}
+	}
+	return "", false
+}
+
+func (o *answerOutbox) deliver(ctx context.Context, api SlackClient, e OutboxEntry) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "outbox_delivery")
+	defer span.End()
+	span.SetAttributes(attribute.String("outbox.id", e.ID), attribute.Int("outbox.attempts", e.Attempts))
+
+	ts, found := "", false
+	if e.Uncertain {
+		ts, found = findPostedCopy(ctx, e)
+	}
+	if !found {
+		var err error
+		// Text was filtered when the entry was queued; post it unchanged.
+		_, ts, err = api.PostMessageContext(ctx, e.Channel, e.options()...)
+		if err != nil {
+			span.RecordError(err)
+			if o.failed(e.ID, err, time.Now()) {
+				slog.ErrorContext(ctx, "Failed to deliver outbox entry", "entry", e.ID, "channel", e.Channel, "attempts", e.Attempts+1, "err", err)
+			}
+			return
+		}
+	}
+	o.delivered(e.ID)
+	if e.ConversationID != "" {
+		if conversations.AddMessage(e.ConversationID, ts) {
+			if rec, ok := conversations.Get(e.ConversationID); ok && len(rec.MessageTS) == 1 {
+				searchIndex.add(ctx, &rec)
+			}
+		}
+	}
+	slog.InfoContext(ctx, "Delivered outbox entry", "entry", e.ID, "channel", e.Channel)
+}
+
+// run retries due entries until ctx is done.
+func (o *answerOutbox) run(ctx context.Context, api SlackClient) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, e := range o.due(now) {
+				o.deliver(ctx, api, e)
+			}
+		}
+	}
+}
+
+// adminOutboxHandler lists outbox entries (GET, ?status=dead for dead
+// letters) or requeues a dead letter (POST ?id=).
+func adminOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		status := r.URL.Query().Get("status")
+		out := []OutboxEntry{}
+		for _, e := range outbox.list() {
+			if status == "" || e.Status == status {
+				out = append(out, e)
+			}
+		}
+		json.NewEncoder(w).Encode(out)
+	case http.MethodPost:
+		if !outbox.requeue(r.URL.Query().Get("id")) {
+			http.Error(w, "dead letter not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/slackfetch"
+	"github.com/slack-go/slack"
+)
+
+// flakySlackClient fails the first failures posts with err.
+type flakySlackClient struct {
+	*fakeSlackClient
+	failures int
+	err      error
+}
+
+func (f *flakySlackClient) PostMessageContext(ctx context.Context, channel string, options ...slack.MsgOption) (string, string, error) {
+	if f.failures > 0 {
+		f.failures--
+		return "", "", f.err
+	}
+	return f.fakeSlackClient.PostMessageContext(ctx, channel, options...)
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryablePostError(t *testing.T) {
+	for _, tc := range []struct {
+		err              error
+		retry, uncertain bool
+	}{
+		{&slack.RateLimitedError{RetryAfter: time.Second}, true, false},
+		{slack.StatusCodeError{Code: 502}, true, true},
+		{slack.StatusCodeError{Code: 404}, false, false},
+		{fmt.Errorf("post: %w", context.DeadlineExceeded), true, true},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, true, true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true, false},
+		{context.Canceled, false, false},
+		{slack.SlackErrorResponse{Err: "channel_not_found"}, false, false},
+	} {
+		retry, uncertain := retryablePostError(tc.err)
+		if retry != tc.retry || uncertain != tc.uncertain {
+			t.Errorf("%v: got (%v, %v), want (%v, %v)", tc.err, retry, uncertain, tc.retry, tc.uncertain)
+		}
+	}
+}
+
+func TestSendAnswerMessage_QueuesAndDelivers(t *testing.T) {
+	defer func(o *answerOutbox) { outbox = o }(outbox)
+	outbox = newAnswerOutbox(3)
+	o := outbox
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	if err := o.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	conversations.Save(&ConversationRecord{ID: "conv-outbox", Channel: "COUT"})
+	api := &flakySlackClient{fakeSlackClient: &fakeSlackClient{}, failures: 1, err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	ctx := withConversationID(context.Background(), "conv-outbox")
+	if _, err := sendAnswerMessage(ctx, api, outgoingMessage{Channel: "COUT", User: "U1", Text: "the answer"}, slack.MsgOptionTS("5.0")); err == nil {
+		t.Fatal("expected the first post to fail")
+	}
+
+	// The entry survives a restart.
+	reloaded := newAnswerOutbox(3)
+	if err := reloaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	entries := reloaded.list()
+	if len(entries) != 1 || entries[0].Text != "the answer" || entries[0].ThreadTS != "5.0" || entries[0].ConversationID != "conv-outbox" {
+		t.Fatalf("unexpected persisted outbox: %+v", entries)
+	}
+	if due := reloaded.due(time.Now()); len(due) != 0 {
+		t.Errorf("entry should wait for its backoff, got %d due", len(due))
+	}
+
+	reloaded.deliver(context.Background(), api, entries[0])
+	if len(reloaded.list()) != 0 {
+		t.Errorf("delivered entry still queued")
+	}
+	if len(api.posts) != 1 || api.posts[0].Values.Get("thread_ts") != "5.0" || api.posts[0].Text() != "the answer" {
+		t.Errorf("unexpected delivery: %+v", api.posts)
+	}
+	if rec, ok := conversations.Get("conv-outbox"); !ok || len(rec.MessageTS) != 1 {
+		t.Errorf("late delivery not linked to conversation: %+v", rec)
+	}
+}
+
+func TestOutbox_DeadLettersAndRequeue(t *testing.T) {
+	defer func(o *answerOutbox) { outbox = o }(outbox)
+	outbox = newAnswerOutbox(3)
+	o := outbox
+	api := &flakySlackClient{fakeSlackClient: &fakeSlackClient{}, failures: 10, err: slack.StatusCodeError{Code: 503}}
+	sendAnswerMessage(context.Background(), api, outgoingMessage{Channel: "CDLQ", Text: "lost"})
+
+	for i := 0; i < 5; i++ {
+		for _, e := range o.due(time.Now().Add(time.Hour)) {
+			o.deliver(context.Background(), api, e)
+		}
+	}
+	entries := o.list()
+	if len(entries) != 1 || entries[0].Status != OutboxDead || entries[0].Attempts != 3 {
+		t.Fatalf("expected a dead letter after 3 attempts, got %+v", entries)
+	}
+
+	// Ephemeral posts are never queued.
+	sendAnswerMessage(context.Background(), api, outgoingMessage{Channel: "CDLQ", Text: "private"}, slack.MsgOptionPostEphemeral("U1"))
+	if len(o.list()) != 1 {
+		t.Errorf("ephemeral post was queued")
+	}
+
+	if !o.requeue(entries[0].ID) {
+		t.Fatal("requeue failed")
+	}
+	api.failures = 0
+	for _, e := range o.due(time.Now()) {
+		o.deliver(context.Background(), api, e)
+	}
+	if len(o.list()) != 0 || len(api.posts) != 1 {
+		t.Errorf("requeued entry not delivered: %+v", o.list())
+	}
+}
+
+// historyAPI serves a fixed channel history to slackfetch.
+type historyAPI struct {
+	slackfetch.API
+	messages []slack.Message
+}
+
+func (h historyAPI) GetConversationHistoryContext(ctx context.Context, params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error) {
+	return &slack.GetConversationHistoryResponse{Messages: h.messages}, nil
+}
+
+func TestOutbox_UncertainPostNotDuplicated(t *testing.T) {
+	defer func(o *answerOutbox) { outbox = o }(outbox)
+	outbox = newAnswerOutbox(3)
+	o := outbox
+	defer func(r *slackfetch.Fetcher) { slackReader = r }(slackReader)
+	posted := slack.Message{}
+	posted.BotID, posted.Text, posted.Timestamp = "B1", "maybe sent", "7.000100"
+	slackReader = slackfetch.New(historyAPI{messages: []slack.Message{posted}}, slackfetch.Options{CacheTTL: -1})
+
+	api := &flakySlackClient{fakeSlackClient: &fakeSlackClient{}, failures: 1, err: context.DeadlineExceeded}
+	sendAnswerMessage(context.Background(), api, outgoingMessage{Channel: "CUNC", Text: "maybe sent"})
+	entries := o.list()
+	if len(entries) != 1 || !entries[0].Uncertain {
+		t.Fatalf("expected an uncertain entry, got %+v", entries)
+	}
+
+	o.deliver(context.Background(), api, entries[0])
+	if len(api.posts) != 0 {
+		t.Errorf("answer that reached Slack was posted again")
+	}
+	if len(o.list()) != 0 {
+		t.Errorf("entry should be resolved")
+	}
+}
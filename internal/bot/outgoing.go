@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Outgoing Messages
+//
+// All bot-authored text goes through sendMessage so that outgoing filters
+// apply to answers, notices and anything else the bot posts, including the
+// text in its blocks (see filterBlocks).
+type outgoingMessage struct {
+	Channel string
+	User    string
+	Text    string
+}
+
+type outgoingFilter func(ctx context.Context, msg *outgoingMessage)
+
+var outgoingFilters []outgoingFilter
+
+func applyOutgoingFilters(ctx context.Context, msg *outgoingMessage) {
+	for _, filter := range outgoingFilters {
+		filter(ctx, msg)
+	}
+}
+
+func filterText(ctx context.Context, channel, user, text string) string {
+	msg := outgoingMessage{Channel: channel, User: user, Text: text}
+	applyOutgoingFilters(ctx, &msg)
+	return msg.Text
+}
+
+// filterLines copies r to w through the outgoing filters a line at a time,
+// so text too long to hold in memory can be filtered on its way to a file.
+// A filter in reject mode replaces only the lines it matches.
+func filterLines(ctx context.Context, w io.Writer, r io.Reader, channel, user string) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			text, newline := strings.CutSuffix(line, "\n")
+			text = filterText(ctx, channel, user, text)
+			if newline {
+				text += "\n"
+			}
+			if _, err := io.WriteString(w, text); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sendMessage filters msg.Text and the text of any blocks and posts them;
+// extra options (blocks, thread, ephemeral) are applied after the text so
+// blocks keep it as a fallback.
+func sendMessage(ctx context.Context, api SlackClient, msg outgoingMessage, options ...slack.MsgOption) (string, error) {
+	options = filterBlockOptions(ctx, msg, options)
+	applyOutgoingFilters(ctx, &msg)
+	opts := append([]slack.MsgOption{slack.MsgOptionText(msg.Text, false)}, options...)
+	_, ts, err := api.PostMessageContext(ctx, msg.Channel, opts...)
+	return ts, err
+}
+
+// filterBlockOptions replaces each blocks option with its blocks run
+// through filterBlocks, and drops it when the filters reject every block.
+// Other options are kept as they are.
+func filterBlockOptions(ctx context.Context, msg outgoingMessage, options []slack.MsgOption) []slack.MsgOption {
+	if len(outgoingFilters) == 0 {
+		return options
+	}
+	out := make([]slack.MsgOption, 0, len(options))
+	for _, opt := range options {
+		_, values, err := slack.UnsafeApplyMsgOptions("", msg.Channel, "", opt)
+		if err != nil || values.Get("blocks") == "" {
+			out = append(out, opt)
+			continue
+		}
+		var raw []json.RawMessage
+		if err := json.Unmarshal([]byte(values.Get("blocks")), &raw); err != nil {
+			// Blocks that cannot be read cannot be filtered either.
+			continue
+		}
+		blocks := make([]slack.Block, 0, len(raw))
+		for _, item := range raw {
+			var head struct {
+				Type    string `json:"type"`
+				BlockID string `json:"block_id"`
+			}
+			json.Unmarshal(item, &head)
+			blocks = append(blocks, rawBlock{typ: head.Type, id: head.BlockID, raw: item})
+		}
+		if blocks = filterBlocks(ctx, msg.Channel, msg.User, blocks); len(blocks) > 0 {
+			out = append(out, slack.MsgOptionBlocks(blocks...))
+		}
+	}
+	return out
+}
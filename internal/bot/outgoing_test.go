@@ -0,0 +1,27 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestSendMessage_FiltersBlockText(t *testing.T) {
+	defer func() { outgoingFilters = nil }()
+	mask, _ := newBlocklistFilter([]string{"hunter2"}, FilterModeMask)
+	outgoingFilters = []outgoingFilter{mask.Apply}
+
+	r := &pendingReview{ID: "r1", Channel: "C1", User: "U1", Query: "is hunter2 the password?", Chunks: []string{"No."}}
+	api := &fakeSlackClient{}
+	sendMessage(context.Background(), api, outgoingMessage{Channel: "CREVIEW", User: "U1", Text: "Review requested: " + r.Query},
+		slack.MsgOptionBlocks(reviewBlocks(context.Background(), r)...), slack.MsgOptionTS("1.000100"))
+	p := api.sent()[0]
+	if blocks := p.Values.Get("blocks"); strings.Contains(blocks, "hunter2") || !strings.Contains(blocks, ActionReviewApprove) {
+		t.Errorf("block text was not filtered: %s", blocks)
+	}
+	if strings.Contains(p.Text(), "hunter2") || p.Values.Get("thread_ts") != "1.000100" {
+		t.Errorf("fallback or other options changed: %v", p.Values)
+	}
+}
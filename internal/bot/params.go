@@ -0,0 +1,174 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Generation Parameters
+//
+// Channels tune the backend's sampling with "generation" in the channel
+// config or "!params"; values are checked against the ranges the backend
+// advertises at /v1/capabilities, fetched at most every five minutes and
+// given five seconds to answer.
+var defaultParamRanges = map[string]backend.ParamRange{
+	"temperature": {Min: 0, Max: 2},
+	"max_tokens":  {Min: 1, Max: 32768},
+	"top_p":       {Min: 0, Max: 1},
+}
+
+const capabilitiesTTL = 5 * time.Minute
+
+var capabilitiesTimeout = 5 * time.Second
+
+var capabilities struct {
+	sync.Mutex
+	ranges  map[string]backend.ParamRange
+	fetched time.Time
+}
+
+func capabilitiesURL() string {
+	u, err := url.Parse(config.BackendURL)
+	if err != nil {
+		return ""
+	}
+	u.Path = backend.CapabilitiesPath
+	u.RawQuery = ""
+	return u.String()
+}
+
+// paramRanges returns the backend-advertised ranges, falling back to the
+// defaults for anything the backend does not report.
+func paramRanges(ctx context.Context) map[string]backend.ParamRange {
+	capabilities.Lock()
+	if capabilities.ranges != nil && time.Since(capabilities.fetched) < capabilitiesTTL {
+		defer capabilities.Unlock()
+		return capabilities.ranges
+	}
+	capabilities.Unlock()
+
+	// The fetch runs unlocked, so a slow backend holds up only this caller.
+	ranges := fetchParamRanges(ctx)
+	capabilities.Lock()
+	defer capabilities.Unlock()
+	capabilities.ranges = ranges
+	capabilities.fetched = time.Now()
+	return ranges
+}
+
+func fetchParamRanges(ctx context.Context) map[string]backend.ParamRange {
+	ranges := make(map[string]backend.ParamRange, len(defaultParamRanges))
+	for k, v := range defaultParamRanges {
+		ranges[k] = v
+	}
+	ctx, cancel := context.WithTimeout(ctx, capabilitiesTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", capabilitiesURL(), nil)
+	if err != nil {
+		return ranges
+	}
+	signBackendRequest(req, nil)
+	resp, err := backendClient.Do(req)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to fetch backend capabilities", "err", err)
+		return ranges
+	}
+	defer resp.Body.Close()
+	var caps backend.Capabilities
+	if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&caps) == nil {
+		for k, v := range caps.Parameters {
+			ranges[k] = v
+		}
+	}
+	return ranges
+}
+
+// parseGenerationParams parses "temperature=0.2 max_tokens=512" on top of
+// the current parameters.
+func parseGenerationParams(current backend.GenerationParams, args string) (backend.GenerationParams, error) {
+	g := current
+	for _, field := range strings.Fields(args) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return g, fmt.Errorf("expected key=value, got %q", field)
+		}
+		switch strings.ToLower(key) {
+		case "temperature":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return g, fmt.Errorf("invalid temperature %q", value)
+			}
+			g.Temperature = &v
+		case "max_tokens":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return g, fmt.Errorf("invalid max_tokens %q", value)
+			}
+			g.MaxTokens = &v
+		case "top_p":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return g, fmt.Errorf("invalid top_p %q", value)
+			}
+			g.TopP = &v
+		default:
+			return g, fmt.Errorf("unknown parameter %q", key)
+		}
+	}
+	return g, nil
+}
+
+func setChannelGeneration(channelID string, g backend.GenerationParams) {
+	channelMu.Lock()
+	defer channelMu.Unlock()
+	cc, ok := channelConfigs.Channels[channelID]
+	if !ok {
+		cc = channelConfigs.Default
+	}
+	cc.Generation = g
+	if channelConfigs.Channels == nil {
+		channelConfigs.Channels = make(map[string]ChannelConfig)
+	}
+	channelConfigs.Channels[channelID] = cc
+}
+
+func init() {
+	registerCommand("params", command{
+		Admin: true,
+		Usage: "[temperature=<n>] [max_tokens=<n>] [top_p=<n>] | reset",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			current := channelConfigFor(ev.Channel).Generation
+			switch args {
+			case "":
+				notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Generation parameters for <#%s>: %s", ev.Channel, current))
+				return
+			case "reset":
+				setChannelGeneration(ev.Channel, backend.GenerationParams{})
+				notifyUser(ctx, api, ev.Channel, ev.User, "Generation parameters reset to backend defaults.")
+				return
+			}
+			g, err := parseGenerationParams(current, args)
+			if err == nil {
+				err = g.Validate(paramRanges(ctx))
+			}
+			if err != nil {
+				notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Parameters not changed: %v", err))
+				return
+			}
+			setChannelGeneration(ev.Channel, g)
+			slog.InfoContext(ctx, "Generation parameters set", "channel", ev.Channel, "params", g, "user", ev.User)
+			notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Generation parameters for <#%s>: %s", ev.Channel, g))
+		},
+	})
+}
@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestParseGenerationParams(t *testing.T) {
+	g, err := parseGenerationParams(backend.GenerationParams{}, "temperature=0.2 max_tokens=256")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if *g.Temperature != 0.2 || *g.MaxTokens != 256 || g.TopP != nil {
+		t.Errorf("unexpected params %s", g)
+	}
+	if _, err := parseGenerationParams(g, "seed=1"); err == nil {
+		t.Error("expected error for unknown parameter")
+	}
+	if err := g.Validate(map[string]backend.ParamRange{"temperature": {Min: 0, Max: 0.1}}); err == nil {
+		t.Error("expected temperature outside range to fail validation")
+	}
+}
+
+func TestParamsCommand_ValidatesAgainstBackendRanges(t *testing.T) {
+	var got backend.ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == backend.CapabilitiesPath {
+			json.NewEncoder(w).Encode(backend.Capabilities{Parameters: map[string]backend.ParamRange{"temperature": {Min: 0, Max: 1}}})
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "ok"})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL + backend.Path
+	config.AdminUsers = []string{"UADMIN"}
+	capabilities.fetched = time.Time{}
+	defer func() {
+		config.AdminUsers = nil
+		setChannelSettings(channelSettings{})
+	}()
+
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{User: "UADMIN", Channel: "C1"}
+	dispatchCommand(context.Background(), api, ev, "!params temperature=1.5")
+	if channelConfigFor("C1").Generation.Temperature != nil {
+		t.Fatal("out-of-range temperature should be rejected")
+	}
+
+	dispatchCommand(context.Background(), api, ev, "!params temperature=0.5 top_p=0.9")
+	processTask(context.Background(), api, ev, "foo")
+	if got.Temperature == nil || *got.Temperature != 0.5 || got.TopP == nil || *got.TopP != 0.9 {
+		t.Errorf("expected channel params in backend request, got %+v", got)
+	}
+
+	ev.User = "UOTHER"
+	dispatchCommand(context.Background(), api, ev, "!params reset")
+	if channelConfigFor("C1").Generation.Temperature == nil {
+		t.Error("non-admin must not change parameters")
+	}
+	last := api.sent()[len(api.sent())-1]
+	if !strings.Contains(last.Text(), "restricted to admins") {
+		t.Errorf("expected admin restriction notice, got %q", last.Text())
+	}
+}
+
+func TestParamRanges_HangingBackendFallsBackToDefaults(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+	defer func(url string, timeout time.Duration) { config.BackendURL, capabilitiesTimeout = url, timeout }(config.BackendURL, capabilitiesTimeout)
+	config.BackendURL, capabilitiesTimeout = ts.URL, 20*time.Millisecond
+	capabilities.fetched = time.Time{}
+	defer func() { capabilities.fetched = time.Time{} }()
+
+	ranges := paramRanges(context.Background())
+	if ranges["temperature"] != defaultParamRanges["temperature"] {
+		t.Errorf("ranges = %v, want the defaults", ranges)
+	}
+	if !capabilities.TryLock() {
+		t.Fatal("capabilities lock still held after the fetch")
+	}
+	capabilities.Unlock()
+}
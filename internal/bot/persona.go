@@ -0,0 +1,462 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Personas
+//
+// A persona is a named system prompt with a version history, kept in
+// PERSONAS_FILE. Admins publish new versions with "!persona publish", which
+// makes them active at once, and "!persona rollback" points a persona back
+// at an earlier version without losing the later ones. Channels answer with
+// the "default" persona unless "!persona pin" pins them to another persona,
+// or to one version of it. The prompt is sent to the backend as "persona",
+// and the name and version that answered ("support@v3") are recorded on the
+// conversation, its span, the answer.completed webhook and the request
+// timeline, so a quality regression can be traced to the prompt behind it.
+// Publishes, rollbacks and pins are audited; answers are counted per
+// persona version under "personas" on /debug/vars.
+const defaultPersona = "default"
+
+var (
+	metricPersonas = expvar.NewMap("personas")
+
+	personaNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,39}$`)
+	channelRefPattern  = regexp.MustCompile(`^<#([A-Z0-9]+)(\|[^>]*)?>$`)
+)
+
+type PersonaVersion struct {
+	Version     int       `json:"version"`
+	Prompt      string    `json:"prompt"`
+	PublishedAt time.Time `json:"published_at"`
+	PublishedBy string    `json:"published_by,omitempty"`
+}
+
+type Persona struct {
+	Versions []PersonaVersion `json:"versions"`
+	// Active is the version answering for channels not pinned to one.
+	Active int `json:"active"`
+}
+
+// PersonaPin ties a channel to a persona; a zero Version follows the
+// persona's active version.
+type PersonaPin struct {
+	Persona string `json:"persona"`
+	Version int    `json:"version,omitempty"`
+}
+
+type personaState struct {
+	Personas map[string]*Persona   `json:"personas"`
+	Pins     map[string]PersonaPin `json:"pins,omitempty"`
+}
+
+type personaStore struct {
+	mu    sync.RWMutex
+	path  string
+	state personaState
+	now   func() time.Time
+}
+
+var personas = newPersonaStore()
+
+func newPersonaStore() *personaStore {
+	return &personaStore{state: personaState{Personas: map[string]*Persona{}, Pins: map[string]PersonaPin{}}, now: time.Now}
+}
+
+func (s *personaStore) load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state personaState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := validatePersonaState(&state); err != nil {
+		return err
+	}
+	s.state = state
+	return nil
+}
+
+// validatePersonaState checks state and fills in its empty maps.
+func validatePersonaState(state *personaState) error {
+	if state.Personas == nil {
+		state.Personas = map[string]*Persona{}
+	}
+	if state.Pins == nil {
+		state.Pins = map[string]PersonaPin{}
+	}
+	for name, p := range state.Personas {
+		if !personaNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid persona name %q", name)
+		}
+		if p == nil {
+			return fmt.Errorf("persona %s has no versions", name)
+		}
+		if _, ok := p.version(p.Active); !ok {
+			return fmt.Errorf("persona %s: active version %d does not exist", name, p.Active)
+		}
+	}
+	for channel, pin := range state.Pins {
+		p, ok := state.Personas[pin.Persona]
+		if !ok {
+			return fmt.Errorf("channel %s is pinned to unknown persona %s", channel, pin.Persona)
+		}
+		if _, ok := p.version(pin.Version); pin.Version != 0 && !ok {
+			return fmt.Errorf("channel %s is pinned to %s version %d, which does not exist", channel, pin.Persona, pin.Version)
+		}
+	}
+	return nil
+}
+
+// snapshot returns a copy of the personas and pins.
+func (s *personaStore) snapshot() personaState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state := personaState{Personas: make(map[string]*Persona, len(s.state.Personas)), Pins: make(map[string]PersonaPin, len(s.state.Pins))}
+	for name, p := range s.state.Personas {
+		cp := *p
+		cp.Versions = append([]PersonaVersion(nil), p.Versions...)
+		state.Personas[name] = &cp
+	}
+	for channel, pin := range s.state.Pins {
+		state.Pins[channel] = pin
+	}
+	return state
+}
+
+// replace swaps in a validated state and saves it.
+func (s *personaStore) replace(state personaState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	return s.saveLocked()
+}
+
+func (s *personaStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o644)
+}
+
+func (p *Persona) version(v int) (PersonaVersion, bool) {
+	for _, pv := range p.Versions {
+		if pv.Version == v {
+			return pv, true
+		}
+	}
+	return PersonaVersion{}, false
+}
+
+// publish adds a version of name and makes it active.
+func (s *personaStore) publish(name, prompt, by string) (int, error) {
+	if !personaNamePattern.MatchString(name) {
+		return 0, fmt.Errorf("invalid persona name %q: use lowercase letters, digits, - and _", name)
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return 0, errors.New("the prompt is empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.state.Personas[name]
+	if !ok {
+		p = &Persona{}
+		s.state.Personas[name] = p
+	}
+	next := 1
+	if n := len(p.Versions); n > 0 {
+		next = p.Versions[n-1].Version + 1
+	}
+	p.Versions = append(p.Versions, PersonaVersion{Version: next, Prompt: strings.TrimSpace(prompt), PublishedAt: s.now().UTC(), PublishedBy: by})
+	p.Active = next
+	return next, s.saveLocked()
+}
+
+// rollback makes version v of name active, or the version before the
+// active one when v is zero.
+func (s *personaStore) rollback(name string, v int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.state.Personas[name]
+	if !ok {
+		return 0, fmt.Errorf("no persona named %s", name)
+	}
+	if v == 0 {
+		for _, pv := range p.Versions {
+			if pv.Version < p.Active {
+				v = pv.Version
+			}
+		}
+		if v == 0 {
+			return 0, fmt.Errorf("%s@v%d is the first version", name, p.Active)
+		}
+	}
+	if _, ok := p.version(v); !ok {
+		return 0, fmt.Errorf("%s has no version %d", name, v)
+	}
+	p.Active = v
+	return v, s.saveLocked()
+}
+
+func (s *personaStore) pin(channel string, pin PersonaPin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.state.Personas[pin.Persona]
+	if !ok {
+		return fmt.Errorf("no persona named %s", pin.Persona)
+	}
+	if _, ok := p.version(pin.Version); pin.Version != 0 && !ok {
+		return fmt.Errorf("%s has no version %d", pin.Persona, pin.Version)
+	}
+	s.state.Pins[channel] = pin
+	return s.saveLocked()
+}
+
+func (s *personaStore) unpin(channel string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.state.Pins[channel]; !ok {
+		return false, nil
+	}
+	delete(s.state.Pins, channel)
+	return true, s.saveLocked()
+}
+
+// resolve returns the persona version answering in channel.
+func (s *personaStore) resolve(channel string) (backend.PersonaPrompt, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pin, pinned := s.state.Pins[channel]
+	if !pinned {
+		pin = PersonaPin{Persona: defaultPersona}
+	}
+	p, ok := s.state.Personas[pin.Persona]
+	if !ok {
+		return backend.PersonaPrompt{}, false
+	}
+	v := pin.Version
+	if v == 0 {
+		v = p.Active
+	}
+	pv, ok := p.version(v)
+	if !ok {
+		return backend.PersonaPrompt{}, false
+	}
+	return backend.PersonaPrompt{Name: pin.Persona, Version: pv.Version, Prompt: pv.Prompt}, true
+}
+
+// personaFor returns the persona answering in channel and tags the span
+// with it.
+func personaFor(ctx context.Context, channel string) *backend.PersonaPrompt {
+	p, ok := personas.resolve(channel)
+	if !ok {
+		return nil
+	}
+	metricPersonas.Add(p.Label(), 1)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("persona.version", p.Label()))
+	requests.record(ctx, "persona", p.Label())
+	return &p
+}
+
+func formatPersonas(s *personaStore) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.state.Personas) == 0 {
+		return "No personas yet. Publish one with `!persona publish <name> <prompt>`."
+	}
+	names := make([]string, 0, len(s.state.Personas))
+	for name := range s.state.Personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("*Personas*\n")
+	for _, name := range names {
+		p := s.state.Personas[name]
+		fmt.Fprintf(&b, "• `%s` active v%d of %d\n", name, p.Active, len(p.Versions))
+	}
+	channels := make([]string, 0, len(s.state.Pins))
+	for channel := range s.state.Pins {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		pin := s.state.Pins[channel]
+		version := "active"
+		if pin.Version != 0 {
+			version = fmt.Sprintf("v%d", pin.Version)
+		}
+		fmt.Fprintf(&b, "• <#%s> pinned to `%s` (%s)\n", channel, pin.Persona, version)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func formatPersonaVersion(s *personaStore, name string, v int) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.state.Personas[name]
+	if !ok {
+		return "", fmt.Errorf("no persona named %s", name)
+	}
+	if v == 0 {
+		v = p.Active
+	}
+	pv, ok := p.version(v)
+	if !ok {
+		return "", fmt.Errorf("%s has no version %d", name, v)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s@v%d*", name, pv.Version)
+	if pv.Version == p.Active {
+		b.WriteString(" (active)")
+	}
+	fmt.Fprintf(&b, ", published %s", pv.PublishedAt.Format("2006-01-02 15:04 MST"))
+	if pv.PublishedBy != "" {
+		fmt.Fprintf(&b, " by <@%s>", pv.PublishedBy)
+	}
+	fmt.Fprintf(&b, "\n```\n%s\n```", pv.Prompt)
+	return b.String(), nil
+}
+
+// parsePersonaRef reads "name" or "name@v3" (the "v" is optional).
+func parsePersonaRef(ref string) (string, int, error) {
+	name, version, found := strings.Cut(strings.ToLower(ref), "@")
+	if !found {
+		return name, 0, nil
+	}
+	v, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if err != nil || v <= 0 {
+		return "", 0, fmt.Errorf("invalid persona version %q", version)
+	}
+	return name, v, nil
+}
+
+// parseChannelRef reads "here", a channel mention or a channel ID.
+func parseChannelRef(ref, here string) string {
+	if ref == "" || strings.EqualFold(ref, "here") {
+		return here
+	}
+	if m := channelRefPattern.FindStringSubmatch(ref); m != nil {
+		return m[1]
+	}
+	return ref
+}
+
+const personaUsage = "Usage: `!persona [list]`, `!persona show <name>[@version]`, `!persona publish <name> <prompt>`, `!persona rollback <name> [version]`, `!persona pin <name>[@version] [#channel]` or `!persona unpin [#channel]`"
+
+func handlePersonaCommand(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+	sub, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+	reply := func(text string) { notifyUser(ctx, api, ev.Channel, ev.User, text) }
+	audit := func(action, channel, detail string) {
+		if err := auditLog.record(ctx, AuditEntry{Actor: ev.User, Action: action, Channel: channel, Detail: detail}); err != nil {
+			slog.ErrorContext(ctx, "Failed to write audit log", "err", err)
+		}
+	}
+	switch strings.ToLower(sub) {
+	case "", "list":
+		reply(formatPersonas(personas))
+	case "show":
+		name, v, err := parsePersonaRef(rest)
+		if err == nil {
+			var text string
+			if text, err = formatPersonaVersion(personas, name, v); err == nil {
+				reply(text)
+				return
+			}
+		}
+		reply(err.Error())
+	case "publish":
+		name, prompt, _ := strings.Cut(rest, " ")
+		v, err := personas.publish(strings.ToLower(name), prompt, ev.User)
+		if err != nil {
+			reply(fmt.Sprintf("Could not publish: %v", err))
+			return
+		}
+		label := backend.PersonaPrompt{Name: strings.ToLower(name), Version: v}.Label()
+		audit("persona_publish", "", label)
+		reply(fmt.Sprintf("Published %s; it answers from now on.", label))
+	case "rollback":
+		name, version, _ := strings.Cut(rest, " ")
+		v := 0
+		if version != "" {
+			var err error
+			if v, err = strconv.Atoi(strings.TrimPrefix(strings.ToLower(version), "v")); err != nil || v <= 0 {
+				reply(personaUsage)
+				return
+			}
+		}
+		v, err := personas.rollback(strings.ToLower(name), v)
+		if err != nil {
+			reply(fmt.Sprintf("Could not roll back: %v", err))
+			return
+		}
+		label := backend.PersonaPrompt{Name: strings.ToLower(name), Version: v}.Label()
+		audit("persona_rollback", "", label)
+		reply(fmt.Sprintf("Rolled back: %s is active again.", label))
+	case "pin":
+		ref, target, _ := strings.Cut(rest, " ")
+		name, v, err := parsePersonaRef(ref)
+		if err == nil {
+			channel := parseChannelRef(strings.TrimSpace(target), ev.Channel)
+			if err = personas.pin(channel, PersonaPin{Persona: name, Version: v}); err == nil {
+				audit("persona_pin", channel, ref)
+				reply(fmt.Sprintf("<#%s> now answers with `%s`.", channel, ref))
+				return
+			}
+		}
+		reply(fmt.Sprintf("Could not pin: %v", err))
+	case "unpin":
+		channel := parseChannelRef(rest, ev.Channel)
+		removed, err := personas.unpin(channel)
+		switch {
+		case err != nil:
+			reply(fmt.Sprintf("Could not unpin: %v", err))
+		case !removed:
+			reply(fmt.Sprintf("<#%s> is not pinned to a persona.", channel))
+		default:
+			audit("persona_unpin", channel, "")
+			reply(fmt.Sprintf("<#%s> answers with the `%s` persona again.", channel, defaultPersona))
+		}
+	default:
+		reply(personaUsage)
+	}
+}
+
+func init() {
+	registerCommand("persona", command{
+		Admin:   true,
+		Usage:   "list | show | publish | rollback | pin | unpin (versioned system prompts)",
+		Handler: handlePersonaCommand,
+	})
+}
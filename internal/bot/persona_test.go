@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestPersonaStore_PublishRollbackPin(t *testing.T) {
+	s := newPersonaStore()
+	path := filepath.Join(t.TempDir(), "personas.json")
+	if err := s.load(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, prompt := range []string{"Be brief.", "Be brief and cite sources.", "Be chatty."} {
+		if _, err := s.publish(defaultPersona, prompt, "UADMIN"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.publish("support", "Answer as the support desk.", "UADMIN")
+
+	if p, _ := s.resolve("CANY"); p.Label() != "default@v3" || p.Prompt != "Be chatty." {
+		t.Errorf("resolved %+v, want default@v3", p)
+	}
+	if v, err := s.rollback(defaultPersona, 0); err != nil || v != 2 {
+		t.Fatalf("rollback = %d, %v; want 2", v, err)
+	}
+	if p, _ := s.resolve("CANY"); p.Label() != "default@v2" {
+		t.Errorf("after rollback resolved %s", p.Label())
+	}
+	if err := s.pin("CSUP", PersonaPin{Persona: "support"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.pin("COLD", PersonaPin{Persona: defaultPersona, Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.pin("CBAD", PersonaPin{Persona: defaultPersona, Version: 9}); err == nil {
+		t.Error("pinned to a missing version")
+	}
+
+	reloaded := newPersonaStore()
+	if err := reloaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	for channel, want := range map[string]string{"CSUP": "support@v1", "COLD": "default@v1", "CANY": "default@v2"} {
+		if p, _ := reloaded.resolve(channel); p.Label() != want {
+			t.Errorf("%s resolved %s after reload, want %s", channel, p.Label(), want)
+		}
+	}
+}
+
+func TestPersona_SentAndRecordedOnAnswer(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	defer func(p *personaStore) { personas = p }(personas)
+	personas = newPersonaStore()
+	s := personas
+	s.publish(defaultPersona, "Be brief.", "UADMIN")
+
+	var got backend.ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Yes."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	rec := processTask(context.Background(), &fakeSlackClient{}, slackevents.AppMentionEvent{User: "U1", Channel: "CPER"}, "Is it up?")
+	if got.Persona == nil || got.Persona.Prompt != "Be brief." || got.Persona.Version != 1 {
+		t.Errorf("backend got persona %+v", got.Persona)
+	}
+	if rec == nil || rec.Persona != "default@v1" {
+		t.Errorf("record persona = %+v", rec)
+	}
+}
+
+func TestPersonaCommand(t *testing.T) {
+	defer func(p *personaStore) { personas = p }(personas)
+	personas = newPersonaStore()
+	defer func(admins []string) { config.AdminUsers = admins }(config.AdminUsers)
+	config.AdminUsers = []string{"UADMIN"}
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{User: "UADMIN", Channel: "CHERE"}
+
+	dispatchCommand(context.Background(), api, ev, "!persona publish Support Answer as the support desk.")
+	dispatchCommand(context.Background(), api, ev, "!persona pin support@v1 <#CSUP|support>")
+	if p, ok := personas.resolve("CSUP"); !ok || p.Label() != "support@v1" {
+		t.Errorf("CSUP resolved %+v", p)
+	}
+	dispatchCommand(context.Background(), api, ev, "!persona rollback support")
+	if last := api.posts[len(api.posts)-1].Text(); !strings.Contains(last, "first version") {
+		t.Errorf("rollback past the first version answered %q", last)
+	}
+
+	ev.User = "UOTHER"
+	dispatchCommand(context.Background(), api, ev, "!persona publish support Say nothing.")
+	if p, _ := personas.resolve("CSUP"); p.Prompt != "Answer as the support desk." {
+		t.Errorf("non-admin published %q", p.Prompt)
+	}
+}
@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/relayplugin"
+	"github.com/heykvr/chatrelaybot/relayplugin/pluginpb"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Plugins
+//
+// Executables listed in PLUGINS are started as subprocesses with
+// hashicorp/go-plugin and called over gRPC (see the relayplugin package).
+// On start the relay calls Describe; depending on the manifest a plugin
+// then receives Command (for "!name" commands it registered), Retrieve
+// (extra context sent to the backend) and PostProcess (answer text before
+// it is posted). Retrievers are not called for channels where internal
+// retrieval is off, such as Slack Connect channels under the external
+// policy. Each call is given pluginCallTimeout. Anything a plugin writes
+// to stderr is logged.
+const pluginCallTimeout = 10 * time.Second
+
+type plugin struct {
+	manifest *pluginpb.Manifest
+	client   pluginpb.PluginClient
+	close    func()
+}
+
+var plugins []*plugin
+
+func (p *plugin) Close() {
+	if p.close != nil {
+		p.close()
+	}
+}
+
+// newPlugin asks a connected plugin to describe itself.
+func newPlugin(client pluginpb.PluginClient, close func()) (*plugin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginCallTimeout)
+	defer cancel()
+	manifest, err := client.Describe(ctx, &pluginpb.DescribeRequest{})
+	if err == nil && manifest.Name == "" {
+		err = errors.New("plugin did not report a name")
+	}
+	if err != nil {
+		if close != nil {
+			close()
+		}
+		return nil, err
+	}
+	return &plugin{manifest: manifest, client: client, close: close}, nil
+}
+
+// pluginStderr logs each line a plugin writes to stderr.
+type pluginStderr string
+
+func (path pluginStderr) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			slog.Info("Plugin output", "plugin", string(path), "line", line)
+		}
+	}
+	return len(p), nil
+}
+
+func startPlugin(path string) (*plugin, error) {
+	client, close, err := relayplugin.Start(path, pluginStderr(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	p, err := newPlugin(client, close)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+func loadPluginsFromEnv() error {
+	for _, path := range strings.Split(os.Getenv("PLUGINS"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		p, err := startPlugin(path)
+		if err != nil {
+			return err
+		}
+		registerPlugin(p)
+	}
+	return nil
+}
+
+func registerPlugin(p *plugin) {
+	plugins = append(plugins, p)
+	for _, c := range p.manifest.Commands {
+		name := strings.ToLower(c.Name)
+		if _, exists := commands[name]; exists {
+			slog.Warn("Plugin command already registered, skipping", "plugin", p.manifest.Name, "command", name)
+			continue
+		}
+		registerCommand(name, command{Admin: c.Admin, Usage: c.Usage, Handler: pluginCommandHandler(p, name)})
+	}
+	slog.Info("Loaded plugin", "plugin", p.manifest.Name, "commands", len(p.manifest.Commands), "retriever", p.manifest.Retriever, "post_processor", p.manifest.PostProcessor)
+}
+
+func closePlugins() {
+	for _, p := range plugins {
+		p.Close()
+	}
+	plugins = nil
+}
+
+func pluginCommandHandler(p *plugin, name string) commandHandler {
+	return func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+		ctx, span := otel.Tracer("bot").Start(ctx, "plugin_command")
+		defer span.End()
+		span.SetAttributes(attribute.String("plugin.name", p.manifest.Name))
+
+		callCtx, cancel := context.WithTimeout(ctx, pluginCallTimeout)
+		defer cancel()
+		reply, err := p.client.Command(callCtx, &pluginpb.CommandRequest{Command: name, Args: args, User: ev.User, Channel: ev.Channel})
+		if err != nil {
+			span.RecordError(err)
+			slog.ErrorContext(ctx, "Plugin command failed", "plugin", p.manifest.Name, "command", name, "err", err)
+			notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("`!%s` failed, please try again later.", name))
+			return
+		}
+		if reply.Text == "" {
+			return
+		}
+		if reply.Ephemeral {
+			notifyUser(ctx, api, ev.Channel, ev.User, reply.Text)
+			return
+		}
+		sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: reply.Text})
+	}
+}
+
+// pluginContext collects retrieval context from every retriever plugin; a
+// failing plugin is logged and skipped so the question is still answered.
+// Channels where cc turns internal retrieval off get none.
+func pluginContext(ctx context.Context, cc ChannelConfig, user, channel, query string) []string {
+	if cc.DisableInternalRetrieval {
+		return nil
+	}
+	var out []string
+	for _, p := range plugins {
+		if !p.manifest.Retriever {
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, pluginCallTimeout)
+		reply, err := p.client.Retrieve(callCtx, &pluginpb.RetrieveRequest{Query: query, User: user, Channel: channel})
+		cancel()
+		if err != nil {
+			slog.ErrorContext(ctx, "Plugin retrieval failed", "plugin", p.manifest.Name, "err", err)
+			continue
+		}
+		out = append(out, reply.Context...)
+	}
+	return out
+}
+
+// postProcessAnswer runs answer text through post-processor plugins in load
+// order; a failing plugin leaves the text unchanged.
+func postProcessAnswer(ctx context.Context, channel, user, text string) string {
+	for _, p := range plugins {
+		if !p.manifest.PostProcessor {
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, pluginCallTimeout)
+		reply, err := p.client.PostProcess(callCtx, &pluginpb.PostProcessRequest{Text: text, User: user, Channel: channel})
+		cancel()
+		if err != nil {
+			slog.ErrorContext(ctx, "Plugin post-processing failed", "plugin", p.manifest.Name, "err", err)
+			continue
+		}
+		text = reply.Text
+	}
+	return text
+}
@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/relayplugin"
+	"github.com/heykvr/chatrelaybot/relayplugin/pluginpb"
+	"github.com/slack-go/slack/slackevents"
+)
+
+type testPlugin struct {
+	pluginpb.UnimplementedPluginServer
+}
+
+func (testPlugin) Describe(context.Context, *pluginpb.DescribeRequest) (*pluginpb.Manifest, error) {
+	return &pluginpb.Manifest{
+		Name:          "test",
+		Commands:      []*pluginpb.Command{{Name: "Oncall", Usage: "<team>"}},
+		Retriever:     true,
+		PostProcessor: true,
+	}, nil
+}
+
+func (testPlugin) Command(_ context.Context, req *pluginpb.CommandRequest) (*pluginpb.CommandReply, error) {
+	return &pluginpb.CommandReply{Text: "on call for " + req.Args + ": <@U9>"}, nil
+}
+
+func (testPlugin) Retrieve(_ context.Context, req *pluginpb.RetrieveRequest) (*pluginpb.RetrieveReply, error) {
+	return &pluginpb.RetrieveReply{Context: []string{"kb: " + req.Query}}, nil
+}
+
+func (testPlugin) PostProcess(_ context.Context, req *pluginpb.PostProcessRequest) (*pluginpb.PostProcessReply, error) {
+	return &pluginpb.PostProcessReply{Text: strings.ToUpper(req.Text)}, nil
+}
+
+// startTestPlugin serves testPlugin over an in-process gRPC connection.
+func startTestPlugin(t *testing.T) *plugin {
+	t.Helper()
+	client, server := goplugin.TestPluginGRPCConn(t, false, map[string]goplugin.Plugin{
+		relayplugin.Name: &relayplugin.GRPCPlugin{Impl: testPlugin{}},
+	})
+	raw, err := client.Dispense(relayplugin.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newPlugin(raw.(pluginpb.PluginClient), func() {
+		client.Close()
+		server.Stop()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerPlugin(p)
+	t.Cleanup(func() {
+		closePlugins()
+		delete(commands, "oncall")
+	})
+	return p
+}
+
+func TestPlugin_Stages(t *testing.T) {
+	p := startTestPlugin(t)
+	if p.manifest.Name != "test" {
+		t.Fatalf("unexpected manifest %+v", p.manifest)
+	}
+
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{User: "U1", Channel: "C1"}
+	if !dispatchCommand(context.Background(), api, ev, "!oncall payments") {
+		t.Fatal("plugin command not registered")
+	}
+	if posts := api.sent(); len(posts) != 1 || posts[0].Text() != "on call for payments: <@U9>" {
+		t.Errorf("unexpected command reply %+v", posts)
+	}
+
+	if got := pluginContext(context.Background(), ChannelConfig{}, "U1", "C1", "refunds"); len(got) != 1 || got[0] != "kb: refunds" {
+		t.Errorf("unexpected retrieval context %v", got)
+	}
+	if got := postProcessAnswer(context.Background(), "C1", "U1", "done"); got != "DONE" {
+		t.Errorf("unexpected post-processed text %q", got)
+	}
+}
+
+func TestProcessTask_NoPluginRetrievalInExternalChannels(t *testing.T) {
+	startTestPlugin(t)
+	setChannelSettings(channelSettings{External: ExternalPolicy{DisableInternalRetrieval: true}})
+	defer setChannelSettings(channelSettings{})
+	var got []backend.ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		got = append(got, req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Answer."})
+	}))
+	defer ts.Close()
+	defer func(url string) { config.BackendURL = url }(config.BackendURL)
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{external: map[string]bool{"CEXT": true}}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CEXT"}, "refunds")
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CINT"}, "refunds")
+	if len(got) != 2 {
+		t.Fatalf("expected two backend requests, got %d", len(got))
+	}
+	if slices.Contains(got[0].Context, "kb: refunds") {
+		t.Errorf("plugin retrieval sent for an externally shared channel: %v", got[0].Context)
+	}
+	if !slices.Contains(got[1].Context, "kb: refunds") {
+		t.Errorf("plugin retrieval missing for an internal channel: %v", got[1].Context)
+	}
+}
+
+func TestPlugin_FailureLeavesAnswerUnchanged(t *testing.T) {
+	p := startTestPlugin(t)
+	p.Close()
+	if got := postProcessAnswer(context.Background(), "C1", "U1", "done"); got != "done" {
+		t.Errorf("expected text unchanged when the plugin is gone, got %q", got)
+	}
+}
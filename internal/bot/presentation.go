@@ -0,0 +1,53 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+)
+
+// Answer Numbering
+//
+// With number_parts set for a channel, every message of a multi-message
+// answer is prefixed with its position ("(2/…)"; the total is unknown while
+// the backend is still streaming), and a final "✅ done" message says how
+// many parts were posted and how long the answer took. An answer that is
+// cut short gets no done message, so a reader scrolling back later can tell
+// a complete answer from one that stopped. Only answer chunks are numbered;
+// notices and disclaimers are posted as they are.
+type answerProgress struct {
+	started   time.Time
+	parts     int
+	completed bool
+}
+
+func newAnswerProgress() *answerProgress {
+	return &answerProgress{started: time.Now()}
+}
+
+// label numbers the next part.
+func (p *answerProgress) label(text string) string {
+	p.parts++
+	return fmt.Sprintf("(%d/…) %s", p.parts, text)
+}
+
+// complete marks the answer as having reached the end of the response.
+func (p *answerProgress) complete() {
+	p.completed = true
+}
+
+// doneNotice returns the completion message, or "" when the answer did not
+// finish or posted nothing.
+func (p *answerProgress) doneNotice() string {
+	if !p.completed || p.parts == 0 {
+		return ""
+	}
+	noun := "parts"
+	if p.parts == 1 {
+		noun = "part"
+	}
+	elapsed := time.Since(p.started).Round(time.Second)
+	if elapsed < time.Second {
+		elapsed = time.Since(p.started).Round(100 * time.Millisecond)
+	}
+	return fmt.Sprintf("✅ done (%d %s, %s)", p.parts, noun, elapsed)
+}
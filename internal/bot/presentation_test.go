@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func numberedAnswer(t *testing.T, events ...string) []string {
+	t.Helper()
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{
+		"CNUM": {NumberParts: true, Disclaimer: "Check before relying on this."},
+	}})
+	defer setChannelSettings(channelSettings{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, e := range events {
+			fmt.Fprintf(w, "data: %s\n\n", e)
+		}
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	rec := processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CNUM"}, "question")
+	if strings.Join(rec.Answer, "|") != "First|Second" {
+		t.Errorf("recorded answer should be unnumbered, got %q", rec.Answer)
+	}
+	var texts []string
+	for _, p := range api.sent() {
+		texts = append(texts, p.Text())
+	}
+	return texts
+}
+
+func TestAnswerNumbering_Completed(t *testing.T) {
+	texts := numberedAnswer(t,
+		`{"id":1,"event":"message_part","text_chunk":"First"}`,
+		`{"id":2,"event":"message_part","text_chunk":"Second"}`,
+		`{"id":3,"event":"stream_end"}`)
+	if len(texts) != 4 {
+		t.Fatalf("posts = %q", texts)
+	}
+	if texts[0] != "(1/…) First" || texts[1] != "(2/…) Second" || texts[2] != "Check before relying on this." {
+		t.Errorf("posts = %q", texts)
+	}
+	if !strings.HasPrefix(texts[3], "✅ done (2 parts, ") {
+		t.Errorf("done message = %q", texts[3])
+	}
+}
+
+func TestAnswerNumbering_NoDoneAfterError(t *testing.T) {
+	texts := numberedAnswer(t,
+		`{"id":1,"event":"message_part","text_chunk":"First"}`,
+		`{"id":2,"event":"message_part","text_chunk":"Second"}`,
+		`{"id":3,"event":"error","status":"backend crashed"}`)
+	for _, text := range texts {
+		if strings.Contains(text, "done") {
+			t.Errorf("incomplete answer got a done message: %q", texts)
+		}
+	}
+}
+
+func TestAnswerProgress_DoneNotice(t *testing.T) {
+	p := newAnswerProgress()
+	p.label("only")
+	if p.doneNotice() != "" {
+		t.Error("no done message before completion")
+	}
+	p.complete()
+	if got := p.doneNotice(); !strings.HasPrefix(got, "✅ done (1 part, ") {
+		t.Errorf("done message = %q", got)
+	}
+}
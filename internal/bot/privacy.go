@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// DM Privacy Mode
+//
+// DMs in privacy mode are answered statelessly: the question and answer are
+// not saved to the conversation store (so there is no transcript, FAQ
+// duplicate match, search result, summary or follow-up edit), never sampled
+// for evaluation, never queued in the outbox, left out of logs, trace
+// attributes and webhook payloads, and sent to the backend with "no_store"
+// set. Every private answer ends with privacyIndicator. DM_PRIVACY=all turns
+// it on for every DM; otherwise users opt in with "!privacy on", either
+// mentioning the bot or in a DM. DM_PRIVACY_FILE keeps the opted-in user IDs
+// across restarts. Private answers are counted under "dm_privacy" on
+// /debug/vars.
+const privacyIndicator = "_:lock: Privacy mode is on: this conversation was not stored._"
+
+type dmPrivacySettings struct {
+	mu    sync.Mutex
+	all   bool
+	path  string
+	users map[string]bool
+}
+
+func newDMPrivacySettings() *dmPrivacySettings {
+	return &dmPrivacySettings{users: map[string]bool{}}
+}
+
+type privateDMKey struct{}
+
+var (
+	dmPrivacy         = newDMPrivacySettings()
+	metricDMPrivacy   = expvar.NewMap("dm_privacy")
+	metricDMPrivacyIn = new(expvar.Int)
+	errPrivacyGlobal  = errors.New("privacy mode is on for all DMs")
+)
+
+// configure applies DM_PRIVACY: "all" or empty (per-user opt-in).
+func (p *dmPrivacySettings) configure(mode string) error {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "optin", "opt-in":
+		p.all = false
+	case "all":
+		p.all = true
+	default:
+		return fmt.Errorf("unknown DM_PRIVACY %q (use all or optin)", mode)
+	}
+	return nil
+}
+
+func (p *dmPrivacySettings) load(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var users []string
+	if err := json.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, u := range users {
+		p.users[u] = true
+	}
+	metricDMPrivacyIn.Set(int64(len(p.users)))
+	return nil
+}
+
+// applies reports whether user's DMs are private.
+func (p *dmPrivacySettings) applies(user string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.all || p.users[user]
+}
+
+// set opts user in or out and saves the list.
+func (p *dmPrivacySettings) set(user string, on bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.all && !on {
+		return errPrivacyGlobal
+	}
+	if on {
+		p.users[user] = true
+	} else {
+		delete(p.users, user)
+	}
+	metricDMPrivacyIn.Set(int64(len(p.users)))
+	if p.path == "" {
+		return nil
+	}
+	users := make([]string, 0, len(p.users))
+	for u := range p.users {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	data, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.path, data, 0o600)
+}
+
+func withPrivateDM(ctx context.Context) context.Context {
+	return context.WithValue(ctx, privateDMKey{}, true)
+}
+
+// isPrivateDM reports whether ctx belongs to a DM answered in privacy mode.
+func isPrivateDM(ctx context.Context) bool {
+	private, _ := ctx.Value(privateDMKey{}).(bool)
+	return private
+}
+
+// isPrivacyCommand reports whether a DM is the "!privacy" command, which
+// DMs accept even though they take no other commands.
+func isPrivacyCommand(text string) bool {
+	name, _, _ := strings.Cut(strings.TrimSpace(text), " ")
+	return strings.EqualFold(name, "!privacy")
+}
+
+func handlePrivacyCommand(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		if dmPrivacy.applies(ev.User) {
+			notifyUser(ctx, api, ev.Channel, ev.User, "Privacy mode is on: your DMs with me are not stored.")
+		} else {
+			notifyUser(ctx, api, ev.Channel, ev.User, "Privacy mode is off. Use `!privacy on` to stop storing your DMs with me.")
+		}
+	case "on":
+		if err := dmPrivacy.set(ev.User, true); err != nil {
+			slog.ErrorContext(ctx, "Failed to save DM privacy settings", "err", err)
+		}
+		notifyUser(ctx, api, ev.Channel, ev.User, "Privacy mode turned on: your DMs with me are no longer stored.")
+	case "off":
+		if err := dmPrivacy.set(ev.User, false); errors.Is(err, errPrivacyGlobal) {
+			notifyUser(ctx, api, ev.Channel, ev.User, "Privacy mode is on for all DMs in this workspace and can't be turned off.")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Failed to save DM privacy settings", "err", err)
+		}
+		notifyUser(ctx, api, ev.Channel, ev.User, "Privacy mode turned off.")
+	default:
+		notifyUser(ctx, api, ev.Channel, ev.User, "Usage: `!privacy [on|off]`")
+	}
+}
+
+func init() {
+	metricDMPrivacy.Set("opted_in", metricDMPrivacyIn)
+	registerCommand("privacy", command{
+		Usage:   "[on|off]",
+		Handler: handlePrivacyCommand,
+	})
+}
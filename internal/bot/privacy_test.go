@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestProcessDirectMessage_PrivacyModeStoresNothing(t *testing.T) {
+	defer func(p *dmPrivacySettings) { dmPrivacy = p }(dmPrivacy)
+	dmPrivacy = newDMPrivacySettings()
+	dmPrivacy.set("UPRIV", true)
+
+	var got backend.ChatRequest
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Rotate the key in the vault."})
+	}))
+	defer backendServer.Close()
+	config.BackendURL = backendServer.URL
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+	processDirectMessage(context.Background(), api, &slackevents.MessageEvent{User: "UPRIV", Channel: "DPRIV", ChannelType: "im", Text: "how do I rotate my key?"}, pool)
+	pool.Shutdown()
+
+	if !got.NoStore || got.Query != "how do I rotate my key?" {
+		t.Errorf("backend request %+v", got)
+	}
+	posts := api.sent()
+	if len(posts) != 2 || posts[0].Text() != "Rotate the key in the vault." || posts[1].Text() != privacyIndicator {
+		t.Fatalf("expected the answer and the privacy indicator, got %+v", posts)
+	}
+	for _, rec := range conversations.All() {
+		if rec.Channel == "DPRIV" {
+			t.Errorf("private DM was stored: %+v", rec)
+		}
+	}
+}
+
+func TestPrivacyCommand_InDM(t *testing.T) {
+	defer func(p *dmPrivacySettings) { dmPrivacy = p }(dmPrivacy)
+	dmPrivacy = newDMPrivacySettings()
+	path := filepath.Join(t.TempDir(), "privacy.json")
+	if err := dmPrivacy.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	api := &fakeSlackClient{}
+	processDirectMessage(context.Background(), api, &slackevents.MessageEvent{User: "U1", Channel: "D1", ChannelType: "im", Text: "!privacy on"}, workerpool.New(1))
+	if !dmPrivacy.applies("U1") || dmPrivacy.applies("U2") {
+		t.Fatal("opt-in not applied")
+	}
+	if posts := api.sent(); len(posts) != 1 || !strings.Contains(posts[0].Text(), "turned on") {
+		t.Errorf("unexpected reply %+v", posts)
+	}
+	if data, _ := os.ReadFile(path); string(data) != `["U1"]` {
+		t.Errorf("privacy file = %s", data)
+	}
+
+	dmPrivacy.configure("all")
+	if err := dmPrivacy.set("U2", false); err != errPrivacyGlobal || !dmPrivacy.applies("U2") {
+		t.Errorf("opted out of global privacy mode: %v", err)
+	}
+	if err := dmPrivacy.configure("sometimes"); err == nil {
+		t.Error("invalid DM_PRIVACY accepted")
+	}
+}
+
+func TestEmitWebhook_PrivateDMOmitsText(t *testing.T) {
+	d, received := newTestWebhooks(t, "", http.StatusOK)
+	defer func(w *webhookDispatcher) { webhooks = w }(webhooks)
+	webhooks = d
+	emitWebhook(withPrivateDM(context.Background()), EventAnswerCompleted, "D1", "U1", "", map[string]any{"query": "q", "answer": "a", "model": "m"})
+	ev := nextWebhook(t, received)
+	if _, ok := ev.Data["query"]; ok || ev.Data["answer"] != nil || ev.Data["model"] != "m" {
+		t.Errorf("unexpected payload %+v", ev.Data)
+	}
+}
@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Answer Provenance
+//
+// Answers posted anywhere other than the thread they were asked in carry a
+// context block naming the original question, the asker and a permalink to
+// the question, so readers can always trace them back. `!share #channel` in
+// an answered thread broadcasts the latest answer with that block. As with
+// archive search, answers from private channels and DMs only go to channels
+// the sharer is a member of, unless the sharer is an admin.
+const maxSectionText = 3000
+
+// questionPermalink links to the question message, falling back to the
+// first answer when the question timestamp is unknown.
+func questionPermalink(ctx context.Context, api SlackClient, rec ConversationRecord) string {
+	ts := rec.QueryTS
+	if ts == "" && len(rec.MessageTS) > 0 {
+		ts = rec.MessageTS[0]
+	}
+	if ts == "" {
+		return ""
+	}
+	link, err := api.GetPermalinkContext(ctx, &slack.PermalinkParameters{Channel: rec.Channel, Ts: ts})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get permalink", "conversation", rec.ID, "err", err)
+		return ""
+	}
+	return link
+}
+
+func provenanceBlock(ctx context.Context, api SlackClient, rec ConversationRecord) *slack.ContextBlock {
+	text := fmt.Sprintf(":link: Asked by <@%s> in <#%s>: _%s_", rec.User, rec.Channel, truncate(rec.Query, 200))
+	if link := questionPermalink(ctx, api, rec); link != "" {
+		text += fmt.Sprintf(" · <%s|View original>", link)
+	}
+	return slack.NewContextBlock("provenance_"+rec.ID, slack.NewTextBlockObject(slack.MarkdownType, text, false, false))
+}
+
+// broadcastAnswer posts the answer of rec to channel followed by its
+// provenance block.
+func broadcastAnswer(ctx context.Context, api SlackClient, rec ConversationRecord, channel, sharedBy string) (string, error) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "broadcast_answer")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("conversation.id", rec.ID),
+		attribute.String("channel.id", channel),
+		attribute.String("user.id", sharedBy),
+	)
+
+	answer := filterText(ctx, channel, rec.User, rec.AnswerText())
+	var blocks []slack.Block
+	for _, part := range splitSectionText(answer, maxSectionText) {
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, part, false, false), nil, nil))
+	}
+	blocks = append(blocks, provenanceBlock(ctx, api, rec))
+	ts, err := sendBlocks(ctx, api, channel, sharedBy, answer, blocks)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return ts, err
+}
+
+// splitSectionText cuts text into pieces that fit a section block,
+// preferring line breaks.
+func splitSectionText(text string, limit int) []string {
+	var parts []string
+	for len(text) > limit {
+		cut := strings.LastIndex(text[:limit], "\n")
+		if cut <= 0 {
+			cut = limit
+			for cut > 0 && !isRuneStart(text[cut]) {
+				cut--
+			}
+		}
+		parts = append(parts, text[:cut])
+		text = strings.TrimPrefix(text[cut:], "\n")
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// mayShare reports whether user may post an answer from channel from into
+// channel to.
+func mayShare(ctx context.Context, api SlackClient, from, to, user string) bool {
+	if isAdmin(user) {
+		return true
+	}
+	if info, ok := lookupChannelInfo(ctx, api, from); ok && !info.private {
+		return true
+	}
+	return isChannelMember(ctx, api, to, user)
+}
+
+func init() {
+	registerCommand("share", command{
+		Usage: "#channel (in an answered thread)",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			m := channelMentionPattern.FindStringSubmatch(strings.TrimSpace(args))
+			if m == nil || ev.ThreadTimeStamp == "" {
+				notifyUser(ctx, api, ev.Channel, ev.User, "Usage: `!share #channel` in the thread of the answer to share.")
+				return
+			}
+			rec, ok := conversations.LatestInThread(ev.Channel, ev.ThreadTimeStamp)
+			if !ok || len(rec.Answer) == 0 {
+				notifyUser(ctx, api, ev.Channel, ev.User, "There is no answer in this thread to share.")
+				return
+			}
+			if !mayShare(ctx, api, rec.Channel, m[1], ev.User) {
+				notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Answers from this channel can only be shared to channels you are a member of, and you are not in <#%s>.", m[1]))
+				return
+			}
+			if _, err := broadcastAnswer(ctx, api, rec, m[1], ev.User); err != nil {
+				notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Could not share the answer to <#%s>: %v", m[1], err))
+				return
+			}
+			slog.InfoContext(ctx, "Answer shared", "conversation", rec.ID, "to", m[1], "user", ev.User)
+			notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Answer shared to <#%s>.", m[1]))
+		},
+	})
+}
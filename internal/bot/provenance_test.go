@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestShareCommand_AddsProvenance(t *testing.T) {
+	conversations.Save(&ConversationRecord{
+		ID: "share1", Channel: "CSRC", ThreadTS: "100.000100", QueryTS: "100.000100",
+		User: "UASK", Query: "How do I rotate keys?", Answer: []string{"Use the rotate command."},
+		MessageTS: []string{"101.000100"}, CreatedAt: time.Now(),
+	})
+
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{User: "USHARE", Channel: "CSRC", ThreadTimeStamp: "100.000100"}
+	if !dispatchCommand(context.Background(), api, ev, "!share <#CDEST|announcements>") {
+		t.Fatal("share command not dispatched")
+	}
+
+	var shared *fakePost
+	for _, p := range api.sent() {
+		if p.Channel == "CDEST" {
+			p := p
+			shared = &p
+		}
+	}
+	if shared == nil {
+		t.Fatalf("nothing posted to the target channel: %+v", api.sent())
+	}
+	if shared.Text() != "Use the rotate command." {
+		t.Errorf("fallback text = %q", shared.Text())
+	}
+	var blocks []struct {
+		Type     string `json:"type"`
+		Elements []struct {
+			Text string `json:"text"`
+		} `json:"elements"`
+	}
+	if err := json.Unmarshal([]byte(shared.Values.Get("blocks")), &blocks); err != nil {
+		t.Fatal(err)
+	}
+	last := blocks[len(blocks)-1]
+	if last.Type != "context" || len(last.Elements) != 1 {
+		t.Fatalf("last block is not a provenance context block: %+v", last)
+	}
+	for _, want := range []string{"<@UASK>", "<#CSRC>", "How do I rotate keys?", "archives/CSRC/p100000100"} {
+		if !strings.Contains(last.Elements[0].Text, want) {
+			t.Errorf("provenance block missing %q: %s", want, last.Elements[0].Text)
+		}
+	}
+}
+
+func TestShareCommand_PrivateChannelNeedsMembership(t *testing.T) {
+	defer func(admins []string) { config.AdminUsers = admins }(config.AdminUsers)
+	config.AdminUsers = []string{"UADMIN"}
+	conversations.Save(&ConversationRecord{
+		ID: "share-private", Channel: "CSHAREPRIV", ThreadTS: "200.000100", User: "UASK",
+		Query: "What is the salary band?", Answer: []string{"Band 4."}, MessageTS: []string{"201.000100"}, CreatedAt: time.Now(),
+	})
+	api := &fakeSlackClient{private: map[string]bool{"CSHAREPRIV": true}, members: map[string][]string{"CINSIDE": {"UIN"}}}
+	share := func(user, target string) bool {
+		before := len(api.sent())
+		ev := slackevents.AppMentionEvent{User: user, Channel: "CSHAREPRIV", ThreadTimeStamp: "200.000100"}
+		dispatchCommand(context.Background(), api, ev, "!share <#"+target+">")
+		for _, p := range api.sent()[before:] {
+			if p.Channel == target {
+				return true
+			}
+		}
+		return false
+	}
+	if share("UOUT", "CINSIDE") {
+		t.Error("shared out of a private channel into a channel the sharer is not in")
+	}
+	if !share("UIN", "CINSIDE") {
+		t.Error("a member of the target channel could not share")
+	}
+	if !share("UADMIN", "CANYWHERE") {
+		t.Error("an admin could not share")
+	}
+}
+
+func TestSplitSectionText(t *testing.T) {
+	parts := splitSectionText(strings.Repeat("a", 8)+"\n"+strings.Repeat("é", 10), 10)
+	if len(parts) < 3 || parts[0] != strings.Repeat("a", 8) {
+		t.Fatalf("unexpected split %q", parts)
+	}
+	for _, p := range parts {
+		if len(p) > 10 || !utf8.ValidString(p) {
+			t.Errorf("bad part %q", p)
+		}
+	}
+	if strings.Join(parts, "") != strings.Repeat("a", 8)+strings.Repeat("é", 10) {
+		t.Errorf("text lost in split: %q", parts)
+	}
+}
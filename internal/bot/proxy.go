@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Slack Egress Proxy
+//
+// SLACK_PROXY_URL routes both Web API calls and the Socket Mode WebSocket
+// through an HTTP (CONNECT) or SOCKS5 proxy. It only applies to Slack: the
+// backend client keeps using HTTP_PROXY/HTTPS_PROXY from the environment.
+// At startup the relay checks that it can reach Slack over both paths so a
+// misconfigured proxy fails fast instead of looking like a silent bot.
+const slackSelfTestTimeout = 15 * time.Second
+
+// parseSlackProxy validates a proxy URL; an empty string means no proxy.
+func parseSlackProxy(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http:// or socks5://)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	return u, nil
+}
+
+// slackHTTPClient returns the client for Slack Web API calls.
+func slackHTTPClient(proxy *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// slackDialer returns the dialer for the Socket Mode WebSocket.
+func slackDialer(proxy *url.URL) *websocket.Dialer {
+	d := *websocket.DefaultDialer
+	if proxy != nil {
+		d.Proxy = http.ProxyURL(proxy)
+	}
+	return &d
+}
+
+// checkSlackConnectivity makes one Web API call and opens (then closes) a
+// Socket Mode WebSocket.
+func checkSlackConnectivity(ctx context.Context, api *slack.Client, socket *socketmode.Client, dialer *websocket.Dialer) error {
+	ctx, cancel := context.WithTimeout(ctx, slackSelfTestTimeout)
+	defer cancel()
+	if _, err := api.AuthTestContext(ctx); err != nil {
+		return fmt.Errorf("web API: %w", err)
+	}
+	_, wsURL, err := socket.OpenContext(ctx)
+	if err != nil {
+		return fmt.Errorf("socket mode connection URL: %w", err)
+	}
+	conn, _, err := dialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		return fmt.Errorf("socket mode WebSocket: %w", err)
+	}
+	return conn.Close()
+}
+
+// redactProxyURL hides a proxy password for logs and diagnostics.
+func redactProxyURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || raw == "" {
+		return ""
+	}
+	return u.Redacted()
+}
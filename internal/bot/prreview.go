@@ -0,0 +1,300 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Pull Request Reviews
+//
+// With GITHUB_TOKEN set, mentioning the bot with a pull request link and
+// the word "review" fetches the PR's diff from the GitHub API
+// (GITHUB_API_URL for GitHub Enterprise). The diff is split by file into
+// chunks of at most prChunkChars, each chunk is reviewed by the backend,
+// and a final request turns the chunk reviews into a summary. The summary
+// is posted in the thread, where follow-ups work as usual, and the
+// file-level notes are attached as a snippet. The chunk reviews are kept in
+// transcript buffers and streamed into the snippet, and the summary request
+// gets the first prChunkChars of each. Reviews are counted under
+// "pr_reviews" on /debug/vars.
+const (
+	defaultGitHubAPIURL = "https://api.github.com"
+	githubTimeout       = 30 * time.Second
+
+	prChunkChars   = 12000
+	maxPRChunks    = 12
+	maxPRDiffBytes = 4 << 20
+
+	prChunkInstruction   = "The context is part of a pull request diff. Review it file by file: point out bugs, risky changes, missing tests and unclear code, citing file names and lines. Skip files with nothing worth saying. Be concise."
+	prSummaryInstruction = "The context holds reviews of the parts of a pull request. Write a short overall review: what the change does, the most important problems to fix before merging, and smaller suggestions. Do not repeat every detail."
+)
+
+var (
+	githubToken  string
+	githubAPIURL = defaultGitHubAPIURL
+
+	prURLPattern     = regexp.MustCompile(`https?://([^/\s|>]+)/([\w.-]+)/([\w.-]+)/pull/(\d+)`)
+	reviewIntentWord = regexp.MustCompile(`(?i)\breview\b`)
+
+	metricPRReviews = expvar.NewMap("pr_reviews")
+)
+
+type pullRequestRef struct {
+	Owner, Repo, Number string
+}
+
+func (p pullRequestRef) String() string {
+	return fmt.Sprintf("%s/%s#%s", p.Owner, p.Repo, p.Number)
+}
+
+// githubWebHost returns the host PR links point at for the configured API.
+func githubWebHost() string {
+	u, err := url.Parse(githubAPIURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Host, "api.")
+}
+
+// parsePRReviewRequest returns the pull request query asks to review.
+func parsePRReviewRequest(query string) (pullRequestRef, bool) {
+	if githubToken == "" || !reviewIntentWord.MatchString(prURLPattern.ReplaceAllString(query, "")) {
+		return pullRequestRef{}, false
+	}
+	host := githubWebHost()
+	for _, m := range prURLPattern.FindAllStringSubmatch(query, -1) {
+		if strings.EqualFold(m[1], host) {
+			return pullRequestRef{Owner: m[2], Repo: m[3], Number: m[4]}, true
+		}
+	}
+	return pullRequestRef{}, false
+}
+
+func githubGet(ctx context.Context, path, accept string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, githubTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(githubAPIURL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("Authorization", "Bearer "+githubToken)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("GitHub returned %s for %s", resp.Status, path)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPRDiffBytes+1))
+	if err == nil && len(body) > maxPRDiffBytes {
+		err = fmt.Errorf("%s is larger than %d bytes", path, maxPRDiffBytes)
+	}
+	return body, err
+}
+
+type pullRequest struct {
+	Title        string `json:"title"`
+	Body         string `json:"body"`
+	ChangedFiles int    `json:"changed_files"`
+	Diff         string `json:"-"`
+}
+
+func fetchPullRequest(ctx context.Context, pr pullRequestRef) (pullRequest, error) {
+	path := fmt.Sprintf("/repos/%s/%s/pulls/%s", url.PathEscape(pr.Owner), url.PathEscape(pr.Repo), pr.Number)
+	var p pullRequest
+	data, err := githubGet(ctx, path, "application/vnd.github+json")
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("decode pull request: %w", err)
+	}
+	diff, err := githubGet(ctx, path, "application/vnd.github.diff")
+	if err != nil {
+		return p, err
+	}
+	p.Diff = string(diff)
+	return p, nil
+}
+
+// chunkDiff splits a unified diff at file boundaries into chunks of at most
+// size characters; a single larger file is cut into several chunks. It
+// returns at most limit chunks and the number of files left out.
+func chunkDiff(diff string, size, limit int) ([]string, int) {
+	var files []string
+	for _, part := range strings.Split(diff, "\ndiff --git ") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		if !strings.HasPrefix(part, "diff --git ") {
+			part = "diff --git " + part
+		}
+		files = append(files, part)
+	}
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+	for i, file := range files {
+		if current.Len() > 0 && current.Len()+len(file) > size {
+			flush()
+		}
+		if len(chunks) >= limit {
+			return chunks[:limit], len(files) - i
+		}
+		for len(file) > size {
+			flush()
+			chunks = append(chunks, file[:size])
+			file = file[size:]
+		}
+		current.WriteString(file)
+		current.WriteString("\n")
+	}
+	flush()
+	if len(chunks) > limit {
+		return chunks[:limit], 0
+	}
+	return chunks, 0
+}
+
+// handlePRReview reports whether the mention asked for a pull request
+// review, which is then queued.
+func handlePRReview(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, query string, pool *workerpool.Pool) bool {
+	pr, ok := parsePRReviewRequest(query)
+	if !ok {
+		return false
+	}
+	thread := ev.ThreadTimeStamp
+	if thread == "" {
+		thread = ev.TimeStamp
+	}
+	ctx = withRequestID(ctx)
+	requests.record(ctx, "queued", "review "+pr.String())
+	emitWebhook(ctx, EventQueryReceived, ev.Channel, ev.User, ev.ThreadTimeStamp, map[string]any{"source": "pr_review", "query": query})
+	submitConversationTask(ctx, api, pool, ev.Channel, ev.ThreadTimeStamp, ev.User, func() {
+		reviewPullRequest(ctx, api, ev, query, pr, thread)
+	})
+	return true
+}
+
+func reviewPullRequest(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, query string, pr pullRequestRef, thread string) {
+	ctx, cancel := taskContext(ctx)
+	defer cancel()
+	ctx, span := otel.Tracer("bot").Start(ctx, "review_pull_request")
+	defer span.End()
+	span.SetAttributes(attribute.String("github.pr", pr.String()), attribute.String("user.id", ev.User))
+	requests.describe(ctx, ev.Channel, ev.User)
+	inThread := slack.MsgOptionTS(thread)
+	fail := func(text string, err error) {
+		span.RecordError(err)
+		metricPRReviews.Add("errors", 1)
+		slog.ErrorContext(ctx, "Failed to review pull request", "pull_request", pr, "err", err)
+		requests.recordError(ctx, err.Error())
+		emitAnswerFailed(ctx, ev.Channel, ev.User, thread, "pr_review_failed", err)
+		sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: withErrorReference(ctx, text)}, inThread)
+	}
+
+	p, err := fetchPullRequest(ctx, pr)
+	if err != nil {
+		fail(fmt.Sprintf("Sorry, I couldn't fetch %s from GitHub.", pr), err)
+		return
+	}
+	chunks, omitted := chunkDiff(p.Diff, prChunkChars, maxPRChunks)
+	if len(chunks) == 0 {
+		sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: fmt.Sprintf("%s has no changes to review.", pr)}, inThread)
+		return
+	}
+	requests.record(ctx, "pr_fetched", fmt.Sprintf("%d files, %d chunks, %d files omitted", p.ChangedFiles, len(chunks), omitted))
+	sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User,
+		Text: fmt.Sprintf(":mag: Reviewing *%s* (%s, %d files)…", p.Title, pr, p.ChangedFiles)}, inThread)
+
+	title := fmt.Sprintf("Pull request %s: %s\n\n%s", pr, p.Title, truncate(p.Body, 2000))
+	// The chunk reviews stay in transcripts and are streamed into the notes
+	// file; the summary request gets the start of each.
+	var notes []*transcriptBuffer
+	defer func() {
+		for _, note := range notes {
+			note.Close()
+		}
+	}()
+	var noteContext []string
+	for i, chunk := range chunks {
+		note, err := requestTranscript(ctx, backend.ChatRequest{UserID: ev.User, ChannelID: ev.Channel, Query: title, Instruction: prChunkInstruction, Context: []string{chunk}})
+		if err != nil {
+			fail(fmt.Sprintf("Sorry, the review of %s failed partway through.", pr), err)
+			return
+		}
+		requests.record(ctx, "pr_chunk", fmt.Sprintf("%d/%d", i+1, len(chunks)))
+		notes = append(notes, note)
+		start, err := io.ReadAll(io.LimitReader(note.reader(), prChunkChars))
+		if err != nil {
+			fail(fmt.Sprintf("Sorry, the review of %s failed partway through.", pr), err)
+			return
+		}
+		noteContext = append(noteContext, strings.TrimSpace(string(start)))
+	}
+	summary, err := requestAnswer(ctx, backend.ChatRequest{UserID: ev.User, ChannelID: ev.Channel, Query: title, Instruction: prSummaryInstruction, Context: noteContext})
+	if err != nil {
+		fail(fmt.Sprintf("Sorry, the review of %s failed partway through.", pr), err)
+		return
+	}
+	if omitted > 0 {
+		summary += fmt.Sprintf("\n\n_%d files were too many to review and were skipped._", omitted)
+	}
+
+	rec := &ConversationRecord{ID: conversationIDFrom(ctx), Channel: ev.Channel, ThreadTS: thread, User: ev.User, Query: query, QueryTS: ev.TimeStamp, Model: "pr_review"}
+	if ts, err := sendAnswer(ctx, api, ev.Channel, ev.User, summary, inThread); err == nil {
+		rec.MessageTS = append(rec.MessageTS, ts)
+		rec.AnswerTS = append(rec.AnswerTS, ts)
+		rec.Answer = append(rec.Answer, summary)
+	}
+	details := newTranscriptBuffer(ctx)
+	defer details.Close()
+	details.WriteString(filterText(ctx, ev.Channel, ev.User, fmt.Sprintf("# Review of %s: %s", pr, p.Title)))
+	for i, note := range notes {
+		if i > 0 {
+			details.WriteString("\n\n---")
+		}
+		details.WriteString("\n\n")
+		if err := filterLines(ctx, details, note.reader(), ev.Channel, ev.User); err != nil {
+			span.RecordError(err)
+			slog.ErrorContext(ctx, "Failed to copy review notes", "pull_request", pr, "err", err)
+		}
+	}
+	details.WriteString("\n")
+	name := fmt.Sprintf("review-%s-%s-%s.md", pr.Owner, pr.Repo, pr.Number)
+	if _, err := uploadTranscript(ctx, api, slack.UploadFileV2Parameters{
+		Channel:         ev.Channel,
+		ThreadTimestamp: thread,
+		Filename:        name,
+		Title:           "File-level review notes",
+	}, details); err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to upload file", "file", name, "channel", ev.Channel, "err", err)
+	}
+	metricPRReviews.Add("reviews", 1)
+	finishConversation(ctx, api, rec)
+}
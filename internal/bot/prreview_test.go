@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestParsePRReviewRequest(t *testing.T) {
+	defer func(token, api string) { githubToken, githubAPIURL = token, api }(githubToken, githubAPIURL)
+	githubToken, githubAPIURL = "t", defaultGitHubAPIURL
+
+	pr, ok := parsePRReviewRequest("can you review <https://github.com/acme/web-app/pull/42>?")
+	if !ok || pr != (pullRequestRef{Owner: "acme", Repo: "web-app", Number: "42"}) {
+		t.Errorf("parsed %+v, %v", pr, ok)
+	}
+	for _, q := range []string{
+		"what does <https://github.com/acme/web-app/pull/42> change?",
+		"review <https://gitlab.com/acme/web-app/pull/42>",
+		"review <https://github.com/acme/review/issues/3>",
+	} {
+		if _, ok := parsePRReviewRequest(q); ok {
+			t.Errorf("%q should not start a review", q)
+		}
+	}
+	githubToken = ""
+	if _, ok := parsePRReviewRequest("review https://github.com/acme/web-app/pull/42"); ok {
+		t.Error("reviews need GITHUB_TOKEN")
+	}
+}
+
+func TestChunkDiff(t *testing.T) {
+	file := func(name string, n int) string {
+		return fmt.Sprintf("diff --git a/%s b/%s\n+%s", name, name, strings.Repeat("x", n))
+	}
+	diff := strings.Join([]string{file("a.go", 10), file("b.go", 10), file("c.go", 100)}, "\n")
+	chunks, omitted := chunkDiff(diff, 80, 10)
+	if omitted != 0 || len(chunks) != 3 || !strings.Contains(chunks[0], "a.go") || !strings.Contains(chunks[0], "b.go") {
+		t.Errorf("chunks = %q", chunks)
+	}
+	if chunks, omitted := chunkDiff(diff, 40, 1); len(chunks) != 1 || omitted != 2 {
+		t.Errorf("limited to %d chunks with %d files omitted", len(chunks), omitted)
+	}
+}
+
+func TestProcessMention_ReviewsPullRequest(t *testing.T) {
+	defer func(token, api string) { githubToken, githubAPIURL = token, api }(githubToken, githubAPIURL)
+	diff := "diff --git a/main.go b/main.go\n+fmt.Println(\"hi\")\ndiff --git a/main_test.go b/main_test.go\n+// TODO"
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/acme/api/pulls/7" || r.Header.Get("Authorization") != "Bearer ghp_test" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Accept") == "application/vnd.github.diff" {
+			fmt.Fprint(w, diff)
+			return
+		}
+		fmt.Fprint(w, `{"title":"Say hi","body":"Adds a greeting","changed_files":2}`)
+	}))
+	defer github.Close()
+	githubToken, githubAPIURL = "ghp_test", github.URL
+
+	var mu sync.Mutex
+	var instructions []string
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		instructions = append(instructions, req.Instruction)
+		mu.Unlock()
+		answer := "main.go: use the logger."
+		if req.Instruction == prSummaryInstruction {
+			answer = "Looks fine; " + strings.Join(req.Context, " ")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: answer})
+	}))
+	defer backendServer.Close()
+	config.BackendURL = backendServer.URL
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+	prURL := strings.Replace(github.URL, "http://", "https://", 1) + "/acme/api/pull/7"
+	processMention(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CPR", TimeStamp: "600.000100",
+		Text: "please review <" + prURL + ">"}, pool)
+	pool.Shutdown()
+
+	if len(instructions) != 2 || instructions[0] != prChunkInstruction || instructions[1] != prSummaryInstruction {
+		t.Fatalf("backend instructions = %q", instructions)
+	}
+	posts := api.sent()
+	if len(posts) != 2 || !strings.Contains(posts[0].Text(), "Reviewing *Say hi*") || !strings.HasPrefix(posts[1].Text(), "Looks fine; main.go: use the logger.") {
+		t.Fatalf("unexpected posts %+v", posts)
+	}
+	if posts[1].Values.Get("thread_ts") != "600.000100" {
+		t.Error("the review should be posted in the thread")
+	}
+	if len(api.uploads) != 1 || api.uploads[0].Filename != "review-acme-api-7.md" || !strings.Contains(api.uploads[0].Content, "use the logger") {
+		t.Errorf("file notes upload = %+v", api.uploads)
+	}
+	if rec, ok := conversations.LatestInThread("CPR", "600.000100"); !ok || rec.Model != "pr_review" {
+		t.Errorf("review not recorded for follow-ups: %+v", rec)
+	}
+}
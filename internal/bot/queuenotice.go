@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+)
+
+// Queue Position
+//
+// When every worker is busy, a question that has to wait for one gets a
+// notice such as "You're #4 in the queue, about 30s". The estimate is the
+// number of tasks ahead divided by the number of workers, times the pool's
+// recent average task time; until a task has finished only the position is
+// given. Slack does not let bots delete ephemeral messages, so the notice
+// is an ordinary reply in the question's thread and is deleted as soon as
+// a worker picks the question up. Channels with serialize_threads already
+// say how many questions are ahead in the thread and get no extra notice.
+// Notices are counted under "queue_notices" on /debug/vars.
+var metricQueueNotices = expvar.NewMap("queue_notices")
+
+// queueNotice is a posted queue position that is removed once its task
+// starts.
+type queueNotice struct {
+	api         SlackClient
+	channel, ts string
+}
+
+// queueEstimate returns the wait for a task at position, or 0 when the
+// pool has no recent timings.
+func queueEstimate(pool *workerpool.Pool, position int) time.Duration {
+	avg := pool.AverageTask()
+	if avg <= 0 {
+		return 0
+	}
+	return avg * time.Duration(position) / time.Duration(max(pool.Stats().Workers, 1))
+}
+
+func formatQueueNotice(position int, eta time.Duration) string {
+	text := fmt.Sprintf(":hourglass_flowing_sand: You're #%d in the queue", position)
+	switch {
+	case eta <= 0:
+		return text + "."
+	case eta < time.Minute:
+		// Round up to five seconds so the estimate is never "0s".
+		return fmt.Sprintf("%s, about %ds.", text, int((eta+5*time.Second-1)/(5*time.Second))*5)
+	default:
+		return fmt.Sprintf("%s, about %d min.", text, int((eta+30*time.Second)/time.Minute))
+	}
+}
+
+// postQueueNotice tells user their place in the pool's queue when the pool
+// is saturated. It returns nil when the question will start right away.
+func postQueueNotice(ctx context.Context, api SlackClient, pool *workerpool.Pool, channel, threadTS, user string) *queueNotice {
+	if !pool.Saturated() || isSelfTest(ctx) {
+		return nil
+	}
+	stats := pool.Stats()
+	position := stats.QueueDepth + 1
+	eta := queueEstimate(pool, position)
+	var opts []slack.MsgOption
+	if threadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(threadTS))
+	}
+	requests.record(ctx, "queue_position", fmt.Sprintf("#%d, estimate %s", position, eta.Round(time.Second)))
+	ts, err := sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: formatQueueNotice(position, eta)}, opts...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to post queue position", "channel", channel, "err", err)
+		return nil
+	}
+	metricQueueNotices.Add("posted", 1)
+	return &queueNotice{api: api, channel: channel, ts: ts}
+}
+
+// start deletes the notice; it is safe to call on nil.
+func (n *queueNotice) start(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	if _, _, err := n.api.DeleteMessageContext(ctx, n.channel, n.ts); err != nil {
+		metricQueueNotices.Add("delete_errors", 1)
+		slog.ErrorContext(ctx, "Failed to delete queue position", "channel", n.channel, "ts", n.ts, "err", err)
+		return
+	}
+	metricQueueNotices.Add("deleted", 1)
+}
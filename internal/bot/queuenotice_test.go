@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+)
+
+func TestFormatQueueNotice(t *testing.T) {
+	tests := []struct {
+		position int
+		eta      time.Duration
+		want     string
+	}{
+		{4, 0, ":hourglass_flowing_sand: You're #4 in the queue."},
+		{4, 28 * time.Second, ":hourglass_flowing_sand: You're #4 in the queue, about 30s."},
+		{1, 200 * time.Millisecond, ":hourglass_flowing_sand: You're #1 in the queue, about 5s."},
+		{9, 150 * time.Second, ":hourglass_flowing_sand: You're #9 in the queue, about 3 min."},
+	}
+	for _, tt := range tests {
+		if got := formatQueueNotice(tt.position, tt.eta); got != tt.want {
+			t.Errorf("formatQueueNotice(%d, %s) = %q, want %q", tt.position, tt.eta, got, tt.want)
+		}
+	}
+}
+
+func TestQueueEstimate(t *testing.T) {
+	pool := workerpool.New(2)
+	defer pool.Shutdown()
+	if got := queueEstimate(pool, 3); got != 0 {
+		t.Errorf("estimate without timings = %s, want 0", got)
+	}
+	pool.Submit(func() { time.Sleep(10 * time.Millisecond) })
+	for pool.AverageTask() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := queueEstimate(pool, 3), pool.AverageTask()*3/2; got != want {
+		t.Errorf("estimate = %s, want %s", got, want)
+	}
+}
+
+func TestSubmitConversationTask_PostsAndDeletesQueueNotice(t *testing.T) {
+	pool := workerpool.New(1)
+	api := &fakeSlackClient{}
+	release := make(chan struct{})
+	ctx := context.Background()
+
+	submitConversationTask(ctx, api, pool, "C1", "", "U1", func() { <-release })
+	for pool.Stats().Busy == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ran := make(chan struct{})
+	submitConversationTask(ctx, api, pool, "C1", "5.0", "U2", func() { close(ran) })
+
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Text() != ":hourglass_flowing_sand: You're #1 in the queue." {
+		t.Fatalf("expected a queue notice, got %v", posts)
+	}
+	if posts[0].Values.Get("thread_ts") != "5.0" {
+		t.Errorf("notice should be in the question's thread, got %v", posts[0].Values)
+	}
+	close(release)
+	<-ran
+	pool.Shutdown()
+	if len(api.deleted) != 1 || api.deleted[0] != "1.000100" {
+		t.Errorf("notice should be deleted when the question starts, deleted %v", api.deleted)
+	}
+}
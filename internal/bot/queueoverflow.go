@@ -0,0 +1,75 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+)
+
+// Queue Overflow
+//
+// Tasks wait for a worker in a queue of QUEUE_SIZE (default twice WORKERS).
+// Socket Mode events are dispatched on the event loop, so a submit that
+// waits for room stalls every later event. When the queue is full,
+// QUEUE_OVERFLOW decides: "reject" (the default) turns the new task away at
+// once, "drop_oldest" discards the task that has waited longest to make
+// room for it, and "block" waits up to QUEUE_BLOCK_TIMEOUT for room, holding
+// up the event loop meanwhile. A question turned away or discarded is not answered; its asker
+// gets an ephemeral busy notice instead, and tasks waiting behind it in the
+// same conversation lane (see Ordered Delivery) go with it. Event intake
+// checks for room before acknowledging an event (see Event Intake), except
+// under drop_oldest, which always has room. Turned-away and discarded tasks
+// are counted under "queue_overflow" on /debug/vars; the queue's depth,
+// capacity and policy are in "worker_pool".
+const queueBusyText = ":hourglass: I'm answering a lot of questions right now and couldn't take yours. Please try again in a minute."
+
+var metricQueueOverflow = expvar.NewMap("queue_overflow")
+
+// queueTask queues task on pool under key, as SubmitKeyed does. If the
+// queue turns it away or later discards it, cleanup runs and user is told
+// the relay is busy. It returns how many tasks with key are ahead.
+func queueTask(ctx context.Context, api SlackClient, pool *workerpool.Pool, key, channel, threadTS, user string, task, cleanup func()) int {
+	busy := func(outcome string) {
+		if cleanup != nil {
+			cleanup()
+		}
+		turnedAway(ctx, api, pool, channel, threadTS, user, outcome)
+	}
+	ahead, err := pool.SubmitTask(workerpool.Task{Key: key, Run: task, Dropped: func() { busy("dropped") }})
+	if errors.Is(err, workerpool.ErrQueueFull) {
+		busy("rejected")
+	}
+	return ahead
+}
+
+// turnedAway records a task the full queue did not run and tells user.
+func turnedAway(ctx context.Context, api SlackClient, pool *workerpool.Pool, channel, threadTS, user, outcome string) {
+	metricQueueOverflow.Add(outcome, 1)
+	stats := pool.Stats()
+	slog.WarnContext(ctx, "Queue full", "depth", stats.QueueDepth, "capacity", stats.QueueCapacity, "channel", channel, "outcome", outcome)
+	requests.recordError(ctx, fmt.Sprintf("queue full: %s", outcome))
+	notifyBusy(ctx, api, channel, threadTS, user)
+}
+
+// notifyBusy tells user, only them, that their question was turned away.
+func notifyBusy(ctx context.Context, api SlackClient, channel, threadTS, user string) {
+	if user == "" || isSelfTest(ctx) {
+		return
+	}
+	opts := []slack.MsgOption{slack.MsgOptionPostEphemeral(user)}
+	if threadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(threadTS))
+	}
+	sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: queueBusyText}, opts...)
+}
+
+// queueHasRoom reports whether stats leave room for another task, which a
+// drop_oldest queue always makes.
+func queueHasRoom(stats workerpool.Stats) bool {
+	return stats.Overflow == workerpool.DropOldest || stats.QueueDepth < stats.QueueCapacity
+}
@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+)
+
+// fullPool returns a one-worker pool of overflow whose worker is blocked
+// and whose one queue slot is taken, and the function unblocking it.
+func fullPool(t *testing.T, overflow workerpool.Overflow) (*workerpool.Pool, func()) {
+	t.Helper()
+	pool := workerpool.NewScaling(workerpool.Options{Min: 1, Max: 1, QueueSize: 1, Overflow: overflow})
+	release, started := make(chan struct{}), make(chan struct{})
+	pool.Submit(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	return pool, func() {
+		close(release)
+		pool.Shutdown()
+	}
+}
+
+// busyNotice returns the busy notice api sent, waiting briefly for one
+// sent from a dropped task's goroutine.
+func busyNotice(api *fakeSlackClient) (fakePost, bool) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		for _, p := range api.sent() {
+			if p.Text() == queueBusyText {
+				return p, true
+			}
+		}
+		if time.Now().After(deadline) {
+			return fakePost{}, false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubmitConversationTask_RejectedWhenQueueFull(t *testing.T) {
+	pool, release := fullPool(t, workerpool.Reject)
+	pool.Submit(func() {})
+
+	api := &fakeSlackClient{}
+	ran := false
+	submitConversationTask(context.Background(), api, pool, "CBUSY", "1.0", "U9", func() { ran = true })
+	notice, ok := busyNotice(api)
+	if !ok || notice.Values.Get("user") != "U9" || notice.Values.Get("thread_ts") != "1.0" {
+		t.Fatalf("expected an ephemeral busy notice to U9 in the thread, got %+v", api.sent())
+	}
+	if fairness.snapshot()["users_answered"] != 0 {
+		t.Errorf("rejected question still holds its user's slot: %v", fairness.snapshot())
+	}
+	release()
+	if ran {
+		t.Error("rejected task ran")
+	}
+	if st := pool.Stats(); st.Rejected != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestSubmitConversationTask_DropOldestTellsFirstAsker(t *testing.T) {
+	pool, release := fullPool(t, workerpool.DropOldest)
+	defer release()
+
+	api := &fakeSlackClient{}
+	submitConversationTask(context.Background(), api, pool, "CDROP", "", "U1", func() {})
+	submitConversationTask(context.Background(), api, pool, "CDROP", "", "U2", func() {})
+	notice, ok := busyNotice(api)
+	if !ok || notice.Values.Get("user") != "U1" {
+		t.Fatalf("expected a busy notice to the oldest asker, got %+v", api.sent())
+	}
+	if !queueHasRoom(pool.Stats()) {
+		t.Error("a drop_oldest queue should always admit events")
+	}
+}
@@ -0,0 +1,187 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Redaction
+//
+// The "Redact" message shortcut removes a leaked answer. Admins can redact
+// any answer and askers their own; the modal asks whether to replace the
+// answer with a redaction notice (the default, which keeps the thread
+// readable) or delete it, and for an optional reason. Every message of the
+// answer is removed from Slack, its Q&A canvas entry is replaced or
+// deleted the same way, the thread's summary is replaced with a notice, the
+// answer text is dropped from the conversation store, conversation memory,
+// evaluation samples, the outbox, the request timeline and the Slack read
+// cache, and the action is written to the audit log. Redacted answers can
+// no longer be fixed. Redactions are
+// counted under "redactions" on /debug/vars.
+const (
+	CallbackRedact      = "redact_answer"
+	CallbackRedactModal = "redact_answer_modal"
+
+	RedactReplace = "replace"
+	RedactDelete  = "delete"
+
+	redactModeBlockID    = "redact_mode"
+	redactModeActionID   = "mode"
+	redactReasonBlockID  = "redact_reason"
+	redactReasonActionID = "reason"
+)
+
+var metricRedactions = expvar.NewMap("redactions")
+
+// canRedact reports whether user may redact the answer; rec is nil for
+// bot messages with no conversation record.
+func canRedact(user string, rec *ConversationRecord) bool {
+	return isAdmin(user) || (rec != nil && rec.User == user)
+}
+
+func redactModal(channel, ts string) slack.ModalViewRequest {
+	option := func(value, label string) *slack.OptionBlockObject {
+		return slack.NewOptionBlockObject(value, slack.NewTextBlockObject(slack.PlainTextType, label, false, false), nil)
+	}
+	replace := option(RedactReplace, "Replace with a redaction notice")
+	mode := slack.NewRadioButtonsBlockElement(redactModeActionID, replace, option(RedactDelete, "Delete the messages"))
+	mode.InitialOption = replace
+	reason := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject(slack.PlainTextType, "Why is it being redacted?", false, false), redactReasonActionID)
+	reasonBlock := slack.NewInputBlock(redactReasonBlockID, slack.NewTextBlockObject(slack.PlainTextType, "Reason (audit log only)", false, false), nil, reason)
+	reasonBlock.Optional = true
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      CallbackRedactModal,
+		PrivateMetadata: messageKey(channel, ts),
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, "Redact answer", false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Redact", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			slack.NewInputBlock(redactModeBlockID, slack.NewTextBlockObject(slack.PlainTextType, "Action", false, false), nil, mode),
+			reasonBlock,
+		}},
+	}
+}
+
+// redactTarget finds the conversation behind a message and checks that
+// user may redact it, returning a notice for the user when not.
+func redactTarget(channel, ts, botID, user string) (*ConversationRecord, string) {
+	var rec *ConversationRecord
+	if r, ok := conversations.ByMessage(channel, ts); ok {
+		rec = &r
+	}
+	switch {
+	case rec == nil && botID == "":
+		return nil, "Only the bot's answers can be redacted."
+	case rec != nil && rec.Redacted:
+		return nil, "That answer has already been redacted."
+	case !canRedact(user, rec):
+		return nil, "Only admins and the person who asked can redact an answer."
+	}
+	return rec, ""
+}
+
+func handleRedactShortcut(ctx context.Context, api SlackClient, callback slack.InteractionCallback) {
+	channel, user, ts := callback.Channel.ID, callback.User.ID, callback.Message.Timestamp
+	if _, notice := redactTarget(channel, ts, callback.Message.BotID, user); notice != "" {
+		notifyUser(ctx, api, channel, user, notice)
+		return
+	}
+	if _, err := api.OpenViewContext(ctx, callback.TriggerID, redactModal(channel, ts)); err != nil {
+		slog.ErrorContext(ctx, "Failed to open the redaction dialog", "err", err)
+	}
+}
+
+func handleRedactSubmission(ctx context.Context, api SlackClient, callback slack.InteractionCallback) {
+	channel, ts, ok := strings.Cut(callback.View.PrivateMetadata, "/")
+	if !ok {
+		return
+	}
+	values := callback.View.State.Values
+	mode := values[redactModeBlockID][redactModeActionID].SelectedOption.Value
+	reason := strings.TrimSpace(values[redactReasonBlockID][redactReasonActionID].Value)
+	user := callback.User.ID
+	// Check again on submission; a message without a record was already
+	// confirmed to be the bot's when the dialog opened.
+	rec, notice := redactTarget(channel, ts, "bot", user)
+	if notice != "" {
+		notifyUser(ctx, api, channel, user, notice)
+		return
+	}
+	if err := redactAnswer(ctx, api, channel, ts, rec, user, mode, reason); err != nil {
+		notifyUser(ctx, api, channel, user, fmt.Sprintf("The answer was only partly redacted: %v", err))
+		return
+	}
+	notifyUser(ctx, api, channel, user, "The answer was redacted.")
+}
+
+// redactAnswer removes every message of the answer containing ts (just ts
+// when rec is nil) and scrubs the answer from the relay's stores.
+func redactAnswer(ctx context.Context, api SlackClient, channel, ts string, rec *ConversationRecord, actor, mode, reason string) error {
+	ctx, span := otel.Tracer("bot").Start(ctx, "redact_answer")
+	defer span.End()
+	if mode != RedactDelete {
+		mode = RedactReplace
+	}
+	span.SetAttributes(attribute.String("channel.id", channel), attribute.String("redact.mode", mode), attribute.String("user.id", actor))
+
+	messages := []string{ts}
+	conversationID := ""
+	if rec != nil {
+		messages, conversationID = rec.MessageTS, rec.ID
+	}
+	notice := fmt.Sprintf(":no_entry_sign: _This answer was redacted by <@%s>._", actor)
+	var errs []error
+	for i, msgTS := range messages {
+		var err error
+		if mode == RedactReplace && i == 0 {
+			_, _, _, err = api.UpdateMessageContext(ctx, channel, msgTS, slack.MsgOptionText(notice, false),
+				slack.MsgOptionBlocks(slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, notice, false, false), nil, nil)))
+		} else {
+			_, _, err = api.DeleteMessageContext(ctx, channel, msgTS)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", msgTS, err))
+		}
+	}
+
+	if rec != nil {
+		if err := redactQACanvasEntry(ctx, api, rec, mode); err != nil {
+			errs = append(errs, err)
+		}
+		if err := scrubThreadSummary(ctx, api, rec); err != nil {
+			errs = append(errs, err)
+		}
+		conversations.Redact(rec.ID)
+		requests.forget(rec.ID)
+		evals.forget(rec.ID)
+		outbox.forget(rec.ID)
+		searchIndex.remove(ctx, rec.ID)
+		memory.forgetTurn(memoryKey(rec.Channel, rec.ThreadTS, rec.User), rec.Query)
+	}
+	if slackReader != nil {
+		slackReader.Invalidate()
+	}
+	err := errors.Join(errs...)
+	detail := fmt.Sprintf("mode=%s messages=%d", mode, len(messages))
+	if reason != "" {
+		detail += " reason=" + reason
+	}
+	if err != nil {
+		span.RecordError(err)
+		detail += fmt.Sprintf(" failed=%d", len(errs))
+	}
+	if auditErr := auditLog.record(ctx, AuditEntry{Actor: actor, Action: "redact", Channel: channel, MessageTS: ts, ConversationID: conversationID, Detail: detail}); auditErr != nil {
+		slog.ErrorContext(ctx, "Failed to write the audit log", "err", auditErr)
+	}
+	metricRedactions.Add(mode, 1)
+	return err
+}
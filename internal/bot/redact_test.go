@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func redactSubmission(user, channel, ts, mode, reason string) slack.InteractionCallback {
+	var cb slack.InteractionCallback
+	cb.Type = slack.InteractionTypeViewSubmission
+	cb.User.ID = user
+	cb.View.CallbackID = CallbackRedactModal
+	cb.View.PrivateMetadata = messageKey(channel, ts)
+	cb.View.State = &slack.ViewState{Values: map[string]map[string]slack.BlockAction{
+		redactModeBlockID:   {redactModeActionID: {SelectedOption: slack.OptionBlockObject{Value: mode}}},
+		redactReasonBlockID: {redactReasonActionID: {Value: reason}},
+	}}
+	return cb
+}
+
+func TestRedact_ReplacesAnswerAndScrubsStores(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog.configure(auditPath)
+	defer auditLog.configure("")
+	defer func(e *evalStore) { evals = e }(evals)
+	evals = newEvalStore()
+	evals.configure(100)
+	defer func(m *conversationMemory) { memory = m }(memory)
+	memory = &conversationMemory{turns: defaultMemoryTurns, ttl: defaultMemoryTTL, conversations: make(map[string]*memoryConversation), now: time.Now}
+	memory.remember(memoryKey("CRED", "9.000100", "UASKER"), "earlier", "fine")
+	memory.remember(memoryKey("CRED", "9.000100", "UASKER"), "q", "the secret is hunter2")
+	conversations.Save(&ConversationRecord{ID: "redact-1", Channel: "CRED", ThreadTS: "9.000100", User: "UASKER", Query: "q",
+		Answer: []string{"the secret is hunter2", "more"}, MessageTS: []string{"10.000100", "11.000100"}})
+	evals.sample(&ConversationRecord{ID: "redact-1", Channel: "CRED", Query: "q", Answer: []string{"the secret is hunter2"}})
+	outbox.add(&OutboxEntry{ID: "redact-outbox", ConversationID: "redact-1", Channel: "CRED", Text: "hunter2"})
+
+	api := &fakeSlackClient{}
+	handleInteraction(context.Background(), api, redactSubmission("USOMEONE", "CRED", "11.000100", RedactReplace, ""))
+	if len(api.updates) != 0 || len(api.deleted) != 0 {
+		t.Fatal("only admins and the asker may redact")
+	}
+
+	handleInteraction(context.Background(), api, redactSubmission("UASKER", "CRED", "11.000100", RedactReplace, "leaked a password"))
+	if len(api.updates) != 1 || api.updates[0].Values.Get("ts") != "10.000100" || !strings.Contains(api.updates[0].Text(), "redacted by <@UASKER>") {
+		t.Fatalf("first message should become the notice, got %+v", api.updates)
+	}
+	if len(api.deleted) != 1 || api.deleted[0] != "11.000100" {
+		t.Errorf("remaining parts should be deleted, got %v", api.deleted)
+	}
+	rec, _ := conversations.Get("redact-1")
+	if !rec.Redacted || len(rec.Answer) != 0 {
+		t.Errorf("answer still stored: %+v", rec)
+	}
+	for _, s := range evals.list() {
+		if s.ConversationID == "redact-1" {
+			t.Error("evaluation sample was kept")
+		}
+	}
+	for _, e := range outbox.list() {
+		if e.ConversationID == "redact-1" {
+			t.Error("outbox entry was kept")
+		}
+	}
+	if turns := memory.history(memoryKey("CRED", "9.000100", "UASKER")); len(turns) != 1 || turns[0].Query != "earlier" {
+		t.Errorf("redacted turn still in conversation memory: %+v", turns)
+	}
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry AuditEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Actor != "UASKER" || entry.ConversationID != "redact-1" || !strings.Contains(entry.Detail, "leaked a password") {
+		t.Errorf("audit entry = %+v, %v", entry, err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Error("the audit log must not contain the redacted content")
+	}
+
+	// A second redaction is refused.
+	handleInteraction(context.Background(), api, redactSubmission("UASKER", "CRED", "10.000100", RedactDelete, ""))
+	if len(api.deleted) != 1 {
+		t.Error("an answer should only be redacted once")
+	}
+}
+
+func TestRedact_ScrubsThreadSummaryAndTimeline(t *testing.T) {
+	defer func(old *threadSummarizer) { threadSummaries = old }(threadSummaries)
+	threadSummaries = &threadSummarizer{threads: map[string]*threadSummaryState{
+		messageKey("CRED", "20.000100"): {replies: 25, summarizedAt: 25, ts: "30.000100"},
+	}}
+	ctx := withConversationID(context.Background(), "redact-2")
+	requests.record(ctx, "backend_error", "the secret is hunter2")
+
+	rec := &ConversationRecord{ID: "redact-2", Channel: "CRED", QueryTS: "20.000100", User: "UASKER", Query: "q",
+		Answer: []string{"the secret is hunter2"}, MessageTS: []string{"21.000100"}}
+	conversations.Save(rec)
+	api := &fakeSlackClient{}
+	if err := redactAnswer(context.Background(), api, "CRED", "21.000100", rec, "UASKER", RedactReplace, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var summary bool
+	for _, u := range api.updates {
+		if u.Values.Get("ts") == "30.000100" {
+			summary = u.Text() == threadSummaryScrubbed
+		}
+	}
+	if !summary {
+		t.Errorf("thread summary was not scrubbed: %+v", api.updates)
+	}
+	if !threadSummaries.countReply("CRED", "20.000100", ThreadSummaryConfig{After: 20}) {
+		t.Error("the next reply should rewrite the scrubbed summary")
+	}
+	if _, ok := requests.find("redact-2"); ok {
+		t.Error("the request timeline was kept")
+	}
+}
+
+func TestRedactTarget_BotMessagesWithoutRecord(t *testing.T) {
+	defer func(admins []string) { config.AdminUsers = admins }(config.AdminUsers)
+	config.AdminUsers = []string{"UADMIN"}
+	if _, notice := redactTarget("CRED", "99.000100", "", "UADMIN"); notice == "" {
+		t.Error("messages by people cannot be redacted")
+	}
+	if _, notice := redactTarget("CRED", "99.000100", "B1", "UASKER"); notice == "" {
+		t.Error("bot messages without a record are admin-only")
+	}
+	if _, notice := redactTarget("CRED", "99.000100", "B1", "UADMIN"); notice != "" {
+		t.Errorf("admins can redact any bot message, got %q", notice)
+	}
+}
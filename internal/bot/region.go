@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Data Residency
+//
+// Workspaces pinned to a data-residency region (or GovSlack) are served from
+// a different API host. SLACK_API_URL points the client at it and file
+// downloads are routed to the matching files host, or SLACK_FILES_URL when
+// the region uses a non-standard one.
+const maxSlackFileSize = 50 << 20
+
+// slackFilesBase returns the scheme and host file downloads should use:
+// the explicit override, or "files." on the API URL's domain.
+func slackFilesBase(apiURL, override string) string {
+	if override != "" {
+		return strings.TrimSuffix(override, "/")
+	}
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Host == "" {
+		return "https://files.slack.com"
+	}
+	host := u.Hostname()
+	if labels := strings.Split(host, "."); len(labels) > 2 {
+		host = strings.Join(labels[len(labels)-2:], ".")
+	}
+	if port := u.Port(); port != "" {
+		host += ":" + port
+	}
+	return u.Scheme + "://files." + host
+}
+
+// regionalFileURL rewrites a url_private(_download) link onto the configured
+// files host; other URLs are returned unchanged.
+func regionalFileURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || !strings.HasPrefix(u.Hostname(), "files.") {
+		return raw
+	}
+	base, err := url.Parse(slackFilesBase(config.SlackAPIURL, config.SlackFilesURL))
+	if err != nil {
+		return raw
+	}
+	u.Scheme, u.Host = base.Scheme, base.Host
+	return u.String()
+}
+
+func downloadSlackFile(ctx context.Context, fileURL string) ([]byte, error) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "download_slack_file")
+	defer span.End()
+
+	target := regionalFileURL(fileURL)
+	span.SetAttributes(attribute.String("file.host", hostOf(target)))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+config.SlackBotToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("file download returned %s", resp.Status)
+		span.RecordError(err)
+		return nil, err
+	}
+	// A wrong region answers with the HTML sign-in page instead of an error.
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		err := fmt.Errorf("file download from %s returned a sign-in page; check SLACK_API_URL/SLACK_FILES_URL for this workspace's region", hostOf(target))
+		span.RecordError(err)
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSlackFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSlackFileSize {
+		return nil, fmt.Errorf("file exceeds %d bytes", maxSlackFileSize)
+	}
+	return data, nil
+}
+
+func hostOf(raw string) string {
+	if u, err := url.Parse(raw); err == nil {
+		return u.Host
+	}
+	return ""
+}
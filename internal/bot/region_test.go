@@ -0,0 +1,52 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegionalFileURL(t *testing.T) {
+	defer func(api, files string) { config.SlackAPIURL, config.SlackFilesURL = api, files }(config.SlackAPIURL, config.SlackFilesURL)
+
+	config.SlackAPIURL, config.SlackFilesURL = "https://slack-gov.com/api/", ""
+	if got := regionalFileURL("https://files.slack.com/files-pri/T1-F1/report.pdf"); got != "https://files.slack-gov.com/files-pri/T1-F1/report.pdf" {
+		t.Errorf("file URL not routed to regional host: %s", got)
+	}
+	if got := regionalFileURL("https://example.com/a.png"); got != "https://example.com/a.png" {
+		t.Errorf("non-Slack URL should be unchanged: %s", got)
+	}
+
+	config.SlackFilesURL = "https://files.eu.example.test/"
+	if got := regionalFileURL("https://files.slack.com/files-pri/T1-F1/x"); got != "https://files.eu.example.test/files-pri/T1-F1/x" {
+		t.Errorf("override not applied: %s", got)
+	}
+}
+
+func TestDownloadSlackFile(t *testing.T) {
+	defer func(files, token string) { config.SlackFilesURL, config.SlackBotToken = files, token }(config.SlackFilesURL, config.SlackBotToken)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxb-test" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>sign in</html>"))
+			return
+		}
+		w.Write([]byte("file body"))
+	}))
+	defer ts.Close()
+	config.SlackFilesURL = ts.URL
+
+	config.SlackBotToken = "xoxb-test"
+	data, err := downloadSlackFile(context.Background(), "https://files.slack.com/files-pri/T1-F1/x.txt")
+	if err != nil || string(data) != "file body" {
+		t.Fatalf("download failed: %q %v", data, err)
+	}
+
+	config.SlackBotToken = "wrong"
+	if _, err := downloadSlackFile(context.Background(), "https://files.slack.com/files-pri/T1-F1/x.txt"); err == nil || !strings.Contains(err.Error(), "sign-in page") {
+		t.Errorf("expected sign-in page error, got %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package bot
+
+import (
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Reply Threads
+//
+// Answers go where the question was asked: a mention or DM inside a thread
+// is answered in that thread, not at the channel root. Channels with
+// "reply_in_thread" set also answer top-level mentions in a new thread
+// under the question, which keeps busy channels readable; follow-ups in
+// that thread then continue the same conversation. Top-level DMs are
+// answered in the conversation itself.
+func answerThread(ev slackevents.AppMentionEvent, dm bool) string {
+	if ev.ThreadTimeStamp != "" || dm {
+		return ev.ThreadTimeStamp
+	}
+	if channelConfigFor(ev.Channel).ReplyInThread {
+		return ev.TimeStamp
+	}
+	return ""
+}
+
+// threadOptions posts into threadTS, or at the root when it is empty.
+func threadOptions(threadTS string) []slack.MsgOption {
+	if threadTS == "" {
+		return nil
+	}
+	return []slack.MsgOption{slack.MsgOptionTS(threadTS)}
+}
@@ -0,0 +1,55 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestProcessMention_RepliesInThread(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CTHREADED": {ReplyInThread: true}}})
+	defer setChannelSettings(channelSettings{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Threaded reply."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+	pool := workerpool.New(1)
+	defer pool.Shutdown()
+	defer waitIdle(pool, time.Second)
+
+	for i, tc := range []struct {
+		name, channel, threadTS, want string
+	}{
+		{"mention in a thread", "CPLAIN", "100.000001", "100.000001"},
+		{"top-level mention", "CPLAIN", "", ""},
+		{"top-level mention with reply_in_thread", "CTHREADED", "", "200.000002"},
+	} {
+		api := &fakeSlackClient{}
+		ev := slackevents.AppMentionEvent{User: fmt.Sprintf("UTHREAD%d", i), Channel: tc.channel, BotID: "B1", Text: "<@B1> what is Go?", TimeStamp: "200.000002", ThreadTimeStamp: tc.threadTS}
+		processMention(context.Background(), api, ev, pool)
+		deadline := time.Now().Add(time.Second)
+		for len(api.sent()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		posts := api.sent()
+		if len(posts) == 0 {
+			t.Fatalf("%s: nothing posted", tc.name)
+		}
+		if got := posts[0].Values.Get("thread_ts"); got != tc.want {
+			t.Errorf("%s: thread_ts = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
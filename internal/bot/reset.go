@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Conversation Reset
+//
+// "@bot reset" (also "start over" or "!reset") in a thread, or in a DM,
+// starts the conversation there over. Its memory is cleared, so the next
+// question is sent without history (see memory.go). The earlier questions
+// and answers stay in the store, but lookups of the thread's latest answer
+// skip everything before the reset. At a channel root it resets the user's
+// own conversation there. Channels with "reset_button" set also get a
+// "Start over" button under each answer that does the same for its thread.
+// Anyone in the thread can reset it, and the reset is confirmed there.
+// Resets are counted under "conversation_resets" on /debug/vars.
+const (
+	ActionResetConversation = "reset_conversation"
+
+	resetConfirmation = ":arrows_counterclockwise: Starting over. I'll answer the next question here without the earlier ones."
+)
+
+var (
+	metricConversationResets = expvar.NewMap("conversation_resets")
+
+	resetPhrases = map[string]bool{"reset": true, "start over": true}
+)
+
+// isResetRequest reports whether text only asks to start over.
+func isResetRequest(text string) bool {
+	return resetPhrases[strings.ToLower(strings.Trim(strings.TrimSpace(text), ".!?"))]
+}
+
+// resetConversation forgets the conversation in channel's thread and
+// confirms it there.
+func resetConversation(ctx context.Context, api SlackClient, channel, thread, user, source string) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "reset_conversation")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("user.id", user),
+		attribute.String("channel.id", channel),
+		attribute.String("thread.ts", thread),
+		attribute.String("reset.source", source),
+	)
+	conversations.ResetThread(channel, thread)
+	memory.forget(memoryKey(channel, thread, user))
+	metricConversationResets.Add(source, 1)
+	slog.InfoContext(ctx, "Conversation reset", "user", user, "thread", messageKey(channel, thread))
+	if _, err := sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: resetConfirmation}, threadOptions(thread)...); err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to confirm reset", "channel", channel, "err", err)
+	}
+}
+
+// handleResetRequest resets ev's thread when query asks for it.
+func handleResetRequest(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, query string) bool {
+	if !isResetRequest(query) {
+		return false
+	}
+	resetConversation(ctx, api, ev.Channel, ev.ThreadTimeStamp, ev.User, "command")
+	return true
+}
+
+func resetButtonBlock(thread string) slack.Block {
+	return slack.NewActionBlock("reset_"+thread,
+		slack.NewButtonBlockElement(ActionResetConversation, thread, slack.NewTextBlockObject(slack.PlainTextType, "Start over", false, false)),
+	)
+}
+
+// postResetButton offers to start rec's conversation over, in its thread.
+func postResetButton(ctx context.Context, api SlackClient, rec *ConversationRecord) {
+	if !channelConfigFor(rec.Channel).ResetButton || len(rec.MessageTS) == 0 {
+		return
+	}
+	sendMessage(ctx, api, outgoingMessage{Channel: rec.Channel, User: rec.User, Text: "Asking about something else? Start over."},
+		append([]slack.MsgOption{slack.MsgOptionBlocks(resetButtonBlock(rec.ThreadTS))}, threadOptions(rec.ThreadTS)...)...,
+	)
+}
+
+func handleResetAction(ctx context.Context, api SlackClient, callback slack.InteractionCallback, action *slack.BlockAction) {
+	resetConversation(ctx, api, callback.Channel.ID, action.Value, callback.User.ID, "button")
+}
+
+func init() {
+	registerCommand("reset", command{
+		Usage: "(in a thread; \"reset\" and \"start over\" work too)",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			resetConversation(ctx, api, ev.Channel, ev.ThreadTimeStamp, ev.User, "command")
+		},
+	})
+}
@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestConversationStore_ResetThread(t *testing.T) {
+	store := NewConversationStore()
+	store.Save(&ConversationRecord{ID: "old", Channel: "C1", ThreadTS: "1.1", CreatedAt: time.Now().Add(-time.Minute)})
+	store.Save(&ConversationRecord{ID: "other", Channel: "C1", ThreadTS: "2.2", CreatedAt: time.Now().Add(-time.Minute)})
+	store.ResetThread("C1", "1.1")
+
+	if rec, ok := store.LatestInThread("C1", "1.1"); ok {
+		t.Fatalf("reset thread still has %s", rec.ID)
+	}
+	if _, ok := store.LatestInThread("C1", "2.2"); !ok {
+		t.Error("reset leaked into another thread")
+	}
+	if _, ok := store.Get("old"); !ok {
+		t.Error("reset must keep the record")
+	}
+	store.Save(&ConversationRecord{ID: "new", Channel: "C1", ThreadTS: "1.1", CreatedAt: time.Now().Add(time.Second)})
+	if rec, ok := store.LatestInThread("C1", "1.1"); !ok || rec.ID != "new" {
+		t.Errorf("latest after reset = %+v", rec)
+	}
+}
+
+func TestReset_MentionAndButton(t *testing.T) {
+	defer func(s *ConversationStore) { conversations = s }(conversations)
+	conversations = NewConversationStore()
+	conversations.Save(&ConversationRecord{ID: "r1", Channel: "CRESET", ThreadTS: "5.5", CreatedAt: time.Now().Add(-time.Minute)})
+	pool := workerpool.New(1)
+	defer pool.Shutdown()
+
+	api := &fakeSlackClient{}
+	processMention(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CRESET", BotID: "B1", Text: "<@B1> Start over!", ThreadTimeStamp: "5.5"}, pool)
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Text() != resetConfirmation || posts[0].Values.Get("thread_ts") != "5.5" {
+		t.Fatalf("expected the reset to be confirmed in the thread, got %+v", posts)
+	}
+	if _, ok := conversations.LatestInThread("CRESET", "5.5"); ok {
+		t.Error("thread was not reset")
+	}
+
+	conversations.Save(&ConversationRecord{ID: "r2", Channel: "CREVIEW", ThreadTS: "6.6", CreatedAt: time.Now().Add(-time.Minute)})
+	handleInteraction(context.Background(), api, reviewCallback("U2", ActionResetConversation, "6.6"))
+	if _, ok := conversations.LatestInThread("CREVIEW", "6.6"); ok {
+		t.Error("button did not reset the thread")
+	}
+}
+
+func TestPostResetButton_OnlyWhenConfigured(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CBUTTON": {ResetButton: true}}})
+	defer setChannelSettings(channelSettings{})
+	api := &fakeSlackClient{}
+	postResetButton(context.Background(), api, &ConversationRecord{Channel: "CPLAIN", MessageTS: []string{"1.0"}})
+	postResetButton(context.Background(), api, &ConversationRecord{Channel: "CBUTTON", ThreadTS: "7.7", MessageTS: []string{"1.0"}})
+	if len(api.posts) != 1 || api.posts[0].Values.Get("thread_ts") != "7.7" || !strings.Contains(api.posts[0].Values.Get("blocks"), ActionResetConversation) {
+		t.Errorf("posts %+v", api.posts)
+	}
+}
@@ -0,0 +1,223 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Review Mode
+//
+// Held answers wait for a decision for up to reviewTTL, and at most
+// reviewMax are kept; older ones are dropped and counted under "reviews"
+// on /debug/vars. An approved answer is posted where the question was
+// asked, in its thread.
+const (
+	ActionReviewApprove = "review_approve"
+	ActionReviewReject  = "review_reject"
+
+	reviewTTL = 7 * 24 * time.Hour
+	reviewMax = 1000
+)
+
+var metricReviews = expvar.NewMap("reviews")
+
+type pendingReview struct {
+	ID       string
+	Channel  string
+	ThreadTS string
+	User     string
+	Query    string
+	Chunks   []string
+	// Conversation is the ID of the held answer's conversation record.
+	Conversation string
+
+	added time.Time
+}
+
+type reviewQueue struct {
+	mu      sync.Mutex
+	pending map[string]*pendingReview
+	order   []string
+	ttl     time.Duration
+	max     int
+	now     func() time.Time
+}
+
+func newReviewQueue(ttl time.Duration, max int) *reviewQueue {
+	return &reviewQueue{pending: make(map[string]*pendingReview), ttl: ttl, max: max, now: time.Now}
+}
+
+var reviews = newReviewQueue(reviewTTL, reviewMax)
+
+func (q *reviewQueue) add(r *pendingReview) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r.added = q.now()
+	q.pending[r.ID] = r
+	q.order = append(q.order, r.ID)
+	q.evictLocked()
+}
+
+// evictLocked drops expired reviews, then the oldest while over max.
+// Callers hold q.mu.
+func (q *reviewQueue) evictLocked() {
+	cutoff := q.now().Add(-q.ttl)
+	for len(q.order) > 0 {
+		id := q.order[0]
+		switch {
+		case q.pending[id].added.Before(cutoff):
+			metricReviews.Add("expired", 1)
+		case len(q.pending) > q.max:
+			metricReviews.Add("evicted_full", 1)
+		default:
+			return
+		}
+		delete(q.pending, id)
+		q.order = q.order[1:]
+	}
+}
+
+// take removes the review so that only the first decision is applied.
+func (q *reviewQueue) take(id string) (*pendingReview, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.evictLocked()
+	r, ok := q.pending[id]
+	if ok {
+		delete(q.pending, id)
+		q.order = slices.DeleteFunc(q.order, func(o string) bool { return o == id })
+	}
+	return r, ok
+}
+
+func (q *reviewQueue) get(id string) (*pendingReview, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.evictLocked()
+	r, ok := q.pending[id]
+	return r, ok
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func submitForReview(ctx context.Context, api SlackClient, cc ChannelConfig, r *pendingReview) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "submit_for_review")
+	defer span.End()
+
+	if len(r.Chunks) == 0 {
+		return
+	}
+	reviews.add(r)
+	span.SetAttributes(attribute.String("review.id", r.ID))
+
+	blocks := reviewBlocks(ctx, r)
+	fallback := outgoingMessage{Channel: cc.ReviewChannel, User: r.User, Text: "Review requested: " + r.Query}
+	if cc.ReviewChannel != "" {
+		if _, err := sendMessage(ctx, api, fallback, slack.MsgOptionBlocks(blocks...)); err != nil {
+			span.RecordError(err)
+			slog.ErrorContext(ctx, "Failed to post review", "review", r.ID, "err", err)
+		}
+		return
+	}
+	if len(cc.Reviewers) == 0 {
+		slog.InfoContext(ctx, "Answer held for review but no reviewers are configured", "review", r.ID, "channel", r.Channel)
+		return
+	}
+	fallback.Channel = r.Channel
+	for _, reviewer := range cc.Reviewers {
+		if _, err := sendMessage(ctx, api, fallback, slack.MsgOptionBlocks(blocks...), slack.MsgOptionPostEphemeral(reviewer)); err != nil {
+			span.RecordError(err)
+		}
+	}
+	slog.InfoContext(ctx, "Answer held for review", "review", r.ID)
+}
+
+func reviewBlocks(ctx context.Context, r *pendingReview) []slack.Block {
+	header := fmt.Sprintf("*Review requested* for <#%s>\n*<@%s> asked:* %s", r.Channel, r.User, r.Query)
+	answer := filterText(ctx, r.Channel, r.User, strings.Join(r.Chunks, "\n"))
+	return []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, header, false, false), nil, nil),
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, answer, false, false), nil, nil),
+		slack.NewActionBlock("review_"+r.ID,
+			slack.NewButtonBlockElement(ActionReviewApprove, r.ID, slack.NewTextBlockObject(slack.PlainTextType, "Approve", false, false)).WithStyle(slack.StylePrimary),
+			slack.NewButtonBlockElement(ActionReviewReject, r.ID, slack.NewTextBlockObject(slack.PlainTextType, "Reject", false, false)).WithStyle(slack.StyleDanger),
+		),
+	}
+}
+
+func handleReviewAction(ctx context.Context, api SlackClient, callback slack.InteractionCallback, action *slack.BlockAction) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "review_decision")
+	defer span.End()
+
+	reviewer := callback.User.ID
+	span.SetAttributes(
+		attribute.String("review.id", action.Value),
+		attribute.String("user.id", reviewer),
+		attribute.String("review.action", action.ActionID),
+	)
+
+	r, ok := reviews.get(action.Value)
+	if !ok {
+		notifyUser(ctx, api, callback.Channel.ID, reviewer, "This answer has already been reviewed.")
+		return
+	}
+	// Shared channels may be reviewed by the external policy's reviewers.
+	if !effectiveChannelConfig(ctx, api, r.Channel).isReviewer(reviewer) {
+		notifyUser(ctx, api, callback.Channel.ID, reviewer, "You are not authorized to review answers for this channel.")
+		return
+	}
+	if r, ok = reviews.take(action.Value); !ok {
+		return
+	}
+
+	if action.ActionID == ActionReviewReject {
+		slog.InfoContext(ctx, "Answer rejected", "review", r.ID, "reviewer", reviewer)
+		notifyUser(ctx, api, callback.Channel.ID, reviewer, "Answer rejected; nothing was published.")
+		return
+	}
+
+	var posted []string
+	for _, chunk := range r.Chunks {
+		ts, err := sendAnswer(ctx, api, r.Channel, r.User, chunk, threadOptions(r.ThreadTS)...)
+		if err != nil {
+			span.RecordError(err)
+			continue
+		}
+		posted = append(posted, ts)
+	}
+	// The record was saved without messages while the answer was held;
+	// the published ones make it eligible for the Q&A canvas.
+	if len(posted) > 0 && conversations.Update(r.Conversation, func(rec *ConversationRecord) {
+		rec.MessageTS = append(rec.MessageTS, posted...)
+		rec.AnswerTS = append(rec.AnswerTS, posted...)
+	}) {
+		if rec, ok := conversations.Get(r.Conversation); ok {
+			appendToQACanvas(ctx, api, &rec)
+		}
+	}
+	slog.InfoContext(ctx, "Answer approved", "review", r.ID, "reviewer", reviewer)
+	notifyUser(ctx, api, callback.Channel.ID, reviewer, fmt.Sprintf("Answer published to <#%s>.", r.Channel))
+}
+
+func notifyUser(ctx context.Context, api SlackClient, channel, user, text string) {
+	if channel == "" {
+		channel = user
+	}
+	sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: text}, slack.MsgOptionPostEphemeral(user))
+}
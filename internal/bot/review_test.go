@@ -0,0 +1,161 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func reviewCallback(user, actionID, reviewID string) slack.InteractionCallback {
+	var callback slack.InteractionCallback
+	callback.Type = slack.InteractionTypeBlockActions
+	callback.User.ID = user
+	callback.Channel.ID = "CREVIEW"
+	callback.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: actionID, Value: reviewID}}
+	return callback
+}
+
+func TestProcessTask_ReviewModeHoldsAnswer(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{
+		"C1": {ReviewMode: true, ReviewChannel: "CREVIEW", Reviewers: []string{"UREV"}},
+	}})
+	defer setChannelSettings(channelSettings{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "First. Second."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "C1"}, "foo")
+
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Channel != "CREVIEW" {
+		t.Fatalf("expected a single post to the review channel, got %+v", posts)
+	}
+
+	var pending *pendingReview
+	reviews.mu.Lock()
+	for _, r := range reviews.pending {
+		if r.Channel == "C1" {
+			pending = r
+		}
+	}
+	reviews.mu.Unlock()
+	if pending == nil || len(pending.Chunks) != 2 {
+		t.Fatalf("expected pending review with 2 chunks, got %+v", pending)
+	}
+
+	handleInteraction(context.Background(), api, reviewCallback("UOTHER", ActionReviewApprove, pending.ID))
+	for _, p := range api.sent() {
+		if p.Channel == "C1" && p.Values.Get("user") == "" {
+			t.Fatal("unauthorized reviewer must not publish the answer")
+		}
+	}
+
+	handleInteraction(context.Background(), api, reviewCallback("UREV", ActionReviewApprove, pending.ID))
+	var published int
+	for _, p := range api.sent() {
+		if p.Channel == "C1" {
+			published++
+		}
+	}
+	if published != 2 {
+		t.Errorf("expected 2 chunks published after approval, got %d", published)
+	}
+	if _, ok := reviews.get(pending.ID); ok {
+		t.Error("review should be removed after a decision")
+	}
+}
+
+func TestReviewReject_DoesNotPublish(t *testing.T) {
+	setChannelSettings(channelSettings{Default: ChannelConfig{ReviewMode: true, Reviewers: []string{"UREV"}}})
+	defer setChannelSettings(channelSettings{})
+
+	r := &pendingReview{ID: newID(), Channel: "C2", User: "U1", Query: "q", Chunks: []string{"answer"}}
+	reviews.add(r)
+
+	api := &fakeSlackClient{}
+	handleInteraction(context.Background(), api, reviewCallback("UREV", ActionReviewReject, r.ID))
+	for _, p := range api.sent() {
+		if p.Channel == "C2" {
+			t.Fatalf("rejected answer was published: %+v", p)
+		}
+	}
+}
+
+func TestReviewApprove_PublishesInThread(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{
+		"CTHREAD": {ReviewMode: true, ReviewChannel: "CREVIEW", Reviewers: []string{"UREV"}},
+	}})
+	defer setChannelSettings(channelSettings{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Answer."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CTHREAD", ThreadTimeStamp: "5.000100"}, "foo")
+
+	var pending *pendingReview
+	reviews.mu.Lock()
+	for _, r := range reviews.pending {
+		if r.Channel == "CTHREAD" {
+			pending = r
+		}
+	}
+	reviews.mu.Unlock()
+	if pending == nil || pending.ThreadTS != "5.000100" {
+		t.Fatalf("expected a pending review in the question's thread, got %+v", pending)
+	}
+	handleInteraction(context.Background(), api, reviewCallback("UREV", ActionReviewApprove, pending.ID))
+	var published int
+	for _, p := range api.sent() {
+		if p.Channel == "CTHREAD" {
+			published++
+			if p.Values.Get("thread_ts") != "5.000100" {
+				t.Errorf("approved answer posted outside the thread: %+v", p)
+			}
+		}
+	}
+	if published != 1 {
+		t.Errorf("expected the approved answer published, got %d posts", published)
+	}
+}
+
+func TestReviewQueue_ExpiresAndBoundsReviews(t *testing.T) {
+	now := time.Now()
+	q := newReviewQueue(time.Hour, 2)
+	q.now = func() time.Time { return now }
+	q.add(&pendingReview{ID: "r1"})
+	now = now.Add(2 * time.Hour)
+	if _, ok := q.get("r1"); ok {
+		t.Error("expired review should be dropped")
+	}
+	for _, id := range []string{"r2", "r3", "r4"} {
+		q.add(&pendingReview{ID: id})
+	}
+	if _, ok := q.get("r2"); ok {
+		t.Error("oldest review should be dropped when full")
+	}
+	for _, id := range []string{"r3", "r4"} {
+		if _, ok := q.take(id); !ok {
+			t.Errorf("review %s should still be pending", id)
+		}
+	}
+	if len(q.pending) != 0 || len(q.order) != 0 {
+		t.Errorf("decided reviews left behind: %v %v", q.pending, q.order)
+	}
+}
@@ -0,0 +1,230 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Subsystem Lifecycles
+//
+// Every long-running part of the relay is started as a subsystem of a
+// Runner instead of a bare goroutine: the admin HTTP server, the worker
+// pool, the watchers, the scheduled jobs, the event loop and the Socket
+// Mode listener. The subsystems run under an errgroup, each with its own
+// context. A panic is recovered and treated as an error, so a failing
+// subsystem is logged and counted instead of dying silently. What happens
+// next depends on its restart policy:
+//
+//   - restartOnFailure restarts it after a backoff that doubles from
+//     restartBackoffMin up to restartBackoffMax (scheduled jobs, watchers).
+//   - runOnce lets it finish, but an error stops the relay.
+//   - stopRunner stops the relay whenever it returns (the listener, so
+//     a handover or a lost connection ends the process as before).
+//
+// Shutdown, after a signal or once a subsystem stops the relay, runs in
+// reverse start order: each subsystem's context is cancelled only after
+// the ones started later have returned, or after its stop timeout (default
+// defaultStopTimeout). The listener therefore stops first, and the pool
+// drains while the HTTP server still answers /readyz. Each subsystem's
+// state ("running", "restarting", "stopped", "failed") and its restarts
+// and failures are published under "subsystems" on /debug/vars.
+type restartPolicy int
+
+const (
+	restartOnFailure restartPolicy = iota
+	runOnce
+	stopRunner
+)
+
+const (
+	defaultStopTimeout = 10 * time.Second
+	restartBackoffMin  = time.Second
+	restartBackoffMax  = time.Minute
+	// restartBackoffReset is how long a restarted subsystem must run
+	// before its backoff starts over.
+	restartBackoffReset = 5 * time.Minute
+)
+
+var (
+	metricSubsystems = expvar.NewMap("subsystems")
+
+	errStopTimeout = errors.New("did not stop in time")
+	// errRunnerStopped ends the run when a stopRunner subsystem returns
+	// without an error.
+	errRunnerStopped = errors.New("stopped the relay")
+)
+
+type subsystem struct {
+	name   string
+	policy restartPolicy
+	run    func(ctx context.Context) error
+	// stopTimeout bounds the wait for run to return once its context is
+	// cancelled; 0 means defaultStopTimeout.
+	stopTimeout time.Duration
+}
+
+// Runner starts subsystems in the order they were added and stops them in
+// reverse.
+type Runner struct {
+	subsystems []subsystem
+	states     map[string]*expvar.String
+	// sleep waits between restarts; tests replace it.
+	sleep func(ctx context.Context, d time.Duration)
+}
+
+func NewRunner() *Runner {
+	return &Runner{states: make(map[string]*expvar.String), sleep: sleepContext}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// Add registers a subsystem; it must be called before Run.
+func (r *Runner) Add(s subsystem) {
+	if s.stopTimeout <= 0 {
+		s.stopTimeout = defaultStopTimeout
+	}
+	r.subsystems = append(r.subsystems, s)
+	state := new(expvar.String)
+	r.states[s.name] = state
+	metricSubsystems.Set(s.name, state)
+}
+
+// loop adapts a function that runs until its context ends.
+func loop(f func(ctx context.Context)) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		f(ctx)
+		return nil
+	}
+}
+
+func (r *Runner) setState(name, state string) {
+	r.states[name].Set(state)
+}
+
+// Run starts every subsystem and returns once all have stopped, with the
+// error of the first one that failed.
+func (r *Runner) Run(ctx context.Context) error {
+	g, stopping := errgroup.WithContext(ctx)
+	cancels := make([]context.CancelFunc, len(r.subsystems))
+	done := make([]chan struct{}, len(r.subsystems))
+	var finished sync.WaitGroup
+	for i, s := range r.subsystems {
+		sctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		cancels[i], done[i] = cancel, make(chan struct{})
+		finished.Add(1)
+		g.Go(func() error {
+			defer finished.Done()
+			defer close(done[i])
+			return r.supervise(sctx, s)
+		})
+	}
+	allDone := make(chan struct{})
+	go func() {
+		finished.Wait()
+		close(allDone)
+	}()
+	g.Go(func() error {
+		select {
+		case <-stopping.Done():
+		case <-allDone:
+		}
+		for i := len(r.subsystems) - 1; i >= 0; i-- {
+			cancels[i]()
+			<-done[i]
+		}
+		return nil
+	})
+	err := g.Wait()
+	if errors.Is(err, errRunnerStopped) {
+		return nil
+	}
+	return err
+}
+
+// supervise runs s until its context is cancelled, applying its restart
+// policy when it returns early.
+func (r *Runner) supervise(ctx context.Context, s subsystem) error {
+	backoff := restartBackoffMin
+	for {
+		r.setState(s.name, "running")
+		started := time.Now()
+		err := r.runOnce(ctx, s)
+		if ctx.Err() != nil {
+			r.setState(s.name, "stopped")
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("Subsystem stopped with error", "subsystem", s.name, "err", err)
+			}
+			return nil
+		}
+		if err != nil {
+			metricSubsystems.Add(s.name+"_failures", 1)
+			slog.Error("Subsystem failed", "subsystem", s.name, "err", err)
+		}
+		switch {
+		case s.policy == stopRunner:
+			r.setState(s.name, "stopped")
+			if err != nil {
+				return fmt.Errorf("%s: %w", s.name, err)
+			}
+			slog.Warn("Subsystem exited; stopping", "subsystem", s.name)
+			return fmt.Errorf("%s %w", s.name, errRunnerStopped)
+		case err == nil:
+			r.setState(s.name, "stopped")
+			return nil
+		case s.policy == runOnce:
+			r.setState(s.name, "failed")
+			return fmt.Errorf("%s: %w", s.name, err)
+		}
+		if time.Since(started) > restartBackoffReset {
+			backoff = restartBackoffMin
+		}
+		r.setState(s.name, "restarting")
+		metricSubsystems.Add(s.name+"_restarts", 1)
+		slog.Info("Restarting subsystem", "subsystem", s.name, "backoff", backoff)
+		r.sleep(ctx, backoff)
+		backoff = min(2*backoff, restartBackoffMax)
+	}
+}
+
+// runOnce calls s.run, recovering a panic as an error, and gives up
+// waiting stopTimeout after ctx is cancelled.
+func (r *Runner) runOnce(ctx context.Context, s subsystem) error {
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				result <- fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+			}
+		}()
+		result <- s.run(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+	}
+	t := time.NewTimer(s.stopTimeout)
+	defer t.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-t.C:
+		metricSubsystems.Add(s.name+"_stop_timeouts", 1)
+		return fmt.Errorf("%w after %s", errStopTimeout, s.stopTimeout)
+	}
+}
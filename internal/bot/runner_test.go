@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRunner() *Runner {
+	r := NewRunner()
+	r.sleep = func(context.Context, time.Duration) {}
+	return r
+}
+
+func TestRunner_StopsInReverseOrder(t *testing.T) {
+	r := newTestRunner()
+	var mu sync.Mutex
+	var stopped []string
+	for _, name := range []string{"test_first", "test_second", "test_third"} {
+		r.Add(subsystem{name: name, policy: runOnce, run: loop(func(ctx context.Context) {
+			<-ctx.Done()
+			mu.Lock()
+			stopped = append(stopped, name)
+			mu.Unlock()
+		})})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- r.Run(ctx) }()
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("Run returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+	want := []string{"test_third", "test_second", "test_first"}
+	for i := range want {
+		if i >= len(stopped) || stopped[i] != want[i] {
+			t.Fatalf("stop order %v, want %v", stopped, want)
+		}
+	}
+}
+
+func TestRunner_RestartsAfterPanic(t *testing.T) {
+	r := newTestRunner()
+	var calls int
+	r.Add(subsystem{name: "test_panicky", run: func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			panic("boom")
+		}
+		return nil
+	}})
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("ran %d times, want 3", calls)
+	}
+	if got := r.states["test_panicky"].Value(); got != "stopped" {
+		t.Errorf("state %q, want stopped", got)
+	}
+}
+
+func TestRunner_StopRunnerEndsRun(t *testing.T) {
+	r := newTestRunner()
+	var drained bool
+	r.Add(subsystem{name: "test_pool", policy: runOnce, run: loop(func(ctx context.Context) {
+		<-ctx.Done()
+		drained = true
+	})})
+	r.Add(subsystem{name: "test_listener", policy: stopRunner, run: func(ctx context.Context) error {
+		return nil
+	}})
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+	if !drained {
+		t.Error("earlier subsystem not stopped")
+	}
+}
+
+func TestRunner_RunOnceFailureStopsRelay(t *testing.T) {
+	r := newTestRunner()
+	r.Add(subsystem{name: "test_watch", run: loop(func(ctx context.Context) { <-ctx.Done() })})
+	failure := errors.New("listen: address in use")
+	r.Add(subsystem{name: "test_http", policy: runOnce, run: func(ctx context.Context) error {
+		return failure
+	}})
+	err := r.Run(context.Background())
+	if !errors.Is(err, failure) {
+		t.Fatalf("Run returned %v, want %v", err, failure)
+	}
+	if got := r.states["test_http"].Value(); got != "failed" {
+		t.Errorf("state %q, want failed", got)
+	}
+}
+
+func TestRunner_StopTimeout(t *testing.T) {
+	r := newTestRunner()
+	block := make(chan struct{})
+	defer close(block)
+	r.Add(subsystem{name: "test_stuck", policy: runOnce, stopTimeout: 10 * time.Millisecond, run: loop(func(ctx context.Context) {
+		<-block
+	})})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run waited past the stop timeout")
+	}
+}
@@ -0,0 +1,235 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Archive Search
+//
+// "@bot search <terms>" looks through past answers and the FAQ_FILE
+// entries and replies, visible only to the asker, with the best matches and
+// permalinks to them. Questions and answers are ranked with BM25. Past
+// answers come from the persistent index when SEARCH_INDEX_FILE is set (see
+// Search Index); otherwise the in-memory conversation store is scanned at
+// query time, the same way FAQ matching scans it, and search only reaches
+// back as far as the store does. Answers from other channels are only shown
+// when those channels are public, and redacted answers are never shown.
+// Searches are counted under "archive_search" on /debug/vars.
+const (
+	maxSearchResults  = 5
+	searchSnippetSize = 200
+
+	// BM25 parameters, at their usual values.
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var (
+	metricSearch = expvar.NewMap("archive_search")
+
+	searchStopWords = map[string]bool{
+		"a": true, "an": true, "and": true, "are": true, "do": true, "does": true, "for": true, "how": true,
+		"i": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true, "the": true,
+		"to": true, "we": true, "what": true, "with": true,
+	}
+)
+
+// searchTerms splits text into lowercase words, without stop words.
+func searchTerms(text string) []string {
+	var terms []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !searchStopWords[w] {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// searchDoc is one searchable question and answer.
+type searchDoc struct {
+	Question string
+	Answer   string
+	// Record is nil for FAQ entries.
+	Record *ConversationRecord
+
+	terms map[string]int
+	size  int
+}
+
+type searchHit struct {
+	Doc   *searchDoc
+	Score float64
+}
+
+// rankDocs scores docs against query with BM25 and returns the matches,
+// best first.
+func rankDocs(docs []*searchDoc, query string) []searchHit {
+	terms := searchTerms(query)
+	if len(terms) == 0 || len(docs) == 0 {
+		return nil
+	}
+	total := 0
+	df := map[string]int{}
+	for _, d := range docs {
+		words := searchTerms(d.Question + " " + d.Answer)
+		d.terms, d.size = map[string]int{}, len(words)
+		for _, w := range words {
+			if d.terms[w]++; d.terms[w] == 1 {
+				df[w]++
+			}
+		}
+		total += d.size
+	}
+	avg := float64(total) / float64(len(docs))
+	var hits []searchHit
+	for _, d := range docs {
+		score := 0.0
+		for _, t := range terms {
+			tf := float64(d.terms[t])
+			if tf == 0 {
+				continue
+			}
+			idf := math.Log(1 + (float64(len(docs))-float64(df[t])+0.5)/(float64(df[t])+0.5))
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(d.size)/max(avg, 1)))
+		}
+		if score > 0 {
+			hits = append(hits, searchHit{Doc: d, Score: score})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	return hits
+}
+
+// faqDocs returns the FAQ entries as search documents.
+func faqDocs() []*searchDoc {
+	faqs.mu.RLock()
+	defer faqs.mu.RUnlock()
+	var docs []*searchDoc
+	for _, e := range faqs.entries {
+		docs = append(docs, &searchDoc{Question: e.Question, Answer: e.Answer})
+	}
+	return docs
+}
+
+// searchVisibility reports whether answers from a channel may be shown in
+// channel: they may from channel itself and from public channels.
+func searchVisibility(ctx context.Context, api SlackClient, channel string) func(string) bool {
+	visible := map[string]bool{channel: true}
+	return func(from string) bool {
+		shown, checked := visible[from]
+		if !checked {
+			info, ok := lookupChannelInfo(ctx, api, from)
+			shown = ok && !info.private
+			visible[from] = shown
+		}
+		return shown
+	}
+}
+
+// searchableDocs returns the FAQ entries and the answers channel may see.
+func searchableDocs(ctx context.Context, api SlackClient, channel string) []*searchDoc {
+	docs := faqDocs()
+	visible := searchVisibility(ctx, api, channel)
+	for _, rec := range conversations.All() {
+		if rec.Redacted || rec.Withdrawn || len(rec.Answer) == 0 || len(rec.MessageTS) == 0 {
+			continue
+		}
+		if visible(rec.Channel) {
+			docs = append(docs, &searchDoc{Question: rec.Query, Answer: rec.AnswerText(), Record: &rec})
+		}
+	}
+	return docs
+}
+
+// searchArchive returns the FAQ entries and past answers matching terms that
+// channel may see, best first.
+func searchArchive(ctx context.Context, api SlackClient, channel, terms string) []searchHit {
+	if searchIndex == nil {
+		return rankDocs(searchableDocs(ctx, api, channel), terms)
+	}
+	hits := rankDocs(faqDocs(), terms)
+	indexed, err := searchIndex.search(ctx, terms, maxIndexCandidates)
+	if err != nil {
+		metricSearch.Add("index_errors", 1)
+		slog.ErrorContext(ctx, "Failed to query the search index", "err", err)
+	}
+	visible := searchVisibility(ctx, api, channel)
+	for _, hit := range indexed {
+		if visible(hit.Doc.Record.Channel) {
+			hits = append(hits, hit)
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	return hits
+}
+
+// parseSearchRequest returns the terms of a "search <terms>" mention.
+func parseSearchRequest(query string) (string, bool) {
+	word, terms, _ := strings.Cut(strings.TrimSpace(query), " ")
+	if !strings.EqualFold(word, "search") {
+		return "", false
+	}
+	return strings.TrimSpace(terms), true
+}
+
+// handleSearch answers a "search <terms>" mention, reporting whether it was
+// one.
+func handleSearch(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, query string) bool {
+	terms, ok := parseSearchRequest(query)
+	if !ok {
+		return false
+	}
+	ctx, span := otel.Tracer("bot").Start(ctx, "archive_search")
+	defer span.End()
+	span.SetAttributes(attribute.String("user.id", ev.User), attribute.String("search.terms", terms))
+	opts := []slack.MsgOption{slack.MsgOptionPostEphemeral(ev.User)}
+	if ev.ThreadTimeStamp != "" {
+		opts = append(opts, slack.MsgOptionTS(ev.ThreadTimeStamp))
+	}
+	if len(searchTerms(terms)) == 0 {
+		sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: "Tell me what to look for, e.g. `search vpn certificate`."}, opts...)
+		return true
+	}
+
+	metricSearch.Add("searches", 1)
+	hits := searchArchive(ctx, api, ev.Channel, terms)
+	span.SetAttributes(attribute.Int("search.hits", len(hits)))
+	if len(hits) == 0 {
+		metricSearch.Add("no_results", 1)
+		sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: fmt.Sprintf(":mag: No past answers match _%s_.", terms)}, opts...)
+		return true
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, ":mag: Past answers matching _%s_:\n", terms)
+	for _, hit := range hits[:min(len(hits), maxSearchResults)] {
+		fmt.Fprintf(&b, "\n• *%s*", truncate(strings.Join(strings.Fields(hit.Doc.Question), " "), 120))
+		if rec := hit.Doc.Record; rec != nil {
+			if link, err := api.GetPermalinkContext(ctx, &slack.PermalinkParameters{Channel: rec.Channel, Ts: rec.MessageTS[0]}); err == nil {
+				fmt.Fprintf(&b, " (<%s|%s>)", link, rec.CreatedAt.Format(time.DateOnly))
+			}
+		} else {
+			b.WriteString(" (FAQ)")
+		}
+		fmt.Fprintf(&b, "\n> %s", truncate(strings.Join(strings.Fields(hit.Doc.Answer), " "), searchSnippetSize))
+	}
+	if len(hits) > maxSearchResults {
+		fmt.Fprintf(&b, "\n\n_%d more matches; add terms to narrow it down._", len(hits)-maxSearchResults)
+	}
+	sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: b.String()}, opts...)
+	return true
+}
@@ -0,0 +1,75 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestRankDocs_PrefersRareTerms(t *testing.T) {
+	docs := []*searchDoc{
+		{Question: "How do I reset my password?", Answer: "Use the password reset page."},
+		{Question: "VPN certificate expired", Answer: "Renew the VPN certificate from the portal."},
+		{Question: "VPN is slow", Answer: "Try another VPN region."},
+	}
+	hits := rankDocs(docs, "vpn certificate")
+	if len(hits) != 2 || hits[0].Doc != docs[1] {
+		t.Fatalf("hits = %+v, want the certificate answer first", hits)
+	}
+	if hits := rankDocs(docs, "the how"); hits != nil {
+		t.Errorf("stop words alone should not match, got %+v", hits)
+	}
+}
+
+func TestParseSearchRequest(t *testing.T) {
+	if terms, ok := parseSearchRequest("Search  vpn certificate "); !ok || terms != "vpn certificate" {
+		t.Errorf("got %q, %v", terms, ok)
+	}
+	if _, ok := parseSearchRequest("searching for answers"); ok {
+		t.Error("only the word search should match")
+	}
+}
+
+func TestHandleSearch_ListsVisibleAnswers(t *testing.T) {
+	original := conversations
+	conversations = NewConversationStore()
+	defer func() { conversations = original }()
+	channelInfoCache.Store("CSECRET", channelInfo{private: true, fetched: time.Now()})
+	defer channelInfoCache.Delete("CSECRET")
+
+	conversations.Save(&ConversationRecord{ID: "r1", Channel: "CPUB", Query: "VPN certificate expired", Answer: []string{"Renew it from the portal."}, MessageTS: []string{"1.000100"}})
+	conversations.Save(&ConversationRecord{ID: "r2", Channel: "CSECRET", Query: "VPN certificate for the secret project", Answer: []string{"Ask the security team."}, MessageTS: []string{"2.000100"}})
+	conversations.Save(&ConversationRecord{ID: "r3", Channel: "CPUB", Query: "VPN certificate leak", Redacted: true, MessageTS: []string{"3.000100"}})
+
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{Channel: "CHERE", User: "U1"}
+	if !handleSearch(context.Background(), api, ev, "search vpn certificate") {
+		t.Fatal("search mention not handled")
+	}
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Values.Get("user") != "U1" {
+		t.Fatalf("expected one ephemeral reply, got %v", posts)
+	}
+	text := posts[0].Text()
+	if !strings.Contains(text, "Renew it from the portal.") || !strings.Contains(text, "https://example.slack.com/archives/CPUB/p1000100") {
+		t.Errorf("public answer or its permalink missing: %q", text)
+	}
+	if strings.Contains(text, "security team") || strings.Contains(text, "leak") {
+		t.Errorf("private or redacted answer shown: %q", text)
+	}
+}
+
+func TestHandleSearch_NoResults(t *testing.T) {
+	original := conversations
+	conversations = NewConversationStore()
+	defer func() { conversations = original }()
+
+	api := &fakeSlackClient{}
+	handleSearch(context.Background(), api, slackevents.AppMentionEvent{Channel: "C1", User: "U1"}, "search kubernetes")
+	if posts := api.sent(); len(posts) != 1 || !strings.Contains(posts[0].Text(), "No past answers") {
+		t.Errorf("expected a no-results reply, got %v", posts)
+	}
+}
@@ -0,0 +1,223 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Search Index
+//
+// With SEARCH_INDEX_FILE set, answers are also written to an SQLite
+// database at that path, in an FTS4 full-text table, and archive search
+// queries it instead of scanning the conversation store. The index survives
+// restarts and is not bounded by CONVERSATION_TTL or CONVERSATION_MAX, so
+// search covers every answer given since it was created. Edited answers are
+// indexed again, and redacted and withdrawn ones are deleted from it.
+// Matches are ranked inside SQLite with the same BM25 formula as the
+// in-memory search, computed from FTS4's matchinfo statistics, and the best
+// maxIndexCandidates are read back before the channel visibility check.
+// Indexed and removed answers and failed writes are counted under
+// "search_index" on /debug/vars. The SQLite driver uses cgo.
+const (
+	maxIndexCandidates = 50
+	searchIndexDriver  = "sqlite3_search"
+)
+
+var (
+	metricSearchIndex = expvar.NewMap("search_index")
+
+	// searchIndex is nil unless SEARCH_INDEX_FILE is set.
+	searchIndex *answerIndex
+)
+
+func init() {
+	sql.Register(searchIndexDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("relay_bm25", matchinfoBM25, true)
+		},
+	})
+}
+
+// answerIndex keeps one row per answer in answers, and its question and
+// answer text in the answer_text full-text table under the same rowid.
+type answerIndex struct {
+	db *sql.DB
+}
+
+func openAnswerIndex(path string) (*answerIndex, error) {
+	db, err := sql.Open(searchIndexDriver, "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS answers (id TEXT PRIMARY KEY, channel TEXT, user TEXT, message_ts TEXT, created_at INTEGER, question TEXT, answer TEXT)`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS answer_text USING fts4(body, tokenize=unicode61)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("create search index in %s: %w", path, err)
+		}
+	}
+	return &answerIndex{db: db}, nil
+}
+
+func (x *answerIndex) close() error {
+	return x.db.Close()
+}
+
+// add indexes rec, replacing any earlier version of it. Answers without a
+// posted message have nothing to link to and are skipped.
+func (x *answerIndex) add(ctx context.Context, rec *ConversationRecord) {
+	if x == nil || len(rec.MessageTS) == 0 || len(rec.Answer) == 0 {
+		return
+	}
+	err := x.write(ctx, func(tx *sql.Tx) error {
+		if err := deleteIndexed(ctx, tx, rec.ID); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `INSERT INTO answers (id, channel, user, message_ts, created_at, question, answer) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			rec.ID, rec.Channel, rec.User, rec.MessageTS[0], rec.CreatedAt.Unix(), rec.Query, rec.AnswerText())
+		if err != nil {
+			return err
+		}
+		rowid, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO answer_text (docid, body) VALUES (?, ?)`, rowid, rec.Query+"\n"+rec.AnswerText())
+		return err
+	})
+	if err != nil {
+		metricSearchIndex.Add("errors", 1)
+		slog.ErrorContext(ctx, "Failed to index answer", "conversation", rec.ID, "err", err)
+		return
+	}
+	metricSearchIndex.Add("indexed", 1)
+}
+
+// remove deletes the answer of conversation id from the index.
+func (x *answerIndex) remove(ctx context.Context, id string) {
+	if x == nil {
+		return
+	}
+	if err := x.write(ctx, func(tx *sql.Tx) error { return deleteIndexed(ctx, tx, id) }); err != nil {
+		metricSearchIndex.Add("errors", 1)
+		slog.ErrorContext(ctx, "Failed to remove answer from the search index", "conversation", id, "err", err)
+		return
+	}
+	metricSearchIndex.Add("removed", 1)
+}
+
+func (x *answerIndex) write(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := x.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteIndexed(ctx context.Context, tx *sql.Tx, id string) error {
+	var rowid int64
+	switch err := tx.QueryRowContext(ctx, `SELECT rowid FROM answers WHERE id = ?`, id).Scan(&rowid); err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil
+	default:
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM answer_text WHERE docid = ?`, rowid); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM answers WHERE rowid = ?`, rowid)
+	return err
+}
+
+// search returns up to limit indexed answers matching query, best first.
+func (x *answerIndex) search(ctx context.Context, query string, limit int) ([]searchHit, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	// searchTerms leaves only letters and digits, so quoting is enough to
+	// keep terms from being read as FTS operators.
+	phrases := make([]string, len(terms))
+	for i, t := range terms {
+		phrases[i] = `"` + t + `"`
+	}
+	rows, err := x.db.QueryContext(ctx, `SELECT a.id, a.channel, a.user, a.message_ts, a.created_at, a.question, a.answer,
+		relay_bm25(matchinfo(answer_text, 'pcnalx')) AS score
+		FROM answer_text JOIN answers a ON a.rowid = answer_text.docid
+		WHERE answer_text MATCH ? ORDER BY score DESC LIMIT ?`, strings.Join(phrases, " OR "), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hits []searchHit
+	for rows.Next() {
+		var (
+			rec             ConversationRecord
+			messageTS, text string
+			created         int64
+			score           float64
+		)
+		if err := rows.Scan(&rec.ID, &rec.Channel, &rec.User, &messageTS, &created, &rec.Query, &text, &score); err != nil {
+			return nil, err
+		}
+		rec.MessageTS, rec.Answer, rec.CreatedAt = []string{messageTS}, []string{text}, time.Unix(created, 0)
+		hits = append(hits, searchHit{Doc: &searchDoc{Question: rec.Query, Answer: text, Record: &rec}, Score: score})
+	}
+	return hits, rows.Err()
+}
+
+// matchinfoBM25 scores one row from FTS4's matchinfo 'pcnalx' blob for a
+// one-column table: phrase count, column count, row count, average and
+// row token counts, then per phrase the hits in this row, in all rows, and
+// the rows with a hit.
+func matchinfoBM25(info []byte) float64 {
+	v := make([]uint32, len(info)/4)
+	for i := range v {
+		v[i] = binary.NativeEndian.Uint32(info[4*i:])
+	}
+	if len(v) < 5 || v[1] != 1 || len(v) < 5+3*int(v[0]) {
+		return 0
+	}
+	phrases, rows, avg, size := int(v[0]), float64(v[2]), float64(v[3]), float64(v[4])
+	score := 0.0
+	for i := 0; i < phrases; i++ {
+		tf, df := float64(v[5+3*i]), float64(v[5+3*i+2])
+		if tf == 0 {
+			continue
+		}
+		idf := math.Log(1 + (rows-df+0.5)/(df+0.5))
+		score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*size/max(avg, 1)))
+	}
+	return score
+}
+
+// configureSearchIndex opens SEARCH_INDEX_FILE.
+func configureSearchIndex() error {
+	path := os.Getenv("SEARCH_INDEX_FILE")
+	if path == "" {
+		return nil
+	}
+	x, err := openAnswerIndex(path)
+	if err != nil {
+		return fmt.Errorf("invalid SEARCH_INDEX_FILE: %w", err)
+	}
+	searchIndex = x
+	return nil
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestAnswerIndex_RanksAndPersists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "search.db")
+	x, err := openAnswerIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	x.add(ctx, &ConversationRecord{ID: "r1", Channel: "C1", Query: "VPN is slow", Answer: []string{"Try another VPN region."}, MessageTS: []string{"1.000100"}, CreatedAt: created})
+	x.add(ctx, &ConversationRecord{ID: "r2", Channel: "C2", Query: "VPN certificate expired", Answer: []string{"Renew the VPN certificate from the portal."}, MessageTS: []string{"2.000100"}, CreatedAt: created})
+	x.add(ctx, &ConversationRecord{ID: "r3", Channel: "C1", Query: "Reset my password", Answer: []string{"Use the reset page."}, MessageTS: []string{"3.000100"}, CreatedAt: created})
+	x.add(ctx, &ConversationRecord{ID: "r4", Channel: "C1", Query: "VPN certificate draft", Answer: []string{"Never posted."}})
+	// Indexing an edited answer replaces the old text.
+	x.add(ctx, &ConversationRecord{ID: "r3", Channel: "C1", Query: "Reset my password", Answer: []string{"Use the account portal."}, MessageTS: []string{"3.000100"}, CreatedAt: created})
+	if err := x.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	x, err = openAnswerIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.close()
+	hits, err := x.search(ctx, "vpn certificate", maxIndexCandidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || hits[0].Doc.Record.ID != "r2" || hits[1].Doc.Record.ID != "r1" {
+		t.Fatalf("hits = %+v, want r2 then r1", hits)
+	}
+	rec := hits[0].Doc.Record
+	if rec.Channel != "C2" || rec.MessageTS[0] != "2.000100" || !rec.CreatedAt.Equal(created) || hits[0].Doc.Answer != "Renew the VPN certificate from the portal." {
+		t.Errorf("stored record not read back: %+v", rec)
+	}
+	if hits, _ := x.search(ctx, "reset page", maxIndexCandidates); len(hits) != 1 || hits[0].Doc.Answer != "Use the account portal." {
+		t.Errorf("edited answer not re-indexed: %+v", hits)
+	}
+
+	x.remove(ctx, "r2")
+	if hits, _ := x.search(ctx, "certificate", maxIndexCandidates); len(hits) != 0 {
+		t.Errorf("removed answer still found: %+v", hits)
+	}
+}
+
+func TestHandleSearch_UsesIndex(t *testing.T) {
+	x, err := openAnswerIndex(filepath.Join(t.TempDir(), "search.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.close()
+	defer func(old *answerIndex) { searchIndex = old }(searchIndex)
+	searchIndex = x
+	defer func(old *ConversationStore) { conversations = old }(conversations)
+	conversations = NewConversationStore()
+	channelInfoCache.Store("CSECRET", channelInfo{private: true, fetched: time.Now()})
+	defer channelInfoCache.Delete("CSECRET")
+
+	// Neither answer is in the conversation store any more.
+	ctx := context.Background()
+	x.add(ctx, &ConversationRecord{ID: "r1", Channel: "CPUB", Query: "VPN certificate expired", Answer: []string{"Renew it from the portal."}, MessageTS: []string{"1.000100"}})
+	x.add(ctx, &ConversationRecord{ID: "r2", Channel: "CSECRET", Query: "VPN certificate for the secret project", Answer: []string{"Ask the security team."}, MessageTS: []string{"2.000100"}})
+
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{Channel: "CHERE", User: "U1"}
+	if !handleSearch(ctx, api, ev, "search vpn certificate") {
+		t.Fatal("search mention not handled")
+	}
+	posts := api.sent()
+	if len(posts) != 1 {
+		t.Fatalf("expected one reply, got %v", posts)
+	}
+	text := posts[0].Text()
+	if !strings.Contains(text, "Renew it from the portal.") || !strings.Contains(text, "https://example.slack.com/archives/CPUB/p1000100") {
+		t.Errorf("indexed answer or its permalink missing: %q", text)
+	}
+	if strings.Contains(text, "security team") {
+		t.Errorf("private channel answer shown: %q", text)
+	}
+}
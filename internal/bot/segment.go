@@ -0,0 +1,298 @@
+package bot
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Sentence Segmentation
+//
+// Non-streaming answers are posted a sentence at a time. Sentences end at
+// terminal punctuation (including CJK, Devanagari and Arabic forms) followed
+// by a space or a line break, or at a blank line; abbreviations, initials
+// and decimals do not end a sentence. Fenced code blocks are never split
+// into sentences. A chunk longer than FALLBACK_CHUNK_SIZE bytes is broken
+// at whitespace, or failing that between grapheme clusters, and a long code
+// block is broken between lines with each piece re-fenced.
+const defaultFallbackChunkSize = 3000
+
+var sentenceTerminators = map[rune]bool{
+	'.': true, '!': true, '?': true, '…': true,
+	'。': true, '！': true, '？': true, '．': true,
+	'।': true, '॥': true, '؟': true, '۔': true,
+}
+
+// fullWidthTerminators end a sentence without a following space.
+var fullWidthTerminators = map[rune]bool{'。': true, '！': true, '？': true, '．': true}
+
+var sentenceClosers = map[rune]bool{'"': true, '\'': true, ')': true, ']': true, '”': true, '’': true, '」': true, '』': true, '）': true, '*': true, '_': true}
+
+var abbreviations = map[string]bool{
+	"e.g": true, "i.e": true, "etc": true, "vs": true, "mr": true, "mrs": true, "ms": true,
+	"dr": true, "prof": true, "st": true, "no": true, "fig": true, "jr": true, "sr": true,
+	"inc": true, "ltd": true, "co": true, "approx": true, "cf": true, "al": true, "u.s": true,
+}
+
+// segmentAnswer splits text into postable chunks of at most maxBytes.
+func segmentAnswer(text string, maxBytes int) []string {
+	if maxBytes <= 0 {
+		maxBytes = defaultFallbackChunkSize
+	}
+	var chunks []string
+	for _, part := range splitCodeFences(text) {
+		if strings.HasPrefix(part, "```") {
+			chunks = append(chunks, splitCodeBlock(part, maxBytes)...)
+			continue
+		}
+		for _, sentence := range splitSentences(part) {
+			chunks = append(chunks, splitLong(sentence, maxBytes)...)
+		}
+	}
+	return chunks
+}
+
+// splitCodeFences separates fenced code blocks from the prose around them.
+// An unterminated fence runs to the end of the text.
+func splitCodeFences(text string) []string {
+	var parts []string
+	for {
+		start := strings.Index(text, "```")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(text[start+3:], "```")
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += start + 6
+		}
+		if start > 0 {
+			parts = append(parts, text[:start])
+		}
+		parts = append(parts, text[start:end])
+		text = text[end:]
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+func splitSentences(text string) []string {
+	var out []string
+	emit := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	start := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		next := i + size
+		if r == '\n' && strings.HasPrefix(text[next:], "\n") {
+			emit(text[start:i])
+			start = next
+			i = next
+			continue
+		}
+		if !sentenceTerminators[r] {
+			i = next
+			continue
+		}
+		// Runs of terminators ("?!", "...") and closing quotes stay with
+		// the sentence.
+		end := next
+		for end < len(text) {
+			c, n := utf8.DecodeRuneInString(text[end:])
+			if !sentenceTerminators[c] && !sentenceClosers[c] {
+				break
+			}
+			end += n
+		}
+		if isSentenceEnd(text, start, i, r, end) {
+			emit(text[start:end])
+			start = end
+		}
+		i = end
+	}
+	emit(text[start:])
+	return out
+}
+
+// isSentenceEnd decides whether the terminator r at i, whose run ends at
+// end, closes the sentence that began at start.
+func isSentenceEnd(text string, start, i int, r rune, end int) bool {
+	if end >= len(text) {
+		return true
+	}
+	following, _ := utf8.DecodeRuneInString(text[end:])
+	if fullWidthTerminators[r] {
+		return true
+	}
+	if !unicode.IsSpace(following) {
+		return false
+	}
+	if r != '.' || end != i+1 {
+		return true
+	}
+	word := strings.ToLower(lastWord(text[start:i]))
+	if abbreviations[word] {
+		return false
+	}
+	// Initials such as "J. Smith".
+	if w, _ := utf8.DecodeRuneInString(word); utf8.RuneCountInString(word) == 1 && unicode.IsLetter(w) {
+		return false
+	}
+	// A lowercase continuation means the period did not end the sentence.
+	rest := strings.TrimLeftFunc(text[end:], unicode.IsSpace)
+	if n, _ := utf8.DecodeRuneInString(rest); unicode.IsLower(n) && !strings.Contains(text[end:len(text)-len(rest)], "\n") {
+		return false
+	}
+	return true
+}
+
+func lastWord(s string) string {
+	s = strings.TrimRightFunc(s, unicode.IsSpace)
+	i := strings.LastIndexFunc(s, func(r rune) bool { return unicode.IsSpace(r) || r == '(' || r == '"' })
+	return s[i+1:]
+}
+
+// splitLong breaks s into pieces of at most maxBytes, preferring
+// whitespace and never cutting a grapheme cluster; a cluster longer than
+// maxBytes becomes a piece of its own.
+func splitLong(s string, maxBytes int) []string {
+	var out []string
+	for len(s) > maxBytes {
+		cut := -1
+		for i := maxBytes; i > 0; i-- {
+			if isGraphemeBoundary(s, i) {
+				if r, _ := utf8.DecodeLastRuneInString(s[:i]); unicode.IsSpace(r) {
+					cut = i
+					break
+				}
+				if cut < 0 {
+					cut = i
+				}
+			}
+		}
+		if cut <= 0 {
+			cut = nextGraphemeBoundary(s, maxBytes)
+		}
+		if piece := strings.TrimSpace(s[:cut]); piece != "" {
+			out = append(out, piece)
+		}
+		s = strings.TrimLeftFunc(s[cut:], unicode.IsSpace)
+	}
+	if s = strings.TrimSpace(s); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// isGraphemeBoundary approximates the Unicode rules closely enough for
+// chat text: no split inside a rune, before a combining mark, variation
+// selector, emoji modifier or zero-width joiner, after a joiner, or
+// between the two halves of a flag.
+func isGraphemeBoundary(s string, i int) bool {
+	if i <= 0 || i >= len(s) {
+		return true
+	}
+	if !utf8.RuneStart(s[i]) {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(s[i:])
+	prev, _ := utf8.DecodeLastRuneInString(s[:i])
+	switch {
+	case unicode.In(next, unicode.Mn, unicode.Me, unicode.Mc):
+		return false
+	case next == '‍' || prev == '‍':
+		return false
+	case next >= 0xFE00 && next <= 0xFE0F, next >= 0xE0020 && next <= 0xE007F:
+		return false
+	case next >= 0x1F3FB && next <= 0x1F3FF:
+		return false
+	case prev == '\r' && next == '\n':
+		return false
+	case isRegionalIndicator(prev) && isRegionalIndicator(next):
+		// Flags are pairs: split only after an even number of indicators.
+		n := 0
+		for j := i; j > 0; {
+			r, size := utf8.DecodeLastRuneInString(s[:j])
+			if !isRegionalIndicator(r) {
+				break
+			}
+			n++
+			j -= size
+		}
+		return n%2 == 0
+	}
+	return true
+}
+
+// nextGraphemeBoundary returns the first boundary after i, used when a
+// single cluster is longer than the chunk size and has to stay whole.
+func nextGraphemeBoundary(s string, i int) int {
+	for i < len(s) && !isGraphemeBoundary(s, i) {
+		i++
+	}
+	return i
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// splitCodeBlock keeps a fenced block whole when it fits, otherwise splits
+// it between lines and fences every piece with the original info string.
+func splitCodeBlock(block string, maxBytes int) []string {
+	if len(block) <= maxBytes {
+		return []string{block}
+	}
+	header, body, _ := strings.Cut(strings.TrimPrefix(block, "```"), "\n")
+	body = strings.TrimSuffix(strings.TrimSuffix(body, "```"), "\n")
+	opening, closing := "```"+header+"\n", "\n```"
+	room := maxBytes - len(opening) - len(closing)
+	if room <= 0 {
+		room = maxBytes
+	}
+	var out []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			out = append(out, opening+cur.String()+closing)
+			cur.Reset()
+		}
+	}
+	for _, line := range strings.Split(body, "\n") {
+		for _, piece := range splitLongLine(line, room) {
+			if cur.Len() > 0 && cur.Len()+1+len(piece) > room {
+				flush()
+			}
+			if cur.Len() > 0 {
+				cur.WriteByte('\n')
+			}
+			cur.WriteString(piece)
+		}
+	}
+	flush()
+	return out
+}
+
+// splitLongLine cuts a single code line at grapheme boundaries, keeping its
+// whitespace.
+func splitLongLine(line string, maxBytes int) []string {
+	var out []string
+	for len(line) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !isGraphemeBoundary(line, cut) {
+			cut--
+		}
+		if cut == 0 {
+			cut = nextGraphemeBoundary(line, maxBytes)
+		}
+		out = append(out, line[:cut])
+		line = line[cut:]
+	}
+	return append(out, line)
+}
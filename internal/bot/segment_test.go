@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestSegmentAnswer_Sentences(t *testing.T) {
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"Use a channel, e.g. a buffered one. It takes 3.14 seconds. Ask J. Smith!", []string{"Use a channel, e.g. a buffered one.", "It takes 3.14 seconds.", "Ask J. Smith!"}},
+		{"Really?! Yes. \"Quoted.\" Done", []string{"Really?!", "Yes.", "\"Quoted.\"", "Done"}},
+		{"你好。再见。", []string{"你好。", "再见。"}},
+		{"यह पहला है। यह दूसरा है।", []string{"यह पहला है।", "यह दूसरा है।"}},
+		{"First paragraph\n\nSecond paragraph", []string{"First paragraph", "Second paragraph"}},
+		{"See v1.2.3 and example.com. Next", []string{"See v1.2.3 and example.com.", "Next"}},
+	}
+	for _, c := range cases {
+		if got := segmentAnswer(c.text, 0); strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("segmentAnswer(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestSegmentAnswer_CodeBlocks(t *testing.T) {
+	text := "Try this. ```go\nfmt.Println(\"a. b\")\nx := 1.5\n``` It works."
+	got := segmentAnswer(text, 0)
+	want := []string{"Try this.", "```go\nfmt.Println(\"a. b\")\nx := 1.5\n```", "It works."}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	var long strings.Builder
+	long.WriteString("```python\n")
+	for i := 0; i < 20; i++ {
+		long.WriteString("print('line. number')\n")
+	}
+	long.WriteString("```")
+	pieces := segmentAnswer(long.String(), 100)
+	if len(pieces) < 2 {
+		t.Fatalf("expected the block to be split, got %q", pieces)
+	}
+	for _, p := range pieces {
+		if len(p) > 100 || !strings.HasPrefix(p, "```python\n") || !strings.HasSuffix(p, "\n```") {
+			t.Errorf("bad code piece %q", p)
+		}
+	}
+}
+
+func TestSegmentAnswer_NeverSplitsGraphemes(t *testing.T) {
+	family := "👨‍👩‍👧‍👦"
+	flag := "🇯🇵"
+	accent := "é"
+	text := strings.Repeat(family+flag+accent, 40)
+	for _, max := range []int{30, 64, 100} {
+		var joined strings.Builder
+		for _, p := range segmentAnswer(text, max) {
+			if len(p) > max {
+				t.Errorf("max %d: piece of %d bytes", max, len(p))
+			}
+			if !utf8.ValidString(p) {
+				t.Errorf("max %d: piece is not valid UTF-8", max)
+			}
+			if strings.HasPrefix(p, "‍") || strings.HasPrefix(p, "́") || strings.HasSuffix(p, "‍") {
+				t.Errorf("max %d: piece %q cuts a cluster", max, p)
+			}
+			if n := strings.Count(p, "🇯") + strings.Count(p, "🇵"); n%2 != 0 {
+				t.Errorf("max %d: piece %q splits a flag", max, p)
+			}
+			joined.WriteString(p)
+		}
+		if joined.String() != text {
+			t.Errorf("max %d: pieces do not rejoin to the input", max)
+		}
+	}
+
+	// A cluster longer than the limit is posted whole rather than cut.
+	if got := segmentAnswer(family+family, 10); len(got) != 2 || got[0] != family || got[1] != family {
+		t.Errorf("oversized clusters = %q", got)
+	}
+}
+
+func TestProcessTask_JSONResponseSegmented(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Pi is approx. 3.14 here. ```\nx. y\n``` Done."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CSEG"}, "pi")
+	var texts []string
+	for _, p := range api.sent() {
+		texts = append(texts, p.Text())
+	}
+	if want := []string{"Pi is approx. 3.14 here.", "```\nx. y\n```", "Done."}; strings.Join(texts, "|") != strings.Join(want, "|") {
+		t.Errorf("posts = %q, want %q", texts, want)
+	}
+}
@@ -0,0 +1,207 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Self-test
+//
+// A canary question is sent through the full pipeline into
+// SELFTEST_CHANNEL: the bot posts a marker message there and answers the
+// canary in its thread, exactly as it would answer a user, so missing Slack
+// permissions and backend regressions both make it fail. Admins run it with
+// "!selftest", and with SELFTEST_INTERVAL set it also runs periodically.
+// The first failure after a success is reported in ADMIN_CHANNEL, as is
+// the recovery. The latest result is published under "selftest" on
+// /debug/vars.
+const (
+	defaultSelfTestQuery = "This is an automated self-test. Reply with a one-sentence confirmation that you are working."
+	selfTestTimeout      = 2 * time.Minute
+)
+
+type SelfTestResult struct {
+	At                  time.Time `json:"at"`
+	OK                  bool      `json:"ok"`
+	LatencyMS           int64     `json:"latency_ms"`
+	Error               string    `json:"error,omitempty"`
+	Runs                int64     `json:"runs"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+type selfTester struct {
+	mu       sync.Mutex
+	channel  string
+	query    string
+	interval time.Duration
+	last     SelfTestResult
+	running  bool
+}
+
+var selfTest = &selfTester{query: defaultSelfTestQuery}
+
+func init() {
+	expvar.Publish("selftest", expvar.Func(func() any { return selfTest.result() }))
+}
+
+func (s *selfTester) configure(channel, query string, interval time.Duration) {
+	if query == "" {
+		query = defaultSelfTestQuery
+	}
+	s.mu.Lock()
+	s.channel, s.query, s.interval = channel, query, interval
+	s.mu.Unlock()
+}
+
+func (s *selfTester) result() SelfTestResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+type selfTestKey struct{}
+
+// withSelfTest marks ctx as carrying the canary, which bypasses the FAQ
+// and is left out of evaluation samples.
+func withSelfTest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, selfTestKey{}, true)
+}
+
+func isSelfTest(ctx context.Context) bool {
+	v, _ := ctx.Value(selfTestKey{}).(bool)
+	return v
+}
+
+// run sends the canary as user and records the outcome. It returns false
+// without running when self-tests are not configured or one is running.
+func (s *selfTester) run(ctx context.Context, api SlackClient, user string) (SelfTestResult, bool) {
+	s.mu.Lock()
+	channel, query := s.channel, s.query
+	if channel == "" || s.running {
+		s.mu.Unlock()
+		return SelfTestResult{}, false
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(withSelfTest(ctx), selfTestTimeout)
+	defer cancel()
+	ctx, span := otel.Tracer("bot").Start(ctx, "selftest")
+	defer span.End()
+
+	started := time.Now()
+	err := s.probe(ctx, api, channel, user, query)
+	latency := time.Since(started)
+	span.SetAttributes(attribute.Bool("selftest.ok", err == nil), attribute.Int64("selftest.latency_ms", latency.Milliseconds()))
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	s.mu.Lock()
+	prev := s.last
+	res := SelfTestResult{At: started, OK: err == nil, LatencyMS: latency.Milliseconds(), Runs: prev.Runs + 1}
+	if err != nil {
+		res.Error = err.Error()
+		res.ConsecutiveFailures = prev.ConsecutiveFailures + 1
+	}
+	s.last, s.running = res, false
+	s.mu.Unlock()
+
+	if err != nil {
+		slog.ErrorContext(ctx, "Self-test failed", "latency", latency.Round(time.Millisecond), "err", err)
+	} else {
+		slog.InfoContext(ctx, "Self-test passed", "latency", latency.Round(time.Millisecond))
+	}
+	switch {
+	case err != nil && res.ConsecutiveFailures == 1:
+		alertAdmins(ctx, api, fmt.Sprintf(":rotating_light: Self-test failed in <#%s>: %v", channel, err))
+	case err == nil && prev.ConsecutiveFailures > 0:
+		alertAdmins(ctx, api, fmt.Sprintf(":white_check_mark: Self-test passing again after %d failures (%s end to end).", prev.ConsecutiveFailures, latency.Round(time.Millisecond)))
+	}
+	return res, true
+}
+
+// probe posts a marker in channel and answers query in its thread.
+func (s *selfTester) probe(ctx context.Context, api SlackClient, channel, user, query string) error {
+	ts, err := sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: fmt.Sprintf(":stethoscope: Self-test at %s: _%s_", time.Now().UTC().Format(time.RFC3339), query)})
+	if err != nil {
+		return fmt.Errorf("posting to <#%s> failed: %w", channel, err)
+	}
+	ev := slackevents.AppMentionEvent{User: user, Channel: channel, TimeStamp: ts, ThreadTimeStamp: ts}
+	rec := processTask(withRequestID(ctx), api, ev, query, slack.MsgOptionTS(ts))
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("no answer within %s", selfTestTimeout)
+	case rec == nil:
+		return errors.New("the backend did not answer (see !trace for the request)")
+	case len(rec.MessageTS) == 0:
+		return fmt.Errorf("the answer could not be posted to <#%s>", channel)
+	}
+	return nil
+}
+
+// alertAdmins posts text to ADMIN_CHANNEL, when one is set.
+func alertAdmins(ctx context.Context, api SlackClient, text string) {
+	if config.AdminChannel == "" {
+		return
+	}
+	sendMessage(ctx, api, outgoingMessage{Channel: config.AdminChannel, Text: text})
+}
+
+func runSelfTests(ctx context.Context, api SlackClient) {
+	ctx = withQuerySource(ctx, SourceScheduled)
+	selfTest.mu.Lock()
+	interval := selfTest.interval
+	selfTest.mu.Unlock()
+	if interval <= 0 {
+		return
+	}
+	user := ""
+	if len(config.AdminUsers) > 0 {
+		user = config.AdminUsers[0]
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !leadership.leads() {
+				continue
+			}
+			selfTest.run(ctx, api, user)
+		}
+	}
+}
+
+func init() {
+	registerCommand("selftest", command{
+		Admin: true,
+		Usage: "(sends a canary question through the full pipeline)",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			go func() {
+				ctx := context.WithoutCancel(ctx)
+				res, ok := selfTest.run(ctx, api, ev.User)
+				switch {
+				case !ok:
+					notifyUser(ctx, api, ev.Channel, ev.User, "Self-tests need `SELFTEST_CHANNEL`, or one is already running.")
+				case res.OK:
+					notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf(":white_check_mark: Self-test passed in %dms.", res.LatencyMS))
+				default:
+					notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf(":x: Self-test failed after %dms: %s", res.LatencyMS, res.Error))
+				}
+			}()
+		},
+	})
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+)
+
+func TestSelfTest_AlertsOnFailureAndRecovery(t *testing.T) {
+	var failing atomic.Bool
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "model not loaded", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "I am working."})
+	}))
+	defer backendServer.Close()
+	defer func(url, admin string, d time.Duration) {
+		config.BackendURL, config.AdminChannel, postInterval = url, admin, d
+	}(config.BackendURL, config.AdminChannel, postInterval)
+	config.BackendURL, config.AdminChannel, postInterval = backendServer.URL, "CADMIN", 0
+	selfTest.configure("CCANARY", "", 0)
+	defer selfTest.configure("", "", 0)
+
+	api := &fakeSlackClient{}
+	res, ok := selfTest.run(context.Background(), api, "UADMIN")
+	if !ok || !res.OK {
+		t.Fatalf("self-test should pass, got %+v", res)
+	}
+	posts := api.sent()
+	if len(posts) != 2 || posts[0].Channel != "CCANARY" || posts[1].Values.Get("thread_ts") != "1.000100" {
+		t.Fatalf("expected a marker and a threaded answer, got %+v", posts)
+	}
+
+	failing.Store(true)
+	for i := 0; i < 2; i++ {
+		if res, _ = selfTest.run(context.Background(), api, "UADMIN"); res.OK {
+			t.Fatal("self-test should fail while the backendServer fails")
+		}
+	}
+	if res.ConsecutiveFailures != 2 || res.Runs != 3 {
+		t.Errorf("result = %+v", res)
+	}
+	failing.Store(false)
+	selfTest.run(context.Background(), api, "UADMIN")
+
+	var alerts []string
+	for _, p := range api.sent() {
+		if p.Channel == "CADMIN" {
+			alerts = append(alerts, p.Text())
+		}
+	}
+	if len(alerts) != 2 || !strings.Contains(alerts[0], "Self-test failed") || !strings.Contains(alerts[1], "passing again after 2 failures") {
+		t.Errorf("expected one failure alert and one recovery, got %q", alerts)
+	}
+}
+
+func TestSelfTest_RequiresChannel(t *testing.T) {
+	selfTest.configure("", "", 0)
+	if _, ok := selfTest.run(context.Background(), &fakeSlackClient{}, "UADMIN"); ok {
+		t.Error("self-test should not run without SELFTEST_CHANNEL")
+	}
+}
@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+)
+
+// Thread Serialization
+//
+// With "serialize_threads" set, questions from the same conversation (a
+// thread, or the top level of a channel or DM) are answered one at a time in
+// arrival order so their answers cannot interleave. Later askers are told
+// how many questions are ahead of them. Waiting questions do not occupy a
+// worker: the worker that finishes one answer picks up the next.
+//
+// TASK_ORDERING applies the same ordering everywhere, without the notes:
+// "thread" runs the tasks of each conversation (answers, fixes,
+// translations, thread summaries and the like) one at a time, "channel"
+// those of each channel, and "off" (the default) leaves every task to run
+// as soon as a worker is free. Tasks of different conversations still run
+// in parallel. Tasks waiting their turn are reported as "waiting" in the
+// pool stats of !diag.
+const (
+	taskOrderingOff     = "off"
+	taskOrderingThread  = "thread"
+	taskOrderingChannel = "channel"
+)
+
+var taskOrdering = taskOrderingOff
+
+func configureTaskOrdering() error {
+	switch v := strings.ToLower(os.Getenv("TASK_ORDERING")); v {
+	case "", taskOrderingOff:
+		taskOrdering = taskOrderingOff
+	case taskOrderingThread, taskOrderingChannel:
+		taskOrdering = v
+	default:
+		return fmt.Errorf("invalid TASK_ORDERING %q: use off, thread or channel", v)
+	}
+	return nil
+}
+
+func conversationKey(channel, threadTS string) string {
+	return channel + "/" + threadTS
+}
+
+// orderKey is the pool key that keeps a task in order under TASK_ORDERING,
+// or "" when tasks are not ordered.
+func orderKey(channel, threadTS string) string {
+	switch taskOrdering {
+	case taskOrderingThread:
+		return conversationKey(channel, threadTS)
+	case taskOrderingChannel:
+		return channel
+	}
+	return ""
+}
+
+// laneKey is the pool key of a conversation that is always answered in
+// order; TASK_ORDERING=channel widens it to the channel.
+func laneKey(channel, threadTS string) string {
+	if taskOrdering == taskOrderingChannel {
+		return channel
+	}
+	return conversationKey(channel, threadTS)
+}
+
+// submitOrdered queues task on pool in order with the conversation's other
+// tasks when TASK_ORDERING asks for it, telling user if the full queue
+// turns it away.
+func submitOrdered(ctx context.Context, api SlackClient, pool *workerpool.Pool, channel, threadTS, user string, task func()) {
+	queueTask(ctx, api, pool, orderKey(channel, threadTS), channel, threadTS, user, task, nil)
+}
+
+// submitConversationTask queues task on pool, serialized per conversation
+// when the channel asks for it and held back while user is at their
+// in-flight cap, and tells the asker when they have to wait.
+func submitConversationTask(ctx context.Context, api SlackClient, pool *workerpool.Pool, channel, threadTS, user string, task func()) {
+	if isSelfTest(ctx) {
+		dispatchConversationTask(ctx, api, pool, channel, threadTS, user, task, nil)
+		return
+	}
+	start := func() {
+		dispatchConversationTask(ctx, api, pool, channel, threadTS, user, func() {
+			defer fairness.release(user)
+			task()
+		}, func() { fairness.release(user) })
+	}
+	position, ok := fairness.submit(user, start)
+	switch {
+	case !ok:
+		slog.WarnContext(ctx, "User's question queue is full", "channel", channel, "user", user)
+		requests.recordError(ctx, "user queue full")
+		notifyBusy(ctx, api, channel, threadTS, user)
+	case position > 0:
+		requests.record(ctx, "user_cap", fmt.Sprintf("waiting, number %d in the user's queue", position))
+		notifyDeferred(ctx, api, channel, threadTS, user, position, fairness.limit)
+	}
+}
+
+// dispatchConversationTask queues task; cleanup runs instead if the full
+// queue turns it away or discards it.
+func dispatchConversationTask(ctx context.Context, api SlackClient, pool *workerpool.Pool, channel, threadTS, user string, task, cleanup func()) {
+	if !channelConfigFor(channel).SerializeThreads {
+		notice := postQueueNotice(ctx, api, pool, channel, threadTS, user)
+		queueTask(ctx, api, pool, orderKey(channel, threadTS), channel, threadTS, user, func() {
+			notice.start(ctx)
+			task()
+		}, func() {
+			notice.start(ctx)
+			if cleanup != nil {
+				cleanup()
+			}
+		})
+		return
+	}
+	ahead := queueTask(ctx, api, pool, laneKey(channel, threadTS), channel, threadTS, user, task, cleanup)
+	if ahead == 0 {
+		return
+	}
+	text := fmt.Sprintf("I'm still answering earlier questions here; %d are ahead of yours.", ahead)
+	if ahead == 1 {
+		text = "I'm still answering an earlier question here; I'll get to yours right after."
+	}
+	opts := []slack.MsgOption{slack.MsgOptionPostEphemeral(user)}
+	if threadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(threadTS))
+	}
+	sendMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: text}, opts...)
+}
@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+)
+
+func TestSubmitConversationTask_TaskOrdering(t *testing.T) {
+	defer func(saved string) { taskOrdering = saved }(taskOrdering)
+	for _, tc := range []struct {
+		ordering string
+		// threads are the threads of the second and third task; the
+		// first task, in thread 1.0, blocks until released.
+		threads []string
+		// waiting is how many of them wait behind the first task.
+		waiting int
+		want    []string
+	}{
+		{taskOrderingThread, []string{"1.0", "2.0"}, 1, []string{"2.0", "1.0"}},
+		{taskOrderingChannel, []string{"1.0", "2.0"}, 2, []string{"1.0", "2.0"}},
+	} {
+		taskOrdering = tc.ordering
+		pool := workerpool.New(4)
+		release := make(chan struct{})
+		var (
+			mu  sync.Mutex
+			ran []string
+		)
+		record := func(thread string) func() {
+			return func() {
+				mu.Lock()
+				ran = append(ran, thread)
+				mu.Unlock()
+			}
+		}
+		ctx := context.Background()
+		submitConversationTask(ctx, &fakeSlackClient{}, pool, "CORD", "1.0", "U1", func() { <-release })
+		for i, thread := range tc.threads {
+			submitConversationTask(ctx, &fakeSlackClient{}, pool, "CORD", thread, fmt.Sprintf("U%d", i+2), record(thread))
+		}
+		// Tasks of other conversations run while the first one blocks.
+		deadline := time.Now().Add(time.Second)
+		for pool.Pending() > tc.waiting+1 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := pool.Stats().Waiting; got != tc.waiting {
+			t.Errorf("TASK_ORDERING=%s: %d tasks waiting, want %d", tc.ordering, got, tc.waiting)
+		}
+		close(release)
+		pool.Shutdown()
+		if strings.Join(ran, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("TASK_ORDERING=%s ran %v, want %v", tc.ordering, ran, tc.want)
+		}
+	}
+
+	t.Setenv("TASK_ORDERING", "conversation")
+	if err := configureTaskOrdering(); err == nil {
+		t.Error("invalid TASK_ORDERING accepted")
+	}
+}
+
+func TestSubmitConversationTask_TellsSecondAsker(t *testing.T) {
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CSER": {SerializeThreads: true}}})
+	defer setChannelSettings(channelSettings{})
+
+	pool := workerpool.New(2)
+	api := &fakeSlackClient{}
+	release := make(chan struct{})
+	ctx := context.Background()
+
+	submitConversationTask(ctx, api, pool, "CSER", "9.0", "U1", func() { <-release })
+	submitConversationTask(ctx, api, pool, "CSER", "9.0", "U2", func() {})
+	close(release)
+	pool.Shutdown()
+
+	if len(api.posts) != 1 {
+		t.Fatalf("expected one queue hint, got %d posts", len(api.posts))
+	}
+	hint := api.posts[0]
+	if hint.Values.Get("user") != "U2" || hint.Values.Get("thread_ts") != "9.0" {
+		t.Errorf("hint should be ephemeral to U2 in the thread, got %v", hint.Values)
+	}
+	if !strings.Contains(hint.Text(), "right after") {
+		t.Errorf("unexpected hint %q", hint.Text())
+	}
+}
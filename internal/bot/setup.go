@@ -0,0 +1,315 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Onboarding Wizard
+//
+// When the relay starts without a backend (no BACKEND_URL and nothing in
+// SETUP_FILE), it runs a first-run setup over DM instead of failing every
+// question. It checks that its tokens work and have the scopes it needs,
+// then asks an admin (the first of ADMIN_USERS, or whoever first DMs it
+// "setup" when none are configured) for the admin channel and the default
+// backend. The answers are written to SETUP_FILE and applied at once;
+// environment variables always take precedence over the file.
+const (
+	defaultSetupFile = "chatrelaybot-setup.json"
+
+	setupStepChannel = "admin_channel"
+	setupStepBackend = "backend"
+
+	setupProbeTimeout = 10 * time.Second
+)
+
+var requiredScopes = []string{"app_mentions:read", "chat:write", "im:history", "channels:read", "users:read"}
+
+// optionalScopes enable individual features and are reported, not required.
+var optionalScopes = []string{"channels:history", "groups:read", "reactions:read", "files:write", "emoji:read", "pins:write", "files:read"}
+
+// SetupState is what the wizard persists to SETUP_FILE.
+type SetupState struct {
+	AdminUsers   []string  `json:"admin_users,omitempty"`
+	AdminChannel string    `json:"admin_channel,omitempty"`
+	BackendURL   string    `json:"backend_url,omitempty"`
+	CompletedBy  string    `json:"completed_by,omitempty"`
+	CompletedAt  time.Time `json:"completed_at,omitempty"`
+}
+
+func loadSetup(path string) (SetupState, error) {
+	var state SetupState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	return state, json.Unmarshal(data, &state)
+}
+
+func saveSetup(path string, state SetupState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// applySetup fills in settings the environment left empty.
+func applySetup(state SetupState) {
+	if config.BackendURL == "" {
+		config.BackendURL = state.BackendURL
+	}
+	if len(config.AdminUsers) == 0 {
+		config.AdminUsers = state.AdminUsers
+	}
+	if config.AdminChannel == "" {
+		config.AdminChannel = state.AdminChannel
+	}
+}
+
+// missingScopes calls auth.test and compares the token's granted scopes,
+// which Slack returns in the X-OAuth-Scopes header, with the ones listed.
+func missingScopes(ctx context.Context, client *http.Client, apiURL, token string, scopes []string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"auth.test", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("auth.test: %w", err)
+	}
+	if !result.OK {
+		return nil, fmt.Errorf("auth.test: %s", result.Error)
+	}
+	granted := map[string]bool{}
+	for _, s := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		granted[strings.TrimSpace(s)] = true
+	}
+	var missing []string
+	for _, s := range scopes {
+		if !granted[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing, nil
+}
+
+type onboarding struct {
+	mu     sync.Mutex
+	path   string
+	active bool
+	admin  string
+	step   string
+	state  SetupState
+	report string
+}
+
+var setupWizard = &onboarding{}
+
+// start enters setup mode and greets the admin, if one is known. report
+// describes the token and scope checks.
+func (w *onboarding) start(ctx context.Context, api SlackClient, path, report string) {
+	w.mu.Lock()
+	w.path, w.active, w.step, w.report = path, true, setupStepChannel, report
+	if len(config.AdminUsers) > 0 {
+		w.admin = config.AdminUsers[0]
+	}
+	admin := w.admin
+	w.mu.Unlock()
+	if admin == "" {
+		slog.InfoContext(ctx, "Setup mode: no backend is configured. DM the bot \"setup\" to run the setup wizard; the first user to do so becomes the admin.")
+		return
+	}
+	slog.InfoContext(ctx, "Setup mode: no backend is configured, asking the admin to finish setup", "admin", admin)
+	w.greet(ctx, api, admin)
+}
+
+func (w *onboarding) greet(ctx context.Context, api SlackClient, admin string) {
+	w.mu.Lock()
+	report := w.report
+	w.mu.Unlock()
+	sendMessage(ctx, api, outgoingMessage{Channel: admin, User: admin, Text: ":wave: Let's get me set up. " + report +
+		"\n\n*Step 1 of 2:* which channel should admin notices go to? Reply with a channel mention such as #bot-admin (invite me there first)."})
+}
+
+// blocking answers with a setup notice while the wizard is running and
+// reports whether it did.
+func (w *onboarding) blocking(ctx context.Context, api SlackClient, channel, user string) bool {
+	w.mu.Lock()
+	active, admin := w.active, w.admin
+	w.mu.Unlock()
+	if !active {
+		return false
+	}
+	text := ":hammer_and_wrench: I'm still being set up and can't answer questions yet."
+	if admin != "" {
+		text += fmt.Sprintf(" <@%s> is finishing the setup.", admin)
+	}
+	notifyUser(ctx, api, channel, user, text)
+	return true
+}
+
+// handleDM runs one wizard step and reports whether the message belonged
+// to the wizard.
+func (w *onboarding) handleDM(ctx context.Context, api SlackClient, ev *slackevents.MessageEvent) bool {
+	w.mu.Lock()
+	if !w.active {
+		w.mu.Unlock()
+		return false
+	}
+	reply := func(text string) {
+		sendMessage(ctx, api, outgoingMessage{Channel: ev.Channel, User: ev.User, Text: text})
+	}
+	text := strings.TrimSpace(ev.Text)
+	if w.admin == "" && strings.EqualFold(text, "setup") {
+		w.admin = ev.User
+		w.mu.Unlock()
+		slog.InfoContext(ctx, "Setup wizard claimed; the claimer becomes the admin", "user", ev.User)
+		w.greet(ctx, api, ev.User)
+		return true
+	}
+	if ev.User != w.admin {
+		w.mu.Unlock()
+		w.blocking(ctx, api, ev.Channel, ev.User)
+		return true
+	}
+	step := w.step
+	w.mu.Unlock()
+
+	switch step {
+	case setupStepChannel:
+		m := channelMentionPattern.FindStringSubmatch(text)
+		if m == nil {
+			reply("Please reply with a channel mention, such as #bot-admin.")
+			return true
+		}
+		channel := m[1]
+		ch, err := api.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{ChannelID: channel})
+		if err != nil {
+			reply(fmt.Sprintf("I couldn't look up <#%s> (%v). Check that it exists and that I've been invited.", channel, err))
+			return true
+		}
+		if !ch.IsMember {
+			reply(fmt.Sprintf("I'm not in <#%s> yet. Invite me with `/invite @chatrelaybot`, then reply with the channel again.", channel))
+			return true
+		}
+		w.mu.Lock()
+		w.state.AdminChannel, w.step = channel, setupStepBackend
+		w.mu.Unlock()
+		reply(fmt.Sprintf("Admin notices will go to <#%s>.\n\n*Step 2 of 2:* what is the backend URL? Reply with a URL such as `https://llm.internal/v1/chat/stream`, or `mock` to try the built-in mock backend.", channel))
+	case setupStepBackend:
+		backendURL := strings.Trim(text, "<>")
+		if strings.EqualFold(backendURL, "mock") {
+			backendURL = "http://localhost:" + config.Port + backend.Path
+		}
+		if err := probeBackend(ctx, backendURL); err != nil {
+			reply(fmt.Sprintf("That backend didn't work: %v\nReply with another URL, or `mock`.", err))
+			return true
+		}
+		w.finish(ctx, api, ev.User, backendURL, reply)
+	}
+	return true
+}
+
+func (w *onboarding) finish(ctx context.Context, api SlackClient, user, backend string, reply func(string)) {
+	w.mu.Lock()
+	w.state.BackendURL = backend
+	w.state.AdminUsers = []string{user}
+	w.state.CompletedBy, w.state.CompletedAt = user, time.Now()
+	state, path := w.state, w.path
+	w.mu.Unlock()
+
+	if err := saveSetup(path, state); err != nil {
+		slog.ErrorContext(ctx, "Failed to save setup", "path", path, "err", err)
+		reply(fmt.Sprintf("I couldn't save the setup to %s (%v), so it will be lost on restart. Fix the path or set SETUP_FILE, then reply with the backend URL again.", path, err))
+		return
+	}
+	applySetup(state)
+	w.mu.Lock()
+	w.active = false
+	w.mu.Unlock()
+	slog.InfoContext(ctx, "Setup completed", "user", user, "backend", backend, "admin_channel", state.AdminChannel)
+	reply(fmt.Sprintf(":white_check_mark: Setup complete and saved to `%s`. Mention me in any channel I'm in to ask a question.", path))
+	sendMessage(ctx, api, outgoingMessage{Channel: state.AdminChannel, User: user,
+		Text: fmt.Sprintf(":white_check_mark: ChatRelayBot was set up by <@%s> and is answering questions. Admin notices will be posted here.", user)})
+}
+
+// probeBackend checks that url accepts a chat request.
+func probeBackend(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", rawURL)
+	}
+	ctx, cancel := context.WithTimeout(ctx, setupProbeTimeout)
+	defer cancel()
+	body, _ := json.Marshal(backend.ChatRequest{UserID: "setup", Query: "ping", DisableInternalRetrieval: true})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("backend returned %s", resp.Status)
+	}
+	return nil
+}
+
+// checkSlackScopes summarizes the token and scope checks for the wizard's
+// greeting.
+func checkSlackScopes(ctx context.Context, client *http.Client, apiURL, token string) string {
+	missing, err := missingScopes(ctx, client, apiURL, token, append(append([]string{}, requiredScopes...), optionalScopes...))
+	var missingRequired, missingOptional []string
+	for _, s := range missing {
+		if slices.Contains(requiredScopes, s) {
+			missingRequired = append(missingRequired, s)
+		} else {
+			missingOptional = append(missingOptional, s)
+		}
+	}
+	switch {
+	case err != nil:
+		return fmt.Sprintf(":warning: I couldn't check my token scopes (%v).", err)
+	case len(missingRequired) > 0:
+		return fmt.Sprintf(":warning: My bot token is missing required scopes: `%s`. Add them under *OAuth & Permissions* and reinstall the app.", strings.Join(missingRequired, "`, `"))
+	case len(missingOptional) > 0:
+		return fmt.Sprintf(":white_check_mark: My tokens work. Some features need extra scopes: `%s`.", strings.Join(missingOptional, "`, `"))
+	}
+	return ":white_check_mark: My tokens work and have every scope I use."
+}
@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestSetupWizard_FirstRun(t *testing.T) {
+	savedBackend, savedAdmins, savedChannel := config.BackendURL, config.AdminUsers, config.AdminChannel
+	defer func() {
+		config.BackendURL, config.AdminUsers, config.AdminChannel = savedBackend, savedAdmins, savedChannel
+		setupWizard = &onboarding{}
+	}()
+	config.BackendURL, config.AdminUsers, config.AdminChannel = "", nil, ""
+	setupWizard = &onboarding{}
+
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "pong"})
+	}))
+	defer backendServer.Close()
+
+	path := filepath.Join(t.TempDir(), "setup.json")
+	api := &fakeSlackClient{}
+	ctx := context.Background()
+	setupWizard.start(ctx, api, path, "Tokens OK.")
+
+	dm := func(user, text string) {
+		processDirectMessage(ctx, api, &slackevents.MessageEvent{User: user, Channel: "D" + user, ChannelType: "im", Text: text}, workerpool.New(1))
+	}
+	last := func() string {
+		posts := api.sent()
+		return posts[len(posts)-1].Text()
+	}
+
+	processTask(ctx, api, slackevents.AppMentionEvent{User: "U9", Channel: "CGEN"}, "what is Go?")
+	if !strings.Contains(last(), "still being set up") {
+		t.Fatalf("questions during setup should get a notice, got %q", last())
+	}
+	dm("U1", "setup")
+	if !strings.Contains(last(), "Step 1 of 2") || !strings.Contains(last(), "Tokens OK.") {
+		t.Fatalf("greeting = %q", last())
+	}
+	dm("U2", "setup")
+	if !strings.Contains(last(), "<@U1> is finishing the setup") {
+		t.Errorf("second user should not take over setup, got %q", last())
+	}
+	dm("U1", "the admin one")
+	if !strings.Contains(last(), "channel mention") {
+		t.Errorf("expected a retry prompt, got %q", last())
+	}
+	dm("U1", "<#CADMIN|bot-admin>")
+	if !strings.Contains(last(), "Step 2 of 2") {
+		t.Fatalf("expected the backendServer prompt, got %q", last())
+	}
+	dm("U1", "ftp://example.com")
+	if !strings.Contains(last(), "didn't work") {
+		t.Errorf("expected the bad backendServer to be rejected, got %q", last())
+	}
+	dm("U1", "<"+backendServer.URL+">")
+
+	posts := api.sent()
+	if announce := posts[len(posts)-1]; announce.Channel != "CADMIN" || !strings.Contains(announce.Text(), "set up by <@U1>") {
+		t.Errorf("expected an announcement in the admin channel, got %+v", announce)
+	}
+	if config.BackendURL != backendServer.URL || config.AdminChannel != "CADMIN" || len(config.AdminUsers) != 1 || config.AdminUsers[0] != "U1" {
+		t.Errorf("setup not applied: backendServer %q, channel %q, admins %v", config.BackendURL, config.AdminChannel, config.AdminUsers)
+	}
+	saved, err := loadSetup(path)
+	if err != nil || saved.BackendURL != backendServer.URL || saved.AdminChannel != "CADMIN" || saved.CompletedBy != "U1" {
+		t.Errorf("saved setup = %+v, %v", saved, err)
+	}
+	if setupWizard.blocking(ctx, api, "CGEN", "U9") {
+		t.Error("setup mode should end once the setup is saved")
+	}
+}
+
+func TestMissingScopes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/auth.test" || r.Header.Get("Authorization") != "Bearer xoxb-test" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		w.Header().Set("X-OAuth-Scopes", "app_mentions:read,chat:write, im:history,users:read,reactions:read")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer ts.Close()
+
+	report := checkSlackScopes(context.Background(), ts.Client(), ts.URL+"/api/", "xoxb-test")
+	if !strings.Contains(report, "missing required scopes: `channels:read`") {
+		t.Errorf("report = %q", report)
+	}
+	missing, err := missingScopes(context.Background(), ts.Client(), ts.URL+"/api/", "xoxb-test", []string{"chat:write", "reactions:read"})
+	if err != nil || len(missing) != 0 {
+		t.Errorf("missing = %v, %v", missing, err)
+	}
+}
@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+)
+
+func TestSignBackendRequest_VerifiesOnce(t *testing.T) {
+	original := config.BackendSigningSecret
+	config.BackendSigningSecret = "s3cret"
+	defer func() { config.BackendSigningSecret = original }()
+
+	body := []byte(`{"query":"hi"}`)
+	req, err := newBackendRequest(context.Background(), body, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(req.Header.Get(headerRelaySignature), "v1=") {
+		t.Fatalf("missing signature, headers %v", req.Header)
+	}
+	v := newSignatureVerifier("s3cret")
+	if err := v.verify(req.Header, body); err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+	if err := v.verify(req.Header, body); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("replay should be rejected, got %v", err)
+	}
+}
+
+func TestSignatureVerifier_Rejects(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	signed := func(secret string, at time.Time, body string) http.Header {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		h := http.Header{}
+		h.Set(headerRelayTimestamp, ts)
+		h.Set(headerRelayNonce, "abc")
+		h.Set(headerRelaySignature, computeSignature(secret, ts, "abc", []byte(body)))
+		return h
+	}
+	tests := []struct {
+		name   string
+		header http.Header
+		body   string
+	}{
+		{"unsigned", http.Header{}, "{}"},
+		{"wrong secret", signed("other", now, "{}"), "{}"},
+		{"tampered body", signed("s3cret", now, "{}"), `{"query":"x"}`},
+		{"stale", signed("s3cret", now.Add(-10*time.Minute), "{}"), "{}"},
+		{"from the future", signed("s3cret", now.Add(10*time.Minute), "{}"), "{}"},
+	}
+	for _, tt := range tests {
+		v := newSignatureVerifier("s3cret")
+		v.now = func() time.Time { return now }
+		if err := v.verify(tt.header, []byte(tt.body)); err == nil {
+			t.Errorf("%s: request accepted", tt.name)
+		}
+	}
+}
+
+func TestRequireSignature_MockBackend(t *testing.T) {
+	original := config.BackendSigningSecret
+	config.BackendSigningSecret = "s3cret"
+	defer func() { config.BackendSigningSecret = original }()
+
+	var got string
+	handler := newSignatureVerifier("s3cret").requireSignature(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, backend.Path, strings.NewReader("{}")))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request: status %d, want 401", rec.Code)
+	}
+
+	req, _ := newBackendRequest(context.Background(), []byte(`{"query":"hi"}`), "application/json")
+	server := httptest.NewRequest(http.MethodPost, backend.Path, req.Body)
+	server.Header = req.Header
+	rec = httptest.NewRecorder()
+	handler(rec, server)
+	if rec.Code != http.StatusOK || got != `{"query":"hi"}` {
+		t.Errorf("signed request: status %d, body %q", rec.Code, got)
+	}
+}
@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Slash Commands
+//
+// "/ask <question>" asks the bot from any channel it can post in, without
+// a mention. Slack gives a slash command three seconds to be acknowledged,
+// which is less than most answers take, so the acknowledgement carries no
+// answer: it only makes the invocation visible in the channel
+// ("in_channel" with no text). The answer follows as a deferred response.
+// The command becomes a mention without a timestamp and goes through
+// processMention, so it uses the same commands, worker pool, queue notices
+// and backend pipeline, and is answered at the channel root. An empty
+// question gets the usage, and a full queue gets a busy notice, both as
+// ephemeral acknowledgements. Commands are counted under "slash_commands"
+// on /debug/vars.
+const (
+	slashCommandAsk = "/ask"
+
+	slashAskUsage = "Usage: `/ask <question>`, for example `/ask how do I rotate my API key?`"
+	slashAskBusy  = ":hourglass: I'm answering a lot of questions right now. Please try again in a minute."
+)
+
+var metricSlashCommands = expvar.NewMap("slash_commands")
+
+// slashResponse is the payload a slash command is acknowledged with.
+type slashResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text,omitempty"`
+}
+
+func ephemeralSlashResponse(text string) slashResponse {
+	return slashResponse{ResponseType: slack.ResponseTypeEphemeral, Text: text}
+}
+
+// handleSlashCommand queues cmd and returns the acknowledgement payload; it
+// must not block, since the acknowledgement is sent after it returns.
+func handleSlashCommand(ctx context.Context, api SlackClient, cmd slack.SlashCommand, pool *workerpool.Pool) slashResponse {
+	ctx = withLogFields(withQuerySource(ctx, SourceSlash), cmd.UserID, cmd.ChannelID)
+	ctx, span := otel.Tracer("bot").Start(ctx, "process_slash_command")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("slash.command", cmd.Command),
+		attribute.String("user.id", cmd.UserID),
+		attribute.String("channel.id", cmd.ChannelID),
+	)
+
+	if cmd.Command != slashCommandAsk {
+		metricSlashCommands.Add("unknown", 1)
+		slog.InfoContext(ctx, "Ignoring unknown slash command", "command", cmd.Command)
+		return ephemeralSlashResponse(fmt.Sprintf("I don't know `%s`. %s", cmd.Command, slashAskUsage))
+	}
+	query := strings.TrimSpace(cmd.Text)
+	if query == "" {
+		metricSlashCommands.Add("usage", 1)
+		return ephemeralSlashResponse(slashAskUsage)
+	}
+	if stats := pool.Stats(); !queueHasRoom(stats) {
+		// Slash commands are not redelivered, so the asker is told instead.
+		metricSlashCommands.Add("busy", 1)
+		slog.WarnContext(ctx, "Queue full, turning away /ask", "depth", stats.QueueDepth, "capacity", stats.QueueCapacity, "user", cmd.UserID)
+		return ephemeralSlashResponse(slashAskBusy)
+	}
+
+	metricSlashCommands.Add("ask", 1)
+	processMention(ctx, api, slackevents.AppMentionEvent{User: cmd.UserID, Channel: cmd.ChannelID, Text: query}, pool)
+	return slashResponse{ResponseType: slack.ResponseTypeInChannel}
+}
@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+)
+
+func TestHandleSlashCommand_AsksThroughThePool(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	var got backend.ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Rotate it under Settings."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+	pool := workerpool.New(1)
+	defer pool.Shutdown()
+	defer waitIdle(pool, time.Second)
+
+	api := &fakeSlackClient{}
+	ack := handleSlashCommand(context.Background(), api, slack.SlashCommand{Command: "/ask", Text: " how do I rotate my key? ", UserID: "USLASH", ChannelID: "CSLASH"}, pool)
+	if ack.ResponseType != slack.ResponseTypeInChannel || ack.Text != "" {
+		t.Fatalf("ack = %+v, want an empty in_channel acknowledgement", ack)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(api.sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	posts := api.sent()
+	if len(posts) == 0 {
+		t.Fatal("no answer was posted")
+	}
+	if posts[0].Values.Get("channel") != "CSLASH" || posts[0].Text() != "Rotate it under Settings." {
+		t.Errorf("answer %q in %s", posts[0].Text(), posts[0].Values.Get("channel"))
+	}
+	if got.Query != "how do I rotate my key?" || got.UserID != "USLASH" {
+		t.Errorf("backend request %+v", got)
+	}
+}
+
+func TestHandleSlashCommand_EphemeralAcks(t *testing.T) {
+	pool := workerpool.New(1)
+	defer pool.Shutdown()
+	api := &fakeSlackClient{}
+	for _, cmd := range []slack.SlashCommand{
+		{Command: "/ask", Text: "  ", UserID: "U1", ChannelID: "C1"},
+		{Command: "/other", Text: "hi", UserID: "U1", ChannelID: "C1"},
+	} {
+		ack := handleSlashCommand(context.Background(), api, cmd, pool)
+		if ack.ResponseType != slack.ResponseTypeEphemeral || ack.Text == "" {
+			t.Errorf("%s %q: ack = %+v, want an ephemeral notice", cmd.Command, cmd.Text, ack)
+		}
+	}
+	if len(api.sent()) != 0 {
+		t.Errorf("nothing should be posted, got %+v", api.sent())
+	}
+}
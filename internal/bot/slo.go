@@ -0,0 +1,442 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Latency SLOs
+//
+// SLOS defines latency objectives for answers, such as
+// "first_chunk=95%/3s": 95% of answers post their first chunk within 3s of
+// the question being queued. "answer" measures until the whole answer is
+// posted. Answers that fail or never post count against every objective;
+// self-tests are not counted. Each replica keeps a minute-by-minute count
+// of good and bad answers for six hours and an hourly count for the
+// 30-day budget period. Burn rate is the share of bad answers divided by
+// the share the objective allows: a burn rate of 1 spends the budget
+// exactly over the period. Alerts follow the multi-window rule: a fast
+// burn (14.4x over both the last hour and 5 minutes) or a slow burn (6x
+// over both 6 hours and 30 minutes) alerts ADMIN_CHANNEL and sends a
+// slo.alert webhook, and recovery is announced once neither holds. Status
+// is shown by "!slo", GET /admin/slo and "slo" on /debug/vars.
+const (
+	defaultSLOs      = "first_chunk=95%/3s"
+	sloPeriod        = 30 * 24 * time.Hour
+	sloMinuteBuckets = 6 * 60
+	sloHourBuckets   = 30 * 24
+	sloCheckInterval = time.Minute
+	// minSLOEvents avoids alerting on a handful of slow answers.
+	minSLOEvents = 20
+
+	SLOFirstChunk = "first_chunk"
+	SLOAnswer     = "answer"
+)
+
+var metricSLO = expvar.NewMap("slo")
+
+type sloObjective struct {
+	Name      string
+	Objective float64
+	Threshold time.Duration
+}
+
+func (o sloObjective) String() string {
+	return fmt.Sprintf("%s (%s within %s)", o.Name, strconv.FormatFloat(o.Objective*100, 'f', -1, 64)+"%", o.Threshold)
+}
+
+// burnPolicy fires when both windows burn at least Rate.
+type burnPolicy struct {
+	Severity    string
+	Long, Short time.Duration
+	Rate        float64
+}
+
+var burnPolicies = []burnPolicy{
+	{Severity: "fast", Long: time.Hour, Short: 5 * time.Minute, Rate: 14.4},
+	{Severity: "slow", Long: 6 * time.Hour, Short: 30 * time.Minute, Rate: 6},
+}
+
+// sloWindows are the windows reported in status.
+var sloWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour}
+
+type sloCounts struct {
+	Good, Total int64
+}
+
+func (c sloCounts) add(o sloCounts) sloCounts {
+	return sloCounts{Good: c.Good + o.Good, Total: c.Total + o.Total}
+}
+
+// sloRing counts answers in fixed-size time buckets.
+type sloRing struct {
+	size   time.Duration
+	counts []sloCounts
+	at     []int64
+}
+
+func newSLORing(size time.Duration, n int) sloRing {
+	return sloRing{size: size, counts: make([]sloCounts, n), at: make([]int64, n)}
+}
+
+func (r *sloRing) add(now time.Time, good bool) {
+	slot := now.UnixNano() / int64(r.size)
+	i := int(slot % int64(len(r.counts)))
+	if r.at[i] != slot {
+		r.at[i], r.counts[i] = slot, sloCounts{}
+	}
+	r.counts[i].Total++
+	if good {
+		r.counts[i].Good++
+	}
+}
+
+// sum adds the buckets within d of now.
+func (r *sloRing) sum(now time.Time, d time.Duration) sloCounts {
+	slot := now.UnixNano() / int64(r.size)
+	oldest := slot - int64(d/r.size) + 1
+	var c sloCounts
+	for i, at := range r.at {
+		if at >= oldest && at <= slot {
+			c = c.add(r.counts[i])
+		}
+	}
+	return c
+}
+
+type sloState struct {
+	sloObjective
+	minutes sloRing
+	hours   sloRing
+	firing  string
+}
+
+func (s *sloState) burnRate(now time.Time, window time.Duration) (float64, int64) {
+	c := s.minutes.sum(now, window)
+	if c.Total == 0 {
+		return 0, 0
+	}
+	return float64(c.Total-c.Good) / float64(c.Total) / (1 - s.Objective), c.Total
+}
+
+// budgetRemaining is the share of the period's error budget left.
+func (s *sloState) budgetRemaining(now time.Time) float64 {
+	c := s.hours.sum(now, sloPeriod)
+	if c.Total == 0 {
+		return 1
+	}
+	allowed := (1 - s.Objective) * float64(c.Total)
+	return 1 - float64(c.Total-c.Good)/allowed
+}
+
+// severity returns the first burn policy that holds, or "".
+func (s *sloState) severity(now time.Time) (burnPolicy, bool) {
+	for _, p := range burnPolicies {
+		long, n := s.burnRate(now, p.Long)
+		short, _ := s.burnRate(now, p.Short)
+		if n >= minSLOEvents && long >= p.Rate && short >= p.Rate {
+			return p, true
+		}
+	}
+	return burnPolicy{}, false
+}
+
+type sloTracker struct {
+	mu   sync.Mutex
+	slos []*sloState
+	now  func() time.Time
+}
+
+func newSLOTracker(now func() time.Time) *sloTracker {
+	return &sloTracker{now: now}
+}
+
+var slos = newSLOTracker(time.Now)
+
+func init() {
+	slos.configure(mustParseSLOs(defaultSLOs))
+	metricSLO.Set("status", expvar.Func(func() any { return slos.status() }))
+}
+
+func mustParseSLOs(spec string) []sloObjective {
+	objs, err := parseSLOs(spec)
+	if err != nil {
+		panic(err)
+	}
+	return objs
+}
+
+// parseSLOs reads "first_chunk=95%/3s,answer=99%/30s"; "off" defines none.
+func parseSLOs(spec string) ([]sloObjective, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		spec = defaultSLOs
+	}
+	if strings.EqualFold(spec, "off") {
+		return nil, nil
+	}
+	var objs []sloObjective
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(spec, ",") {
+		name, rest, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		target, threshold, ok2 := strings.Cut(rest, "/")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid SLO %q (use e.g. first_chunk=95%%/3s)", entry)
+		}
+		if name != SLOFirstChunk && name != SLOAnswer {
+			return nil, fmt.Errorf("unknown SLO %q (use %s or %s)", name, SLOFirstChunk, SLOAnswer)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("SLO %s is defined twice", name)
+		}
+		seen[name] = true
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(target, "%"), 64)
+		if err != nil || pct <= 0 || pct >= 100 {
+			return nil, fmt.Errorf("invalid SLO objective %q: use a percentage between 0 and 100 such as 95%%", target)
+		}
+		d, err := time.ParseDuration(threshold)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid SLO threshold %q: use a duration such as 3s", threshold)
+		}
+		objs = append(objs, sloObjective{Name: name, Objective: pct / 100, Threshold: d})
+	}
+	return objs, nil
+}
+
+func (t *sloTracker) configure(objs []sloObjective) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.slos = nil
+	for _, o := range objs {
+		t.slos = append(t.slos, &sloState{sloObjective: o, minutes: newSLORing(time.Minute, sloMinuteBuckets), hours: newSLORing(time.Hour, sloHourBuckets)})
+	}
+}
+
+// observe counts one answer against the SLO name; a zero latency means the
+// answer never got that far.
+func (t *sloTracker) observe(name string, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, s := range t.slos {
+		if s.Name != name {
+			continue
+		}
+		good := latency > 0 && latency <= s.Threshold
+		s.minutes.add(now, good)
+		s.hours.add(now, good)
+		if good {
+			metricSLO.Add(name+".good", 1)
+		} else {
+			metricSLO.Add(name+".bad", 1)
+		}
+	}
+}
+
+// sloAnswer times one answer for the SLOs; see processTask.
+type sloAnswer struct {
+	ctx        context.Context
+	queued     time.Time
+	firstChunk time.Duration
+	discarded  bool
+}
+
+func startSLOAnswer(ctx context.Context) *sloAnswer {
+	queued, ok := requests.queuedAt(ctx)
+	if !ok {
+		queued = time.Now()
+	}
+	return &sloAnswer{ctx: ctx, queued: queued}
+}
+
+func (a *sloAnswer) chunk() {
+	if a.firstChunk == 0 {
+		a.firstChunk = time.Since(a.queued)
+	}
+}
+
+// discard leaves the answer out, for outcomes that say nothing about
+// latency (input too long, drafts offered).
+func (a *sloAnswer) discard() {
+	a.discarded = true
+}
+
+func (a *sloAnswer) finish() {
+	if a.discarded || isSelfTest(a.ctx) {
+		return
+	}
+	slos.observe(SLOFirstChunk, a.firstChunk)
+	var total time.Duration
+	if a.firstChunk > 0 {
+		total = time.Since(a.queued)
+	}
+	slos.observe(SLOAnswer, total)
+}
+
+type SLOStatus struct {
+	Name            string             `json:"name"`
+	Objective       float64            `json:"objective"`
+	Threshold       string             `json:"threshold"`
+	Answers         int64              `json:"answers"`
+	Attainment      float64            `json:"attainment"`
+	BudgetRemaining float64            `json:"budget_remaining"`
+	BurnRates       map[string]float64 `json:"burn_rates"`
+	Alert           string             `json:"alert,omitempty"`
+}
+
+func (t *sloTracker) status() []SLOStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	out := []SLOStatus{}
+	for _, s := range t.slos {
+		c := s.hours.sum(now, sloPeriod)
+		st := SLOStatus{Name: s.Name, Objective: s.Objective, Threshold: s.Threshold.String(), Answers: c.Total,
+			Attainment: 1, BudgetRemaining: s.budgetRemaining(now), BurnRates: map[string]float64{}, Alert: s.firing}
+		if c.Total > 0 {
+			st.Attainment = float64(c.Good) / float64(c.Total)
+		}
+		for _, w := range sloWindows {
+			rate, _ := s.burnRate(now, w)
+			st.BurnRates[formatWindow(w)] = rate
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+func formatWindow(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+type sloAlert struct {
+	SLO             sloObjective
+	Policy          burnPolicy
+	LongRate        float64
+	ShortRate       float64
+	BudgetRemaining float64
+	Resolved        bool
+}
+
+// check returns the alerts that started, escalated or resolved since the
+// last check.
+func (t *sloTracker) check() []sloAlert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	var alerts []sloAlert
+	for _, s := range t.slos {
+		p, burning := s.severity(now)
+		switch {
+		case burning && s.firing != "" && p.Severity != burnPolicies[0].Severity:
+			// A fast burn easing into a slow one is still the same incident.
+			s.firing = p.Severity
+		case burning && s.firing != p.Severity:
+			long, _ := s.burnRate(now, p.Long)
+			short, _ := s.burnRate(now, p.Short)
+			s.firing = p.Severity
+			alerts = append(alerts, sloAlert{SLO: s.sloObjective, Policy: p, LongRate: long, ShortRate: short, BudgetRemaining: s.budgetRemaining(now)})
+		case !burning && s.firing != "":
+			s.firing = ""
+			alerts = append(alerts, sloAlert{SLO: s.sloObjective, BudgetRemaining: s.budgetRemaining(now), Resolved: true})
+		}
+	}
+	return alerts
+}
+
+func (a sloAlert) text() string {
+	if a.Resolved {
+		return fmt.Sprintf(":white_check_mark: SLO %s is no longer burning its error budget too fast; %.0f%% of the 30-day budget is left.", a.SLO, a.BudgetRemaining*100)
+	}
+	return fmt.Sprintf(":fire: SLO %s is burning its error budget %.1fx too fast over the last %s (%.1fx over %s); %.0f%% of the 30-day budget is left.",
+		a.SLO, a.LongRate, formatWindow(a.Policy.Long), a.ShortRate, formatWindow(a.Policy.Short), a.BudgetRemaining*100)
+}
+
+func sendSLOAlert(ctx context.Context, api SlackClient, a sloAlert) {
+	metricSLO.Add("alerts", 1)
+	alertAdmins(ctx, api, a.text())
+	data := map[string]any{
+		"slo": a.SLO.Name, "objective": a.SLO.Objective, "threshold": a.SLO.Threshold.String(),
+		"resolved": a.Resolved, "budget_remaining": a.BudgetRemaining,
+	}
+	if !a.Resolved {
+		data["severity"] = a.Policy.Severity
+		data["burn_rate_"+formatWindow(a.Policy.Long)] = a.LongRate
+		data["burn_rate_"+formatWindow(a.Policy.Short)] = a.ShortRate
+	}
+	emitWebhook(ctx, EventSLOAlert, "", "", "", data)
+}
+
+// watchSLOs checks the burn rates every minute until ctx is done.
+func watchSLOs(ctx context.Context, api SlackClient) {
+	ticker := time.NewTicker(sloCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, a := range slos.check() {
+				sendSLOAlert(ctx, api, a)
+			}
+		}
+	}
+}
+
+func configureSLOs() error {
+	objs, err := parseSLOs(os.Getenv("SLOS"))
+	if err != nil {
+		return fmt.Errorf("invalid SLOS: %w", err)
+	}
+	slos.configure(objs)
+	return nil
+}
+
+func formatSLOStatus(status []SLOStatus) string {
+	if len(status) == 0 {
+		return "No SLOs are defined (SLOS=off)."
+	}
+	var b strings.Builder
+	b.WriteString("*Latency SLOs* (this replica)\n```\n")
+	fmt.Fprintf(&b, "%-12s %-14s %8s %11s %8s %6s %6s %6s %6s\n", "slo", "objective", "answers", "attainment", "budget", "5m", "30m", "1h", "6h")
+	for _, s := range status {
+		objective := fmt.Sprintf("%s/%s", strconv.FormatFloat(s.Objective*100, 'f', -1, 64)+"%", s.Threshold)
+		fmt.Fprintf(&b, "%-12s %-14s %8d %10.2f%% %7.0f%% %6.1f %6.1f %6.1f %6.1f", s.Name, objective, s.Answers, s.Attainment*100, s.BudgetRemaining*100,
+			s.BurnRates["5m"], s.BurnRates["30m"], s.BurnRates["1h"], s.BurnRates["6h"])
+		if s.Alert != "" {
+			fmt.Fprintf(&b, "  %s burn", s.Alert)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("```")
+	return b.String()
+}
+
+// adminSLOHandler returns the SLO status as JSON.
+func adminSLOHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(slos.status())
+}
+
+func init() {
+	registerCommand("slo", command{
+		Admin: true,
+		Usage: "(latency SLO attainment, error budget and burn rates)",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			notifyUser(ctx, api, ev.Channel, ev.User, formatSLOStatus(slos.status()))
+		},
+	})
+}
@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func observeN(s *sloTracker, name string, n int, latency time.Duration) {
+	for i := 0; i < n; i++ {
+		s.observe(name, latency)
+	}
+}
+
+func TestParseSLOs(t *testing.T) {
+	objs, err := parseSLOs("first_chunk=95%/3s, answer=99.5%/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 || objs[0].Name != SLOFirstChunk || objs[0].Objective != 0.95 || objs[0].Threshold != 3*time.Second ||
+		objs[1].Objective != 0.995 || objs[1].Threshold != time.Minute {
+		t.Errorf("parsed %+v", objs)
+	}
+	if objs, err := parseSLOs(""); err != nil || len(objs) != 1 || objs[0].Name != SLOFirstChunk {
+		t.Errorf("default = %+v, %v", objs, err)
+	}
+	if objs, err := parseSLOs("off"); err != nil || len(objs) != 0 {
+		t.Errorf("off = %+v, %v", objs, err)
+	}
+	for _, spec := range []string{"first_chunk", "latency=95%/3s", "first_chunk=100%/3s", "first_chunk=95%/soon", "answer=99%/1m,answer=95%/1m"} {
+		if _, err := parseSLOs(spec); err == nil {
+			t.Errorf("parseSLOs(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestSLOTracker_BurnRate(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 30, 0, time.UTC)
+	slos := newSLOTracker(func() time.Time { return now })
+	slos.configure(mustParseSLOs("first_chunk=90%/3s"))
+	observeN(slos, SLOFirstChunk, 80, time.Second)
+	observeN(slos, SLOFirstChunk, 20, 5*time.Second)
+	st := slos.status()[0]
+	// 20% bad against a 10% allowance burns at 2x.
+	if st.Answers != 100 || st.Attainment != 0.8 || st.BurnRates["5m"] < 1.99 || st.BurnRates["5m"] > 2.01 {
+		t.Errorf("status = %+v", st)
+	}
+	now = now.Add(10 * time.Minute)
+	st = slos.status()[0]
+	if st.BurnRates["5m"] != 0 || st.BurnRates["1h"] < 1.99 {
+		t.Errorf("after 10m: %+v", st.BurnRates)
+	}
+	if st.BudgetRemaining > -0.99 || st.BudgetRemaining < -1.01 {
+		t.Errorf("budget remaining = %v, want -1 (twice the budget spent)", st.BudgetRemaining)
+	}
+}
+
+func TestSLOTracker_AlertsOnFastBurnAndResolves(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 30, 0, time.UTC)
+	slos := newSLOTracker(func() time.Time { return now })
+	slos.configure(mustParseSLOs("first_chunk=95%/3s"))
+	observeN(slos, SLOFirstChunk, 10, 10*time.Second)
+	if alerts := slos.check(); len(alerts) != 0 {
+		t.Fatalf("alerted on %d answers: %+v", 10, alerts)
+	}
+	observeN(slos, SLOFirstChunk, 30, 10*time.Second)
+	alerts := slos.check()
+	if len(alerts) != 1 || alerts[0].Resolved || alerts[0].Policy.Severity != "fast" {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	if !strings.Contains(alerts[0].text(), "first_chunk (95% within 3s)") {
+		t.Errorf("text = %q", alerts[0].text())
+	}
+	if alerts := slos.check(); len(alerts) != 0 {
+		t.Errorf("repeated alert: %+v", alerts)
+	}
+	if st := slos.status()[0]; st.Alert != "fast" {
+		t.Errorf("status alert = %q", st.Alert)
+	}
+	// Past the short window the fast burn eases into a slow one, which
+	// does not alert again.
+	now = now.Add(10 * time.Minute)
+	observeN(slos, SLOFirstChunk, 5, 10*time.Second)
+	observeN(slos, SLOFirstChunk, 5, time.Second)
+	if alerts := slos.check(); len(alerts) != 0 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	if st := slos.status()[0]; st.Alert != "slow" {
+		t.Errorf("status alert = %q", st.Alert)
+	}
+	now = now.Add(7 * time.Hour)
+	alerts = slos.check()
+	if len(alerts) != 1 || !alerts[0].Resolved {
+		t.Fatalf("alerts after recovery = %+v", alerts)
+	}
+	if st := slos.status()[0]; st.Alert != "" {
+		t.Errorf("still firing: %+v", st)
+	}
+}
+
+func TestSLOAnswer_FailureCountsAsBad(t *testing.T) {
+	defer func(s *sloTracker) { slos = s }(slos)
+	slos = newSLOTracker(time.Now)
+	slos.configure(mustParseSLOs("first_chunk=95%/3s,answer=95%/1m"))
+	a := &sloAnswer{ctx: t.Context(), queued: time.Now()}
+	a.finish()
+	for _, st := range slos.status() {
+		if st.Answers != 1 || st.Attainment != 0 {
+			t.Errorf("%s: %+v", st.Name, st)
+		}
+	}
+	b := &sloAnswer{ctx: t.Context(), queued: time.Now()}
+	b.discard()
+	b.finish()
+	c := &sloAnswer{ctx: t.Context(), queued: time.Now()}
+	c.chunk()
+	c.finish()
+	for _, st := range slos.status() {
+		if st.Answers != 2 || st.Attainment != 0.5 {
+			t.Errorf("%s: %+v", st.Name, st)
+		}
+	}
+}
+
+func TestFormatSLOStatus(t *testing.T) {
+	slos := newSLOTracker(time.Now)
+	slos.configure(mustParseSLOs("off"))
+	if got := formatSLOStatus(slos.status()); !strings.Contains(got, "No SLOs") {
+		t.Errorf("got %q", got)
+	}
+	slos.configure(mustParseSLOs("answer=99%/30s"))
+	observeN(slos, SLOAnswer, 3, time.Second)
+	got := formatSLOStatus(slos.status())
+	if !strings.Contains(got, "answer") || !strings.Contains(got, "99%/30s") || !strings.Contains(got, "100.00%") {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Small Talk
+//
+// Channels with small_talk enabled answer greetings, thanks and similar
+// low-value messages with a canned reply instead of a backend call, and
+// reply to unknown "!" commands with a pointer to !help. Only messages made
+// up entirely of a known phrase are matched, so "hi, how do I ..." still
+// reaches the backend. Replies can be overridden per category.
+const (
+	SmallTalkGreeting = "greeting"
+	SmallTalkThanks   = "thanks"
+	SmallTalkFarewell = "farewell"
+	SmallTalkAck      = "ack"
+
+	smallTalkUnknownCommand = "unknown_command"
+)
+
+type SmallTalkConfig struct {
+	Enabled bool              `json:"enabled,omitempty"`
+	Replies map[string]string `json:"replies,omitempty"`
+}
+
+var smallTalkPhrases = map[string][]string{
+	SmallTalkGreeting: {"hi", "hello", "hey", "hey there", "hi there", "hello there", "good morning", "good afternoon", "good evening", "yo", "howdy"},
+	SmallTalkThanks:   {"thanks", "thank you", "thx", "ty", "thanks a lot", "thank you so much", "many thanks", "cheers", "much appreciated", "thanks so much"},
+	SmallTalkFarewell: {"bye", "goodbye", "see you", "see ya", "good night", "later"},
+	SmallTalkAck:      {"ok", "okay", "k", "cool", "great", "nice", "got it", "perfect", "awesome", "sounds good"},
+}
+
+var defaultSmallTalkReplies = map[string]string{
+	SmallTalkGreeting:       "Hi! Ask me a question and I'll do my best to answer it.",
+	SmallTalkThanks:         "You're welcome!",
+	SmallTalkFarewell:       "Bye! Mention me any time you have a question.",
+	SmallTalkAck:            ":+1:",
+	smallTalkUnknownCommand: "I don't know that command. Try `!help` for the list.",
+}
+
+var smallTalkIndex = func() map[string]string {
+	index := map[string]string{}
+	for category, phrases := range smallTalkPhrases {
+		for _, p := range phrases {
+			index[p] = category
+		}
+	}
+	return index
+}()
+
+var (
+	slackEmojiPattern    = regexp.MustCompile(`:[a-z0-9_+\-]+:`)
+	commandNamePattern   = regexp.MustCompile(`^![a-zA-Z0-9_\-]+$`)
+	smallTalkTrailerWord = map[string]bool{"bot": true, "team": true, "all": true, "everyone": true, "again": true}
+)
+
+// classifySmallTalk returns the category of a trivial message, or "".
+func classifySmallTalk(text string) string {
+	text = strings.ToLower(slackEmojiPattern.ReplaceAllString(text, " "))
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	for len(words) > 1 && smallTalkTrailerWord[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	if len(words) == 0 || len(words) > 4 {
+		return ""
+	}
+	return smallTalkIndex[strings.Join(words, " ")]
+}
+
+func (c SmallTalkConfig) reply(category string) string {
+	if r, ok := c.Replies[category]; ok {
+		return r
+	}
+	return defaultSmallTalkReplies[category]
+}
+
+// handleSmallTalk sends a canned reply when query is small talk or an
+// unknown command in a channel that has small talk enabled. It reports
+// whether the query was handled.
+func handleSmallTalk(ctx context.Context, api SlackClient, channel, user, query string) bool {
+	cc := channelConfigFor(channel)
+	if !cc.SmallTalk.Enabled {
+		return false
+	}
+	category := classifySmallTalk(query)
+	if category == "" && commandNamePattern.MatchString(strings.TrimSpace(query)) {
+		category = smallTalkUnknownCommand
+	}
+	if category == "" {
+		return false
+	}
+	reply := cc.SmallTalk.reply(category)
+	if reply == "" {
+		return false
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("small_talk.category", category))
+	metricSmallTalkReplies.Add(1)
+	slog.InfoContext(ctx, "Answered small talk without the backend", "category", category)
+	if category == smallTalkUnknownCommand {
+		notifyUser(ctx, api, channel, user, reply)
+		return true
+	}
+	sendAnswer(ctx, api, channel, user, reply)
+	return true
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestClassifySmallTalk(t *testing.T) {
+	cases := map[string]string{
+		"hi":                              SmallTalkGreeting,
+		"Hello there!":                    SmallTalkGreeting,
+		"thanks bot :pray:":               SmallTalkThanks,
+		"Thank you so much!!":             SmallTalkThanks,
+		"ok, got it":                      "",
+		"got it":                          SmallTalkAck,
+		"bye":                             SmallTalkFarewell,
+		"hi, how do I reset my password?": "",
+		"thanks, but why does it fail?":   "",
+		"":                                "",
+	}
+	for text, want := range cases {
+		if got := classifySmallTalk(text); got != want {
+			t.Errorf("classifySmallTalk(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestProcessMention_SmallTalkSkipsBackend(t *testing.T) {
+	var backendCalls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backendCalls, 1)
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{
+		"CTALK": {SmallTalk: SmallTalkConfig{Enabled: true, Replies: map[string]string{SmallTalkThanks: "Anytime!"}}},
+	}})
+	defer setChannelSettings(channelSettings{})
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+	processMention(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CTALK", Text: "thanks!"}, pool)
+	processMention(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CTALK", Text: "!frobnicate"}, pool)
+	pool.Shutdown()
+
+	if n := atomic.LoadInt32(&backendCalls); n != 0 {
+		t.Errorf("backend called %d times for small talk", n)
+	}
+	posts := api.sent()
+	if len(posts) != 2 || posts[0].Text() != "Anytime!" {
+		t.Fatalf("unexpected posts %+v", posts)
+	}
+	if posts[1].Values.Get("user") != "U1" {
+		t.Errorf("unknown command reply should be ephemeral: %+v", posts[1])
+	}
+
+	// Channels without small talk still send greetings to the backend.
+	api = &fakeSlackClient{}
+	pool = workerpool.New(1)
+	processMention(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "COTHER", Text: "hi"}, pool)
+	pool.Shutdown()
+	if atomic.LoadInt32(&backendCalls) != 1 {
+		t.Error("expected a backend call when small talk is disabled")
+	}
+}
@@ -0,0 +1,145 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Query Sources
+//
+// Every question is tagged with the surface it came in through: "mention",
+// "dm", "thread" (a "fix:" follow-up), "slash", "interactive" (shortcuts,
+// modals and buttons), "api" (POST /admin/import) or "scheduled"
+// (self-tests and digests). The source is set on the request's spans as
+// query.source and kept on its conversation record and request timeline.
+// Questions, answers and errors are counted per source under
+// "source_requests" on /debug/vars, and "!sources" reports them.
+// FILTER_MODE_BY_SOURCE gives some sources their own content filter mode,
+// e.g. "api=reject"; those sources are filtered even where the moderation
+// flag is off (see contentfilter.go).
+const (
+	SourceMention     = "mention"
+	SourceDM          = "dm"
+	SourceThread      = "thread"
+	SourceSlash       = "slash"
+	SourceInteractive = "interactive"
+	SourceAPI         = "api"
+	SourceScheduled   = "scheduled"
+
+	// sourceUnknown labels work started without a source, such as tests.
+	sourceUnknown = "unknown"
+)
+
+var (
+	metricSourceRequests = expvar.NewMap("source_requests")
+
+	querySources = map[string]bool{
+		SourceMention: true, SourceDM: true, SourceThread: true, SourceSlash: true,
+		SourceInteractive: true, SourceAPI: true, SourceScheduled: true,
+	}
+)
+
+type querySourceKey struct{}
+
+func withQuerySource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, querySourceKey{}, source)
+}
+
+// withDefaultQuerySource tags ctx with source unless an earlier entry
+// point already did, as when /ask goes through processMention.
+func withDefaultQuerySource(ctx context.Context, source string) context.Context {
+	if _, ok := ctx.Value(querySourceKey{}).(string); ok {
+		return ctx
+	}
+	return withQuerySource(ctx, source)
+}
+
+func querySourceFrom(ctx context.Context) string {
+	if source, ok := ctx.Value(querySourceKey{}).(string); ok {
+		return source
+	}
+	return sourceUnknown
+}
+
+// parseSourceFilterModes reads FILTER_MODE_BY_SOURCE, e.g.
+// "api=reject,slash=mask".
+func parseSourceFilterModes(v string) (map[string]string, error) {
+	modes := make(map[string]string)
+	for _, pair := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' }) {
+		source, mode, ok := strings.Cut(pair, "=")
+		source, mode = strings.ToLower(strings.TrimSpace(source)), strings.ToLower(strings.TrimSpace(mode))
+		if !ok || !querySources[source] {
+			return nil, fmt.Errorf("invalid FILTER_MODE_BY_SOURCE entry %q: use source=mode with a source such as api", pair)
+		}
+		if mode != FilterModeMask && mode != FilterModeReject {
+			return nil, fmt.Errorf("invalid FILTER_MODE_BY_SOURCE mode %q for %s: use mask or reject", mode, source)
+		}
+		modes[source] = mode
+	}
+	return modes, nil
+}
+
+type sourceUsage struct {
+	Source    string
+	Questions int64
+	Answers   int64
+	Errors    int64
+}
+
+// sourceReport returns the per-source counts, busiest first.
+func sourceReport() []sourceUsage {
+	var out []sourceUsage
+	metricSourceRequests.Do(func(kv expvar.KeyValue) {
+		counts, ok := kv.Value.(*expvar.Map)
+		if !ok {
+			return
+		}
+		u := sourceUsage{Source: kv.Key}
+		for event, n := range map[string]*int64{"questions": &u.Questions, "answers": &u.Answers, "errors": &u.Errors} {
+			if v, ok := counts.Get(event).(*expvar.Int); ok {
+				*n = v.Value()
+			}
+		}
+		out = append(out, u)
+	})
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Questions != out[j].Questions {
+			return out[i].Questions > out[j].Questions
+		}
+		return out[i].Source < out[j].Source
+	})
+	return out
+}
+
+func formatSourceReport(usage []sourceUsage) string {
+	if len(usage) == 0 {
+		return "No questions since the relay started."
+	}
+	var b strings.Builder
+	b.WriteString("*Questions by source* since the relay started\n```\n")
+	fmt.Fprintf(&b, "%-12s %9s %9s %7s %6s\n", "source", "questions", "answers", "errors", "error%")
+	for _, u := range usage {
+		rate := 0.0
+		if u.Questions > 0 {
+			rate = 100 * float64(u.Errors) / float64(u.Questions)
+		}
+		fmt.Fprintf(&b, "%-12s %9d %9d %7d %5.1f%%\n", u.Source, u.Questions, u.Answers, u.Errors, rate)
+	}
+	b.WriteString("```")
+	return b.String()
+}
+
+func init() {
+	registerCommand("sources", command{
+		Admin: true,
+		Usage: "(questions, answers and errors by entry point)",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			notifyUser(ctx, api, ev.Channel, ev.User, formatSourceReport(sourceReport()))
+		},
+	})
+}
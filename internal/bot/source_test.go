@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+)
+
+func TestQuerySource_SlashKeepsItsSource(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	defer func(s *ConversationStore) { conversations = s }(conversations)
+	conversations = NewConversationStore()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Rotate keys in settings."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	slashAnswers := func() int64 {
+		counts, _ := metricSourceRequests.Get(SourceSlash).(*expvar.Map)
+		if counts == nil {
+			return 0
+		}
+		n, _ := counts.Get("answers").(*expvar.Int)
+		if n == nil {
+			return 0
+		}
+		return n.Value()
+	}
+	before := slashAnswers()
+
+	pool := workerpool.New(1)
+	api := &fakeSlackClient{}
+	handleSlashCommand(context.Background(), api, slack.SlashCommand{Command: slashCommandAsk, UserID: "U1", ChannelID: "CSRC", Text: "how do I rotate keys?"}, pool)
+	waitIdle(pool, time.Second)
+
+	var rec ConversationRecord
+	for _, r := range conversations.All() {
+		rec = r
+	}
+	if rec.Source != SourceSlash {
+		t.Errorf("record source %q, want %q", rec.Source, SourceSlash)
+	}
+	if got := slashAnswers() - before; got != 1 {
+		t.Errorf("counted %d slash answers, want 1", got)
+	}
+	if tl, ok := requests.find(rec.ID); !ok || !strings.Contains(formatTimeline(tl), "via slash") {
+		t.Errorf("timeline %+v does not show the source", tl)
+	}
+}
+
+func TestParseSourceFilterModes(t *testing.T) {
+	modes, err := parseSourceFilterModes("api=reject, Slash=mask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modes[SourceAPI] != FilterModeReject || modes[SourceSlash] != FilterModeMask {
+		t.Errorf("modes = %v", modes)
+	}
+	for _, v := range []string{"web=reject", "api=shout", "api"} {
+		if _, err := parseSourceFilterModes(v); err == nil {
+			t.Errorf("%q accepted", v)
+		}
+	}
+}
+
+func TestBlocklistFilter_SourceMode(t *testing.T) {
+	f, _ := newBlocklistFilter([]string{"darn"}, FilterModeMask)
+	f.bySource = map[string]string{SourceAPI: FilterModeReject}
+
+	msg := &outgoingMessage{Text: "darn"}
+	f.Apply(withQuerySource(context.Background(), SourceAPI), msg)
+	if msg.Text != filterRejectNotice {
+		t.Errorf("api message %q, want the reject notice", msg.Text)
+	}
+	msg = &outgoingMessage{Text: "darn"}
+	f.Apply(withQuerySource(context.Background(), SourceMention), msg)
+	if msg.Text != "****" {
+		t.Errorf("mention message %q, want masked", msg.Text)
+	}
+}
+
+func TestFormatSourceReport(t *testing.T) {
+	out := formatSourceReport([]sourceUsage{{Source: SourceAPI, Questions: 4, Answers: 3, Errors: 1}})
+	if !strings.Contains(out, "api") || !strings.Contains(out, "25.0%") {
+		t.Errorf("report %q", out)
+	}
+}
@@ -0,0 +1,398 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Startup Report
+//
+// Once the relay is connected and the backend warmed up, it posts a
+// diagnostics summary to ADMIN_CHANNEL, so a misconfigured deploy shows up
+// right away instead of as a bot that quietly does less. The report lists
+// the build (version, VCS revision, Go version), where the settings came
+// from, the features switched on by the environment, the feature flag
+// defaults, and the result of each check: one backend request (or the
+// warm-up's outcome), the bot token's Slack scopes, and every configured
+// file, directory and store. STARTUP_REPORT chooses when it is posted:
+// "on" (the default) after every start, "problems" only when a check
+// failed or warned, "off" never. Every replica posts its own, so fleets
+// usually want "problems". GET /admin/startup returns the latest report as
+// JSON and "startup_report" on /debug/vars counts check outcomes.
+const (
+	startupReportOn       = "on"
+	startupReportProblems = "problems"
+	startupReportOff      = "off"
+
+	startupCheckTimeout = 10 * time.Second
+)
+
+const (
+	checkOK      = "ok"
+	checkWarning = "warning"
+	checkFailed  = "failed"
+)
+
+var metricStartupReport = expvar.NewMap("startup_report")
+
+// StartupCheck is the outcome of one startup check.
+type StartupCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// BuildInfo identifies the running binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// StartupReport is what the relay found when it started.
+type StartupReport struct {
+	At       time.Time       `json:"at"`
+	Replica  string          `json:"replica"`
+	Build    BuildInfo       `json:"build"`
+	Sources  []string        `json:"config_sources"`
+	Features []string        `json:"features"`
+	Flags    map[string]bool `json:"flags"`
+	Checks   []StartupCheck  `json:"checks"`
+}
+
+// problems reports whether a check failed or warned.
+func (r StartupReport) problems() bool {
+	return slices.ContainsFunc(r.Checks, func(c StartupCheck) bool { return c.Status != checkOK })
+}
+
+var startupReport = struct {
+	mu     sync.Mutex
+	mode   string
+	latest *StartupReport
+}{mode: startupReportOn}
+
+func configureStartupReport() error {
+	mode := strings.ToLower(os.Getenv("STARTUP_REPORT"))
+	switch mode {
+	case "":
+		mode = startupReportOn
+	case startupReportOn, startupReportProblems, startupReportOff:
+	default:
+		return fmt.Errorf("invalid STARTUP_REPORT %q: use on, problems or off", mode)
+	}
+	startupReport.mu.Lock()
+	startupReport.mode = mode
+	startupReport.mu.Unlock()
+	return nil
+}
+
+// startupFeatures are switched on by setting any of their variables.
+var startupFeatures = []struct {
+	name string
+	envs []string
+}{
+	{"tracing", []string{"OTEL_EXPORTER_OTLP_ENDPOINT"}},
+	{"webhooks", []string{"WEBHOOK_URLS"}},
+	{"analytics API", []string{"ANALYTICS_API_TOKEN"}},
+	{"workspace installs", []string{"SLACK_CLIENT_ID"}},
+	{"HTTP events", []string{"SLACK_SIGNING_SECRET"}},
+	{"cost budget", []string{"COST_BUDGET_DAILY", "COST_BUDGET_MONTHLY"}},
+	{"digests", []string{"DIGEST_CHANNELS"}},
+	{"self-tests", []string{"SELFTEST_INTERVAL"}},
+	{"tickets", []string{"TICKET_PROVIDER"}},
+	{"FAQ", []string{"FAQ_FILE"}},
+	{"glossary", []string{"GLOSSARY_FILE"}},
+	{"voice notes", []string{"TRANSCRIBE_URL"}},
+	{"semantic search", []string{"EMBEDDINGS_URL"}},
+	{"backend regions", []string{"BACKEND_REGIONS"}},
+	{"model routing", []string{"BACKEND_MODELS"}},
+	{"plugins", []string{"PLUGINS"}},
+	{"leader lease", []string{"LEADER_LEASE_FILE"}},
+	{"DM privacy", []string{"DM_PRIVACY", "DM_PRIVACY_FILE"}},
+	{"maintenance file", []string{"MAINTENANCE_FILE"}},
+}
+
+// startupPaths are the files and directories checked at startup. Files the
+// relay writes need a writable directory; the others only need to be
+// readable if they exist.
+var startupPaths = []struct {
+	env    string
+	dir    bool
+	writes bool
+}{
+	{"AUDIT_LOG_FILE", false, true},
+	{"OUTBOX_FILE", false, true},
+	{"LEADER_LEASE_FILE", false, true},
+	{"GAPS_FILE", false, true},
+	{"EVAL_FILE", false, true},
+	{"FEATURE_FLAGS", false, true},
+	{"PERSONAS_FILE", false, true},
+	{"DM_PRIVACY_FILE", false, true},
+	{"SETUP_FILE", false, true},
+	{"CHANNEL_CONFIG", false, false},
+	{"FAQ_FILE", false, false},
+	{"GLOSSARY_FILE", false, false},
+	{"BLOCKLIST_FILE", false, false},
+	{"MAINTENANCE_FILE", false, false},
+	{"TRANSCRIPT_SPILL_DIR", true, true},
+	{"DIAG_DIR", true, true},
+}
+
+func currentBuildInfo() BuildInfo {
+	b := BuildInfo{Version: "unknown"}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.GoVersion = info.GoVersion
+	if v := info.Main.Version; v != "" {
+		b.Version = v
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.time":
+			b.Time = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+func enabledFeatures() []string {
+	var on []string
+	for _, f := range startupFeatures {
+		if slices.ContainsFunc(f.envs, func(env string) bool { return os.Getenv(env) != "" }) {
+			on = append(on, f.name)
+		}
+	}
+	if !strings.EqualFold(os.Getenv("SLOS"), "off") {
+		on = append(on, "SLOs")
+	}
+	return on
+}
+
+// checkPath checks that the relay can read path and, if it writes there,
+// create files next to it.
+func checkPath(env, path string, dir, writes bool) StartupCheck {
+	c := StartupCheck{Name: env, Status: checkOK, Detail: path}
+	target := filepath.Dir(path)
+	if dir {
+		target = path
+	}
+	if info, err := os.Stat(path); err == nil && !dir && !info.IsDir() {
+		f, err := os.Open(path)
+		if err != nil {
+			c.Status, c.Detail = checkFailed, err.Error()
+			return c
+		}
+		f.Close()
+	} else if err != nil && !os.IsNotExist(err) {
+		c.Status, c.Detail = checkFailed, err.Error()
+		return c
+	} else if err != nil && !writes {
+		c.Status, c.Detail = checkWarning, path+" does not exist"
+		return c
+	}
+	if writes {
+		probe, err := os.CreateTemp(target, ".chatrelay-startup-*")
+		if err != nil {
+			c.Status, c.Detail = checkFailed, fmt.Sprintf("cannot write in %s: %v", target, err)
+			return c
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+	return c
+}
+
+func checkBackend(ctx context.Context) StartupCheck {
+	c := StartupCheck{Name: "backend", Status: checkOK}
+	if config.BackendURL == "" {
+		c.Status, c.Detail = checkWarning, "BACKEND_URL is not set; the setup wizard is running"
+		return c
+	}
+	if st := warmup.snapshot(); st.Attempted > 0 {
+		c.Detail = fmt.Sprintf("%d/%d warm-up requests succeeded", st.Succeeded, st.Attempted)
+		if st.Succeeded == 0 {
+			c.Status, c.Detail = checkFailed, c.Detail+": "+st.LastError
+		}
+		return c
+	}
+	start := time.Now()
+	if err := sendWarmupRequest(ctx, config.WarmupQuery); err != nil {
+		c.Status, c.Detail = checkFailed, err.Error()
+		return c
+	}
+	c.Detail = fmt.Sprintf("answered in %s", time.Since(start).Round(time.Millisecond))
+	return c
+}
+
+func checkScopes(ctx context.Context, client *http.Client) StartupCheck {
+	c := StartupCheck{Name: "slack_scopes", Status: checkOK, Detail: "every scope granted"}
+	missing, err := missingScopes(ctx, client, config.SlackAPIURL, config.SlackBotToken, append(append([]string{}, requiredScopes...), optionalScopes...))
+	switch {
+	case err != nil:
+		c.Status, c.Detail = checkFailed, err.Error()
+	case slices.ContainsFunc(missing, func(s string) bool { return slices.Contains(requiredScopes, s) }):
+		c.Status, c.Detail = checkFailed, "missing "+strings.Join(missing, ", ")
+	case len(missing) > 0:
+		c.Status, c.Detail = checkWarning, "missing optional "+strings.Join(missing, ", ")
+	}
+	return c
+}
+
+func checkStores(ctx context.Context, replica string) []StartupCheck {
+	tokens := StartupCheck{Name: "token_store", Status: checkOK}
+	if installs, err := workspaces.tokenStore().List(ctx); err != nil {
+		tokens.Status, tokens.Detail = checkFailed, err.Error()
+	} else {
+		tokens.Detail = fmt.Sprintf("%d installations", len(installs))
+	}
+	dedup := StartupCheck{Name: "event_dedup_store", Status: checkOK}
+	intake.mu.Lock()
+	store := intake.dedup
+	intake.mu.Unlock()
+	key := "startup:" + replica
+	if _, err := store.Claim(ctx, key, time.Minute); err != nil {
+		dedup.Status, dedup.Detail = checkFailed, err.Error()
+	} else {
+		store.Forget(ctx, key)
+	}
+	return []StartupCheck{tokens, dedup}
+}
+
+// buildStartupReport runs the checks; client makes the Slack scope check.
+func buildStartupReport(ctx context.Context, client *http.Client) StartupReport {
+	ctx, cancel := context.WithTimeout(ctx, startupCheckTimeout)
+	defer cancel()
+	leadership.mu.Lock()
+	replica := leadership.id
+	leadership.mu.Unlock()
+	r := StartupReport{At: time.Now(), Replica: replica, Build: currentBuildInfo(), Sources: config.Sources,
+		Features: enabledFeatures(), Flags: map[string]bool{}}
+	for name := range flagRegistry {
+		r.Flags[name] = flags.enabled(name, "", "")
+	}
+	r.Checks = append(r.Checks, checkBackend(ctx), checkScopes(ctx, client))
+	r.Checks = append(r.Checks, checkStores(ctx, replica)...)
+	for _, p := range startupPaths {
+		path := os.Getenv(p.env)
+		if p.env == "SETUP_FILE" {
+			path = config.SetupFile
+		}
+		if path != "" {
+			r.Checks = append(r.Checks, checkPath(p.env, path, p.dir, p.writes))
+		}
+	}
+	for _, c := range r.Checks {
+		metricStartupReport.Add("checks_"+c.Status, 1)
+	}
+	return r
+}
+
+// format renders the report for Slack.
+func (r StartupReport) format() string {
+	var b strings.Builder
+	build := r.Build.Version
+	if r.Build.Revision != "" {
+		rev := r.Build.Revision
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		build += " (" + rev
+		if r.Build.Modified {
+			build += ", modified"
+		}
+		build += ")"
+	}
+	fmt.Fprintf(&b, "*ChatRelayBot started* on `%s`: %s, %s\n", r.Replica, build, r.Build.GoVersion)
+	if len(r.Sources) > 0 {
+		fmt.Fprintf(&b, "*Config:* %s\n", strings.Join(r.Sources, ", "))
+	}
+	features := "none beyond the defaults"
+	if len(r.Features) > 0 {
+		features = strings.Join(r.Features, ", ")
+	}
+	fmt.Fprintf(&b, "*Features:* %s\n", features)
+	names := make([]string, 0, len(r.Flags))
+	for name := range r.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		state := "off"
+		if r.Flags[name] {
+			state = "on"
+		}
+		names[i] = name + " " + state
+	}
+	fmt.Fprintf(&b, "*Flags:* %s\n", strings.Join(names, ", "))
+	for _, c := range r.Checks {
+		icon := ":white_check_mark:"
+		switch c.Status {
+		case checkWarning:
+			icon = ":warning:"
+		case checkFailed:
+			icon = ":x:"
+		}
+		line := fmt.Sprintf("%s %s", icon, c.Name)
+		if c.Detail != "" {
+			line += ": " + c.Detail
+		}
+		b.WriteString(line + "\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// postStartupReport builds the report and posts it to ADMIN_CHANNEL as
+// STARTUP_REPORT allows.
+func postStartupReport(ctx context.Context, api SlackClient, client *http.Client) {
+	startupReport.mu.Lock()
+	mode := startupReport.mode
+	startupReport.mu.Unlock()
+	if mode == startupReportOff {
+		return
+	}
+	r := buildStartupReport(ctx, client)
+	startupReport.mu.Lock()
+	startupReport.latest = &r
+	startupReport.mu.Unlock()
+	if r.problems() {
+		slog.WarnContext(ctx, "Startup checks found problems; see GET /admin/startup")
+	}
+	if config.AdminChannel == "" || (mode == startupReportProblems && !r.problems()) {
+		return
+	}
+	alertAdmins(ctx, api, r.format())
+	metricStartupReport.Add("posted", 1)
+}
+
+// adminStartupHandler serves GET /admin/startup.
+func adminStartupHandler(w http.ResponseWriter, r *http.Request) {
+	startupReport.mu.Lock()
+	latest := startupReport.latest
+	startupReport.mu.Unlock()
+	if latest == nil {
+		http.Error(w, "no startup report yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(latest)
+}
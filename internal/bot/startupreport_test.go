@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startupReportServers starts a backend that answers the warm-up request
+// and a Slack API that grants scopes, and points config at them.
+func startupReportServers(scopes string) (backendServer, slackAPI *httptest.Server) {
+	backendServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"full_response":"pong"}`))
+	}))
+	slackAPI = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-OAuth-Scopes", scopes)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	config.BackendURL, config.SlackAPIURL, config.SlackBotToken = backendServer.URL, slackAPI.URL+"/", "xoxb-test"
+	config.AdminChannel, config.SetupFile, config.Sources = "CADMIN", "", []string{"config file relay.yaml", "environment"}
+	return backendServer, slackAPI
+}
+
+func TestStartupReport_ChecksAndFormat(t *testing.T) {
+	saved := config
+	defer func() {
+		config = saved
+		startupReport.mode, startupReport.latest = startupReportOn, nil
+		warmup.update(func(r *WarmupReport) { *r = WarmupReport{State: WarmupDisabled} })
+	}()
+	warmup.update(func(r *WarmupReport) { *r = WarmupReport{State: WarmupDisabled} })
+	backendServer, slackAPI := startupReportServers(strings.Join(append(append([]string{}, requiredScopes...), optionalScopes...), ","))
+	defer backendServer.Close()
+	defer slackAPI.Close()
+	client := slackAPI.Client()
+	dir := t.TempDir()
+	t.Setenv("OUTBOX_FILE", filepath.Join(dir, "outbox.json"))
+	t.Setenv("GLOSSARY_FILE", filepath.Join(dir, "missing.json"))
+	t.Setenv("DIAG_DIR", filepath.Join(dir, "nope"))
+	t.Setenv("WEBHOOK_URLS", "https://hooks.example.com")
+	t.Setenv("SLOS", "off")
+
+	r := buildStartupReport(context.Background(), client)
+	status := map[string]string{}
+	for _, c := range r.Checks {
+		status[c.Name] = c.Status
+	}
+	want := map[string]string{"backend": checkOK, "slack_scopes": checkOK, "token_store": checkOK, "event_dedup_store": checkOK,
+		"OUTBOX_FILE": checkOK, "GLOSSARY_FILE": checkWarning, "DIAG_DIR": checkFailed}
+	for name, s := range want {
+		if status[name] != s {
+			t.Errorf("check %s = %q, want %q", name, status[name], s)
+		}
+	}
+	if !r.problems() {
+		t.Error("report with a failed check has no problems")
+	}
+	if len(r.Features) != 2 || r.Features[0] != "webhooks" || r.Features[1] != "glossary" {
+		t.Errorf("features = %v", r.Features)
+	}
+	text := r.format()
+	for _, s := range []string{"*ChatRelayBot started*", "config file relay.yaml", "*Flags:* moderation on, retrieval on, streaming on",
+		":x: DIAG_DIR: cannot write in", ":warning: GLOSSARY_FILE"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("report missing %q:\n%s", s, text)
+		}
+	}
+}
+
+func TestPostStartupReport_Modes(t *testing.T) {
+	saved := config
+	defer func() {
+		config = saved
+		startupReport.mode, startupReport.latest = startupReportOn, nil
+		warmup.update(func(r *WarmupReport) { *r = WarmupReport{State: WarmupDisabled} })
+	}()
+	warmup.update(func(r *WarmupReport) { *r = WarmupReport{State: WarmupDisabled} })
+	backendServer, slackAPI := startupReportServers("chat:write")
+	defer backendServer.Close()
+	defer slackAPI.Close()
+	client := slackAPI.Client()
+
+	t.Setenv("STARTUP_REPORT", "sometimes")
+	if err := configureStartupReport(); err == nil {
+		t.Error("invalid STARTUP_REPORT accepted")
+	}
+
+	t.Setenv("STARTUP_REPORT", "off")
+	configureStartupReport()
+	api := &fakeSlackClient{}
+	postStartupReport(context.Background(), api, client)
+	if len(api.sent()) != 0 || startupReport.latest != nil {
+		t.Fatal("report built with STARTUP_REPORT=off")
+	}
+
+	t.Setenv("STARTUP_REPORT", "problems")
+	configureStartupReport()
+	postStartupReport(context.Background(), api, client)
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Channel != "CADMIN" || !strings.Contains(posts[0].Text(), ":x: slack_scopes: missing") {
+		t.Fatalf("posts = %+v", posts)
+	}
+
+	rec := httptest.NewRecorder()
+	adminStartupHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/startup", nil))
+	var got StartupReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.Build.GoVersion == "" || len(got.Checks) == 0 {
+		t.Errorf("/admin/startup = %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,382 @@
+package bot
+
+import (
+	"expvar"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Conversation Store
+//
+// Answers are kept in memory so follow-up actions (tickets, edits, reactions)
+// can find the question and answer behind a posted message. The store is
+// bounded: a record is evicted CONVERSATION_TTL (default 720h) after it was
+// saved, and the oldest records go first once more than CONVERSATION_MAX
+// (default 50000) are kept. Everything that looks back at past answers
+// (search, analytics, digests, edits, tickets) only sees what is still
+// kept, and nothing survives a restart. Records are indexed by message,
+// thread, user and channel. Kept and evicted records are counted under
+// "conversation_store" on /debug/vars.
+const (
+	defaultConversationTTL = 30 * 24 * time.Hour
+	defaultConversationMax = 50000
+)
+
+var metricConversationStore = expvar.NewMap("conversation_store")
+
+type ConversationRecord struct {
+	ID        string
+	Channel   string
+	ThreadTS  string
+	User      string
+	Query     string
+	QueryTS   string
+	Model     string
+	Answer    []string
+	MessageTS []string
+	// AnswerTS are the messages in MessageTS that hold answer chunks, as
+	// opposed to disclaimers, notices and buttons.
+	AnswerTS  []string
+	Escalated bool
+	TicketURL string
+	// Escalating is set while a ticket is being created; see ticket.go.
+	Escalating bool
+	Edits      []AnswerEdit
+	CreatedAt  time.Time
+	// Embedding is the question's vector when semantic FAQ matching is
+	// enabled; see faq.go.
+	Embedding []float32
+	// Redacted is set once the answer has been redacted; see redact.go.
+	Redacted bool
+	// Unanswered is set when the backend flagged that it had no answer;
+	// see knowledgegap.go.
+	Unanswered bool
+	// Withdrawn is set once the asker undid the answer; see undo.go.
+	Withdrawn bool
+	// Source is the entry point the question came in through; see
+	// source.go.
+	Source string
+	// Persona is the persona version that answered, e.g. "support@v3";
+	// see persona.go.
+	Persona string
+	// Cost is what the backend request cost, as charged to the budget;
+	// see budget.go.
+	Cost float64
+	// Feedback holds ratings from reactions and evaluation labels; see
+	// analytics.go.
+	Feedback []AnswerFeedback
+	// CanvasID and CanvasSection locate the answer's entry in its
+	// channel's Q&A canvas; see canvas.go.
+	CanvasID      string
+	CanvasSection string
+}
+
+type AnswerFeedback struct {
+	At     time.Time
+	By     string
+	Rating string
+	Source string
+}
+
+type AnswerEdit struct {
+	At          time.Time
+	By          string
+	Instruction string
+	Previous    []string
+}
+
+func (r *ConversationRecord) AnswerText() string {
+	return strings.Join(r.Answer, "\n")
+}
+
+// answerMessages returns the messages holding the answer's chunks; a
+// record without AnswerTS has its answer in its first message.
+func (r *ConversationRecord) answerMessages() []string {
+	if len(r.AnswerTS) > 0 || len(r.MessageTS) == 0 {
+		return r.AnswerTS
+	}
+	return r.MessageTS[:1]
+}
+
+type ConversationStore struct {
+	mu        sync.RWMutex
+	ttl       time.Duration
+	max       int
+	now       func() time.Time
+	records   map[string]*ConversationRecord
+	saved     map[string]time.Time
+	order     []string
+	byMessage map[string]string
+	byThread  map[string][]string
+	byUser    map[string][]string
+	byChannel map[string][]string
+	// resets holds when each thread was last started over; see reset.go.
+	resets map[string]time.Time
+}
+
+func NewConversationStore() *ConversationStore {
+	return &ConversationStore{
+		ttl:       defaultConversationTTL,
+		max:       defaultConversationMax,
+		now:       time.Now,
+		records:   make(map[string]*ConversationRecord),
+		saved:     make(map[string]time.Time),
+		byMessage: make(map[string]string),
+		byThread:  make(map[string][]string),
+		byUser:    make(map[string][]string),
+		byChannel: make(map[string][]string),
+		resets:    make(map[string]time.Time),
+	}
+}
+
+// configure bounds how long records are kept and how many; zero keeps the
+// default.
+func (s *ConversationStore) configure(ttl time.Duration, max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ttl > 0 {
+		s.ttl = ttl
+	}
+	if max > 0 {
+		s.max = max
+	}
+	s.evictLocked()
+}
+
+var conversations = NewConversationStore()
+
+func messageKey(channel, ts string) string {
+	return channel + "/" + ts
+}
+
+func (s *ConversationStore) Save(rec *ConversationRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = s.now()
+	}
+	if _, ok := s.records[rec.ID]; ok {
+		s.removeLocked(rec.ID)
+	}
+	s.records[rec.ID] = rec
+	s.saved[rec.ID] = s.now()
+	s.order = append(s.order, rec.ID)
+	thread := messageKey(rec.Channel, rec.ThreadTS)
+	s.byThread[thread] = append(s.byThread[thread], rec.ID)
+	s.byUser[rec.User] = append(s.byUser[rec.User], rec.ID)
+	s.byChannel[rec.Channel] = append(s.byChannel[rec.Channel], rec.ID)
+	for _, ts := range rec.MessageTS {
+		s.byMessage[messageKey(rec.Channel, ts)] = rec.ID
+	}
+	s.evictLocked()
+	metricConversationStore.Set("records", intVar(len(s.records)))
+}
+
+// evictLocked drops records saved longer than the TTL ago, then the oldest
+// records beyond the size bound. s.order is in save order, so both stop at
+// the first record that stays.
+func (s *ConversationStore) evictLocked() {
+	cutoff := s.now().Add(-s.ttl)
+	for len(s.order) > 0 {
+		id := s.order[0]
+		switch {
+		case s.saved[id].Before(cutoff):
+			metricConversationStore.Add("evicted_expired", 1)
+		case len(s.records) > s.max:
+			metricConversationStore.Add("evicted_full", 1)
+		default:
+			return
+		}
+		s.removeLocked(id)
+	}
+}
+
+// removeLocked drops the record with id and its index entries.
+func (s *ConversationStore) removeLocked(id string) {
+	rec, ok := s.records[id]
+	if !ok {
+		return
+	}
+	delete(s.records, id)
+	delete(s.saved, id)
+	if i := slices.Index(s.order, id); i >= 0 {
+		s.order = slices.Delete(s.order, i, i+1)
+	}
+	unindex(s.byThread, messageKey(rec.Channel, rec.ThreadTS), id)
+	unindex(s.byUser, rec.User, id)
+	unindex(s.byChannel, rec.Channel, id)
+	for _, ts := range rec.MessageTS {
+		if key := messageKey(rec.Channel, ts); s.byMessage[key] == id {
+			delete(s.byMessage, key)
+		}
+	}
+}
+
+func unindex(index map[string][]string, key, id string) {
+	ids := slices.DeleteFunc(index[key], func(v string) bool { return v == id })
+	if len(ids) == 0 {
+		delete(index, key)
+		return
+	}
+	index[key] = ids
+}
+
+// AddMessage links a message posted after the record was saved, such as a
+// late outbox delivery, to the conversation.
+func (s *ConversationStore) AddMessage(id, ts string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[id]
+	if !ok {
+		return false
+	}
+	rec.MessageTS = append(rec.MessageTS, ts)
+	s.byMessage[messageKey(rec.Channel, ts)] = id
+	return true
+}
+
+// Get returns a copy so callers can read it without holding the lock.
+func (s *ConversationStore) Get(id string) (ConversationRecord, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.records[id]
+	if !ok {
+		return ConversationRecord{}, false
+	}
+	return *rec, true
+}
+
+func (s *ConversationStore) ByMessage(channel, ts string) (ConversationRecord, bool) {
+	s.mu.RLock()
+	id, ok := s.byMessage[messageKey(channel, ts)]
+	s.mu.RUnlock()
+	if !ok {
+		return ConversationRecord{}, false
+	}
+	return s.Get(id)
+}
+
+// LatestInThread finds the most recent answer to a question asked in the
+// given thread since it was last reset, leaving out withdrawn and redacted
+// answers.
+func (s *ConversationStore) LatestInThread(channel, threadTS string) (ConversationRecord, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key := messageKey(channel, threadTS)
+	reset := s.resets[key]
+	var latest *ConversationRecord
+	for _, id := range s.byThread[key] {
+		rec := s.records[id]
+		if rec.CreatedAt.Before(reset) || rec.Withdrawn || rec.Redacted {
+			continue
+		}
+		if latest == nil || rec.CreatedAt.After(latest.CreatedAt) {
+			latest = rec
+		}
+	}
+	if latest == nil {
+		return ConversationRecord{}, false
+	}
+	return *latest, true
+}
+
+// ResetThread starts the conversation in a thread over; answers saved
+// before now are no longer its latest.
+func (s *ConversationStore) ResetThread(channel, threadTS string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	// A reset older than the TTL hides only records already evicted.
+	for key, at := range s.resets {
+		if now.Sub(at) > s.ttl {
+			delete(s.resets, key)
+		}
+	}
+	s.resets[messageKey(channel, threadTS)] = now
+}
+
+// ForUser returns the user's conversations created since the given time,
+// oldest first.
+func (s *ConversationStore) ForUser(user string, since time.Time) []ConversationRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []ConversationRecord
+	for _, id := range s.byUser[user] {
+		if rec := s.records[id]; !rec.CreatedAt.Before(since) {
+			out = append(out, *rec)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// All returns a copy of every stored conversation.
+func (s *ConversationStore) All() []ConversationRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]ConversationRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		out = append(out, *rec)
+	}
+	return out
+}
+
+// MostSimilar returns the answered conversation in channel whose question
+// is closest to vec by cosine similarity, and its score. Only answers still
+// posted in the channel count: withdrawn and redacted ones are skipped, and
+// so are answers that were never posted, such as those a reviewer held or
+// rejected.
+func (s *ConversationStore) MostSimilar(channel string, vec []float32) (ConversationRecord, float64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var best *ConversationRecord
+	bestScore := 0.0
+	for _, id := range s.byChannel[channel] {
+		rec := s.records[id]
+		if len(rec.Answer) == 0 || len(rec.MessageTS) == 0 || rec.Withdrawn || rec.Redacted {
+			continue
+		}
+		if score := cosineSimilarity(vec, rec.Embedding); score > bestScore {
+			best, bestScore = rec, score
+		}
+	}
+	if best == nil {
+		return ConversationRecord{}, 0
+	}
+	return *best, bestScore
+}
+
+// Redact drops the answer text, its edit history and the question vector,
+// keeping the record so its messages still resolve.
+func (s *ConversationStore) Redact(id string) bool {
+	return s.Update(id, func(rec *ConversationRecord) {
+		rec.Answer, rec.Edits, rec.Embedding, rec.Redacted = nil, nil, nil, true
+	})
+}
+
+// AddFeedback appends a rating to the conversation's feedback.
+func (s *ConversationStore) AddFeedback(id string, f AnswerFeedback) bool {
+	if f.At.IsZero() {
+		f.At = time.Now()
+	}
+	return s.Update(id, func(rec *ConversationRecord) {
+		rec.Feedback = append(rec.Feedback, f)
+	})
+}
+
+func (s *ConversationStore) Update(id string, fn func(rec *ConversationRecord)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[id]
+	if !ok {
+		return false
+	}
+	fn(rec)
+	for _, ts := range rec.MessageTS {
+		s.byMessage[messageKey(rec.Channel, ts)] = rec.ID
+	}
+	return true
+}
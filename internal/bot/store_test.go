@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConversationStore_EvictsExpiredAndOldest(t *testing.T) {
+	now := time.Now()
+	store := NewConversationStore()
+	store.now = func() time.Time { return now }
+	store.configure(time.Hour, 2)
+
+	store.Save(&ConversationRecord{ID: "a", Channel: "C1", ThreadTS: "1.1", User: "U1", Answer: []string{"a"}, MessageTS: []string{"1.2"}})
+	now = now.Add(30 * time.Minute)
+	store.Save(&ConversationRecord{ID: "b", Channel: "C1", ThreadTS: "1.1", User: "U1", Answer: []string{"b"}})
+	store.Save(&ConversationRecord{ID: "c", Channel: "C2", User: "U2", Answer: []string{"c"}})
+
+	// Over the bound, the oldest record goes with its index entries.
+	if _, ok := store.Get("a"); ok {
+		t.Error("oldest record kept past CONVERSATION_MAX")
+	}
+	if _, ok := store.ByMessage("C1", "1.2"); ok {
+		t.Error("evicted record still found by message")
+	}
+	if rec, ok := store.LatestInThread("C1", "1.1"); !ok || rec.ID != "b" {
+		t.Errorf("latest in thread = %+v, %v", rec, ok)
+	}
+	if recs := store.ForUser("U1", time.Time{}); len(recs) != 1 || recs[0].ID != "b" {
+		t.Errorf("ForUser = %+v", recs)
+	}
+
+	now = now.Add(45 * time.Minute)
+	store.Save(&ConversationRecord{ID: "d", Channel: "C2", User: "U2", Answer: []string{"d"}})
+	if _, ok := store.Get("b"); ok {
+		t.Error("record kept past CONVERSATION_TTL")
+	}
+	if _, ok := store.LatestInThread("C1", "1.1"); ok {
+		t.Error("expired record still the thread's latest")
+	}
+	if len(store.byThread) != 1 || len(store.byUser) != 1 || len(store.byChannel) != 1 {
+		t.Errorf("indexes not pruned: %v %v %v", store.byThread, store.byUser, store.byChannel)
+	}
+	if all := store.All(); len(all) != 2 {
+		t.Errorf("All = %+v", all)
+	}
+}
+
+func TestConversationStore_MostSimilarSkipsUnpostedAnswers(t *testing.T) {
+	store := NewConversationStore()
+	vec := []float32{1, 0}
+	save := func(id string, edit func(*ConversationRecord)) {
+		rec := &ConversationRecord{ID: id, Channel: "C1", Query: "vpn?", Answer: []string{"Use the VPN client."}, MessageTS: []string{id + ".1"}, Embedding: vec}
+		if edit != nil {
+			edit(rec)
+		}
+		store.Save(rec)
+	}
+	save("withdrawn", func(r *ConversationRecord) { r.Withdrawn = true })
+	save("held", func(r *ConversationRecord) { r.MessageTS = nil })
+	save("redacted", nil)
+	store.Redact("redacted")
+	if rec, score := store.MostSimilar("C1", vec); score > 0 {
+		t.Errorf("MostSimilar = %+v (%.2f), want no withdrawn, held or redacted answer", rec, score)
+	}
+
+	save("posted", nil)
+	if rec, score := store.MostSimilar("C1", vec); rec.ID != "posted" || score < 0.99 {
+		t.Errorf("MostSimilar = %+v (%.2f), want the posted answer", rec, score)
+	}
+	// Redacted records lose their vector; flag one that still has it.
+	store.Update("posted", func(r *ConversationRecord) { r.Redacted = true })
+	if rec, score := store.MostSimilar("C1", vec); score > 0 {
+		t.Errorf("redacted answer reused: %+v", rec)
+	}
+}
@@ -0,0 +1,118 @@
+package bot
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+)
+
+// Stream Validation
+//
+// STREAM_VALIDATION controls how streamed chunks are checked. "off" (the
+// default) keeps the lenient parser. "repair" drops chunks that are
+// malformed, have an unknown event, an empty message_part, or an ID that
+// does not increase, and fixes invalid UTF-8. "strict" stops the answer at
+// the first such chunk and tells the user why, so a misbehaving backend
+// never produces garbled output.
+const (
+	StreamValidationOff    = "off"
+	StreamValidationRepair = "repair"
+	StreamValidationStrict = "strict"
+)
+
+var knownStreamEvents = map[string]bool{
+	"message_part": true,
+	"blocks":       true,
+	"form":         true,
+	"image":        true,
+	"chart":        true,
+	"stream_end":   true,
+	"error":        true,
+}
+
+var metricStreamViolations = expvar.NewMap("stream_violations")
+
+func validateStreamValidation(mode string) error {
+	switch mode {
+	case "", StreamValidationOff, StreamValidationRepair, StreamValidationStrict:
+		return nil
+	}
+	return fmt.Errorf("unknown STREAM_VALIDATION %q (use off, repair or strict)", mode)
+}
+
+type streamViolation struct {
+	Kind   string
+	Chunk  int
+	Detail string
+}
+
+func (v *streamViolation) Error() string {
+	return fmt.Sprintf("chunk %d: %s", v.Chunk, v.Detail)
+}
+
+type streamValidator struct {
+	mode   string
+	chunks int
+	lastID int
+}
+
+func newStreamValidator(mode string) *streamValidator {
+	if mode == "" {
+		mode = StreamValidationOff
+	}
+	return &streamValidator{mode: mode}
+}
+
+func (v *streamValidator) enabled() bool {
+	return v.mode != StreamValidationOff
+}
+
+func (v *streamValidator) strict() bool {
+	return v.mode == StreamValidationStrict
+}
+
+func (v *streamValidator) violation(kind, format string, args ...any) *streamViolation {
+	metricStreamViolations.Add(kind, 1)
+	return &streamViolation{Kind: kind, Chunk: v.chunks, Detail: fmt.Sprintf(format, args...)}
+}
+
+// malformed reports a data line that is not valid JSON.
+func (v *streamValidator) malformed(err error) *streamViolation {
+	v.chunks++
+	return v.violation("malformed", "invalid JSON: %v", err)
+}
+
+// check validates msg, repairing its text in repair mode, and returns the
+// violation that makes it unusable, if any.
+func (v *streamValidator) check(msg *backend.ChatResponse) *streamViolation {
+	v.chunks++
+	if !knownStreamEvents[msg.Event] {
+		return v.violation("unknown_event", "unknown event %q", msg.Event)
+	}
+	if msg.ID != 0 {
+		if msg.ID <= v.lastID {
+			return v.violation("id_order", "id %d after %d", msg.ID, v.lastID)
+		}
+		v.lastID = msg.ID
+	}
+	if !utf8.ValidString(msg.Text) {
+		if v.strict() {
+			return v.violation("invalid_utf8", "text is not valid UTF-8")
+		}
+		metricStreamViolations.Add("invalid_utf8", 1)
+		msg.Text = strings.ToValidUTF8(msg.Text, "�")
+	}
+	if msg.Event == "message_part" && strings.TrimSpace(msg.Text) == "" {
+		return v.violation("empty_text", "message_part without text")
+	}
+	if msg.Event == "form" && msg.Form == nil {
+		return v.violation("empty_form", "form event without a form")
+	}
+	if (msg.Event == "image" || msg.Event == "chart") && msg.Image == nil {
+		return v.violation("empty_image", "%s event without an image", msg.Event)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// misbehavingStream sends valid chunks mixed with every kind of violation.
+func misbehavingStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, data := range []string{
+		`{"id":1,"event":"message_part","text_chunk":"First"}`,
+		`{"id":2,"event":"message_part","text_chunk":"  "}`,
+		`{"id":3,"event":"telemetry","text_chunk":"debug noise"}`,
+		`{"id":5,"event":"message_part","text_chunk":"Fifth"}`,
+		`{"id":4,"event":"message_part","text_chunk":"Late"}`,
+		`{"id":6,"event":"message_part","text_chunk":"Sixth`,
+		"{\"id\":7,\"event\":\"message_part\",\"text_chunk\":\"Bad \xff byte\"}",
+		`{"id":8,"event":"stream_end","status":"done"}`,
+	} {
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+}
+
+func streamWithValidation(t *testing.T, mode string) []fakePost {
+	t.Helper()
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	config.StreamValidation = mode
+	defer func() { config.StreamValidation = "" }()
+	ts := httptest.NewServer(http.HandlerFunc(misbehavingStream))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CSTREAM"}, "question")
+	return api.sent()
+}
+
+func TestStreamValidation_Repair(t *testing.T) {
+	posts := streamWithValidation(t, StreamValidationRepair)
+	var texts []string
+	for _, p := range posts {
+		texts = append(texts, p.Text())
+	}
+	if want := []string{"First", "Fifth", "Bad � byte"}; strings.Join(texts, "|") != strings.Join(want, "|") {
+		t.Errorf("posts = %q, want %q", texts, want)
+	}
+}
+
+func TestStreamValidation_StrictAborts(t *testing.T) {
+	posts := streamWithValidation(t, StreamValidationStrict)
+	if len(posts) != 2 || posts[0].Text() != "First" {
+		t.Fatalf("unexpected posts %+v", posts)
+	}
+	if !strings.Contains(posts[1].Text(), "invalid response") || !strings.Contains(posts[1].Text(), "chunk 2") {
+		t.Errorf("abort notice = %q", posts[1].Text())
+	}
+}
+
+func TestStreamValidation_OffKeepsLenientParsing(t *testing.T) {
+	posts := streamWithValidation(t, "")
+	if len(posts) != 5 {
+		t.Errorf("expected the lenient parser to post every parsable message_part, got %d", len(posts))
+	}
+	if err := validateStreamValidation("paranoid"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Streaming Fallback
+//
+// Streamed answers need the backend to honor "Accept: text/event-stream"
+// and every proxy on the way to pass events through as they are written.
+// A backend that ignores the header answers with JSON, which is posted as
+// a whole answer and logged as a downgrade. A proxy that buffers the stream
+// shows up as an open event stream that sends nothing: when not a single
+// line arrives within STREAM_STALL_TIMEOUT (default
+// defaultStreamStallTimeout, 0 turns detection off), the stream is dropped
+// and the question is asked again without streaming, before anything has
+// been posted. Afterwards that backend URL is asked without streaming for
+// STREAM_DOWNGRADE_FOR (default defaultStreamDowngrade), so later questions
+// do not wait out the timeout, and then streaming is tried again.
+// Downgrades are logged, tagged "stream.fallback" on the request's span and
+// counted under "stream_fallback" on /debug/vars.
+const (
+	defaultStreamStallTimeout = 20 * time.Second
+	defaultStreamDowngrade    = 10 * time.Minute
+
+	streamFallbackIgnoredAccept = "ignored_accept"
+	streamFallbackStalled       = "stalled"
+	streamFallbackDowngraded    = "downgraded"
+)
+
+var metricStreamFallback = expvar.NewMap("stream_fallback")
+
+type streamFallbackState struct {
+	mu        sync.Mutex
+	stall     time.Duration
+	downgrade time.Duration
+	// until is when each downgraded backend URL is streamed to again.
+	until map[string]time.Time
+	now   func() time.Time
+}
+
+func newStreamFallbackState(stall, downgrade time.Duration) *streamFallbackState {
+	return &streamFallbackState{stall: stall, downgrade: downgrade, until: make(map[string]time.Time), now: time.Now}
+}
+
+var streamFallback = newStreamFallbackState(defaultStreamStallTimeout, defaultStreamDowngrade)
+
+func configureStreamFallback() error {
+	stall, downgrade := defaultStreamStallTimeout, defaultStreamDowngrade
+	for _, d := range []struct {
+		name string
+		dst  *time.Duration
+	}{{"STREAM_STALL_TIMEOUT", &stall}, {"STREAM_DOWNGRADE_FOR", &downgrade}} {
+		if v := os.Getenv(d.name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < 0 {
+				return fmt.Errorf("invalid %s %q: use a duration such as 20s, or 0 to turn it off", d.name, v)
+			}
+			*d.dst = parsed
+		}
+	}
+	streamFallback.mu.Lock()
+	defer streamFallback.mu.Unlock()
+	streamFallback.stall, streamFallback.downgrade = stall, downgrade
+	streamFallback.until = make(map[string]time.Time)
+	return nil
+}
+
+// stallTimeout is how long an event stream may stay silent before it is
+// given up; 0 means never.
+func (s *streamFallbackState) stallTimeout() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stall
+}
+
+// downgraded reports whether requests to url skip streaming for now.
+func (s *streamFallbackState) downgraded(url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.until[url]
+	if ok && !s.now().Before(until) {
+		delete(s.until, url)
+		return false
+	}
+	return ok
+}
+
+// stalled records that url's event stream was buffered.
+func (s *streamFallbackState) stalled(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.downgrade > 0 {
+		s.until[url] = s.now().Add(s.downgrade)
+	}
+}
+
+// streamWatchdog cancels a stream that sends nothing before the stall
+// timeout.
+type streamWatchdog struct {
+	timer *time.Timer
+	fired chan struct{}
+}
+
+// watchStream starts a watchdog calling cancel unless stop is called
+// first; with no stall timeout it never fires.
+func watchStream(cancel context.CancelFunc) *streamWatchdog {
+	w := &streamWatchdog{fired: make(chan struct{})}
+	if d := streamFallback.stallTimeout(); d > 0 {
+		w.timer = time.AfterFunc(d, func() {
+			close(w.fired)
+			cancel()
+		})
+	}
+	return w
+}
+
+// stop disarms the watchdog once the stream has sent something or ended.
+func (w *streamWatchdog) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// stalled reports whether the watchdog gave up on the stream.
+func (w *streamWatchdog) stalled() bool {
+	select {
+	case <-w.fired:
+		return true
+	default:
+		return false
+	}
+}
+
+// requestWithoutStreaming asks the backend for reqBody as a whole JSON
+// answer.
+func requestWithoutStreaming(ctx context.Context, reqBody []byte) (*http.Response, error) {
+	req, err := newBackendRequest(ctx, reqBody, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := backendClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("backend returned %s", resp.Status)
+	}
+	if err := decodeBackendResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func logStreamFallback(ctx context.Context, reason, detail string) {
+	metricStreamFallback.Add(reason, 1)
+	slog.WarnContext(ctx, "Answering without streaming", "reason", reason, "detail", detail)
+}
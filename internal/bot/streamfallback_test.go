@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// fallbackCount reads one of the stream_fallback counters.
+func fallbackCount(reason string) int64 {
+	n, _ := metricStreamFallback.Get(reason).(*expvar.Int)
+	if n == nil {
+		return 0
+	}
+	return n.Value()
+}
+
+func TestProcessTask_RetriesStalledStreamWithoutStreaming(t *testing.T) {
+	defer func(d time.Duration, url string, f *streamFallbackState) {
+		postInterval, config.BackendURL, streamFallback = d, url, f
+	}(postInterval, config.BackendURL, streamFallback)
+	postInterval, streamFallback = 0, newStreamFallbackState(50*time.Millisecond, time.Minute)
+	var (
+		mu      sync.Mutex
+		accepts []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		accepts = append(accepts, r.Header.Get("Accept"))
+		mu.Unlock()
+		if r.Header.Get("Accept") == "text/event-stream" {
+			// A buffering proxy: the stream opens but nothing comes through.
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"full_response":"Whole answer."}`))
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	stalled := fallbackCount(streamFallbackStalled)
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CSTALL"}, "foo")
+	if posts := api.sent(); len(posts) != 1 || posts[0].Text() != "Whole answer." {
+		t.Fatalf("expected the non-streaming answer, got %+v", posts)
+	}
+	if stalled == fallbackCount(streamFallbackStalled) {
+		t.Error("stalled stream not counted")
+	}
+
+	// The backend is asked without streaming until the downgrade expires.
+	processTask(context.Background(), &fakeSlackClient{}, slackevents.AppMentionEvent{User: "U1", Channel: "CSTALL"}, "foo")
+	want := []string{"text/event-stream", "application/json", "application/json"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(accepts) != len(want) {
+		t.Fatalf("Accept headers %v, want %v", accepts, want)
+	}
+	for i := range want {
+		if accepts[i] != want[i] {
+			t.Errorf("request %d Accept %q, want %q", i+1, accepts[i], want[i])
+		}
+	}
+
+	streamFallback.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if streamFallback.downgraded(ts.URL) {
+		t.Error("downgrade did not expire")
+	}
+}
+
+func TestProcessTask_BackendIgnoresStreamingAccept(t *testing.T) {
+	defer func(d time.Duration, url string, f *streamFallbackState) {
+		postInterval, config.BackendURL, streamFallback = d, url, f
+	}(postInterval, config.BackendURL, streamFallback)
+	postInterval, streamFallback = 0, newStreamFallbackState(time.Second, time.Minute)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"full_response":"Plain answer."}`))
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	ignored := fallbackCount(streamFallbackIgnoredAccept)
+	api := &fakeSlackClient{}
+	processTask(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CJSON"}, "foo")
+	if posts := api.sent(); len(posts) != 1 || posts[0].Text() != "Plain answer." {
+		t.Fatalf("expected the JSON answer, got %+v", posts)
+	}
+	if ignored == fallbackCount(streamFallbackIgnoredAccept) {
+		t.Error("ignored Accept header not counted")
+	}
+	if streamFallback.downgraded(ts.URL) {
+		t.Error("a JSON reply should not downgrade later requests")
+	}
+
+	t.Setenv("STREAM_STALL_TIMEOUT", "soon")
+	if err := configureStreamFallback(); err == nil {
+		t.Error("invalid STREAM_STALL_TIMEOUT accepted")
+	}
+}
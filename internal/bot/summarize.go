@@ -0,0 +1,182 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Chunked Summarization
+//
+// A question longer than BACKEND_MAX_INPUT_CHARS (a pasted log, an error
+// snippet, a long thread) would not fit the backend's context window. Such
+// input is split into overlapping parts of half that size, each part is
+// summarized by the backend in parallel on the worker pool, and the final
+// request carries the part summaries as context together with the
+// beginning and end of the original text as its query. The task that asks
+// for the summaries works through parts itself and borrows only idle
+// workers, so a busy pool slows it down but can never deadlock it. If some
+// parts fail the answer is built from the rest, and the backend is told
+// what is missing; if more than half fail the request fails. Inputs longer
+// than maxSummaryParts parts lose their middle first. Each stage has its own
+// span, and runs, parts and failures are counted under
+// "chunked_summaries" on /debug/vars.
+const (
+	defaultMaxInputChars = 32000
+	maxSummaryParts      = 24
+	summaryQueryChars    = 2000
+
+	summaryPartInstruction = "The question is part %d of %d of a long input the user sent. Summarize it in a few bullets, keeping error messages, names, numbers and anything else needed to answer questions about the whole input. The input begins:\n%s"
+	summaryNote            = "The user's input was too long to send whole. The question keeps its beginning and end, and the following context summarizes all of it part by part."
+)
+
+var (
+	metricChunkedSummaries = expvar.NewMap("chunked_summaries")
+	errSummaryFailed       = errors.New("too many parts of the input could not be summarized")
+)
+
+// maxInputChars returns the longest query sent to the backend as is.
+func maxInputChars() int {
+	if config.MaxInputChars > 0 {
+		return config.MaxInputChars
+	}
+	return defaultMaxInputChars
+}
+
+// splitOverlapping splits text into parts of at most size bytes, each
+// starting about overlap bytes before the previous one ends. Parts end
+// after a newline when there is one in their second half.
+func splitOverlapping(text string, size, overlap int) []string {
+	overlap = min(overlap, size/4)
+	var parts []string
+	for start := 0; start < len(text); {
+		end := start + size
+		if end >= len(text) {
+			parts = append(parts, text[start:])
+			break
+		}
+		if i := strings.LastIndexByte(text[start+size/2:end], '\n'); i >= 0 {
+			end = start + size/2 + i + 1
+		} else {
+			for !utf8.RuneStart(text[end]) {
+				end--
+			}
+		}
+		parts = append(parts, text[start:end])
+		next := end - overlap
+		if i := strings.IndexByte(text[next:end], '\n'); i >= 0 && next+i+1 < end {
+			next += i + 1
+		} else {
+			for !utf8.RuneStart(text[next]) {
+				next++
+			}
+		}
+		start = next
+	}
+	return parts
+}
+
+// runParallel calls fn for 0..n-1, on the calling goroutine and on as many
+// idle workers of pool as help. Helpers that start after every index was
+// taken return at once, so waiting never depends on queued tasks.
+func runParallel(pool *workerpool.Pool, n int, fn func(i int)) {
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(n)
+	work := func() {
+		for {
+			i := int(next.Add(1)) - 1
+			if i >= n {
+				return
+			}
+			fn(i)
+			wg.Done()
+		}
+	}
+	for helpers := 0; pool != nil && helpers < n-1 && !pool.Saturated(); helpers++ {
+		if !pool.TrySubmit(work) {
+			break
+		}
+	}
+	work()
+	wg.Wait()
+}
+
+// condenseRequest returns req unchanged when its query fits, and otherwise
+// with the query summarized part by part as described above.
+func condenseRequest(ctx context.Context, pool *workerpool.Pool, req backend.ChatRequest) (backend.ChatRequest, error) {
+	limit := maxInputChars()
+	if len(req.Query) <= limit {
+		return req, nil
+	}
+	ctx, span := otel.Tracer("bot").Start(ctx, "summarize_long_input")
+	defer span.End()
+
+	size := limit / 2
+	text := req.Query
+	if clipAt := size * maxSummaryParts * 9 / 10; len(text) > clipAt {
+		text = clipMiddle(text, clipAt)
+	}
+	parts := splitOverlapping(text, size, size/10)
+	span.SetAttributes(attribute.Int("input.chars", len(req.Query)), attribute.Int("summary.parts", len(parts)))
+	requests.record(ctx, "summarize_start", fmt.Sprintf("%d chars in %d parts", len(req.Query), len(parts)))
+	metricChunkedSummaries.Add("runs", 1)
+	metricChunkedSummaries.Add("parts", int64(len(parts)))
+
+	head := truncate(text, 300)
+	summaries := make([]string, len(parts))
+	errs := make([]error, len(parts))
+	runParallel(pool, len(parts), func(i int) {
+		ctx, span := otel.Tracer("bot").Start(ctx, "summarize_part")
+		defer span.End()
+		span.SetAttributes(attribute.Int("summary.part", i+1))
+		summary, err := requestAnswer(ctx, backend.ChatRequest{UserID: req.UserID, ChannelID: req.ChannelID, Query: parts[i],
+			Instruction: fmt.Sprintf(summaryPartInstruction, i+1, len(parts), head), DisableInternalRetrieval: true, NoStore: req.NoStore})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "part failed")
+		}
+		summaries[i], errs[i] = strings.TrimSpace(summary), err
+	})
+
+	notes := []string{summaryNote}
+	var failed []string
+	for i, summary := range summaries {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Sprint(i+1))
+			slog.ErrorContext(ctx, "Failed to summarize part", "part", i+1, "of", len(parts), "err", errs[i])
+			continue
+		}
+		notes = append(notes, fmt.Sprintf("Summary of part %d of %d:\n%s", i+1, len(parts), summary))
+	}
+	span.SetAttributes(attribute.Int("summary.failed_parts", len(failed)))
+	if len(failed) > 0 {
+		metricChunkedSummaries.Add("failed_parts", int64(len(failed)))
+		requests.recordError(ctx, fmt.Sprintf("%d of %d parts could not be summarized", len(failed), len(parts)))
+	}
+	if 2*len(failed) > len(parts) {
+		metricChunkedSummaries.Add("failures", 1)
+		span.SetStatus(codes.Error, errSummaryFailed.Error())
+		return req, errSummaryFailed
+	}
+	if len(failed) > 0 {
+		notes = append(notes, fmt.Sprintf("Parts %s of %d could not be summarized; say so if they may matter to the answer.", strings.Join(failed, ", "), len(parts)))
+	}
+	requests.record(ctx, "summarize_done", fmt.Sprintf("%d/%d parts", len(parts)-len(failed), len(parts)))
+
+	req.Query = clipMiddle(req.Query, summaryQueryChars)
+	req.Context = append(notes, req.Context...)
+	return req, nil
+}
@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+)
+
+func TestSplitOverlapping(t *testing.T) {
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, fmt.Sprintf("line %03d é", i))
+	}
+	text := strings.Join(lines, "\n")
+	parts := splitOverlapping(text, 300, 30)
+	if len(parts) < 2 {
+		t.Fatalf("got %d parts", len(parts))
+	}
+	for i, p := range parts {
+		if len(p) > 300 || !strings.Contains(text, p) {
+			t.Fatalf("part %d is not a slice of at most 300 bytes: %q", i, p)
+		}
+		if i > 0 && !strings.HasPrefix(p, "line") {
+			t.Errorf("part %d does not start on a line: %q", i, p[:10])
+		}
+		if i < len(parts)-1 && !strings.HasSuffix(p, "\n") {
+			t.Errorf("part %d does not end on a line", i)
+		}
+	}
+	if !strings.HasPrefix(text, parts[0]) || !strings.HasSuffix(text, parts[len(parts)-1]) {
+		t.Error("parts do not cover the text")
+	}
+	if last := parts[0][strings.LastIndex(strings.TrimSuffix(parts[0], "\n"), "\n")+1:]; !strings.Contains(parts[1], last) {
+		t.Errorf("parts do not overlap: %q is not in %q", last, parts[1])
+	}
+	if got := splitOverlapping(strings.Repeat("é", 500), 101, 10); strings.Join(got, "") == "" || !strings.HasPrefix(got[1], "é") {
+		t.Errorf("split inside a rune: %q", got[1][:4])
+	}
+}
+
+func TestRunParallel_BusyPoolDoesNotDeadlock(t *testing.T) {
+	pool := workerpool.New(1)
+	defer pool.Shutdown()
+	done := make(chan struct{})
+	var calls atomic.Int64
+	pool.Submit(func() {
+		// The only worker is busy running this task, so it summarizes alone.
+		runParallel(pool, 5, func(int) { calls.Add(1) })
+		close(done)
+	})
+	<-done
+	if calls.Load() != 5 {
+		t.Errorf("fn called %d times", calls.Load())
+	}
+}
+
+func TestCondenseRequest(t *testing.T) {
+	defer func(n int) { config.MaxInputChars = n }(config.MaxInputChars)
+	config.MaxInputChars = 400
+
+	var mu sync.Mutex
+	var partQueries []string
+	failPart := ""
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		partQueries = append(partQueries, req.Query)
+		fail := failPart != "" && strings.Contains(req.Instruction, failPart)
+		mu.Unlock()
+		if fail {
+			http.Error(w, "boom", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "summary of " + strings.Fields(req.Query)[0]})
+	}))
+	defer backendServer.Close()
+	config.BackendURL = backendServer.URL
+
+	short := backend.ChatRequest{Query: "short question", Context: []string{"ctx"}}
+	if got, err := condenseRequest(context.Background(), nil, short); err != nil || got.Query != short.Query || len(got.Context) != 1 {
+		t.Fatalf("short request changed: %+v, %v", got, err)
+	}
+
+	var lines []string
+	for i := 0; i < 60; i++ {
+		lines = append(lines, fmt.Sprintf("L%02d error in step %d", i, i))
+	}
+	long := backend.ChatRequest{UserID: "U1", Query: "explain this log:\n" + strings.Join(lines, "\n"), Context: []string{"glossary"}}
+	pool := workerpool.New(3)
+	defer pool.Shutdown()
+	got, err := condenseRequest(context.Background(), pool, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(partQueries)
+	if n < 4 || len(got.Context) != n+2 || got.Context[0] != summaryNote || got.Context[len(got.Context)-1] != "glossary" {
+		t.Fatalf("%d parts, context %q", n, got.Context)
+	}
+	if !strings.HasPrefix(got.Context[1], fmt.Sprintf("Summary of part 1 of %d:\nsummary of explain", n)) {
+		t.Errorf("first summary %q", got.Context[1])
+	}
+
+	failPart = fmt.Sprintf("part 2 of %d", n)
+	got, err = condenseRequest(context.Background(), pool, long)
+	if err != nil || !strings.Contains(got.Context[len(got.Context)-2], fmt.Sprintf("Parts 2 of %d could not be summarized", n)) {
+		t.Errorf("partial failure: %v, %q", err, got.Context)
+	}
+
+	failPart = "part"
+	if _, err := condenseRequest(context.Background(), pool, long); err != errSummaryFailed {
+		t.Errorf("err = %v, want errSummaryFailed", err)
+	}
+}
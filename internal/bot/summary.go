@@ -0,0 +1,246 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Daily Summaries
+//
+// Users opt in with "!summary on [hour]" and get a DM each day, at that hour
+// in the timezone from their Slack profile, listing the questions they asked
+// since the previous summary. SUMMARY_TEMPLATE can point at a text/template
+// file to replace the built-in layout.
+const (
+	defaultSummaryHour = 17
+	summaryCheckPeriod = time.Minute
+)
+
+const defaultSummaryTemplate = `*Your ChatRelayBot summary for {{.Date}}*
+{{range $i, $item := .Items}}
+{{inc $i}}. *{{$item.Query}}*
+{{$item.Answer}}{{if $item.Permalink}}
+<{{$item.Permalink}}|View conversation>{{end}}
+{{end}}`
+
+type summaryItem struct {
+	Query     string
+	Answer    string
+	Permalink string
+}
+
+type summaryData struct {
+	User  string
+	Date  string
+	Items []summaryItem
+}
+
+var summaryTemplate = template.Must(parseSummaryTemplate(defaultSummaryTemplate))
+
+func parseSummaryTemplate(text string) (*template.Template, error) {
+	return template.New("summary").Funcs(template.FuncMap{
+		"inc": func(i int) int { return i + 1 },
+	}).Parse(text)
+}
+
+func loadSummaryTemplate(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	tmpl, err := parseSummaryTemplate(string(data))
+	if err != nil {
+		return err
+	}
+	summaryTemplate = tmpl
+	return nil
+}
+
+type summarySubscription struct {
+	User     string
+	Hour     int
+	Location *time.Location
+	LastSent time.Time
+}
+
+type summarySubscriptions struct {
+	mu    sync.Mutex
+	users map[string]*summarySubscription
+}
+
+var summaries = &summarySubscriptions{users: make(map[string]*summarySubscription)}
+
+func (s *summarySubscriptions) subscribe(sub *summarySubscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// New subscribers get their first summary at the next send hour.
+	if prev, ok := s.users[sub.User]; ok {
+		sub.LastSent = prev.LastSent
+	} else if sub.LastSent.IsZero() {
+		sub.LastSent = time.Now()
+	}
+	s.users[sub.User] = sub
+}
+
+func (s *summarySubscriptions) unsubscribe(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.users[user]
+	delete(s.users, user)
+	return ok
+}
+
+func (s *summarySubscriptions) get(user string) (summarySubscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.users[user]
+	if !ok {
+		return summarySubscription{}, false
+	}
+	return *sub, true
+}
+
+// due returns the subscriptions whose local send hour has passed today and
+// that have not been sent since.
+func (s *summarySubscriptions) due(now time.Time) []summarySubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []summarySubscription
+	for _, sub := range s.users {
+		local := now.In(sub.Location)
+		sendAt := time.Date(local.Year(), local.Month(), local.Day(), sub.Hour, 0, 0, 0, sub.Location)
+		if !local.Before(sendAt) && sub.LastSent.Before(sendAt) {
+			out = append(out, *sub)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].User < out[j].User })
+	return out
+}
+
+func (s *summarySubscriptions) markSent(user string, at time.Time, loc *time.Location) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub, ok := s.users[user]; ok {
+		sub.LastSent = at
+		sub.Location = loc
+	}
+}
+
+func userLocation(ctx context.Context, api SlackClient, user string) *time.Location {
+	return lookupUserLocale(ctx, api, user).Location
+}
+
+func runDailySummaries(ctx context.Context, api SlackClient) {
+	ticker := time.NewTicker(summaryCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !leadership.leads() {
+				continue
+			}
+			sendDueSummaries(ctx, api, now)
+		}
+	}
+}
+
+func sendDueSummaries(ctx context.Context, api SlackClient, now time.Time) {
+	for _, sub := range summaries.due(now) {
+		sendDailySummary(ctx, api, sub, now)
+	}
+}
+
+func sendDailySummary(ctx context.Context, api SlackClient, sub summarySubscription, now time.Time) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "daily_summary")
+	defer span.End()
+
+	// Cover at least a full day, and everything since the last summary if
+	// one was missed.
+	since := now.Add(-24 * time.Hour)
+	if sub.LastSent.Before(since) {
+		since = sub.LastSent
+	}
+	records := conversations.ForUser(sub.User, since)
+	span.SetAttributes(attribute.String("user.id", sub.User), attribute.Int("summary.items", len(records)))
+
+	// Refresh the timezone so travellers get tomorrow's summary on local time.
+	loc := userLocation(ctx, api, sub.User)
+	defer summaries.markSent(sub.User, now, loc)
+	if len(records) == 0 {
+		return
+	}
+
+	data := summaryData{User: sub.User, Date: now.In(sub.Location).Format("Monday, January 2")}
+	for _, rec := range records {
+		item := summaryItem{Query: rec.Query, Answer: truncate(rec.AnswerText(), 300)}
+		if len(rec.MessageTS) > 0 {
+			if link, err := api.GetPermalinkContext(ctx, &slack.PermalinkParameters{Channel: rec.Channel, Ts: rec.MessageTS[0]}); err == nil {
+				item.Permalink = link
+			}
+		}
+		data.Items = append(data.Items, item)
+	}
+
+	var b strings.Builder
+	if err := summaryTemplate.Execute(&b, data); err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to render summary", "user", sub.User, "err", err)
+		return
+	}
+	if _, err := sendMessage(ctx, api, outgoingMessage{Channel: sub.User, User: sub.User, Text: b.String()}); err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to send summary", "user", sub.User, "err", err)
+	}
+}
+
+func init() {
+	registerCommand("summary", command{
+		Usage: "on [hour 0-23] | off",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			fields := strings.Fields(strings.ToLower(args))
+			if len(fields) == 0 {
+				if sub, ok := summaries.get(ev.User); ok {
+					notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Daily summary is on, sent at %02d:00 %s.", sub.Hour, sub.Location))
+				} else {
+					notifyUser(ctx, api, ev.Channel, ev.User, "Daily summary is off. Use `!summary on [hour]` to enable it.")
+				}
+				return
+			}
+			switch fields[0] {
+			case "off":
+				summaries.unsubscribe(ev.User)
+				notifyUser(ctx, api, ev.Channel, ev.User, "Daily summary turned off.")
+			case "on":
+				hour := defaultSummaryHour
+				if len(fields) > 1 {
+					h, err := strconv.Atoi(strings.TrimSuffix(fields[1], ":00"))
+					if err != nil || h < 0 || h > 23 {
+						notifyUser(ctx, api, ev.Channel, ev.User, "Hour must be between 0 and 23.")
+						return
+					}
+					hour = h
+				}
+				loc := userLocation(ctx, api, ev.User)
+				summaries.subscribe(&summarySubscription{User: ev.User, Hour: hour, Location: loc})
+				notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("Daily summary turned on; you'll get a DM at %02d:00 %s.", hour, loc))
+			default:
+				notifyUser(ctx, api, ev.Channel, ev.User, "Usage: `!summary on [hour]` or `!summary off`")
+			}
+		},
+	})
+}
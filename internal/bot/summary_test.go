@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestDailySummary(t *testing.T) {
+	api := &fakeSlackClient{}
+	ev := slackevents.AppMentionEvent{User: "USUM", Channel: "C1"}
+	if !dispatchCommand(context.Background(), api, ev, "!summary on 9") {
+		t.Fatal("summary command not registered")
+	}
+	defer summaries.unsubscribe("USUM")
+	sub, ok := summaries.get("USUM")
+	if !ok || sub.Hour != 9 || sub.Location != time.UTC {
+		t.Fatalf("unexpected subscription: %+v", sub)
+	}
+
+	conversations.Save(&ConversationRecord{ID: newID(), Channel: "C1", User: "USUM", Query: "What is Go?",
+		Answer: []string{"A language."}, MessageTS: []string{"5.000100"}})
+
+	tomorrow := time.Now().UTC().Add(24 * time.Hour)
+	before := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 8, 59, 0, 0, time.UTC)
+	sendDueSummaries(context.Background(), api, before)
+	if n := len(api.sent()); n != 1 {
+		t.Fatalf("summary sent before the user's hour: %d posts", n)
+	}
+
+	at := before.Add(2 * time.Minute)
+	sendDueSummaries(context.Background(), api, at)
+	posts := api.sent()
+	if len(posts) != 2 || posts[1].Channel != "USUM" {
+		t.Fatalf("expected one summary DM, got %+v", posts)
+	}
+	text := posts[1].Text()
+	if !strings.Contains(text, "1. *What is Go?*") || !strings.Contains(text, "A language.") || !strings.Contains(text, "archives/C1/p5000100") {
+		t.Errorf("summary missing question, answer or permalink:\n%s", text)
+	}
+
+	sendDueSummaries(context.Background(), api, at.Add(time.Hour))
+	if n := len(api.sent()); n != 2 {
+		t.Errorf("summary sent twice in one day: %d posts", n)
+	}
+}
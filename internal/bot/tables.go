@@ -0,0 +1,190 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Table Rendering
+//
+// Slack does not render markdown tables. Small tables are re-aligned into a
+// monospaced code block; tables over either threshold are attached as a CSV
+// file in the answer's thread instead.
+const (
+	tableMaxRows  = 20
+	tableMaxWidth = 80
+)
+
+type tableAttachment struct {
+	Filename string
+	Rows     int
+	CSV      string
+}
+
+// formatTables rewrites every markdown table in text and returns the tables
+// that are too large to inline.
+func formatTables(text string) (string, []tableAttachment) {
+	lines := strings.Split(text, "\n")
+	var out []string
+	var files []tableAttachment
+	for i := 0; i < len(lines); i++ {
+		if i+1 >= len(lines) || !isTableRow(lines[i]) || !isTableSeparator(lines[i+1]) {
+			out = append(out, lines[i])
+			continue
+		}
+		rows := [][]string{splitTableRow(lines[i])}
+		j := i + 2
+		for ; j < len(lines) && isTableRow(lines[j]); j++ {
+			rows = append(rows, splitTableRow(lines[j]))
+		}
+		i = j - 1
+
+		block := alignTable(rows)
+		if len(rows)-1 <= tableMaxRows && utf8.RuneCountInString(block[0]) <= tableMaxWidth {
+			out = append(out, "```")
+			out = append(out, block...)
+			out = append(out, "```")
+			continue
+		}
+		file := tableAttachment{Filename: fmt.Sprintf("table-%d.csv", len(files)+1), Rows: len(rows) - 1, CSV: tableCSV(rows)}
+		files = append(files, file)
+		out = append(out, fmt.Sprintf("_Table with %d rows attached as %s._", file.Rows, file.Filename))
+	}
+	return strings.Join(out, "\n"), files
+}
+
+func isTableRow(line string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) > 1 && strings.HasPrefix(line, "|") && strings.Count(line, "|") >= 2
+}
+
+func isTableSeparator(line string) bool {
+	if !isTableRow(line) {
+		return false
+	}
+	for _, cell := range splitTableRow(line) {
+		cell = strings.Trim(cell, ":")
+		if cell == "" || strings.Trim(cell, "-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// alignTable pads every column to its widest cell and underlines the header.
+func alignTable(rows [][]string) []string {
+	var widths []int
+	for _, row := range rows {
+		for c, cell := range row {
+			if c >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[c] = max(widths[c], utf8.RuneCountInString(cell))
+		}
+	}
+	format := func(row []string) string {
+		parts := make([]string, len(widths))
+		for c := range widths {
+			var cell string
+			if c < len(row) {
+				cell = row[c]
+			}
+			parts[c] = cell + strings.Repeat(" ", widths[c]-utf8.RuneCountInString(cell))
+		}
+		return strings.TrimRight(strings.Join(parts, "  "), " ")
+	}
+	rule := make([]string, len(widths))
+	for c, w := range widths {
+		rule[c] = strings.Repeat("-", w)
+	}
+	lines := []string{format(rows[0]), strings.Join(rule, "  ")}
+	for _, row := range rows[1:] {
+		lines = append(lines, format(row))
+	}
+	return lines
+}
+
+func tableCSV(rows [][]string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.WriteAll(rows)
+	return buf.String()
+}
+
+// sendAnswer posts one chunk of an answer, rendered by renderAnswer, with
+// large tables uploaded into its thread.
+func sendAnswer(ctx context.Context, api SlackClient, channel, user, text string, options ...slack.MsgOption) (string, error) {
+	text, files := renderAnswer(ctx, api, channel, user, text)
+	ts, err := sendAnswerMessage(ctx, api, outgoingMessage{Channel: channel, User: user, Text: text}, options...)
+	if err != nil {
+		return ts, err
+	}
+	uploadTables(ctx, api, channel, user, ts, files)
+	return ts, nil
+}
+
+// renderAnswer applies plugin post-processing, locale formatting, glossary
+// links and the channel's emoji policy to answer text and renders its
+// tables for Slack, returning the tables too large to show inline.
+func renderAnswer(ctx context.Context, api SlackClient, channel, user, text string) (string, []tableAttachment) {
+	text = localizeAnswer(ctx, api, channel, user, postProcessAnswer(ctx, channel, user, text))
+	text = linkifyGlossary(channel, text)
+	text = enforceEmojiPolicy(ctx, api, channel, text)
+	return formatTables(text)
+}
+
+// uploadTables uploads large tables into the thread of the message at ts.
+func uploadTables(ctx context.Context, api SlackClient, channel, user, ts string, files []tableAttachment) {
+	if len(files) == 0 {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("answer.table_files", len(files)))
+	for _, f := range files {
+		content := filterText(ctx, channel, user, f.CSV)
+		_, err := api.UploadFileV2Context(ctx, slack.UploadFileV2Parameters{
+			Channel:         channel,
+			ThreadTimestamp: ts,
+			Filename:        f.Filename,
+			Title:           f.Filename,
+			Content:         content,
+			FileSize:        len(content),
+		})
+		if err != nil {
+			span.RecordError(err)
+			slog.ErrorContext(ctx, "Failed to upload file", "file", f.Filename, "channel", channel, "err", err)
+		}
+	}
+}
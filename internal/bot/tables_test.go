@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFormatTables_SmallTableBecomesCodeBlock(t *testing.T) {
+	text := "Prices:\n| Plan | Price |\n|:-----|------:|\n| Basic | $5 |\n| Pro \\| Team | $20 |\nThanks."
+	got, files := formatTables(text)
+	want := "Prices:\n```\nPlan        Price\n----------  -----\nBasic       $5\nPro | Team  $20\n```\nThanks."
+	if got != want || len(files) != 0 {
+		t.Errorf("unexpected rendering:\n%s\nfiles=%v", got, files)
+	}
+}
+
+func TestFormatTables_LargeTableBecomesCSV(t *testing.T) {
+	lines := []string{"| id | name |", "|---|---|"}
+	for i := 0; i <= tableMaxRows; i++ {
+		lines = append(lines, fmt.Sprintf("| %d | item, %d |", i, i))
+	}
+	got, files := formatTables(strings.Join(lines, "\n"))
+	if len(files) != 1 || files[0].Rows != tableMaxRows+1 {
+		t.Fatalf("expected one CSV attachment, got %+v", files)
+	}
+	if !strings.HasPrefix(files[0].CSV, "id,name\n0,\"item, 0\"\n") {
+		t.Errorf("unexpected CSV: %q", files[0].CSV)
+	}
+	if !strings.Contains(got, "attached as table-1.csv") || strings.Contains(got, "|") {
+		t.Errorf("table should be replaced by a note: %q", got)
+	}
+}
+
+func TestFormatTables_IgnoresPipesOutsideTables(t *testing.T) {
+	text := "Use `a | b` to pipe.\n| not a table"
+	if got, files := formatTables(text); got != text || len(files) != 0 {
+		t.Errorf("text should be unchanged, got %q", got)
+	}
+}
+
+func TestSendAnswer_UploadsLargeTablesInThread(t *testing.T) {
+	lines := []string{"| a | b |", "|---|---|"}
+	for i := 0; i <= tableMaxRows; i++ {
+		lines = append(lines, "| x | y |")
+	}
+	api := &fakeSlackClient{}
+	ts, err := sendAnswer(context.Background(), api, "C1", "U1", strings.Join(lines, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(api.uploads) != 1 || api.uploads[0].ThreadTimestamp != ts || api.uploads[0].Channel != "C1" || api.uploads[0].FileSize != len(api.uploads[0].Content) {
+		t.Errorf("expected CSV upload in the answer thread, got %+v", api.uploads)
+	}
+}
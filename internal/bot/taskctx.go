@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+)
+
+// Task Contexts
+//
+// Events are handled with the listener's context, which is cancelled as
+// soon as the relay is asked to stop. Work queued from an event must not
+// inherit that cancellation, or every answer in flight would be cut off
+// mid-stream. detachTask gives queued work a context that keeps the
+// event's values (request ID, workspace, trace span) but is only cancelled
+// when the shutdown drain gives up, and taskContext adds the task's own
+// TASK_TIMEOUT (default 5 minutes) once it starts running. On shutdown the
+// listener stops taking events and drainTasks waits up to DRAIN_TIMEOUT
+// (default 30 seconds) for queued and running tasks before cancelling
+// them. Timed-out and cancelled tasks are counted under "task_contexts" on
+// /debug/vars.
+const (
+	defaultTaskTimeout  = 5 * time.Minute
+	defaultDrainTimeout = 30 * time.Second
+	drainGrace          = 5 * time.Second
+	drainPollInterval   = 50 * time.Millisecond
+)
+
+var (
+	// taskLifetime ends when the shutdown drain times out.
+	taskLifetime, abortTasks = context.WithCancel(context.Background())
+
+	metricTaskContexts = expvar.NewMap("task_contexts")
+)
+
+// detachedContext has the values of one context and the lifetime of
+// another.
+type detachedContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c detachedContext) Value(key any) any {
+	return c.values.Value(key)
+}
+
+// detachTask returns a context with parent's values that is cancelled only
+// when queued work is abandoned at shutdown, not when parent is.
+func detachTask(parent context.Context) context.Context {
+	return detachedContext{Context: taskLifetime, values: parent}
+}
+
+func taskTimeout() time.Duration {
+	if config.TaskTimeout > 0 {
+		return config.TaskTimeout
+	}
+	return defaultTaskTimeout
+}
+
+// taskContext applies the task timeout to ctx, from the moment the task
+// starts running.
+func taskContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, taskTimeout())
+	return ctx, func() {
+		switch context.Cause(ctx) {
+		case context.DeadlineExceeded:
+			metricTaskContexts.Add("timed_out", 1)
+			slog.WarnContext(ctx, "Task exceeded its timeout", "timeout", taskTimeout())
+		case context.Canceled:
+			if taskLifetime.Err() != nil {
+				metricTaskContexts.Add("aborted", 1)
+			}
+		}
+		cancel()
+	}
+}
+
+// tasksPending reports how many tasks pool is running or holding, counting
+// questions that wait for a per-user slot.
+func tasksPending(pool *workerpool.Pool) int {
+	// Questions holding a slot are also in the pool, so the larger count
+	// covers both without adding them twice.
+	return max(pool.Pending(), fairness.pending())
+}
+
+// waitIdle polls until pool has no pending tasks or timeout passes, and
+// reports whether it went idle.
+func waitIdle(pool *workerpool.Pool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for tasksPending(pool) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
+
+// drainTasks waits up to timeout for pool's queued and running tasks to
+// finish, then cancels the rest and gives them drainGrace to wind down.
+// The pool is left open, since finishing tasks may still queue follow-ups.
+func drainTasks(pool *workerpool.Pool, timeout time.Duration) {
+	slog.Info("Draining pending tasks", "pending", tasksPending(pool), "timeout", timeout)
+	if waitIdle(pool, timeout) {
+		slog.Info("All tasks finished")
+		return
+	}
+	slog.Warn("Drain timeout expired; cancelling pending tasks", "pending", tasksPending(pool))
+	metricTaskContexts.Add("drain_timeouts", 1)
+	abortTasks()
+	waitIdle(pool, drainGrace)
+}
@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+)
+
+func TestDetachTask_SurvivesListenerShutdown(t *testing.T) {
+	defer func(lifetime context.Context, abort context.CancelFunc) {
+		taskLifetime, abortTasks = lifetime, abort
+	}(taskLifetime, abortTasks)
+	taskLifetime, abortTasks = context.WithCancel(context.Background())
+	listener, stop := context.WithCancel(context.Background())
+	ctx := detachTask(withConversationID(listener, "req-7"))
+	stop()
+
+	if ctx.Err() != nil {
+		t.Fatalf("task cancelled with the listener: %v", ctx.Err())
+	}
+	if conversationIDFrom(ctx) != "req-7" {
+		t.Error("task lost the event's values")
+	}
+	abortTasks()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("task not cancelled when the drain gave up")
+	}
+}
+
+func TestTaskContext_AppliesTimeout(t *testing.T) {
+	defer func(d time.Duration) { config.TaskTimeout = d }(config.TaskTimeout)
+	config.TaskTimeout = 10 * time.Millisecond
+
+	before := metricTaskContexts.Get("timed_out")
+	ctx, cancel := taskContext(context.Background())
+	<-ctx.Done()
+	cancel()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("err = %v", ctx.Err())
+	}
+	if after := metricTaskContexts.Get("timed_out"); after == nil || (before != nil && after.String() == before.String()) {
+		t.Error("timeout not counted")
+	}
+}
+
+func TestDrainTasks(t *testing.T) {
+	defer func(lifetime context.Context, abort context.CancelFunc) {
+		taskLifetime, abortTasks = lifetime, abort
+	}(taskLifetime, abortTasks)
+	taskLifetime, abortTasks = context.WithCancel(context.Background())
+	pool := workerpool.New(2)
+	defer pool.Shutdown()
+
+	finished := make(chan struct{})
+	pool.Submit(func() {
+		time.Sleep(20 * time.Millisecond)
+		close(finished)
+	})
+	drainTasks(pool, time.Second)
+	select {
+	case <-finished:
+	default:
+		t.Fatal("drain returned before the task finished")
+	}
+	if taskLifetime.Err() != nil {
+		t.Fatal("tasks cancelled although they finished in time")
+	}
+
+	stuck := detachTask(context.Background())
+	cancelled := make(chan struct{})
+	pool.Submit(func() {
+		<-stuck.Done()
+		close(cancelled)
+	})
+	drainTasks(pool, 20*time.Millisecond)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("stuck task not cancelled after the drain timeout")
+	}
+}
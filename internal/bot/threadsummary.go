@@ -0,0 +1,246 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Thread Summaries
+//
+// Channels with thread_summary.after set keep a rolling summary in long
+// threads the bot has answered in. Once such a thread has more than
+// `after` human replies, it is read through slackReader and summarized by
+// the backend. The summary is posted as a reply and pinned to the channel
+// so late joiners can find it, and after every `every` further replies
+// (default 10) the same message is rewritten with a fresh summary. When an
+// answer in the thread is redacted, the summary is replaced with a notice
+// and rewritten with the next reply.
+// Summaries are low-priority backend work; threads longer than
+// BACKEND_MAX_INPUT_CHARS are summarized in parts (see summarize.go).
+const (
+	defaultThreadSummaryEvery = 10
+	maxThreadSummaryMessages  = 500
+
+	threadSummaryInstruction = "Summarize this Slack thread for someone joining late. Start with the question being discussed, then list answers given, decisions and open points as short bullets."
+	threadSummaryHeader      = ":pushpin: *Thread summary* (updated as the thread grows)"
+	threadSummaryScrubbed    = threadSummaryHeader + "\n_An answer in this thread was redacted; the summary is rewritten with the next reply._"
+)
+
+var metricThreadSummaries = expvar.NewMap("thread_summaries")
+
+// ThreadSummaryConfig is the per-channel thread_summary setting.
+type ThreadSummaryConfig struct {
+	After int `json:"after,omitempty"`
+	Every int `json:"every,omitempty"`
+}
+
+type threadSummaryState struct {
+	replies      int
+	summarizedAt int
+	ts           string
+	running      bool
+	// stale is set when the summary was scrubbed; the next reply rewrites it.
+	stale bool
+}
+
+type threadSummarizer struct {
+	mu      sync.Mutex
+	threads map[string]*threadSummaryState
+}
+
+var threadSummaries = &threadSummarizer{threads: make(map[string]*threadSummaryState)}
+
+// countReply records a reply and reports whether a summary is due, in
+// which case the thread is marked as being summarized.
+func (s *threadSummarizer) countReply(channel, thread string, cfg ThreadSummaryConfig) bool {
+	every := cfg.Every
+	if every <= 0 {
+		every = defaultThreadSummaryEvery
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := messageKey(channel, thread)
+	st, ok := s.threads[key]
+	if !ok {
+		st = &threadSummaryState{}
+		s.threads[key] = st
+	}
+	st.replies++
+	due := st.replies > cfg.After && (st.ts == "" || st.stale || st.replies-st.summarizedAt >= every)
+	if !due || st.running {
+		return false
+	}
+	st.running = true
+	return true
+}
+
+// summaryTS returns the summary message of a thread, if one was posted.
+func (s *threadSummarizer) summaryTS(channel, thread string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.threads[messageKey(channel, thread)]; ok {
+		return st.ts
+	}
+	return ""
+}
+
+func (s *threadSummarizer) finish(channel, thread, ts string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.threads[messageKey(channel, thread)]
+	st.running = false
+	if ts != "" {
+		st.ts, st.summarizedAt, st.stale = ts, st.replies, false
+	}
+}
+
+// scrub marks the thread's summary stale and returns it, if one was
+// posted.
+func (s *threadSummarizer) scrub(channel, thread string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.threads[messageKey(channel, thread)]
+	if !ok || st.ts == "" {
+		return ""
+	}
+	st.stale = true
+	return st.ts
+}
+
+// scrubThreadSummary replaces the summary of the thread rec was answered
+// in, which may quote a redacted answer, with a notice until the next
+// reply rewrites it from the redacted thread.
+func scrubThreadSummary(ctx context.Context, api SlackClient, rec *ConversationRecord) error {
+	seen := map[string]bool{}
+	// The thread is the question's thread, or the one started under the
+	// question or under the answer.
+	for _, thread := range append([]string{rec.ThreadTS, rec.QueryTS}, rec.MessageTS...) {
+		if thread == "" || seen[thread] {
+			continue
+		}
+		seen[thread] = true
+		ts := threadSummaries.scrub(rec.Channel, thread)
+		if ts == "" {
+			continue
+		}
+		if _, _, _, err := api.UpdateMessageContext(ctx, rec.Channel, ts, slack.MsgOptionText(threadSummaryScrubbed, false)); err != nil {
+			return fmt.Errorf("thread summary: %w", err)
+		}
+	}
+	return nil
+}
+
+// trackThreadLength counts human replies in threads the bot answered in
+// and queues a summary when one is due.
+func trackThreadLength(ctx context.Context, api SlackClient, ev *slackevents.MessageEvent, pool *workerpool.Pool) {
+	if ev.BotID != "" || ev.SubType != "" || ev.ThreadTimeStamp == "" || ev.ThreadTimeStamp == ev.TimeStamp || ev.ChannelType == "im" {
+		return
+	}
+	cfg := channelConfigFor(ev.Channel).ThreadSummary
+	if cfg.After <= 0 {
+		return
+	}
+	if _, ok := conversations.ByMessage(ev.Channel, ev.ThreadTimeStamp); !ok {
+		if _, ok := conversations.LatestInThread(ev.Channel, ev.ThreadTimeStamp); !ok {
+			return
+		}
+	}
+	if !threadSummaries.countReply(ev.Channel, ev.ThreadTimeStamp, cfg) {
+		return
+	}
+	channel, thread := ev.Channel, ev.ThreadTimeStamp
+	// Nobody asked for the summary, so nobody is told if it is turned away.
+	submitOrdered(ctx, api, pool, channel, thread, "", func() {
+		ctx, cancel := taskContext(withLowPriority(ctx))
+		defer cancel()
+		ts := summarizeThread(ctx, api, channel, thread)
+		threadSummaries.finish(channel, thread, ts)
+	})
+}
+
+// summarizeThread posts or refreshes the thread's summary and returns its
+// timestamp, or "" when it could not be written.
+func summarizeThread(ctx context.Context, api SlackClient, channel, thread string) string {
+	ctx, span := otel.Tracer("bot").Start(ctx, "summarize_thread")
+	defer span.End()
+	span.SetAttributes(attribute.String("channel.id", channel), attribute.String("thread.ts", thread))
+
+	if slackReader == nil {
+		return ""
+	}
+	existing := threadSummaries.summaryTS(channel, thread)
+	slackReader.InvalidateThread(channel, thread)
+	msgs, err := slackReader.Replies(ctx, channel, thread, maxThreadSummaryMessages)
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to read thread for its summary", "channel", channel, "thread", thread, "err", err)
+		metricThreadSummaries.Add("errors", 1)
+		return ""
+	}
+	// Threads too long to send whole are summarized part by part first.
+	req, err := condenseRequest(ctx, workerPool, backend.ChatRequest{UserID: "thread-summary", ChannelID: channel, Query: threadTranscript(msgs, existing),
+		Instruction: threadSummaryInstruction, DisableInternalRetrieval: true})
+	var summary string
+	if err == nil {
+		summary, err = requestAnswer(ctx, req)
+	}
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to summarize thread", "channel", channel, "thread", thread, "err", err)
+		metricThreadSummaries.Add("errors", 1)
+		return ""
+	}
+	text := threadSummaryHeader + "\n" + strings.TrimSpace(summary)
+
+	if existing != "" {
+		msg := outgoingMessage{Channel: channel, Text: text}
+		applyOutgoingFilters(ctx, &msg)
+		if _, _, _, err := api.UpdateMessageContext(ctx, channel, existing, slack.MsgOptionText(msg.Text, false)); err != nil {
+			span.RecordError(err)
+			slog.ErrorContext(ctx, "Failed to update the thread summary", "channel", channel, "thread", thread, "err", err)
+			metricThreadSummaries.Add("errors", 1)
+			return ""
+		}
+		metricThreadSummaries.Add("updated", 1)
+		return existing
+	}
+	ts, err := sendMessage(ctx, api, outgoingMessage{Channel: channel, Text: text}, slack.MsgOptionTS(thread))
+	if err != nil {
+		metricThreadSummaries.Add("errors", 1)
+		return ""
+	}
+	if err := api.AddPinContext(ctx, channel, slack.NewRefToMessage(channel, ts)); err != nil {
+		// The summary still helps unpinned, e.g. without the pins:write scope.
+		slog.ErrorContext(ctx, "Failed to pin the thread summary", "channel", channel, "thread", thread, "err", err)
+	}
+	metricThreadSummaries.Add("posted", 1)
+	return ts
+}
+
+// threadTranscript lists the thread's messages in order, leaving out the
+// summary itself and channel events.
+func threadTranscript(msgs []slack.Message, summaryTS string) string {
+	var lines []string
+	for _, m := range msgs {
+		if m.SubType != "" || m.Timestamp == summaryTS || strings.TrimSpace(m.Text) == "" {
+			continue
+		}
+		who := "<@" + m.User + ">"
+		if m.BotID != "" {
+			who = "bot"
+		}
+		lines = append(lines, who+": "+strings.TrimSpace(m.Text))
+	}
+	return strings.Join(lines, "\n")
+}
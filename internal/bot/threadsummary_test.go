@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/heykvr/chatrelaybot/slackfetch"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+type threadRepliesAPI struct {
+	slackfetch.API
+	mu      sync.Mutex
+	replies []slack.Message
+}
+
+func (a *threadRepliesAPI) GetConversationRepliesContext(ctx context.Context, params *slack.GetConversationRepliesParameters) ([]slack.Message, bool, string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]slack.Message(nil), a.replies...), false, "", nil
+}
+
+func (a *threadRepliesAPI) add(user, text string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m := digestMessage(user, text)
+	m.Timestamp = fmt.Sprintf("%d.000200", len(a.replies)+1)
+	a.replies = append(a.replies, m)
+}
+
+func TestThreadSummary_PostsPinsAndUpdates(t *testing.T) {
+	var queries []string
+	var mu sync.Mutex
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req backend.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		queries = append(queries, req.Query)
+		n := len(queries)
+		mu.Unlock()
+		if req.Instruction != threadSummaryInstruction || r.Header.Get("X-Request-Priority") != "low" {
+			t.Errorf("unexpected summary request %+v", req)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: fmt.Sprintf("summary %d", n)})
+	}))
+	defer backendServer.Close()
+	defer func(url string) { config.BackendURL = url }(config.BackendURL)
+	config.BackendURL = backendServer.URL
+
+	replies := &threadRepliesAPI{}
+	defer func(r *slackfetch.Fetcher) { slackReader = r }(slackReader)
+	slackReader = slackfetch.New(replies, slackfetch.Options{})
+	setChannelSettings(channelSettings{Channels: map[string]ChannelConfig{"CLONG": {ThreadSummary: ThreadSummaryConfig{After: 2, Every: 2}}}})
+	defer setChannelSettings(channelSettings{})
+	conversations.Save(&ConversationRecord{ID: "thread-summary-test", Channel: "CLONG", Answer: []string{"answer"}, MessageTS: []string{"100.000100"}})
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+	reply := func(user, text string) {
+		replies.add(user, text)
+		trackThreadLength(context.Background(), api, &slackevents.MessageEvent{User: user, Channel: "CLONG", Text: text,
+			ThreadTimeStamp: "100.000100", TimeStamp: fmt.Sprintf("%d.000300", time.Now().UnixNano())}, pool)
+	}
+	wait := func() {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			threadSummaries.mu.Lock()
+			running := threadSummaries.threads[messageKey("CLONG", "100.000100")].running
+			threadSummaries.mu.Unlock()
+			if !running {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("summary did not finish")
+	}
+
+	reply("U1", "does the VPN work from home?")
+	reply("U2", "only with the new client")
+	if len(api.sent()) != 0 {
+		t.Fatal("no summary before the threshold")
+	}
+	reply("U1", "where do I get it?")
+	wait()
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Values.Get("thread_ts") != "100.000100" || !strings.Contains(posts[0].Text(), "summary 1") {
+		t.Fatalf("expected the summary in the thread, got %+v", posts)
+	}
+	api.mu.Lock()
+	pinned := len(api.pins) == 1 && api.pins[0].Timestamp == "1.000100"
+	api.mu.Unlock()
+	if !pinned {
+		t.Error("the summary should be pinned")
+	}
+
+	reply("U3", "from the IT portal")
+	reply("U1", "thanks, that worked")
+	wait()
+	api.mu.Lock()
+	updates := append([]fakePost(nil), api.updates...)
+	api.mu.Unlock()
+	if len(updates) != 1 || updates[0].Values.Get("ts") != "1.000100" || !strings.Contains(updates[0].Text(), "summary 2") {
+		t.Fatalf("expected the summary to be updated in place, got %+v", updates)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(queries[1], "thanks, that worked") {
+		t.Errorf("the refreshed summary should cover new replies, got %q", queries[1])
+	}
+}
+
+func TestThreadSummary_IgnoresUntrackedThreads(t *testing.T) {
+	setChannelSettings(channelSettings{Default: ChannelConfig{ThreadSummary: ThreadSummaryConfig{After: 1}}})
+	defer setChannelSettings(channelSettings{})
+	api := &fakeSlackClient{}
+	for i := 0; i < 5; i++ {
+		trackThreadLength(context.Background(), api, &slackevents.MessageEvent{User: "U1", Channel: "COTHER", Text: "chatter",
+			ThreadTimeStamp: "200.000100", TimeStamp: fmt.Sprintf("20%d.000100", i)}, nil)
+	}
+	if _, ok := threadSummaries.threads[messageKey("COTHER", "200.000100")]; ok {
+		t.Error("threads the bot did not answer in should not be counted")
+	}
+}
@@ -0,0 +1,215 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Ticketing
+const ActionConvertTicket = "convert_ticket"
+
+type Ticket struct {
+	Subject      string
+	Question     string
+	Answer       string
+	Permalink    string
+	UserName     string
+	UserEmail    string
+	SlackUserID  string
+	SlackChannel string
+}
+
+func (t Ticket) Description() string {
+	return fmt.Sprintf("Question from %s (%s, Slack user %s):\n%s\n\nAnswer from ChatRelayBot:\n%s\n\nSlack thread: %s",
+		t.UserName, t.UserEmail, t.SlackUserID, t.Question, t.Answer, t.Permalink)
+}
+
+type TicketProvider interface {
+	CreateTicket(ctx context.Context, t Ticket) (string, error)
+}
+
+var ticketProvider TicketProvider
+
+func newTicketProviderFromEnv() TicketProvider {
+	switch strings.ToLower(os.Getenv("TICKET_PROVIDER")) {
+	case "zendesk":
+		return &zendeskProvider{
+			baseURL:  strings.TrimSuffix(os.Getenv("ZENDESK_URL"), "/"),
+			email:    os.Getenv("ZENDESK_EMAIL"),
+			apiToken: os.Getenv("ZENDESK_API_TOKEN"),
+		}
+	case "servicenow":
+		return &serviceNowProvider{
+			baseURL:  strings.TrimSuffix(os.Getenv("SERVICENOW_URL"), "/"),
+			user:     os.Getenv("SERVICENOW_USER"),
+			password: os.Getenv("SERVICENOW_PASSWORD"),
+		}
+	}
+	return nil
+}
+
+type zendeskProvider struct {
+	baseURL  string
+	email    string
+	apiToken string
+}
+
+func (z *zendeskProvider) CreateTicket(ctx context.Context, t Ticket) (string, error) {
+	body := map[string]any{
+		"ticket": map[string]any{
+			"subject":   t.Subject,
+			"comment":   map[string]any{"body": t.Description()},
+			"requester": map[string]any{"name": t.UserName, "email": t.UserEmail},
+			"tags":      []string{"chatrelaybot", "slack"},
+		},
+	}
+	var result struct {
+		Ticket struct {
+			ID int64 `json:"id"`
+		} `json:"ticket"`
+	}
+	if err := postTicketJSON(ctx, z.baseURL+"/api/v2/tickets.json", z.email+"/token", z.apiToken, body, &result); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/agent/tickets/%d", z.baseURL, result.Ticket.ID), nil
+}
+
+type serviceNowProvider struct {
+	baseURL  string
+	user     string
+	password string
+}
+
+func (s *serviceNowProvider) CreateTicket(ctx context.Context, t Ticket) (string, error) {
+	body := map[string]any{
+		"short_description": t.Subject,
+		"description":       t.Description(),
+		"caller_id":         t.UserEmail,
+		"contact_type":      "Slack",
+	}
+	var result struct {
+		Result struct {
+			SysID string `json:"sys_id"`
+		} `json:"result"`
+	}
+	if err := postTicketJSON(ctx, s.baseURL+"/api/now/table/incident", s.user, s.password, body, &result); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/nav_to.do?uri=incident.do?sys_id=%s", s.baseURL, result.Result.SysID), nil
+}
+
+func postTicketJSON(ctx context.Context, url, user, password string, body, out any) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(user, password)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("ticket API returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func ticketButtonBlock(recordID string) slack.Block {
+	return slack.NewActionBlock("ticket_"+recordID,
+		slack.NewButtonBlockElement(ActionConvertTicket, recordID, slack.NewTextBlockObject(slack.PlainTextType, "Convert to ticket", false, false)),
+	)
+}
+
+func handleTicketAction(ctx context.Context, api SlackClient, callback slack.InteractionCallback, action *slack.BlockAction) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "convert_to_ticket")
+	defer span.End()
+
+	user := callback.User.ID
+	span.SetAttributes(attribute.String("conversation.id", action.Value), attribute.String("user.id", user))
+
+	if ticketProvider == nil {
+		notifyUser(ctx, api, callback.Channel.ID, user, "Ticketing is not configured.")
+		return
+	}
+	// Claim the conversation first, so that two clicks create one ticket.
+	var rec ConversationRecord
+	claimed := false
+	ok := conversations.Update(action.Value, func(r *ConversationRecord) {
+		rec = *r
+		if !r.Escalated && !r.Escalating {
+			r.Escalating, claimed = true, true
+		}
+	})
+	switch {
+	case !ok:
+		notifyUser(ctx, api, callback.Channel.ID, user, "This conversation is no longer available.")
+		return
+	case rec.Escalated:
+		notifyUser(ctx, api, callback.Channel.ID, user, fmt.Sprintf("Already escalated: %s", rec.TicketURL))
+		return
+	case !claimed:
+		notifyUser(ctx, api, callback.Channel.ID, user, "A ticket is already being created for this conversation.")
+		return
+	}
+
+	ticket := Ticket{
+		Subject:      "Slack question: " + truncate(rec.Query, 80),
+		Question:     rec.Query,
+		Answer:       rec.AnswerText(),
+		SlackUserID:  rec.User,
+		SlackChannel: rec.Channel,
+	}
+	if len(rec.MessageTS) > 0 {
+		link, err := api.GetPermalinkContext(ctx, &slack.PermalinkParameters{Channel: rec.Channel, Ts: rec.MessageTS[0]})
+		if err == nil {
+			ticket.Permalink = link
+		}
+	}
+	if info, err := api.GetUserInfoContext(ctx, rec.User); err == nil {
+		ticket.UserName = info.RealName
+		ticket.UserEmail = info.Profile.Email
+	}
+
+	url, err := ticketProvider.CreateTicket(ctx, ticket)
+	if err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to create ticket", "conversation", rec.ID, "err", err)
+		conversations.Update(rec.ID, func(r *ConversationRecord) { r.Escalating = false })
+		notifyUser(ctx, api, callback.Channel.ID, user, "Could not create the ticket, please try again later.")
+		return
+	}
+	conversations.Update(rec.ID, func(r *ConversationRecord) {
+		r.Escalating = false
+		r.Escalated = true
+		r.TicketURL = url
+	})
+
+	var opts []slack.MsgOption
+	if len(rec.MessageTS) > 0 {
+		opts = append(opts, slack.MsgOptionTS(rec.MessageTS[0]))
+	}
+	sendMessage(ctx, api, outgoingMessage{Channel: rec.Channel, User: rec.User, Text: fmt.Sprintf(":ticket: <@%s> escalated this conversation: %s", user, url)}, opts...)
+	slog.InfoContext(ctx, "Conversation escalated", "conversation", rec.ID, "ticket", url)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
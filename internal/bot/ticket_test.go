@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+type fakeTicketProvider struct {
+	tickets []Ticket
+}
+
+func (f *fakeTicketProvider) CreateTicket(ctx context.Context, t Ticket) (string, error) {
+	f.tickets = append(f.tickets, t)
+	return "https://tickets.example.com/1", nil
+}
+
+func TestZendeskProvider_CreateTicket(t *testing.T) {
+	var body map[string]map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if r.URL.Path != "/api/v2/tickets.json" || user != "ops@example.com/token" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ticket":{"id":42}}`))
+	}))
+	defer ts.Close()
+
+	z := &zendeskProvider{baseURL: ts.URL, email: "ops@example.com", apiToken: "secret"}
+	url, err := z.CreateTicket(context.Background(), Ticket{Subject: "Help", Question: "q", Answer: "a"})
+	if err != nil {
+		t.Fatalf("CreateTicket: %v", err)
+	}
+	if url != ts.URL+"/agent/tickets/42" {
+		t.Errorf("unexpected ticket url %q", url)
+	}
+	if body["ticket"]["subject"] != "Help" {
+		t.Errorf("unexpected request body %+v", body)
+	}
+}
+
+func TestHandleTicketAction_EscalatesConversation(t *testing.T) {
+	provider := &fakeTicketProvider{}
+	ticketProvider = provider
+	defer func() { ticketProvider = nil }()
+
+	rec := &ConversationRecord{ID: newID(), Channel: "C1", User: "U1", Query: "How do I reset?", Answer: []string{"Click reset."}, MessageTS: []string{"1.000100"}}
+	conversations.Save(rec)
+
+	var callback slack.InteractionCallback
+	callback.Type = slack.InteractionTypeBlockActions
+	callback.User.ID = "U2"
+	callback.Channel.ID = "C1"
+	callback.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: ActionConvertTicket, Value: rec.ID}}
+
+	api := &fakeSlackClient{}
+	handleInteraction(context.Background(), api, callback)
+
+	if len(provider.tickets) != 1 {
+		t.Fatalf("expected one ticket, got %d", len(provider.tickets))
+	}
+	tk := provider.tickets[0]
+	if !strings.Contains(tk.Permalink, "/archives/C1/") || tk.UserEmail != "u1@example.com" || tk.Answer != "Click reset." {
+		t.Errorf("ticket missing context: %+v", tk)
+	}
+	got, _ := conversations.Get(rec.ID)
+	if !got.Escalated || got.TicketURL == "" {
+		t.Errorf("conversation not marked escalated: %+v", got)
+	}
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Values.Get("thread_ts") != "1.000100" || !strings.Contains(posts[0].Text(), "tickets.example.com") {
+		t.Errorf("expected ticket link in thread, got %+v", posts)
+	}
+
+	handleInteraction(context.Background(), api, callback)
+	if len(provider.tickets) != 1 {
+		t.Error("escalated conversation must not create a second ticket")
+	}
+}
+
+// slowTicketProvider holds each ticket until release is closed.
+type slowTicketProvider struct {
+	mu      sync.Mutex
+	calls   int
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (p *slowTicketProvider) CreateTicket(ctx context.Context, t Ticket) (string, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	p.started <- struct{}{}
+	<-p.release
+	return "https://tickets.example.com/2", p.err
+}
+
+func TestHandleTicketAction_ConcurrentClicksCreateOneTicket(t *testing.T) {
+	provider := &slowTicketProvider{started: make(chan struct{}, 2), release: make(chan struct{}), err: errors.New("unavailable")}
+	ticketProvider = provider
+	defer func() { ticketProvider = nil }()
+
+	rec := &ConversationRecord{ID: newID(), Channel: "C1", User: "U1", Query: "q", Answer: []string{"a"}, MessageTS: []string{"1.000100"}}
+	conversations.Save(rec)
+	click := func(user string) slack.InteractionCallback {
+		var callback slack.InteractionCallback
+		callback.Type = slack.InteractionTypeBlockActions
+		callback.User.ID = user
+		callback.Channel.ID = "C1"
+		callback.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: ActionConvertTicket, Value: rec.ID}}
+		return callback
+	}
+
+	api := &fakeSlackClient{}
+	done := make(chan struct{})
+	go func() {
+		handleInteraction(context.Background(), api, click("U2"))
+		close(done)
+	}()
+	<-provider.started
+	handleInteraction(context.Background(), api, click("U3"))
+	close(provider.release)
+	<-done
+	if provider.calls != 1 {
+		t.Fatalf("expected one ticket while the first is being created, got %d", provider.calls)
+	}
+
+	// A failed attempt releases the claim, so the next click tries again.
+	provider.err = nil
+	provider.release = make(chan struct{})
+	close(provider.release)
+	handleInteraction(context.Background(), api, click("U3"))
+	if got, _ := conversations.Get(rec.ID); provider.calls != 2 || !got.Escalated || got.Escalating {
+		t.Errorf("retry after a failure: %d calls, record %+v", provider.calls, got)
+	}
+}
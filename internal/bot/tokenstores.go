@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/redis/go-redis/v9"
+)
+
+// Database Token Stores
+//
+// Besides memory and a JSON file, TOKEN_STORE can keep installations in a
+// database so they survive restarts and are shared by replicas:
+// "sqlite:/path/to/tokens.db" keeps them in an SQLite table (the file is
+// created with mode 0600; the driver uses cgo), and "redis://host:6379/0"
+// (or "rediss://" for TLS, with credentials in the URL as usual) keeps them
+// in the redisTokenKey hash, one JSON value per team ID. Both are checked
+// when the relay starts, so a wrong path or an unreachable server fails
+// Configure rather than the first install.
+const redisTokenKey = "chatrelay:installations"
+
+type sqliteTokenStore struct {
+	db *sql.DB
+}
+
+func newSQLiteTokenStore(path string) (*sqliteTokenStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS installations (team_id TEXT PRIMARY KEY, installation TEXT NOT NULL)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &sqliteTokenStore{db: db}, nil
+}
+
+func (s *sqliteTokenStore) Save(ctx context.Context, inst Installation) error {
+	data, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO installations (team_id, installation) VALUES (?, ?)
+		ON CONFLICT (team_id) DO UPDATE SET installation = excluded.installation`, inst.TeamID, string(data))
+	return err
+}
+
+func (s *sqliteTokenStore) Find(ctx context.Context, teamID string) (Installation, bool, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT installation FROM installations WHERE team_id = ?`, teamID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Installation{}, false, nil
+	}
+	if err != nil {
+		return Installation{}, false, err
+	}
+	var inst Installation
+	return inst, true, json.Unmarshal([]byte(data), &inst)
+}
+
+func (s *sqliteTokenStore) Delete(ctx context.Context, teamID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM installations WHERE team_id = ?`, teamID)
+	return err
+}
+
+func (s *sqliteTokenStore) List(ctx context.Context) ([]Installation, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT installation FROM installations ORDER BY team_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Installation{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var inst Installation
+		if err := json.Unmarshal([]byte(data), &inst); err != nil {
+			return nil, err
+		}
+		out = append(out, inst)
+	}
+	return out, rows.Err()
+}
+
+type redisTokenStore struct {
+	client *redis.Client
+}
+
+// newRedisClient connects to the server at url and checks that it answers.
+func newRedisClient(url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("%s: %w", opts.Addr, err)
+	}
+	return client, nil
+}
+
+func (s *redisTokenStore) Save(ctx context.Context, inst Installation) error {
+	data, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, redisTokenKey, inst.TeamID, data).Err()
+}
+
+func (s *redisTokenStore) Find(ctx context.Context, teamID string) (Installation, bool, error) {
+	data, err := s.client.HGet(ctx, redisTokenKey, teamID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Installation{}, false, nil
+	}
+	if err != nil {
+		return Installation{}, false, err
+	}
+	var inst Installation
+	return inst, true, json.Unmarshal(data, &inst)
+}
+
+func (s *redisTokenStore) Delete(ctx context.Context, teamID string) error {
+	return s.client.HDel(ctx, redisTokenKey, teamID).Err()
+}
+
+func (s *redisTokenStore) List(ctx context.Context) ([]Installation, error) {
+	all, err := s.client.HGetAll(ctx, redisTokenKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Installation, 0, len(all))
+	for _, data := range all {
+		var inst Installation
+		if err := json.Unmarshal([]byte(data), &inst); err != nil {
+			return nil, err
+		}
+		out = append(out, inst)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TeamID < out[j].TeamID })
+	return out, nil
+}
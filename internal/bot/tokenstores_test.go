@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// checkTokenStore saves, replaces and deletes installations in store, then
+// reopens it with reopen and checks what survived.
+func checkTokenStore(t *testing.T, store TokenStore, reopen func() TokenStore) {
+	t.Helper()
+	ctx := context.Background()
+	for _, inst := range []Installation{{TeamID: "T2", BotToken: "xoxb-2"}, {TeamID: "T1", BotToken: "xoxb-old"}, {TeamID: "T1", BotToken: "xoxb-1", BotUserID: "UBOT"}} {
+		if err := store.Save(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(ctx, "T2"); err != nil {
+		t.Fatal(err)
+	}
+	reopened := reopen()
+	if inst, ok, err := reopened.Find(ctx, "T1"); err != nil || !ok || inst.BotToken != "xoxb-1" || inst.BotUserID != "UBOT" {
+		t.Errorf("T1 = %+v, %v, %v", inst, ok, err)
+	}
+	if _, ok, err := reopened.Find(ctx, "T2"); err != nil || ok {
+		t.Errorf("deleted installation came back: %v, %v", ok, err)
+	}
+	if all, err := reopened.List(ctx); err != nil || len(all) != 1 || all[0].TeamID != "T1" {
+		t.Errorf("List = %+v, %v", all, err)
+	}
+}
+
+func TestSQLiteTokenStore_PersistsInstallations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.db")
+	open := func() TokenStore {
+		store, err := tokenStoreFromSpec("sqlite:" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	checkTokenStore(t, open(), open)
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("token database: %v, %v", info, err)
+	}
+}
+
+func TestRedisTokenStore_PersistsInstallations(t *testing.T) {
+	server := miniredis.RunT(t)
+	url := "redis://" + server.Addr() + "/0"
+	open := func() TokenStore {
+		store, err := tokenStoreFromSpec(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	checkTokenStore(t, open(), open)
+	if !server.Exists(redisTokenKey) {
+		t.Errorf("installations not kept in %s", redisTokenKey)
+	}
+	server.Close()
+	if _, err := tokenStoreFromSpec(url); err == nil {
+		t.Error("unreachable Redis server accepted")
+	}
+}
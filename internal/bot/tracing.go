@@ -0,0 +1,226 @@
+package bot
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/credentials"
+)
+
+// Trace Export
+//
+// With OTEL_EXPORTER_OTLP_ENDPOINT set, spans go to an OTLP collector over
+// gRPC (the default) or HTTP, chosen by OTEL_EXPORTER_OTLP_PROTOCOL.
+// Otherwise, or with OTEL_EXPORTER=console, they are printed to stdout for
+// local development. The variables follow the OpenTelemetry conventions,
+// so the same settings work for other services talking to the collector.
+
+const (
+	otlpProtocolGRPC = "grpc"
+	otlpProtocolHTTP = "http/protobuf"
+	// otlpTracesPath is appended to an HTTP endpoint, as the signal path
+	// is for the generic OTEL_EXPORTER_OTLP_ENDPOINT.
+	otlpTracesPath = "/v1/traces"
+)
+
+type tracingSettings struct {
+	// Endpoint is empty for the stdout exporter.
+	Endpoint string
+	Protocol string
+	Headers  map[string]string
+	// Insecure disables TLS for an endpoint without a scheme; an http://
+	// endpoint is always plaintext.
+	Insecure bool
+	// CAFile verifies the collector; ClientCertFile and ClientKeyFile
+	// authenticate the relay to it.
+	CAFile         string
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// Batch options; zero keeps the SDK's default.
+	BatchTimeout  time.Duration
+	ExportTimeout time.Duration
+	MaxQueueSize  int
+	MaxBatchSize  int
+}
+
+func tracingSettingsFromEnv() (tracingSettings, error) {
+	s := tracingSettings{
+		Endpoint:       os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		Protocol:       strings.ToLower(os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")),
+		CAFile:         os.Getenv("OTEL_EXPORTER_OTLP_CERTIFICATE"),
+		ClientCertFile: os.Getenv("OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE"),
+		ClientKeyFile:  os.Getenv("OTEL_EXPORTER_OTLP_CLIENT_KEY"),
+	}
+	if strings.EqualFold(os.Getenv("OTEL_EXPORTER"), "console") {
+		s.Endpoint = ""
+	}
+	switch s.Protocol {
+	case "":
+		s.Protocol = otlpProtocolGRPC
+	case otlpProtocolGRPC, otlpProtocolHTTP:
+	default:
+		return s, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_PROTOCOL %q: use grpc or http/protobuf", s.Protocol)
+	}
+	var err error
+	if s.Headers, err = parseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")); err != nil {
+		return s, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_INSECURE"); v != "" {
+		if s.Insecure, err = strconv.ParseBool(v); err != nil {
+			return s, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_INSECURE %q: use true or false", v)
+		}
+	}
+	if (s.ClientCertFile == "") != (s.ClientKeyFile == "") {
+		return s, fmt.Errorf("OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE and OTEL_EXPORTER_OTLP_CLIENT_KEY must be set together")
+	}
+	// The batch variables are milliseconds and counts, as in the SDK.
+	for name, d := range map[string]*time.Duration{"OTEL_BSP_SCHEDULE_DELAY": &s.BatchTimeout, "OTEL_BSP_EXPORT_TIMEOUT": &s.ExportTimeout} {
+		if v := os.Getenv(name); v != "" {
+			ms, err := strconv.Atoi(v)
+			if err != nil || ms <= 0 {
+				return s, fmt.Errorf("invalid %s %q: use a positive number of milliseconds", name, v)
+			}
+			*d = time.Duration(ms) * time.Millisecond
+		}
+	}
+	for name, n := range map[string]*int{"OTEL_BSP_MAX_QUEUE_SIZE": &s.MaxQueueSize, "OTEL_BSP_MAX_EXPORT_BATCH_SIZE": &s.MaxBatchSize} {
+		if v := os.Getenv(name); v != "" {
+			if *n, err = strconv.Atoi(v); err != nil || *n <= 0 {
+				return s, fmt.Errorf("invalid %s %q: use a positive number", name, v)
+			}
+		}
+	}
+	queue := s.MaxQueueSize
+	if queue == 0 {
+		queue = sdktrace.DefaultMaxQueueSize
+	}
+	if s.MaxBatchSize > queue {
+		return s, fmt.Errorf("OTEL_BSP_MAX_EXPORT_BATCH_SIZE %d exceeds the queue size %d", s.MaxBatchSize, queue)
+	}
+	return s, nil
+}
+
+// parseOTLPHeaders reads "key1=value1,key2=value2" with URL-encoded
+// values, e.g. "authorization=Bearer%20abc".
+func parseOTLPHeaders(v string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q is not key=value", pair)
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", key, err)
+		}
+		headers[key] = decoded
+	}
+	return headers, nil
+}
+
+func (s tracingSettings) batchOptions() []sdktrace.BatchSpanProcessorOption {
+	var opts []sdktrace.BatchSpanProcessorOption
+	if s.BatchTimeout > 0 {
+		opts = append(opts, sdktrace.WithBatchTimeout(s.BatchTimeout))
+	}
+	if s.ExportTimeout > 0 {
+		opts = append(opts, sdktrace.WithExportTimeout(s.ExportTimeout))
+	}
+	if s.MaxQueueSize > 0 {
+		opts = append(opts, sdktrace.WithMaxQueueSize(s.MaxQueueSize))
+	}
+	if s.MaxBatchSize > 0 {
+		opts = append(opts, sdktrace.WithMaxExportBatchSize(s.MaxBatchSize))
+	}
+	return opts
+}
+
+// tlsConfig returns the TLS settings for the collector, or nil for the
+// system defaults.
+func (s tracingSettings) tlsConfig() (*tls.Config, error) {
+	if s.CAFile == "" && s.ClientCertFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", s.CAFile)
+		}
+	}
+	if s.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.ClientCertFile, s.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// newSpanExporter builds the OTLP exporter the settings describe, or the
+// stdout exporter without an endpoint. The OTLP exporters connect lazily,
+// so an unreachable collector does not stop the relay from starting.
+func newSpanExporter(ctx context.Context, s tracingSettings) (sdktrace.SpanExporter, error) {
+	if s.Endpoint == "" {
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	}
+	tlsCfg, err := s.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("OTLP TLS: %w", err)
+	}
+	hasScheme := strings.Contains(s.Endpoint, "://")
+	if s.Protocol == otlpProtocolHTTP {
+		opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(s.Headers)}
+		if hasScheme {
+			u, err := url.Parse(s.Endpoint)
+			if err != nil {
+				return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_ENDPOINT: %w", err)
+			}
+			u.Path = strings.TrimSuffix(u.Path, "/") + otlpTracesPath
+			opts = append(opts, otlptracehttp.WithEndpointURL(u.String()))
+		} else {
+			opts = append(opts, otlptracehttp.WithEndpoint(s.Endpoint))
+			if s.Insecure {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			}
+		}
+		if tlsCfg != nil {
+			opts = append(opts, otlptracehttp.WithTLSClientConfig(tlsCfg))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithHeaders(s.Headers)}
+	if hasScheme {
+		opts = append(opts, otlptracegrpc.WithEndpointURL(s.Endpoint))
+	} else {
+		opts = append(opts, otlptracegrpc.WithEndpoint(s.Endpoint))
+		if s.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+	}
+	if tlsCfg != nil {
+		opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+	}
+	return otlptracegrpc.New(ctx, opts...)
+}
@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestParseOTLPHeaders(t *testing.T) {
+	headers, err := parseOTLPHeaders("authorization=Bearer%20abc, x-team = relay ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers["authorization"] != "Bearer abc" || headers["x-team"] != "relay" || len(headers) != 2 {
+		t.Errorf("headers = %v", headers)
+	}
+	if _, err := parseOTLPHeaders("novalue"); err == nil {
+		t.Error("header without = accepted")
+	}
+}
+
+func TestTracingSettingsFromEnv(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "collector:4317")
+	t.Setenv("OTEL_EXPORTER_OTLP_INSECURE", "true")
+	t.Setenv("OTEL_BSP_SCHEDULE_DELAY", "250")
+	t.Setenv("OTEL_BSP_MAX_QUEUE_SIZE", "100")
+	t.Setenv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", "50")
+	s, err := tracingSettingsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Protocol != otlpProtocolGRPC || !s.Insecure || s.BatchTimeout != 250*time.Millisecond {
+		t.Errorf("settings = %+v", s)
+	}
+	if got := len(s.batchOptions()); got != 3 {
+		t.Errorf("%d batch options, want 3", got)
+	}
+
+	t.Setenv("OTEL_EXPORTER", "console")
+	if s, _ := tracingSettingsFromEnv(); s.Endpoint != "" {
+		t.Errorf("OTEL_EXPORTER=console kept endpoint %q", s.Endpoint)
+	}
+}
+
+func TestTracingSettingsFromEnv_Invalid(t *testing.T) {
+	for name, env := range map[string][2]string{
+		"protocol":   {"OTEL_EXPORTER_OTLP_PROTOCOL", "thrift"},
+		"insecure":   {"OTEL_EXPORTER_OTLP_INSECURE", "maybe"},
+		"delay":      {"OTEL_BSP_SCHEDULE_DELAY", "5s"},
+		"batch size": {"OTEL_BSP_MAX_EXPORT_BATCH_SIZE", "5000"},
+		"client key": {"OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE", "client.pem"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			if _, err := tracingSettingsFromEnv(); err == nil {
+				t.Errorf("%s=%s accepted", env[0], env[1])
+			}
+		})
+	}
+}
+
+func TestNewSpanExporter_HTTP(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case received <- r:
+		default:
+		}
+	}))
+	defer srv.Close()
+
+	exporter, err := newSpanExporter(context.Background(), tracingSettings{
+		Endpoint: srv.URL,
+		Protocol: otlpProtocolHTTP,
+		Headers:  map[string]string{"authorization": "Bearer abc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := tp.Tracer("test").Start(context.Background(), "op")
+	span.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-received:
+		if r.URL.Path != otlpTracesPath {
+			t.Errorf("exported to %s, want %s", r.URL.Path, otlpTracesPath)
+		}
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			t.Errorf("authorization header %q", r.Header.Get("Authorization"))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no spans exported")
+	}
+}
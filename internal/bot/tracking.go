@@ -0,0 +1,238 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Request Tracking
+//
+// Each question gets a request ID (the ID of its conversation record) and a
+// timeline of what happened to it: when it was queued, when the backend
+// answered, the stream's chunks and every Slack post, plus any errors. The
+// most recent timelines are kept in memory so "!trace <request or trace id>"
+// can show them, with the request's recent log lines and a link to the
+// trace when TRACE_URL_TEMPLATE is set. The reference code in an error
+// message finds its request too.
+const (
+	maxTrackedRequests = 1000
+	maxTimelineEvents  = 100
+	maxTraceLogLines   = 20
+)
+
+type TimelineEvent struct {
+	At     time.Time
+	Name   string
+	Detail string
+}
+
+type RequestTimeline struct {
+	ID      string
+	TraceID string
+	Channel string
+	User    string
+	Source  string
+	Events  []TimelineEvent
+	Errors  []string
+	Dropped int
+}
+
+type requestTracker struct {
+	mu    sync.Mutex
+	byID  map[string]*RequestTimeline
+	order []string
+	max   int
+}
+
+var requests = newRequestTracker(maxTrackedRequests)
+
+func newRequestTracker(max int) *requestTracker {
+	return &requestTracker{byID: make(map[string]*RequestTimeline), max: max}
+}
+
+// timelineLocked returns the timeline for the request in ctx, creating it and
+// evicting the oldest one when needed. Callers hold t.mu.
+func (t *requestTracker) timelineLocked(ctx context.Context) *RequestTimeline {
+	id := conversationIDFrom(ctx)
+	if id == "" {
+		return nil
+	}
+	tl, ok := t.byID[id]
+	if !ok {
+		if len(t.order) >= t.max {
+			delete(t.byID, t.order[0])
+			t.order = t.order[1:]
+		}
+		tl = &RequestTimeline{ID: id}
+		t.byID[id] = tl
+		t.order = append(t.order, id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); tl.TraceID == "" && sc.HasTraceID() {
+		tl.TraceID = sc.TraceID().String()
+	}
+	return tl
+}
+
+// record adds an event to the timeline of the request in ctx, if any.
+func (t *requestTracker) record(ctx context.Context, name, detail string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tl := t.timelineLocked(ctx)
+	if tl == nil {
+		return
+	}
+	if len(tl.Events) >= maxTimelineEvents {
+		tl.Dropped++
+		return
+	}
+	tl.Events = append(tl.Events, TimelineEvent{At: time.Now(), Name: name, Detail: detail})
+}
+
+func (t *requestTracker) recordError(ctx context.Context, msg string) {
+	t.record(ctx, "error", msg)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tl := t.timelineLocked(ctx); tl != nil {
+		tl.Errors = append(tl.Errors, msg)
+	}
+}
+
+func (t *requestTracker) describe(ctx context.Context, channel, user string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tl := t.timelineLocked(ctx); tl != nil {
+		tl.Channel, tl.User, tl.Source = channel, user, querySourceFrom(ctx)
+	}
+}
+
+// queuedAt returns when the request in ctx was first recorded.
+func (t *requestTracker) queuedAt(ctx context.Context) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tl := t.timelineLocked(ctx)
+	if tl == nil || len(tl.Events) == 0 {
+		return time.Time{}, false
+	}
+	return tl.Events[0].At, true
+}
+
+// find looks a timeline up by request ID or trace ID.
+func (t *requestTracker) find(key string) (RequestTimeline, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tl, ok := t.byID[key]
+	if !ok {
+		for i := len(t.order) - 1; i >= 0; i-- {
+			if c := t.byID[t.order[i]]; c.TraceID == key {
+				tl, ok = c, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return RequestTimeline{}, false
+	}
+	c := *tl
+	c.Events = append([]TimelineEvent(nil), tl.Events...)
+	c.Errors = append([]string(nil), tl.Errors...)
+	return c, true
+}
+
+// forget drops the timeline of a request, e.g. when its answer is
+// redacted.
+func (t *requestTracker) forget(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.byID[id]; !ok {
+		return
+	}
+	delete(t.byID, id)
+	t.order = slices.DeleteFunc(t.order, func(o string) bool { return o == id })
+}
+
+// withRequestID starts tracking a new request under ctx.
+func withRequestID(ctx context.Context) context.Context {
+	return withConversationID(ctx, newID())
+}
+
+func traceURL(traceID string) string {
+	if config.TraceURLTemplate == "" || traceID == "" {
+		return ""
+	}
+	return strings.ReplaceAll(config.TraceURLTemplate, "{trace_id}", traceID)
+}
+
+func formatTimeline(tl RequestTimeline) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*Request `%s`*", tl.ID)
+	if tl.TraceID != "" {
+		fmt.Fprintf(&b, " (trace `%s`)", tl.TraceID)
+	}
+	if tl.Channel != "" {
+		fmt.Fprintf(&b, " in <#%s> from <@%s>", tl.Channel, tl.User)
+	}
+	if tl.Source != "" {
+		fmt.Fprintf(&b, " via %s", tl.Source)
+	}
+	b.WriteString("\n```\n")
+	for _, e := range tl.Events {
+		offset := e.At.Sub(tl.Events[0].At).Round(time.Millisecond)
+		fmt.Fprintf(&b, "%-10s %-18s %s\n", "+"+offset.String(), e.Name, e.Detail)
+	}
+	if tl.Dropped > 0 {
+		fmt.Fprintf(&b, "… %d more events not kept\n", tl.Dropped)
+	}
+	b.WriteString("```")
+	if len(tl.Errors) > 0 {
+		b.WriteString("\n*Errors:*")
+		for _, e := range tl.Errors {
+			fmt.Fprintf(&b, "\n• %s", e)
+		}
+	}
+	if link := traceURL(tl.TraceID); link != "" {
+		fmt.Fprintf(&b, "\n<%s|Open trace>", link)
+	}
+	return b.String()
+}
+
+func init() {
+	registerCommand("trace", command{
+		Admin: true,
+		Usage: "<reference code, request-id or trace-id>",
+		Handler: func(ctx context.Context, api SlackClient, ev slackevents.AppMentionEvent, args string) {
+			key := strings.Trim(strings.TrimSpace(args), "`")
+			if key == "" {
+				notifyUser(ctx, api, ev.Channel, ev.User, "Usage: `!trace <reference code, request-id or trace-id>`")
+				return
+			}
+			var tl RequestTimeline
+			var ok bool
+			var logs []LogEntry
+			if code, isCode := normalizeRefCode(key); isCode {
+				tl, ok = requests.findByRefCode(code)
+				logs = logsForRefCode(code)
+				if !ok && len(logs) > 0 {
+					notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("*Reference `%s`* matches no tracked request.%s", code, formatRefLogs(logs, maxTraceLogLines)))
+					return
+				}
+			} else {
+				tl, ok = requests.find(key)
+			}
+			if !ok {
+				notifyUser(ctx, api, ev.Channel, ev.User, fmt.Sprintf("No recent request matches `%s`; only the last %d are kept.", key, maxTrackedRequests))
+				return
+			}
+			if logs == nil && tl.TraceID != "" {
+				logs = recentLogs.Query(logQuery{TraceID: tl.TraceID})
+			}
+			notifyUser(ctx, api, ev.Channel, ev.User, formatTimeline(tl)+formatRefLogs(logs, maxTraceLogLines))
+		},
+	})
+}
@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestTraceCommand_ShowsTimeline(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	config.AdminUsers = []string{"UADMIN"}
+	config.TraceURLTemplate = "https://traces.example.com/trace/{trace_id}"
+	defer func() { config.AdminUsers, config.TraceURLTemplate = nil, "" }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"event\":\"message_part\",\"text_chunk\":\"One\"}\n\n")
+		fmt.Fprint(w, "data: {\"event\":\"message_part\",\"text_chunk\":\"Two\"}\n\n")
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+	processMention(context.Background(), api, slackevents.AppMentionEvent{User: "U1", Channel: "CTRACE", Text: "how?"}, pool)
+	pool.Shutdown()
+
+	rec, ok := conversations.LatestInThread("CTRACE", "")
+	if !ok {
+		t.Fatal("conversation not saved")
+	}
+	tl, ok := requests.find(rec.ID)
+	if !ok {
+		t.Fatalf("no timeline for request %s", rec.ID)
+	}
+	var names []string
+	for _, e := range tl.Events {
+		names = append(names, e.Name)
+	}
+	want := "queued started backend_request backend_response first_chunk slack_post slack_post answer_done"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("timeline = %s\nwant %s", got, want)
+	}
+
+	dispatchCommand(context.Background(), api, slackevents.AppMentionEvent{User: "UADMIN", Channel: "CTRACE"}, "!trace "+rec.ID)
+	posts := api.sent()
+	reply := posts[len(posts)-1].Text()
+	for _, s := range []string{"*Request `" + rec.ID + "`*", "backend_response", "TTFB", "2 chunks"} {
+		if !strings.Contains(reply, s) {
+			t.Errorf("reply missing %q:\n%s", s, reply)
+		}
+	}
+	// The no-op tracer in tests has no trace ID, so there is nothing to link.
+	if strings.Contains(reply, "Open trace") {
+		t.Errorf("unexpected trace link without a trace ID:\n%s", reply)
+	}
+
+	dispatchCommand(context.Background(), api, slackevents.AppMentionEvent{User: "UADMIN", Channel: "CTRACE"}, "!trace nope")
+	posts = api.sent()
+	if !strings.Contains(posts[len(posts)-1].Text(), "No recent request") {
+		t.Errorf("unexpected reply for unknown id: %q", posts[len(posts)-1].Text())
+	}
+}
+
+func TestRequestTracker_EvictsOldestAndFindsByTrace(t *testing.T) {
+	tr := newRequestTracker(2)
+	for _, id := range []string{"r1", "r2", "r3"} {
+		tr.record(withConversationID(context.Background(), id), "queued", "")
+	}
+	if _, ok := tr.find("r1"); ok {
+		t.Error("oldest request should be evicted")
+	}
+	tr.mu.Lock()
+	tr.byID["r3"].TraceID = "abc123"
+	tr.mu.Unlock()
+	if tl, ok := tr.find("abc123"); !ok || tl.ID != "r3" {
+		t.Errorf("find by trace id = %+v, %v", tl, ok)
+	}
+
+	tr.record(context.Background(), "orphan", "")
+	if len(tr.byID) != 2 {
+		t.Errorf("events without a request id should be ignored")
+	}
+	if got := traceURL("abc123"); got != "" {
+		t.Errorf("no template configured, got %q", got)
+	}
+}
@@ -0,0 +1,227 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+// Transcript Buffers
+//
+// Output uploaded as files (backend answers requested with
+// requestTranscript, such as pull request review notes, and reports) is
+// written to a transcriptBuffer and read back through its reader. A
+// buffer keeps up to TRANSCRIPT_MEMORY_LIMIT bytes in memory, and all
+// buffers together at most TRANSCRIPT_MEMORY_TOTAL; past either limit the
+// transcript spills to a temporary file in TRANSCRIPT_SPILL_DIR, so large
+// outputs arriving at once cannot exhaust memory. Spilled transcripts are
+// uploaded straight from disk and the file is removed when the buffer is
+// closed. If a spill file cannot be created the transcript stays in memory.
+// Transcripts of private DMs always stay in memory, so nothing from them is
+// written to disk.
+// Memory in use, spills and spilled bytes are published under
+// "transcripts" on /debug/vars.
+const (
+	defaultTranscriptMemoryLimit = 256 << 10
+	defaultTranscriptMemoryTotal = 32 << 20
+)
+
+var metricTranscripts = expvar.NewMap("transcripts")
+
+// transcriptMemory is the in-memory budget shared by all buffers.
+type transcriptMemory struct {
+	mu       sync.Mutex
+	perBuf   int64
+	total    int64
+	used     int64
+	spillDir string
+}
+
+func newTranscriptMemory(perBuf, total int64, spillDir string) *transcriptMemory {
+	return &transcriptMemory{perBuf: perBuf, total: total, spillDir: spillDir}
+}
+
+var transcripts = newTranscriptMemory(defaultTranscriptMemoryLimit, defaultTranscriptMemoryTotal, "")
+
+func init() {
+	metricTranscripts.Set("memory_bytes", expvar.Func(func() any { return transcripts.inUse() }))
+}
+
+func (m *transcriptMemory) configure(perBuf, total int64, spillDir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.perBuf, m.total, m.spillDir = perBuf, total, spillDir
+}
+
+// reserve claims n bytes for a buffer already holding held bytes.
+func (m *transcriptMemory) reserve(held, n int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if held+n > m.perBuf || m.used+n > m.total {
+		return false
+	}
+	m.used += n
+	return true
+}
+
+func (m *transcriptMemory) release(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.used -= n
+}
+
+func (m *transcriptMemory) inUse() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used
+}
+
+func (m *transcriptMemory) dir() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.spillDir
+}
+
+// transcriptBuffer assembles one output; Close must be called to release
+// its memory and spill file.
+type transcriptBuffer struct {
+	budget   *transcriptMemory
+	mem      bytes.Buffer
+	reserved int64
+	file     *os.File
+	size     int64
+	// pinned is set for private DMs and when a spill failed; the buffer
+	// then stays in memory.
+	pinned bool
+}
+
+// newTranscriptBuffer returns a buffer drawing on the shared budget, kept
+// in memory for private DMs.
+func newTranscriptBuffer(ctx context.Context) *transcriptBuffer {
+	b := transcripts.newBuffer()
+	b.pinned = isPrivateDM(ctx)
+	return b
+}
+
+func (m *transcriptMemory) newBuffer() *transcriptBuffer {
+	return &transcriptBuffer{budget: m}
+}
+
+func (b *transcriptBuffer) Write(p []byte) (int, error) {
+	switch {
+	case b.file != nil:
+		n, err := b.file.Write(p)
+		b.size += int64(n)
+		metricTranscripts.Add("spilled_bytes", int64(n))
+		return n, err
+	case b.pinned || b.budget.reserve(b.reserved, int64(len(p))):
+		if !b.pinned {
+			b.reserved += int64(len(p))
+		}
+		b.size += int64(len(p))
+		return b.mem.Write(p)
+	}
+	if err := b.spill(); err != nil {
+		slog.Warn("Keeping transcript in memory", "err", err)
+		metricTranscripts.Add("spill_errors", 1)
+		b.pinned = true
+		return b.Write(p)
+	}
+	return b.Write(p)
+}
+
+func (b *transcriptBuffer) WriteString(s string) (int, error) {
+	return b.Write([]byte(s))
+}
+
+// spill moves the buffered bytes to a temporary file.
+func (b *transcriptBuffer) spill() error {
+	f, err := os.CreateTemp(b.budget.dir(), "chatrelay-transcript-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b.mem.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	metricTranscripts.Add("spills", 1)
+	metricTranscripts.Add("spilled_bytes", int64(b.mem.Len()))
+	b.budget.release(b.reserved)
+	b.file, b.reserved, b.mem = f, 0, bytes.Buffer{}
+	return nil
+}
+
+// Len returns the number of bytes written.
+func (b *transcriptBuffer) Len() int64 {
+	return b.size
+}
+
+func (b *transcriptBuffer) spilled() bool {
+	return b.file != nil
+}
+
+// reader returns the transcript from its first byte.
+func (b *transcriptBuffer) reader() io.Reader {
+	if b.file != nil {
+		return io.NewSectionReader(b.file, 0, b.size)
+	}
+	return bytes.NewReader(b.mem.Bytes())
+}
+
+func (b *transcriptBuffer) Close() error {
+	b.budget.release(b.reserved)
+	b.reserved, b.mem = 0, bytes.Buffer{}
+	if b.file == nil {
+		return nil
+	}
+	f := b.file
+	b.file = nil
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// uploadTranscript uploads b as a file; a spilled transcript is streamed
+// from disk rather than loaded back into memory.
+func uploadTranscript(ctx context.Context, api SlackClient, params slack.UploadFileV2Parameters, b *transcriptBuffer) (*slack.FileSummary, error) {
+	params.FileSize = int(b.Len())
+	if b.spilled() {
+		params.Reader = b.reader()
+	} else {
+		params.Content = b.mem.String()
+	}
+	return api.UploadFileV2Context(ctx, params)
+}
+
+// configureTranscripts reads the TRANSCRIPT_* limits.
+func configureTranscripts() error {
+	perBuf, total := int64(defaultTranscriptMemoryLimit), int64(defaultTranscriptMemoryTotal)
+	for _, s := range []struct {
+		name string
+		dst  *int64
+	}{{"TRANSCRIPT_MEMORY_LIMIT", &perBuf}, {"TRANSCRIPT_MEMORY_TOTAL", &total}} {
+		if v := os.Getenv(s.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s %q: use a number of bytes", s.name, v)
+			}
+			*s.dst = n
+		}
+	}
+	dir := os.Getenv("TRANSCRIPT_SPILL_DIR")
+	if dir != "" {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("invalid TRANSCRIPT_SPILL_DIR %q: not a directory", dir)
+		}
+	}
+	transcripts.configure(perBuf, total, dir)
+	return nil
+}
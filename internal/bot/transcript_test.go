@@ -0,0 +1,179 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack"
+)
+
+func spillFiles(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestTranscriptBuffer_StaysInMemoryUnderLimit(t *testing.T) {
+	dir := t.TempDir()
+	transcripts := newTranscriptMemory(64, 1024, dir)
+	b := transcripts.newBuffer()
+	b.WriteString("short answer")
+	if b.spilled() || spillFiles(t, dir) != 0 {
+		t.Fatal("a small transcript spilled")
+	}
+	if transcripts.inUse() != int64(len("short answer")) {
+		t.Errorf("memory in use = %d", transcripts.inUse())
+	}
+	b.Close()
+	if transcripts.inUse() != 0 {
+		t.Errorf("memory not released: %d", transcripts.inUse())
+	}
+}
+
+func TestTranscriptBuffer_SpillsPastPerBufferLimit(t *testing.T) {
+	dir := t.TempDir()
+	transcripts := newTranscriptMemory(16, 1024, dir)
+	b := transcripts.newBuffer()
+	want := ""
+	for _, part := range []string{"first part ", "second part ", "third part"} {
+		b.WriteString(part)
+		want += part
+	}
+	if !b.spilled() || spillFiles(t, dir) != 1 {
+		t.Fatal("expected the transcript to spill to disk")
+	}
+	if transcripts.inUse() != 0 {
+		t.Errorf("spilled transcript still holds %d bytes of memory", transcripts.inUse())
+	}
+	if data, _ := io.ReadAll(b.reader()); string(data) != want || b.Len() != int64(len(want)) {
+		t.Errorf("reader = %q, Len = %d", data, b.Len())
+	}
+	b.Close()
+	if spillFiles(t, dir) != 0 {
+		t.Error("spill file not removed on Close")
+	}
+}
+
+func TestTranscriptBuffer_SharedBudget(t *testing.T) {
+	transcripts := newTranscriptMemory(100, 30, t.TempDir())
+	a, b := transcripts.newBuffer(), transcripts.newBuffer()
+	defer a.Close()
+	defer b.Close()
+	a.WriteString(strings.Repeat("a", 20))
+	b.WriteString(strings.Repeat("b", 20))
+	if a.spilled() || !b.spilled() {
+		t.Errorf("spilled a=%v b=%v; want only b past the shared budget", a.spilled(), b.spilled())
+	}
+}
+
+func TestTranscriptBuffer_SpillFailureKeepsMemory(t *testing.T) {
+	b := newTranscriptMemory(4, 1024, "/nonexistent/transcripts").newBuffer()
+	defer b.Close()
+	b.WriteString("more than four bytes")
+	b.WriteString(", and more")
+	if got, _ := io.ReadAll(b.reader()); b.spilled() || string(got) != "more than four bytes, and more" {
+		t.Errorf("transcript = %q, spilled %v", got, b.spilled())
+	}
+}
+
+func TestNewTranscriptBuffer_PrivateDMStaysInMemory(t *testing.T) {
+	dir := t.TempDir()
+	defer func(m *transcriptMemory) { transcripts = m }(transcripts)
+	transcripts = newTranscriptMemory(4, 1024, dir)
+	b := newTranscriptBuffer(withPrivateDM(context.Background()))
+	defer b.Close()
+	b.WriteString("a private answer longer than the limit")
+	if b.spilled() || spillFiles(t, dir) != 0 {
+		t.Fatal("a private DM transcript was written to disk")
+	}
+	shared := newTranscriptBuffer(context.Background())
+	defer shared.Close()
+	shared.WriteString("an ordinary answer longer than the limit")
+	if !shared.spilled() {
+		t.Error("an ordinary transcript past the limit should spill")
+	}
+}
+
+func TestUploadTranscript_StreamsSpilledFile(t *testing.T) {
+	transcripts := newTranscriptMemory(8, 1024, t.TempDir())
+	api := &fakeSlackClient{}
+	small, large := transcripts.newBuffer(), transcripts.newBuffer()
+	defer small.Close()
+	defer large.Close()
+	small.WriteString("tiny")
+	large.WriteString(strings.Repeat("x", 100))
+
+	uploadTranscript(context.Background(), api, slack.UploadFileV2Parameters{Filename: "small.txt"}, small)
+	uploadTranscript(context.Background(), api, slack.UploadFileV2Parameters{Filename: "large.txt"}, large)
+	if u := api.uploads[0]; u.Content != "tiny" || u.Reader != nil || u.FileSize != 4 {
+		t.Errorf("small upload = %+v", u)
+	}
+	u := api.uploads[1]
+	if u.Content != "" || u.Reader == nil || u.FileSize != 100 {
+		t.Fatalf("large upload = %+v", u)
+	}
+	if data, _ := io.ReadAll(u.Reader); len(data) != 100 {
+		t.Errorf("large upload streamed %d bytes", len(data))
+	}
+}
+
+func TestConfigureTranscripts(t *testing.T) {
+	defer func(m *transcriptMemory) { transcripts = m }(transcripts)
+	transcripts = newTranscriptMemory(1, 1, "")
+	t.Setenv("TRANSCRIPT_MEMORY_LIMIT", "1024")
+	t.Setenv("TRANSCRIPT_MEMORY_TOTAL", "4096")
+	if err := configureTranscripts(); err != nil || transcripts.perBuf != 1024 || transcripts.total != 4096 {
+		t.Errorf("configure = %v, limits %d/%d", err, transcripts.perBuf, transcripts.total)
+	}
+	t.Setenv("TRANSCRIPT_MEMORY_LIMIT", "lots")
+	if err := configureTranscripts(); err == nil {
+		t.Error("expected an error for a non-numeric limit")
+	}
+	t.Setenv("TRANSCRIPT_MEMORY_LIMIT", "")
+	t.Setenv("TRANSCRIPT_SPILL_DIR", "/nonexistent/transcripts")
+	if err := configureTranscripts(); err == nil {
+		t.Error("expected an error for a missing spill directory")
+	}
+}
+
+func TestRequestTranscript_SpillsAndStreams(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, part := range []string{"first review part", "second review part"} {
+			data, _ := json.Marshal(backend.ChatResponse{Event: "message_part", Text: part})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+	dir := t.TempDir()
+	defer func(m *transcriptMemory) { transcripts = m }(transcripts)
+	transcripts = newTranscriptMemory(8, 1024, dir)
+
+	note, err := requestTranscript(context.Background(), backend.ChatRequest{Query: "review"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer note.Close()
+	if !note.spilled() || spillFiles(t, dir) != 1 {
+		t.Fatal("expected the long answer to spill")
+	}
+	var out strings.Builder
+	if err := filterLines(context.Background(), &out, note.reader(), "C1", "U1"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "first review part\nsecond review part" {
+		t.Errorf("streamed %q", out.String())
+	}
+}
@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Reaction Translations
+//
+// REACTION_LANGUAGES maps reaction names to languages, e.g.
+// "fr=French,flag-de=German". Reacting to an answer with a mapped emoji
+// asks the backend to translate it and posts the translation as a thread
+// reply. Each answer is translated into a language at most once, however
+// many people react; the count per language is kept in the
+// "reaction_translations" map on /debug/vars.
+const translateInstruction = "Translate the previous answer into %s. Keep code blocks, links, Slack mentions and formatting unchanged, and do not add anything else."
+
+var (
+	reactionLanguages = map[string]string{}
+
+	translatedMu sync.Mutex
+	translated   = map[string]bool{}
+
+	metricReactionTranslations = expvar.NewMap("reaction_translations")
+)
+
+func parseReactionLanguages(spec string) (map[string]string, error) {
+	languages := map[string]string{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		emoji, language, found := strings.Cut(part, "=")
+		emoji = strings.Trim(strings.TrimSpace(emoji), ":")
+		language = strings.TrimSpace(language)
+		if !found || emoji == "" || language == "" {
+			return nil, fmt.Errorf("invalid reaction language %q (use emoji=Language)", part)
+		}
+		languages[emoji] = language
+	}
+	return languages, nil
+}
+
+// claimTranslation reports whether the answer still needs translating into
+// language, reserving it for the caller.
+func claimTranslation(recordID, language string) bool {
+	translatedMu.Lock()
+	defer translatedMu.Unlock()
+	key := recordID + "/" + language
+	if translated[key] {
+		return false
+	}
+	translated[key] = true
+	return true
+}
+
+func releaseTranslation(recordID, language string) {
+	translatedMu.Lock()
+	delete(translated, recordID+"/"+language)
+	translatedMu.Unlock()
+}
+
+// processReaction queues a translation when a mapped emoji is added to one
+// of the bot's answers.
+func processReaction(ctx context.Context, api SlackClient, ev *slackevents.ReactionAddedEvent, pool *workerpool.Pool) {
+	ctx = withLogFields(ctx, ev.User, ev.Item.Channel)
+	if ev.Item.Type == "message" {
+		recordReactionFeedback(ctx, ev.Item.Channel, ev.Item.Timestamp, ev.User, ev.Reaction)
+	}
+	language, ok := reactionLanguages[ev.Reaction]
+	if !ok || ev.Item.Type != "message" {
+		return
+	}
+	rec, ok := conversations.ByMessage(ev.Item.Channel, ev.Item.Timestamp)
+	if !ok || !budget.allowRegeneration(ctx, api, ev.Item.Channel, ev.User) || !claimTranslation(rec.ID, language) {
+		return
+	}
+	slog.InfoContext(ctx, "Translating answer", "conversation", rec.ID, "language", language, "user", ev.User)
+	thread := rec.ThreadTS
+	if thread == "" {
+		thread = ev.Item.Timestamp
+	}
+	submitOrdered(ctx, api, pool, rec.Channel, rec.ThreadTS, ev.User, func() {
+		translateAnswer(ctx, api, rec, ev.User, ev.Reaction, language, thread)
+	})
+}
+
+func translateAnswer(ctx context.Context, api SlackClient, rec ConversationRecord, user, emoji, language, thread string) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "translate_answer")
+	defer span.End()
+	span.SetAttributes(attribute.String("translation.language", language))
+
+	text, err := requestAnswer(ctx, backend.ChatRequest{
+		UserID:         user,
+		Query:          rec.Query,
+		ChannelID:      rec.Channel,
+		PreviousAnswer: rec.AnswerText(),
+		Instruction:    fmt.Sprintf(translateInstruction, language),
+		Model:          rec.Model,
+	})
+	if err != nil || strings.TrimSpace(text) == "" {
+		releaseTranslation(rec.ID, language)
+		if err == errMaintenance {
+			handleMaintenance(ctx, api, rec.Channel, user)
+			return
+		}
+		if err != nil {
+			span.RecordError(err)
+		}
+		notifyUser(ctx, api, rec.Channel, user, withErrorReference(ctx, fmt.Sprintf("Sorry, I couldn't translate that answer into %s. Please try again later.", language)))
+		return
+	}
+	metricReactionTranslations.Add(language, 1)
+	sendAnswer(ctx, api, rec.Channel, user, fmt.Sprintf(":%s: %s", emoji, text), slack.MsgOptionTS(thread))
+}
@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestParseReactionLanguages(t *testing.T) {
+	got, err := parseReactionLanguages(" fr=French, :flag-de: = German ,")
+	if err != nil || len(got) != 2 || got["fr"] != "French" || got["flag-de"] != "German" {
+		t.Errorf("parseReactionLanguages = %v, %v", got, err)
+	}
+	for _, spec := range []string{"fr", "=French", "fr="} {
+		if _, err := parseReactionLanguages(spec); err == nil {
+			t.Errorf("%q should be rejected", spec)
+		}
+	}
+}
+
+func TestProcessReaction_TranslatesOncePerLanguage(t *testing.T) {
+	var calls int32
+	var got backend.ChatRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Dix miles."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	saved := reactionLanguages
+	reactionLanguages = map[string]string{"fr": "French"}
+	defer func() { reactionLanguages = saved }()
+
+	rec := &ConversationRecord{ID: newID(), Channel: "CTR", User: "U1", Query: "How far?",
+		Answer: []string{"Ten miles."}, MessageTS: []string{"20.000100"}}
+	conversations.Save(rec)
+
+	api := &fakeSlackClient{}
+	pool := workerpool.New(1)
+	react := func(user, reaction, ts string) {
+		ev := &slackevents.ReactionAddedEvent{User: user, Reaction: reaction}
+		ev.Item.Type, ev.Item.Channel, ev.Item.Timestamp = "message", "CTR", ts
+		processReaction(context.Background(), api, ev, pool)
+	}
+	react("U2", "fr", "20.000100")
+	react("U3", "fr", "20.000100")
+	react("U2", "thumbsup", "20.000100")
+	react("U2", "fr", "99.000100")
+	pool.Shutdown()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("backend called %d times, want 1", n)
+	}
+	if got.PreviousAnswer != "Ten miles." || got.Instruction == "" {
+		t.Errorf("backend request = %+v", got)
+	}
+	posts := api.sent()
+	if len(posts) != 1 || posts[0].Text() != ":fr: Dix miles." || posts[0].Values.Get("thread_ts") != "20.000100" {
+		t.Errorf("unexpected posts %+v", posts)
+	}
+}
@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Answer Undo
+//
+// With UNDO_WINDOW set (e.g. 30s), each answer is followed by an "Undo"
+// button in its thread. Until the window closes, the asker can click it to
+// take the answer back, for instance after asking in the wrong channel.
+// The answer's messages are deleted with chat.delete. Its record is kept
+// but marked withdrawn, so it is no longer the thread's latest answer, is
+// left out of search and is dropped from conversation memory. Only the
+// asker can undo; anyone else is told so. The button is deleted when the
+// window closes. Offers, undos and refused clicks are counted under
+// "answer_undo" on /debug/vars.
+const ActionUndoAnswer = "undo_answer"
+
+var metricAnswerUndo = expvar.NewMap("answer_undo")
+
+type pendingUndo struct {
+	RecordID  string
+	Channel   string
+	ThreadTS  string
+	User      string
+	Query     string
+	MessageTS []string
+	// ButtonTS is the message holding the Undo button.
+	ButtonTS string
+	Deadline time.Time
+}
+
+type undoOffers struct {
+	mu      sync.Mutex
+	window  time.Duration
+	pending map[string]*pendingUndo
+	now     func() time.Time
+}
+
+func newUndoOffers(window time.Duration) *undoOffers {
+	return &undoOffers{window: window, pending: make(map[string]*pendingUndo), now: time.Now}
+}
+
+var undos = newUndoOffers(0)
+
+func (u *undoOffers) configure(window time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.window = window
+}
+
+func (u *undoOffers) currentWindow() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.window
+}
+
+func (u *undoOffers) add(id string, p *pendingUndo) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.pending[id] = p
+}
+
+// get returns id's offer while its window is open.
+func (u *undoOffers) get(id string) (pendingUndo, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	p, ok := u.pending[id]
+	if !ok || u.now().After(p.Deadline) {
+		return pendingUndo{}, false
+	}
+	return *p, true
+}
+
+// take removes id's offer, reporting whether it was still there.
+func (u *undoOffers) take(id string) (pendingUndo, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	p, ok := u.pending[id]
+	if !ok {
+		return pendingUndo{}, false
+	}
+	delete(u.pending, id)
+	return *p, true
+}
+
+func undoButtonBlock(id string) slack.Block {
+	return slack.NewActionBlock("undo_"+id,
+		slack.NewButtonBlockElement(ActionUndoAnswer, id, slack.NewTextBlockObject(slack.PlainTextType, "Undo", false, false)),
+	)
+}
+
+// offerUndo posts the Undo button under rec's answer and removes it once
+// the window closes.
+func offerUndo(ctx context.Context, api SlackClient, rec *ConversationRecord) {
+	offers := undos
+	window := offers.currentWindow()
+	if window <= 0 || isSelfTest(ctx) || len(rec.MessageTS) == 0 {
+		return
+	}
+	id := newID()
+	text := fmt.Sprintf("Asked in the wrong place? You can undo this answer for %s.", window)
+	ts, err := sendMessage(ctx, api, outgoingMessage{Channel: rec.Channel, User: rec.User, Text: text},
+		append([]slack.MsgOption{slack.MsgOptionBlocks(undoButtonBlock(id))}, threadOptions(rec.ThreadTS)...)...,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to offer undo", "channel", rec.Channel, "err", err)
+		return
+	}
+	offers.add(id, &pendingUndo{
+		RecordID:  rec.ID,
+		Channel:   rec.Channel,
+		ThreadTS:  rec.ThreadTS,
+		User:      rec.User,
+		Query:     rec.Query,
+		MessageTS: append([]string(nil), rec.MessageTS...),
+		ButtonTS:  ts,
+		Deadline:  offers.now().Add(window),
+	})
+	metricAnswerUndo.Add("offered", 1)
+	ctx = context.WithoutCancel(ctx)
+	time.AfterFunc(window, func() {
+		if p, ok := offers.take(id); ok {
+			if _, _, err := api.DeleteMessageContext(ctx, p.Channel, p.ButtonTS); err != nil {
+				slog.ErrorContext(ctx, "Failed to remove undo button", "channel", p.Channel, "err", err)
+			}
+		}
+	})
+}
+
+func handleUndoAction(ctx context.Context, api SlackClient, callback slack.InteractionCallback, action *slack.BlockAction) {
+	ctx, span := otel.Tracer("bot").Start(ctx, "undo_answer")
+	defer span.End()
+
+	user := callback.User.ID
+	span.SetAttributes(attribute.String("user.id", user), attribute.String("channel.id", callback.Channel.ID))
+	p, ok := undos.get(action.Value)
+	if !ok {
+		notifyUser(ctx, api, callback.Channel.ID, user, "It's too late to undo that answer.")
+		return
+	}
+	if user != p.User {
+		metricAnswerUndo.Add("refused", 1)
+		notifyUser(ctx, api, p.Channel, user, "Only the person who asked can undo this answer.")
+		return
+	}
+	if p, ok = undos.take(action.Value); !ok {
+		return
+	}
+	span.SetAttributes(attribute.String("conversation.id", p.RecordID))
+	for _, ts := range append(p.MessageTS, p.ButtonTS) {
+		if _, _, err := api.DeleteMessageContext(ctx, p.Channel, ts); err != nil {
+			span.RecordError(err)
+			slog.ErrorContext(ctx, "Failed to delete message while undoing an answer", "message", messageKey(p.Channel, ts), "err", err)
+		}
+	}
+	conversations.Update(p.RecordID, func(rec *ConversationRecord) { rec.Withdrawn = true })
+	searchIndex.remove(ctx, p.RecordID)
+	memory.forgetTurn(memoryKey(p.Channel, p.ThreadTS, p.User), p.Query)
+	metricAnswerUndo.Add("undone", 1)
+	slog.InfoContext(ctx, "Answer undone", "user", user, "conversation", p.RecordID, "channel", p.Channel)
+	notifyUser(ctx, api, p.Channel, user, ":leftwards_arrow_with_hook: Answer removed.")
+}
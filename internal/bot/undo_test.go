@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestUndo_OnlyAskerCanUndo(t *testing.T) {
+	defer func(d time.Duration) { postInterval = d }(postInterval)
+	postInterval = 0
+	defer func(s *ConversationStore) { conversations = s }(conversations)
+	conversations = NewConversationStore()
+	defer func(u *undoOffers) { undos = u }(undos)
+	undos = newUndoOffers(time.Minute)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.ChatResponse{Full: "Deploys run from the release branch."})
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	api := &fakeSlackClient{}
+	rec := processTask(context.Background(), api, slackevents.AppMentionEvent{User: "UASK", Channel: "CUNDO", ThreadTimeStamp: "7.7"}, "How do deploys work?")
+	if rec == nil || len(rec.MessageTS) == 0 {
+		t.Fatalf("no answer posted: %+v", rec)
+	}
+	offer := api.posts[len(api.posts)-1]
+	if !strings.Contains(offer.Values.Get("blocks"), ActionUndoAnswer) || offer.Values.Get("thread_ts") != "7.7" {
+		t.Fatalf("expected an undo button in the thread, got %v", offer.Values)
+	}
+	var id string
+	for k := range undos.pending {
+		id = k
+	}
+
+	handleInteraction(context.Background(), api, reviewCallback("UOTHER", ActionUndoAnswer, id))
+	if len(api.deleted) != 0 {
+		t.Fatalf("another user's click deleted %v", api.deleted)
+	}
+	handleInteraction(context.Background(), api, reviewCallback("UASK", ActionUndoAnswer, id))
+	if len(api.deleted) != len(rec.MessageTS)+1 {
+		t.Fatalf("deleted %v, want the answer and the button", api.deleted)
+	}
+	if saved, _ := conversations.Get(rec.ID); !saved.Withdrawn {
+		t.Error("record not marked withdrawn")
+	}
+	if _, ok := conversations.LatestInThread("CUNDO", "7.7"); ok {
+		t.Error("withdrawn answer still the thread's latest")
+	}
+}
+
+func TestUndo_ExpiresAfterWindow(t *testing.T) {
+	defer func(u *undoOffers) { undos = u }(undos)
+	undos = newUndoOffers(10 * time.Millisecond)
+	api := &fakeSlackClient{}
+	offerUndo(context.Background(), api, &ConversationRecord{ID: "r1", Channel: "CUNDO", User: "UASK", MessageTS: []string{"1.1"}})
+	var id string
+	for k := range undos.pending {
+		id = k
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := undos.get(id); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("offer still open after its window")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	handleInteraction(context.Background(), api, reviewCallback("UASK", ActionUndoAnswer, id))
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for _, ts := range api.deleted {
+		if ts == "1.1" {
+			t.Error("answer deleted after the window closed")
+		}
+	}
+}
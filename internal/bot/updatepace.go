@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Update Coalescing
+//
+// Live-edit streaming rewrites a single message with chat.update as chunks
+// arrive, and Slack allows only about one update per second per channel.
+// updatePacer decides per channel how often such edits may be sent: each
+// rate-limited update (HTTP 429) doubles the channel's coalescing interval,
+// honouring Retry-After, up to maxUpdateInterval, and each successful update
+// shrinks it back toward the base interval. A channel that is rate limited
+// finalOnlyStrikes times within pressureWindow is downgraded to final-only
+// mode for finalOnlyCooldown: intermediate edits are skipped and only the
+// finished answer is written. Interval increases, downgrades and recoveries
+// are counted under "update_pacing" on /debug/vars.
+const (
+	defaultUpdateInterval = time.Second
+	maxUpdateInterval     = 10 * time.Second
+	finalOnlyStrikes      = 3
+	pressureWindow        = time.Minute
+	finalOnlyCooldown     = 5 * time.Minute
+)
+
+var metricUpdatePacing = expvar.NewMap("update_pacing")
+
+type channelPace struct {
+	interval       time.Duration
+	lastUpdate     time.Time
+	strikes        []time.Time
+	finalOnlyUntil time.Time
+}
+
+type updatePacer struct {
+	mu       sync.Mutex
+	base     time.Duration
+	max      time.Duration
+	channels map[string]*channelPace
+}
+
+var updatePacing = newUpdatePacer(defaultUpdateInterval, maxUpdateInterval)
+
+func newUpdatePacer(base, max time.Duration) *updatePacer {
+	return &updatePacer{base: base, max: max, channels: make(map[string]*channelPace)}
+}
+
+// paceLocked returns the channel's state; callers hold p.mu.
+func (p *updatePacer) paceLocked(channel string) *channelPace {
+	c, ok := p.channels[channel]
+	if !ok {
+		c = &channelPace{interval: p.base}
+		p.channels[channel] = c
+	}
+	return c
+}
+
+// interval is the current minimum gap between edits in channel.
+func (p *updatePacer) interval(channel string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paceLocked(channel).interval
+}
+
+// finalOnly reports whether intermediate edits in channel are suspended.
+func (p *updatePacer) finalOnly(channel string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return now.Before(p.paceLocked(channel).finalOnlyUntil)
+}
+
+// due reports whether an intermediate edit may be sent in channel now.
+func (p *updatePacer) due(channel string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.paceLocked(channel)
+	return !now.Before(c.finalOnlyUntil) && now.Sub(c.lastUpdate) >= c.interval
+}
+
+// observe records the result of a chat.update in channel.
+func (p *updatePacer) observe(ctx context.Context, channel string, err error, now time.Time) {
+	var rateLimited *slack.RateLimitedError
+	switch {
+	case err == nil:
+		p.succeeded(ctx, channel, now)
+	case errors.As(err, &rateLimited):
+		p.rateLimited(ctx, channel, rateLimited.RetryAfter, now)
+	}
+}
+
+func (p *updatePacer) succeeded(ctx context.Context, channel string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.paceLocked(channel)
+	c.lastUpdate = now
+	if c.interval > p.base {
+		c.interval = max(p.base, c.interval*3/4)
+		if c.interval == p.base {
+			metricUpdatePacing.Add("recoveries", 1)
+			slog.InfoContext(ctx, "chat.update pacing back to normal", "channel", channel, "interval", p.base)
+		}
+	}
+}
+
+func (p *updatePacer) rateLimited(ctx context.Context, channel string, retryAfter time.Duration, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.paceLocked(channel)
+	c.lastUpdate = now
+	c.interval = min(p.max, max(c.interval*2, retryAfter))
+	metricUpdatePacing.Add("interval_increases", 1)
+
+	cutoff := now.Add(-pressureWindow)
+	kept := c.strikes[:0]
+	for _, t := range c.strikes {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	c.strikes = append(kept, now)
+	if len(c.strikes) >= finalOnlyStrikes && !now.Before(c.finalOnlyUntil) {
+		c.finalOnlyUntil = now.Add(finalOnlyCooldown)
+		c.strikes = nil
+		metricUpdatePacing.Add("final_only_downgrades", 1)
+		slog.WarnContext(ctx, "chat.update rate limited; only final answers will be written for a while", "strikes", finalOnlyStrikes, "window", pressureWindow, "channel", channel, "cooldown", finalOnlyCooldown)
+	}
+}
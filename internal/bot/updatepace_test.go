@@ -0,0 +1,79 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestUpdatePacer_AdaptsToRateLimits(t *testing.T) {
+	p := newUpdatePacer(time.Second, 10*time.Second)
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+
+	if !p.due("C1", now) {
+		t.Fatal("first edit should be due")
+	}
+	p.observe(ctx, "C1", nil, now)
+	if p.due("C1", now.Add(500*time.Millisecond)) {
+		t.Error("edit within the interval should wait")
+	}
+
+	p.observe(ctx, "C1", &slack.RateLimitedError{RetryAfter: 3 * time.Second}, now.Add(time.Second))
+	if got := p.interval("C1"); got != 3*time.Second {
+		t.Errorf("interval after Retry-After 3s = %s", got)
+	}
+	p.observe(ctx, "C1", &slack.RateLimitedError{}, now.Add(2*time.Second))
+	if got := p.interval("C1"); got != 6*time.Second {
+		t.Errorf("interval after second 429 = %s", got)
+	}
+	if p.interval("C2") != time.Second {
+		t.Error("other channels keep the base interval")
+	}
+
+	// Successes bring the interval back down.
+	for i := 0; i < 10; i++ {
+		p.observe(ctx, "C1", nil, now.Add(time.Duration(10+i)*time.Second))
+	}
+	if got := p.interval("C1"); got != time.Second {
+		t.Errorf("interval after recovery = %s", got)
+	}
+
+	// Errors other than rate limits do not change pacing.
+	p.observe(ctx, "C1", errors.New("message_not_found"), now.Add(30*time.Second))
+	if p.interval("C1") != time.Second {
+		t.Error("non rate-limit errors should not slow edits down")
+	}
+}
+
+func TestUpdatePacer_FallsBackToFinalOnly(t *testing.T) {
+	p := newUpdatePacer(time.Second, 10*time.Second)
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+
+	before := metricUpdatePacing.Get("final_only_downgrades")
+	for i := 0; i < finalOnlyStrikes; i++ {
+		p.observe(ctx, "CBUSY", &slack.RateLimitedError{}, now.Add(time.Duration(i)*10*time.Second))
+	}
+	later := now.Add(time.Minute)
+	if !p.finalOnly("CBUSY", later) || p.due("CBUSY", later) {
+		t.Fatal("persistent pressure should switch the channel to final-only mode")
+	}
+	if after := metricUpdatePacing.Get("final_only_downgrades"); after == nil || (before != nil && after.String() == before.String()) {
+		t.Error("downgrade was not counted")
+	}
+	if p.finalOnly("CBUSY", now.Add(finalOnlyCooldown+time.Minute)) {
+		t.Error("final-only mode should end after the cooldown")
+	}
+
+	// Strikes spread out beyond the pressure window do not downgrade.
+	for i := 0; i < finalOnlyStrikes; i++ {
+		p.observe(ctx, "CSLOW", &slack.RateLimitedError{}, now.Add(time.Duration(i)*2*pressureWindow))
+	}
+	if p.finalOnly("CSLOW", now.Add(6*pressureWindow)) {
+		t.Error("occasional 429s should not trigger final-only mode")
+	}
+}
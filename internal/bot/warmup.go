@@ -0,0 +1,176 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/backend"
+	relayconfig "github.com/heykvr/chatrelaybot/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Backend Warm-up
+//
+// Cold-start backends (serverless, model loading) are primed with a few
+// low-priority requests before socket mode starts delivering user traffic.
+// Warm-up runs as the "warmup" subsystem, started after the HTTP server so
+// the built-in mock backend can answer it, and the listener and startup
+// report wait for its first round. Each request gets WARMUP_TIMEOUT
+// (default 30s), so a hanging backend delays startup by a bounded time.
+// /readyz answers 503 while the first round runs. If every request of a
+// round fails, warm-up is "degraded": the relay serves traffic anyway,
+// /readyz answers 200 with the last error, and the runner retries the
+// round with its restart backoff until one request succeeds.
+const (
+	WarmupDisabled = "disabled"
+	WarmupRunning  = "warming"
+	WarmupReady    = "ready"
+	WarmupDegraded = "degraded"
+)
+
+type WarmupReport struct {
+	State     string    `json:"state"`
+	Succeeded int       `json:"succeeded"`
+	Attempted int       `json:"attempted"`
+	LastError string    `json:"last_error,omitempty"`
+	Finished  time.Time `json:"finished_at,omitempty"`
+}
+
+type warmupStatus struct {
+	mu     sync.RWMutex
+	report WarmupReport
+
+	// settled is closed once the first round has finished, or warm-up
+	// turned out to be disabled.
+	settled    chan struct{}
+	settleOnce sync.Once
+}
+
+var warmup = &warmupStatus{report: WarmupReport{State: WarmupDisabled}, settled: make(chan struct{})}
+
+func (w *warmupStatus) snapshot() WarmupReport {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.report
+}
+
+func (w *warmupStatus) update(fn func(r *WarmupReport)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fn(&w.report)
+}
+
+func (w *warmupStatus) settle() {
+	w.settleOnce.Do(func() { close(w.settled) })
+}
+
+// wait returns once the first round has finished or ctx is done.
+func (w *warmupStatus) wait(ctx context.Context) {
+	select {
+	case <-w.settled:
+	case <-ctx.Done():
+	}
+}
+
+// warmUpBackend sends one round of warm-up requests and returns an error
+// when all of them failed, so that the runner retries the round.
+func warmUpBackend(ctx context.Context, requests int, query string) error {
+	defer warmup.settle()
+	if requests <= 0 {
+		return nil
+	}
+	ctx, span := otel.Tracer("bot").Start(ctx, "backend_warmup")
+	defer span.End()
+	span.SetAttributes(attribute.Int("warmup.requests", requests))
+
+	// A retry keeps serving traffic, so only the first round is "warming".
+	warmup.update(func(r *WarmupReport) {
+		if r.State != WarmupDegraded {
+			*r = WarmupReport{State: WarmupRunning}
+		}
+	})
+	slog.InfoContext(ctx, "Warming up backend", "requests", requests)
+
+	for i := 0; i < requests; i++ {
+		err := sendWarmupRequest(ctx, query)
+		warmup.update(func(r *WarmupReport) {
+			r.Attempted++
+			if err != nil {
+				r.LastError = err.Error()
+			} else {
+				r.Succeeded++
+			}
+		})
+		if err != nil {
+			span.RecordError(err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(i+1) * time.Second):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	warmup.update(func(r *WarmupReport) {
+		r.Finished = time.Now()
+		r.State = WarmupDegraded
+		if r.Succeeded > 0 {
+			r.State = WarmupReady
+		}
+	})
+	st := warmup.snapshot()
+	span.SetAttributes(attribute.String("warmup.state", st.State))
+	slog.InfoContext(ctx, "Backend warm-up finished", "state", st.State, "succeeded", st.Succeeded, "attempted", st.Attempted)
+	if st.State == WarmupDegraded && ctx.Err() == nil {
+		return fmt.Errorf("every warm-up request failed: %s", st.LastError)
+	}
+	return nil
+}
+
+func sendWarmupRequest(ctx context.Context, query string) error {
+	timeout := config.WarmupTimeout
+	if timeout <= 0 {
+		timeout = relayconfig.DefaultWarmupTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	release, err := backendLimits.acquire(ctx, config.BackendURL)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	body, _ := json.Marshal(backend.ChatRequest{UserID: "warmup", Query: query})
+	req, err := newBackendRequest(ctx, body, "application/json")
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Request-Priority", "low")
+	resp, err := backendClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("backend returned %s", resp.Status)
+	}
+	return nil
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	st := warmup.snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	// A replica handing over stops being ready so no new traffic is sent.
+	if st.State == WarmupRunning || handover.paused() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"warmup": st, "handover": handover.current()})
+}
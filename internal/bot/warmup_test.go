@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmUpBackend_SendsLowPriorityRequests(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Request-Priority") == "low" {
+			atomic.AddInt32(&count, 1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"full_response":"pong"}`))
+	}))
+	defer ts.Close()
+	config.BackendURL = ts.URL
+
+	warmUpBackend(context.Background(), 3, "ping")
+
+	if count != 3 {
+		t.Errorf("expected 3 low-priority warm-up requests, got %d", count)
+	}
+	if st := warmup.snapshot(); st.State != WarmupReady || st.Succeeded != 3 {
+		t.Errorf("unexpected warm-up status %+v", st)
+	}
+
+	rec := httptest.NewRecorder()
+	readyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /readyz 200 after warm-up, got %d", rec.Code)
+	}
+}
+
+func TestReadyz_UnavailableWhileWarming(t *testing.T) {
+	warmup.update(func(r *WarmupReport) { *r = WarmupReport{State: WarmupRunning} })
+	defer warmup.update(func(r *WarmupReport) { *r = WarmupReport{State: WarmupDisabled} })
+
+	rec := httptest.NewRecorder()
+	readyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while warming, got %d", rec.Code)
+	}
+}
+
+func TestWarmUpBackend_HangingBackendTimesOutAndDegrades(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+	saved := config
+	defer func() { config = saved }()
+	config.BackendURL, config.WarmupTimeout = ts.URL, 20*time.Millisecond
+	defer warmup.update(func(r *WarmupReport) { *r = WarmupReport{State: WarmupDisabled} })
+
+	done := make(chan error, 1)
+	go func() { done <- warmUpBackend(context.Background(), 1, "ping") }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("a failed round should be reported so it is retried")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("warm-up did not time out against a hanging backend")
+	}
+	if st := warmup.snapshot(); st.State != WarmupDegraded || st.LastError == "" {
+		t.Errorf("unexpected warm-up status %+v", st)
+	}
+	// A degraded replica still takes traffic.
+	rec := httptest.NewRecorder()
+	readyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /readyz 200 after a failed warm-up, got %d", rec.Code)
+	}
+}
+
+func TestWarmupSubsystem_RetriesUntilReady(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			http.Error(w, "cold", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"full_response":"pong"}`))
+	}))
+	defer ts.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.BackendURL = ts.URL
+	defer warmup.update(func(r *WarmupReport) { *r = WarmupReport{State: WarmupDisabled} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := NewRunner()
+	runner.sleep = func(context.Context, time.Duration) {}
+	runner.Add(subsystem{name: "warmup_test", run: func(ctx context.Context) error {
+		return warmUpBackend(ctx, 1, "ping")
+	}})
+	done := make(chan error, 1)
+	go func() { done <- runner.Run(ctx) }()
+	deadline := time.After(5 * time.Second)
+	for warmup.snapshot().State != WarmupReady {
+		select {
+		case <-deadline:
+			t.Fatalf("warm-up not retried to ready: %+v", warmup.snapshot())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if st := warmup.snapshot(); st.Attempted != 3 || st.Succeeded != 1 {
+		t.Errorf("unexpected warm-up status %+v", st)
+	}
+	// The waiters were released by the first, failed round.
+	waited := make(chan struct{})
+	go func() {
+		warmup.wait(context.Background())
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Error("listener still waiting after warm-up finished")
+	}
+	cancel()
+	<-done
+}
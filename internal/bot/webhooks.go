@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Lifecycle Webhooks
+//
+// With WEBHOOK_URLS set (comma-separated), the relay POSTs a JSON event to
+// each URL as questions move through it: query.received when a question is
+// queued, answer.started when a worker picks it up, answer.completed with
+// the answer once it is posted, answer.failed with the error and its
+// reference code, and feedback.received when someone reacts to an answer
+// with a thumbs up or down or an admin labels an evaluation sample.
+// slo.alert is sent when a latency SLO burns its budget too fast or
+// recovers (see slo.go).
+// WEBHOOK_EVENTS limits which events are sent. Payloads carry question and
+// answer text, except for DMs in privacy mode (see privacy.go). With WEBHOOK_SECRET set they are signed like backend
+// requests (see signing.go), so receivers can verify them the same way.
+// Events are delivered in the background from a bounded queue and retried
+// up to webhookAttempts times; a full queue drops events rather than
+// slowing answers down. Deliveries, failures and drops are counted per
+// event under "webhooks" on /debug/vars.
+const (
+	EventQueryReceived    = "query.received"
+	EventAnswerStarted    = "answer.started"
+	EventAnswerCompleted  = "answer.completed"
+	EventAnswerFailed     = "answer.failed"
+	EventFeedbackReceived = "feedback.received"
+	EventSLOAlert         = "slo.alert"
+
+	webhookQueueSize = 256
+	webhookAttempts  = 3
+	webhookTimeout   = 10 * time.Second
+	webhookWorkers   = 2
+
+	headerRelayEvent    = "X-Relay-Event"
+	headerRelayDelivery = "X-Relay-Delivery"
+)
+
+var webhookEventTypes = []string{EventQueryReceived, EventAnswerStarted, EventAnswerCompleted, EventAnswerFailed, EventFeedbackReceived, EventSLOAlert}
+
+// WebhookEvent is the body of every webhook.
+type WebhookEvent struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	Time      time.Time      `json:"time"`
+	RequestID string         `json:"request_id,omitempty"`
+	TraceID   string         `json:"trace_id,omitempty"`
+	Channel   string         `json:"channel,omitempty"`
+	User      string         `json:"user,omitempty"`
+	ThreadTS  string         `json:"thread_ts,omitempty"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+type webhookDispatcher struct {
+	urls    []string
+	secret  string
+	events  map[string]bool
+	queue   chan WebhookEvent
+	client  *http.Client
+	backoff time.Duration
+}
+
+var (
+	// webhooks is nil unless WEBHOOK_URLS is set.
+	webhooks      *webhookDispatcher
+	metricWebhook = expvar.NewMap("webhooks")
+)
+
+// newWebhookDispatcher starts delivering to urls. events limits the event
+// types sent; empty means all of them.
+func newWebhookDispatcher(urls []string, secret string, events []string) (*webhookDispatcher, error) {
+	d := &webhookDispatcher{
+		urls:    urls,
+		secret:  secret,
+		events:  map[string]bool{},
+		queue:   make(chan WebhookEvent, webhookQueueSize),
+		client:  &http.Client{Timeout: webhookTimeout},
+		backoff: time.Second,
+	}
+	if len(events) == 0 {
+		events = webhookEventTypes
+	}
+	for _, e := range events {
+		known := false
+		for _, t := range webhookEventTypes {
+			known = known || e == t
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown webhook event %q (use %s)", e, strings.Join(webhookEventTypes, ", "))
+		}
+		d.events[e] = true
+	}
+	for i := 0; i < webhookWorkers; i++ {
+		go d.run()
+	}
+	return d, nil
+}
+
+// emitWebhook queues an event about the request in ctx, if webhooks are
+// on. It never blocks.
+func emitWebhook(ctx context.Context, eventType, channel, user, threadTS string, data map[string]any) {
+	d := webhooks
+	if d == nil || !d.events[eventType] || isSelfTest(ctx) {
+		return
+	}
+	if isPrivateDM(ctx) && (data["query"] != nil || data["answer"] != nil) {
+		redacted := make(map[string]any, len(data))
+		for k, v := range data {
+			if k != "query" && k != "answer" {
+				redacted[k] = v
+			}
+		}
+		data = redacted
+	}
+	ev := WebhookEvent{ID: newID(), Type: eventType, Time: time.Now().UTC(), RequestID: conversationIDFrom(ctx),
+		Channel: channel, User: user, ThreadTS: threadTS, Data: data}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		ev.TraceID = sc.TraceID().String()
+	}
+	select {
+	case d.queue <- ev:
+	default:
+		metricWebhook.Add(eventType+".dropped", 1)
+	}
+}
+
+func (d *webhookDispatcher) run() {
+	for ev := range d.queue {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			slog.Error("Failed to encode webhook", "type", ev.Type, "err", err)
+			continue
+		}
+		for _, url := range d.urls {
+			d.deliver(url, ev, body)
+		}
+	}
+}
+
+func (d *webhookDispatcher) deliver(url string, ev WebhookEvent, body []byte) {
+	var err error
+	for attempt := 0; attempt < webhookAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(d.backoff * time.Duration(1<<(attempt-1)))
+		}
+		if err = d.post(url, ev, body); err == nil {
+			metricWebhook.Add(ev.Type+".delivered", 1)
+			return
+		}
+	}
+	metricWebhook.Add(ev.Type+".failed", 1)
+	slog.Error("Failed to deliver webhook", "type", ev.Type, "webhook", ev.ID, "host", hostOf(url), "attempts", webhookAttempts, "err", err)
+}
+
+func (d *webhookDispatcher) post(url string, ev WebhookEvent, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerRelayEvent, ev.Type)
+	req.Header.Set(headerRelayDelivery, ev.ID)
+	signWithSecret(req, d.secret, body)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Feedback reactions on answers, by emoji name.
+var feedbackReactions = map[string]string{
+	"+1": "positive", "thumbsup": "positive",
+	"-1": "negative", "thumbsdown": "negative",
+}
+
+// recordReactionFeedback stores a thumbs reaction on an answer and sends
+// feedback.received.
+func recordReactionFeedback(ctx context.Context, channel, ts, user, reaction string) {
+	rating, ok := feedbackReactions[strings.SplitN(reaction, "::", 2)[0]]
+	if !ok {
+		return
+	}
+	rec, ok := conversations.ByMessage(channel, ts)
+	if !ok {
+		return
+	}
+	conversations.AddFeedback(rec.ID, AnswerFeedback{By: user, Rating: rating, Source: "reaction"})
+	emitWebhook(withConversationID(ctx, rec.ID), EventFeedbackReceived, rec.Channel, rec.User, rec.ThreadTS, map[string]any{
+		"source": "reaction", "rating": rating, "reaction": reaction, "by": user, "message_ts": ts,
+	})
+}
+
+// emitAnswerFailed sends answer.failed with the reference code the user
+// was shown.
+func emitAnswerFailed(ctx context.Context, channel, user, threadTS, reason string, err error) {
+	emitWebhook(ctx, EventAnswerFailed, channel, user, threadTS, map[string]any{
+		"reason": reason, "error": err.Error(), "ref_code": errorReference(ctx),
+	})
+}
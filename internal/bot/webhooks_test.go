@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestWebhooks returns a dispatcher delivering to a test server, and the
+// events the server receives, verified against secret when one is given.
+func newTestWebhooks(t *testing.T, secret string, status int, events ...string) (*webhookDispatcher, <-chan WebhookEvent) {
+	t.Helper()
+	received := make(chan WebhookEvent, 16)
+	verifier := newSignatureVerifier(secret)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := verifier.verify(r.Header, body); secret != "" && err != nil {
+			t.Errorf("webhook signature: %v", err)
+		}
+		var ev WebhookEvent
+		if err := json.Unmarshal(body, &ev); err != nil || r.Header.Get(headerRelayEvent) != ev.Type {
+			t.Errorf("bad webhook %s: %v", body, err)
+		}
+		received <- ev
+		w.WriteHeader(status)
+	}))
+	d, err := newWebhookDispatcher([]string{server.URL}, secret, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.backoff = time.Millisecond
+	t.Cleanup(func() {
+		close(d.queue)
+		server.Close()
+	})
+	return d, received
+}
+
+func nextWebhook(t *testing.T, received <-chan WebhookEvent) WebhookEvent {
+	t.Helper()
+	select {
+	case ev := <-received:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no webhook delivered")
+		return WebhookEvent{}
+	}
+}
+
+func TestEmitWebhook_SignedDelivery(t *testing.T) {
+	d, received := newTestWebhooks(t, "hook-secret", http.StatusOK)
+	defer func(w *webhookDispatcher) { webhooks = w }(webhooks)
+	webhooks = d
+	ctx := withConversationID(context.Background(), "req-1")
+	emitWebhook(ctx, EventQueryReceived, "C1", "U1", "1.0", map[string]any{"query": "hi"})
+
+	ev := nextWebhook(t, received)
+	if ev.Type != EventQueryReceived || ev.RequestID != "req-1" || ev.Channel != "C1" || ev.Data["query"] != "hi" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestEmitWebhook_RetriesFailures(t *testing.T) {
+	d, received := newTestWebhooks(t, "", http.StatusServiceUnavailable)
+	defer func(w *webhookDispatcher) { webhooks = w }(webhooks)
+	webhooks = d
+	emitWebhook(context.Background(), EventAnswerStarted, "C1", "U1", "", nil)
+	for i := 0; i < webhookAttempts; i++ {
+		nextWebhook(t, received)
+	}
+	select {
+	case ev := <-received:
+		t.Errorf("delivered more than %d times: %+v", webhookAttempts, ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEmitWebhook_EventFilter(t *testing.T) {
+	d, received := newTestWebhooks(t, "", http.StatusOK, EventAnswerFailed)
+	defer func(w *webhookDispatcher) { webhooks = w }(webhooks)
+	webhooks = d
+	emitWebhook(context.Background(), EventAnswerStarted, "C1", "U1", "", nil)
+	emitAnswerFailed(context.Background(), "C1", "U1", "", "backend_unreachable", io.ErrUnexpectedEOF)
+	ev := nextWebhook(t, received)
+	if ev.Type != EventAnswerFailed || ev.Data["reason"] != "backend_unreachable" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if _, err := newWebhookDispatcher([]string{"http://example.com"}, "", []string{"answer.exploded"}); err == nil {
+		t.Error("unknown event type accepted")
+	}
+}
+
+func TestRecordReactionFeedback(t *testing.T) {
+	d, received := newTestWebhooks(t, "", http.StatusOK)
+	defer func(w *webhookDispatcher) { webhooks = w }(webhooks)
+	webhooks = d
+	conversations.Save(&ConversationRecord{ID: "conv-feedback", Channel: "CFB", User: "U1", Query: "q", Answer: []string{"a"}, MessageTS: []string{"70.000100"}})
+
+	recordReactionFeedback(context.Background(), "CFB", "70.000100", "U2", "eyes")
+	recordReactionFeedback(context.Background(), "CFB", "70.000100", "U2", "thumbsdown::skin-tone-3")
+	ev := nextWebhook(t, received)
+	if ev.Type != EventFeedbackReceived || ev.RequestID != "conv-feedback" || ev.Data["rating"] != "negative" || ev.Data["by"] != "U2" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
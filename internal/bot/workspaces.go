@@ -0,0 +1,467 @@
+package bot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Workspaces
+//
+// The relay can be installed into more than one Slack workspace. With
+// SLACK_CLIENT_ID, SLACK_CLIENT_SECRET and SLACK_OAUTH_REDIRECT_URL set,
+// GET /slack/install sends the installer to Slack's consent screen and
+// GET /slack/oauth_redirect (the redirect URL) exchanges the code for the
+// workspace's bot token and saves it in the token store. Every event,
+// interaction and slash command is then answered with the token of its
+// team_id; teams without an installation, such as the one SLACK_BOT_TOKEN
+// belongs to, use SLACK_BOT_TOKEN. Uninstalling the app or revoking its
+// tokens removes the installation. The install state is signed with the
+// client secret and bound to a cookie, so it survives restarts and works
+// on any replica.
+//
+// TOKEN_STORE picks where installations are kept: "memory" (the default,
+// lost on restart), "file:/path/to/tokens.json" (written with mode 0600),
+// or SQLite or Redis (see Database Token Stores). Other stores implement
+// TokenStore and are passed to the relay with relay.WithTokenStore. Scheduled jobs (digests,
+// daily summaries, the outbox) and the setup wizard post with
+// SLACK_BOT_TOKEN. Installs, failures, uninstalls and clients in use are
+// counted under "workspaces" on /debug/vars.
+const (
+	slackAuthorizeURL      = "https://slack.com/oauth/v2/authorize"
+	defaultSlackOAuthScope = "app_mentions:read,channels:history,channels:read,chat:write,commands,files:read,files:write,groups:history,im:history,im:read,im:write,reactions:read,users:read"
+	oauthStateTTL          = 10 * time.Minute
+	oauthStateCookie       = "chatrelay_oauth_state"
+	oauthTimeout           = 10 * time.Second
+)
+
+var metricWorkspaces = expvar.NewMap("workspaces")
+
+// Installation is the relay's bot token in one workspace.
+type Installation struct {
+	TeamID       string    `json:"team_id"`
+	TeamName     string    `json:"team_name,omitempty"`
+	EnterpriseID string    `json:"enterprise_id,omitempty"`
+	AppID        string    `json:"app_id,omitempty"`
+	BotUserID    string    `json:"bot_user_id,omitempty"`
+	BotToken     string    `json:"bot_token"`
+	Scope        string    `json:"scope,omitempty"`
+	InstalledBy  string    `json:"installed_by,omitempty"`
+	InstalledAt  time.Time `json:"installed_at"`
+}
+
+// TokenStore keeps installations by team ID. Find reports false, without
+// an error, for a team that is not installed.
+type TokenStore interface {
+	Save(ctx context.Context, inst Installation) error
+	Find(ctx context.Context, teamID string) (Installation, bool, error)
+	Delete(ctx context.Context, teamID string) error
+	List(ctx context.Context) ([]Installation, error)
+}
+
+type memoryTokenStore struct {
+	mu    sync.Mutex
+	teams map[string]Installation
+	// path, when set, is rewritten after every change.
+	path string
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{teams: make(map[string]Installation)}
+}
+
+// newFileTokenStore loads path; a missing file is an empty store.
+func newFileTokenStore(path string) (*memoryTokenStore, error) {
+	s := newMemoryTokenStore()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var insts []Installation
+	if err := json.Unmarshal(data, &insts); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, inst := range insts {
+		s.teams[inst.TeamID] = inst
+	}
+	return s, nil
+}
+
+func (s *memoryTokenStore) Save(_ context.Context, inst Installation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, had := s.teams[inst.TeamID]
+	s.teams[inst.TeamID] = inst
+	if err := s.saveLocked(); err != nil {
+		if had {
+			s.teams[inst.TeamID] = prev
+		} else {
+			delete(s.teams, inst.TeamID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *memoryTokenStore) Find(_ context.Context, teamID string) (Installation, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst, ok := s.teams[teamID]
+	return inst, ok, nil
+}
+
+func (s *memoryTokenStore) Delete(_ context.Context, teamID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.teams[teamID]; !ok {
+		return nil
+	}
+	delete(s.teams, teamID)
+	return s.saveLocked()
+}
+
+func (s *memoryTokenStore) List(context.Context) ([]Installation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listLocked(), nil
+}
+
+func (s *memoryTokenStore) listLocked() []Installation {
+	out := make([]Installation, 0, len(s.teams))
+	for _, inst := range s.teams {
+		out = append(out, inst)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TeamID < out[j].TeamID })
+	return out
+}
+
+// saveLocked writes the store atomically; callers hold s.mu.
+func (s *memoryTokenStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// tokenStoreFromSpec opens a TOKEN_STORE setting.
+func tokenStoreFromSpec(spec string) (TokenStore, error) {
+	kind, path, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch kind {
+	case "", "memory":
+		return newMemoryTokenStore(), nil
+	case "file":
+		if path == "" {
+			return nil, errors.New("file token store needs a path, e.g. file:/var/lib/chatrelay/tokens.json")
+		}
+		return newFileTokenStore(path)
+	case "sqlite":
+		if path == "" {
+			return nil, errors.New("sqlite token store needs a path, e.g. sqlite:/var/lib/chatrelay/tokens.db")
+		}
+		return newSQLiteTokenStore(path)
+	case "redis", "rediss":
+		client, err := newRedisClient(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		return &redisTokenStore{client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown token store %q (use memory, file:/path, sqlite:/path or redis://host:port)", spec)
+}
+
+// workspaceClients resolves the Slack client for each team.
+type workspaceClients struct {
+	mu      sync.Mutex
+	store   TokenStore
+	clients map[string]SlackClient
+	// newClient builds a client for a bot token; set by Run.
+	newClient func(token string) SlackClient
+}
+
+func newWorkspaceClients(store TokenStore) *workspaceClients {
+	return &workspaceClients{store: store, clients: make(map[string]SlackClient)}
+}
+
+var workspaces = newWorkspaceClients(newMemoryTokenStore())
+
+func init() {
+	metricWorkspaces.Set("clients", expvar.Func(func() any {
+		workspaces.mu.Lock()
+		defer workspaces.mu.Unlock()
+		return len(workspaces.clients)
+	}))
+}
+
+// UseTokenStore replaces the token store, such as with a database-backed
+// one; installations already resolved are looked up again.
+func UseTokenStore(store TokenStore) {
+	workspaces.mu.Lock()
+	defer workspaces.mu.Unlock()
+	workspaces.store = store
+	workspaces.clients = make(map[string]SlackClient)
+}
+
+// connect sets how clients are built for installed workspaces.
+func (w *workspaceClients) connect(newClient func(token string) SlackClient) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.newClient = newClient
+	w.clients = make(map[string]SlackClient)
+}
+
+func (w *workspaceClients) tokenStore() TokenStore {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.store
+}
+
+// client returns the client for teamID, or fallback when the team has no
+// installation of its own.
+func (w *workspaceClients) client(ctx context.Context, teamID string, fallback SlackClient) SlackClient {
+	if teamID == "" {
+		return fallback
+	}
+	w.mu.Lock()
+	c, ok := w.clients[teamID]
+	store, newClient := w.store, w.newClient
+	w.mu.Unlock()
+	if ok {
+		return c
+	}
+	if newClient == nil {
+		return fallback
+	}
+	inst, found, err := store.Find(ctx, teamID)
+	if err != nil {
+		metricWorkspaces.Add("lookup_errors", 1)
+		slog.ErrorContext(ctx, "Failed to look up the workspace token", "workspace", teamID, "err", err)
+		return fallback
+	}
+	if !found {
+		return fallback
+	}
+	c = newClient(inst.BotToken)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.clients[teamID] = c
+	return c
+}
+
+// forget drops the cached client of teamID after it changed.
+func (w *workspaceClients) forget(teamID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.clients, teamID)
+}
+
+func (w *workspaceClients) install(ctx context.Context, inst Installation) error {
+	if err := w.tokenStore().Save(ctx, inst); err != nil {
+		return err
+	}
+	w.forget(inst.TeamID)
+	return nil
+}
+
+// uninstall removes teamID's installation after the app was uninstalled
+// or its tokens revoked.
+func (w *workspaceClients) uninstall(ctx context.Context, teamID, reason string) {
+	if err := w.tokenStore().Delete(ctx, teamID); err != nil {
+		slog.ErrorContext(ctx, "Failed to remove the workspace installation", "workspace", teamID, "err", err)
+		return
+	}
+	w.forget(teamID)
+	metricWorkspaces.Add("uninstalls", 1)
+	auditLog.record(ctx, AuditEntry{Actor: "slack", Action: "workspace_uninstall", Detail: teamID + ": " + reason})
+	slog.InfoContext(ctx, "Removed the workspace installation", "workspace", teamID, "reason", reason)
+}
+
+// oauthConfig holds the OAuth app credentials; an empty ClientID turns
+// the install endpoints off.
+type oauthConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scope        string
+}
+
+var oauth oauthConfig
+
+func configureWorkspaces() error {
+	store, err := tokenStoreFromSpec(os.Getenv("TOKEN_STORE"))
+	if err != nil {
+		return fmt.Errorf("invalid TOKEN_STORE: %w", err)
+	}
+	UseTokenStore(store)
+	oauth = oauthConfig{
+		ClientID:     os.Getenv("SLACK_CLIENT_ID"),
+		ClientSecret: os.Getenv("SLACK_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("SLACK_OAUTH_REDIRECT_URL"),
+		Scope:        os.Getenv("SLACK_OAUTH_SCOPES"),
+	}
+	if oauth.Scope == "" {
+		oauth.Scope = defaultSlackOAuthScope
+	}
+	if oauth.ClientID == "" && oauth.ClientSecret == "" {
+		return nil
+	}
+	if oauth.ClientID == "" || oauth.ClientSecret == "" {
+		return errors.New("SLACK_CLIENT_ID and SLACK_CLIENT_SECRET must be set together")
+	}
+	if u, err := url.Parse(oauth.RedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("SLACK_OAUTH_REDIRECT_URL must be the absolute URL of /slack/oauth_redirect, got %q", oauth.RedirectURL)
+	}
+	return nil
+}
+
+// newOAuthState returns a state value that expires after oauthStateTTL.
+func newOAuthState(now time.Time) string {
+	payload := strconv.FormatInt(now.Add(oauthStateTTL).Unix(), 10) + "." + newID()
+	return payload + "." + signOAuthState(payload)
+}
+
+func signOAuthState(payload string) string {
+	mac := hmac.New(sha256.New, []byte(oauth.ClientSecret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validOAuthState(state string, now time.Time) bool {
+	i := strings.LastIndex(state, ".")
+	if i < 0 || !hmac.Equal([]byte(state[i+1:]), []byte(signOAuthState(state[:i]))) {
+		return false
+	}
+	expiry, _, _ := strings.Cut(state[:i], ".")
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	return err == nil && now.Before(time.Unix(unix, 0))
+}
+
+func slackInstallHandler(w http.ResponseWriter, r *http.Request) {
+	if oauth.ClientID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	state := newOAuthState(time.Now())
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Value: state, Path: "/slack/", MaxAge: int(oauthStateTTL.Seconds()),
+		HttpOnly: true, Secure: strings.HasPrefix(oauth.RedirectURL, "https:"), SameSite: http.SameSiteLaxMode})
+	q := url.Values{"client_id": {oauth.ClientID}, "scope": {oauth.Scope}, "redirect_uri": {oauth.RedirectURL}, "state": {state}}
+	http.Redirect(w, r, slackAuthorizeURL+"?"+q.Encode(), http.StatusFound)
+}
+
+func slackOAuthRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	if oauth.ClientID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		installPage(w, http.StatusBadRequest, "The app was not installed: "+e+".")
+		return
+	}
+	cookie, err := r.Cookie(oauthStateCookie)
+	state := q.Get("state")
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 || !validOAuthState(state, time.Now()) {
+		metricWorkspaces.Add("install_failures", 1)
+		installPage(w, http.StatusBadRequest, "This install link has expired or was opened in another browser. Start again from the install page.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/slack/", MaxAge: -1})
+	ctx, cancel := context.WithTimeout(r.Context(), oauthTimeout)
+	defer cancel()
+	inst, err := exchangeOAuthCode(ctx, q.Get("code"))
+	if err == nil {
+		err = workspaces.install(ctx, inst)
+	}
+	if err != nil {
+		metricWorkspaces.Add("install_failures", 1)
+		slog.ErrorContext(ctx, "Failed to install into a workspace", "err", err)
+		installPage(w, http.StatusBadGateway, "The app could not be installed. Please try again.")
+		return
+	}
+	metricWorkspaces.Add("installs", 1)
+	auditLog.record(ctx, AuditEntry{Actor: inst.InstalledBy, Action: "workspace_install", Detail: inst.TeamID + " " + inst.TeamName})
+	slog.InfoContext(ctx, "Installed into workspace", "workspace", inst.TeamID, "name", inst.TeamName)
+	installPage(w, http.StatusOK, fmt.Sprintf("Installed into %s. You can close this window and mention the bot in Slack.", inst.TeamName))
+}
+
+func installPage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<!doctype html><title>chatrelaybot</title><p>%s</p>\n", html.EscapeString(message))
+}
+
+// exchangeOAuthCode trades an OAuth code for the workspace's bot token.
+func exchangeOAuthCode(ctx context.Context, code string) (Installation, error) {
+	if code == "" {
+		return Installation{}, errors.New("missing code")
+	}
+	form := url.Values{"client_id": {oauth.ClientID}, "client_secret": {oauth.ClientSecret}, "code": {code}, "redirect_uri": {oauth.RedirectURL}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.SlackAPIURL+"oauth.v2.access", strings.NewReader(form.Encode()))
+	if err != nil {
+		return Installation{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := slackHTTPClient(slackProxy).Do(req)
+	if err != nil {
+		return Installation{}, err
+	}
+	defer resp.Body.Close()
+	var out slack.OAuthV2Response
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Installation{}, fmt.Errorf("oauth.v2.access: %s: %w", resp.Status, err)
+	}
+	if !out.Ok {
+		return Installation{}, fmt.Errorf("oauth.v2.access: %s", out.Error)
+	}
+	if out.Team.ID == "" || !strings.HasPrefix(out.AccessToken, "xoxb-") {
+		return Installation{}, errors.New("oauth.v2.access returned no bot token")
+	}
+	return Installation{
+		TeamID: out.Team.ID, TeamName: out.Team.Name, EnterpriseID: out.Enterprise.ID, AppID: out.AppID,
+		BotUserID: out.BotUserID, BotToken: out.AccessToken, Scope: out.Scope, InstalledBy: out.AuthedUser.ID,
+		InstalledAt: time.Now().UTC(),
+	}, nil
+}
+
+// adminWorkspacesHandler lists installations without their tokens.
+func adminWorkspacesHandler(w http.ResponseWriter, r *http.Request) {
+	insts, err := workspaces.tokenStore().List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range insts {
+		insts[i].BotToken = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(insts)
+}
@@ -0,0 +1,178 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testOAuth is the app configuration the OAuth tests install with.
+var testOAuth = oauthConfig{ClientID: "123.456", ClientSecret: "shh", RedirectURL: "https://relay.example.com/slack/oauth_redirect", Scope: defaultSlackOAuthScope}
+
+func TestFileTokenStore_PersistsInstallations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := tokenStoreFromSpec("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	store.Save(ctx, Installation{TeamID: "T2", BotToken: "xoxb-2"})
+	store.Save(ctx, Installation{TeamID: "T1", BotToken: "xoxb-1"})
+	store.Delete(ctx, "T2")
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("token file: %v, %v", info, err)
+	}
+	reopened, err := tokenStoreFromSpec("file:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst, ok, _ := reopened.Find(ctx, "T1"); !ok || inst.BotToken != "xoxb-1" {
+		t.Errorf("T1 = %+v, %v", inst, ok)
+	}
+	if _, ok, _ := reopened.Find(ctx, "T2"); ok {
+		t.Error("deleted installation came back")
+	}
+	for _, spec := range []string{"file:", "sqlite:", "mongodb://localhost"} {
+		if _, err := tokenStoreFromSpec(spec); err == nil {
+			t.Errorf("tokenStoreFromSpec(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestOAuthState(t *testing.T) {
+	defer func(w *workspaceClients, o oauthConfig) { workspaces, oauth = w, o }(workspaces, oauth)
+	workspaces, oauth = newWorkspaceClients(newMemoryTokenStore()), testOAuth
+	now := time.Now()
+	state := newOAuthState(now)
+	if !validOAuthState(state, now) {
+		t.Fatal("fresh state rejected")
+	}
+	if validOAuthState(state, now.Add(oauthStateTTL+time.Second)) {
+		t.Error("expired state accepted")
+	}
+	forged := state[:len(state)-1] + "0"
+	if strings.HasSuffix(state, "0") {
+		forged = state[:len(state)-1] + "1"
+	}
+	if validOAuthState(forged, now) || validOAuthState("garbage", now) {
+		t.Error("forged state accepted")
+	}
+	oauth.ClientSecret = "rotated"
+	if validOAuthState(state, now) {
+		t.Error("state signed with another secret accepted")
+	}
+}
+
+func TestWorkspaceClients_ResolvesByTeam(t *testing.T) {
+	defer func(w *workspaceClients, o oauthConfig) { workspaces, oauth = w, o }(workspaces, oauth)
+	workspaces, oauth = newWorkspaceClients(newMemoryTokenStore()), testOAuth
+	ctx := context.Background()
+	fallback := &fakeSlackClient{}
+	if got := workspaces.client(ctx, "T1", fallback); got != fallback {
+		t.Fatal("unconnected relay did not use the default client")
+	}
+	var built []string
+	workspaces.connect(func(token string) SlackClient {
+		built = append(built, token)
+		return &fakeSlackClient{}
+	})
+	workspaces.install(ctx, Installation{TeamID: "T1", BotToken: "xoxb-t1"})
+	first := workspaces.client(ctx, "T1", fallback)
+	if first == fallback || workspaces.client(ctx, "T1", fallback) != first || len(built) != 1 {
+		t.Fatalf("T1 client not built once: %v", built)
+	}
+	if workspaces.client(ctx, "T2", fallback) != fallback || workspaces.client(ctx, "", fallback) != fallback {
+		t.Error("team without an installation did not use the default client")
+	}
+	workspaces.install(ctx, Installation{TeamID: "T1", BotToken: "xoxb-t1-new"})
+	if workspaces.client(ctx, "T1", fallback) == first || built[len(built)-1] != "xoxb-t1-new" {
+		t.Error("reinstall kept the old client")
+	}
+	workspaces.uninstall(ctx, "T1", "app uninstalled")
+	if workspaces.client(ctx, "T1", fallback) != fallback {
+		t.Error("uninstalled team still resolved")
+	}
+}
+
+func TestSlackOAuth_InstallFlow(t *testing.T) {
+	defer func(w *workspaceClients, o oauthConfig) { workspaces, oauth = w, o }(workspaces, oauth)
+	workspaces, oauth = newWorkspaceClients(newMemoryTokenStore()), testOAuth
+	defer func(u string) { config.SlackAPIURL = u }(config.SlackAPIURL)
+	slackAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path != "/oauth.v2.access" || r.Form.Get("code") != "good-code" || r.Form.Get("client_secret") != "shh" ||
+			r.Form.Get("redirect_uri") != oauth.RedirectURL {
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "invalid_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "access_token": "xoxb-acme", "token_type": "bot", "bot_user_id": "UBOT",
+			"team": map[string]string{"id": "TACME", "name": "Acme"}, "authed_user": map[string]string{"id": "UINSTALLER"}})
+	}))
+	defer slackAPI.Close()
+	config.SlackAPIURL = slackAPI.URL + "/"
+
+	rec := httptest.NewRecorder()
+	slackInstallHandler(rec, httptest.NewRequest(http.MethodGet, "/slack/install", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("install status = %d", rec.Code)
+	}
+	loc, _ := url.Parse(rec.Header().Get("Location"))
+	state := loc.Query().Get("state")
+	if !strings.HasPrefix(loc.String(), slackAuthorizeURL) || loc.Query().Get("client_id") != "123.456" || state == "" {
+		t.Fatalf("redirect = %s", loc)
+	}
+	cookies := rec.Result().Cookies()
+
+	redirect := func(query string, withCookie bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/slack/oauth_redirect?"+query, nil)
+		if withCookie {
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+		}
+		rec := httptest.NewRecorder()
+		slackOAuthRedirectHandler(rec, req)
+		return rec
+	}
+	if rec := redirect("code=good-code&state="+url.QueryEscape(state), false); rec.Code != http.StatusBadRequest {
+		t.Errorf("redirect without the state cookie = %d", rec.Code)
+	}
+	if rec := redirect("code=bad-code&state="+url.QueryEscape(state), true); rec.Code != http.StatusBadGateway {
+		t.Errorf("redirect with a rejected code = %d", rec.Code)
+	}
+	if rec := redirect("error=access_denied", true); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "access_denied") {
+		t.Errorf("cancelled install = %d %q", rec.Code, rec.Body.String())
+	}
+	rec = redirect("code=good-code&state="+url.QueryEscape(state), true)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Installed into Acme") {
+		t.Fatalf("install = %d %q", rec.Code, rec.Body.String())
+	}
+	inst, ok, _ := workspaces.tokenStore().Find(context.Background(), "TACME")
+	if !ok || inst.BotToken != "xoxb-acme" || inst.BotUserID != "UBOT" || inst.InstalledBy != "UINSTALLER" {
+		t.Errorf("installation = %+v, %v", inst, ok)
+	}
+
+	rec = httptest.NewRecorder()
+	adminWorkspacesHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/workspaces", nil))
+	if strings.Contains(rec.Body.String(), "xoxb-") || !strings.Contains(rec.Body.String(), "TACME") {
+		t.Errorf("admin listing = %s", rec.Body.String())
+	}
+}
+
+func TestSlackOAuth_DisabledWithoutClientID(t *testing.T) {
+	defer func(w *workspaceClients, o oauthConfig) { workspaces, oauth = w, o }(workspaces, oauth)
+	workspaces, oauth = newWorkspaceClients(newMemoryTokenStore()), testOAuth
+	oauth = oauthConfig{}
+	rec := httptest.NewRecorder()
+	slackInstallHandler(rec, httptest.NewRequest(http.MethodGet, "/slack/install", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("install without SLACK_CLIENT_ID = %d", rec.Code)
+	}
+}
@@ -0,0 +1,197 @@
+// Package config holds the relay's core settings: how it reaches Slack and
+// the backend, who administers it and how long work may take. Settings of
+// individual features are read by the features themselves in internal/bot.
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/slack-go/slack"
+)
+
+const (
+	DefaultPort    = "8080"
+	DefaultWorkers = 100
+	// DefaultMinWorkers is how many workers run while the relay is idle,
+	// unless WORKERS is lower.
+	DefaultMinWorkers = 10
+	// DefaultQueueBlockTimeout is how long a task waits for room in a full
+	// queue under the block overflow policy.
+	DefaultQueueBlockTimeout = 5 * time.Second
+	// DefaultWarmupTimeout is how long one warm-up request may take.
+	DefaultWarmupTimeout = 30 * time.Second
+)
+
+type Config struct {
+	SlackBotToken   string
+	SlackAppToken   string
+	SlackAPIURL     string
+	SlackFilesURL   string
+	SlackProxyURL   string
+	SlackRecordFile string
+	BackendURL      string
+	OtelEndpoint    string
+	Port            string
+	ChannelConfig   string
+	WarmupCount     int
+	WarmupQuery     string
+	WarmupTimeout   time.Duration
+	AdminUsers      []string
+	AdminAPIToken   string
+	// Workers is the most workers answering questions at once, and
+	// MinWorkers how many keep running while idle; the pool scales between
+	// them, retiring workers idle for WorkerIdleTimeout. Zero MinWorkers
+	// means DefaultMinWorkers.
+	Workers           int
+	MinWorkers        int
+	WorkerIdleTimeout time.Duration
+	// QueueSize bounds the tasks waiting for a worker, zero meaning twice
+	// Workers, and QueueOverflow is what a full queue does with another
+	// task, workerpool.Reject by default; under workerpool.Block it waits
+	// QueueBlockTimeout for room.
+	QueueSize         int
+	QueueOverflow     workerpool.Overflow
+	QueueBlockTimeout time.Duration
+	// BackendCompression is "gzip" or "zstd" to compress large backend
+	// request bodies.
+	BackendCompression string
+	// BackendSigningSecret signs backend requests; see
+	// internal/bot/signing.go.
+	BackendSigningSecret string
+	// TraceURLTemplate links !trace output to the tracing UI; "{trace_id}"
+	// is replaced with the trace ID.
+	TraceURLTemplate string
+	// StreamValidation is "off", "repair" or "strict"; see
+	// internal/bot/streamcheck.go.
+	StreamValidation string
+	// FallbackChunkSize caps the chunks non-streaming answers are posted in.
+	FallbackChunkSize int
+	// TaskTimeout bounds each queued task and DrainTimeout the wait for
+	// tasks at shutdown; see internal/bot/taskctx.go.
+	TaskTimeout  time.Duration
+	DrainTimeout time.Duration
+	// LeaderLeaseTTL is how long the scheduled-jobs lease lasts without
+	// renewal; see internal/bot/handover.go.
+	LeaderLeaseTTL time.Duration
+	// MaxInputChars is the longest query sent to the backend whole; see
+	// internal/bot/summarize.go.
+	MaxInputChars int
+	// SetupFile and AdminChannel come from the first-run wizard; see
+	// internal/bot/setup.go.
+	SetupFile    string
+	AdminChannel string
+	// Sources says where the settings came from, such as ".env" or a
+	// config file, for the startup report; see internal/bot/startupreport.go.
+	Sources []string
+}
+
+// FromEnv reads the core settings with getenv, usually os.Getenv.
+func FromEnv(getenv func(string) string) (Config, error) {
+	c := Config{
+		SlackBotToken:        getenv("SLACK_BOT_TOKEN"),
+		SlackAppToken:        getenv("SLACK_APP_TOKEN"),
+		SlackFilesURL:        getenv("SLACK_FILES_URL"),
+		SlackProxyURL:        getenv("SLACK_PROXY_URL"),
+		SlackRecordFile:      getenv("SLACK_RECORD_FILE"),
+		BackendURL:           getenv("BACKEND_URL"),
+		BackendCompression:   strings.ToLower(getenv("BACKEND_COMPRESSION")),
+		BackendSigningSecret: getenv("BACKEND_SIGNING_SECRET"),
+		StreamValidation:     strings.ToLower(getenv("STREAM_VALIDATION")),
+		OtelEndpoint:         getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		Port:                 getenv("PORT"),
+		ChannelConfig:        getenv("CHANNEL_CONFIG"),
+		AdminUsers:           strings.Fields(strings.ReplaceAll(getenv("ADMIN_USERS"), ",", " ")),
+		AdminAPIToken:        getenv("ADMIN_API_TOKEN"),
+		TraceURLTemplate:     getenv("TRACE_URL_TEMPLATE"),
+		WarmupQuery:          getenv("WARMUP_QUERY"),
+		AdminChannel:         getenv("ADMIN_CHANNEL"),
+		SetupFile:            getenv("SETUP_FILE"),
+		Workers:              DefaultWorkers,
+		QueueOverflow:        workerpool.Reject,
+		QueueBlockTimeout:    DefaultQueueBlockTimeout,
+		WarmupTimeout:        DefaultWarmupTimeout,
+	}
+	var err error
+	if c.SlackAPIURL, err = NormalizeSlackAPIURL(getenv("SLACK_API_URL")); err != nil {
+		return c, fmt.Errorf("invalid Slack API URL: %w", err)
+	}
+	if err := ValidateBackendCompression(c.BackendCompression); err != nil {
+		return c, err
+	}
+	if v := getenv("WORKERS"); v != "" {
+		if c.Workers, err = strconv.Atoi(v); err != nil {
+			return c, fmt.Errorf("invalid WORKERS %q: use a number such as %d", v, DefaultWorkers)
+		}
+	}
+	if v := getenv("WORKERS_MIN"); v != "" {
+		if c.MinWorkers, err = strconv.Atoi(v); err != nil {
+			return c, fmt.Errorf("invalid WORKERS_MIN %q: use a number such as %d", v, DefaultMinWorkers)
+		}
+	}
+	if v := getenv("QUEUE_SIZE"); v != "" {
+		if c.QueueSize, err = strconv.Atoi(v); err != nil {
+			return c, fmt.Errorf("invalid QUEUE_SIZE %q: use a number such as %d", v, 2*DefaultWorkers)
+		}
+	}
+	if v := getenv("QUEUE_OVERFLOW"); v != "" {
+		if c.QueueOverflow, err = workerpool.ParseOverflow(strings.ToLower(v)); err != nil {
+			return c, fmt.Errorf("invalid QUEUE_OVERFLOW: %w", err)
+		}
+	}
+	c.FallbackChunkSize, _ = strconv.Atoi(getenv("FALLBACK_CHUNK_SIZE"))
+	c.MaxInputChars, _ = strconv.Atoi(getenv("BACKEND_MAX_INPUT_CHARS"))
+	c.WarmupCount, _ = strconv.Atoi(getenv("WARMUP_REQUESTS"))
+	for _, d := range []struct {
+		name string
+		dst  *time.Duration
+	}{{"TASK_TIMEOUT", &c.TaskTimeout}, {"DRAIN_TIMEOUT", &c.DrainTimeout}, {"LEADER_LEASE_TTL", &c.LeaderLeaseTTL}, {"WORKER_IDLE_TIMEOUT", &c.WorkerIdleTimeout},
+		{"QUEUE_BLOCK_TIMEOUT", &c.QueueBlockTimeout}, {"WARMUP_TIMEOUT", &c.WarmupTimeout}} {
+		if v := getenv(d.name); v != "" {
+			if *d.dst, err = time.ParseDuration(v); err != nil || *d.dst <= 0 {
+				return c, fmt.Errorf("invalid %s %q: use a positive duration such as 90s", d.name, v)
+			}
+		}
+	}
+	if c.Port == "" {
+		c.Port = DefaultPort
+	}
+	if c.WarmupQuery == "" {
+		c.WarmupQuery = "ping"
+	}
+	return c, nil
+}
+
+// NormalizeSlackAPIURL checks SLACK_API_URL and gives it the trailing
+// "/api/" the Slack client expects; empty means slack.com.
+func NormalizeSlackAPIURL(raw string) (string, error) {
+	if raw == "" {
+		return slack.APIURL, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return "", fmt.Errorf("SLACK_API_URL must be an absolute http(s) URL, got %q", raw)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/api/"
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String(), nil
+}
+
+func ValidateBackendCompression(encoding string) error {
+	switch encoding {
+	case "", "gzip", "zstd":
+		return nil
+	}
+	return fmt.Errorf("unsupported BACKEND_COMPRESSION %q (use gzip or zstd)", encoding)
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+)
+
+func envOf(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestFromEnv(t *testing.T) {
+	c, err := FromEnv(envOf(map[string]string{
+		"SLACK_BOT_TOKEN":     "xoxb-1",
+		"SLACK_API_URL":       "https://slack-gov.com",
+		"ADMIN_USERS":         "U1, U2",
+		"TASK_TIMEOUT":        "90s",
+		"WORKERS_MIN":         "4",
+		"WORKER_IDLE_TIMEOUT": "1m",
+		"QUEUE_OVERFLOW":      "Drop_Oldest",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SlackBotToken != "xoxb-1" || c.SlackAPIURL != "https://slack-gov.com/api/" || len(c.AdminUsers) != 2 || c.TaskTimeout != 90*time.Second ||
+		c.MinWorkers != 4 || c.WorkerIdleTimeout != time.Minute || c.QueueOverflow != workerpool.DropOldest {
+		t.Errorf("config = %+v", c)
+	}
+	if c.Port != DefaultPort || c.Workers != DefaultWorkers || c.WarmupQuery != "ping" || c.QueueBlockTimeout != DefaultQueueBlockTimeout {
+		t.Errorf("defaults not applied: %+v", c)
+	}
+	if c, _ := FromEnv(envOf(map[string]string{})); c.QueueOverflow != workerpool.Reject {
+		t.Errorf("QUEUE_OVERFLOW defaults to %q", c.QueueOverflow)
+	}
+	for name, v := range map[string]string{"DRAIN_TIMEOUT": "soon", "BACKEND_COMPRESSION": "br", "SLACK_API_URL": "slack-gov.com", "WORKERS": "many", "WORKERS_MIN": "few",
+		"QUEUE_OVERFLOW": "spill", "QUEUE_BLOCK_TIMEOUT": "0s"} {
+		if _, err := FromEnv(envOf(map[string]string{name: v})); err == nil {
+			t.Errorf("%s=%q accepted", name, v)
+		}
+	}
+}
+
+func TestNormalizeSlackAPIURL(t *testing.T) {
+	cases := map[string]string{
+		"":                            "https://slack.com/api/",
+		"https://slack-gov.com":       "https://slack-gov.com/api/",
+		"https://slack-gov.com/api":   "https://slack-gov.com/api/",
+		"https://example.test/slack/": "https://example.test/slack/",
+	}
+	for in, want := range cases {
+		if got, err := NormalizeSlackAPIURL(in); err != nil || got != want {
+			t.Errorf("NormalizeSlackAPIURL(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := NormalizeSlackAPIURL("slack-gov.com"); err == nil {
+		t.Error("expected relative URL to be rejected")
+	}
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"gopkg.in/yaml.v3"
+)
+
+// Config files
+//
+// A config file holds the same settings as the environment, in YAML
+// (.yaml, .yml) or TOML (.toml). Nested keys are joined with "_" and
+// upper-cased, so slack.bot_token sets SLACK_BOT_TOKEN and a top-level
+// workers sets WORKERS. Lists become comma-separated values. Environment
+// variables override the file.
+
+var settingNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// ReadFile reads a config file into environment variable names and values.
+func ReadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("%s: unknown config format %q (use .yaml, .yml or .toml)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	settings := map[string]string{}
+	if err := flatten(settings, "", doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return settings, nil
+}
+
+func flatten(out map[string]string, prefix string, m map[string]any) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(k))
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		if !settingNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid key %q", k)
+		}
+		switch v := m[k].(type) {
+		case nil:
+		case map[string]any:
+			if err := flatten(out, name, v); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				s, ok := scalar(item)
+				if !ok {
+					return fmt.Errorf("%s: list items must be plain values", name)
+				}
+				items[i] = s
+			}
+			if err := set(out, name, strings.Join(items, ",")); err != nil {
+				return err
+			}
+		default:
+			s, ok := scalar(v)
+			if !ok {
+				return fmt.Errorf("%s: unsupported value %v", name, v)
+			}
+			if err := set(out, name, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func set(out map[string]string, name, value string) error {
+	if _, dup := out[name]; dup {
+		return fmt.Errorf("%s is set twice", name)
+	}
+	out[name] = value
+	return nil
+}
+
+func scalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case fmt.Stringer:
+		// TOML dates and times.
+		return v.String(), true
+	}
+	return "", false
+}
+
+// Overlay sets each setting the environment leaves unset, using lookup and
+// setenv (usually os.LookupEnv and os.Setenv), and returns how many it set.
+func Overlay(settings map[string]string, lookup func(string) (string, bool), setenv func(string, string) error) (int, error) {
+	n := 0
+	for name, value := range settings {
+		if _, ok := lookup(name); ok {
+			continue
+		}
+		if err := setenv(name, value); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Validate checks the settings every deployment needs and explains how to
+// fix each problem it finds.
+func (c Config) Validate() error {
+	var problems []string
+	for _, t := range []struct {
+		name, value, prefix, where string
+	}{
+		{"SLACK_BOT_TOKEN", c.SlackBotToken, "xoxb-", "the Bot User OAuth Token under OAuth & Permissions"},
+		{"SLACK_APP_TOKEN", c.SlackAppToken, "xapp-", "an app-level token with connections:write under Basic Information"},
+	} {
+		switch {
+		case t.value == "":
+			problems = append(problems, fmt.Sprintf("%s is not set: use %s", t.name, t.where))
+		case !strings.HasPrefix(t.value, t.prefix):
+			problems = append(problems, fmt.Sprintf("%s should start with %q: use %s", t.name, t.prefix, t.where))
+		}
+	}
+	if c.BackendURL != "" {
+		if u, err := url.Parse(c.BackendURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("BACKEND_URL must be an absolute http(s) URL such as http://localhost:9000/chat, got %q", c.BackendURL))
+		}
+	}
+	if p, err := strconv.Atoi(c.Port); err != nil || p <= 0 || p > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT must be a port number, got %q", c.Port))
+	}
+	if c.Workers <= 0 {
+		problems = append(problems, fmt.Sprintf("WORKERS must be positive, got %d", c.Workers))
+	}
+	if c.MinWorkers < 0 || c.MinWorkers > c.Workers {
+		problems = append(problems, fmt.Sprintf("WORKERS_MIN must be between 0 and WORKERS (%d), got %d", c.Workers, c.MinWorkers))
+	}
+	if c.QueueSize < 0 {
+		problems = append(problems, fmt.Sprintf("QUEUE_SIZE must not be negative, got %d", c.QueueSize))
+	}
+	if c.QueueOverflow != "" {
+		if _, err := workerpool.ParseOverflow(string(c.QueueOverflow)); err != nil {
+			problems = append(problems, fmt.Sprintf("QUEUE_OVERFLOW: %v", err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadFile_YAMLAndTOML(t *testing.T) {
+	want := map[string]string{
+		"SLACK_BOT_TOKEN":             "xoxb-file",
+		"BACKEND_URL":                 "http://localhost:9000/chat",
+		"WORKERS":                     "20",
+		"ADMIN_USERS":                 "U1,U2",
+		"STREAM_VALIDATION":           "repair",
+		"OTEL_EXPORTER_OTLP_INSECURE": "true",
+	}
+	files := map[string]string{
+		"relay.yaml": `
+slack:
+  bot_token: xoxb-file
+backend:
+  url: http://localhost:9000/chat
+workers: 20
+admin_users: [U1, U2]
+stream-validation: repair
+otel:
+  exporter:
+    otlp:
+      insecure: true
+`,
+		"relay.toml": `
+workers = 20
+admin_users = ["U1", "U2"]
+stream-validation = "repair"
+
+[slack]
+bot_token = "xoxb-file"
+
+[backend]
+url = "http://localhost:9000/chat"
+
+[otel.exporter.otlp]
+insecure = true
+`,
+	}
+	for name, content := range files {
+		got, err := ReadFile(writeConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got %v", name, got)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("%s: %s = %q, want %q", name, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestReadFile_Errors(t *testing.T) {
+	for name, content := range map[string]string{
+		"relay.json":     `{}`,
+		"broken.yaml":    "slack: [",
+		"broken.toml":    "workers = ",
+		"duplicate.yaml": "slack_bot_token: a\nslack:\n  bot_token: b\n",
+		"nested.yaml":    "admin_users:\n  - {id: U1}\n",
+		"badkey.yaml":    "\"1st\": x\n",
+	} {
+		if _, err := ReadFile(writeConfig(t, name, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestOverlay_EnvironmentWins(t *testing.T) {
+	env := map[string]string{"PORT": "9090"}
+	lookup := func(k string) (string, bool) { v, ok := env[k]; return v, ok }
+	setenv := func(k, v string) error { env[k] = v; return nil }
+	n, err := Overlay(map[string]string{"PORT": "8081", "WORKERS": "5"}, lookup, setenv)
+	if err != nil || n != 1 {
+		t.Fatalf("Overlay = %d, %v", n, err)
+	}
+	if env["PORT"] != "9090" || env["WORKERS"] != "5" {
+		t.Errorf("env = %v", env)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	good := Config{SlackBotToken: "xoxb-1", SlackAppToken: "xapp-1", BackendURL: "https://backend/chat", Port: "8080", Workers: 4}
+	if err := good.Validate(); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+	noBackend := good
+	noBackend.BackendURL = ""
+	if err := noBackend.Validate(); err != nil {
+		t.Errorf("an empty BACKEND_URL is left to the setup wizard, got %v", err)
+	}
+
+	bad := Config{SlackAppToken: "xoxb-wrong", BackendURL: "localhost:9000", Port: "http", Workers: 0, MinWorkers: 5}
+	err := bad.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"SLACK_BOT_TOKEN is not set", `SLACK_APP_TOKEN should start with "xapp-"`, "BACKEND_URL must be", "PORT must be", "WORKERS must be positive", "WORKERS_MIN must be between"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %q:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+// Package workerpool runs queued tasks on a bounded number of goroutines.
+package workerpool
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// laneShards is how many locks the keyed lanes are spread over.
+const laneShards = 16
+
+// DefaultIdleTimeout is how long a worker above the minimum waits for a
+// task before it exits.
+const DefaultIdleTimeout = 30 * time.Second
+
+// ErrQueueFull is returned for a task turned away because the queue is
+// full.
+var ErrQueueFull = errors.New("workerpool: queue full")
+
+// Overflow is what a pool does with a task submitted while its queue is
+// full.
+type Overflow string
+
+const (
+	// Block waits for room in the queue, for at most the block timeout
+	// when one is set.
+	Block Overflow = "block"
+	// Reject turns the new task away at once.
+	Reject Overflow = "reject"
+	// DropOldest discards the task that has waited longest in the queue
+	// to make room for the new one.
+	DropOldest Overflow = "drop_oldest"
+)
+
+// ParseOverflow checks an overflow policy name.
+func ParseOverflow(s string) (Overflow, error) {
+	switch o := Overflow(s); o {
+	case Block, Reject, DropOldest:
+		return o, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q (use block, reject or drop_oldest)", s)
+}
+
+// Pool runs submitted tasks on a set of workers. A task submitted while
+// every worker is busy starts another worker, up to the maximum; a worker
+// that has been idle for the idle timeout exits, down to the minimum.
+// Tasks wait for a worker in a bounded queue, and the overflow policy
+// decides what happens to a task submitted while it is full.
+//
+// Tasks submitted with SubmitKeyed run one at a time, in submission order,
+// for each key, while tasks with other keys run in parallel. A task whose
+// key is busy waits in its key's lane rather than in the queue, and the
+// worker that finishes a keyed task runs the next one in its lane.
+type Pool struct {
+	tasks    chan job
+	lanes    [laneShards]lane
+	wg       sync.WaitGroup
+	min      int
+	max      int
+	idle     time.Duration
+	overflow Overflow
+	timeout  time.Duration
+	workers  atomic.Int64
+	busy     atomic.Int64
+	// pending counts tasks from Submit until they finish.
+	pending atomic.Int64
+	// taskNanos is a moving average of recent task durations.
+	taskNanos atomic.Int64
+	// waiting counts keyed tasks held in a lane.
+	waiting atomic.Int64
+	// scaleUps and scaleDowns count workers started and retired.
+	scaleUps   atomic.Int64
+	scaleDowns atomic.Int64
+	// rejected and dropped count tasks turned away and discarded when the
+	// queue was full.
+	rejected atomic.Int64
+	dropped  atomic.Int64
+}
+
+// Options bound a pool that scales with its load.
+type Options struct {
+	// Min workers are always running; at least one.
+	Min int
+	// Max is the most workers that run at once; at least Min.
+	Max int
+	// IdleTimeout is how long a worker above Min waits for a task before
+	// it exits; 0 means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// QueueSize is how many tasks wait for a worker; 0 means twice Max.
+	QueueSize int
+	// Overflow is what happens to a task submitted while the queue is
+	// full; empty means Block.
+	Overflow Overflow
+	// BlockTimeout is how long Block waits for room before the task is
+	// turned away; 0 waits as long as it takes.
+	BlockTimeout time.Duration
+}
+
+// Task is a task with its key and an optional hook for when the pool
+// discards it.
+type Task struct {
+	// Key orders the task as in SubmitKeyed; empty means no order.
+	Key string
+	Run func()
+	// Dropped runs, on its own goroutine, instead of Run when the task is
+	// discarded: by DropOldest, or because the task ahead of it with the
+	// same key was turned away.
+	Dropped func()
+}
+
+type job struct {
+	key     string
+	task    func()
+	dropped func()
+}
+
+// lane holds, for each busy key in its shard, the tasks waiting behind the
+// running one.
+type lane struct {
+	mu      sync.Mutex
+	waiting map[string][]job
+}
+
+// Stats is a snapshot of the pool for /readyz and diagnostics.
+type Stats struct {
+	// Workers is the number of workers running now, between MinWorkers
+	// and MaxWorkers.
+	Workers       int `json:"workers"`
+	MinWorkers    int `json:"min_workers"`
+	MaxWorkers    int `json:"max_workers"`
+	Busy          int `json:"busy"`
+	QueueDepth    int `json:"queue_depth"`
+	QueueCapacity int `json:"queue_capacity"`
+	// Waiting is the number of keyed tasks held behind an earlier task
+	// with the same key; they are not in the queue.
+	Waiting int `json:"waiting"`
+	// ScaleUps and ScaleDowns count workers started under load and
+	// retired when idle.
+	ScaleUps   int64 `json:"scale_ups"`
+	ScaleDowns int64 `json:"scale_downs"`
+	// Overflow is the policy for a full queue; Rejected and Dropped count
+	// the tasks it turned away and discarded.
+	Overflow Overflow `json:"overflow"`
+	Rejected int64    `json:"rejected"`
+	Dropped  int64    `json:"dropped"`
+}
+
+// Utilization is the share of running workers that are busy.
+func (s Stats) Utilization() float64 {
+	if s.Workers == 0 {
+		return 0
+	}
+	return float64(s.Busy) / float64(s.Workers)
+}
+
+// New starts a pool of a fixed maxWorkers workers.
+func New(maxWorkers int) *Pool {
+	return NewScaling(Options{Min: maxWorkers, Max: maxWorkers})
+}
+
+// NewScaling starts a pool of opts.Min workers that grows to opts.Max
+// under load.
+func NewScaling(opts Options) *Pool {
+	opts.Min = max(opts.Min, 1)
+	opts.Max = max(opts.Max, opts.Min)
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = opts.Max * 2
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = Block
+	}
+	pool := &Pool{
+		tasks:    make(chan job, opts.QueueSize),
+		min:      opts.Min,
+		max:      opts.Max,
+		idle:     opts.IdleTimeout,
+		overflow: opts.Overflow,
+		timeout:  opts.BlockTimeout,
+	}
+	for i := range pool.lanes {
+		pool.lanes[i].waiting = make(map[string][]job)
+	}
+	pool.workers.Store(int64(opts.Min))
+	for i := 0; i < opts.Min; i++ {
+		pool.start()
+	}
+	return pool
+}
+
+func (p *Pool) start() {
+	p.wg.Add(1)
+	go p.worker()
+}
+
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	var timer *time.Timer
+	var idle <-chan time.Time
+	if p.min < p.max {
+		timer = time.NewTimer(p.idle)
+		defer timer.Stop()
+		idle = timer.C
+	}
+	for {
+		select {
+		case j, ok := <-p.tasks:
+			if !ok {
+				return
+			}
+			p.run(j)
+		case <-idle:
+			if p.retire() {
+				return
+			}
+		}
+		if timer != nil {
+			timer.Reset(p.idle)
+		}
+	}
+}
+
+func (p *Pool) run(j job) {
+	p.busy.Add(1)
+	for task := j.task; task != nil; task = p.next(j.key) {
+		started := time.Now()
+		task()
+		p.observe(time.Since(started))
+		p.pending.Add(-1)
+	}
+	p.busy.Add(-1)
+}
+
+// grow starts a worker when none is free for another task and the pool is
+// below its maximum.
+func (p *Pool) grow() {
+	for {
+		n := p.workers.Load()
+		if int(n) >= p.max || int(p.busy.Load())+len(p.tasks) < int(n) {
+			return
+		}
+		if p.workers.CompareAndSwap(n, n+1) {
+			p.scaleUps.Add(1)
+			p.start()
+			return
+		}
+	}
+}
+
+// retire reports whether an idle worker may exit, keeping the minimum.
+func (p *Pool) retire() bool {
+	for {
+		n := p.workers.Load()
+		if int(n) <= p.min {
+			return false
+		}
+		if p.workers.CompareAndSwap(n, n-1) {
+			p.scaleDowns.Add(1)
+			return true
+		}
+	}
+}
+
+func (p *Pool) lane(key string) *lane {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &p.lanes[h.Sum32()%laneShards]
+}
+
+// next takes the task waiting behind a finished one with the same key, or
+// frees the key.
+func (p *Pool) next(key string) func() {
+	if j, ok := p.nextJob(key); ok {
+		return j.task
+	}
+	return nil
+}
+
+func (p *Pool) nextJob(key string) (job, bool) {
+	if key == "" {
+		return job{}, false
+	}
+	l := p.lane(key)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	queue := l.waiting[key]
+	if len(queue) == 0 {
+		delete(l.waiting, key)
+		return job{}, false
+	}
+	l.waiting[key] = queue[1:]
+	p.waiting.Add(-1)
+	return queue[0], true
+}
+
+// discard drops j, which will not run, and the tasks waiting behind it
+// with the same key, freeing the key.
+func (p *Pool) discard(j job) {
+	for ok := true; ok; j, ok = p.nextJob(j.key) {
+		p.dropped.Add(1)
+		p.pending.Add(-1)
+		if j.dropped != nil {
+			go j.dropped()
+		}
+	}
+}
+
+// enqueue puts j in the queue, applying the overflow policy when it is
+// full.
+func (p *Pool) enqueue(j job) error {
+	p.grow()
+	select {
+	case p.tasks <- j:
+		return nil
+	default:
+	}
+	switch p.overflow {
+	case Reject:
+		return ErrQueueFull
+	case DropOldest:
+		for {
+			select {
+			case oldest := <-p.tasks:
+				p.discard(oldest)
+			default:
+			}
+			select {
+			case p.tasks <- j:
+				return nil
+			default:
+			}
+		}
+	}
+	if p.timeout <= 0 {
+		p.tasks <- j
+		return nil
+	}
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case p.tasks <- j:
+		return nil
+	case <-timer.C:
+		return ErrQueueFull
+	}
+}
+
+// turnAway undoes the submission of j, which the queue had no room for.
+func (p *Pool) turnAway(j job) {
+	p.rejected.Add(1)
+	p.pending.Add(-1)
+	// Tasks that queued behind j with its key are discarded with it.
+	if next, ok := p.nextJob(j.key); ok {
+		p.discard(next)
+	}
+}
+
+// observe folds a finished task's duration into the moving average, giving
+// the latest task a fifth of the weight.
+func (p *Pool) observe(d time.Duration) {
+	for {
+		old := p.taskNanos.Load()
+		next := int64(d)
+		if old > 0 {
+			next = old + (int64(d)-old)/5
+		}
+		if p.taskNanos.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// AverageTask returns the moving average of recent task durations, or 0
+// before any task has finished.
+func (p *Pool) AverageTask() time.Duration {
+	return time.Duration(p.taskNanos.Load())
+}
+
+// Saturated reports whether a new task would wait for a worker, even
+// after the pool grows to its maximum.
+func (p *Pool) Saturated() bool {
+	return int(p.busy.Load())+len(p.tasks) >= p.max
+}
+
+// Submit queues task. When the queue is full, the overflow policy either
+// waits for room, returns ErrQueueFull, or discards the oldest queued task.
+func (p *Pool) Submit(task func()) error {
+	_, err := p.SubmitTask(Task{Run: task})
+	return err
+}
+
+// SubmitKeyed runs task after every task submitted earlier with the same
+// key, and returns how many of those are still waiting or running. When
+// none are, task is queued like Submit. An empty key is the same as
+// Submit.
+func (p *Pool) SubmitKeyed(key string, task func()) (int, error) {
+	return p.SubmitTask(Task{Key: key, Run: task})
+}
+
+// SubmitTask queues t like SubmitKeyed, running t.Dropped if the pool
+// discards it later.
+func (p *Pool) SubmitTask(t Task) (int, error) {
+	p.pending.Add(1)
+	j := job{key: t.Key, task: t.Run, dropped: t.Dropped}
+	if j.key != "" {
+		l := p.lane(j.key)
+		l.mu.Lock()
+		if queue, busy := l.waiting[j.key]; busy {
+			l.waiting[j.key] = append(queue, j)
+			l.mu.Unlock()
+			p.waiting.Add(1)
+			// One task is running in addition to those waiting.
+			return len(queue) + 1, nil
+		}
+		l.waiting[j.key] = nil
+		l.mu.Unlock()
+	}
+	if err := p.enqueue(j); err != nil {
+		p.turnAway(j)
+		return 0, err
+	}
+	return 0, nil
+}
+
+// TrySubmit queues task unless the queue is full, reporting whether it did.
+func (p *Pool) TrySubmit(task func()) bool {
+	p.pending.Add(1)
+	p.grow()
+	select {
+	case p.tasks <- job{task: task}:
+		return true
+	default:
+		p.pending.Add(-1)
+		return false
+	}
+}
+
+// Pending returns the number of tasks queued or running.
+func (p *Pool) Pending() int {
+	return int(p.pending.Load())
+}
+
+// QueueDepth returns the number of tasks waiting for a worker.
+func (p *Pool) QueueDepth() int {
+	return len(p.tasks)
+}
+
+// Stats returns the pool's current size and load.
+func (p *Pool) Stats() Stats {
+	return Stats{Workers: int(p.workers.Load()), MinWorkers: p.min, MaxWorkers: p.max, Busy: int(p.busy.Load()),
+		QueueDepth: len(p.tasks), QueueCapacity: cap(p.tasks), Waiting: int(p.waiting.Load()),
+		ScaleUps: p.scaleUps.Load(), ScaleDowns: p.scaleDowns.Load(),
+		Overflow: p.overflow, Rejected: p.rejected.Load(), Dropped: p.dropped.Load()}
+}
+
+// Shutdown stops accepting tasks and waits for queued ones, and those
+// waiting behind them in a lane, to finish.
+func (p *Pool) Shutdown() {
+	close(p.tasks)
+	p.wg.Wait()
+}
@@ -0,0 +1,218 @@
+package workerpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_SubmitAndShutdown(t *testing.T) {
+	var count int32
+	pool := New(3)
+	for i := 0; i < 10; i++ {
+		pool.Submit(func() {
+			atomic.AddInt32(&count, 1)
+		})
+	}
+	pool.Shutdown()
+	if count != 10 {
+		t.Errorf("expected 10 tasks to run, got %d", count)
+	}
+}
+
+func TestWorkerPool_ConcurrentSubmit(t *testing.T) {
+	var count int32
+	pool := New(5)
+	for i := 0; i < 50; i++ {
+		pool.Submit(func() {
+			time.Sleep(2 * time.Millisecond)
+			atomic.AddInt32(&count, 1)
+		})
+	}
+	pool.Shutdown()
+	if count != 50 {
+		t.Errorf("expected 50 tasks to run, got %d", count)
+	}
+}
+
+func TestPool_AverageTask(t *testing.T) {
+	pool := New(1)
+	defer pool.Shutdown()
+	pool.observe(10 * time.Second)
+	pool.observe(20 * time.Second)
+	if got := pool.AverageTask(); got != 12*time.Second {
+		t.Errorf("moving average = %s, want 12s", got)
+	}
+}
+
+func TestPool_SubmitKeyedRunsInOrder(t *testing.T) {
+	pool := New(4)
+	release := make(chan struct{})
+	var (
+		mu    sync.Mutex
+		order []int
+	)
+	record := func(i int) func() {
+		return func() {
+			if i == 0 {
+				<-release
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}
+	}
+
+	var ahead []int
+	for i := 0; i < 3; i++ {
+		n, _ := pool.SubmitKeyed("C1/1.0", record(i))
+		ahead = append(ahead, n)
+	}
+	if other, _ := pool.SubmitKeyed("C1/2.0", func() {}); other != 0 {
+		t.Errorf("another key should not wait, got %d ahead", other)
+	}
+	if st := pool.Stats(); st.Waiting != 2 {
+		t.Errorf("waiting = %d, want 2", st.Waiting)
+	}
+	close(release)
+	pool.Shutdown()
+
+	if ahead[0] != 0 || ahead[1] != 1 || ahead[2] != 2 {
+		t.Errorf("ahead = %v, want [0 1 2]", ahead)
+	}
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Errorf("order = %v, want [0 1 2]", order)
+	}
+	if pool.Pending() != 0 || pool.Stats().Waiting != 0 {
+		t.Errorf("pending = %d, waiting = %d after shutdown", pool.Pending(), pool.Stats().Waiting)
+	}
+	for i := range pool.lanes {
+		if len(pool.lanes[i].waiting) != 0 {
+			t.Errorf("lanes not cleaned up: %v", pool.lanes[i].waiting)
+		}
+	}
+}
+
+func TestPool_ScalesWithLoad(t *testing.T) {
+	pool := NewScaling(Options{Min: 1, Max: 3, IdleTimeout: 10 * time.Millisecond})
+	defer pool.Shutdown()
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(3)
+	for i := 0; i < 3; i++ {
+		pool.Submit(func() {
+			started.Done()
+			<-release
+		})
+	}
+	// Three blocking tasks only all start if the pool grew to three.
+	started.Wait()
+	if st := pool.Stats(); st.Workers != 3 || st.ScaleUps != 2 || st.Utilization() != 1 {
+		t.Errorf("under load: %+v", st)
+	}
+	pool.Submit(func() {})
+	if !pool.Saturated() {
+		t.Error("pool at its maximum with a queued task is not saturated")
+	}
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.Stats().Workers > 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if st := pool.Stats(); st.Workers != 1 || st.ScaleDowns != 2 || st.MinWorkers != 1 || st.MaxWorkers != 3 {
+		t.Errorf("after idling: %+v", st)
+	}
+}
+
+func TestPool_Overflow(t *testing.T) {
+	for _, tc := range []struct {
+		overflow Overflow
+		timeout  time.Duration
+		// ran and dropped are which of the three queued tasks run and are
+		// discarded once the fourth is submitted.
+		ran, dropped string
+		rejected     int64
+	}{
+		{Reject, 0, "123", "", 1},
+		{Block, 10 * time.Millisecond, "123", "", 1},
+		{DropOldest, 0, "234", "1", 0},
+	} {
+		pool := NewScaling(Options{Min: 1, Max: 1, QueueSize: 3, Overflow: tc.overflow, BlockTimeout: tc.timeout})
+		release := make(chan struct{})
+		started := make(chan struct{})
+		pool.Submit(func() {
+			close(started)
+			<-release
+		})
+		<-started
+		var (
+			mu           sync.Mutex
+			ran, dropped string
+			wg           sync.WaitGroup
+		)
+		task := func(id string) Task {
+			return Task{
+				Run: func() {
+					mu.Lock()
+					ran += id
+					mu.Unlock()
+				},
+				Dropped: func() {
+					mu.Lock()
+					dropped += id
+					mu.Unlock()
+					wg.Done()
+				},
+			}
+		}
+		for _, id := range []string{"1", "2", "3"} {
+			if _, err := pool.SubmitTask(task(id)); err != nil {
+				t.Fatalf("%s: task %s turned away with room in the queue: %v", tc.overflow, id, err)
+			}
+		}
+		wg.Add(len(tc.dropped))
+		_, err := pool.SubmitTask(task("4"))
+		if (err != nil) != (tc.rejected > 0) {
+			t.Errorf("%s: fourth task error = %v", tc.overflow, err)
+		}
+		wg.Wait()
+		close(release)
+		pool.Shutdown()
+		st := pool.Stats()
+		if ran != tc.ran || dropped != tc.dropped || st.Rejected != tc.rejected || st.Overflow != tc.overflow {
+			t.Errorf("%s: ran %q, dropped %q, stats %+v", tc.overflow, ran, dropped, st)
+		}
+		if pool.Pending() != 0 {
+			t.Errorf("%s: %d tasks still pending", tc.overflow, pool.Pending())
+		}
+	}
+}
+
+func TestPool_TurnedAwayKeyFreesItsLane(t *testing.T) {
+	pool := NewScaling(Options{Min: 1, Max: 1, QueueSize: 1, Overflow: Reject})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pool.Submit(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	pool.Submit(func() {})
+	if _, err := pool.SubmitKeyed("C1/1.0", func() {}); err != ErrQueueFull {
+		t.Fatalf("keyed task into a full queue: %v", err)
+	}
+	close(release)
+	var ran atomic.Bool
+	for pool.QueueDepth() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := pool.SubmitKeyed("C1/1.0", func() { ran.Store(true) }); err != nil {
+		t.Fatal(err)
+	}
+	pool.Shutdown()
+	if !ran.Load() {
+		t.Error("key still held by a task that was turned away")
+	}
+}
@@ -0,0 +1,216 @@
+// Package relay embeds the Slack relay in another program. Settings come from
+// the environment, as for the chatrelaybot binary, and options override them:
+//
+//	r, err := relay.New(relay.WithBackendURL("http://localhost:9000/chat"))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	err = r.Run(ctx)
+//
+// The relay keeps process-wide state (metrics, HTTP handlers, logging), so a
+// process runs at most one.
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"github.com/heykvr/chatrelaybot/internal/bot"
+	"github.com/heykvr/chatrelaybot/internal/config"
+	"github.com/heykvr/chatrelaybot/internal/workerpool"
+	"github.com/joho/godotenv"
+)
+
+// Relay connects to Slack and answers questions from the backend.
+type Relay struct {
+	cfg config.Config
+}
+
+type options struct {
+	skipDotEnv bool
+	configFile string
+	tokenStore TokenStore
+	dedupStore EventDedupStore
+	apply      []func(*config.Config)
+}
+
+// Option overrides a setting otherwise read from the environment.
+type Option func(*options)
+
+func set(f func(*config.Config)) Option {
+	return func(o *options) { o.apply = append(o.apply, f) }
+}
+
+// WithSlackTokens sets the bot (xoxb-) and app-level (xapp-) tokens.
+func WithSlackTokens(botToken, appToken string) Option {
+	return set(func(c *config.Config) {
+		c.SlackBotToken = botToken
+		c.SlackAppToken = appToken
+	})
+}
+
+// WithBackendURL sets the backend chat endpoint.
+func WithBackendURL(url string) Option {
+	return set(func(c *config.Config) { c.BackendURL = url })
+}
+
+// WithPort sets the port of the health, metrics and admin endpoints.
+func WithPort(port string) Option {
+	return set(func(c *config.Config) { c.Port = port })
+}
+
+// WithWorkers sets the most questions answered at once.
+func WithWorkers(n int) Option {
+	return set(func(c *config.Config) { c.Workers = n })
+}
+
+// WithMinWorkers sets how many workers keep running while the relay is
+// idle; the pool grows to the WithWorkers maximum under load.
+func WithMinWorkers(n int) Option {
+	return set(func(c *config.Config) { c.MinWorkers = n })
+}
+
+// QueueOverflow is what a full worker queue does with another question;
+// see WithQueue.
+type QueueOverflow = workerpool.Overflow
+
+const (
+	// QueueBlock waits up to QUEUE_BLOCK_TIMEOUT for room, then turns the
+	// question away.
+	QueueBlock = workerpool.Block
+	// QueueReject turns the question away at once.
+	QueueReject = workerpool.Reject
+	// QueueDropOldest discards the question that has waited longest.
+	QueueDropOldest = workerpool.DropOldest
+)
+
+// WithQueue sets how many questions wait for a worker, 0 meaning twice
+// the workers, and what happens to another one while that many wait.
+// Askers of questions turned away or discarded are told the relay is busy.
+func WithQueue(size int, overflow QueueOverflow) Option {
+	return set(func(c *config.Config) {
+		c.QueueSize = size
+		c.QueueOverflow = overflow
+	})
+}
+
+// WithAdminUsers sets the Slack user IDs allowed to run admin commands.
+func WithAdminUsers(ids ...string) Option {
+	return set(func(c *config.Config) { c.AdminUsers = ids })
+}
+
+// WithoutDotEnv skips loading a .env file from the working directory.
+func WithoutDotEnv() Option {
+	return func(o *options) { o.skipDotEnv = true }
+}
+
+// WithConfigFile reads settings from a YAML or TOML file; environment
+// variables override it. An empty path reads no file.
+func WithConfigFile(path string) Option {
+	return func(o *options) { o.configFile = path }
+}
+
+// TokenStore keeps the bot token of each workspace the relay is installed
+// into; see WithTokenStore.
+type TokenStore = bot.TokenStore
+
+// Installation is the relay's bot token in one workspace.
+type Installation = bot.Installation
+
+// WithTokenStore keeps workspace installations in store, such as a
+// database, instead of the store named by TOKEN_STORE.
+func WithTokenStore(store TokenStore) Option {
+	return func(o *options) { o.tokenStore = store }
+}
+
+// EventDedupStore remembers which Slack events the relay has already
+// accepted; see WithEventDedupStore.
+type EventDedupStore = bot.EventDedupStore
+
+// WithEventDedupStore checks events for duplicates against store, such as
+// one in Redis shared by every replica, instead of an in-memory cache.
+func WithEventDedupStore(store EventDedupStore) Option {
+	return func(o *options) { o.dedupStore = store }
+}
+
+var created atomic.Bool
+
+// New reads the settings, applies opts and prepares the relay's features.
+func New(opts ...Option) (*Relay, error) {
+	if !created.CompareAndSwap(false, true) {
+		return nil, errors.New("relay: only one relay may run per process")
+	}
+	cfg, envErr, err := load(opts)
+	if err == nil {
+		err = bot.Configure(cfg)
+	}
+	if o := collect(opts); err == nil {
+		if o.tokenStore != nil {
+			bot.UseTokenStore(o.tokenStore)
+		}
+		if o.dedupStore != nil {
+			bot.UseEventDedupStore(o.dedupStore)
+		}
+	}
+	if err != nil {
+		created.Store(false)
+		return nil, err
+	}
+	if envErr != nil {
+		slog.Warn(fmt.Sprintf("No .env file loaded: %v", envErr))
+	}
+	return &Relay{cfg: cfg}, nil
+}
+
+func collect(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// load returns the settings after opts, and why no .env file was loaded.
+func load(opts []Option) (cfg config.Config, envErr, err error) {
+	o := collect(opts)
+	if !o.skipDotEnv {
+		envErr = godotenv.Load()
+	}
+	if o.configFile != "" {
+		settings, err := config.ReadFile(o.configFile)
+		if err != nil {
+			return cfg, envErr, fmt.Errorf("failed to read config file: %w", err)
+		}
+		// Features read their settings from the environment, so the file
+		// fills in whatever the environment leaves unset.
+		if _, err := config.Overlay(settings, os.LookupEnv, os.Setenv); err != nil {
+			return cfg, envErr, err
+		}
+	}
+	if cfg, err = config.FromEnv(os.Getenv); err != nil {
+		return cfg, envErr, err
+	}
+	if envErr == nil && !o.skipDotEnv {
+		cfg.Sources = append(cfg.Sources, ".env")
+	}
+	if o.configFile != "" {
+		cfg.Sources = append(cfg.Sources, "config file "+o.configFile)
+	}
+	cfg.Sources = append(cfg.Sources, "environment")
+	for _, apply := range o.apply {
+		apply(&cfg)
+	}
+	if len(o.apply) > 0 {
+		cfg.Sources = append(cfg.Sources, "relay options")
+	}
+	return cfg, envErr, cfg.Validate()
+}
+
+// Run answers questions until ctx is done or a subsystem fails.
+func (r *Relay) Run(ctx context.Context) error {
+	return bot.Run(ctx)
+}
@@ -0,0 +1,101 @@
+package relay
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/heykvr/chatrelaybot/internal/config"
+)
+
+func TestLoad_OptionsOverrideEnvironment(t *testing.T) {
+	t.Setenv("SLACK_BOT_TOKEN", "xoxb-env")
+	t.Setenv("BACKEND_URL", "http://env/chat")
+	t.Setenv("PORT", "9999")
+
+	cfg, envErr, err := load([]Option{
+		WithoutDotEnv(),
+		WithSlackTokens("xoxb-opt", "xapp-opt"),
+		WithWorkers(7),
+		WithAdminUsers("U1", "U2"),
+	})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if envErr != nil {
+		t.Errorf("envErr = %v, want nil when .env is skipped", envErr)
+	}
+	if cfg.SlackBotToken != "xoxb-opt" || cfg.SlackAppToken != "xapp-opt" {
+		t.Errorf("tokens = %q, %q", cfg.SlackBotToken, cfg.SlackAppToken)
+	}
+	if cfg.BackendURL != "http://env/chat" || cfg.Port != "9999" {
+		t.Errorf("unset options should keep the environment, got %q %q", cfg.BackendURL, cfg.Port)
+	}
+	if cfg.Workers != 7 {
+		t.Errorf("Workers = %d, want 7", cfg.Workers)
+	}
+	if !reflect.DeepEqual(cfg.AdminUsers, []string{"U1", "U2"}) {
+		t.Errorf("AdminUsers = %v", cfg.AdminUsers)
+	}
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, _, err := load([]Option{WithoutDotEnv(), WithSlackTokens("xoxb-1", "xapp-1"), WithBackendURL("http://b/chat")})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Workers != config.DefaultWorkers {
+		t.Errorf("Workers = %d, want %d", cfg.Workers, config.DefaultWorkers)
+	}
+	if cfg.BackendURL != "http://b/chat" {
+		t.Errorf("BackendURL = %q", cfg.BackendURL)
+	}
+}
+
+func TestLoad_RejectsBadWorkers(t *testing.T) {
+	if _, _, err := load([]Option{WithoutDotEnv(), WithSlackTokens("xoxb-1", "xapp-1"), WithWorkers(0)}); err == nil {
+		t.Error("expected an error for zero workers")
+	}
+}
+
+func TestLoad_ConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.yaml")
+	os.WriteFile(path, []byte("slack:\n  bot_token: xoxb-file\n  app_token: xapp-file\nbackend:\n  url: http://file/chat\nworkers: 12\n"), 0o600)
+	t.Setenv("BACKEND_URL", "http://env/chat")
+	for _, name := range []string{"SLACK_BOT_TOKEN", "SLACK_APP_TOKEN", "WORKERS"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	cfg, _, err := load([]Option{WithoutDotEnv(), WithConfigFile(path), WithWorkers(3)})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.SlackBotToken != "xoxb-file" || cfg.SlackAppToken != "xapp-file" {
+		t.Errorf("tokens from the file = %q, %q", cfg.SlackBotToken, cfg.SlackAppToken)
+	}
+	if cfg.BackendURL != "http://env/chat" {
+		t.Errorf("BACKEND_URL = %q, want the environment to win", cfg.BackendURL)
+	}
+	if cfg.Workers != 3 {
+		t.Errorf("Workers = %d, want the option to win", cfg.Workers)
+	}
+}
+
+func TestLoad_MissingTokens(t *testing.T) {
+	t.Setenv("SLACK_BOT_TOKEN", "")
+	t.Setenv("SLACK_APP_TOKEN", "")
+	_, _, err := load([]Option{WithoutDotEnv()})
+	if err == nil || !strings.Contains(err.Error(), "SLACK_BOT_TOKEN is not set") || !strings.Contains(err.Error(), "SLACK_APP_TOKEN is not set") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestLoad_InvalidEnvironment(t *testing.T) {
+	t.Setenv("BACKEND_COMPRESSION", "brotli")
+	if _, _, err := load([]Option{WithoutDotEnv()}); err == nil {
+		t.Error("expected the environment error to be returned")
+	}
+}
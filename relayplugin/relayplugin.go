@@ -0,0 +1,90 @@
+// Package relayplugin connects the relay and its plugins. Plugins are
+// separate executables that the relay starts with hashicorp/go-plugin and
+// calls over gRPC; the service is defined in pluginpb/plugin.proto. A plugin
+// implements pluginpb.PluginServer, embedding
+// pluginpb.UnimplementedPluginServer for the stages it does not provide, and
+// calls Serve from main. The relay starts plugins with Start. See
+// examples/plugins/uppercase for a complete plugin.
+package relayplugin
+
+//go:generate protoc -I pluginpb --go_out=pluginpb --go_opt=paths=source_relative --go-grpc_out=pluginpb --go-grpc_opt=paths=source_relative plugin.proto
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/heykvr/chatrelaybot/relayplugin/pluginpb"
+	"google.golang.org/grpc"
+)
+
+// Name is the name the plugin is dispensed under.
+const Name = "relay"
+
+// Handshake keeps the relay from starting executables that are not relay
+// plugins, and plugins from being run by hand. ProtocolVersion changes
+// when the service does incompatibly.
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "CHATRELAYBOT_PLUGIN",
+	MagicCookieValue: "b7e3c1f09a4d42e6a85d0c2f6e91b3d4",
+}
+
+// GRPCPlugin serves Impl in a plugin, and gives the relay a
+// pluginpb.PluginClient for it.
+type GRPCPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	Impl pluginpb.PluginServer
+}
+
+func (p *GRPCPlugin) GRPCServer(_ *plugin.GRPCBroker, s *grpc.Server) error {
+	pluginpb.RegisterPluginServer(s, p.Impl)
+	return nil
+}
+
+func (p *GRPCPlugin) GRPCClient(_ context.Context, _ *plugin.GRPCBroker, c *grpc.ClientConn) (any, error) {
+	return pluginpb.NewPluginClient(c), nil
+}
+
+// Serve runs impl as a plugin until the relay stops it. It is called from
+// the plugin's main.
+func Serve(impl pluginpb.PluginServer) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         plugin.PluginSet{Name: &GRPCPlugin{Impl: impl}},
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+}
+
+// Start launches the plugin executable at path and connects to it. What
+// the plugin writes to stderr is copied to stderr, as are go-plugin's
+// warnings about it. The returned close stops the plugin.
+func Start(path string, stderr io.Writer) (pluginpb.PluginClient, func(), error) {
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  Handshake,
+		Plugins:          plugin.PluginSet{Name: &GRPCPlugin{}},
+		Cmd:              exec.Command(path),
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		SyncStderr:       stderr,
+		Logger:           hclog.New(&hclog.LoggerOptions{Name: "plugin", Output: stderr, Level: hclog.Warn}),
+	})
+	conn, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return nil, nil, err
+	}
+	raw, err := conn.Dispense(Name)
+	if err != nil {
+		client.Kill()
+		return nil, nil, err
+	}
+	impl, ok := raw.(pluginpb.PluginClient)
+	if !ok {
+		client.Kill()
+		return nil, nil, fmt.Errorf("plugin dispensed %T, not a plugin client", raw)
+	}
+	return impl, client.Kill, nil
+}
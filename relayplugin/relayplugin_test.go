@@ -0,0 +1,84 @@
+package relayplugin
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heykvr/chatrelaybot/relayplugin/pluginpb"
+)
+
+type echoPlugin struct {
+	pluginpb.UnimplementedPluginServer
+}
+
+func (echoPlugin) Describe(context.Context, *pluginpb.DescribeRequest) (*pluginpb.Manifest, error) {
+	os.Stderr.WriteString("describing\n")
+	return &pluginpb.Manifest{Name: "echo", PostProcessor: true}, nil
+}
+
+func (echoPlugin) PostProcess(_ context.Context, req *pluginpb.PostProcessRequest) (*pluginpb.PostProcessReply, error) {
+	return &pluginpb.PostProcessReply{Text: req.Channel + ": " + req.Text}, nil
+}
+
+// TestMain lets the test binary double as a plugin: Start runs it again
+// with the handshake cookie set.
+func TestMain(m *testing.M) {
+	if os.Getenv(Handshake.MagicCookieKey) == Handshake.MagicCookieValue {
+		Serve(echoPlugin{})
+		return
+	}
+	os.Exit(m.Run())
+}
+
+type lines struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (l *lines) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.got = append(l.got, string(p))
+	return len(p), nil
+}
+
+func (l *lines) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Contains(strings.Join(l.got, ""), s)
+}
+
+func TestStart_ServesOverGRPC(t *testing.T) {
+	stderr := &lines{}
+	client, stop, err := Start(os.Args[0], stderr)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer stop()
+
+	ctx := context.Background()
+	m, err := client.Describe(ctx, &pluginpb.DescribeRequest{})
+	if err != nil || m.Name != "echo" || !m.PostProcessor {
+		t.Fatalf("Describe = %v, %v", m, err)
+	}
+	reply, err := client.PostProcess(ctx, &pluginpb.PostProcessRequest{Text: "hi", Channel: "C1"})
+	if err != nil || reply.Text != "C1: hi" {
+		t.Errorf("PostProcess = %v, %v", reply, err)
+	}
+	if _, err := client.Retrieve(ctx, &pluginpb.RetrieveRequest{Query: "q"}); err == nil {
+		t.Error("expected Unimplemented for a stage the plugin does not provide")
+	}
+
+	// Plugin stderr is streamed back asynchronously.
+	deadline := time.Now().Add(5 * time.Second)
+	for !stderr.contains("describing") {
+		if time.Now().After(deadline) {
+			t.Fatal("plugin stderr not passed on")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,238 @@
+// Package slackfetch reads paginated Slack data (channel history, thread
+// replies, the user list) with cursor handling, Retry-After aware retries,
+// optional request spacing, a short-lived cache and expvar metrics. Context
+// features should read Slack through a Fetcher rather than calling the API
+// directly.
+package slackfetch
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	DefaultPageSize   = 200
+	DefaultMaxRetries = 5
+	DefaultCacheTTL   = 5 * time.Minute
+)
+
+// API is the subset of *slack.Client the fetcher needs.
+type API interface {
+	GetConversationHistoryContext(ctx context.Context, params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error)
+	GetConversationRepliesContext(ctx context.Context, params *slack.GetConversationRepliesParameters) ([]slack.Message, bool, string, error)
+	GetUsersPaginated(options ...slack.GetUsersOption) slack.UserPagination
+}
+
+type Options struct {
+	PageSize   int
+	MaxRetries int
+	// CacheTTL is how long results are reused; negative disables caching.
+	CacheTTL time.Duration
+	// MinInterval spaces consecutive calls to the same Slack method.
+	MinInterval time.Duration
+}
+
+// Metrics are published under "slackfetch": "<method>.requests", ".errors"
+// and ".rate_limited" per Slack method, plus "cache_hits".
+var metrics = expvar.NewMap("slackfetch")
+
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+type Fetcher struct {
+	api  API
+	opts Options
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+	last  map[string]time.Time
+}
+
+func New(api API, opts Options) *Fetcher {
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = DefaultCacheTTL
+	}
+	return &Fetcher{api: api, opts: opts, cache: make(map[string]cacheEntry), last: make(map[string]time.Time)}
+}
+
+// History returns up to max messages (newest first) posted in channel since
+// oldest; a zero oldest reads from the beginning and max <= 0 means no limit.
+func (f *Fetcher) History(ctx context.Context, channel string, oldest time.Time, max int) ([]slack.Message, error) {
+	params := &slack.GetConversationHistoryParameters{ChannelID: channel, Limit: f.opts.PageSize}
+	if !oldest.IsZero() {
+		params.Oldest = formatTS(oldest)
+	}
+	key := fmt.Sprintf("history/%s/%s/%d", channel, params.Oldest, max)
+	return cached(f, key, func() ([]slack.Message, error) {
+		return collect(ctx, f, "conversations.history", max, func(ctx context.Context) ([]slack.Message, bool, error) {
+			resp, err := f.api.GetConversationHistoryContext(ctx, params)
+			if err != nil {
+				return nil, false, err
+			}
+			params.Cursor = resp.ResponseMetaData.NextCursor
+			return resp.Messages, resp.HasMore && params.Cursor != "", nil
+		})
+	})
+}
+
+// Replies returns up to max messages of a thread, parent first.
+func (f *Fetcher) Replies(ctx context.Context, channel, threadTS string, max int) ([]slack.Message, error) {
+	params := &slack.GetConversationRepliesParameters{ChannelID: channel, Timestamp: threadTS, Limit: f.opts.PageSize}
+	key := fmt.Sprintf("replies/%s/%s/%d", channel, threadTS, max)
+	return cached(f, key, func() ([]slack.Message, error) {
+		return collect(ctx, f, "conversations.replies", max, func(ctx context.Context) ([]slack.Message, bool, error) {
+			msgs, hasMore, next, err := f.api.GetConversationRepliesContext(ctx, params)
+			if err != nil {
+				return nil, false, err
+			}
+			params.Cursor = next
+			return msgs, hasMore && next != "", nil
+		})
+	})
+}
+
+// Users returns every user in the workspace.
+func (f *Fetcher) Users(ctx context.Context) ([]slack.User, error) {
+	return cached(f, "users", func() ([]slack.User, error) {
+		p := f.api.GetUsersPaginated(slack.GetUsersOptionLimit(f.opts.PageSize))
+		return collect(ctx, f, "users.list", 0, func(ctx context.Context) ([]slack.User, bool, error) {
+			next, err := p.Next(ctx)
+			if p.Done(err) {
+				return nil, false, nil
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			p = next
+			// users.list only reports the end on the following call.
+			return next.Users, true, nil
+		})
+	})
+}
+
+// Invalidate drops all cached results.
+func (f *Fetcher) Invalidate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cache = make(map[string]cacheEntry)
+}
+
+// InvalidateThread drops cached replies of one thread, for callers that
+// know it has changed.
+func (f *Fetcher) InvalidateThread(channel, threadTS string) {
+	prefix := fmt.Sprintf("replies/%s/%s/", channel, threadTS)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key := range f.cache {
+		if strings.HasPrefix(key, prefix) {
+			delete(f.cache, key)
+		}
+	}
+}
+
+func cached[T any](f *Fetcher, key string, load func() ([]T, error)) ([]T, error) {
+	if f.opts.CacheTTL > 0 {
+		f.mu.Lock()
+		entry, ok := f.cache[key]
+		f.mu.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			metrics.Add("cache_hits", 1)
+			return entry.value.([]T), nil
+		}
+	}
+	items, err := load()
+	if err != nil || f.opts.CacheTTL < 0 {
+		return items, err
+	}
+	f.mu.Lock()
+	f.cache[key] = cacheEntry{value: items, expires: time.Now().Add(f.opts.CacheTTL)}
+	f.mu.Unlock()
+	return items, nil
+}
+
+// collect calls page until it reports no more results or max items are
+// gathered. Rate-limited pages are retried after Slack's Retry-After.
+func collect[T any](ctx context.Context, f *Fetcher, method string, max int, page func(context.Context) ([]T, bool, error)) ([]T, error) {
+	var out []T
+	for {
+		items, more, err := fetchPage(ctx, f, method, page)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, items...)
+		if max > 0 && len(out) >= max {
+			return out[:max], nil
+		}
+		if !more {
+			return out, nil
+		}
+	}
+}
+
+func fetchPage[T any](ctx context.Context, f *Fetcher, method string, page func(context.Context) ([]T, bool, error)) ([]T, bool, error) {
+	for attempt := 0; ; attempt++ {
+		if err := f.wait(ctx, method); err != nil {
+			return nil, false, err
+		}
+		metrics.Add(method+".requests", 1)
+		items, more, err := page(ctx)
+		var limited *slack.RateLimitedError
+		if !errors.As(err, &limited) {
+			if err != nil {
+				metrics.Add(method+".errors", 1)
+			}
+			return items, more, err
+		}
+		metrics.Add(method+".rate_limited", 1)
+		if attempt >= f.opts.MaxRetries {
+			return nil, false, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(limited.RetryAfter):
+		}
+	}
+}
+
+// wait enforces MinInterval between calls to the same method.
+func (f *Fetcher) wait(ctx context.Context, method string) error {
+	if f.opts.MinInterval <= 0 {
+		return nil
+	}
+	f.mu.Lock()
+	next := f.last[method].Add(f.opts.MinInterval)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	f.last[method] = next
+	f.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(next)):
+		return nil
+	}
+}
+
+func formatTS(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10) + "." + fmt.Sprintf("%06d", t.Nanosecond()/1000)
+}
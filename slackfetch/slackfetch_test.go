@@ -0,0 +1,107 @@
+package slackfetch
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func counter(name string) int64 {
+	if v, ok := metrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func newTestFetcher(t *testing.T, handler http.HandlerFunc, opts Options) *Fetcher {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return New(slack.New("xoxb-test", slack.OptionAPIURL(ts.URL+"/")), opts)
+}
+
+func TestHistory_FollowsCursorsAndRetriesRateLimits(t *testing.T) {
+	var calls, limited int32
+	f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path != "/conversations.history" || r.Form.Get("channel") != "C1" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Form)
+		}
+		if r.Form.Get("cursor") == "page2" && atomic.AddInt32(&limited, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Form.Get("cursor") {
+		case "":
+			fmt.Fprint(w, `{"ok":true,"has_more":true,"messages":[{"ts":"3.0","text":"c"},{"ts":"2.0","text":"b"}],"response_metadata":{"next_cursor":"page2"}}`)
+		case "page2":
+			fmt.Fprint(w, `{"ok":true,"has_more":false,"messages":[{"ts":"1.0","text":"a"}]}`)
+		}
+	}, Options{})
+
+	before := counter("conversations.history.rate_limited")
+	msgs, err := f.History(context.Background(), "C1", time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || msgs[2].Text != "a" {
+		t.Errorf("expected all pages, got %+v", msgs)
+	}
+	if counter("conversations.history.rate_limited") != before+1 {
+		t.Error("rate limited page was not counted")
+	}
+
+	if _, err := f.History(context.Background(), "C1", time.Time{}, 0); err != nil || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("second read should come from the cache, calls=%d err=%v", calls, err)
+	}
+}
+
+func TestHistory_StopsAtMax(t *testing.T) {
+	var calls int32
+	f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprint(w, `{"ok":true,"has_more":true,"messages":[{"ts":"3.0"},{"ts":"2.0"}],"response_metadata":{"next_cursor":"more"}}`)
+	}, Options{CacheTTL: -1})
+
+	msgs, err := f.History(context.Background(), "C1", time.Unix(1700000000, 0), 3)
+	if err != nil || len(msgs) != 3 || calls != 2 {
+		t.Errorf("expected 3 messages from 2 pages, got %d messages, %d calls, %v", len(msgs), calls, err)
+	}
+}
+
+func TestUsers_Paginates(t *testing.T) {
+	f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("cursor") == "" {
+			fmt.Fprint(w, `{"ok":true,"members":[{"id":"U1"}],"response_metadata":{"next_cursor":"u2"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true,"members":[{"id":"U2"}],"response_metadata":{"next_cursor":""}}`)
+	}, Options{})
+
+	users, err := f.Users(context.Background())
+	if err != nil || len(users) != 2 || users[1].ID != "U2" {
+		t.Errorf("unexpected users %+v %v", users, err)
+	}
+}
+
+func TestFetchPage_GivesUpAfterMaxRetries(t *testing.T) {
+	f := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}, Options{MaxRetries: 2})
+
+	if _, err := f.Replies(context.Background(), "C1", "1.0", 0); err == nil {
+		t.Error("expected rate limit error after retries")
+	}
+}
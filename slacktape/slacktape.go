@@ -0,0 +1,251 @@
+// Package slacktape records Slack Web API traffic to a cassette file and
+// replays it in tests. Tokens and other secrets are redacted before anything
+// is written, so cassettes captured against a real workspace can be
+// committed. Replays fail when the client sends a request the cassette did
+// not record, which is how slack-go upgrades and new API calls are checked
+// without talking to Slack.
+package slacktape
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Interaction is one recorded request/response pair. Body holds the
+// canonical request body for form and JSON requests; other bodies (file
+// uploads) are not compared.
+type Interaction struct {
+	Method      string `json:"method"`
+	Host        string `json:"host"`
+	Path        string `json:"path"`
+	Query       string `json:"query,omitempty"`
+	Body        string `json:"body,omitempty"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Response    string `json:"response"`
+}
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette atomically.
+func (c *Cassette) Save(path string) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Sanitization
+
+var (
+	slackTokenPattern = regexp.MustCompile(`\b(xox[abposre]|xapp)-[A-Za-z0-9-]+`)
+	secretFields      = map[string]bool{"token": true, "client_secret": true, "refresh_token": true, "code": true}
+)
+
+// Redact replaces Slack tokens in s with a placeholder.
+func Redact(s string) string {
+	return slackTokenPattern.ReplaceAllString(s, "$1-REDACTED")
+}
+
+func canonicalQuery(q url.Values) string {
+	for k := range q {
+		if secretFields[k] {
+			q.Set(k, "REDACTED")
+		}
+	}
+	return Redact(q.Encode())
+}
+
+// canonicalBody returns the comparable form of a request body: sorted,
+// redacted form values or compact redacted JSON. Other content types
+// return "".
+func canonicalBody(contentType string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return Redact(string(body))
+		}
+		return canonicalQuery(values)
+	case "application/json":
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, body); err != nil {
+			return Redact(string(body))
+		}
+		return Redact(buf.String())
+	}
+	return ""
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err
+}
+
+func requestInteraction(r *http.Request) (Interaction, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return Interaction{}, err
+	}
+	return Interaction{
+		Method: r.Method,
+		Host:   r.URL.Host,
+		Path:   r.URL.Path,
+		Query:  canonicalQuery(r.URL.Query()),
+		Body:   canonicalBody(r.Header.Get("Content-Type"), body),
+	}, nil
+}
+
+// Recording
+
+// Recorder is an http.RoundTripper that forwards requests to Next and
+// appends each exchange to the cassette at Path.
+type Recorder struct {
+	Next http.RoundTripper
+	Path string
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+func NewRecorder(next http.RoundTripper, path string) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{Next: next, Path: path}
+}
+
+func (rec *Recorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	in, err := requestInteraction(r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rec.Next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	in.Status = resp.StatusCode
+	in.ContentType = resp.Header.Get("Content-Type")
+	in.Response = Redact(string(body))
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.cassette.Interactions = append(rec.cassette.Interactions, in)
+	if err := rec.cassette.Save(rec.Path); err != nil {
+		return nil, fmt.Errorf("saving cassette: %w", err)
+	}
+	return resp, nil
+}
+
+// Replay
+
+// Replayer is an http.RoundTripper that answers requests from a cassette.
+// Each interaction is used once, in recorded order per method and path.
+type Replayer struct {
+	mu   sync.Mutex
+	used []bool
+	c    *Cassette
+}
+
+func NewReplayer(c *Cassette) *Replayer {
+	return &Replayer{c: c, used: make([]bool, len(c.Interactions))}
+}
+
+// Client returns an *http.Client that replays instead of dialing.
+func (p *Replayer) Client() *http.Client {
+	return &http.Client{Transport: p}
+}
+
+func (p *Replayer) RoundTrip(r *http.Request) (*http.Response, error) {
+	got, err := requestInteraction(r)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, in := range p.c.Interactions {
+		if p.used[i] || in.Method != got.Method || in.Path != got.Path {
+			continue
+		}
+		if in.Query != got.Query || in.Body != got.Body {
+			return nil, fmt.Errorf("slacktape: %s %s differs from the recording:\n  recorded query=%q body=%q\n  sent     query=%q body=%q",
+				got.Method, got.Path, in.Query, in.Body, got.Query, got.Body)
+		}
+		p.used[i] = true
+		header := http.Header{}
+		if in.ContentType != "" {
+			header.Set("Content-Type", in.ContentType)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+			StatusCode:    in.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(in.Response)),
+			ContentLength: int64(len(in.Response)),
+			Request:       r,
+		}, nil
+	}
+	return nil, fmt.Errorf("slacktape: no recorded interaction for %s %s", got.Method, got.Path)
+}
+
+// Unused lists the recorded interactions that were never replayed, as
+// "METHOD path" strings.
+func (p *Replayer) Unused() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var unused []string
+	for i, in := range p.c.Interactions {
+		if !p.used[i] {
+			unused = append(unused, in.Method+" "+in.Path)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}